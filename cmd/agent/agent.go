@@ -26,9 +26,14 @@ import (
 	localscheme "github.com/alibaba/open-local/pkg/generated/clientset/versioned/scheme"
 	localinformers "github.com/alibaba/open-local/pkg/generated/informers/externalversions"
 	"github.com/alibaba/open-local/pkg/signals"
+	"github.com/alibaba/open-local/pkg/utils"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	snapscheme "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/scheme"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -59,6 +64,9 @@ func init() {
 
 // Start will start agent
 func Start(opt *agentOption) error {
+	if err := utils.SetLogFormat(opt.LogFormat); err != nil {
+		return err
+	}
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
@@ -87,7 +95,17 @@ func Start(opt *agentOption) error {
 		return fmt.Errorf("fail to get agent config: %s", err.Error())
 	}
 
+	lvm.SetMutatingOpsLimit(opt.LVMOpsPerSecond)
+	lvmConfig := lvm.CommandConfig{SystemDir: opt.LVMSystemDir, LockingDir: opt.LVMLockingDir}
+	if err := lvmConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid lvm command config: %s", err.Error())
+	}
+	lvm.SetCommandConfig(lvmConfig)
+	lvm.SetLockDir(opt.VGLockDir)
+
 	utilruntime.Must(localscheme.AddToScheme(scheme.Scheme))
+	// snapshot usage alert is recorded on VolumeSnapshotContent
+	utilruntime.Must(snapscheme.AddToScheme(scheme.Scheme))
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
 	eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "open-local-agent"})
@@ -106,12 +124,117 @@ func Start(opt *agentOption) error {
 // getAgentConfig returns Configuration that agent needs
 func getAgentConfig(opt *agentOption) (*common.Configuration, error) {
 	configuration := &common.Configuration{
-		Nodename:                opt.NodeName,
-		SysPath:                 opt.SysPath,
-		MountPath:               opt.MountPath,
-		DiscoverInterval:        opt.Interval,
-		LogicalVolumeNamePrefix: opt.LVNamePrefix,
-		RegExp:                  opt.RegExp,
+		Nodename:                    opt.NodeName,
+		SysPath:                     opt.SysPath,
+		MountPath:                   opt.MountPath,
+		DiscoverInterval:            opt.Interval,
+		LogicalVolumeNamePrefix:     opt.LVNamePrefix,
+		LogicalVolumeNameTemplate:   opt.LVNameTemplate,
+		RegExp:                      opt.RegExp,
+		SnapshotProjectionWindow:    opt.SnapshotProjectionWindow,
+		SnapshotExpansionsPerCycle:  opt.SnapshotExpansionsPerCycle,
+		SnapshotTrendSamples:        opt.SnapshotTrendSamples,
+		SnapshotTrendSampleInterval: opt.SnapshotTrendInterval,
+		SnapshotEmergencyUsage:      opt.SnapshotEmergencyUsage,
+		MetadataLowThreshold:        opt.MetadataLowThreshold,
+		DiskTemperature:             opt.DiskTemperature,
+		DiskHotThreshold:            opt.DiskHotThreshold,
+		DiskIOStats:                 opt.DiskIOStats,
+		VolumeIdleTracking:          opt.VolumeIdleTracking,
+		SnapshotAwareCapacity:       opt.SnapshotAwareCapacity,
+		VGMissingGraceCycles:        opt.VGMissingGraceCycles,
+		LVActivationConcurrency:     opt.LVActivationConcurrency,
+		LVActivationOrder:           opt.LVActivationOrder,
+		InventoryFile:               opt.InventoryFile,
+		InventoryFormat:             opt.InventoryFormat,
+		InventoryInterval:           opt.InventoryInterval,
+		InventoryOnly:               opt.InventoryOnly,
+		StatusDriftCheckInterval:    opt.StatusDriftCheckInterval,
+		StatusDriftTolerance:        opt.StatusDriftTolerance,
+		ShutdownTimeout:             opt.ShutdownTimeout,
+		StatusUpdateInterval:        opt.StatusUpdateInterval,
+		LoopDevices:                 opt.LoopDevices,
+	}
+	if err := utils.ValidateLVNameTemplate(opt.LVNameTemplate); err != nil {
+		return nil, err
+	}
+	if opt.MetadataLowThreshold < 0 || opt.MetadataLowThreshold > 1 {
+		return nil, fmt.Errorf("lvm-metadata-low-threshold must be between 0 and 1, got %v", opt.MetadataLowThreshold)
+	}
+	if opt.DiskHotThreshold < 0 {
+		return nil, fmt.Errorf("disk-hot-threshold must not be negative, got %d", opt.DiskHotThreshold)
+	}
+	if opt.SnapshotExpansionsPerCycle < 0 {
+		return nil, fmt.Errorf("snapshot-expansions-per-cycle must not be negative, got %d", opt.SnapshotExpansionsPerCycle)
+	}
+	if opt.SnapshotTrendSamples < 0 || opt.SnapshotTrendSamples > common.MaxSnapshotTrendSamples {
+		return nil, fmt.Errorf("snapshot-trend-samples must be between 0 and %d, got %d", common.MaxSnapshotTrendSamples, opt.SnapshotTrendSamples)
+	}
+	if opt.SnapshotTrendInterval < 0 {
+		return nil, fmt.Errorf("snapshot-trend-sample-interval must not be negative, got %d", opt.SnapshotTrendInterval)
+	}
+	if opt.SnapshotEmergencyUsage <= 0 || opt.SnapshotEmergencyUsage > 1 {
+		return nil, fmt.Errorf("snapshot-emergency-usage must be in (0, 1], got %v", opt.SnapshotEmergencyUsage)
+	}
+	if opt.VGMissingGraceCycles < 0 {
+		return nil, fmt.Errorf("vg-missing-grace-cycles must not be negative, got %d", opt.VGMissingGraceCycles)
+	}
+	if opt.LVActivationConcurrency < 0 {
+		return nil, fmt.Errorf("lv-activation-concurrency must not be negative, got %d", opt.LVActivationConcurrency)
+	}
+	if opt.LVActivationOrder != common.LVActivationOrderScheduledFirst && opt.LVActivationOrder != common.LVActivationOrderName {
+		return nil, fmt.Errorf("lv-activation-order must be %s or %s, got %q", common.LVActivationOrderScheduledFirst, common.LVActivationOrderName, opt.LVActivationOrder)
+	}
+	if opt.InventoryFormat != common.InventoryFormatJSON && opt.InventoryFormat != common.InventoryFormatYAML {
+		return nil, fmt.Errorf("inventory-format must be %s or %s, got %q", common.InventoryFormatJSON, common.InventoryFormatYAML, opt.InventoryFormat)
+	}
+	if opt.InventoryInterval < 0 {
+		return nil, fmt.Errorf("inventory-interval must not be negative, got %d", opt.InventoryInterval)
+	}
+	if opt.InventoryOnly && opt.InventoryFile == "" {
+		return nil, fmt.Errorf("inventory-only requires inventory-file")
 	}
+	if opt.StatusDriftCheckInterval < 0 {
+		return nil, fmt.Errorf("status-drift-check-interval must not be negative, got %d", opt.StatusDriftCheckInterval)
+	}
+	if opt.StatusDriftTolerance < 0 || opt.StatusDriftTolerance >= 1 {
+		return nil, fmt.Errorf("status-drift-tolerance must be in [0, 1), got %v", opt.StatusDriftTolerance)
+	}
+	if opt.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("shutdown-timeout must not be negative, got %d", opt.ShutdownTimeout)
+	}
+	if opt.StatusUpdateInterval < 0 {
+		return nil, fmt.Errorf("status-update-interval must not be negative, got %d", opt.StatusUpdateInterval)
+	}
+	var err error
+	if configuration.MinDeviceSize, err = parseDeviceSize("min-device-size", opt.MinDeviceSize); err != nil {
+		return nil, err
+	}
+	if configuration.MaxDeviceSize, err = parseDeviceSize("max-device-size", opt.MaxDeviceSize); err != nil {
+		return nil, err
+	}
+	if configuration.MaxDeviceSize > 0 && configuration.MinDeviceSize > configuration.MaxDeviceSize {
+		return nil, fmt.Errorf("min-device-size %s must not be larger than max-device-size %s", opt.MinDeviceSize, opt.MaxDeviceSize)
+	}
+	signatures, err := deviceutil.ParseSignatures(opt.DeviceSignatures)
+	if err != nil {
+		return nil, err
+	}
+	configuration.DeviceSignatures = signatures
 	return configuration, nil
 }
+
+// parseDeviceSize parses size flag in quantity such as 10Gi, 0 if empty
+func parseDeviceSize(name, value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a quantity such as 10Gi, got %q: %s", name, value, err.Error())
+	}
+	if quantity.Sign() < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %q", name, value)
+	}
+	return uint64(quantity.Value()), nil
+}
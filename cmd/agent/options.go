@@ -17,19 +17,55 @@ limitations under the License.
 package agent
 
 import (
+	"fmt"
+
 	"github.com/alibaba/open-local/pkg/agent/common"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	"github.com/spf13/pflag"
 )
 
 type agentOption struct {
-	Master       string
-	Kubeconfig   string
-	NodeName     string
-	SysPath      string
-	MountPath    string
-	Interval     int
-	LVNamePrefix string
-	RegExp       string
+	Master                     string
+	Kubeconfig                 string
+	NodeName                   string
+	SysPath                    string
+	MountPath                  string
+	Interval                   int
+	LVNamePrefix               string
+	LVNameTemplate             string
+	RegExp                     string
+	MinDeviceSize              string
+	MaxDeviceSize              string
+	SnapshotProjectionWindow   int
+	SnapshotExpansionsPerCycle int
+	SnapshotTrendSamples       int
+	SnapshotTrendInterval      int
+	SnapshotEmergencyUsage     float64
+	LVMOpsPerSecond            float64
+	DeviceSignatures           []string
+	MetadataLowThreshold       float64
+	DiskTemperature            bool
+	DiskHotThreshold           int64
+	DiskIOStats                bool
+	VolumeIdleTracking         bool
+	SnapshotAwareCapacity      bool
+	VGMissingGraceCycles       int
+	LVActivationConcurrency    int
+	LVActivationOrder          string
+	LVMSystemDir               string
+	LVMLockingDir              string
+	VGLockDir                  string
+	LogFormat                  string
+	InventoryFile              string
+	InventoryFormat            string
+	InventoryInterval          int
+	InventoryOnly              bool
+	StatusDriftCheckInterval   int
+	StatusDriftTolerance       float64
+	ShutdownTimeout            int
+	StatusUpdateInterval       int
+	LoopDevices                bool
 }
 
 func (option *agentOption) addFlags(fs *pflag.FlagSet) {
@@ -40,5 +76,37 @@ func (option *agentOption) addFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&option.MountPath, "path.mount", "/mnt/open-local", "Path that specifies mount path of local volumes")
 	fs.IntVar(&option.Interval, "interval", common.DefaultInterval, "The interval that the agent checks the local storage at one time")
 	fs.StringVar(&option.LVNamePrefix, "lvname", "local", "The prefix of Logical Volume Name created by open-local")
+	fs.StringVar(&option.LVNameTemplate, "lv-name-template", utils.DefaultLVNameTemplate, "The template of Logical Volume Name created by open-local, must be the same as csi plugin")
+	fs.IntVar(&option.SnapshotProjectionWindow, "snapshot-projection-window", common.DefaultInterval, "The duration(second) that the agent projects snapshot usage by fill velocity, snapshot whose projected usage exceeds threshold is expanded in advance, 0 means disabled")
+	fs.IntVar(&option.SnapshotExpansionsPerCycle, "snapshot-expansions-per-cycle", 0, "The maximum number of snapshot lvs expanded in one discovery cycle, snapshots of lower projected usage exceeding the limit are expanded in subsequent cycles, 0 means unlimited")
+	fs.IntVar(&option.SnapshotTrendSamples, "snapshot-trend-samples", 0, fmt.Sprintf("The number of recent usage samples of each snapshot lv kept to report its fill rate and estimated time to full in status of nodelocalstorage, at most %d, 0 means disabled", common.MaxSnapshotTrendSamples))
+	fs.IntVar(&option.SnapshotTrendInterval, "snapshot-trend-sample-interval", common.DefaultInterval, "The minimum duration(second) between usage samples of snapshot lv, usage is sampled in snapshot expansion cycles")
+	fs.Float64Var(&option.SnapshotEmergencyUsage, "snapshot-emergency-usage", common.DefaultSnapshotEmergencyUsage, "The usage ratio of snapshot lv above which it is expanded out of snapshotExpansionWindows of nodelocalstorage, snapshot lv below it waits for the next window")
+	fs.Float64Var(&option.LVMOpsPerSecond, "lvm-ops-per-second", 0, "The maximum number of mutating lvm operations per second, such as snapshot expansion, operations exceeding the limit are queued, 0 means unlimited")
 	fs.StringVar(&option.RegExp, "regexp", "^(s|v|xv)d[a-z]+$", "regexp is used to filter device names")
+	fs.StringVar(&option.MinDeviceSize, "min-device-size", "", "The minimum size of devices and partitions reported in status of nodelocalstorage, such as 10Gi, smaller ones are filtered out as those not matching regexp, empty means unlimited")
+	fs.StringVar(&option.MaxDeviceSize, "max-device-size", "", "The maximum size of devices and partitions reported in status of nodelocalstorage, such as 10Ti, larger ones are filtered out as those not matching regexp, empty means unlimited")
+	fs.Float64Var(&option.MetadataLowThreshold, "lvm-metadata-low-threshold", common.DefaultMetadataLowThreshold, "The ratio of free vg metadata area below which vg is reported as MetadataLow, 0 means disabled")
+	fs.BoolVar(&option.DiskTemperature, "disk-temperature", false, "Read temperature of devices by nvme-cli or smartctl and report it in status of nodelocalstorage")
+	fs.Int64Var(&option.DiskHotThreshold, "disk-hot-threshold", common.DefaultDiskHotThreshold, "The temperature(celsius) above which device is reported as DiskHot when disk-temperature is enabled, 0 means disabled")
+	fs.BoolVar(&option.DiskIOStats, "disk-io-stats", false, "Collect io statistics of physical volumes from /proc/diskstats and report them per vg in status of nodelocalstorage")
+	fs.BoolVar(&option.VolumeIdleTracking, "volume-idle-tracking", false, "Track io of every logical volume from /proc/diskstats and report since when it is idle and when it was last written in status of nodelocalstorage")
+	fs.BoolVar(&option.SnapshotAwareCapacity, "snapshot-aware-capacity", false, "Report snapshotAwareAvailable of every vg in status of nodelocalstorage, which is available size minus the size snapshot lvs may still grow to before they reach the size of their origins")
+	fs.IntVar(&option.VGMissingGraceCycles, "vg-missing-grace-cycles", 0, "The number of consecutive discovery cycles a vg must be absent before it is removed from status of nodelocalstorage, vg reappearing within them is reported as before, 0 means removed immediately")
+	fs.IntVar(&option.LVActivationConcurrency, "lv-activation-concurrency", common.DefaultLVActivationConcurrency, "The number of inactive logical volumes activated at the same time when agent starts, 0 means disabled")
+	fs.StringVar(&option.LVActivationOrder, "lv-activation-order", common.LVActivationOrderScheduledFirst, "The order in which inactive logical volumes are activated when agent starts, scheduled-first activates those backing pods scheduled to the node first, name activates by name")
+	fs.StringVar(&option.LVMSystemDir, "lvm-system-dir", "", "The directory of lvm.conf used by lvm commands, exported as LVM_SYSTEM_DIR, empty means default of host")
+	fs.StringVar(&option.LVMLockingDir, "lvm-locking-dir", "", "The locking_dir of lvm commands, overriding the one in lvm.conf, empty means default of host")
+	fs.StringVar(&option.VGLockDir, "vg-lock-dir", lvm.DefaultLockDir, "The host directory of lock files serializing mutating operations on the same vg or device with lvmd of csi plugin, which must be the same as --vg-lock-dir of csi plugin, empty means operations are serialized within agent only")
+	fs.StringVar(&option.LogFormat, "log-format", utils.LogFormatText, "The format of log, text or json, json log carries fields such as lv, vg, snapshot and operation")
+	fs.StringVar(&option.InventoryFile, "inventory-file", "", "The path where the latest discovery of vgs, lvs, snapshots, devices and mount points is exported for offline inventory, empty means disabled")
+	fs.StringVar(&option.InventoryFormat, "inventory-format", common.InventoryFormatJSON, "The format of inventory file, json or yaml")
+	fs.IntVar(&option.InventoryInterval, "inventory-interval", 0, "The minimum duration(second) between writes of inventory file, 0 means written on every discovery")
+	fs.BoolVar(&option.InventoryOnly, "inventory-only", false, "Export discovery to inventory file only without updating status of nodelocalstorage, requires inventory-file")
+	fs.IntVar(&option.StatusDriftCheckInterval, "status-drift-check-interval", 0, "The duration(second) between checks comparing vgs and lvs in status of nodelocalstorage with lvm state, status diverging beyond tolerance is reported as StatusDrift and re-published, 0 means disabled")
+	fs.Float64Var(&option.StatusDriftTolerance, "status-drift-tolerance", common.DefaultStatusDriftTolerance, "The ratio of vg size within which capacity of vg and lv in status may differ from lvm state without being reported as drift")
+	fs.IntVar(&option.ShutdownTimeout, "shutdown-timeout", common.DefaultShutdownTimeout, "The duration(second) agent waits on SIGTERM for the running discovery or snapshot expansion to finish before publishing the final status of nodelocalstorage, should be less than terminationGracePeriodSeconds of agent pod")
+	fs.IntVar(&option.StatusUpdateInterval, "status-update-interval", common.DefaultStatusUpdateInterval, "The minimum duration(second) between status updates of nodelocalstorage, capacity changes in between are coalesced into one update while condition changes are updated at once, 0 means every change is updated at once")
+	fs.BoolVar(&option.LoopDevices, "loop-devices", false, "Attach loop devices over loopFiles of vgs in resourceToBeInited of nodelocalstorage and initialize them as physical volumes, vg backed by loop devices is marked loopBacked in status, for testing and development only, never enable it in production")
+	fs.StringSliceVar(&option.DeviceSignatures, "device-signatures", nil, "Additional signatures marking devices in use, which are never initialized as vg or mount point, in form of type:<blkid type> or magic:<offset>:<hex bytes>")
 }
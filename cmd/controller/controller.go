@@ -18,15 +18,18 @@ package controller
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/alibaba/open-local/pkg/controller"
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	localinformers "github.com/alibaba/open-local/pkg/generated/informers/externalversions"
 	"github.com/alibaba/open-local/pkg/signals"
+	"github.com/alibaba/open-local/pkg/webhook"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	snapshotinformers "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -91,10 +94,39 @@ func Start(opt *controllerOption) error {
 	localInformerFactory.Start(stopCh)
 	snapshotInformerFactory.Start(stopCh)
 
+	if opt.WebhookPort > 0 {
+		webhook.Start(opt.WebhookPort, opt.WebhookCertFile, opt.WebhookKeyFile)
+	}
+
 	log.Info("Starting open-local controller")
-	if err = controller.Run(2, stopCh); err != nil {
+	if opt.LeaderElect {
+		config, configErr := opt.leaderElectionConfig()
+		if configErr != nil {
+			return configErr
+		}
+		err = controller.RunWithLeaderElection(2, config, stopCh)
+	} else {
+		err = controller.Run(2, stopCh)
+	}
+	if err != nil {
 		return fmt.Errorf("fail to run controller: %s", err.Error())
 	}
 	log.Info("Quitting now")
 	return nil
 }
+
+func (opt *controllerOption) leaderElectionConfig() (controller.LeaderElectionConfig, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return controller.LeaderElectionConfig{}, fmt.Errorf("fail to get hostname: %s", err.Error())
+	}
+	return controller.LeaderElectionConfig{
+		Namespace: opt.LeaderElectNamespace,
+		Name:      "open-local-controller",
+		// unique even if replicas share hostname
+		Identity:      hostname + "_" + string(uuid.NewUUID()),
+		LeaseDuration: opt.LeaderElectLeaseDuration,
+		RenewDeadline: opt.LeaderElectRenewDeadline,
+		RetryPeriod:   opt.LeaderElectRetryPeriod,
+	}, nil
+}
@@ -18,6 +18,7 @@ package controller
 
 import (
 	"strings"
+	"time"
 
 	"github.com/alibaba/open-local/pkg/controller"
 	"github.com/spf13/pflag"
@@ -29,6 +30,16 @@ type controllerOption struct {
 	Kubeconfig   string
 	InitConfig   string
 	FeatureGates map[string]bool
+
+	WebhookPort     int
+	WebhookCertFile string
+	WebhookKeyFile  string
+
+	LeaderElect              bool
+	LeaderElectNamespace     string
+	LeaderElectLeaseDuration time.Duration
+	LeaderElectRenewDeadline time.Duration
+	LeaderElectRetryPeriod   time.Duration
 }
 
 func (option *controllerOption) addFlags(fs *pflag.FlagSet) {
@@ -37,4 +48,12 @@ func (option *controllerOption) addFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&option.InitConfig, "initconfig", "open-local", "initconfig is NodeLocalStorageInitConfig(CRD) for controller to create NodeLocalStorage")
 	fs.Var(cliflag.NewMapStringBool(&option.FeatureGates), "feature-gates", "A set of key=value pairs that describe feature gates for alpha/experimental features. "+
 		"Options are:\n"+strings.Join(controller.DefaultFeatureGate.KnownFeatures(), "\n"))
+	fs.IntVar(&option.WebhookPort, "webhook-port", 0, "Port of the https server validating open-local StorageClass and NodeLocalStorage, 0 disables the webhook.")
+	fs.StringVar(&option.WebhookCertFile, "webhook-tls-cert-file", "/etc/open-local/webhook/tls.crt", "Path to the serving certificate of webhook.")
+	fs.StringVar(&option.WebhookKeyFile, "webhook-tls-private-key-file", "/etc/open-local/webhook/tls.key", "Path to the private key matching --webhook-tls-cert-file.")
+	fs.BoolVar(&option.LeaderElect, "leader-elect", false, "Compete for a lease before doing any work, so that only one of replicas of controller operates at a time. Operations in flight are aborted once leadership is lost, and state is resynced on acquiring it.")
+	fs.StringVar(&option.LeaderElectNamespace, "leader-elect-namespace", "kube-system", "Namespace of the lease of leader election.")
+	fs.DurationVar(&option.LeaderElectLeaseDuration, "leader-elect-lease-duration", 15*time.Second, "Duration that non-leader candidates wait after observing a leadership renewal before acquiring leadership.")
+	fs.DurationVar(&option.LeaderElectRenewDeadline, "leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries refreshing leadership before giving it up, it must be less than lease duration.")
+	fs.DurationVar(&option.LeaderElectRetryPeriod, "leader-elect-retry-period", 2*time.Second, "Duration candidates wait between tries of acquiring and renewing leadership.")
 }
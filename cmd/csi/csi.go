@@ -17,10 +17,16 @@ limitations under the License.
 package csi
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/alibaba/open-local/pkg/csi"
 	lvmserver "github.com/alibaba/open-local/pkg/csi/server"
 	local "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	"github.com/alibaba/open-local/pkg/om"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"github.com/alibaba/open-local/pkg/utils/tracing"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/spf13/cobra"
 	"k8s.io/client-go/kubernetes"
@@ -50,13 +56,40 @@ func init() {
 
 // Start will start agent
 func Start(opt *csiOption) error {
+	if err := utils.SetLogFormat(opt.LogFormat); err != nil {
+		return err
+	}
 	log.Infof("CSI Driver Name: %s, nodeID: %s, endPoints %s", opt.Driver, opt.NodeID, opt.Endpoint)
+	shutdownTracing, err := tracing.Setup(opt.TracingEndpoint, opt.Driver)
+	if err != nil {
+		return fmt.Errorf("fail to setup tracing: %s", err.Error())
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warningf("fail to flush traces: %s", err.Error())
+		}
+	}()
 
 	// Storage devops
 	go om.StorageOM()
 	// local volume daemon
 	// GRPC server to provide volume manage
-	go lvmserver.Start(opt.LVMDPort)
+	lvm.SetMutatingOpsLimit(opt.LVMOpsPerSecond)
+	lvmConfig := lvm.CommandConfig{SystemDir: opt.LVMSystemDir, LockingDir: opt.LVMLockingDir}
+	if err := lvmConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid lvm command config: %s", err.Error())
+	}
+	lvm.SetCommandConfig(lvmConfig)
+	lvm.SetLockDir(opt.VGLockDir)
+	var executor lvmserver.Executor
+	if opt.RemoteLVMHost != "" {
+		remote, err := lvmserver.NewRemoteExecutor(opt.RemoteLVMHost, opt.RemoteLVMPort, opt.RemoteLVMKeyFile, opt.RemoteLVMKnownHostsFile)
+		if err != nil {
+			return err
+		}
+		executor = remote
+	}
+	go lvmserver.Start(opt.LVMDPort, opt.SysPath, executor)
 
 	cfg, err := clientcmd.BuildConfigFromFlags(opt.Master, opt.Kubeconfig)
 	if err != nil {
@@ -76,10 +109,12 @@ func Start(opt *csiOption) error {
 		log.Fatalf("fail to build local clientset: %s", err.Error())
 	}
 
-	driver := csi.NewDriver(
-		opt.Driver,
-		opt.NodeID,
-		opt.Endpoint,
+	preallocSizes, err := csi.ParsePreallocSizes(opt.LVPreallocSizes)
+	if err != nil {
+		return err
+	}
+
+	options := []csi.Option{
 		csi.WithSysPath(opt.SysPath),
 		csi.WithCgroupDriver(opt.CgroupDriver),
 		csi.WithGrpcConnectionTimeout(opt.GrpcConnectionTimeout),
@@ -89,7 +124,20 @@ func Start(opt *csiOption) error {
 		csi.WithSnapshotClient(snapClient),
 		csi.WithLocalClient(localclient),
 		csi.WithDriverMode(opt.DriverMode),
-	)
+		csi.WithLVNameTemplate(opt.LVNameTemplate),
+		csi.WithFormatTimeout(opt.FormatTimeout),
+		csi.WithPostProvisionHooks(opt.PostProvisionHooks, opt.PostProvisionHookTimeout),
+		csi.WithFsck(opt.FsckMode, opt.FsckTimeout),
+		csi.WithFSGroupPolicy(opt.FSGroupPolicy),
+		csi.WithLVPrealloc(preallocSizes, opt.LVPreallocCount, opt.LVPreallocTTL),
+	}
+	if opt.LeaderElection {
+		if opt.LeaderElectionNamespace == "" {
+			return fmt.Errorf("--leader-election-namespace must be set with --leader-election")
+		}
+		options = append(options, csi.WithLeaderElection(opt.LeaderElectionNamespace))
+	}
+	driver := csi.NewDriver(opt.Driver, opt.NodeID, opt.Endpoint, options...)
 	if err := driver.Run(); err != nil {
 		return err
 	}
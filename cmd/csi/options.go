@@ -18,22 +18,47 @@ package csi
 
 import (
 	"github.com/alibaba/open-local/pkg/csi"
+	lvmserver "github.com/alibaba/open-local/pkg/csi/server"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	"github.com/spf13/pflag"
 )
 
 type csiOption struct {
-	Master                  string
-	Kubeconfig              string
-	Endpoint                string
-	NodeID                  string
-	Driver                  string
-	SysPath                 string
-	GrpcConnectionTimeout   int
-	LVMDPort                string
-	CgroupDriver            string
-	DriverMode              string
-	ExtenderSchedulerNames  []string
-	FrameworkSchedulerNames []string
+	Master                   string
+	Kubeconfig               string
+	Endpoint                 string
+	NodeID                   string
+	Driver                   string
+	SysPath                  string
+	GrpcConnectionTimeout    int
+	LVMDPort                 string
+	CgroupDriver             string
+	DriverMode               string
+	ExtenderSchedulerNames   []string
+	FrameworkSchedulerNames  []string
+	LVNameTemplate           string
+	LVMOpsPerSecond          float64
+	LVMSystemDir             string
+	LVMLockingDir            string
+	VGLockDir                string
+	FormatTimeout            int
+	LogFormat                string
+	TracingEndpoint          string
+	PostProvisionHooks       []string
+	PostProvisionHookTimeout int
+	FsckMode                 string
+	FsckTimeout              int
+	FSGroupPolicy            string
+	LVPreallocSizes          []string
+	LVPreallocCount          int
+	LVPreallocTTL            int
+	RemoteLVMHost            string
+	RemoteLVMPort            int
+	RemoteLVMKeyFile         string
+	RemoteLVMKnownHostsFile  string
+	LeaderElection           bool
+	LeaderElectionNamespace  string
 }
 
 func (option *csiOption) addFlags(fs *pflag.FlagSet) {
@@ -49,4 +74,26 @@ func (option *csiOption) addFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&option.DriverMode, "driver-mode", "all", "driver mode")
 	fs.StringSliceVar(&option.ExtenderSchedulerNames, "extender-scheduler-names", []string{"default-scheduler"}, "extender scheduler names")
 	fs.StringSliceVar(&option.FrameworkSchedulerNames, "framework-scheduler-names", []string{}, "framework scheduler names")
+	fs.StringVar(&option.LVNameTemplate, "lv-name-template", utils.DefaultLVNameTemplate, "template of logical volume name, supported placeholders are {pv}, {pvc} and {ns}, {pvc} and {ns} are shortened with hash suffix if lv name is too long")
+	fs.IntVar(&option.FormatTimeout, "format-timeout", 0, "timeout(second) of formatting volume when publishing, volume still being formatted after timeout is rejected to publish until formatted, 0 means no timeout")
+	fs.Float64Var(&option.LVMOpsPerSecond, "lvm-ops-per-second", 0, "the maximum number of mutating lvm operations per second on node, operations exceeding the limit are queued, 0 means unlimited")
+	fs.StringVar(&option.LVMSystemDir, "lvm-system-dir", "", "the directory of lvm.conf used by lvm commands of lvmd and node server, exported as LVM_SYSTEM_DIR, the same as --lvm-system-dir of agent, empty means default of host")
+	fs.StringVar(&option.LVMLockingDir, "lvm-locking-dir", "", "the locking_dir of lvm commands of lvmd and node server, overriding the one in lvm.conf, the same as --lvm-locking-dir of agent, empty means default of host")
+	fs.StringVar(&option.VGLockDir, "vg-lock-dir", lvm.DefaultLockDir, "the host directory of lock files serializing mutating operations of lvmd on the same vg or device with agent, which must be the same as --vg-lock-dir of agent, empty means operations are serialized within lvmd only")
+	fs.StringVar(&option.LogFormat, "log-format", utils.LogFormatText, "format of log, text or json, json log carries fields such as lv, vg, snapshot, operation and operationID")
+	fs.StringVar(&option.TracingEndpoint, "tracing-endpoint", "", "otlp grpc endpoint(host:port) where opentelemetry traces of csi and lvm operations are exported, empty means tracing is off")
+	fs.StringSliceVar(&option.PostProvisionHooks, "post-provision-hook", []string{}, "absolute path of executable run in order after volume is published, with arguments of target path, volume id, volume type, access type, fs type, pvc namespace and pvc name, publishing fails if it fails")
+	fs.IntVar(&option.PostProvisionHookTimeout, "post-provision-hook-timeout", csi.DefaultPostProvisionHookTimeout, "timeout(second) of every post-provision hook, 0 means no timeout")
+	fs.StringVar(&option.FsckMode, "fsck-mode", csi.FsckModeNone, "check of existing ext and xfs filesystem before it is mounted in node stage, none, check(read-only, corruption is reported as volume condition FilesystemCorrupt) or repair(repair corruption found by check, staging fails if it is not repaired)")
+	fs.IntVar(&option.FsckTimeout, "fsck-timeout", csi.DefaultFsckTimeout, "timeout(second) of every filesystem check or repair, 0 means no timeout")
+	fs.StringVar(&option.FSGroupPolicy, "fs-group-policy", csi.FSGroupPolicyReadWriteOnceWithFSType, "fsGroupPolicy of CSIDriver, must be the same as the CSIDriver object, ReadWriteOnceWithFSType, File(fsGroup is applied to the root of volume by driver instead of recursively by kubelet) or None(fsGroup is never applied)")
+	fs.StringSliceVar(&option.LVPreallocSizes, "lv-prealloc-sizes", []string{}, "size classes(such as 10Gi) of lvs preallocated on vgs where they are requested, lvm volume of exactly the size without striping, zero fill or allocation policy is provisioned with a preallocated lv, empty means preallocation is disabled")
+	fs.IntVar(&option.LVPreallocCount, "lv-prealloc-count", csi.DefaultPreallocCount, "number of unassigned lvs kept per vg and size class")
+	fs.IntVar(&option.LVPreallocTTL, "lv-prealloc-ttl", csi.DefaultPreallocTTL, "time(second) unassigned lvs are kept after the size class is last requested on the vg, idle lvs are removed after that")
+	fs.StringVar(&option.RemoteLVMHost, "remote-lvm-host", "", "ssh destination(user@host or host) where lvm operations of lvmd are run instead of local node, for lvm host without open-local agent, empty means local node")
+	fs.IntVar(&option.RemoteLVMPort, "remote-lvm-port", lvmserver.DefaultRemoteLVMPort, "ssh port of remote lvm host")
+	fs.StringVar(&option.RemoteLVMKeyFile, "remote-lvm-key-file", "", "path of private key authenticating to remote lvm host")
+	fs.StringVar(&option.RemoteLVMKnownHostsFile, "remote-lvm-known-hosts-file", "", "path of known_hosts file holding host key of remote lvm host, unknown host key is rejected")
+	fs.BoolVar(&option.LeaderElection, "leader-election", false, "gate controller operations on leases of csi-provisioner, csi-resizer and csi-snapshotter in the same pod, which must run with --leader-election, operations called by sidecar not holding its lease are rejected")
+	fs.StringVar(&option.LeaderElectionNamespace, "leader-election-namespace", "", "namespace of leases of sidecars, the same as --leader-election-namespace of sidecars")
 }
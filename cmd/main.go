@@ -25,7 +25,10 @@ import (
 	"github.com/alibaba/open-local/cmd/controller"
 	"github.com/alibaba/open-local/cmd/csi"
 	"github.com/alibaba/open-local/cmd/doc"
+	"github.com/alibaba/open-local/cmd/migratequota"
+	"github.com/alibaba/open-local/cmd/rebalance"
 	"github.com/alibaba/open-local/cmd/scheduler"
+	"github.com/alibaba/open-local/cmd/shrink"
 	"github.com/alibaba/open-local/cmd/version"
 	"github.com/alibaba/open-local/pkg/utils"
 	"github.com/spf13/cobra"
@@ -56,6 +59,9 @@ func addCommands() {
 		scheduler.Cmd,
 		csi.Cmd,
 		controller.Cmd,
+		shrink.Cmd,
+		migratequota.Cmd,
+		rebalance.Cmd,
 		version.Cmd,
 		doc.Cmd.Cmd,
 	)
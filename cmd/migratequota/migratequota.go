@@ -0,0 +1,111 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migratequota
+
+import (
+	"fmt"
+
+	"github.com/alibaba/open-local/pkg/csi/server"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	log "k8s.io/klog/v2"
+)
+
+var (
+	opt = migrateQuotaOption{}
+)
+
+type migrateQuotaOption struct {
+	QuotaPath      string
+	VGName         string
+	LVName         string
+	Size           string
+	FsType         string
+	ConfirmStopped bool
+	// LVMOpsPerSecond and VGLockDir are the same as those of csi plugin
+	LVMOpsPerSecond float64
+	VGLockDir       string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "migrate-quota",
+	Short: "migrate a quota directory to a dedicated logical volume, run it in csi-plugin container of the node",
+	Long: `migrate a quota directory to a dedicated logical volume, run it in csi-plugin container of the node.
+A new logical volume is created and formatted, content of the quota directory is copied into it, the logical
+volume is mounted at the quota directory and the quota project is removed. Every step done so far is rolled back
+if any step fails. Pods using the quota directory must be stopped during migration. The mount of logical volume is
+recorded in ` + server.DefaultQuotaMountsFile + `, and csi plugin mounts it again after node reboots.
+PV and PVC are never updated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Start(&opt); err != nil {
+			log.Fatalf("error :%s, quitting now\n", err.Error())
+		}
+	},
+}
+
+func init() {
+	opt.addFlags(Cmd.Flags())
+}
+
+func (option *migrateQuotaOption) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&option.QuotaPath, "quota-path", "", "quota directory to migrate, such as /mnt/quotapath.<namespace>/<subpath>")
+	fs.StringVar(&option.VGName, "vg", "", "volume group where the logical volume is created")
+	fs.StringVar(&option.LVName, "lv", "", "name of the logical volume")
+	fs.StringVar(&option.Size, "size", "", "size of the logical volume, such as 10Gi, empty means the block hard limit of quota project")
+	fs.StringVar(&option.FsType, "fs-type", "ext4", "filesystem of the logical volume, ext3, ext4 or xfs")
+	fs.BoolVar(&option.ConfirmStopped, "confirm-stopped", false, "confirm pods using the quota directory are stopped, required since the directory is switched during migration")
+	fs.Float64Var(&option.LVMOpsPerSecond, "lvm-ops-per-second", 0, "the maximum number of mutating lvm operations per second on node, which should be the same as --lvm-ops-per-second of csi plugin, 0 means unlimited")
+	fs.StringVar(&option.VGLockDir, "vg-lock-dir", lvm.DefaultLockDir, "the host directory of lock files serializing migration with operations of lvmd on the same vg, which must be the same as --vg-lock-dir of csi plugin")
+}
+
+// Start migrates the quota directory
+func Start(opt *migrateQuotaOption) error {
+	if !opt.ConfirmStopped {
+		return fmt.Errorf("quota directory is switched during migration, stop pods using it and rerun with --confirm-stopped")
+	}
+	if opt.QuotaPath == "" || opt.VGName == "" || opt.LVName == "" {
+		return fmt.Errorf("--quota-path, --vg and --lv are required")
+	}
+	var size uint64
+	if opt.Size != "" {
+		quantity, err := resource.ParseQuantity(opt.Size)
+		if err != nil {
+			return fmt.Errorf("invalid size %q: %s", opt.Size, err.Error())
+		}
+		if quantity.Sign() <= 0 {
+			return fmt.Errorf("size %q must be positive", opt.Size)
+		}
+		size = uint64(quantity.Value())
+	}
+	lvm.SetMutatingOpsLimit(opt.LVMOpsPerSecond)
+	lvm.SetLockDir(opt.VGLockDir)
+	migration := &server.QuotaMigration{
+		QuotaPath: opt.QuotaPath,
+		VGName:    opt.VGName,
+		LVName:    opt.LVName,
+		Size:      size,
+		FsType:    opt.FsType,
+		Progress:  func(msg string) { fmt.Println(msg) },
+	}
+	if err := server.MigrateQuotaToLV(migration); err != nil {
+		return fmt.Errorf("fail to migrate %s: %s", opt.QuotaPath, err.Error())
+	}
+	fmt.Printf("%s is migrated to lv %s/%s, capacity of pv is not updated\n", opt.QuotaPath, opt.VGName, opt.LVName)
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rebalance
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/csi/server"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	log "k8s.io/klog/v2"
+)
+
+var (
+	opt = rebalanceOption{}
+)
+
+type rebalanceOption struct {
+	Kubeconfig  string
+	Master      string
+	NodeName    string
+	VGNames     []string
+	Threshold   float64
+	MaxMoves    int
+	Execute     bool
+	MoveMounted bool
+	StopTimeout time.Duration
+	MoveExtents bool
+	// LVMOpsPerSecond and VGLockDir are the same as those of csi plugin
+	LVMOpsPerSecond float64
+	VGLockDir       string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "rebalance",
+	Short: "even out usage of volume groups by moving logical volumes between them, run it in csi-plugin container of the node",
+	Long: `even out usage of volume groups by moving logical volumes between them, run it in csi-plugin container of the node.
+Logical volumes are moved from the most used volume group to the least used one until their usage differs by no more
+than threshold. pvmove only moves extents within a volume group, so a whole logical volume is copied to the other
+volume group, the pv is switched to it and the source is removed. Every step of a move is rolled back if any step fails.
+The pv is annotated as moving during the move, csi plugin refuses to publish it until the move is done or rolled back.
+Mounted logical volumes are moved only with --move-mounted, which evicts pods using them on the node and waits for the
+volumes to be unmounted before copying, the evicted pods are started again on the volume group the volume ends up in.
+Snapshot logical volumes, origins of snapshots and those not backed by open-local pv are never moved. With
+--move-extents, usage of physical volumes within each volume group is also evened out by pvmove, which moves extents
+online. Steps changing volume groups lock them and follow the rate limit of mutating lvm operations, the same as lvmd.
+Only the plan is printed unless --execute is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Start(&opt); err != nil {
+			log.Fatalf("error :%s, quitting now\n", err.Error())
+		}
+	},
+}
+
+func init() {
+	opt.addFlags(Cmd.Flags())
+}
+
+func (option *rebalanceOption) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&option.Kubeconfig, "kubeconfig", option.Kubeconfig, "Path to the kubeconfig file to use.")
+	fs.StringVar(&option.Master, "master", option.Master, "URL/IP for master.")
+	fs.StringVar(&option.NodeName, "node", os.Getenv("KUBE_NODE_NAME"), "name of the node, default to env KUBE_NODE_NAME")
+	fs.StringSliceVar(&option.VGNames, "vgs", nil, "volume groups to rebalance, at least two unless only extents are moved by --move-extents")
+	fs.Float64Var(&option.Threshold, "threshold", server.DefaultRebalanceThreshold, "largest difference of usage between volume groups considered balanced, in (0, 1)")
+	fs.IntVar(&option.MaxMoves, "max-moves", 0, "maximum number of logical volumes to move, 0 means unlimited")
+	fs.BoolVar(&option.Execute, "execute", false, "move logical volumes as planned, otherwise only the plan is printed")
+	fs.BoolVar(&option.MoveMounted, "move-mounted", false, "move mounted logical volumes by evicting pods using them on the node, otherwise mounted logical volumes are not moved")
+	fs.DurationVar(&option.StopTimeout, "stop-timeout", server.DefaultRebalanceStopTimeout, "time to wait for a mounted logical volume to be unmounted after its pods are evicted")
+	fs.BoolVar(&option.MoveExtents, "move-extents", false, "even out usage of physical volumes within each volume group by pvmove as well")
+	fs.Float64Var(&option.LVMOpsPerSecond, "lvm-ops-per-second", 0, "the maximum number of mutating lvm operations per second on node, which should be the same as --lvm-ops-per-second of csi plugin, 0 means unlimited")
+	fs.StringVar(&option.VGLockDir, "vg-lock-dir", lvm.DefaultLockDir, "the host directory of lock files serializing moves with operations of lvmd on the same vg, which must be the same as --vg-lock-dir of csi plugin")
+}
+
+// Start rebalances volume groups of the node
+func Start(opt *rebalanceOption) error {
+	cfg, err := clientcmd.BuildConfigFromFlags(opt.Master, opt.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("fail to build kubeconfig: %s", err.Error())
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("fail to build kubernetes clientset: %s", err.Error())
+	}
+	lvm.SetMutatingOpsLimit(opt.LVMOpsPerSecond)
+	lvm.SetLockDir(opt.VGLockDir)
+	rebalance := &server.Rebalance{
+		NodeName:    opt.NodeName,
+		VGNames:     opt.VGNames,
+		Threshold:   opt.Threshold,
+		MaxMoves:    opt.MaxMoves,
+		Execute:     opt.Execute,
+		MoveMounted: opt.MoveMounted,
+		StopTimeout: opt.StopTimeout,
+		KubeClient:  kubeClient,
+		Progress:    func(msg string) { fmt.Println(msg) },
+	}
+	// extents of a single vg may be rebalanced alone
+	if len(opt.VGNames) != 1 || !opt.MoveExtents {
+		moves, err := server.RunRebalance(rebalance)
+		if err != nil {
+			return fmt.Errorf("fail to rebalance %v after %d moves: %s", opt.VGNames, len(moves), err.Error())
+		}
+		if opt.Execute {
+			fmt.Printf("%d logical volumes are moved\n", len(moves))
+		} else if len(moves) > 0 {
+			fmt.Printf("%d logical volumes to move, rerun with --execute to move them\n", len(moves))
+		}
+	}
+	if !opt.MoveExtents {
+		return nil
+	}
+	extentMoves, err := server.RunExtentRebalance(rebalance)
+	if err != nil {
+		return fmt.Errorf("fail to rebalance physical volumes of %v after %d extent moves: %s", opt.VGNames, len(extentMoves), err.Error())
+	}
+	if opt.Execute {
+		fmt.Printf("%d extent moves are done\n", len(extentMoves))
+	} else if len(extentMoves) > 0 {
+		fmt.Printf("%d extent moves to do, rerun with --execute to move them\n", len(extentMoves))
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"time"
 
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	informers "github.com/alibaba/open-local/pkg/generated/informers/externalversions"
@@ -65,6 +66,11 @@ func Run(opt *extenderOption) error {
 		return err
 	}
 
+	replicaAffinity, err := opt.ParseReplicaAffinity()
+	if err != nil {
+		return err
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(opt.Master, opt.Kubeconfig)
 	if err != nil {
 		return fmt.Errorf("error building kubeconfig: %s", err.Error())
@@ -96,6 +102,8 @@ func Run(opt *extenderOption) error {
 		snapshotInformerFactory,
 		opt.Port,
 		weights,
+		time.Duration(opt.NodeStorageStalenessWindow)*time.Second,
+		replicaAffinity,
 	)
 
 	log.Info("starting open-local scheduler extender")
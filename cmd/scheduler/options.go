@@ -30,6 +30,13 @@ type extenderOption struct {
 	Port                    int32
 	EnabledNodeAntiAffinity string
 	Strategy                string
+	// VGConsistentHashing makes pvc try vg hashed by its identity first
+	VGConsistentHashing bool
+	// NodeStorageStalenessWindow is in second
+	NodeStorageStalenessWindow int
+	// ReplicaAffinityWeight boosts nodes hosting other replicas, 0 means disabled
+	ReplicaAffinityWeight int
+	ReplicaAffinityLabel  string
 }
 
 func (option *extenderOption) AddFlags(fs *pflag.FlagSet) {
@@ -38,12 +45,20 @@ func (option *extenderOption) AddFlags(fs *pflag.FlagSet) {
 	fs.Int32Var(&option.Port, "port", option.Port, "Port for receiving scheduler callback, set to '0' to disable http server")
 	fs.StringVar(&option.EnabledNodeAntiAffinity, "enabled-node-anti-affinity", option.EnabledNodeAntiAffinity, "whether enable node anti-affinity for open-local storage backend, example format: 'MountPoint=5,LVM=3'")
 	fs.StringVar(&option.Strategy, "scheduler-strategy", "binpack", "Scheduler Strategy: binpack or spread")
+	fs.BoolVar(&option.VGConsistentHashing, "vg-consistent-hashing", false, "Place lvm volume without vgName on the vg hashed by its PVC namespace and name if it fits, so that retries of the same PVC land on the same vg, otherwise fall back to scheduler strategy")
+	fs.IntVar(&option.NodeStorageStalenessWindow, "nls-staleness-window", pkg.DefaultNodeStorageStalenessWindow, "The duration(second) after which node whose storage status is not refreshed by open-local agent accepts no new local volume, it must be larger than interval of agent, 0 means disabled")
+	fs.IntVar(&option.ReplicaAffinityWeight, "replica-affinity-weight", 0, "Score weight in range [0, 10] added to nodes already running other replicas of the workload of pod with open-local volumes, replicas are pods with the same controller, 0 means disabled")
+	fs.StringVar(&option.ReplicaAffinityLabel, "replica-affinity-label", "", "Pod label identifying replicas of a workload by the same value instead of controller, pods without the label fall back to controller")
 }
 
 func (option *extenderOption) ParseWeight() (weights *pkg.NodeAntiAffinityWeight, err error) {
 	return utils.ParseWeight(option.EnabledNodeAntiAffinity)
 }
 
+func (option *extenderOption) ParseReplicaAffinity() (pkg.ReplicaAffinity, error) {
+	return utils.ParseReplicaAffinity(option.ReplicaAffinityWeight, option.ReplicaAffinityLabel)
+}
+
 func (option *extenderOption) ParseStrategy() error {
 	switch option.Strategy {
 	case "binpack":
@@ -53,6 +68,7 @@ func (option *extenderOption) ParseStrategy() error {
 	default:
 		return fmt.Errorf("Scheduler strategy parameter may be wrong. You can set one of those: binpack or spread")
 	}
+	pkg.VGConsistentHashing = option.VGConsistentHashing
 
 	return nil
 }
@@ -0,0 +1,97 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shrink
+
+import (
+	"fmt"
+
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	log "k8s.io/klog/v2"
+)
+
+var (
+	opt = shrinkOption{}
+)
+
+type shrinkOption struct {
+	VGName        string
+	LVName        string
+	Size          string
+	ConfirmBackup bool
+}
+
+var Cmd = &cobra.Command{
+	Use:   "shrink",
+	Short: "DANGEROUS: shrink filesystem and logical volume offline, run it in csi-plugin container of the node",
+	Long: `DANGEROUS: shrink filesystem and logical volume offline, run it in csi-plugin container of the node.
+Only ext2/ext3/ext4 is supported, xfs is rejected. The volume must be unmounted and backed up,
+and used space of filesystem must not exceed the target size. PV and PVC are never updated,
+so the capacity declared by them is no longer accurate.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Start(&opt); err != nil {
+			log.Fatalf("error :%s, quitting now\n", err.Error())
+		}
+	},
+}
+
+func init() {
+	opt.addFlags(Cmd.Flags())
+}
+
+func (option *shrinkOption) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&option.VGName, "vg", "", "volume group of the logical volume")
+	fs.StringVar(&option.LVName, "lv", "", "name of the logical volume")
+	fs.StringVar(&option.Size, "size", "", "target size of the logical volume, such as 10Gi")
+	fs.BoolVar(&option.ConfirmBackup, "confirm-backup", false, "confirm the data of the logical volume is backed up, required since data may be lost")
+}
+
+// Start shrinks the logical volume
+func Start(opt *shrinkOption) error {
+	if !opt.ConfirmBackup {
+		return fmt.Errorf("shrinking may lose data, back up the volume and rerun with --confirm-backup")
+	}
+	if opt.VGName == "" || opt.LVName == "" {
+		return fmt.Errorf("--vg and --lv are required")
+	}
+	quantity, err := resource.ParseQuantity(opt.Size)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %s", opt.Size, err.Error())
+	}
+	if quantity.Sign() <= 0 {
+		return fmt.Errorf("size %q must be positive", opt.Size)
+	}
+
+	vg, err := lvm.LookupVolumeGroup(opt.VGName)
+	if err != nil {
+		return fmt.Errorf("fail to look up vg %s: %s", opt.VGName, err.Error())
+	}
+	lv, err := vg.LookupLogicalVolume(opt.LVName)
+	if err != nil {
+		return fmt.Errorf("fail to look up lv %s/%s: %s", opt.VGName, opt.LVName, err.Error())
+	}
+	if lv.IsSnapshot() {
+		return fmt.Errorf("lv %s/%s is a snapshot, which is never shrunk", opt.VGName, opt.LVName)
+	}
+	if err := lv.Shrink(uint64(quantity.Value())); err != nil {
+		return fmt.Errorf("fail to shrink lv %s/%s: %s", opt.VGName, opt.LVName, err.Error())
+	}
+	fmt.Printf("lv %s/%s is shrunk to %s, capacity of pv is not updated\n", opt.VGName, opt.LVName, quantity.String())
+	return nil
+}
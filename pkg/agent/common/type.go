@@ -16,6 +16,10 @@ limitations under the License.
 
 package common
 
+import (
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+)
+
 // Configuration stores all the user-defined parameters to the controller
 type Configuration struct {
 	// Nodename is the kube node name
@@ -28,8 +32,62 @@ type Configuration struct {
 	DiscoverInterval int
 	// LogicalVolumeNamePrefix is the prefix of LogicalVolume Name
 	LogicalVolumeNamePrefix string
+	// LogicalVolumeNameTemplate is the template of LogicalVolume Name, must be the same as csi plugin
+	LogicalVolumeNameTemplate string
+	// SnapshotProjectionWindow is the duration(second) that the agent projects snapshot usage by fill velocity
+	SnapshotProjectionWindow int
+	// SnapshotExpansionsPerCycle is the maximum number of snapshot lvs expanded in one discovery cycle, 0 means unlimited
+	SnapshotExpansionsPerCycle int
+	// SnapshotTrendSamples is the number of usage samples of each snapshot lv kept to compute its fill trend, 0 means disabled
+	SnapshotTrendSamples int
+	// SnapshotTrendSampleInterval is the minimum duration(second) between usage samples of snapshot lv
+	SnapshotTrendSampleInterval int
+	// SnapshotEmergencyUsage is the usage ratio of snapshot lv above which it is expanded out of snapshot expansion windows
+	SnapshotEmergencyUsage float64
 	// RegExp is used to filter device names
 	RegExp string
+	// MinDeviceSize is the minimum size(byte) of devices discovered, 0 means unlimited
+	MinDeviceSize uint64
+	// MaxDeviceSize is the maximum size(byte) of devices discovered, 0 means unlimited
+	MaxDeviceSize uint64
+	// DeviceSignatures mark devices in use besides filesystem and lvm signatures, such devices are never initialized
+	DeviceSignatures []deviceutil.Signature
+	// MetadataLowThreshold is the ratio of free vg metadata area below which vg is reported as MetadataLow
+	MetadataLowThreshold float64
+	// DiskTemperature enables reading temperature of devices from nvme or smart
+	DiskTemperature bool
+	// DiskHotThreshold is the temperature(celsius) above which device is reported as DiskHot, 0 means disabled
+	DiskHotThreshold int64
+	// DiskIOStats enables collecting io statistics of physical volumes per VG
+	DiskIOStats bool
+	// VolumeIdleTracking enables reporting since when no io is observed on each LV
+	VolumeIdleTracking bool
+	// SnapshotAwareCapacity enables reporting available of VG minus potential growth of its snapshot LVs
+	SnapshotAwareCapacity bool
+	// VGMissingGraceCycles is the number of consecutive discovery cycles VG must be absent before it is removed from status
+	VGMissingGraceCycles int
+	// LVActivationConcurrency is the number of inactive lvs activated at the same time when agent starts, 0 means disabled
+	LVActivationConcurrency int
+	// LVActivationOrder is the order in which inactive lvs are activated
+	LVActivationOrder string
+	// InventoryFile is the path where the latest discovery is exported, empty means disabled
+	InventoryFile string
+	// InventoryFormat is the format of inventory file, json or yaml
+	InventoryFormat string
+	// InventoryInterval is the minimum duration(second) between writes of inventory file, 0 means every discovery
+	InventoryInterval int
+	// InventoryOnly exports discovery to inventory file only, status of nodelocalstorage is not updated
+	InventoryOnly bool
+	// StatusDriftCheckInterval is the duration(second) between checks of status against lvm state, 0 means disabled
+	StatusDriftCheckInterval int
+	// StatusDriftTolerance is the ratio of vg size within which capacity in status may differ from lvm state
+	StatusDriftTolerance float64
+	// ShutdownTimeout is the duration(second) agent waits for the running cycle to finish before the final status update on shutdown
+	ShutdownTimeout int
+	// StatusUpdateInterval is the minimum duration(second) between status updates of nodelocalstorage, changes in between are coalesced, 0 means every change is updated at once
+	StatusUpdateInterval int
+	// LoopDevices enables attaching loop devices over loopFiles of vgs to be inited, for testing and development only
+	LoopDevices bool
 }
 
 const (
@@ -38,4 +96,30 @@ const (
 	// DefaultInterval is the duration(second) that the agent checks at one time
 	DefaultInterval int    = 60
 	DefaultEndpoint string = "unix://tmp/csi.sock"
+	// DefaultMetadataLowThreshold is the ratio of free vg metadata area below which vg is reported as MetadataLow
+	DefaultMetadataLowThreshold float64 = 0.1
+	// DefaultDiskHotThreshold is the temperature(celsius) above which device is reported as DiskHot
+	DefaultDiskHotThreshold int64 = 70
+	// DefaultLVActivationConcurrency is the number of inactive lvs activated at the same time when agent starts
+	DefaultLVActivationConcurrency int = 4
+	// DefaultStatusDriftTolerance is the ratio of vg size within which capacity in status may differ from lvm state
+	DefaultStatusDriftTolerance float64 = 0.01
+	// DefaultShutdownTimeout is the duration(second) agent waits for the running cycle to finish on shutdown
+	DefaultShutdownTimeout int = 20
+	// DefaultStatusUpdateInterval is the minimum duration(second) between status updates of nodelocalstorage
+	DefaultStatusUpdateInterval int = 10
+	// DefaultSnapshotEmergencyUsage is the usage ratio of snapshot lv above which it is expanded out of snapshot expansion windows
+	DefaultSnapshotEmergencyUsage float64 = 0.9
+	// MaxSnapshotTrendSamples bounds usage samples kept for each snapshot lv
+	MaxSnapshotTrendSamples int = 1440
+
+	// LVActivationOrderScheduledFirst activates lvs backing pods scheduled to the node first, then others by name
+	LVActivationOrderScheduledFirst string = "scheduled-first"
+	// LVActivationOrderName activates lvs by name
+	LVActivationOrderName string = "name"
+
+	// InventoryFormatJSON writes inventory file as indented json
+	InventoryFormatJSON string = "json"
+	// InventoryFormatYAML writes inventory file as yaml
+	InventoryFormatYAML string = "yaml"
 )
@@ -39,7 +39,10 @@ import (
 	log "k8s.io/klog/v2"
 )
 
-const initResourceKey = "initResource"
+const (
+	initResourceKey = "initResource"
+	syncPauseKey    = "syncPause"
+)
 
 // NewAgent returns a new open-local agent
 func NewAgent(
@@ -82,7 +85,16 @@ func (c *Agent) Run(stopCh <-chan struct{}) error {
 	}
 	// Start the informer factories to begin populating the informer caches
 	discoverer := discovery.NewDiscoverer(c.Configuration, c.kubeclientset, c.localclientset, c.snapclientset, c.eventRecorder)
+	discoverer.CollectVersions()
+	discoverer.CheckPrivileges()
+	// loops started below honor pause from the beginning
+	discoverer.SyncPause()
+	// activate lvs left inactive by reboot along with discovery
+	go discoverer.ActivateLogicalVolumes()
 	go wait.Until(discoverer.Discover, time.Duration(discoverer.DiscoverInterval)*time.Second, stopCh)
+	if discoverer.StatusDriftCheckInterval > 0 {
+		go wait.Until(discoverer.CheckStatusDrift, time.Duration(discoverer.StatusDriftCheckInterval)*time.Second, stopCh)
+	}
 	go wait.BackoffUntil(func() {
 		c.workqueue.Add(initResourceKey)
 	},
@@ -111,6 +123,8 @@ func (c *Agent) Run(stopCh <-chan struct{}) error {
 	log.Info("Started open-local agent")
 	<-stopCh
 	log.Info("Shutting down agent")
+	c.workqueue.ShutDown()
+	discoverer.Shutdown(time.Duration(c.ShutdownTimeout) * time.Second)
 
 	return nil
 }
@@ -125,6 +139,7 @@ func (c *Agent) handleNLS(old, new interface{}) {
 	if nlsName == c.Nodename {
 		if old == nil {
 			c.workqueue.Add(initResourceKey)
+			c.workqueue.Add(syncPauseKey)
 			return
 		}
 		oldNLS, ok := old.(*localv1alpha1.NodeLocalStorage)
@@ -139,6 +154,9 @@ func (c *Agent) handleNLS(old, new interface{}) {
 			c.workqueue.Add(initResourceKey)
 			log.Info("nls spec changed, trigger init resource")
 		}
+		if oldNLS.Annotations[localtype.AnnoDiscoveryPaused] != newNLS.Annotations[localtype.AnnoDiscoveryPaused] {
+			c.workqueue.Add(syncPauseKey)
+		}
 	}
 }
 
@@ -161,6 +179,8 @@ func (c *Agent) processNextWorkItem(discovery *discovery.Discoverer) bool {
 		case string:
 			if item == initResourceKey {
 				discovery.InitResource()
+			} else if item == syncPauseKey {
+				discovery.SyncPause()
 			}
 		default:
 			c.workqueue.Forget(obj)
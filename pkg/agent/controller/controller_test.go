@@ -17,15 +17,19 @@ limitations under the License.
 package controller
 
 import (
+	localtype "github.com/alibaba/open-local/pkg"
 	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
 	localinformerfactory "github.com/alibaba/open-local/pkg/generated/informers/externalversions"
 	volumesnapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	volumesnapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"reflect"
 	"testing"
 	"time"
@@ -88,3 +92,47 @@ func TestNewAgent(t *testing.T) {
 		})
 	}
 }
+
+func TestAgent_handleNLS_Pause(t *testing.T) {
+	running := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	paused := running.DeepCopy()
+	paused.Annotations = map[string]string{localtype.AnnoDiscoveryPaused: "true"}
+	tests := []struct {
+		name     string
+		old      *localv1alpha1.NodeLocalStorage
+		new      *localv1alpha1.NodeLocalStorage
+		wantSync bool
+	}{
+		{
+			name:     "test pause",
+			old:      running,
+			new:      paused,
+			wantSync: true,
+		},
+		{
+			name:     "test resume",
+			old:      paused,
+			new:      running,
+			wantSync: true,
+		},
+		{
+			name:     "test annotation unchanged",
+			old:      paused,
+			new:      paused,
+			wantSync: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Agent{
+				Configuration: &common.Configuration{Nodename: "test-node"},
+				workqueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test"),
+			}
+			defer c.workqueue.ShutDown()
+			c.handleNLS(tt.old, tt.new)
+			if got := c.workqueue.Len() == 1; got != tt.wantSync {
+				t.Errorf("handleNLS() queues sync of pause = %t, want %t", got, tt.wantSync)
+			}
+		})
+	}
+}
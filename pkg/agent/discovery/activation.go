@@ -0,0 +1,236 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	log "k8s.io/klog/v2"
+)
+
+// inactiveLV is a logical volume to activate
+type inactiveLV struct {
+	vgName string
+	lvName string
+}
+
+func (lv inactiveLV) String() string {
+	return lv.vgName + "/" + lv.lvName
+}
+
+// lvActivation tracks progress of activating lvs, which is reported in status
+// of nls by Discover
+type lvActivation struct {
+	lock   sync.Mutex
+	status *localv1alpha1.LVActivationStatus
+}
+
+func (a *lvActivation) start(total int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := metav1.Now()
+	a.status = &localv1alpha1.LVActivationStatus{
+		Phase:     localv1alpha1.LVActivationRunning,
+		Total:     total,
+		StartTime: &now,
+	}
+}
+
+func (a *lvActivation) record(lv inactiveLV, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err != nil {
+		a.status.Failed = append(a.status.Failed, lv.String())
+		return
+	}
+	a.status.Activated++
+}
+
+func (a *lvActivation) complete() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := metav1.Now()
+	a.status.Phase = localv1alpha1.LVActivationCompleted
+	a.status.CompletionTime = &now
+}
+
+// get returns a copy of progress, nil if activation never started
+func (a *lvActivation) get() *localv1alpha1.LVActivationStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.status.DeepCopy()
+}
+
+// ActivateLogicalVolumes activates inactive open-local lvs once when agent
+// starts, with at most LVActivationConcurrency lvs at the same time. Lvs
+// backing pods scheduled to the node are activated first, so that these pods
+// start without waiting for the others.
+func (d *Discoverer) ActivateLogicalVolumes() {
+	if d.LVActivationConcurrency <= 0 {
+		return
+	}
+	end, ok := d.beginCycle(utils.OperationTypeLVActivation)
+	if !ok {
+		return
+	}
+	defer end()
+	all, err := d.listInactiveLVs()
+	if err != nil {
+		log.Errorf("list inactive logical volumes error: %s", err.Error())
+		return
+	}
+	var lvs []inactiveLV
+	for _, lv := range all {
+		if d.isLocalLV(lv.lvName) {
+			lvs = append(lvs, lv)
+		}
+	}
+	if len(lvs) == 0 {
+		log.V(4).Info("no inactive logical volume to activate")
+		return
+	}
+	var scheduled map[string]bool
+	if d.LVActivationOrder == common.LVActivationOrderScheduledFirst {
+		if scheduled, err = d.scheduledLVs(); err != nil {
+			log.Warningf("get logical volumes of pods scheduled to node %s error, activate by name: %s", d.Nodename, err.Error())
+		}
+	}
+	sortInactiveLVs(lvs, scheduled)
+
+	log.Infof("activating %d logical volumes, concurrency %d", len(lvs), d.LVActivationConcurrency)
+	d.activation.start(len(lvs))
+	// unbuffered channel hands out lvs in order
+	queue := make(chan inactiveLV)
+	var wg sync.WaitGroup
+	for i := 0; i < d.LVActivationConcurrency && i < len(lvs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lv := range queue {
+				err := d.activateLV(lv.vgName, lv.lvName)
+				if err != nil {
+					log.Errorf("activate logical volume %s error: %s", lv, err.Error())
+				} else {
+					log.V(4).Infof("logical volume %s is activated", lv)
+				}
+				d.activation.record(lv, err)
+			}
+		}()
+	}
+	for _, lv := range lvs {
+		queue <- lv
+	}
+	close(queue)
+	wg.Wait()
+	d.activation.complete()
+	status := d.activation.get()
+	log.Infof("%d of %d logical volumes are activated, failed: %v", status.Activated, status.Total, status.Failed)
+}
+
+// sortInactiveLVs puts lvs in scheduled first, and sorts lvs by name otherwise
+func sortInactiveLVs(lvs []inactiveLV, scheduled map[string]bool) {
+	sort.SliceStable(lvs, func(i, j int) bool {
+		if si, sj := scheduled[lvs[i].String()], scheduled[lvs[j].String()]; si != sj {
+			return si
+		}
+		return lvs[i].String() < lvs[j].String()
+	})
+}
+
+// scheduledLVs returns lvs in form of <vg>/<lv> backing pods scheduled to the node
+func (d *Discoverer) scheduledLVs() (map[string]bool, error) {
+	pods, err := d.kubeclientset.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", d.Nodename).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pods error: %s", err.Error())
+	}
+	claims := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != d.Nodename || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claims[utils.GetNameKey(pod.Namespace, volume.PersistentVolumeClaim.ClaimName)] = true
+			}
+		}
+	}
+	if len(claims) == 0 {
+		return nil, nil
+	}
+	pvs, err := d.kubeclientset.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pvs error: %s", err.Error())
+	}
+	lvs := map[string]bool{}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.ClaimRef == nil || !claims[utils.GetNameKey(pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)] {
+			continue
+		}
+		// lvs of other nodes never match inactive lvs of this node
+		if isOpenLocal, volumeType := utils.IsOpenLocalPV(pv); !isOpenLocal || volumeType != localtype.VolumeTypeLVM {
+			continue
+		}
+		lvs[inactiveLV{vgName: utils.GetVGNameFromCsiPV(pv), lvName: utils.GetLVNameFromCsiPV(pv)}.String()] = true
+	}
+	return lvs, nil
+}
+
+// listInactiveLVs returns inactive lvs of all vgs on node
+func listInactiveLVs() ([]inactiveLV, error) {
+	vgNames, err := lvm.ListVolumeGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	var lvs []inactiveLV
+	for _, vgName := range vgNames {
+		vg, err := lvm.LookupVolumeGroup(vgName)
+		if err != nil {
+			return nil, err
+		}
+		names, err := vg.ListInactiveLogicalVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			lvs = append(lvs, inactiveLV{vgName: vgName, lvName: name})
+		}
+	}
+	return lvs, nil
+}
+
+// activateLV activates lv in vg
+func activateLV(vgName, lvName string) error {
+	vg, err := lvm.LookupVolumeGroup(vgName)
+	if err != nil {
+		return err
+	}
+	return vg.ActivateLogicalVolume(lvName)
+}
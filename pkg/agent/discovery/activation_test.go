@@ -0,0 +1,219 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+const activationNode = "node-1"
+
+// lvActivationRecorder records order and concurrency of activation
+type lvActivationRecorder struct {
+	lock        sync.Mutex
+	order       []string
+	inflight    int
+	maxInflight int
+	// blocked lvs wait until release is closed
+	blocked int
+	release chan struct{}
+	failOn  string
+}
+
+func (r *lvActivationRecorder) activate(vgName, lvName string) error {
+	lv := vgName + "/" + lvName
+	r.lock.Lock()
+	r.order = append(r.order, lv)
+	r.inflight++
+	if r.inflight > r.maxInflight {
+		r.maxInflight = r.inflight
+	}
+	// first lvs are held until all workers are busy
+	wait := len(r.order) <= r.blocked
+	if len(r.order) == r.blocked {
+		close(r.release)
+	}
+	r.lock.Unlock()
+	if wait {
+		<-r.release
+	}
+	r.lock.Lock()
+	r.inflight--
+	r.lock.Unlock()
+	if lv == r.failOn {
+		return errors.New("fake error")
+	}
+	return nil
+}
+
+func activationPod(name, claim string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: activationNode,
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func activationPV(lvName, claim string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: lvName},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: claim},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver: localtype.ProvisionerName,
+					VolumeAttributes: map[string]string{
+						localtype.VolumeTypeKey: string(localtype.VolumeTypeLVM),
+						localtype.VGName:        "vg",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestDiscoverer_ActivateLogicalVolumes(t *testing.T) {
+	// 12 inactive open-local lvs, 5 of them back pods scheduled to the node
+	var inactive []inactiveLV
+	var objects []runtime.Object
+	scheduled := map[string]bool{}
+	for i := 0; i < 12; i++ {
+		lvName := fmt.Sprintf("local-%02d", i)
+		inactive = append(inactive, inactiveLV{vgName: "vg", lvName: lvName})
+		claim := fmt.Sprintf("pvc-%02d", i)
+		objects = append(objects, activationPV(lvName, claim))
+		// pods of later lvs are scheduled, so that order by name is different
+		if i >= 7 {
+			objects = append(objects, activationPod(fmt.Sprintf("pod-%02d", i), claim, corev1.PodRunning))
+			scheduled["vg/"+lvName] = true
+		}
+	}
+	// lv of finished pod and lv not created by open-local are not prioritized
+	objects = append(objects, activationPod("pod-done", "pvc-00", corev1.PodSucceeded))
+	inactive = append(inactive, inactiveLV{vgName: "vg", lvName: "docker-pool"})
+
+	var byName, scheduledFirst []string
+	for i := 0; i < 12; i++ {
+		byName = append(byName, fmt.Sprintf("vg/local-%02d", i))
+	}
+	scheduledFirst = append(append(scheduledFirst, byName[7:]...), byName[:7]...)
+
+	tests := []struct {
+		name        string
+		concurrency int
+		order       string
+		wantOrder   []string
+	}{
+		{
+			name:        "test serial activation by name",
+			concurrency: 1,
+			order:       common.LVActivationOrderName,
+			wantOrder:   byName,
+		},
+		{
+			name:        "test serial activation scheduled first",
+			concurrency: 1,
+			order:       common.LVActivationOrderScheduledFirst,
+			wantOrder:   scheduledFirst,
+		},
+		{
+			name:        "test bounded concurrency scheduled first",
+			concurrency: 3,
+			order:       common.LVActivationOrderScheduledFirst,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &lvActivationRecorder{blocked: tt.concurrency, release: make(chan struct{}), failOn: "vg/local-03"}
+			d := &Discoverer{
+				Configuration: &common.Configuration{
+					Nodename:                  activationNode,
+					LogicalVolumeNamePrefix:   "local",
+					LogicalVolumeNameTemplate: utils.DefaultLVNameTemplate,
+					LVActivationConcurrency:   tt.concurrency,
+					LVActivationOrder:         tt.order,
+				},
+				kubeclientset:   kubefake.NewSimpleClientset(objects...),
+				listInactiveLVs: func() ([]inactiveLV, error) { return append([]inactiveLV{}, inactive...), nil },
+				activateLV:      recorder.activate,
+				activation:      &lvActivation{},
+			}
+			d.ActivateLogicalVolumes()
+
+			if recorder.maxInflight != tt.concurrency {
+				t.Errorf("max concurrent activations = %d, want %d", recorder.maxInflight, tt.concurrency)
+			}
+			if len(recorder.order) != 12 {
+				t.Fatalf("activated %d lvs, want 12: %v", len(recorder.order), recorder.order)
+			}
+			if tt.wantOrder != nil && !reflect.DeepEqual(recorder.order, tt.wantOrder) {
+				t.Errorf("activation order = %v, want %v", recorder.order, tt.wantOrder)
+			}
+			if tt.order == common.LVActivationOrderScheduledFirst {
+				// lvs held until all workers are busy are the first ones handed out
+				for _, lv := range recorder.order[:tt.concurrency] {
+					if !scheduled[lv] {
+						t.Errorf("lv %s is activated before lvs of scheduled pods, order %v", lv, recorder.order)
+					}
+				}
+			}
+
+			status := d.activation.get()
+			if status == nil {
+				t.Fatalf("activation status is nil")
+			}
+			if status.Phase != localv1alpha1.LVActivationCompleted || status.Total != 12 || status.Activated != 11 ||
+				!reflect.DeepEqual(status.Failed, []string{"vg/local-03"}) || status.CompletionTime == nil {
+				t.Errorf("activation status = %+v", status)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_ActivateLogicalVolumes_Disabled(t *testing.T) {
+	d := &Discoverer{
+		Configuration: &common.Configuration{LVActivationConcurrency: 0},
+		listInactiveLVs: func() ([]inactiveLV, error) {
+			t.Errorf("inactive lvs are listed while activation is disabled")
+			return nil, nil
+		},
+		activation: &lvActivation{},
+	}
+	d.ActivateLogicalVolumes()
+	if status := d.activation.get(); status != nil {
+		t.Errorf("activation status = %+v, want nil", status)
+	}
+}
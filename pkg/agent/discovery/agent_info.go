@@ -0,0 +1,68 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/version"
+	log "k8s.io/klog/v2"
+)
+
+// length of config hash reported in status
+const configHashLength = 16
+
+// discoveryConfigHash returns hash of discovery flags of agent and list
+// config of nls, node name is excluded so that nodes running the same config
+// report the same hash
+func discoveryConfigHash(config *common.Configuration, listConfig localv1alpha1.ListConfig) string {
+	flags := *config
+	flags.Nodename = ""
+	content, err := json.Marshal(struct {
+		Flags      common.Configuration     `json:"flags"`
+		ListConfig localv1alpha1.ListConfig `json:"listConfig"`
+	}{flags, listConfig})
+	if err != nil {
+		log.Warningf("[agentInfo]failed to marshal discovery config: %s", err.Error())
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])[:configHashLength]
+}
+
+// agentInfo returns build of agent and hash of discovery config in effect
+// with nls, a change of the hash is logged as config reload
+func (d *Discoverer) agentInfo(nls *localv1alpha1.NodeLocalStorage) *localv1alpha1.AgentInfo {
+	hash := discoveryConfigHash(d.Configuration, nls.Spec.ListConfig)
+	if hash != d.configHash {
+		if d.configHash == "" {
+			log.Infof("[agentInfo]agent %s runs discovery config %s", version.GetFullVersion(true), hash)
+		} else {
+			log.Infof("[agentInfo]discovery config is reloaded from %s to %s", d.configHash, hash)
+		}
+		d.configHash = hash
+	}
+	return &localv1alpha1.AgentInfo{
+		Version:    version.Version,
+		GitCommit:  version.GitCommit,
+		ConfigHash: hash,
+	}
+}
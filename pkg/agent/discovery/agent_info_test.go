@@ -0,0 +1,81 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/version"
+)
+
+func TestDiscoverer_agentInfo(t *testing.T) {
+	originVersion, originCommit := version.Version, version.GitCommit
+	version.Version, version.GitCommit = "v0.7.1", "7c1f3b9e2d4a"
+	defer func() { version.Version, version.GitCommit = originVersion, originCommit }()
+
+	nls := &localv1alpha1.NodeLocalStorage{}
+	nls.Spec.ListConfig.VGs.Include = []string{"open-local-pool-[0-9]+"}
+	d := &Discoverer{Configuration: &common.Configuration{Nodename: "node-1", DiscoverInterval: 60, RegExp: "^(s|v|xv)d[a-z]+$"}}
+	info := d.agentInfo(nls)
+	if info.Version != "v0.7.1" || info.GitCommit != "7c1f3b9e2d4a" {
+		t.Errorf("agentInfo() build = %s %s, want v0.7.1 7c1f3b9e2d4a", info.Version, info.GitCommit)
+	}
+	if len(info.ConfigHash) != configHashLength {
+		t.Fatalf("agentInfo() config hash = %q, want %d characters", info.ConfigHash, configHashLength)
+	}
+	hash := info.ConfigHash
+
+	// node name is not part of config
+	other := &Discoverer{Configuration: &common.Configuration{Nodename: "node-2", DiscoverInterval: 60, RegExp: "^(s|v|xv)d[a-z]+$"}}
+	if got := other.agentInfo(nls).ConfigHash; got != hash {
+		t.Errorf("config hash of another node = %s, want %s", got, hash)
+	}
+
+	// list config of nls is reloaded
+	reloaded := nls.DeepCopy()
+	reloaded.Spec.ListConfig.VGs.Include = append(reloaded.Spec.ListConfig.VGs.Include, "share")
+	if got := d.agentInfo(reloaded).ConfigHash; got == hash {
+		t.Errorf("config hash is unchanged after list config changes")
+	}
+	if d.configHash == hash {
+		t.Errorf("reported config hash is unchanged after list config changes")
+	}
+	if got := d.agentInfo(nls).ConfigHash; got != hash {
+		t.Errorf("config hash = %s after list config is restored, want %s", got, hash)
+	}
+
+	// flags of agent
+	d.Configuration.DiscoverInterval = 30
+	if got := d.agentInfo(nls).ConfigHash; got == hash {
+		t.Errorf("config hash is unchanged after discover interval changes")
+	}
+}
+
+func Test_isCriticalStatusChange_agent(t *testing.T) {
+	old := &localv1alpha1.NodeLocalStorageStatus{}
+	old.NodeStorageInfo.Agent = &localv1alpha1.AgentInfo{Version: "v0.7.0", ConfigHash: "a"}
+	status := old.DeepCopy()
+	if isCriticalStatusChange(old, status) {
+		t.Errorf("isCriticalStatusChange() = true for the same agent")
+	}
+	status.NodeStorageInfo.Agent.ConfigHash = "b"
+	if !isCriticalStatusChange(old, status) {
+		t.Errorf("isCriticalStatusChange() = false after config hash changes")
+	}
+}
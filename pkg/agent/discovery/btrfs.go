@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	log "k8s.io/klog/v2"
+)
+
+const btrfsFsType = "btrfs"
+
+var (
+	// replaced in unit test
+	btrfsQgroupShow = runBtrfsQgroupShow
+)
+
+// btrfsQgroup is usage and limits of qgroup in bytes, 0 limit means none
+type btrfsQgroup struct {
+	id           string
+	referenced   uint64
+	exclusive    uint64
+	maxRefer     uint64
+	maxExclusive uint64
+}
+
+// setBtrfsCapacity replaces capacity of btrfs mount point got by statfs,
+// which ignores quota, with limit and usage of qgroup of the subvolume. The
+// capacity of statfs is kept if quota is disabled or subvolume is unlimited
+func setBtrfsCapacity(mp *localv1alpha1.MountPoint) {
+	if mp.FsType != btrfsFsType {
+		return
+	}
+	out, err := btrfsQgroupShow(mp.Name)
+	if err != nil {
+		log.V(4).Infof("keep statfs capacity of btrfs mount point %s: %s", mp.Name, err.Error())
+		return
+	}
+	qgroup, err := parseBtrfsQgroupShow(out)
+	if err != nil {
+		log.Warningf("keep statfs capacity of btrfs mount point %s: %s", mp.Name, err.Error())
+		return
+	}
+	total, used := qgroup.maxRefer, qgroup.referenced
+	if total == 0 || (qgroup.maxExclusive != 0 && qgroup.maxExclusive < total) {
+		total, used = qgroup.maxExclusive, qgroup.exclusive
+	}
+	if total == 0 {
+		return
+	}
+	available := uint64(0)
+	if used < total {
+		available = total - used
+	}
+	// free space of the filesystem may be less than what quota allows
+	if mp.Available < available {
+		available = mp.Available
+	}
+	mp.Total = total
+	mp.Available = available
+	if available == 0 {
+		mp.Condition = localv1alpha1.StorageFull
+	}
+}
+
+// runBtrfsQgroupShow lists qgroup of the subvolume at path in bytes,
+// without ancestral qgroups
+func runBtrfsQgroupShow(path string) (string, error) {
+	out, err := exec.Command("sh", "-c", fmt.Sprintf("%s btrfs qgroup show -ref --raw %s", localtype.NsenterCmd, path)).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// parseBtrfsQgroupShow parses level 0 qgroup, the one of subvolume, from
+// output of btrfs qgroup show -ref --raw. Columns are located by header since
+// newer btrfs-progs appends path column
+func parseBtrfsQgroupShow(out string) (btrfsQgroup, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) == 0 {
+		return btrfsQgroup{}, fmt.Errorf("empty qgroup output")
+	}
+	columns := map[string]int{}
+	for i, name := range strings.Fields(lines[0]) {
+		columns[name] = i
+	}
+	for _, name := range []string{"qgroupid", "rfer", "excl", "max_rfer", "max_excl"} {
+		if _, ok := columns[name]; !ok {
+			return btrfsQgroup{}, fmt.Errorf("column %s not found in qgroup output %q", name, out)
+		}
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(columns) || !strings.HasPrefix(fields[columns["qgroupid"]], "0/") {
+			continue
+		}
+		qgroup := btrfsQgroup{id: fields[columns["qgroupid"]]}
+		values := []*uint64{&qgroup.referenced, &qgroup.exclusive, &qgroup.maxRefer, &qgroup.maxExclusive}
+		for i, name := range []string{"rfer", "excl", "max_rfer", "max_excl"} {
+			value := fields[columns[name]]
+			if value == "none" {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return btrfsQgroup{}, fmt.Errorf("invalid %s %q of qgroup %s", name, value, qgroup.id)
+			}
+			*values[i] = v
+		}
+		return qgroup, nil
+	}
+	return btrfsQgroup{}, fmt.Errorf("qgroup of subvolume not found in qgroup output %q", out)
+}
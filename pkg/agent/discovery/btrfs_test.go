@@ -0,0 +1,181 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"testing"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+const (
+	qgroupShowLimited = `qgroupid         rfer         excl     max_rfer     max_excl 
+--------         ----         ----     --------     -------- 
+0/257      3221225472   2147483648  10737418240         none 
+`
+	qgroupShowWithPath = `qgroupid         rfer         excl     max_rfer     max_excl   path 
+--------         ----         ----     --------     --------   ---- 
+0/258      1073741824   1073741824         none   5368709120   data 
+`
+	qgroupShowUnlimited = `qgroupid         rfer         excl     max_rfer     max_excl 
+--------         ----         ----     --------     -------- 
+0/5             16384        16384         none         none 
+`
+)
+
+func Test_parseBtrfsQgroupShow(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    btrfsQgroup
+		wantErr bool
+	}{
+		{
+			name: "test referenced limit",
+			out:  qgroupShowLimited,
+			want: btrfsQgroup{id: "0/257", referenced: 3221225472, exclusive: 2147483648, maxRefer: 10737418240},
+		},
+		{
+			name: "test exclusive limit with path column",
+			out:  qgroupShowWithPath,
+			want: btrfsQgroup{id: "0/258", referenced: 1073741824, exclusive: 1073741824, maxExclusive: 5368709120},
+		},
+		{
+			name: "test unlimited",
+			out:  qgroupShowUnlimited,
+			want: btrfsQgroup{id: "0/5", referenced: 16384, exclusive: 16384},
+		},
+		{
+			name:    "test missing column",
+			out:     "qgroupid rfer excl\n0/5 16384 16384\n",
+			wantErr: true,
+		},
+		{
+			name:    "test invalid value",
+			out:     "qgroupid rfer excl max_rfer max_excl\n0/5 16384 16384 10G none\n",
+			wantErr: true,
+		},
+		{
+			name:    "test no subvolume qgroup",
+			out:     "qgroupid rfer excl max_rfer max_excl\n1/100 16384 16384 none none\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBtrfsQgroupShow(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBtrfsQgroupShow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBtrfsQgroupShow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setBtrfsCapacity(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	tests := []struct {
+		name          string
+		fsType        string
+		out           string
+		err           error
+		available     uint64
+		wantTotal     uint64
+		wantAvailable uint64
+		wantCondition localv1alpha1.StorageConditionType
+	}{
+		{
+			name:          "test subvolume with referenced limit",
+			fsType:        "btrfs",
+			out:           qgroupShowLimited,
+			available:     100 * gib,
+			wantTotal:     10 * gib,
+			wantAvailable: 7 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test subvolume with exclusive limit",
+			fsType:        "btrfs",
+			out:           qgroupShowWithPath,
+			available:     100 * gib,
+			wantTotal:     5 * gib,
+			wantAvailable: 4 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test filesystem free less than quota",
+			fsType:        "btrfs",
+			out:           qgroupShowLimited,
+			available:     2 * gib,
+			wantTotal:     10 * gib,
+			wantAvailable: 2 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test unlimited subvolume keeps statfs",
+			fsType:        "btrfs",
+			out:           qgroupShowUnlimited,
+			available:     100 * gib,
+			wantTotal:     200 * gib,
+			wantAvailable: 100 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test quota disabled keeps statfs",
+			fsType:        "btrfs",
+			err:           errors.New("ERROR: can't list qgroups: quotas not enabled"),
+			available:     100 * gib,
+			wantTotal:     200 * gib,
+			wantAvailable: 100 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test non btrfs keeps statfs",
+			fsType:        "ext4",
+			out:           qgroupShowLimited,
+			available:     100 * gib,
+			wantTotal:     200 * gib,
+			wantAvailable: 100 * gib,
+			wantCondition: localv1alpha1.StorageReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(f func(string) (string, error)) { btrfsQgroupShow = f }(btrfsQgroupShow)
+			btrfsQgroupShow = func(path string) (string, error) {
+				if tt.fsType != "btrfs" {
+					t.Errorf("btrfs qgroup show is run on %s mount point %s", tt.fsType, path)
+				}
+				return tt.out, tt.err
+			}
+			mp := localv1alpha1.MountPoint{
+				Name:      "/mnt/open-local/disk-1",
+				FsType:    tt.fsType,
+				Total:     200 * gib,
+				Available: tt.available,
+				Condition: localv1alpha1.StorageReady,
+			}
+			setBtrfsCapacity(&mp)
+			if mp.Total != tt.wantTotal || mp.Available != tt.wantAvailable || mp.Condition != tt.wantCondition {
+				t.Errorf("setBtrfsCapacity() total %d available %d condition %s, want %d %d %s", mp.Total, mp.Available, mp.Condition, tt.wantTotal, tt.wantAvailable, tt.wantCondition)
+			}
+		})
+	}
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package discovery
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
 
 	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	log "k8s.io/klog/v2"
 )
 
 func (d *Discoverer) discoverDevices(newStatus *localv1alpha1.NodeLocalStorageStatus) error {
@@ -46,12 +48,22 @@ func (d *Discoverer) discoverDevices(newStatus *localv1alpha1.NodeLocalStorageSt
 			devices = append(devices, device)
 
 			for _, device := range devices {
+				if !d.deviceSizeInRange(device.Total) {
+					log.V(4).Infof("device %s of size %d is out of range [%d, %d], skip", device.Name, device.Total, d.MinDeviceSize, d.MaxDeviceSize)
+					continue
+				}
 				var deviceInfo localv1alpha1.DeviceInfo
 				deviceInfo.Name = device.Name
+				deviceInfo.Parent = device.Parent
 				deviceInfo.MediaType = device.MediaType
 				deviceInfo.ReadOnly = device.ReadOnly
 				deviceInfo.Total = device.Total
 				deviceInfo.Condition = localv1alpha1.StorageReady
+				deviceInfo.Serial = device.Serial
+				deviceInfo.WWN = device.WWN
+				if !device.IsPartition {
+					d.setDeviceTemperature(&deviceInfo)
+				}
 				newStatus.NodeStorageInfo.DeviceInfos = append(newStatus.NodeStorageInfo.DeviceInfos, deviceInfo)
 			}
 		}
@@ -59,3 +71,53 @@ func (d *Discoverer) discoverDevices(newStatus *localv1alpha1.NodeLocalStorageSt
 
 	return nil
 }
+
+// deviceSizeInRange checks size of device against min-device-size and
+// max-device-size, 0 means no limit
+func (d *Discoverer) deviceSizeInRange(size uint64) bool {
+	if d.MinDeviceSize > 0 && size < d.MinDeviceSize {
+		return false
+	}
+	if d.MaxDeviceSize > 0 && size > d.MaxDeviceSize {
+		return false
+	}
+	return true
+}
+
+// setDeviceTemperature records temperature of device if monitoring is enabled,
+// device without temperature sensor such as virtual disk is skipped
+func (d *Discoverer) setDeviceTemperature(deviceInfo *localv1alpha1.DeviceInfo) {
+	if !d.DiskTemperature {
+		return
+	}
+	temp, err := d.readTemperature(deviceInfo.Name)
+	if err != nil {
+		log.V(4).Infof("failed to read temperature of device %s: %s", deviceInfo.Name, err.Error())
+		return
+	}
+	if temp == nil {
+		log.V(6).Infof("device %s reports no temperature", deviceInfo.Name)
+		return
+	}
+	deviceInfo.Temperature = &localv1alpha1.DeviceTemperature{Current: temp.Current, Critical: temp.Critical}
+	if d.DiskHotThreshold > 0 && temp.Current > d.DiskHotThreshold {
+		log.Warningf("temperature %d of device %s exceeds threshold %d", temp.Current, deviceInfo.Name, d.DiskHotThreshold)
+		deviceInfo.Condition = localv1alpha1.StorageHot
+	}
+}
+
+// checkDeviceSignature returns error if device carries any of the signatures
+// configured by user, which means device is in use
+func (d *Discoverer) checkDeviceSignature(dev string) error {
+	if len(d.DeviceSignatures) == 0 {
+		return nil
+	}
+	sig, found, err := deviceutil.FindSignature(dev, d.DeviceSignatures, d.probeDeviceType)
+	if err != nil {
+		return fmt.Errorf("check signatures of device %s error: %s", dev, err.Error())
+	}
+	if found {
+		return fmt.Errorf("device %s is in use, signature %s is found", dev, sig)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+// writeSysBlock creates sysfs of disk under sysPath, partitions are keyed by
+// name with size in sectors
+func writeSysBlock(t *testing.T, sysPath, disk string, sectors int, partitions map[string]int) {
+	files := map[string]string{
+		"queue/rotational": "0\n",
+		"ro":               "0\n",
+		"size":             fmt.Sprint(sectors) + "\n",
+		// not partitions
+		"holders/.keep": "",
+		"power/control": "auto\n",
+	}
+	for part, size := range partitions {
+		files[part+"/partition"] = "1\n"
+		files[part+"/ro"] = "0\n"
+		files[part+"/size"] = fmt.Sprint(size) + "\n"
+	}
+	for name, content := range files {
+		path := filepath.Join(sysPath, "block", disk, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiscoverer_discoverDevices_Partitions(t *testing.T) {
+	sysPath := t.TempDir()
+	// sdb of 100GiB has three partitions, sdc is a whole disk of 50GiB
+	writeSysBlock(t, sysPath, "sdb", 209715200, map[string]int{"sdb1": 2097152, "sdb2": 41943040, "sdb3": 165672960})
+	writeSysBlock(t, sysPath, "sdc", 104857600, nil)
+	d := &Discoverer{Configuration: &common.Configuration{SysPath: sysPath, RegExp: "^(s|v|xv)d[a-z]+$"}}
+
+	newStatus := &localv1alpha1.NodeLocalStorageStatus{}
+	if err := d.discoverDevices(newStatus); err != nil {
+		t.Fatalf("discoverDevices() error = %v", err)
+	}
+	gotDevices := map[string]localv1alpha1.DeviceInfo{}
+	for _, dev := range newStatus.NodeStorageInfo.DeviceInfos {
+		gotDevices[dev.Name] = dev
+	}
+	wantDevices := map[string]struct {
+		parent string
+		total  uint64
+	}{
+		"/dev/sdb":  {total: 209715200 * 512},
+		"/dev/sdb1": {parent: "/dev/sdb", total: 2097152 * 512},
+		"/dev/sdb2": {parent: "/dev/sdb", total: 41943040 * 512},
+		"/dev/sdb3": {parent: "/dev/sdb", total: 165672960 * 512},
+		"/dev/sdc":  {total: 104857600 * 512},
+	}
+	if len(gotDevices) != len(wantDevices) {
+		t.Errorf("discoverDevices() got devices %+v, want %+v", gotDevices, wantDevices)
+	}
+	for name, want := range wantDevices {
+		got, ok := gotDevices[name]
+		if !ok || got.Parent != want.parent || got.Total != want.total {
+			t.Errorf("discoverDevices() device %s = %+v, want parent %q and total %d", name, got, want.parent, want.total)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name:    "test whole disks by default",
+			include: []string{"/dev/sd[a-z]+"},
+			want:    []string{"/dev/sdb", "/dev/sdc"},
+		},
+		{
+			name:    "test only one partition included",
+			include: []string{"/dev/sdb3"},
+			want:    []string{"/dev/sdb3"},
+		},
+		{
+			name:    "test disk of included partition not counted",
+			include: []string{"/dev/sd[a-z]+", "/dev/sdb3"},
+			want:    []string{"/dev/sdb3", "/dev/sdc"},
+		},
+		{
+			name:    "test partitions excluded",
+			include: []string{"/dev/sd.*"},
+			exclude: []string{"/dev/sdb[12]"},
+			want:    []string{"/dev/sdb3", "/dev/sdc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := &localv1alpha1.NodeLocalStorage{Status: *newStatus.DeepCopy()}
+			nls.Spec.ListConfig.Devices = localv1alpha1.DeviceList{Include: tt.include, Exclude: tt.exclude}
+			got := FilterDeviceInfo(nls)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterDeviceInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_discoverDevices_SizeRange(t *testing.T) {
+	const gi = 1024 * 1024 * 1024 / 512
+	sysPath := t.TempDir()
+	// sda of 1GiB is too small, sde of 20TiB is too large, vdz is out of regexp
+	writeSysBlock(t, sysPath, "sda", 1*gi, nil)
+	writeSysBlock(t, sysPath, "sdb", 100*gi, map[string]int{"sdb1": 2 * gi, "sdb2": 98 * gi})
+	writeSysBlock(t, sysPath, "sdc", 10*gi, nil)
+	writeSysBlock(t, sysPath, "sdd", 2048*gi, nil)
+	writeSysBlock(t, sysPath, "sde", 20480*gi, nil)
+	writeSysBlock(t, sysPath, "vdz1", 100*gi, nil)
+
+	tests := []struct {
+		name    string
+		minSize uint64
+		maxSize uint64
+		want    []string
+	}{
+		{
+			name: "test unlimited",
+			want: []string{"/dev/sda", "/dev/sdb", "/dev/sdb1", "/dev/sdb2", "/dev/sdc", "/dev/sdd", "/dev/sde"},
+		},
+		{
+			name:    "test min and max size",
+			minSize: 10 * 1024 * 1024 * 1024,
+			maxSize: 10 * 1024 * 1024 * 1024 * 1024,
+			want:    []string{"/dev/sdb", "/dev/sdb2", "/dev/sdc", "/dev/sdd"},
+		},
+		{
+			name:    "test min size only",
+			minSize: 50 * 1024 * 1024 * 1024,
+			want:    []string{"/dev/sdb", "/dev/sdb2", "/dev/sdd", "/dev/sde"},
+		},
+		{
+			name:    "test max size only",
+			maxSize: 10 * 1024 * 1024 * 1024,
+			want:    []string{"/dev/sda", "/dev/sdb1", "/dev/sdc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{Configuration: &common.Configuration{
+				SysPath:       sysPath,
+				RegExp:        "^(s|v|xv)d[a-z]+$",
+				MinDeviceSize: tt.minSize,
+				MaxDeviceSize: tt.maxSize,
+			}}
+			newStatus := &localv1alpha1.NodeLocalStorageStatus{}
+			if err := d.discoverDevices(newStatus); err != nil {
+				t.Fatalf("discoverDevices() error = %v", err)
+			}
+			got := make([]string, 0)
+			for _, dev := range newStatus.NodeStorageInfo.DeviceInfos {
+				got = append(got, dev.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discoverDevices() devices = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_discoverDevices_Identity(t *testing.T) {
+	sysPath := t.TempDir()
+	writeSysBlock(t, sysPath, "sdb", 209715200, map[string]int{"sdb1": 209713152})
+	writeSysBlock(t, sysPath, "sdc", 104857600, nil)
+	writeSysBlock(t, sysPath, "vda", 104857600, nil)
+	writeSysBlock(t, sysPath, "nvme0n1", 104857600, nil)
+	identity := map[string]string{
+		// scsi disk pads serial with spaces
+		"sdb/device/serial": "  ZA1B2C3D  \n",
+		"sdb/device/wwid":   "naa.5000c500a1b2c3d4\n",
+		// virtio disk has serial only
+		"vda/serial": "vol-0123456789\n",
+		// nvme namespace has wwid, controller has serial
+		"nvme0n1/wwid":          "eui.0025388b91b2c3d4\n",
+		"nvme0n1/device/serial": "S4EVNF0M123456\n",
+		// empty serial is not reported
+		"sdc/device/serial": "\n",
+	}
+	for name, content := range identity {
+		path := filepath.Join(sysPath, "block", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &Discoverer{Configuration: &common.Configuration{SysPath: sysPath, RegExp: "^(s|v|xv)d[a-z]+$|^nvme[0-9]+n[0-9]+$"}}
+
+	newStatus := &localv1alpha1.NodeLocalStorageStatus{}
+	if err := d.discoverDevices(newStatus); err != nil {
+		t.Fatalf("discoverDevices() error = %v", err)
+	}
+	got := map[string][2]string{}
+	for _, dev := range newStatus.NodeStorageInfo.DeviceInfos {
+		got[dev.Name] = [2]string{dev.Serial, dev.WWN}
+	}
+	want := map[string][2]string{
+		"/dev/sdb":     {"ZA1B2C3D", "naa.5000c500a1b2c3d4"},
+		"/dev/sdb1":    {"", ""},
+		"/dev/sdc":     {"", ""},
+		"/dev/vda":     {"vol-0123456789", ""},
+		"/dev/nvme0n1": {"S4EVNF0M123456", "eui.0025388b91b2c3d4"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverDevices() serial and wwn = %v, want %v", got, want)
+	}
+}
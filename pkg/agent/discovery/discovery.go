@@ -24,12 +24,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	localtype "github.com/alibaba/open-local/pkg"
 	"github.com/alibaba/open-local/pkg/agent/common"
 	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	"github.com/alibaba/open-local/pkg/utils"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
 	"github.com/alibaba/open-local/pkg/utils/lvm"
 	"github.com/alibaba/open-local/pkg/utils/spdk"
 	units "github.com/docker/go-units"
@@ -56,6 +59,79 @@ type Discoverer struct {
 	// 'spdk' indicate if use SPDK storage backend
 	spdk       bool
 	spdkclient *spdk.SpdkClient
+	// snapshotUsages records usage of snapshot lv to compute fill velocity
+	snapshotUsages map[string]snapshotUsageRecord
+	// snapshotAlerts is snapshot lvs whose usage is above alert threshold
+	// at the last cycle, alert is raised only when usage crosses it
+	snapshotAlerts map[string]bool
+	// snapshotTrends keeps recent usage samples of snapshot lvs, nil if disabled
+	snapshotTrends *snapshotTrendStore
+	// snapshotLimit is the node cap of total size of snapshot lvs, nil means unlimited
+	snapshotLimit *snapshotCapacityLimit
+	// snapshotSchedule is the windows snapshot lvs are expanded by projected usage, nil means always
+	snapshotSchedule *snapshotExpansionSchedule
+	// probeDeviceType returns blkid types of device
+	probeDeviceType deviceutil.ProbeTypeFunc
+	// readTemperature returns temperature of device
+	readTemperature deviceutil.ReadTemperatureFunc
+	// readDiskStats returns io statistics of block devices
+	readDiskStats deviceutil.ReadDiskStatsFunc
+	// attachLoopFile attaches loop device over backing file
+	attachLoopFile func(path string, size uint64) (string, error)
+	// zeroDevice and verifyZeroed wipe devices released with wipe on delete
+	zeroDevice   func(dev string) error
+	verifyZeroed func(dev string) error
+	// wipes is devices being wiped
+	wipes *deviceWipes
+	// diskStats is the io statistics of the last discovery to compute rate
+	diskStats *diskStatsRecord
+	// lvActivities is io counters of lvs of the last discovery keyed by vg/lv
+	lvActivities map[string]*lvActivity
+	// vgMissingCycles is the number of consecutive discoveries each reported vg is absent
+	vgMissingCycles map[string]int
+	// listInactiveLVs and activateLV operate on lvm when agent starts
+	listInactiveLVs func() ([]inactiveLV, error)
+	activateLV      func(vgName, lvName string) error
+	// activation is progress of activating lvs reported in status
+	activation *lvActivation
+	// inventoryWrittenAt is the time inventory file is written last
+	inventoryWrittenAt time.Time
+	// discoverLock serializes discovery with status drift check
+	discoverLock sync.Mutex
+	// readVGs reads vgs from lvm for status drift check
+	readVGs func(reservedVGInfos map[string]ReservedVGInfo) ([]localv1alpha1.VolumeGroup, error)
+	// statusQueue coalesces status updates of nls
+	statusQueue *statusQueue
+	// statusDrift is the result of the last status drift check reported in status
+	statusDrift *localv1alpha1.StatusDriftStatus
+	// readLVMVersion and readKernelRelease read versions when agent starts
+	readLVMVersion    func() (lvm.Version, error)
+	readKernelRelease func() (string, error)
+	// listPendingSnapshots and removeSnapshot retry removing snapshot lvs
+	// pending deletion
+	listPendingSnapshots func() ([]pendingSnapshot, error)
+	removeSnapshot       func(vgName, lvName string) error
+	// pendingDeletions is the number of failed attempts of each snapshot lv
+	// pending deletion
+	pendingDeletions map[string]int
+	// versions is the versions of storage stack reported in status
+	versions *localv1alpha1.StorageVersions
+	// configHash is the hash of discovery config reported last
+	configHash string
+	// shutdownLock guards shuttingDown and adding to cycles
+	shutdownLock sync.Mutex
+	// shuttingDown is set on shutdown, no cycle starts after it
+	shuttingDown bool
+	// cycles are running cycles of discovery, snapshot expansion and so on
+	cycles sync.WaitGroup
+	// pause is whether discovery and snapshot expansion are paused
+	pause *discoveryPause
+	// discover runs a discovery when pause is changed
+	discover func()
+	// readMissingPrivileges returns privileges agent lacks
+	readMissingPrivileges func() []string
+	// missingPrivileges is privileges agent lacks, checked when agent starts
+	missingPrivileges []string
 }
 
 type ReservedVGInfo struct {
@@ -66,20 +142,47 @@ type ReservedVGInfo struct {
 
 const (
 	DefaultFS          = "ext4"
-	AnnoStorageReserve = "csi.aliyun.com/storage-reserved"
+	AnnoStorageReserve = localtype.ParamKeyPrefix + "storage-reserved"
 )
 
 // NewDiscoverer return Discoverer
 func NewDiscoverer(config *common.Configuration, kubeclientset kubernetes.Interface, localclientset clientset.Interface, snapclient snapshot.Interface, recorder record.EventRecorder) *Discoverer {
-	return &Discoverer{
-		Configuration:  config,
-		localclientset: localclientset,
-		kubeclientset:  kubeclientset,
-		snapclient:     snapclient,
-		K8sMounter:     mount.New("" /* default mount path */),
-		recorder:       recorder,
-		spdk:           false,
+	d := &Discoverer{
+		Configuration:    config,
+		localclientset:   localclientset,
+		kubeclientset:    kubeclientset,
+		snapclient:       snapclient,
+		K8sMounter:       mount.New("" /* default mount path */),
+		recorder:         recorder,
+		spdk:             false,
+		snapshotUsages:   make(map[string]snapshotUsageRecord),
+		vgMissingCycles:  make(map[string]int),
+		probeDeviceType:  deviceutil.ProbeType,
+		readTemperature:  deviceutil.ReadTemperature,
+		readDiskStats:    deviceutil.ReadDiskStats,
+		attachLoopFile:   deviceutil.AttachLoopFile,
+		listInactiveLVs:  listInactiveLVs,
+		activateLV:       activateLV,
+		activation:       &lvActivation{},
+		snapshotLimit:    &snapshotCapacityLimit{},
+		snapshotSchedule: &snapshotExpansionSchedule{},
+		zeroDevice:       deviceutil.ZeroDevice,
+		wipes:            &deviceWipes{running: make(map[string]bool)},
+		pause:            &discoveryPause{},
 	}
+	d.verifyZeroed = func(dev string) error {
+		return deviceutil.VerifyZeroed(dev, deviceutil.WipeVerifySamples)
+	}
+	d.readLVMVersion = lvm.GetVersion
+	d.readKernelRelease = readKernelRelease
+	d.listPendingSnapshots = listPendingSnapshots
+	d.removeSnapshot = removeSnapshotLV
+	d.readVGs = d.lvmVGs
+	d.discover = d.Discover
+	d.readMissingPrivileges = utils.MissingPrivileges
+	d.snapshotTrends = newSnapshotTrendStore(config.SnapshotTrendSamples, time.Duration(config.SnapshotTrendSampleInterval)*time.Second)
+	d.statusQueue = newStatusQueue(time.Duration(config.StatusUpdateInterval)*time.Second, d.updateStatus)
+	return d
 }
 
 func (d *Discoverer) getNodeLocalStorage() (*localv1alpha1.NodeLocalStorage, error) {
@@ -104,29 +207,63 @@ func (d *Discoverer) getNodeLocalStorage() (*localv1alpha1.NodeLocalStorage, err
 
 // Discover update local storage periodically
 func (d *Discoverer) Discover() {
+	end, ok := d.beginCycle(utils.OperationTypeDiscovery)
+	if !ok {
+		return
+	}
+	defer end()
+	d.discoverLock.Lock()
+	defer d.discoverLock.Unlock()
 	if nls, err := d.getNodeLocalStorage(); err != nil {
 		return
+	} else if d.pausedByNLS(nls) {
+		log.V(4).Infof("discovery of node %s is paused, skip", d.Nodename)
+		return
+	} else if d.insufficientPrivileges(nls) {
+		log.V(4).Infof("agent of node %s lacks privileges, skip discovery", d.Nodename)
+		return
 	} else {
 		log.V(4).Infof("update node local storage %s status", d.Nodename)
-		nlsCopy := nls.DeepCopy()
+		nlsCopy := d.statusQueue.overlay(nls).DeepCopy()
 		// get anno
 		reservedVGInfos := make(map[string]ReservedVGInfo)
-		if anno, exist := nlsCopy.Annotations[AnnoStorageReserve]; exist {
+		if anno, exist := utils.LookupParam(nlsCopy.Annotations, AnnoStorageReserve); exist {
 			if reservedVGInfos, err = getReservedVGInfo(anno); err != nil {
 				log.Errorf("get reserved vg info failed: %s, but we ignore...", err.Error())
 				return
 			}
 		}
+		// removed snapshots are not reported
+		if !d.spdk {
+			d.removePendingSnapshots()
+		}
 		// get status first, for we need support regexp
 		newStatus := new(localv1alpha1.NodeLocalStorageStatus)
 		if err := d.discoverVGs(newStatus, reservedVGInfos); err != nil {
 			log.Errorf("discover VG error: %s", err.Error())
 			return
 		}
+		if err := d.setLVOwners(newStatus); err != nil {
+			log.Warningf("set owners of logical volumes error: %s", err.Error())
+		}
+		if err := d.setVGIOStats(newStatus); err != nil {
+			log.Warningf("set io statistics of volume groups error: %s", err.Error())
+		}
+		if err := d.trackLVActivity(newStatus, nlsCopy.Status.NodeStorageInfo.VolumeGroups); err != nil {
+			log.Warningf("track io of logical volumes error: %s", err.Error())
+		}
+		d.setLVIdleSince(newStatus)
+		d.setSnapshotAwareAvailable(newStatus)
+		d.setSnapshotUsageTrends(newStatus)
+		d.retainMissingVGs(newStatus, nlsCopy.Status.NodeStorageInfo.VolumeGroups)
+		d.setSnapshotCapacity(newStatus, nlsCopy.Spec.ListConfig.VGs.MaxSnapshotSize)
+		d.snapshotSchedule.set(nlsCopy.Spec.ListConfig.VGs.SnapshotExpansionWindows)
 		if err := d.discoverDevices(newStatus); err != nil {
 			log.Errorf("discover Device error: %s", err.Error())
 			return
 		}
+		setDevicesWiping(newStatus, nlsCopy)
+		d.wipeDevices(nlsCopy)
 		if err := d.discoverMountPoints(newStatus); err != nil {
 			log.Errorf("discover MountPoint error: %s", err.Error())
 			return
@@ -136,10 +273,16 @@ func (d *Discoverer) Discover() {
 		newStatus.NodeStorageInfo.State.Type = localv1alpha1.StorageReady
 		lastHeartbeatTime := metav1.Now()
 		newStatus.NodeStorageInfo.State.LastHeartbeatTime = &lastHeartbeatTime
+		newStatus.NodeStorageInfo.LVActivation = d.activation.get()
+		newStatus.NodeStorageInfo.StatusDrift = d.statusDrift.DeepCopy()
+		newStatus.NodeStorageInfo.Versions = d.versions.DeepCopy()
+		newStatus.NodeStorageInfo.Agent = d.agentInfo(nlsCopy)
 		nlsCopy.Status.NodeStorageInfo = newStatus.NodeStorageInfo
+		SetVGMaintenance(nlsCopy)
 		nlsCopy.Status.FilteredStorageInfo.VolumeGroups = FilterVGInfo(nlsCopy)
 		nlsCopy.Status.FilteredStorageInfo.MountPoints = FilterMPInfo(nlsCopy)
 		nlsCopy.Status.FilteredStorageInfo.Devices = FilterDeviceInfo(nlsCopy)
+		nlsCopy.Status.NodeStorageInfo.StorageClasses = d.storageClassServiceability(nlsCopy)
 		nlsCopy.Status.FilteredStorageInfo.UpdateStatus.Status = localv1alpha1.UpdateStatusAccepted
 		lastUpdateTime := metav1.Now()
 		nlsCopy.Status.FilteredStorageInfo.UpdateStatus.LastUpdateTime = &lastUpdateTime
@@ -149,10 +292,14 @@ func (d *Discoverer) Discover() {
 			d.createSpdkBdevs(&nlsCopy.Status.FilteredStorageInfo.Devices)
 		}
 
+		d.exportInventory(nlsCopy, time.Now())
+		if d.InventoryOnly {
+			return
+		}
+
 		// only update status
 		log.Infof("update nls %s", nlsCopy.Name)
-		_, err = d.localclientset.CsiV1alpha1().NodeLocalStorages().UpdateStatus(context.Background(), nlsCopy, metav1.UpdateOptions{})
-		if err != nil {
+		if err := d.statusQueue.enqueue(nlsCopy, false); err != nil {
 			log.Errorf("local storage CRD updateStatus error: %s", err.Error())
 			return
 		}
@@ -188,6 +335,11 @@ func (d *Discoverer) createSpdkBdevs(devices *[]string) {
 
 // InitResource will create relevant resource
 func (d *Discoverer) InitResource() {
+	end, ok := d.beginCycle(utils.OperationTypeInitResource)
+	if !ok {
+		return
+	}
+	defer end()
 	log.Info("start to init resource")
 	nls, err := d.getNodeLocalStorage()
 	if err != nil {
@@ -198,10 +350,22 @@ func (d *Discoverer) InitResource() {
 	mountpoints := nls.Spec.ResourceToBeInited.MountPoints
 	if !d.spdk {
 		for _, vg := range vgs {
+			// loop devices are attached before lookup, vg over them is
+			// found only after they are attached again on reboot
+			devices, err := d.vgDevices(vg)
+			if err != nil {
+				msg := fmt.Sprintf("attach loop devices of vg %s failed: %s", vg.Name, err.Error())
+				log.Error(msg)
+				d.recorder.Event(nls, corev1.EventTypeWarning, localtype.EventAttachLoopDeviceFailed, msg)
+				continue
+			}
+			if len(devices) == 0 {
+				continue
+			}
 			if _, err := lvm.LookupVolumeGroup(vg.Name); err == lvm.ErrVolumeGroupNotFound {
-				err := d.createVG(vg.Name, vg.Devices)
+				err := d.createVG(vg.Name, devices)
 				if err != nil {
-					msg := fmt.Sprintf("create vg %s with device %v failed: %s. you can try command \"vgcreate %s %v --force\" manually on this node", vg.Name, vg.Devices, err.Error(), vg.Name, strings.Join(vg.Devices, " "))
+					msg := fmt.Sprintf("create vg %s with device %v failed: %s. you can try command \"vgcreate %s %v --force\" manually on this node", vg.Name, devices, err.Error(), vg.Name, strings.Join(devices, " "))
 					log.Error(msg)
 					d.recorder.Event(nls, corev1.EventTypeWarning, localtype.EventCreateVGFailed, msg)
 				}
@@ -261,6 +425,10 @@ func (d *Discoverer) InitResource() {
 			}
 		}
 		if notMounted {
+			if err := d.checkDeviceSignature(mp.Device); err != nil {
+				log.Errorf("skip formatting mount point %s: %s", mp.Path, err.Error())
+				continue
+			}
 			fsType := mp.FsType
 			if fsType == "" {
 				fsType = DefaultFS
@@ -331,6 +499,13 @@ func FilterVGInfo(nls *localv1alpha1.NodeLocalStorage) []string {
 	return FilterInfo(vgSlice, nls.Spec.ListConfig.VGs.Include, nls.Spec.ListConfig.VGs.Exclude)
 }
 
+// SetVGMaintenance reports vgs listed in maintenance of spec in status
+func SetVGMaintenance(nls *localv1alpha1.NodeLocalStorage) {
+	for i, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+		nls.Status.NodeStorageInfo.VolumeGroups[i].Maintenance = utils.IsVGInMaintenance(nls, vg.Name)
+	}
+}
+
 func FilterMPInfo(nls *localv1alpha1.NodeLocalStorage) []string {
 	var mpSlice []string
 	for _, mp := range nls.Status.NodeStorageInfo.MountPoints {
@@ -346,7 +521,29 @@ func FilterDeviceInfo(nls *localv1alpha1.NodeLocalStorage) []string {
 		devSlice = append(devSlice, dev.Name)
 	}
 
-	return FilterInfo(devSlice, nls.Spec.ListConfig.Devices.Include, nls.Spec.ListConfig.Devices.Exclude)
+	filtered := FilterInfo(devSlice, nls.Spec.ListConfig.Devices.Include, nls.Spec.ListConfig.Devices.Exclude)
+
+	// disk is never offered as a whole if any of its partitions is included,
+	// or its capacity is counted twice
+	included := make(map[string]bool, len(filtered))
+	for _, dev := range filtered {
+		included[dev] = true
+	}
+	partlyUsed := map[string]bool{}
+	for _, dev := range nls.Status.NodeStorageInfo.DeviceInfos {
+		if dev.Parent != "" && included[dev.Name] {
+			partlyUsed[dev.Parent] = true
+		}
+	}
+	var devices []string
+	for _, dev := range filtered {
+		if partlyUsed[dev] {
+			log.V(4).Infof("device %s is excluded as its partition is included", dev)
+			continue
+		}
+		devices = append(devices, dev)
+	}
+	return devices
 }
 
 func FilterInfo(info []string, include []string, exclude []string) []string {
@@ -17,7 +17,27 @@ limitations under the License.
 package discovery
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logsjson "k8s.io/component-base/logs/json"
+	log "k8s.io/klog/v2"
 )
 
 func TestFilterInfo(t *testing.T) {
@@ -59,6 +79,222 @@ func TestFilterInfo(t *testing.T) {
 	}
 }
 
+func TestSetVGMaintenance(t *testing.T) {
+	nls := &localv1alpha1.NodeLocalStorage{
+		Spec: localv1alpha1.NodeLocalStorageSpec{
+			ListConfig: localv1alpha1.ListConfig{
+				VGs: localv1alpha1.VGList{
+					Maintenance: []string{"vg1", "vg-absent"},
+				},
+			},
+		},
+		Status: localv1alpha1.NodeLocalStorageStatus{
+			NodeStorageInfo: localv1alpha1.NodeStorageInfo{
+				VolumeGroups: []localv1alpha1.VolumeGroup{
+					{Name: "vg1"},
+					{Name: "vg2", Maintenance: true},
+				},
+			},
+		},
+	}
+	SetVGMaintenance(nls)
+	want := map[string]bool{"vg1": true, "vg2": false}
+	for _, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+		if vg.Maintenance != want[vg.Name] {
+			t.Errorf("SetVGMaintenance() maintenance of %s = %v, want %v", vg.Name, vg.Maintenance, want[vg.Name])
+		}
+	}
+}
+
+func TestDiscoverer_createVG_DeviceSignature(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "disk")
+	data := make([]byte, 4096)
+	copy(data[512:], []byte("OLMARK"))
+	if err := os.WriteFile(dev, data, 0644); err != nil {
+		t.Fatalf("write device error: %s", err.Error())
+	}
+	signatures, err := deviceutil.ParseSignatures([]string{"magic:512:4f4c4d41524b"})
+	if err != nil {
+		t.Fatalf("ParseSignatures() error = %v", err)
+	}
+	d := &Discoverer{
+		Configuration: &common.Configuration{
+			DeviceSignatures: signatures,
+		},
+		probeDeviceType: func(string) ([]string, error) { return nil, nil },
+	}
+	// device with custom signature is treated as occupied, pvcreate is never called
+	err = d.createVG("vg", []string{dev})
+	if err == nil || !strings.Contains(err.Error(), "is in use") {
+		t.Errorf("createVG() error = %v, want device in use", err)
+	}
+}
+
+func TestDiscoverer_setDeviceTemperature(t *testing.T) {
+	temps := map[string]*deviceutil.Temperature{
+		"/dev/nvme0n1": {Current: 39, Critical: 85},
+		"/dev/nvme1n1": {Current: 78, Critical: 85},
+		"/dev/vdb":     nil,
+	}
+	readTemperature := func(dev string) (*deviceutil.Temperature, error) {
+		temp, ok := temps[dev]
+		if !ok {
+			return nil, errors.New("smartctl: unable to detect device type")
+		}
+		return temp, nil
+	}
+	tests := []struct {
+		name          string
+		enabled       bool
+		threshold     int64
+		device        string
+		wantTemp      *localv1alpha1.DeviceTemperature
+		wantCondition localv1alpha1.StorageConditionType
+	}{
+		{
+			name:          "test monitoring disabled",
+			enabled:       false,
+			threshold:     70,
+			device:        "/dev/nvme1n1",
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test below threshold",
+			enabled:       true,
+			threshold:     70,
+			device:        "/dev/nvme0n1",
+			wantTemp:      &localv1alpha1.DeviceTemperature{Current: 39, Critical: 85},
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test above threshold",
+			enabled:       true,
+			threshold:     70,
+			device:        "/dev/nvme1n1",
+			wantTemp:      &localv1alpha1.DeviceTemperature{Current: 78, Critical: 85},
+			wantCondition: localv1alpha1.StorageHot,
+		},
+		{
+			name:          "test threshold disabled",
+			enabled:       true,
+			threshold:     0,
+			device:        "/dev/nvme1n1",
+			wantTemp:      &localv1alpha1.DeviceTemperature{Current: 78, Critical: 85},
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test device missing temperature",
+			enabled:       true,
+			threshold:     70,
+			device:        "/dev/vdb",
+			wantCondition: localv1alpha1.StorageReady,
+		},
+		{
+			name:          "test read error",
+			enabled:       true,
+			threshold:     70,
+			device:        "/dev/vdc",
+			wantCondition: localv1alpha1.StorageReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{
+				Configuration: &common.Configuration{
+					DiskTemperature:  tt.enabled,
+					DiskHotThreshold: tt.threshold,
+				},
+				readTemperature: readTemperature,
+			}
+			deviceInfo := localv1alpha1.DeviceInfo{Name: tt.device, Condition: localv1alpha1.StorageReady}
+			d.setDeviceTemperature(&deviceInfo)
+			if !reflect.DeepEqual(deviceInfo.Temperature, tt.wantTemp) {
+				t.Errorf("setDeviceTemperature() temperature = %v, want %v", deviceInfo.Temperature, tt.wantTemp)
+			}
+			if deviceInfo.Condition != tt.wantCondition {
+				t.Errorf("setDeviceTemperature() condition = %s, want %s", deviceInfo.Condition, tt.wantCondition)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_getSnapshotContentName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		lvName   string
+		want     string
+		wantOK   bool
+	}{
+		{
+			name:     "test default template",
+			template: "",
+			lvName:   "snap-2a1f6d1e",
+			want:     "snapcontent-2a1f6d1e",
+			wantOK:   true,
+		},
+		{
+			name:     "test custom template",
+			template: "ol_{ns}_{pvc}_{pv}",
+			lvName:   "ol_default_volumesnapshot-ro_snap-2a1f6d1e",
+			want:     "snapcontent-2a1f6d1e",
+			wantOK:   true,
+		},
+		{
+			name:     "test lv not rendered by template",
+			template: "ol_{ns}_{pvc}_{pv}",
+			lvName:   "snap-2a1f6d1e",
+			want:     "",
+			wantOK:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{Configuration: &common.Configuration{LogicalVolumeNameTemplate: tt.template}}
+			got, ok := d.getSnapshotContentName(tt.lvName)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("getSnapshotContentName() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_isLocalLV(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		lvName   string
+		want     bool
+	}{
+		{
+			name:     "test default template",
+			template: "",
+			lvName:   "local-0f7d5c5e",
+			want:     true,
+		},
+		{
+			name:     "test custom template",
+			template: "{ns}_{pvc}_{pv}",
+			lvName:   "default_html-nginx-0_local-0f7d5c5e",
+			want:     true,
+		},
+		{
+			name:     "test lv not created by open-local",
+			template: "{ns}_{pvc}_{pv}",
+			lvName:   "root",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{Configuration: &common.Configuration{LogicalVolumeNamePrefix: "local", LogicalVolumeNameTemplate: tt.template}}
+			if got := d.isLocalLV(tt.lvName); got != tt.want {
+				t.Errorf("isLocalLV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func sameStringSlice(x, y []string) bool {
 	if len(x) != len(y) {
 		return false
@@ -81,3 +317,806 @@ func sameStringSlice(x, y []string) bool {
 	}
 	return len(diff) == 0
 }
+
+// fakeSnapshotLV records the order of expansion
+type fakeSnapshotLV struct {
+	name      string
+	size      uint64
+	usage     float64
+	suspended bool
+	expanded  *[]string
+	// originWritten is bytes written to origin, sizes records expansion size
+	originWritten uint64
+	sizes         map[string]uint64
+	originSize    uint64
+	origin        string
+	// readAhead is nil until read ahead is set, readAheadSets counts lvchange
+	readAhead     *uint64
+	readAheadSets int
+	// vg is open-local-pool-0 if empty, expandErr fails expansion
+	vg        string
+	expandErr error
+}
+
+func (lv *fakeSnapshotLV) Name() string         { return lv.name }
+func (lv *fakeSnapshotLV) OriginLVName() string { return lv.origin }
+func (lv *fakeSnapshotLV) SizeInBytes() uint64  { return lv.size }
+func (lv *fakeSnapshotLV) Usage() float64       { return lv.usage }
+func (lv *fakeSnapshotLV) Expand(size uint64) error {
+	if lv.suspended {
+		return lvm.ErrLogicalVolumeSuspended
+	}
+	if lv.expandErr != nil {
+		return lv.expandErr
+	}
+	*lv.expanded = append(*lv.expanded, lv.name)
+	if lv.sizes != nil {
+		lv.sizes[lv.name] = size
+	}
+	return nil
+}
+
+func (lv *fakeSnapshotLV) VGName() string {
+	if lv.vg == "" {
+		return "open-local-pool-0"
+	}
+	return lv.vg
+}
+
+func (lv *fakeSnapshotLV) OriginWrittenBytes(sysPath string) (uint64, error) {
+	return lv.originWritten, nil
+}
+
+func (lv *fakeSnapshotLV) OriginSizeInBytes() (uint64, error) {
+	return lv.originSize, nil
+}
+
+func (lv *fakeSnapshotLV) SetReadAhead(sectors uint64) (bool, error) {
+	if lv.readAhead != nil && *lv.readAhead == sectors {
+		return false, nil
+	}
+	lv.readAhead = &sectors
+	lv.readAheadSets++
+	return true, nil
+}
+
+// fakeVGFreeSpace replaces free space of vg with 4MiB extent, it returns the
+// function restoring it
+func fakeVGFreeSpace(free uint64) func() {
+	origin := vgFreeSpace
+	vgFreeSpace = func(vgName string) (uint64, uint64, error) { return free, 4 * 1024 * 1024, nil }
+	return func() { vgFreeSpace = origin }
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass"
+	now := time.Now()
+	lastCycle := now.Add(-60 * time.Second)
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"fast", "stable", "slow"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &snapshotClassName,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	tests := []struct {
+		name         string
+		window       int
+		suspended    string
+		wantExpanded []string
+	}{
+		{
+			name:         "test expand faster-filling snapshot first",
+			window:       60,
+			wantExpanded: []string{"snap-fast", "snap-stable"},
+		},
+		{
+			name:         "test projection disabled",
+			window:       0,
+			wantExpanded: []string{"snap-stable"},
+		},
+		{
+			name:         "test skip suspended snapshot",
+			window:       60,
+			suspended:    "snap-fast",
+			wantExpanded: []string{"snap-stable"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded := []string{}
+			lvs := []snapshotLV{
+				// 80% and stable
+				&fakeSnapshotLV{name: "snap-stable", size: size, usage: 0.8, expanded: &expanded},
+				// 60%, filled 30% in the last cycle
+				&fakeSnapshotLV{name: "snap-fast", size: size, usage: 0.6, expanded: &expanded},
+				// 50%, filled 5% in the last cycle
+				&fakeSnapshotLV{name: "snap-slow", size: size, usage: 0.5, expanded: &expanded},
+			}
+			for _, lv := range lvs {
+				if lv.Name() == tt.suspended {
+					lv.(*fakeSnapshotLV).suspended = true
+				}
+			}
+			originList, originNow := listSnapshotLVs, timeNow
+			listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+			timeNow = func() time.Time { return now }
+			defer func() { listSnapshotLVs, timeNow = originList, originNow }()
+			defer fakeVGFreeSpace(1024 * size)()
+
+			d := &Discoverer{
+				Configuration: &common.Configuration{SnapshotProjectionWindow: tt.window},
+				snapclient:    fakeSnapClient,
+				snapshotUsages: map[string]snapshotUsageRecord{
+					"snap-stable": {usedBytes: 0.8 * size, timestamp: lastCycle},
+					"snap-fast":   {usedBytes: 0.3 * size, timestamp: lastCycle},
+					"snap-slow":   {usedBytes: 0.45 * size, timestamp: lastCycle},
+				},
+			}
+			d.expandSnapshotLvmLVIfNeeded()
+			if !reflect.DeepEqual(expanded, tt.wantExpanded) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, tt.wantExpanded)
+			}
+			if record := d.snapshotUsages["snap-fast"]; record.usedBytes != 0.6*size || !record.timestamp.Equal(now) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() record of snap-fast = %+v, want usedBytes %v", record, 0.6*size)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_ContentError(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass"
+	lastCycle := time.Now().Add(-60 * time.Second)
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	// snapshot content of snap-missing is not found
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-stable"},
+		Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+			VolumeSnapshotClassName: &snapshotClassName,
+		},
+	}, metav1.CreateOptions{})
+
+	expanded := []string{}
+	lvs := []snapshotLV{
+		&fakeSnapshotLV{name: "snap-missing", size: size, usage: 0.9, expanded: &expanded},
+		&fakeSnapshotLV{name: "snap-stable", size: size, usage: 0.8, expanded: &expanded},
+	}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	defer func() { listSnapshotLVs = originList }()
+	defer fakeVGFreeSpace(1024 * size)()
+
+	missingRecord := snapshotUsageRecord{usedBytes: 0.5 * size, timestamp: lastCycle}
+	d := &Discoverer{
+		Configuration:  &common.Configuration{},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{"snap-missing": missingRecord},
+		snapshotAlerts: map[string]bool{"snap-missing": true},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+	if !reflect.DeepEqual(expanded, []string{"snap-stable"}) {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want [snap-stable]", expanded)
+	}
+	if record, ok := d.snapshotUsages["snap-missing"]; !ok || record != missingRecord {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() record of snap-missing = %+v, want %+v", record, missingRecord)
+	}
+	if !d.snapshotAlerts["snap-missing"] {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() dropped alert of snap-missing")
+	}
+	if _, ok := d.snapshotUsages["snap-stable"]; !ok {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() recorded no usage of snap-stable")
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_OriginGrowth(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	const gi = 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-origin-growth"
+	now := time.Now()
+	lastCycle := now.Add(-60 * time.Second)
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:                  "true",
+			localtype.ParamSnapshotThreshold:         "70%",
+			localtype.ParamSnapshotExpansionSize:     "1Gi",
+			localtype.ParamSnapshotOriginGrowthRatio: "1.5",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"fast", "stable"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &snapshotClassName,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	expanded := []string{}
+	sizes := map[string]uint64{}
+	lvs := []snapshotLV{
+		// origin written 4Gi in the last cycle
+		&fakeSnapshotLV{name: "snap-fast", size: size, usage: 0.8, expanded: &expanded, sizes: sizes, originWritten: 10 * gi},
+		// origin written 256Mi in the last cycle
+		&fakeSnapshotLV{name: "snap-stable", size: size, usage: 0.8, expanded: &expanded, sizes: sizes, originWritten: 6*gi + gi/4},
+	}
+	originList, originNow := listSnapshotLVs, timeNow
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	timeNow = func() time.Time { return now }
+	defer func() { listSnapshotLVs, timeNow = originList, originNow }()
+	defer fakeVGFreeSpace(1024 * size)()
+
+	d := &Discoverer{
+		Configuration: &common.Configuration{},
+		snapclient:    fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{
+			"snap-fast":   {usedBytes: 0.8 * size, timestamp: lastCycle, originWritten: 6 * gi, hasOriginWritten: true},
+			"snap-stable": {usedBytes: 0.8 * size, timestamp: lastCycle, originWritten: 6 * gi, hasOriginWritten: true},
+		},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+	wantSizes := map[string]uint64{
+		"snap-fast":   6 * gi,
+		"snap-stable": 1 * gi,
+	}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() expansion sizes = %v, want %v", sizes, wantSizes)
+	}
+	if record := d.snapshotUsages["snap-fast"]; !record.hasOriginWritten || record.originWritten != 10*gi {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() record of snap-fast = %+v, want originWritten %v", record, 10*gi)
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_PercentExpansion(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-percent"
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:              "true",
+			localtype.ParamSnapshotInitialSize:   "20%",
+			localtype.ParamSnapshotThreshold:     "50%",
+			localtype.ParamSnapshotExpansionSize: "10%",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"tiny", "huge"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &snapshotClassName,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	expanded := []string{}
+	sizes := map[string]uint64{}
+	lvs := []snapshotLV{
+		&fakeSnapshotLV{name: "snap-tiny", size: gi / 5, usage: 0.8, expanded: &expanded, sizes: sizes, originSize: 1 * gi},
+		&fakeSnapshotLV{name: "snap-huge", size: 200 * gi, usage: 0.8, expanded: &expanded, sizes: sizes, originSize: 1000 * gi},
+	}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	defer func() { listSnapshotLVs = originList }()
+	defer fakeVGFreeSpace(1024 * gi)()
+
+	d := &Discoverer{
+		Configuration:  &common.Configuration{},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+	wantSizes := map[string]uint64{
+		"snap-tiny": gi / 10,
+		"snap-huge": 100 * gi,
+	}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() expansion sizes = %v, want %v", sizes, wantSizes)
+	}
+}
+
+// syncBuffer captures output of json logger
+type syncBuffer struct {
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Sync() error { return nil }
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_JSONLog(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-json-log"
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-json"},
+		Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+			VolumeSnapshotClassName: &snapshotClassName,
+		},
+	}, metav1.CreateOptions{})
+
+	expanded := []string{}
+	lvs := []snapshotLV{&fakeSnapshotLV{name: "snap-json", size: size, usage: 0.8, expanded: &expanded}}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	defer func() { listSnapshotLVs = originList }()
+	defer fakeVGFreeSpace(1024 * size)()
+
+	buf := &syncBuffer{}
+	log.SetLogger(logsjson.NewJSONLogger(buf))
+	defer log.SetLogger(nil)
+
+	d := &Discoverer{
+		Configuration:  &common.Configuration{},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+
+	want := map[string]interface{}{
+		"operation": "ExpandSnapshotLV",
+		"lv":        "snap-json",
+		"vg":        "open-local-pool-0",
+		"snapshot":  "snapcontent-json",
+	}
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not json: %s", line, err.Error())
+		}
+		if entry["msg"] != "expand snapshot lv successfully" {
+			continue
+		}
+		found = true
+		for key, value := range want {
+			if entry[key] != value {
+				t.Errorf("log field %s = %v, want %v", key, entry[key], value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() logged no expansion, output: %s", buf.String())
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_SharedOrigin(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	const mi = 1024 * 1024
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	for class, expansionSize := range map[string]string{"test-snapshotclass-2gi": "2Gi", "test-snapshotclass-512mi": "512Mi"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+			ObjectMeta: metav1.ObjectMeta{Name: class},
+			Parameters: map[string]string{
+				localtype.ParamReadonly:              "true",
+				localtype.ParamSnapshotThreshold:     "70%",
+				localtype.ParamSnapshotExpansionSize: expansionSize,
+			},
+		}, metav1.CreateOptions{})
+	}
+	for id, class := range map[string]string{"full": "test-snapshotclass-2gi", "half": "test-snapshotclass-2gi", "small": "test-snapshotclass-512mi"} {
+		className := class
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &className,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	tests := []struct {
+		name         string
+		free         uint64
+		wantExpanded []string
+		wantSizes    map[string]uint64
+	}{
+		{
+			name:         "test enough free space",
+			free:         10 * 1024 * mi,
+			wantExpanded: []string{"snap-full", "snap-half", "snap-small"},
+			wantSizes:    map[string]uint64{"snap-full": 2048 * mi, "snap-half": 2048 * mi, "snap-small": 512 * mi},
+		},
+		{
+			// 769 extents: 128 for snap-small, the rest is divided evenly and
+			// the odd extent goes to the fullest snapshot
+			name:         "test share scarce free space fairly",
+			free:         3*1024*mi + 4*mi,
+			wantExpanded: []string{"snap-full", "snap-half", "snap-small"},
+			wantSizes:    map[string]uint64{"snap-full": 1284 * mi, "snap-half": 1280 * mi, "snap-small": 512 * mi},
+		},
+		{
+			name:         "test expand the fullest first",
+			free:         8 * mi,
+			wantExpanded: []string{"snap-full", "snap-half"},
+			wantSizes:    map[string]uint64{"snap-full": 4 * mi, "snap-half": 4 * mi},
+		},
+		{
+			name:         "test no free space",
+			free:         0,
+			wantExpanded: []string{},
+			wantSizes:    map[string]uint64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded := []string{}
+			sizes := map[string]uint64{}
+			lvs := []snapshotLV{
+				&fakeSnapshotLV{name: "snap-small", size: size, usage: 0.75, expanded: &expanded, sizes: sizes, origin: "origin-lv"},
+				&fakeSnapshotLV{name: "snap-half", size: size, usage: 0.8, expanded: &expanded, sizes: sizes, origin: "origin-lv"},
+				&fakeSnapshotLV{name: "snap-full", size: size, usage: 0.95, expanded: &expanded, sizes: sizes, origin: "origin-lv"},
+			}
+			originList := listSnapshotLVs
+			listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+			defer func() { listSnapshotLVs = originList }()
+			defer fakeVGFreeSpace(tt.free)()
+
+			d := &Discoverer{
+				Configuration:  &common.Configuration{},
+				snapclient:     fakeSnapClient,
+				snapshotUsages: map[string]snapshotUsageRecord{},
+			}
+			d.expandSnapshotLvmLVIfNeeded()
+			if !reflect.DeepEqual(expanded, tt.wantExpanded) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, tt.wantExpanded)
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSizes) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expansion sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_NodeCap(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	const mi = 1024 * 1024
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	className := "test-snapshotclass-node-cap"
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: className},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:              "true",
+			localtype.ParamSnapshotThreshold:     "70%",
+			localtype.ParamSnapshotExpansionSize: "510Mi",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"a", "b", "c"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &className,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	tests := []struct {
+		name         string
+		limit        *snapshotCapacityLimit
+		wantExpanded []string
+		wantSizes    map[string]uint64
+	}{
+		{
+			name:         "test unlimited",
+			wantExpanded: []string{"snap-a", "snap-b", "snap-c"},
+			wantSizes:    map[string]uint64{"snap-a": 510 * mi, "snap-b": 510 * mi, "snap-c": 510 * mi},
+		},
+		{
+			// 510Mi is rounded up to 128 extents, which fit in what is left exactly
+			name:         "test within node cap",
+			limit:        &snapshotCapacityLimit{limit: 3*gi + 3*512*mi, limited: true},
+			wantExpanded: []string{"snap-a", "snap-b", "snap-c"},
+			wantSizes:    map[string]uint64{"snap-a": 512 * mi, "snap-b": 512 * mi, "snap-c": 512 * mi},
+		},
+		{
+			// 1GiB left under cap is 256 extents shared by three
+			name:         "test share what is left under node cap",
+			limit:        &snapshotCapacityLimit{limit: 4 * gi, limited: true},
+			wantExpanded: []string{"snap-a", "snap-b", "snap-c"},
+			wantSizes:    map[string]uint64{"snap-a": 344 * mi, "snap-b": 340 * mi, "snap-c": 340 * mi},
+		},
+		{
+			name:         "test node cap reached",
+			limit:        &snapshotCapacityLimit{limit: 3 * gi, limited: true},
+			wantExpanded: []string{},
+			wantSizes:    map[string]uint64{},
+		},
+		{
+			name:         "test past node cap",
+			limit:        &snapshotCapacityLimit{limit: 2 * gi, limited: true},
+			wantExpanded: []string{},
+			wantSizes:    map[string]uint64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded := []string{}
+			sizes := map[string]uint64{}
+			lvs := []snapshotLV{
+				&fakeSnapshotLV{name: "snap-a", size: gi, usage: 0.95, expanded: &expanded, sizes: sizes, origin: "origin-a"},
+				&fakeSnapshotLV{name: "snap-b", size: gi, usage: 0.9, expanded: &expanded, sizes: sizes, origin: "origin-b"},
+				&fakeSnapshotLV{name: "snap-c", size: gi, usage: 0.8, expanded: &expanded, sizes: sizes, origin: "origin-c"},
+			}
+			originList := listSnapshotLVs
+			listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+			defer func() { listSnapshotLVs = originList }()
+			defer fakeVGFreeSpace(100 * gi)()
+
+			d := &Discoverer{
+				Configuration:  &common.Configuration{},
+				snapclient:     fakeSnapClient,
+				snapshotUsages: map[string]snapshotUsageRecord{},
+				snapshotLimit:  tt.limit,
+			}
+			d.expandSnapshotLvmLVIfNeeded()
+			if !reflect.DeepEqual(expanded, tt.wantExpanded) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, tt.wantExpanded)
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSizes) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expansion sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_ExpansionsPerCycle(t *testing.T) {
+	const size = 1024 * 1024 * 1024
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-snapshotclass"},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	usages := map[string]float64{"a": 0.75, "b": 0.9, "c": 0.8, "d": 0.95, "e": 0.72, "f": 0.85}
+	className := "test-snapshotclass"
+	for id := range usages {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &className,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	expanded := []string{}
+	lvs := []*fakeSnapshotLV{}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		lvs = append(lvs, &fakeSnapshotLV{name: "snap-" + id, size: size, usage: usages[id], expanded: &expanded})
+	}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) {
+		result := []snapshotLV{}
+		for _, lv := range lvs {
+			result = append(result, lv)
+		}
+		return result, nil
+	}
+	defer func() { listSnapshotLVs = originList }()
+	defer fakeVGFreeSpace(1024 * size)()
+
+	d := &Discoverer{
+		Configuration:  &common.Configuration{SnapshotExpansionsPerCycle: 2},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{},
+	}
+	// expanded snapshots drop below threshold, the deferred ones are
+	// expanded in following cycles by usage
+	wantPerCycle := [][]string{
+		{"snap-d", "snap-b"},
+		{"snap-f", "snap-c"},
+		{"snap-a", "snap-e"},
+		{},
+	}
+	for i, want := range wantPerCycle {
+		expanded = expanded[:0]
+		d.expandSnapshotLvmLVIfNeeded()
+		if !reflect.DeepEqual(expanded, want) {
+			t.Errorf("cycle %d: expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", i, expanded, want)
+		}
+		for _, lv := range lvs {
+			for _, name := range expanded {
+				if lv.name == name {
+					lv.usage = 0.5
+				}
+			}
+		}
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_ReadAhead(t *testing.T) {
+	readAhead := uint64(16)
+	tests := []struct {
+		name          string
+		params        map[string]string
+		wantReadAhead *uint64
+		wantSets      int
+	}{
+		{
+			name: "test read ahead is set",
+			params: map[string]string{
+				localtype.ParamReadonly:          "true",
+				localtype.ParamSnapshotReadAhead: "16",
+			},
+			wantReadAhead: &readAhead,
+			wantSets:      1,
+		},
+		{
+			name: "test read ahead is untouched if disabled",
+			params: map[string]string{
+				localtype.ParamReadonly: "true",
+			},
+			wantReadAhead: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+			_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-snapshotclass"},
+				Parameters: tt.params,
+			}, metav1.CreateOptions{})
+			className := "test-snapshotclass"
+			_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-a"},
+				Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+					VolumeSnapshotClassName: &className,
+				},
+			}, metav1.CreateOptions{})
+			expanded := []string{}
+			lv := &fakeSnapshotLV{name: "snap-a", size: 1024 * 1024 * 1024, usage: 0.1, expanded: &expanded}
+			originList := listSnapshotLVs
+			listSnapshotLVs = func() ([]snapshotLV, error) { return []snapshotLV{lv}, nil }
+			defer func() { listSnapshotLVs = originList }()
+
+			d := &Discoverer{
+				Configuration:  &common.Configuration{},
+				snapclient:     fakeSnapClient,
+				snapshotUsages: map[string]snapshotUsageRecord{},
+			}
+			// read ahead is checked every cycle and set only once
+			d.expandSnapshotLvmLVIfNeeded()
+			d.expandSnapshotLvmLVIfNeeded()
+			if !reflect.DeepEqual(lv.readAhead, tt.wantReadAhead) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() read ahead = %v, want %v", lv.readAhead, tt.wantReadAhead)
+			}
+			if lv.readAheadSets != tt.wantSets {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() read ahead is set %d times, want %d", lv.readAheadSets, tt.wantSets)
+			}
+			if len(expanded) != 0 {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want none", expanded)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_SnapshotClassNotFound(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	tests := []struct {
+		name  string
+		usage float64
+		// wantSize is the expansion size of snapshot lv, 0 means not expanded
+		wantSize uint64
+	}{
+		{
+			name:     "test expanded by node default policy above default threshold",
+			usage:    localtype.DefaultSnapshotThreshold + 0.1,
+			wantSize: localtype.DefaultSnapshotExpansionSize,
+		},
+		{
+			name:  "test not expanded below default threshold",
+			usage: localtype.DefaultSnapshotThreshold - 0.1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer fakeVGFreeSpace(16 * gi)()
+			// snapshot class referred by snapshot content is deleted
+			className := "deleted-snapshotclass"
+			fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+			_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-a"},
+				Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+					VolumeSnapshotClassName: &className,
+				},
+			}, metav1.CreateOptions{})
+			expanded := []string{}
+			sizes := map[string]uint64{}
+			lvs := []snapshotLV{
+				&fakeSnapshotLV{name: "snap-a", size: 4 * gi, usage: tt.usage, expanded: &expanded, sizes: sizes},
+			}
+			originList := listSnapshotLVs
+			listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+			defer func() { listSnapshotLVs = originList }()
+
+			d := &Discoverer{
+				Configuration:  &common.Configuration{},
+				snapclient:     fakeSnapClient,
+				snapshotUsages: map[string]snapshotUsageRecord{},
+			}
+			d.expandSnapshotLvmLVIfNeeded()
+			if sizes["snap-a"] != tt.wantSize {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expansion size = %d, want %d", sizes["snap-a"], tt.wantSize)
+			}
+		})
+	}
+}
+
+func Test_getSnapshotInitialInfo_VGPercent(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	defer fakeVGFreeSpace(3 * gi)()
+	origin := vgSize
+	vgSize = func(vgName string) (uint64, error) { return 100 * gi, nil }
+	defer func() { vgSize = origin }()
+
+	lv := &fakeSnapshotLV{name: "snap", size: 4 * gi, originSize: 10 * gi}
+	tests := []struct {
+		name             string
+		expansionSize    string
+		wantIncreaseSize uint64
+	}{
+		{name: "test percentage of vg", expansionSize: "+2%VG", wantIncreaseSize: 2 * gi},
+		{name: "test percentage of free", expansionSize: "+50%FREE", wantIncreaseSize: 1536 * 1024 * 1024},
+		{name: "test target percentage of vg", expansionSize: "6%VG", wantIncreaseSize: 2 * gi},
+		{name: "test clamped at free space", expansionSize: "80%VG", wantIncreaseSize: 3 * gi},
+		{name: "test lv larger than target", expansionSize: "2%VG", wantIncreaseSize: 0},
+		{name: "test invalid percentage uses default", expansionSize: "+200%VG", wantIncreaseSize: localtype.DefaultSnapshotExpansionSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, increaseSize := getSnapshotInitialInfo(map[string]string{localtype.ParamSnapshotExpansionSize: tt.expansionSize}, lv)
+			if increaseSize != tt.wantIncreaseSize {
+				t.Errorf("getSnapshotInitialInfo() increaseSize = %d, want %d", increaseSize, tt.wantIncreaseSize)
+			}
+		})
+	}
+}
+
+func Test_getSnapshotInitialInfo_LegacyKeys(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	lv := &fakeSnapshotLV{name: "snap", originSize: 100 * gi}
+	params := map[string]string{
+		localtype.ParamSnapshotInitialSize:   "20%",
+		localtype.ParamSnapshotThreshold:     "60%",
+		localtype.ParamSnapshotExpansionSize: "2Gi",
+	}
+	legacyParams := map[string]string{}
+	for key, value := range params {
+		legacyParams[strings.Replace(key, localtype.ParamKeyPrefix, localtype.LegacyParamKeyPrefix, 1)] = value
+	}
+	initialSize, threshold, increaseSize := getSnapshotInitialInfo(params, lv)
+	if initialSize != 20*gi || threshold != 0.6 || increaseSize != 2*gi {
+		t.Fatalf("getSnapshotInitialInfo() = %d, %f, %d, want %d, %f, %d", initialSize, threshold, increaseSize, 20*gi, 0.6, 2*gi)
+	}
+	legacyInitialSize, legacyThreshold, legacyIncreaseSize := getSnapshotInitialInfo(legacyParams, lv)
+	if legacyInitialSize != initialSize || legacyThreshold != threshold || legacyIncreaseSize != increaseSize {
+		t.Errorf("getSnapshotInitialInfo() of legacy keys = %d, %f, %d, want %d, %f, %d", legacyInitialSize, legacyThreshold, legacyIncreaseSize, initialSize, threshold, increaseSize)
+	}
+	if ratio := getSnapshotOriginGrowthRatio(map[string]string{"storage.oecp.io/snapshot-origin-growth-ratio": "1.5"}); ratio != 1.5 {
+		t.Errorf("getSnapshotOriginGrowthRatio() of legacy key = %f, want 1.5", ratio)
+	}
+}
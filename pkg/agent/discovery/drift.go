@@ -0,0 +1,251 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// StatusDriftReasonDiverged is reason of StatusDrift state when status diverged from lvm state
+	StatusDriftReasonDiverged = "StatusDiverged"
+	// StatusDriftReasonInSync is reason of StatusDrift state when status matches lvm state
+	StatusDriftReasonInSync = "InSync"
+
+	// maxDriftsInMessage is the number of drifts listed in message of state
+	maxDriftsInMessage = 10
+)
+
+// lvmVGs reads vgs from lvm the same way as discovery
+func (d *Discoverer) lvmVGs(reservedVGInfos map[string]ReservedVGInfo) ([]localv1alpha1.VolumeGroup, error) {
+	status := new(localv1alpha1.NodeLocalStorageStatus)
+	if err := d.discoverVGs(status, reservedVGInfos); err != nil {
+		return nil, err
+	}
+	return status.NodeStorageInfo.VolumeGroups, nil
+}
+
+// CheckStatusDrift reads lvm state again and compares it with vgs in status of
+// nls. Status diverging beyond tolerance is reported as StatusDrift and vgs
+// are re-published at once, so that a dropped or overwritten update does not
+// last until the next discovery
+func (d *Discoverer) CheckStatusDrift() {
+	end, ok := d.beginCycle(utils.OperationTypeStatusDriftCheck)
+	if !ok {
+		return
+	}
+	defer end()
+	if d.pause.get() {
+		// status is not refreshed while paused
+		return
+	}
+	d.discoverLock.Lock()
+	defer d.discoverLock.Unlock()
+	if d.statusQueue.lastUpdated().IsZero() {
+		// nothing to compare with before discovery is published
+		return
+	}
+	nls, err := d.getNodeLocalStorage()
+	if err != nil {
+		return
+	}
+	// status to be published is compared, not the one coalesced away
+	nls = d.statusQueue.overlay(nls)
+	reservedVGInfos := make(map[string]ReservedVGInfo)
+	if anno, exist := utils.LookupParam(nls.Annotations, AnnoStorageReserve); exist {
+		if reservedVGInfos, err = getReservedVGInfo(anno); err != nil {
+			log.Errorf("get reserved vg info failed: %s", err.Error())
+			return
+		}
+	}
+	vgs, err := d.readVGs(reservedVGInfos)
+	if err != nil {
+		log.Errorf("read lvm state to check status drift error: %s", err.Error())
+		return
+	}
+	drifts := d.statusDrifts(nls.Status.NodeStorageInfo.VolumeGroups, vgs)
+	d.recordStatusDrift(drifts, metav1.Now())
+	if len(drifts) == 0 {
+		log.V(4).Infof("status of nls %s matches lvm state", d.Nodename)
+		return
+	}
+	msg := d.statusDrift.State.Message
+	log.Warningf("status of nls %s diverges from lvm state, re-publishing: %s", d.Nodename, msg)
+	d.recorder.Event(nls, corev1.EventTypeWarning, localtype.EventStatusDrift, msg)
+	if err := d.republishVGs(nls, vgs); err != nil {
+		log.Errorf("re-publish status of nls %s error: %s", d.Nodename, err.Error())
+	}
+}
+
+// statusDrifts returns differences between vgs in status and vgs read from
+// lvm. Capacity within StatusDriftTolerance of vg size is not a difference,
+// nor is vg kept in status for vg-missing-grace-cycles
+func (d *Discoverer) statusDrifts(published, current []localv1alpha1.VolumeGroup) []string {
+	var drifts []string
+	publishedVGs := make(map[string]localv1alpha1.VolumeGroup, len(published))
+	for _, vg := range published {
+		publishedVGs[vg.Name] = vg
+	}
+	currentVGs := make(map[string]bool, len(current))
+	for _, vg := range current {
+		currentVGs[vg.Name] = true
+		p, ok := publishedVGs[vg.Name]
+		if !ok {
+			drifts = append(drifts, fmt.Sprintf("vg %s is missing in status", vg.Name))
+			continue
+		}
+		tolerance := uint64(float64(vg.Total) * d.StatusDriftTolerance)
+		for _, c := range []struct {
+			name               string
+			published, current uint64
+		}{
+			{"total", p.Total, vg.Total},
+			{"available", p.Available, vg.Available},
+			{"allocatable", p.Allocatable, vg.Allocatable},
+		} {
+			if exceedsTolerance(c.published, c.current, tolerance) {
+				drifts = append(drifts, fmt.Sprintf("%s of vg %s is %d in status, %d in lvm", c.name, vg.Name, c.published, c.current))
+			}
+		}
+		publishedLVs := make(map[string]uint64, len(p.LogicalVolumes))
+		for _, lv := range p.LogicalVolumes {
+			publishedLVs[lv.Name] = lv.Total
+		}
+		currentLVs := make(map[string]bool, len(vg.LogicalVolumes))
+		for _, lv := range vg.LogicalVolumes {
+			currentLVs[lv.Name] = true
+			total, ok := publishedLVs[lv.Name]
+			if !ok {
+				drifts = append(drifts, fmt.Sprintf("lv %s/%s is missing in status", vg.Name, lv.Name))
+				continue
+			}
+			if exceedsTolerance(total, lv.Total, tolerance) {
+				drifts = append(drifts, fmt.Sprintf("size of lv %s/%s is %d in status, %d in lvm", vg.Name, lv.Name, total, lv.Total))
+			}
+		}
+		for _, lv := range p.LogicalVolumes {
+			if !currentLVs[lv.Name] {
+				drifts = append(drifts, fmt.Sprintf("lv %s/%s in status is absent in lvm", vg.Name, lv.Name))
+			}
+		}
+	}
+	for _, vg := range published {
+		if !currentVGs[vg.Name] && d.vgMissingCycles[vg.Name] == 0 {
+			drifts = append(drifts, fmt.Sprintf("vg %s in status is absent in lvm", vg.Name))
+		}
+	}
+	return drifts
+}
+
+func exceedsTolerance(a, b, tolerance uint64) bool {
+	if a > b {
+		return a-b > tolerance
+	}
+	return b-a > tolerance
+}
+
+// recordStatusDrift updates StatusDrift state reported in status by the result of check
+func (d *Discoverer) recordStatusDrift(drifts []string, now metav1.Time) {
+	state := localv1alpha1.StorageState{
+		Type:              localv1alpha1.StorageStatusDrift,
+		Status:            localv1alpha1.ConditionFalse,
+		Reason:            StatusDriftReasonInSync,
+		LastHeartbeatTime: &now,
+	}
+	if len(drifts) > 0 {
+		state.Status = localv1alpha1.ConditionTrue
+		state.Reason = StatusDriftReasonDiverged
+		listed := drifts
+		if len(listed) > maxDriftsInMessage {
+			listed = listed[:maxDriftsInMessage]
+		}
+		state.Message = strings.Join(listed, "; ")
+		if len(drifts) > len(listed) {
+			state.Message += fmt.Sprintf("; and %d more", len(drifts)-len(listed))
+		}
+	}
+	var count int64
+	state.LastTransitionTime = &now
+	if d.statusDrift != nil {
+		count = d.statusDrift.DriftCount
+		if d.statusDrift.State.Status == state.Status {
+			state.LastTransitionTime = d.statusDrift.State.LastTransitionTime
+		}
+	}
+	if len(drifts) > 0 {
+		count++
+	}
+	d.statusDrift = &localv1alpha1.StatusDriftStatus{State: state, DriftCount: count}
+}
+
+// republishVGs updates status of nls with vgs read from lvm, the rest of status
+// is left as published by the last discovery
+func (d *Discoverer) republishVGs(nls *localv1alpha1.NodeLocalStorage, vgs []localv1alpha1.VolumeGroup) error {
+	nlsCopy := nls.DeepCopy()
+	status := new(localv1alpha1.NodeLocalStorageStatus)
+	status.NodeStorageInfo.VolumeGroups = vgs
+	if err := d.setLVOwners(status); err != nil {
+		log.Warningf("set owners of logical volumes error: %s", err.Error())
+	}
+	// io statistics are rates of the last discovery, which is kept
+	ioStats := make(map[string]*localv1alpha1.VolumeGroupIOStats)
+	for _, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+		ioStats[vg.Name] = vg.IOStats
+	}
+	for i := range status.NodeStorageInfo.VolumeGroups {
+		status.NodeStorageInfo.VolumeGroups[i].IOStats = ioStats[status.NodeStorageInfo.VolumeGroups[i].Name]
+	}
+	d.setLVIdleSince(status)
+	d.setSnapshotAwareAvailable(status)
+	d.setSnapshotUsageTrends(status)
+	// vgs kept in status for vg-missing-grace-cycles are still kept
+	present := make(map[string]bool, len(vgs))
+	for _, vg := range vgs {
+		present[vg.Name] = true
+	}
+	retained := false
+	for _, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+		if !present[vg.Name] && d.vgMissingCycles[vg.Name] > 0 {
+			status.NodeStorageInfo.VolumeGroups = append(status.NodeStorageInfo.VolumeGroups, vg)
+			retained = true
+		}
+	}
+	if retained {
+		sort.SliceStable(status.NodeStorageInfo.VolumeGroups, func(i, j int) bool {
+			return status.NodeStorageInfo.VolumeGroups[i].Name < status.NodeStorageInfo.VolumeGroups[j].Name
+		})
+	}
+	d.setSnapshotCapacity(status, nls.Spec.ListConfig.VGs.MaxSnapshotSize)
+	nlsCopy.Status.NodeStorageInfo.VolumeGroups = status.NodeStorageInfo.VolumeGroups
+	nlsCopy.Status.NodeStorageInfo.SnapshotCapacity = status.NodeStorageInfo.SnapshotCapacity
+	nlsCopy.Status.NodeStorageInfo.StatusDrift = d.statusDrift.DeepCopy()
+	SetVGMaintenance(nlsCopy)
+	nlsCopy.Status.FilteredStorageInfo.VolumeGroups = FilterVGInfo(nlsCopy)
+	lastUpdateTime := metav1.Now()
+	nlsCopy.Status.FilteredStorageInfo.UpdateStatus.LastUpdateTime = &lastUpdateTime
+	// correction of drift is never coalesced
+	return d.statusQueue.enqueue(nlsCopy, true)
+}
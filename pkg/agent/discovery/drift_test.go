@@ -0,0 +1,167 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func driftTestVG(available uint64, lvs ...localv1alpha1.LogicalVolume) localv1alpha1.VolumeGroup {
+	return localv1alpha1.VolumeGroup{
+		Name:           "vg1",
+		Total:          1000,
+		Available:      available,
+		Allocatable:    1000,
+		Condition:      localv1alpha1.StorageReady,
+		LogicalVolumes: lvs,
+	}
+}
+
+func Test_statusDrifts(t *testing.T) {
+	lv1 := localv1alpha1.LogicalVolume{Name: "lv1", VGName: "vg1", Total: 100}
+	lv2 := localv1alpha1.LogicalVolume{Name: "lv2", VGName: "vg1", Total: 200}
+	vg2 := localv1alpha1.VolumeGroup{Name: "vg2", Total: 500, Available: 500, Allocatable: 500}
+	tests := []struct {
+		name       string
+		published  []localv1alpha1.VolumeGroup
+		current    []localv1alpha1.VolumeGroup
+		retained   map[string]int
+		wantDrifts []string
+	}{
+		{
+			name:      "test in sync",
+			published: []localv1alpha1.VolumeGroup{driftTestVG(900, lv1), vg2},
+			current:   []localv1alpha1.VolumeGroup{driftTestVG(900, lv1), vg2},
+		},
+		{
+			name:      "test capacity within tolerance",
+			published: []localv1alpha1.VolumeGroup{driftTestVG(900, lv1)},
+			current:   []localv1alpha1.VolumeGroup{driftTestVG(895, lv1)},
+		},
+		{
+			name:       "test capacity beyond tolerance",
+			published:  []localv1alpha1.VolumeGroup{driftTestVG(900, lv1)},
+			current:    []localv1alpha1.VolumeGroup{driftTestVG(880, lv1)},
+			wantDrifts: []string{"available of vg vg1 is 900 in status, 880 in lvm"},
+		},
+		{
+			name:      "test lv missing and absent",
+			published: []localv1alpha1.VolumeGroup{driftTestVG(900, lv1)},
+			current:   []localv1alpha1.VolumeGroup{driftTestVG(900, lv2)},
+			wantDrifts: []string{
+				"lv vg1/lv2 is missing in status",
+				"lv vg1/lv1 in status is absent in lvm",
+			},
+		},
+		{
+			name:      "test vg missing and absent",
+			published: []localv1alpha1.VolumeGroup{driftTestVG(900)},
+			current:   []localv1alpha1.VolumeGroup{vg2},
+			wantDrifts: []string{
+				"vg vg2 is missing in status",
+				"vg vg1 in status is absent in lvm",
+			},
+		},
+		{
+			name:      "test vg retained in grace cycles",
+			published: []localv1alpha1.VolumeGroup{driftTestVG(900), vg2},
+			current:   []localv1alpha1.VolumeGroup{vg2},
+			retained:  map[string]int{"vg1": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{
+				Configuration:   &common.Configuration{StatusDriftTolerance: 0.01},
+				vgMissingCycles: tt.retained,
+			}
+			if got := d.statusDrifts(tt.published, tt.current); !reflect.DeepEqual(got, tt.wantDrifts) {
+				t.Errorf("statusDrifts() = %q, want %q", got, tt.wantDrifts)
+			}
+		})
+	}
+}
+
+func TestDiscoverer_CheckStatusDrift(t *testing.T) {
+	lv1 := localv1alpha1.LogicalVolume{Name: "lv1", VGName: "vg1", Total: 100, Condition: localv1alpha1.StorageReady}
+	lv2 := localv1alpha1.LogicalVolume{Name: "lv2", VGName: "vg1", Total: 200, Condition: localv1alpha1.StorageReady}
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	nls.Status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{driftTestVG(900, lv1)}
+	nls.Status.FilteredStorageInfo.VolumeGroups = []string{"vg1"}
+	localclient := localfake.NewSimpleClientset(nls)
+
+	// lv2 is created but the update of status is dropped
+	lvmVGs := []localv1alpha1.VolumeGroup{driftTestVG(700, lv1, lv2)}
+	d := NewDiscoverer(&common.Configuration{Nodename: "test-node", StatusDriftTolerance: 0.01},
+		k8sfake.NewSimpleClientset(), localclient, nil, record.NewFakeRecorder(10))
+	d.readVGs = func(map[string]ReservedVGInfo) ([]localv1alpha1.VolumeGroup, error) {
+		return lvmVGs, nil
+	}
+	getStatus := func() localv1alpha1.NodeStorageInfo {
+		got, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get nls: %s", err.Error())
+		}
+		return got.Status.NodeStorageInfo
+	}
+
+	// nothing is checked before status is published
+	d.CheckStatusDrift()
+	if d.statusDrift != nil {
+		t.Fatalf("status drift is checked before status is published: %+v", d.statusDrift)
+	}
+
+	d.statusQueue.lastUpdate = time.Now()
+	d.CheckStatusDrift()
+	status := getStatus()
+	if !reflect.DeepEqual(status.VolumeGroups, lvmVGs) {
+		t.Errorf("re-published vgs = %+v, want %+v", status.VolumeGroups, lvmVGs)
+	}
+	drift := status.StatusDrift
+	if drift == nil {
+		t.Fatalf("status drift is not published")
+	}
+	if drift.State.Type != localv1alpha1.StorageStatusDrift || drift.State.Status != localv1alpha1.ConditionTrue ||
+		drift.State.Reason != StatusDriftReasonDiverged || drift.DriftCount != 1 {
+		t.Errorf("status drift = %+v, want type %s, status %s, reason %s and count 1",
+			*drift, localv1alpha1.StorageStatusDrift, localv1alpha1.ConditionTrue, StatusDriftReasonDiverged)
+	}
+	wantMessage := "available of vg vg1 is 900 in status, 700 in lvm; lv vg1/lv2 is missing in status"
+	if drift.State.Message != wantMessage {
+		t.Errorf("message of status drift = %q, want %q", drift.State.Message, wantMessage)
+	}
+
+	// corrected status is in sync with lvm
+	d.CheckStatusDrift()
+	if d.statusDrift.State.Status != localv1alpha1.ConditionFalse || d.statusDrift.State.Reason != StatusDriftReasonInSync || d.statusDrift.DriftCount != 1 {
+		t.Errorf("status drift after correction = %+v, want status %s, reason %s and count 1",
+			*d.statusDrift, localv1alpha1.ConditionFalse, StatusDriftReasonInSync)
+	}
+	if !d.statusDrift.State.LastTransitionTime.After(drift.State.LastTransitionTime.Time) {
+		t.Errorf("last transition time is not updated after correction")
+	}
+}
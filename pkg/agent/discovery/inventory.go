@@ -0,0 +1,213 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	log "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// InventorySchemaVersion is the version of inventory file, fields are only
+// added within a version
+const InventorySchemaVersion = "v1"
+
+// Inventory is the latest discovery of node exported to file for offline
+// inventory, sizes are in bytes
+type Inventory struct {
+	SchemaVersion string `json:"schemaVersion"`
+	NodeName      string `json:"nodeName"`
+	// DiscoveredAt is the time of discovery in RFC3339
+	DiscoveredAt time.Time              `json:"discoveredAt"`
+	VolumeGroups []InventoryVolumeGroup `json:"volumeGroups"`
+	Snapshots    []InventorySnapshot    `json:"snapshots"`
+	Devices      []InventoryDevice      `json:"devices"`
+	MountPoints  []InventoryMountPoint  `json:"mountPoints"`
+}
+
+// InventoryVolumeGroup is a vg with its lvs except snapshots
+type InventoryVolumeGroup struct {
+	Name            string                   `json:"name"`
+	PhysicalVolumes []string                 `json:"physicalVolumes"`
+	Total           uint64                   `json:"total"`
+	Available       uint64                   `json:"available"`
+	Allocatable     uint64                   `json:"allocatable"`
+	Condition       string                   `json:"condition"`
+	LogicalVolumes  []InventoryLogicalVolume `json:"logicalVolumes"`
+}
+
+// InventoryLogicalVolume is a lv, PVName is empty if lv backs no pv
+type InventoryLogicalVolume struct {
+	Name      string `json:"name"`
+	Total     uint64 `json:"total"`
+	Condition string `json:"condition"`
+	PVName    string `json:"pvName"`
+}
+
+// InventorySnapshot is a snapshot lv of Origin in vg VGName
+type InventorySnapshot struct {
+	Name   string `json:"name"`
+	VGName string `json:"vgName"`
+	Origin string `json:"origin"`
+	Total  uint64 `json:"total"`
+}
+
+// InventoryDevice is a block device
+type InventoryDevice struct {
+	Name      string `json:"name"`
+	MediaType string `json:"mediaType"`
+	Total     uint64 `json:"total"`
+	ReadOnly  bool   `json:"readOnly"`
+	Condition string `json:"condition"`
+}
+
+// InventoryMountPoint is a mount point
+type InventoryMountPoint struct {
+	Name      string `json:"name"`
+	Device    string `json:"device"`
+	FsType    string `json:"fsType"`
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+	ReadOnly  bool   `json:"readOnly"`
+	Condition string `json:"condition"`
+}
+
+// NewInventory returns inventory of discovered storage info, lists are never
+// nil so that empty ones are written as []
+func NewInventory(nodeName string, info localv1alpha1.NodeStorageInfo, discoveredAt time.Time) *Inventory {
+	inventory := &Inventory{
+		SchemaVersion: InventorySchemaVersion,
+		NodeName:      nodeName,
+		DiscoveredAt:  discoveredAt.UTC().Truncate(time.Second),
+		VolumeGroups:  []InventoryVolumeGroup{},
+		Snapshots:     []InventorySnapshot{},
+		Devices:       []InventoryDevice{},
+		MountPoints:   []InventoryMountPoint{},
+	}
+	for _, vg := range info.VolumeGroups {
+		inventoryVG := InventoryVolumeGroup{
+			Name:            vg.Name,
+			PhysicalVolumes: append([]string{}, vg.PhysicalVolumes...),
+			Total:           vg.Total,
+			Available:       vg.Available,
+			Allocatable:     vg.Allocatable,
+			Condition:       string(vg.Condition),
+			LogicalVolumes:  []InventoryLogicalVolume{},
+		}
+		for _, lv := range vg.LogicalVolumes {
+			if lv.Origin != "" {
+				inventory.Snapshots = append(inventory.Snapshots, InventorySnapshot{
+					Name:   lv.Name,
+					VGName: vg.Name,
+					Origin: lv.Origin,
+					Total:  lv.Total,
+				})
+				continue
+			}
+			inventoryVG.LogicalVolumes = append(inventoryVG.LogicalVolumes, InventoryLogicalVolume{
+				Name:      lv.Name,
+				Total:     lv.Total,
+				Condition: string(lv.Condition),
+				PVName:    lv.PVName,
+			})
+		}
+		inventory.VolumeGroups = append(inventory.VolumeGroups, inventoryVG)
+	}
+	for _, device := range info.DeviceInfos {
+		inventory.Devices = append(inventory.Devices, InventoryDevice{
+			Name:      device.Name,
+			MediaType: device.MediaType,
+			Total:     device.Total,
+			ReadOnly:  device.ReadOnly,
+			Condition: string(device.Condition),
+		})
+	}
+	for _, mp := range info.MountPoints {
+		inventory.MountPoints = append(inventory.MountPoints, InventoryMountPoint{
+			Name:      mp.Name,
+			Device:    mp.Device,
+			FsType:    mp.FsType,
+			Total:     mp.Total,
+			Available: mp.Available,
+			ReadOnly:  mp.ReadOnly,
+			Condition: string(mp.Condition),
+		})
+	}
+	return inventory
+}
+
+// exportInventory writes discovery of nls to InventoryFile at most once per
+// InventoryInterval, failure is only logged
+func (d *Discoverer) exportInventory(nls *localv1alpha1.NodeLocalStorage, now time.Time) {
+	if d.InventoryFile == "" {
+		return
+	}
+	if !d.inventoryWrittenAt.IsZero() && now.Sub(d.inventoryWrittenAt) < time.Duration(d.InventoryInterval)*time.Second {
+		return
+	}
+	inventory := NewInventory(d.Nodename, nls.Status.NodeStorageInfo, now)
+	if err := writeInventory(d.InventoryFile, d.InventoryFormat, inventory); err != nil {
+		log.Errorf("write inventory file %s error: %s", d.InventoryFile, err.Error())
+		return
+	}
+	d.inventoryWrittenAt = now
+	log.V(4).Infof("inventory of node %s is written to %s", d.Nodename, d.InventoryFile)
+}
+
+// writeInventory replaces file by rename, so that a reader never sees a
+// partly written one
+func writeInventory(path, format string, inventory *Inventory) error {
+	var content []byte
+	var err error
+	switch format {
+	case "", common.InventoryFormatJSON:
+		content, err = json.MarshalIndent(inventory, "", "  ")
+	case common.InventoryFormatYAML:
+		content, err = yaml.Marshal(inventory)
+	default:
+		return fmt.Errorf("unsupported inventory format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+func inventoryTestNLS() *localv1alpha1.NodeLocalStorage {
+	nls := &localv1alpha1.NodeLocalStorage{}
+	nls.Status.NodeStorageInfo = localv1alpha1.NodeStorageInfo{
+		VolumeGroups: []localv1alpha1.VolumeGroup{
+			{
+				Name:            "vg1",
+				PhysicalVolumes: []string{"/dev/sdb"},
+				Total:           100,
+				Available:       40,
+				Allocatable:     90,
+				Condition:       localv1alpha1.StorageReady,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-pv-1", VGName: "vg1", Total: 50, Condition: localv1alpha1.StorageReady, PVName: "pv-1"},
+					{Name: "snap-1", VGName: "vg1", Total: 10, Condition: localv1alpha1.StorageReady, Origin: "local-pv-1"},
+				},
+			},
+			{
+				Name:            "vg2",
+				PhysicalVolumes: []string{"/dev/sdc"},
+				Total:           200,
+				Available:       200,
+				Allocatable:     200,
+				Condition:       localv1alpha1.StorageReady,
+			},
+		},
+		DeviceInfos: []localv1alpha1.DeviceInfo{
+			{Name: "/dev/sdd", MediaType: "ssd", Total: 300, Condition: localv1alpha1.StorageReady},
+		},
+	}
+	return nls
+}
+
+func TestDiscoverer_exportInventory(t *testing.T) {
+	discoveredAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := &Inventory{
+		SchemaVersion: InventorySchemaVersion,
+		NodeName:      "test-node",
+		DiscoveredAt:  discoveredAt,
+		VolumeGroups: []InventoryVolumeGroup{
+			{
+				Name:            "vg1",
+				PhysicalVolumes: []string{"/dev/sdb"},
+				Total:           100,
+				Available:       40,
+				Allocatable:     90,
+				Condition:       string(localv1alpha1.StorageReady),
+				LogicalVolumes: []InventoryLogicalVolume{
+					{Name: "local-pv-1", Total: 50, Condition: string(localv1alpha1.StorageReady), PVName: "pv-1"},
+				},
+			},
+			{
+				Name:            "vg2",
+				PhysicalVolumes: []string{"/dev/sdc"},
+				Total:           200,
+				Available:       200,
+				Allocatable:     200,
+				Condition:       string(localv1alpha1.StorageReady),
+				LogicalVolumes:  []InventoryLogicalVolume{},
+			},
+		},
+		Snapshots: []InventorySnapshot{
+			{Name: "snap-1", VGName: "vg1", Origin: "local-pv-1", Total: 10},
+		},
+		Devices: []InventoryDevice{
+			{Name: "/dev/sdd", MediaType: "ssd", Total: 300, Condition: string(localv1alpha1.StorageReady)},
+		},
+		MountPoints: []InventoryMountPoint{},
+	}
+	tests := []struct {
+		name      string
+		format    string
+		unmarshal func([]byte, interface{}) error
+	}{
+		{name: "test json", format: common.InventoryFormatJSON, unmarshal: json.Unmarshal},
+		{name: "test yaml", format: common.InventoryFormatYAML, unmarshal: func(data []byte, v interface{}) error { return yaml.Unmarshal(data, v) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "inventory", "node."+tt.format)
+			d := &Discoverer{Configuration: &common.Configuration{
+				Nodename:        "test-node",
+				InventoryFile:   path,
+				InventoryFormat: tt.format,
+			}}
+			d.exportInventory(inventoryTestNLS(), discoveredAt)
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read inventory file error: %v", err)
+			}
+			got := &Inventory{}
+			if err := tt.unmarshal(content, got); err != nil {
+				t.Fatalf("unmarshal inventory error: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("exportInventory() = %+v, want %+v", got, want)
+			}
+
+			// keys are part of the schema
+			raw := map[string]interface{}{}
+			if err := tt.unmarshal(content, &raw); err != nil {
+				t.Fatalf("unmarshal inventory error: %v", err)
+			}
+			var keys []string
+			for key := range raw {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			wantKeys := []string{"devices", "discoveredAt", "mountPoints", "nodeName", "schemaVersion", "snapshots", "volumeGroups"}
+			if !reflect.DeepEqual(keys, wantKeys) {
+				t.Errorf("keys of inventory = %v, want %v", keys, wantKeys)
+			}
+			lv := raw["volumeGroups"].([]interface{})[0].(map[string]interface{})["logicalVolumes"].([]interface{})[0].(map[string]interface{})
+			for _, key := range []string{"name", "total", "condition", "pvName"} {
+				if _, ok := lv[key]; !ok {
+					t.Errorf("key %s of logical volume is missing", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverer_exportInventory_Interval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	d := &Discoverer{Configuration: &common.Configuration{
+		Nodename:          "test-node",
+		InventoryFile:     path,
+		InventoryFormat:   common.InventoryFormatJSON,
+		InventoryInterval: 300,
+	}}
+	start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{now: start, want: start},
+		{now: start.Add(time.Minute), want: start},
+		{now: start.Add(5 * time.Minute), want: start.Add(5 * time.Minute)},
+	} {
+		d.exportInventory(inventoryTestNLS(), tt.now)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read inventory file error: %v", err)
+		}
+		got := &Inventory{}
+		if err := json.Unmarshal(content, got); err != nil {
+			t.Fatalf("unmarshal inventory error: %v", err)
+		}
+		if !got.DiscoveredAt.Equal(tt.want) {
+			t.Errorf("inventory at %v is discovered at %v, want %v", tt.now, got.DiscoveredAt, tt.want)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("temporary inventory file is left: %v", entries)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+)
+
+// diskStatsRecord is io statistics of block devices read at time
+type diskStatsRecord struct {
+	time  time.Time
+	stats map[string]deviceutil.DiskStats
+}
+
+var (
+	// replaced in unit test
+	kernelDeviceName = resolveKernelDeviceName
+)
+
+// setVGIOStats sums io statistics of physical volumes of every vg in status
+// if enabled. Rates are computed against the last discovery, so they are
+// absent in the first discovery after agent starts
+func (d *Discoverer) setVGIOStats(status *localv1alpha1.NodeLocalStorageStatus) error {
+	if !d.DiskIOStats {
+		return nil
+	}
+	stats, err := d.readDiskStats()
+	if err != nil {
+		return fmt.Errorf("read diskstats error: %s", err.Error())
+	}
+	now := timeNow()
+	prev := d.diskStats
+	d.diskStats = &diskStatsRecord{time: now, stats: stats}
+
+	vgs := status.NodeStorageInfo.VolumeGroups
+	for i := range vgs {
+		ioStats := &localv1alpha1.VolumeGroupIOStats{}
+		var rate deviceutil.DiskRate
+		found := false
+		for _, pv := range vgs[i].PhysicalVolumes {
+			name := kernelDeviceName(pv)
+			s, exist := stats[name]
+			if !exist {
+				continue
+			}
+			found = true
+			ioStats.ReadsCompleted += s.ReadsCompleted
+			ioStats.WritesCompleted += s.WritesCompleted
+			ioStats.ReadBytes += s.ReadBytes()
+			ioStats.WriteBytes += s.WriteBytes()
+			ioStats.IOTimeMilliseconds += s.IOTimeMs
+			if prev == nil {
+				continue
+			}
+			prevStats, exist := prev.stats[name]
+			if !exist {
+				continue
+			}
+			r, ok := s.Rate(prevStats, now.Sub(prev.time).Seconds())
+			if !ok {
+				continue
+			}
+			rate.ReadIOPS += r.ReadIOPS
+			rate.WriteIOPS += r.WriteIOPS
+			rate.ReadBytesPerSecond += r.ReadBytesPerSecond
+			rate.WriteBytesPerSecond += r.WriteBytesPerSecond
+			rate.Utilization = math.Max(rate.Utilization, r.Utilization)
+		}
+		if !found {
+			continue
+		}
+		ioStats.ReadIOPS = uint64(math.Round(rate.ReadIOPS))
+		ioStats.WriteIOPS = uint64(math.Round(rate.WriteIOPS))
+		ioStats.ReadBytesPerSecond = uint64(math.Round(rate.ReadBytesPerSecond))
+		ioStats.WriteBytesPerSecond = uint64(math.Round(rate.WriteBytesPerSecond))
+		ioStats.UtilizationPercent = int(math.Round(rate.Utilization * 100))
+		vgs[i].IOStats = ioStats
+	}
+	return nil
+}
+
+// resolveKernelDeviceName returns name of device in diskstats, such as dm-0
+// of /dev/mapper/xxx
+func resolveKernelDeviceName(dev string) string {
+	if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = resolved
+	}
+	return filepath.Base(dev)
+}
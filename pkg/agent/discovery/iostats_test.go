@@ -0,0 +1,93 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+)
+
+func TestDiscoverer_setVGIOStats(t *testing.T) {
+	snapshots := []map[string]deviceutil.DiskStats{
+		{
+			"sdb": {ReadsCompleted: 100, SectorsRead: 200, WritesCompleted: 1000, SectorsWritten: 2000, IOTimeMs: 1000},
+			"sdc": {ReadsCompleted: 10, SectorsRead: 20, WritesCompleted: 100, SectorsWritten: 200, IOTimeMs: 500},
+		},
+		{
+			"sdb": {ReadsCompleted: 700, SectorsRead: 1400, WritesCompleted: 7000, SectorsWritten: 14000, IOTimeMs: 31000},
+			"sdc": {ReadsCompleted: 70, SectorsRead: 140, WritesCompleted: 700, SectorsWritten: 1400, IOTimeMs: 6500},
+		},
+	}
+	cycle := 0
+	d := &Discoverer{
+		Configuration: &common.Configuration{DiskIOStats: true},
+		readDiskStats: func() (map[string]deviceutil.DiskStats, error) {
+			return snapshots[cycle], nil
+		},
+	}
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	originNow, originName := timeNow, kernelDeviceName
+	timeNow = func() time.Time { return start.Add(time.Duration(cycle) * time.Minute) }
+	kernelDeviceName = filepath.Base
+	defer func() { timeNow, kernelDeviceName = originNow, originName }()
+
+	newStatus := func() *localv1alpha1.NodeLocalStorageStatus {
+		status := &localv1alpha1.NodeLocalStorageStatus{}
+		status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{
+			{Name: "share", PhysicalVolumes: []string{"/dev/sdb", "/dev/sdc"}},
+			{Name: "unknown", PhysicalVolumes: []string{"/dev/sdz"}},
+		}
+		return status
+	}
+	want := []*localv1alpha1.VolumeGroupIOStats{
+		// no rate in the first discovery
+		{ReadsCompleted: 110, WritesCompleted: 1100, ReadBytes: 112640, WriteBytes: 1126400, IOTimeMilliseconds: 1500},
+		// 660 reads and 6600 writes in 60s, sdb is busy for 30s
+		{
+			ReadsCompleted: 770, WritesCompleted: 7700, ReadBytes: 788480, WriteBytes: 7884800, IOTimeMilliseconds: 37500,
+			ReadIOPS: 11, WriteIOPS: 110, ReadBytesPerSecond: 11264, WriteBytesPerSecond: 112640, UtilizationPercent: 50,
+		},
+	}
+	for cycle = range snapshots {
+		status := newStatus()
+		if err := d.setVGIOStats(status); err != nil {
+			t.Fatalf("cycle %d: setVGIOStats() error = %v", cycle, err)
+		}
+		vgs := status.NodeStorageInfo.VolumeGroups
+		if !reflect.DeepEqual(vgs[0].IOStats, want[cycle]) {
+			t.Errorf("cycle %d: IOStats = %+v, want %+v", cycle, vgs[0].IOStats, want[cycle])
+		}
+		if vgs[1].IOStats != nil {
+			t.Errorf("cycle %d: IOStats of vg without diskstats = %+v, want nil", cycle, vgs[1].IOStats)
+		}
+	}
+
+	d.DiskIOStats = false
+	status := newStatus()
+	if err := d.setVGIOStats(status); err != nil {
+		t.Fatalf("setVGIOStats() error = %v", err)
+	}
+	if status.NodeStorageInfo.VolumeGroups[0].IOStats != nil {
+		t.Errorf("IOStats = %+v when disabled, want nil", status.NodeStorageInfo.VolumeGroups[0].IOStats)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	log "k8s.io/klog/v2"
+)
+
+// vgDevices returns devices to initialize vg from, loop devices over its
+// loopFiles are attached and appended if loop devices are enabled
+func (d *Discoverer) vgDevices(vg localv1alpha1.VGToBeInited) ([]string, error) {
+	if len(vg.LoopFiles) == 0 {
+		return vg.Devices, nil
+	}
+	if !d.LoopDevices {
+		log.Warningf("loopFiles of vg %s are ignored since loop devices are disabled in agent", vg.Name)
+		return vg.Devices, nil
+	}
+	devices := append([]string{}, vg.Devices...)
+	for _, file := range vg.LoopFiles {
+		size, err := deviceutil.ParseLoopFile(file.Path, file.Size)
+		if err != nil {
+			return nil, err
+		}
+		dev, err := d.attachLoopFile(file.Path, size)
+		if err != nil {
+			return nil, fmt.Errorf("attach loop device over %s error: %s", file.Path, err.Error())
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// hasLoopDevice returns true if any of pvs is a loop device
+func hasLoopDevice(pvs []string) bool {
+	for _, pv := range pvs {
+		if deviceutil.IsLoopDevice(pv) {
+			return true
+		}
+	}
+	return false
+}
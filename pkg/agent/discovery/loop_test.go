@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func TestDiscoverer_vgDevices(t *testing.T) {
+	vg := localv1alpha1.VGToBeInited{
+		Name:    "open-local-pool-loop",
+		Devices: []string{"/dev/vdb"},
+		LoopFiles: []localv1alpha1.LoopFile{
+			{Path: "/var/lib/open-local/pv0.img", Size: "1Gi"},
+			{Path: "/var/lib/open-local/pv1.img", Size: "2Gi"},
+		},
+	}
+	tests := []struct {
+		name        string
+		vg          localv1alpha1.VGToBeInited
+		loopDevices bool
+		attachErr   error
+		wantDevices []string
+		wantSizes   []uint64
+		wantErr     bool
+	}{
+		{
+			name:        "test no loop files",
+			vg:          localv1alpha1.VGToBeInited{Name: "open-local-pool-0", Devices: []string{"/dev/vdb"}},
+			loopDevices: true,
+			wantDevices: []string{"/dev/vdb"},
+		},
+		{
+			name:        "test loop devices disabled",
+			vg:          vg,
+			loopDevices: false,
+			wantDevices: []string{"/dev/vdb"},
+		},
+		{
+			name:        "test loop devices appended",
+			vg:          vg,
+			loopDevices: true,
+			wantDevices: []string{"/dev/vdb", "/dev/loop0", "/dev/loop1"},
+			wantSizes:   []uint64{1 << 30, 2 << 30},
+		},
+		{
+			name: "test invalid loop file",
+			vg: localv1alpha1.VGToBeInited{
+				Name:      "open-local-pool-loop",
+				LoopFiles: []localv1alpha1.LoopFile{{Path: "pv0.img", Size: "1Gi"}},
+			},
+			loopDevices: true,
+			wantErr:     true,
+		},
+		{
+			name:        "test attach failed",
+			vg:          vg,
+			loopDevices: true,
+			attachErr:   errors.New("losetup: cannot find an unused loop device"),
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes []uint64
+			d := &Discoverer{
+				Configuration: &common.Configuration{LoopDevices: tt.loopDevices},
+				attachLoopFile: func(path string, size uint64) (string, error) {
+					if tt.attachErr != nil {
+						return "", tt.attachErr
+					}
+					sizes = append(sizes, size)
+					if path == "/var/lib/open-local/pv0.img" {
+						return "/dev/loop0", nil
+					}
+					return "/dev/loop1", nil
+				},
+			}
+			devices, err := d.vgDevices(tt.vg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("vgDevices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(devices, tt.wantDevices) {
+				t.Errorf("vgDevices() = %v, want %v", devices, tt.wantDevices)
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSizes) {
+				t.Errorf("sizes of backing files = %v, want %v", sizes, tt.wantSizes)
+			}
+		})
+	}
+	if !reflect.DeepEqual(vg.Devices, []string{"/dev/vdb"}) {
+		t.Errorf("devices of spec are modified: %v", vg.Devices)
+	}
+}
+
+func Test_hasLoopDevice(t *testing.T) {
+	if hasLoopDevice([]string{"/dev/vdb", "/dev/vdc"}) {
+		t.Errorf("hasLoopDevice() is true without loop device")
+	}
+	if !hasLoopDevice([]string{"/dev/vdb", "/dev/loop3"}) {
+		t.Errorf("hasLoopDevice() is false with loop device")
+	}
+}
@@ -19,9 +19,11 @@ package discovery
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	localtype "github.com/alibaba/open-local/pkg"
 	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
 	"github.com/alibaba/open-local/pkg/utils/lvm"
 	log "k8s.io/klog/v2"
 )
@@ -95,9 +97,22 @@ func (d *Discoverer) discoverLvmVGs(newStatus *localv1alpha1.NodeLocalStorageSta
 			log.Errorf("List physical volume %s error: %s", vgname, err.Error())
 			continue
 		}
+		vgCrd.LoopBacked = hasLoopDevice(vgCrd.PhysicalVolumes)
 		// total & available
 		vgCrd.Total, _ = vg.BytesTotal()
 		vgCrd.Available, _ = vg.BytesFree()
+		vgCrd.LargestFreeExtentRun, _ = vg.LargestFreeExtentRun()
+		if allocation, err := vg.Allocation(); err != nil {
+			log.Errorf("get allocation of volume group %s error: %s", vgname, err.Error())
+		} else {
+			setVGAllocation(&vgCrd, allocation)
+		}
+		// per pv space is only informational, missing it changes nothing
+		if spaces, err := vg.PhysicalVolumeSpaces(); err != nil {
+			log.Errorf("get physical volume spaces of volume group %s error: %s", vgname, err.Error())
+		} else {
+			setPVSpaces(&vgCrd, spaces)
+		}
 		if vgCrd.Available == 0 {
 			vgCrd.Condition = localv1alpha1.StorageFull
 		}
@@ -109,6 +124,11 @@ func (d *Discoverer) discoverLvmVGs(newStatus *localv1alpha1.NodeLocalStorageSta
 			continue
 		}
 		vgCrd.Allocatable = vgCrd.Total
+		// snapshot chains are only informational, missing them changes nothing
+		relations, err := vg.SnapshotRelations()
+		if err != nil {
+			log.Errorf("get snapshot relations of volume group %s error: %s", vgname, err.Error())
+		}
 		for _, lvname := range logicalVolumeNames {
 			var lv localv1alpha1.LogicalVolume
 			lv.Name = lvname
@@ -119,10 +139,29 @@ func (d *Discoverer) discoverLvmVGs(newStatus *localv1alpha1.NodeLocalStorageSta
 				continue
 			}
 			lv.Total = tmplv.SizeInBytes()
-			if !d.isLocalLV(lvname) {
+			if tmplv.IsSnapshot() {
+				lv.Origin = tmplv.OriginLVName()
+			}
+			setSnapshotRelation(&lv, relations[lvname])
+			// lvs created by csi are tagged, lvs created before are known by name.
+			// snapshot taken before resize belongs to no pv. Unassigned
+			// preallocated lvs are taken by schedulers from annotation of nls
+			// set by csi controller, which never lags behind lvcreate
+			switch {
+			case utils.IsUnassignedPreallocatedLV(tmplv.Tags()):
+			case (!d.isLocalLV(lvname) && !tmplv.HasTag(localtype.ManagedLVTag)) || tmplv.HasTag(localtype.ExpansionSnapshotLVTag):
 				vgCrd.Allocatable -= lv.Total
+			default:
+				vgCrd.LogicalVolumeCount++
 			}
 			lv.Condition = localv1alpha1.StorageReady
+			if tmplv.IsSuspended() {
+				log.Warningf("logical volume %s/%s is suspended, operations on it fail until it is resumed", vgname, lvname)
+				lv.Condition = localv1alpha1.StorageSuspended
+			}
+			if tmplv.IsSnapshot() && tmplv.HasTag(localtype.PendingDeletionLVTag) {
+				lv.Condition = localv1alpha1.StoragePendingDeletion
+			}
 			vgCrd.LogicalVolumes = append(vgCrd.LogicalVolumes, lv)
 		}
 
@@ -148,12 +187,87 @@ func (d *Discoverer) discoverLvmVGs(newStatus *localv1alpha1.NodeLocalStorageSta
 		// }
 		vgCrd.Condition = localv1alpha1.StorageReady
 
+		// no new lv can be created once metadata area is full, even if there is free data space
+		if vgCrd.MetadataFree, vgCrd.MetadataSize, err = vg.MetadataUsage(); err != nil {
+			log.Errorf("get metadata usage of volume group %s error: %s", vgname, err.Error())
+		} else if utils.IsVGMetadataLow(vgCrd.MetadataFree, vgCrd.MetadataSize, d.MetadataLowThreshold) {
+			log.Warningf("metadata area of volume group %s is nearly full: free %d bytes of %d bytes", vgname, vgCrd.MetadataFree, vgCrd.MetadataSize)
+			vgCrd.Condition = localv1alpha1.StorageMetadataLow
+		}
+
 		newStatus.NodeStorageInfo.VolumeGroups = append(newStatus.NodeStorageInfo.VolumeGroups, vgCrd)
 	}
 
 	return nil
 }
 
+// setSnapshotRelation records snapshots depending on lv and its position in
+// snapshot chain, an origin can not be removed before its snapshots
+func setSnapshotRelation(lv *localv1alpha1.LogicalVolume, relation lvm.SnapshotRelation) {
+	if lv.Origin == "" {
+		lv.Origin = relation.Origin
+	}
+	lv.Snapshots = relation.Snapshots
+	lv.SnapshotDepth = int32(relation.Depth)
+}
+
+// setVGAllocation records extent layout and allocation policy of vg, which
+// tells why a lv of certain size can not be allocated
+func setVGAllocation(vgCrd *localv1alpha1.VolumeGroup, allocation lvm.Allocation) {
+	vgCrd.ExtentSize = allocation.ExtentSize
+	vgCrd.ExtentCount = allocation.ExtentCount
+	vgCrd.FreeExtentCount = allocation.FreeExtentCount
+	vgCrd.AllocationPolicy = allocation.Policy
+}
+
+// setPVSpaces records size and free space of each pv of vg, free extents are
+// derived from extent size recorded by setVGAllocation
+func setPVSpaces(vgCrd *localv1alpha1.VolumeGroup, spaces []lvm.PhysicalVolumeSpace) {
+	vgCrd.PhysicalVolumeSpaces = nil
+	for _, space := range spaces {
+		pvCrd := localv1alpha1.PhysicalVolumeSpace{Name: space.Name, Total: space.Size, Available: space.Free}
+		if vgCrd.ExtentSize > 0 {
+			pvCrd.FreeExtentCount = space.Free / vgCrd.ExtentSize
+		}
+		vgCrd.PhysicalVolumeSpaces = append(vgCrd.PhysicalVolumeSpaces, pvCrd)
+	}
+}
+
+// retainMissingVGs keeps vgs of the last status absent from newStatus for
+// VGMissingGraceCycles consecutive discoveries, so that a vg flickering out
+// during device re-enumeration or lvm lock contention changes nothing
+func (d *Discoverer) retainMissingVGs(newStatus *localv1alpha1.NodeLocalStorageStatus, lastVGs []localv1alpha1.VolumeGroup) {
+	present := make(map[string]bool, len(newStatus.NodeStorageInfo.VolumeGroups))
+	for _, vg := range newStatus.NodeStorageInfo.VolumeGroups {
+		present[vg.Name] = true
+		delete(d.vgMissingCycles, vg.Name)
+	}
+	missingCycles := make(map[string]int)
+	retained := false
+	for _, vg := range lastVGs {
+		if present[vg.Name] {
+			continue
+		}
+		cycles := d.vgMissingCycles[vg.Name] + 1
+		if cycles >= d.VGMissingGraceCycles {
+			log.Warningf("volume group %s is absent for %d discoveries, removing it from status", vg.Name, cycles)
+			continue
+		}
+		log.Warningf("volume group %s is absent for %d of %d discoveries, keeping it in status", vg.Name, cycles, d.VGMissingGraceCycles)
+		missingCycles[vg.Name] = cycles
+		newStatus.NodeStorageInfo.VolumeGroups = append(newStatus.NodeStorageInfo.VolumeGroups, vg)
+		retained = true
+	}
+	// forget vgs removed from status
+	d.vgMissingCycles = missingCycles
+	if retained {
+		// vgs are reported by lvm in order of name
+		sort.SliceStable(newStatus.NodeStorageInfo.VolumeGroups, func(i, j int) bool {
+			return newStatus.NodeStorageInfo.VolumeGroups[i].Name < newStatus.NodeStorageInfo.VolumeGroups[j].Name
+		})
+	}
+}
+
 func (d *Discoverer) createVG(vgname string, devices []string) error {
 	force := false
 	forceCreateVG := os.Getenv(localtype.EnvForceCreateVG)
@@ -161,6 +275,15 @@ func (d *Discoverer) createVG(vgname string, devices []string) error {
 		force = true
 	}
 
+	if !force {
+		// pvcreate refuses devices with filesystem or lvm signatures unless forced
+		for _, dev := range devices {
+			if err := d.checkDeviceSignature(dev); err != nil {
+				return err
+			}
+		}
+	}
+
 	var pvs []*lvm.PhysicalVolume
 	for _, dev := range devices {
 		pv, err := lvm.CreatePhysicalVolume(dev, force)
@@ -180,6 +303,10 @@ func (d *Discoverer) createVG(vgname string, devices []string) error {
 
 // isLocalLV check if lv is created by open-local according to the lv name
 func (d *Discoverer) isLocalLV(lvname string) bool {
+	// lv name may be rendered from pv name by template
+	if pvName, ok := utils.ParseLVName(d.Configuration.LogicalVolumeNameTemplate, lvname); ok {
+		lvname = pvName
+	}
 	prefixlen := len(d.Configuration.LogicalVolumeNamePrefix)
 	ephemeralVolumePrefix := "csi-"
 
@@ -0,0 +1,153 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+)
+
+func TestDiscoverer_retainMissingVGs(t *testing.T) {
+	vgA := localv1alpha1.VolumeGroup{Name: "a", PhysicalVolumes: []string{"/dev/sdb"}, Total: 100, Available: 50, Allocatable: 100, Condition: localv1alpha1.StorageReady}
+	vgB := localv1alpha1.VolumeGroup{Name: "b", PhysicalVolumes: []string{"/dev/sdc"}, Total: 200, Available: 200, Allocatable: 200, Condition: localv1alpha1.StorageReady}
+	vgC := localv1alpha1.VolumeGroup{Name: "c", PhysicalVolumes: []string{"/dev/sdd"}, Total: 300, Available: 300, Allocatable: 300, Condition: localv1alpha1.StorageReady}
+	all := []localv1alpha1.VolumeGroup{vgA, vgB, vgC}
+
+	tests := []struct {
+		name        string
+		graceCycles int
+		// discovered is the vgs listed by lvm in each cycle
+		discovered [][]localv1alpha1.VolumeGroup
+		// want is the vgs in status after each cycle
+		want [][]localv1alpha1.VolumeGroup
+	}{
+		{
+			name:        "test flicker out for one cycle and back",
+			graceCycles: 2,
+			discovered:  [][]localv1alpha1.VolumeGroup{{vgA, vgC}, all},
+			want:        [][]localv1alpha1.VolumeGroup{all, all},
+		},
+		{
+			name:        "test removed after grace cycles",
+			graceCycles: 2,
+			discovered:  [][]localv1alpha1.VolumeGroup{{vgA, vgC}, {vgA, vgC}, {vgA, vgC}},
+			want:        [][]localv1alpha1.VolumeGroup{all, {vgA, vgC}, {vgA, vgC}},
+		},
+		{
+			name:        "test reappearance resets grace cycles",
+			graceCycles: 2,
+			discovered:  [][]localv1alpha1.VolumeGroup{{vgA, vgC}, all, {vgA, vgC}, all},
+			want:        [][]localv1alpha1.VolumeGroup{all, all, all, all},
+		},
+		{
+			name:        "test disabled",
+			graceCycles: 0,
+			discovered:  [][]localv1alpha1.VolumeGroup{{vgA, vgC}, all},
+			want:        [][]localv1alpha1.VolumeGroup{{vgA, vgC}, all},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{
+				Configuration:   &common.Configuration{VGMissingGraceCycles: tt.graceCycles},
+				vgMissingCycles: make(map[string]int),
+			}
+			last := all
+			for i, discovered := range tt.discovered {
+				status := &localv1alpha1.NodeLocalStorageStatus{}
+				status.NodeStorageInfo.VolumeGroups = append([]localv1alpha1.VolumeGroup{}, discovered...)
+				d.retainMissingVGs(status, last)
+				if !reflect.DeepEqual(status.NodeStorageInfo.VolumeGroups, tt.want[i]) {
+					t.Errorf("cycle %d: VolumeGroups = %+v, want %+v", i, status.NodeStorageInfo.VolumeGroups, tt.want[i])
+				}
+				last = status.NodeStorageInfo.VolumeGroups
+			}
+		})
+	}
+}
+
+func Test_setVGAllocation(t *testing.T) {
+	vgCrd := localv1alpha1.VolumeGroup{Name: "share", Total: 107369988096}
+	setVGAllocation(&vgCrd, lvm.Allocation{ExtentSize: 4194304, ExtentCount: 25599, FreeExtentCount: 12800, Policy: "cling"})
+	want := localv1alpha1.VolumeGroup{
+		Name:             "share",
+		Total:            107369988096,
+		ExtentSize:       4194304,
+		ExtentCount:      25599,
+		FreeExtentCount:  12800,
+		AllocationPolicy: "cling",
+	}
+	if !reflect.DeepEqual(vgCrd, want) {
+		t.Errorf("setVGAllocation() = %+v, want %+v", vgCrd, want)
+	}
+}
+
+func Test_setPVSpaces(t *testing.T) {
+	spaces := []lvm.PhysicalVolumeSpace{
+		{Name: "/dev/sdb", Size: 107369988096, Free: 0},
+		{Name: "/dev/sdc", Size: 107369988096, Free: 53687091200},
+	}
+	tests := []struct {
+		name       string
+		extentSize uint64
+		want       []localv1alpha1.PhysicalVolumeSpace
+	}{
+		{
+			name:       "with extent size",
+			extentSize: 4194304,
+			want: []localv1alpha1.PhysicalVolumeSpace{
+				{Name: "/dev/sdb", Total: 107369988096, Available: 0, FreeExtentCount: 0},
+				{Name: "/dev/sdc", Total: 107369988096, Available: 53687091200, FreeExtentCount: 12800},
+			},
+		},
+		{
+			name: "without extent size",
+			want: []localv1alpha1.PhysicalVolumeSpace{
+				{Name: "/dev/sdb", Total: 107369988096, Available: 0},
+				{Name: "/dev/sdc", Total: 107369988096, Available: 53687091200},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vgCrd := localv1alpha1.VolumeGroup{Name: "share", ExtentSize: tt.extentSize}
+			setPVSpaces(&vgCrd, spaces)
+			if !reflect.DeepEqual(vgCrd.PhysicalVolumeSpaces, tt.want) {
+				t.Errorf("setPVSpaces() = %+v, want %+v", vgCrd.PhysicalVolumeSpaces, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setSnapshotRelation(t *testing.T) {
+	lv := localv1alpha1.LogicalVolume{Name: "snap-0", VGName: "share"}
+	setSnapshotRelation(&lv, lvm.SnapshotRelation{Origin: "origin", Snapshots: []string{"snap-0-0"}, Depth: 1})
+	want := localv1alpha1.LogicalVolume{
+		Name:          "snap-0",
+		VGName:        "share",
+		Origin:        "origin",
+		Snapshots:     []string{"snap-0-0"},
+		SnapshotDepth: 1,
+	}
+	if !reflect.DeepEqual(lv, want) {
+		t.Errorf("setSnapshotRelation() = %+v, want %+v", lv, want)
+	}
+}
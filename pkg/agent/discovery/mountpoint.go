@@ -70,6 +70,7 @@ func (d *Discoverer) discoverMountPoints(newStatus *localv1alpha1.NodeLocalStora
 		if mpinfo.Available == 0 {
 			mpinfo.Condition = localv1alpha1.StorageFull
 		}
+		setBtrfsCapacity(&mpinfo)
 		// TODO(huizhi.szh): IsBind
 		mpinfo.IsBind = false
 		mpinfo.Options = mountPointMap[filePath].Opts
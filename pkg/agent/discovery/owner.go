@@ -0,0 +1,106 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// setLVOwners fills pv, pvc and pods of lvs in status, so that workloads
+// filling a vg are found from nodelocalstorage only
+func (d *Discoverer) setLVOwners(status *localv1alpha1.NodeLocalStorageStatus) error {
+	vgs := status.NodeStorageInfo.VolumeGroups
+	if !hasLogicalVolumes(vgs) {
+		return nil
+	}
+	pvs, err := d.kubeclientset.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list pvs error: %s", err.Error())
+	}
+	owners := map[string]*corev1.PersistentVolume{}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if isOpenLocal, volumeType := utils.IsOpenLocalPV(pv); !isOpenLocal || volumeType != localtype.VolumeTypeLVM {
+			continue
+		}
+		owners[utils.GetNameKey(utils.GetVGNameFromCsiPV(pv), utils.GetLVNameFromCsiPV(pv))] = pv
+	}
+	pods, err := d.claimPods()
+	if err != nil {
+		return err
+	}
+	for i := range vgs {
+		for j := range vgs[i].LogicalVolumes {
+			lv := &vgs[i].LogicalVolumes[j]
+			pv, exist := owners[utils.GetNameKey(lv.VGName, lv.Name)]
+			if !exist {
+				continue
+			}
+			lv.PVName = pv.Name
+			if pv.Spec.ClaimRef != nil {
+				lv.PVCNamespace = pv.Spec.ClaimRef.Namespace
+				lv.PVCName = pv.Spec.ClaimRef.Name
+				lv.Pods = pods[utils.GetNameKey(lv.PVCNamespace, lv.PVCName)]
+			}
+		}
+	}
+	return nil
+}
+
+// claimPods returns names of running pods on the node by <namespace>/<pvc>
+func (d *Discoverer) claimPods() (map[string][]string, error) {
+	pods, err := d.kubeclientset.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", d.Nodename).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pods error: %s", err.Error())
+	}
+	claims := map[string][]string{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != d.Nodename || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				key := utils.GetNameKey(pod.Namespace, volume.PersistentVolumeClaim.ClaimName)
+				claims[key] = append(claims[key], pod.Name)
+			}
+		}
+	}
+	for _, names := range claims {
+		sort.Strings(names)
+	}
+	return claims, nil
+}
+
+func hasLogicalVolumes(vgs []localv1alpha1.VolumeGroup) bool {
+	for _, vg := range vgs {
+		if len(vg.LogicalVolumes) > 0 {
+			return true
+		}
+	}
+	return false
+}
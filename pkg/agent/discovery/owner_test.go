@@ -0,0 +1,71 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverer_setLVOwners(t *testing.T) {
+	unbound := activationPV("local-unbound", "")
+	unbound.Spec.ClaimRef = nil
+	otherNode := activationPod("pod-other", "pvc-a", corev1.PodRunning)
+	otherNode.Spec.NodeName = "node-2"
+	objects := []runtime.Object{
+		activationPV("local-a", "pvc-a"),
+		activationPV("local-b", "pvc-b"),
+		unbound,
+		activationPod("pod-a-1", "pvc-a", corev1.PodRunning),
+		activationPod("pod-a-0", "pvc-a", corev1.PodPending),
+		activationPod("pod-a-done", "pvc-a", corev1.PodSucceeded),
+		otherNode,
+	}
+	status := &localv1alpha1.NodeLocalStorageStatus{}
+	status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{{
+		Name: "vg",
+		LogicalVolumes: []localv1alpha1.LogicalVolume{
+			{Name: "local-a", VGName: "vg"},
+			{Name: "local-b", VGName: "vg"},
+			{Name: "local-unbound", VGName: "vg"},
+			{Name: "docker-pool", VGName: "vg"},
+		},
+	}}
+	d := &Discoverer{
+		Configuration: &common.Configuration{Nodename: activationNode},
+		kubeclientset: kubefake.NewSimpleClientset(objects...),
+	}
+	if err := d.setLVOwners(status); err != nil {
+		t.Fatalf("setLVOwners error: %s", err.Error())
+	}
+
+	want := []localv1alpha1.LogicalVolume{
+		{Name: "local-a", VGName: "vg", PVName: "local-a", PVCNamespace: "default", PVCName: "pvc-a", Pods: []string{"pod-a-0", "pod-a-1"}},
+		{Name: "local-b", VGName: "vg", PVName: "local-b", PVCNamespace: "default", PVCName: "pvc-b"},
+		{Name: "local-unbound", VGName: "vg", PVName: "local-unbound"},
+		{Name: "docker-pool", VGName: "vg"},
+	}
+	if got := status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes; !reflect.DeepEqual(got, want) {
+		t.Errorf("logical volumes = %+v, want %+v", got, want)
+	}
+}
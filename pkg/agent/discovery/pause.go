@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"sync"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	log "k8s.io/klog/v2"
+)
+
+// discoveryPause is whether discovery is paused by annotation of nls
+type discoveryPause struct {
+	lock   sync.Mutex
+	paused bool
+}
+
+// set records paused state, it returns true if the state is changed
+func (p *discoveryPause) set(paused bool) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	changed := p.paused != paused
+	p.paused = paused
+	return changed
+}
+
+func (p *discoveryPause) get() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.paused
+}
+
+// SyncPause pauses or resumes discovery and snapshot expansion by annotation
+// of nls. A discovery runs once the state is changed, which reports paused
+// state in status on pause and refreshes the whole status on resume
+func (d *Discoverer) SyncPause() {
+	nls, err := d.getNodeLocalStorage()
+	if err != nil {
+		return
+	}
+	paused := utils.IsDiscoveryPaused(nls)
+	if !d.pause.set(paused) {
+		return
+	}
+	if paused {
+		log.Infof("discovery of node %s is paused", d.Nodename)
+	} else {
+		log.Infof("discovery of node %s is resumed", d.Nodename)
+	}
+	d.discover()
+}
+
+// pausedByNLS records paused state of nls, and reports it in status once
+// discovery is paused. Status discovered before pause is kept as it is
+func (d *Discoverer) pausedByNLS(nls *localv1alpha1.NodeLocalStorage) bool {
+	paused := utils.IsDiscoveryPaused(nls)
+	d.pause.set(paused)
+	if !paused {
+		return false
+	}
+	nlsCopy := d.statusQueue.overlay(nls).DeepCopy()
+	if nlsCopy.Status.NodeStorageInfo.DiscoveryPaused || d.InventoryOnly {
+		return true
+	}
+	nlsCopy.Status.NodeStorageInfo.DiscoveryPaused = true
+	if err := d.statusQueue.enqueue(nlsCopy, true); err != nil {
+		log.Errorf("report paused discovery of nls %s error: %s", nlsCopy.Name, err.Error())
+	}
+	return true
+}
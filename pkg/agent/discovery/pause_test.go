@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func pauseTestNLS(paused bool) *localv1alpha1.NodeLocalStorage {
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	if paused {
+		nls.Annotations = map[string]string{localtype.AnnoDiscoveryPaused: "true"}
+	}
+	return nls
+}
+
+func TestDiscoverer_Paused(t *testing.T) {
+	nls := pauseTestNLS(true)
+	nls.Status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{driftTestVG(900)}
+	localclient := localfake.NewSimpleClientset(nls)
+	d := NewDiscoverer(&common.Configuration{Nodename: "test-node"},
+		k8sfake.NewSimpleClientset(), localclient, nil, record.NewFakeRecorder(10))
+	discovered := 0
+	d.listPendingSnapshots = func() ([]pendingSnapshot, error) {
+		discovered++
+		return nil, nil
+	}
+	driftChecked := 0
+	d.readVGs = func(map[string]ReservedVGInfo) ([]localv1alpha1.VolumeGroup, error) {
+		driftChecked++
+		return nil, nil
+	}
+	expanded := 0
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) {
+		expanded++
+		return nil, nil
+	}
+	defer func() { listSnapshotLVs = originList }()
+
+	for i := 0; i < 2; i++ {
+		d.Discover()
+		d.ExpandSnapshotLVIfNeeded()
+		d.CheckStatusDrift()
+	}
+	if discovered != 0 || expanded != 0 || driftChecked != 0 {
+		t.Errorf("%d discoveries, %d snapshot expansions and %d drift checks run while paused", discovered, expanded, driftChecked)
+	}
+	got, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get nls: %s", err.Error())
+	}
+	if !got.Status.NodeStorageInfo.DiscoveryPaused {
+		t.Errorf("paused discovery is not reported in status")
+	}
+	if len(got.Status.NodeStorageInfo.VolumeGroups) != 1 {
+		t.Errorf("status discovered before pause is changed: %+v", got.Status.NodeStorageInfo.VolumeGroups)
+	}
+}
+
+func TestDiscoverer_SyncPause(t *testing.T) {
+	tests := []struct {
+		name          string
+		wasPaused     bool
+		paused        bool
+		wantDiscovery int
+	}{
+		{
+			name:          "test pause",
+			wasPaused:     false,
+			paused:        true,
+			wantDiscovery: 1,
+		},
+		{
+			name:          "test resume",
+			wasPaused:     true,
+			paused:        false,
+			wantDiscovery: 1,
+		},
+		{
+			name:          "test still paused",
+			wasPaused:     true,
+			paused:        true,
+			wantDiscovery: 0,
+		},
+		{
+			name:          "test still running",
+			wasPaused:     false,
+			paused:        false,
+			wantDiscovery: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDiscoverer(&common.Configuration{Nodename: "test-node"},
+				k8sfake.NewSimpleClientset(), localfake.NewSimpleClientset(pauseTestNLS(tt.paused)), nil, record.NewFakeRecorder(10))
+			d.pause.set(tt.wasPaused)
+			discovered := 0
+			d.discover = func() { discovered++ }
+
+			d.SyncPause()
+			if discovered != tt.wantDiscovery {
+				t.Errorf("SyncPause() runs %d discoveries, want %d", discovered, tt.wantDiscovery)
+			}
+			if d.pause.get() != tt.paused {
+				t.Errorf("paused = %t, want %t", d.pause.get(), tt.paused)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	log "k8s.io/klog/v2"
+)
+
+// pendingSnapshot is a snapshot lv tagged for deletion by csi plugin, whose
+// removal failed because origin was busy
+type pendingSnapshot struct {
+	vgName string
+	lvName string
+}
+
+func (s pendingSnapshot) String() string {
+	return s.vgName + "/" + s.lvName
+}
+
+// removePendingSnapshots retries removing snapshot lvs pending deletion. The
+// pending state is the lvm tag of snapshot lv itself, attempts of snapshots
+// no longer listed are dropped so that nothing is kept for vanished ones
+func (d *Discoverer) removePendingSnapshots() {
+	snapshots, err := d.listPendingSnapshots()
+	if err != nil {
+		log.Errorf("[removePendingSnapshots]list snapshots pending deletion error: %s", err.Error())
+		return
+	}
+	attempts := make(map[string]int, len(snapshots))
+	for _, snapshot := range snapshots {
+		key := snapshot.String()
+		if err := d.removeSnapshot(snapshot.vgName, snapshot.lvName); err != nil {
+			attempts[key] = d.pendingDeletions[key] + 1
+			log.Warningf("[removePendingSnapshots]failed to remove snapshot %s pending deletion, attempt %d: %s", key, attempts[key], err.Error())
+			continue
+		}
+		log.Infof("[removePendingSnapshots]snapshot %s pending deletion is removed after %d failed attempts", key, d.pendingDeletions[key])
+	}
+	d.pendingDeletions = attempts
+}
+
+// listPendingSnapshots returns snapshot lvs tagged for deletion of all vgs
+func listPendingSnapshots() ([]pendingSnapshot, error) {
+	vgNames, err := lvm.ListVolumeGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []pendingSnapshot
+	for _, vgName := range vgNames {
+		vg, err := lvm.LookupVolumeGroup(vgName)
+		if err != nil {
+			return nil, err
+		}
+		names, err := vg.ListLogicalVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			lv, err := vg.LookupLogicalVolume(name)
+			if err != nil {
+				// removed meanwhile
+				continue
+			}
+			if lv.IsSnapshot() && lv.HasTag(localtype.PendingDeletionLVTag) {
+				snapshots = append(snapshots, pendingSnapshot{vgName: vgName, lvName: name})
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// removeSnapshotLV removes snapshot lv in vg
+func removeSnapshotLV(vgName, lvName string) error {
+	vg, err := lvm.LookupVolumeGroup(vgName)
+	if err != nil {
+		return err
+	}
+	lv, err := vg.LookupLogicalVolume(lvName)
+	if err != nil {
+		return err
+	}
+	return lv.Remove()
+}
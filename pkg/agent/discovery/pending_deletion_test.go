@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakePendingSnapshots are snapshot lvs pending deletion, each is removed
+// after its origin stays busy for the given number of attempts
+type fakePendingSnapshots struct {
+	busy     map[string]int
+	attempts []string
+}
+
+func (f *fakePendingSnapshots) list() ([]pendingSnapshot, error) {
+	var snapshots []pendingSnapshot
+	for _, name := range []string{"snap-a", "snap-b"} {
+		if _, exist := f.busy[name]; exist {
+			snapshots = append(snapshots, pendingSnapshot{vgName: "vg", lvName: name})
+		}
+	}
+	return snapshots, nil
+}
+
+func (f *fakePendingSnapshots) remove(vgName, lvName string) error {
+	f.attempts = append(f.attempts, vgName+"/"+lvName)
+	if f.busy[lvName] > 0 {
+		f.busy[lvName]--
+		return errors.New("device-mapper: remove ioctl on (253:4) failed: Device or resource busy")
+	}
+	delete(f.busy, lvName)
+	return nil
+}
+
+func TestDiscoverer_removePendingSnapshots(t *testing.T) {
+	fake := &fakePendingSnapshots{busy: map[string]int{"snap-a": 2, "snap-b": 0}}
+	d := &Discoverer{
+		listPendingSnapshots: fake.list,
+		removeSnapshot:       fake.remove,
+	}
+	cycles := []struct {
+		wantPending  map[string]int
+		wantAttempts []string
+	}{
+		{
+			wantPending:  map[string]int{"vg/snap-a": 1},
+			wantAttempts: []string{"vg/snap-a", "vg/snap-b"},
+		},
+		{
+			wantPending:  map[string]int{"vg/snap-a": 2},
+			wantAttempts: []string{"vg/snap-a"},
+		},
+		{
+			wantPending:  map[string]int{},
+			wantAttempts: []string{"vg/snap-a"},
+		},
+		{
+			// nothing is left to retry
+			wantPending:  map[string]int{},
+			wantAttempts: nil,
+		},
+	}
+	for i, cycle := range cycles {
+		fake.attempts = nil
+		d.removePendingSnapshots()
+		if !reflect.DeepEqual(d.pendingDeletions, cycle.wantPending) {
+			t.Errorf("cycle %d: pendingDeletions = %v, want %v", i, d.pendingDeletions, cycle.wantPending)
+		}
+		if !reflect.DeepEqual(fake.attempts, cycle.wantAttempts) {
+			t.Errorf("cycle %d: attempts = %v, want %v", i, fake.attempts, cycle.wantAttempts)
+		}
+	}
+}
+
+func TestDiscoverer_removePendingSnapshots_Vanished(t *testing.T) {
+	fake := &fakePendingSnapshots{busy: map[string]int{"snap-a": 10}}
+	d := &Discoverer{
+		listPendingSnapshots: fake.list,
+		removeSnapshot:       fake.remove,
+	}
+	d.removePendingSnapshots()
+	if want := map[string]int{"vg/snap-a": 1}; !reflect.DeepEqual(d.pendingDeletions, want) {
+		t.Errorf("pendingDeletions = %v, want %v", d.pendingDeletions, want)
+	}
+	// removed by others, e.g. vg is removed
+	delete(fake.busy, "snap-a")
+	d.removePendingSnapshots()
+	if len(d.pendingDeletions) != 0 {
+		t.Errorf("pendingDeletions = %v, want empty", d.pendingDeletions)
+	}
+}
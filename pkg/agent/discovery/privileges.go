@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"strings"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+// CheckPrivileges checks once when agent starts whether it is privileged to
+// run lvm and mount operations. Agent lacking privileges runs in degraded
+// mode: discovery only reports InsufficientPrivileges in status and every
+// other cycle is refused, instead of failing each operation on its own
+func (d *Discoverer) CheckPrivileges() {
+	d.missingPrivileges = d.readMissingPrivileges()
+	if len(d.missingPrivileges) > 0 {
+		log.Errorf("[CheckPrivileges]agent lacks %s, check securityContext of agent, mutating operations are refused", strings.Join(d.missingPrivileges, ", "))
+		return
+	}
+	log.Infof("[CheckPrivileges]agent is privileged to run lvm and mount operations")
+}
+
+// insufficientPrivileges reports InsufficientPrivileges in status of nls if
+// agent lacks privileges, heartbeat is not refreshed so that scheduler treats
+// storage of node as stale. It returns false if agent is privileged
+func (d *Discoverer) insufficientPrivileges(nls *localv1alpha1.NodeLocalStorage) bool {
+	if len(d.missingPrivileges) == 0 {
+		return false
+	}
+	nlsCopy := d.statusQueue.overlay(nls).DeepCopy()
+	state := &nlsCopy.Status.NodeStorageInfo.State
+	message := fmt.Sprintf("agent lacks %s to run lvm and mount operations", strings.Join(d.missingPrivileges, ", "))
+	if state.Type == localv1alpha1.StorageInsufficientPrivileges && state.Message == message {
+		return true
+	}
+	now := metav1.Now()
+	state.Type = localv1alpha1.StorageInsufficientPrivileges
+	state.Status = localv1alpha1.ConditionTrue
+	state.Reason = string(localv1alpha1.StorageInsufficientPrivileges)
+	state.Message = message
+	state.LastTransitionTime = &now
+	if d.InventoryOnly {
+		return true
+	}
+	if err := d.statusQueue.enqueue(nlsCopy, true); err != nil {
+		log.Errorf("report insufficient privileges of nls %s error: %s", nlsCopy.Name, err.Error())
+	}
+	return true
+}
+
+// privilegedFor tells whether cycle of opType may run, only discovery runs
+// without privileges to report the condition
+func (d *Discoverer) privilegedFor(opType string) bool {
+	if len(d.missingPrivileges) == 0 || opType == utils.OperationTypeDiscovery {
+		return true
+	}
+	log.V(4).Infof("agent lacks %s, refuse %s", strings.Join(d.missingPrivileges, ", "), opType)
+	return false
+}
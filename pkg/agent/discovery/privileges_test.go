@@ -0,0 +1,89 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDiscoverer_InsufficientPrivileges(t *testing.T) {
+	nls := pauseTestNLS(false)
+	nls.Status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{driftTestVG(900)}
+	localclient := localfake.NewSimpleClientset(nls)
+	d := NewDiscoverer(&common.Configuration{Nodename: "test-node"},
+		k8sfake.NewSimpleClientset(), localclient, nil, record.NewFakeRecorder(10))
+	d.readMissingPrivileges = func() []string {
+		return []string{"CAP_SYS_ADMIN", "access to " + utils.DeviceMapperControl}
+	}
+	d.CheckPrivileges()
+	discovered := 0
+	d.listPendingSnapshots = func() ([]pendingSnapshot, error) {
+		discovered++
+		return nil, nil
+	}
+	expanded := 0
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) {
+		expanded++
+		return nil, nil
+	}
+	defer func() { listSnapshotLVs = originList }()
+
+	for i := 0; i < 2; i++ {
+		d.Discover()
+		d.ExpandSnapshotLVIfNeeded()
+	}
+	if discovered != 0 || expanded != 0 {
+		t.Errorf("%d discoveries and %d snapshot expansions run without privileges", discovered, expanded)
+	}
+	for _, opType := range []string{utils.OperationTypeLVActivation, utils.OperationTypeInitResource, utils.OperationTypeStatusDriftCheck} {
+		if _, ok := d.beginCycle(opType); ok {
+			t.Errorf("%s is not refused without privileges", opType)
+		}
+	}
+	got, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get nls: %s", err.Error())
+	}
+	state := got.Status.NodeStorageInfo.State
+	if state.Type != localv1alpha1.StorageInsufficientPrivileges || state.Status != localv1alpha1.ConditionTrue ||
+		!strings.Contains(state.Message, "CAP_SYS_ADMIN") || !strings.Contains(state.Message, utils.DeviceMapperControl) {
+		t.Errorf("insufficient privileges is not reported in status: %+v", state)
+	}
+	if state.LastHeartbeatTime != nil {
+		t.Errorf("heartbeat is refreshed without privileges: %v", state.LastHeartbeatTime)
+	}
+
+	// privileged agent runs every cycle
+	d.readMissingPrivileges = func() []string { return nil }
+	d.CheckPrivileges()
+	end, ok := d.beginCycle(utils.OperationTypeInitResource)
+	if !ok {
+		t.Fatalf("init-resource is refused with privileges")
+	}
+	end()
+}
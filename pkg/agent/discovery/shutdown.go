@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+// beginCycle starts a cycle of periodic work, it returns false once agent is
+// shutting down so that no new work is started, or agent lacks privileges for
+// work other than discovery. The returned function ends the cycle
+func (d *Discoverer) beginCycle(opType string) (func(), bool) {
+	d.shutdownLock.Lock()
+	defer d.shutdownLock.Unlock()
+	if d.shuttingDown {
+		log.V(4).Infof("agent is shutting down, skip %s", opType)
+		return nil, false
+	}
+	if !d.privilegedFor(opType) {
+		return nil, false
+	}
+	d.cycles.Add(1)
+	_, deregister := utils.LongOperations.Register(opType, "", "", nil, nil)
+	return func() {
+		deregister()
+		d.cycles.Done()
+	}, true
+}
+
+// Shutdown stops starting new cycles, waits at most timeout for running ones
+// and then publishes the final status, so that lvs created or expanded after
+// the last update of status are not left unreported when agent is terminated
+func (d *Discoverer) Shutdown(timeout time.Duration) {
+	d.shutdownLock.Lock()
+	d.shuttingDown = true
+	d.shutdownLock.Unlock()
+	// coalesced status is covered by the final update
+	defer d.statusQueue.stop()
+
+	done := make(chan struct{})
+	go func() {
+		d.cycles.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		for _, op := range utils.LongOperations.List() {
+			log.Warningf("%s started at %s is still running on shutdown", op.Type, op.StartTime.Format(time.RFC3339))
+		}
+		log.Warningf("running cycles do not finish in %s, skip the final status update of nls %s", timeout, d.Nodename)
+		return
+	}
+	if d.InventoryOnly {
+		return
+	}
+	if err := d.flushStatus(); err != nil {
+		log.Errorf("final status update of nls %s error: %s", d.Nodename, err.Error())
+		return
+	}
+	log.Infof("final status of nls %s is published", d.Nodename)
+}
+
+// flushStatus re-reads vgs from lvm and publishes them in status, other
+// fields are kept as the last discovery reported
+func (d *Discoverer) flushStatus() error {
+	d.discoverLock.Lock()
+	defer d.discoverLock.Unlock()
+	nls, err := d.localclientset.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), d.Nodename, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	nls = d.statusQueue.overlay(nls)
+	reservedVGInfos := make(map[string]ReservedVGInfo)
+	if anno, exist := utils.LookupParam(nls.Annotations, AnnoStorageReserve); exist {
+		if reservedVGInfos, err = getReservedVGInfo(anno); err != nil {
+			return err
+		}
+	}
+	vgs, err := d.readVGs(reservedVGInfos)
+	if err != nil {
+		return err
+	}
+	return d.republishVGs(nls, vgs)
+}
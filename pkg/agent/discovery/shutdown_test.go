@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDiscoverer_Shutdown(t *testing.T) {
+	lv1 := localv1alpha1.LogicalVolume{Name: "lv1", VGName: "vg1", Total: 100, Condition: localv1alpha1.StorageReady}
+	lv2 := localv1alpha1.LogicalVolume{Name: "lv2", VGName: "vg1", Total: 200, Condition: localv1alpha1.StorageReady}
+	publishedVGs := []localv1alpha1.VolumeGroup{driftTestVG(900, lv1)}
+	// lv2 is created by the cycle running when agent is terminated
+	lvmVGs := []localv1alpha1.VolumeGroup{driftTestVG(700, lv1, lv2)}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// finishCycle ends the running cycle before timeout
+		finishCycle bool
+		wantVGs     []localv1alpha1.VolumeGroup
+	}{
+		{
+			name:        "test final status flushed after running cycle",
+			timeout:     10 * time.Second,
+			finishCycle: true,
+			wantVGs:     lvmVGs,
+		},
+		{
+			name:        "test running cycle exceeding timeout",
+			timeout:     100 * time.Millisecond,
+			finishCycle: false,
+			wantVGs:     publishedVGs,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+			nls.Status.NodeStorageInfo.VolumeGroups = publishedVGs
+			localclient := localfake.NewSimpleClientset(nls)
+			d := NewDiscoverer(&common.Configuration{Nodename: "test-node"},
+				k8sfake.NewSimpleClientset(), localclient, nil, record.NewFakeRecorder(10))
+			d.readVGs = func(map[string]ReservedVGInfo) ([]localv1alpha1.VolumeGroup, error) {
+				return lvmVGs, nil
+			}
+
+			end, ok := d.beginCycle(utils.OperationTypeDiscovery)
+			if !ok {
+				t.Fatalf("cycle is refused before shutdown")
+			}
+			done := make(chan struct{})
+			go func() {
+				d.Shutdown(tt.timeout)
+				close(done)
+			}()
+			// no new work starts once shutting down
+			for i := 0; ; i++ {
+				d.shutdownLock.Lock()
+				shuttingDown := d.shuttingDown
+				d.shutdownLock.Unlock()
+				if shuttingDown {
+					break
+				}
+				if i >= 500 {
+					t.Fatalf("discoverer is not shutting down")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if _, ok := d.beginCycle(utils.OperationTypeSnapshotExpansion); ok {
+				t.Errorf("new cycle is started during shutdown")
+			}
+
+			if tt.finishCycle {
+				end()
+				<-done
+			} else {
+				<-done
+				end()
+			}
+			got, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get nls: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got.Status.NodeStorageInfo.VolumeGroups, tt.wantVGs) {
+				t.Errorf("vgs in status after shutdown = %+v, want %+v", got.Status.NodeStorageInfo.VolumeGroups, tt.wantVGs)
+			}
+			if ops := utils.LongOperations.List(); len(ops) != 0 {
+				t.Errorf("operations still registered after shutdown: %+v", ops)
+			}
+		})
+	}
+}
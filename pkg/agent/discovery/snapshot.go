@@ -18,18 +18,32 @@ package discovery
 
 import (
 	"context"
+	"errors"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
 	"github.com/alibaba/open-local/pkg/utils/lvm"
-	units "github.com/docker/go-units"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	log "k8s.io/klog/v2"
 )
 
 func (d *Discoverer) ExpandSnapshotLVIfNeeded() {
+	end, ok := d.beginCycle(utils.OperationTypeSnapshotExpansion)
+	if !ok {
+		return
+	}
+	defer end()
+	if d.pause.get() {
+		log.V(4).Infof("discovery of node %s is paused, skip snapshot expansion", d.Nodename)
+		return
+	}
 	// It's unnecessary for SPDK snapshot. SPDK snapshot size is fixed.
 	// In SPDK, when creating snapshot original volume becomes thin provisioned
 	// and saves only incremental differences from its underlying snapshot.
@@ -38,61 +52,407 @@ func (d *Discoverer) ExpandSnapshotLVIfNeeded() {
 	}
 }
 
-func (d *Discoverer) expandSnapshotLvmLVIfNeeded() {
-	// Step 0: get prefix of snapshot lv
-	prefix := os.Getenv(localtype.EnvSnapshotPrefix)
-	if prefix == "" {
-		prefix = localtype.DefaultSnapshotPrefix
-	}
+// snapshotLV is the snapshot logical volume which can be expanded
+type snapshotLV interface {
+	Name() string
+	VGName() string
+	OriginLVName() string
+	SizeInBytes() uint64
+	Usage() float64
+	Expand(size uint64) error
+	OriginWrittenBytes(sysPath string) (uint64, error)
+	OriginSizeInBytes() (uint64, error)
+	SetReadAhead(sectors uint64) (bool, error)
+}
+
+// snapshotUsageRecord records used bytes of snapshot lv in the last cycle
+type snapshotUsageRecord struct {
+	usedBytes float64
+	timestamp time.Time
+	// originWritten is bytes written to origin, valid if hasOriginWritten
+	originWritten    uint64
+	hasOriginWritten bool
+}
+
+// snapshotExpansion is the snapshot lv to be expanded in this cycle
+type snapshotExpansion struct {
+	lv             snapshotLV
+	snapshot       string
+	projectedUsage float64
+	expansionSize  uint64
+}
 
+var (
+	// replaced in unit test
+	listSnapshotLVs = getAllLocalSnapshotLV
+	vgFreeSpace     = lvmVGFreeSpace
+	vgSize          = lvmVGSize
+	timeNow         = time.Now
+)
+
+func (d *Discoverer) expandSnapshotLvmLVIfNeeded() {
 	// Step 1: get all snapshot lv
-	lvs, err := getAllLocalSnapshotLV()
+	lvs, err := listSnapshotLVs()
 	if err != nil {
 		log.Errorf("[ExpandSnapshotLVIfNeeded]get open-local snapshot lv failed: %s", err.Error())
 		return
 	}
+	now := timeNow()
+	inWindow := d.snapshotSchedule.inWindow(now)
+	d.snapshotTrends.sample(lvs, now)
+	records := make(map[string]snapshotUsageRecord, len(lvs))
+	alerts := make(map[string]bool)
+	defer func() {
+		// drop records of removed snapshot lv
+		d.snapshotUsages = records
+		d.snapshotAlerts = alerts
+	}()
 	// Step 2: handle every snapshot lv(for)
+	expansions := make([]snapshotExpansion, 0)
 	for _, lv := range lvs {
 		// step 1: get threshold and increase size from snapshotClass
-		snapContent, err := d.snapclient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), strings.Replace(lv.Name(), prefix, "snapcontent", 1), metav1.GetOptions{})
+		snapContentName, ok := d.getSnapshotContentName(lv.Name())
+		if !ok {
+			log.InfoS("snapshot lv is not rendered by lv name template, skip", append(snapshotLogKeys(lv, ""), "template", d.Configuration.LogicalVolumeNameTemplate)...)
+			continue
+		}
+		snapContent, err := d.snapclient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), snapContentName, metav1.GetOptions{})
 		if err != nil {
-			log.Errorf("[ExpandSnapshotLVIfNeeded]get snapContent %s error: %s", lv.Name(), err.Error())
-			return
+			log.ErrorS(err, "failed to get snapshot content", snapshotLogKeys(lv, snapContentName)...)
+			d.keepSnapshotRecords(lv.Name(), records, alerts)
+			continue
 		}
-		snapClass, err := d.snapclient.SnapshotV1().VolumeSnapshotClasses().Get(context.TODO(), *snapContent.Spec.VolumeSnapshotClassName, metav1.GetOptions{})
+		params, err := d.getSnapshotClassParameters(lv, snapContent)
 		if err != nil {
-			log.Errorf("[ExpandSnapshotLVIfNeeded]get snapClass %s error: %s", *snapContent.Spec.VolumeSnapshotClassName, err.Error())
-			return
-		}
-		initialSize, threshold, expansionSize := getSnapshotInitialInfo(snapClass.Parameters)
-		// step 2: expand snapshot lv if necessary
-		if lv.Usage() > threshold {
-			log.Infof("[ExpandSnapshotLVIfNeeded]expand snapshot lv %s", lv.Name())
-			log.Infof("[getSnapshotInitialInfo]initialSize(%d), threshold(%f), expansionSize(%d)", initialSize, threshold, expansionSize)
-			if err := lv.Expand(expansionSize); err != nil {
-				log.Errorf("[ExpandSnapshotLVIfNeeded]expand lv %s failed: %s", lv.Name(), err.Error())
-				return
+			d.keepSnapshotRecords(lv.Name(), records, alerts)
+			continue
+		}
+		setSnapshotReadAhead(lv, snapContentName, params)
+		initialSize, threshold, expansionSize := getSnapshotInitialInfo(params, lv)
+		// step 2: project usage by fill velocity
+		usedBytes := lv.Usage() * float64(lv.SizeInBytes())
+		projectedUsage := d.projectSnapshotUsage(lv, usedBytes, now)
+		record := snapshotUsageRecord{usedBytes: usedBytes, timestamp: now}
+		// step 3: expand by expected COW writes of fast-growing origin
+		if ratio := getSnapshotOriginGrowthRatio(params); ratio > 0 {
+			if written, err := lv.OriginWrittenBytes(d.SysPath); err != nil {
+				log.ErrorS(err, "failed to get origin written bytes of snapshot lv", snapshotLogKeys(lv, snapContentName)...)
+			} else {
+				record.originWritten, record.hasOriginWritten = written, true
+				if size := d.originGrowthExpansionSize(lv, written, ratio); size > expansionSize {
+					log.InfoS("expansion size of snapshot lv is raised by origin growth", append(snapshotLogKeys(lv, snapContentName), "from", expansionSize, "to", size)...)
+					expansionSize = size
+				}
+			}
+		}
+		records[lv.Name()] = record
+		if d.alertSnapshotUsage(lv, snapContent, params) {
+			alerts[lv.Name()] = true
+		}
+		if d.snapshotExpansionDue(lv, projectedUsage, threshold, inWindow) {
+			log.InfoS("snapshot lv exceeds threshold", append(snapshotLogKeys(lv, snapContentName), "initialSize", initialSize, "threshold", threshold, "expansionSize", expansionSize)...)
+			expansions = append(expansions, snapshotExpansion{lv: lv, snapshot: snapContentName, projectedUsage: projectedUsage, expansionSize: expansionSize})
+		} else if projectedUsage > threshold {
+			log.InfoS("defer expanding snapshot lv to the next expansion window", append(snapshotLogKeys(lv, snapContentName), "usage", lv.Usage(), "projectedUsage", projectedUsage, "threshold", threshold)...)
+		}
+	}
+	// Step 3: expand snapshot lv whose projected usage is higher first, within
+	// free space of vg shared by all its snapshot lv
+	sort.SliceStable(expansions, func(i, j int) bool {
+		return expansions[i].projectedUsage > expansions[j].projectedUsage
+	})
+	expansions = d.limitSnapshotExpansions(expansions)
+	budget := d.snapshotExpansionBudget(lvs)
+	for _, vgName := range snapshotVGNames(expansions) {
+		expandSnapshotLVsInVG(vgName, expansions, budget)
+	}
+}
+
+// keepSnapshotRecords carries usage record and alert of snapshot lv skipped
+// in this cycle forward, so that a transient error of apiserver neither
+// resets its fill velocity nor alerts it again
+func (d *Discoverer) keepSnapshotRecords(name string, records map[string]snapshotUsageRecord, alerts map[string]bool) {
+	if record, ok := d.snapshotUsages[name]; ok {
+		records[name] = record
+	}
+	if d.snapshotAlerts[name] {
+		alerts[name] = true
+	}
+}
+
+// limitSnapshotExpansions keeps the most urgent expansions within the limit
+// per cycle, so that a backup storm never floods node with lvextend. The rest
+// still exceed threshold and are expanded in subsequent cycles
+func (d *Discoverer) limitSnapshotExpansions(expansions []snapshotExpansion) []snapshotExpansion {
+	limit := d.Configuration.SnapshotExpansionsPerCycle
+	if limit <= 0 || len(expansions) <= limit {
+		return expansions
+	}
+	for _, expansion := range expansions[limit:] {
+		log.InfoS("defer expanding snapshot lv to next cycle for expansion limit", append(snapshotLogKeys(expansion.lv, expansion.snapshot), "projectedUsage", expansion.projectedUsage, "limit", limit)...)
+	}
+	return expansions[:limit]
+}
+
+// snapshotVGNames returns names of vg in the order of their most urgent expansion
+func snapshotVGNames(expansions []snapshotExpansion) []string {
+	names := make([]string, 0)
+	seen := map[string]bool{}
+	for _, expansion := range expansions {
+		if name := expansion.lv.VGName(); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// expandSnapshotLVsInVG expands snapshot lv of vg by the size allocated from
+// free space of vg. Snapshot lv failed to expand is retried in the next cycle
+// and never blocks expansion of others. Budget is the size snapshot lvs of
+// node may still grow by under the node cap, nil if unlimited, it is reduced
+// by every expansion
+func expandSnapshotLVsInVG(vgName string, expansions []snapshotExpansion, budget *uint64) {
+	free, extentSize, err := vgFreeSpace(vgName)
+	if err != nil {
+		log.ErrorS(err, "failed to get free space of vg, skip expanding its snapshot lv", utils.LogKeyOperation, "ExpandSnapshotLV", utils.LogKeyVG, vgName)
+		return
+	}
+	inVG := make([]snapshotExpansion, 0)
+	for _, expansion := range expansions {
+		if expansion.lv.VGName() == vgName {
+			if budget != nil {
+				// lvextend rounds size up to extent, which must stay within budget
+				expansion.expansionSize = roundUpToExtent(expansion.expansionSize, extentSize)
+			}
+			inVG = append(inVG, expansion)
+		}
+	}
+	capped := false
+	if budget != nil {
+		limit := *budget
+		if extentSize > 0 {
+			limit = limit / extentSize * extentSize
+		}
+		if limit < free {
+			free, capped = limit, true
+		}
+	}
+	sizes := allocateSnapshotExpansion(inVG, free, extentSize)
+	for i, expansion := range inVG {
+		lv := expansion.lv
+		keys := append(snapshotLogKeys(lv, expansion.snapshot), "origin", lv.OriginLVName())
+		if sizes[i] == 0 {
+			if capped {
+				log.InfoS("defer expanding snapshot lv for node snapshot capacity limit", append(keys, "usage", lv.Usage(), "projectedUsage", expansion.projectedUsage, "budget", *budget)...)
+				continue
+			}
+			log.InfoS("skip expanding snapshot lv for no free space in vg", append(keys, "usage", lv.Usage(), "projectedUsage", expansion.projectedUsage, "vgFree", free)...)
+			continue
+		}
+		log.InfoS("expand snapshot lv", append(keys, "usage", lv.Usage(), "projectedUsage", expansion.projectedUsage, "expansionSize", expansion.expansionSize, "allocatedSize", sizes[i])...)
+		if err := lv.Expand(sizes[i]); err != nil {
+			// suspended lv must not block expansion of others
+			if errors.Is(err, lvm.ErrLogicalVolumeSuspended) {
+				log.InfoS("skip expanding suspended snapshot lv", append(keys, "reason", err.Error())...)
+				continue
+			}
+			log.ErrorS(err, "failed to expand snapshot lv", keys...)
+			continue
+		}
+		if budget != nil {
+			if sizes[i] < *budget {
+				*budget -= sizes[i]
+			} else {
+				*budget = 0
+			}
+		}
+		log.InfoS("expand snapshot lv successfully", keys...)
+	}
+}
+
+// allocateSnapshotExpansion allocates free bytes of vg to expansions sorted by
+// priority. Nothing is cut if free space is enough, otherwise free extents are
+// shared fairly among origins first and then among snapshots of each origin,
+// so that one origin with many snapshots never starves others. Expansion of
+// higher priority gets the extents left by even division.
+func allocateSnapshotExpansion(expansions []snapshotExpansion, free, extentSize uint64) []uint64 {
+	sizes := make([]uint64, len(expansions))
+	var total uint64
+	for i, expansion := range expansions {
+		sizes[i] = expansion.expansionSize
+		total += expansion.expansionSize
+	}
+	if total <= free || extentSize == 0 {
+		return sizes
+	}
+	// demand in extents, lvextend rounds size up to extent
+	demands := make([]uint64, len(expansions))
+	for i, expansion := range expansions {
+		demands[i] = (expansion.expansionSize + extentSize - 1) / extentSize
+	}
+	// origins in the order of their most urgent snapshot
+	origins := make([]string, 0)
+	members := map[string][]int{}
+	for i, expansion := range expansions {
+		origin := expansion.lv.OriginLVName()
+		if _, exist := members[origin]; !exist {
+			origins = append(origins, origin)
+		}
+		members[origin] = append(members[origin], i)
+	}
+	originDemands := make([]uint64, len(origins))
+	for i, origin := range origins {
+		for _, j := range members[origin] {
+			originDemands[i] += demands[j]
+		}
+	}
+	for i, originShare := range fairShares(originDemands, free/extentSize) {
+		indexes := members[origins[i]]
+		snapshotDemands := make([]uint64, len(indexes))
+		for k, j := range indexes {
+			snapshotDemands[k] = demands[j]
+		}
+		for k, share := range fairShares(snapshotDemands, originShare) {
+			if j := indexes[k]; share < demands[j] {
+				sizes[j] = share * extentSize
+			}
+		}
+	}
+	return sizes
+}
+
+// roundUpToExtent rounds size up to multiple of extentSize
+func roundUpToExtent(size, extentSize uint64) uint64 {
+	if extentSize == 0 {
+		return size
+	}
+	return (size + extentSize - 1) / extentSize * extentSize
+}
+
+// fairShares divides total among demands in max-min fairness: no one gets more
+// than its demand, and what is left by satisfied ones is divided among others.
+// Remainder of even division goes to demands in front.
+func fairShares(demands []uint64, total uint64) []uint64 {
+	shares := make([]uint64, len(demands))
+	for total > 0 {
+		unmet := make([]int, 0, len(demands))
+		for i := range demands {
+			if shares[i] < demands[i] {
+				unmet = append(unmet, i)
+			}
+		}
+		if len(unmet) == 0 {
+			break
+		}
+		share := total / uint64(len(unmet))
+		if share == 0 {
+			for _, i := range unmet[:total] {
+				shares[i]++
 			}
-			log.Infof("[ExpandSnapshotLVIfNeeded]expand snapshot lv %s successfully", lv.Name())
+			break
 		}
+		for _, i := range unmet {
+			given := demands[i] - shares[i]
+			if given > share {
+				given = share
+			}
+			shares[i] += given
+			total -= given
+		}
+	}
+	return shares
+}
+
+// snapshotLogKeys returns keys and values of structured log about snapshot lv
+func snapshotLogKeys(lv snapshotLV, snapshot string) []interface{} {
+	keys := []interface{}{utils.LogKeyOperation, "ExpandSnapshotLV", utils.LogKeyLV, lv.Name(), utils.LogKeyVG, lv.VGName()}
+	if snapshot != "" {
+		keys = append(keys, utils.LogKeySnapshot, snapshot)
 	}
+	return keys
 }
 
-func getSnapshotInitialInfo(param map[string]string) (initialSize uint64, threshold float64, increaseSize uint64) {
+// projectSnapshotUsage projects usage of snapshot lv after SnapshotProjectionWindow
+// by the fill velocity since the last cycle
+func (d *Discoverer) projectSnapshotUsage(lv snapshotLV, usedBytes float64, now time.Time) float64 {
+	if d.SnapshotProjectionWindow <= 0 || lv.SizeInBytes() == 0 {
+		return lv.Usage()
+	}
+	last, exist := d.snapshotUsages[lv.Name()]
+	if !exist || !now.After(last.timestamp) || usedBytes <= last.usedBytes {
+		return lv.Usage()
+	}
+	velocity := (usedBytes - last.usedBytes) / now.Sub(last.timestamp).Seconds()
+	projectedBytes := usedBytes + velocity*float64(d.SnapshotProjectionWindow)
+	return projectedBytes / float64(lv.SizeInBytes())
+}
+
+// originGrowthExpansionSize returns expected COW bytes until the next cycle,
+// which is origin writes since the last cycle multiplied by ratio
+func (d *Discoverer) originGrowthExpansionSize(lv snapshotLV, written uint64, ratio float64) uint64 {
+	last, exist := d.snapshotUsages[lv.Name()]
+	if !exist || !last.hasOriginWritten || written <= last.originWritten {
+		return 0
+	}
+	return uint64(float64(written-last.originWritten) * ratio)
+}
+
+// getSnapshotContentName resolves the name of VolumeSnapshotContent from snapshot lv name
+func (d *Discoverer) getSnapshotContentName(lvName string) (string, bool) {
+	// Step 0: get prefix of snapshot lv
+	prefix := os.Getenv(localtype.EnvSnapshotPrefix)
+	if prefix == "" {
+		prefix = localtype.DefaultSnapshotPrefix
+	}
+	// Step 1: get snapshot name from lv name
+	snapshotName, ok := utils.ParseLVName(d.Configuration.LogicalVolumeNameTemplate, lvName)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(snapshotName, prefix, "snapcontent", 1), true
+}
+
+// getSnapshotClassParameters returns parameters of snapshot class of snapshot
+// content. If the snapshot class is deleted, nil is returned so that snapshot
+// lv is still expanded by node default policy instead of filling up
+func (d *Discoverer) getSnapshotClassParameters(lv snapshotLV, snapContent *volumesnapshotv1.VolumeSnapshotContent) (map[string]string, error) {
+	keys := snapshotLogKeys(lv, snapContent.Name)
+	className := snapContent.Spec.VolumeSnapshotClassName
+	if className == nil || *className == "" {
+		log.InfoS("snapshot content refers to no snapshot class, fall back to node default snapshot policy", append(keys, "threshold", localtype.DefaultSnapshotThreshold, "expansionSize", localtype.DefaultSnapshotExpansionSize)...)
+		return nil, nil
+	}
+	keys = append(keys, "snapshotClass", *className)
+	snapClass, err := d.snapclient.SnapshotV1().VolumeSnapshotClasses().Get(context.TODO(), *className, metav1.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		log.InfoS("snapshot class is not found, fall back to node default snapshot policy", append(keys, "threshold", localtype.DefaultSnapshotThreshold, "expansionSize", localtype.DefaultSnapshotExpansionSize)...)
+		return nil, nil
+	}
+	if err != nil {
+		log.ErrorS(err, "failed to get snapshot class", keys...)
+		return nil, err
+	}
+	return snapClass.Parameters, nil
+}
+
+// getSnapshotInitialInfo parses snapshot class parameters, size in percentage
+// is resolved by origin size of lv and default is used if it fails
+func getSnapshotInitialInfo(param map[string]string, lv snapshotLV) (initialSize uint64, threshold float64, increaseSize uint64) {
 	initialSize = localtype.DefaultSnapshotInitialSize
 	threshold = localtype.DefaultSnapshotThreshold
 	increaseSize = localtype.DefaultSnapshotExpansionSize
 
 	// Step 1: get snapshot initial size
-	if str, exist := param[localtype.ParamSnapshotInitialSize]; exist {
-		size, err := units.RAMInBytes(str)
-		if err != nil {
-			log.Error("[getSnapshotInitialInfo]get initialSize from snapshot annotation failed")
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotInitialSize); exist {
+		if size, err := resolveSnapshotSize(str, lv); err != nil {
+			log.Errorf("[getSnapshotInitialInfo]get initialSize from snapshot annotation failed: %s", err.Error())
+		} else {
+			initialSize = size
 		}
-		initialSize = uint64(size)
 	}
 	// Step 2: get snapshot expand threshold
-	if str, exist := param[localtype.ParamSnapshotThreshold]; exist {
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotThreshold); exist {
 		str = strings.ReplaceAll(str, "%", "")
 		thr, err := strconv.ParseFloat(str, 64)
 		if err != nil {
@@ -101,19 +461,119 @@ func getSnapshotInitialInfo(param map[string]string) (initialSize uint64, thresh
 		threshold = thr / 100
 	}
 	// Step 3: get snapshot increase size
-	if str, exist := param[localtype.ParamSnapshotExpansionSize]; exist {
-		size, err := units.RAMInBytes(str)
-		if err != nil {
-			log.Error("[getSnapshotInitialInfo]get increase size from snapshot annotation failed")
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotExpansionSize); exist {
+		if lvm.IsPercentSize(str) {
+			if size, err := resolveVGPercentSize(str, lv); err != nil {
+				log.Errorf("[getSnapshotInitialInfo]get increase size in percentage of vg failed: %s", err.Error())
+			} else {
+				increaseSize = size
+			}
+		} else if size, err := resolveSnapshotSize(str, lv); err != nil {
+			log.Errorf("[getSnapshotInitialInfo]get increase size from snapshot annotation failed: %s", err.Error())
+		} else {
+			increaseSize = size
 		}
-		increaseSize = uint64(size)
 	}
 	return
 }
 
-func getAllLocalSnapshotLV() (lvs []*lvm.LogicalVolume, err error) {
+// resolveSnapshotSize gets origin size of lv only for size in percentage
+func resolveSnapshotSize(value string, lv snapshotLV) (uint64, error) {
+	size, err := utils.ParseSnapshotSize(value)
+	if err != nil {
+		return 0, err
+	}
+	var originSize uint64
+	if size.IsPercent() {
+		if originSize, err = lv.OriginSizeInBytes(); err != nil {
+			return 0, err
+		}
+	}
+	return size.Resolve(originSize)
+}
+
+// resolveVGPercentSize resolves size in percentage of vg or its free space
+// to bytes added to lv, which is clamped to free space of vg
+func resolveVGPercentSize(value string, lv snapshotLV) (uint64, error) {
+	size, err := lvm.ParsePercentSize(value)
+	if err != nil {
+		return 0, err
+	}
+	total, err := vgSize(lv.VGName())
+	if err != nil {
+		return 0, err
+	}
+	free, extentSize, err := vgFreeSpace(lv.VGName())
+	if err != nil {
+		return 0, err
+	}
+	return size.ExpansionBytes(lv.SizeInBytes(), total, free, extentSize), nil
+}
+
+// getSnapshotOriginGrowthRatio returns 0 if origin growth is not factored in
+func getSnapshotOriginGrowthRatio(param map[string]string) float64 {
+	str, exist := utils.LookupParam(param, localtype.ParamSnapshotOriginGrowthRatio)
+	if !exist {
+		return 0
+	}
+	ratio, err := strconv.ParseFloat(str, 64)
+	if err != nil || ratio < 0 {
+		log.Errorf("[getSnapshotOriginGrowthRatio]invalid %s %q", localtype.ParamSnapshotOriginGrowthRatio, str)
+		return 0
+	}
+	return ratio
+}
+
+// setSnapshotReadAhead sets read ahead of snapshot lv configured in snapshot
+// class, it is checked every cycle so that snapshot lv created or activated
+// since the last cycle is covered
+func setSnapshotReadAhead(lv snapshotLV, snapContentName string, param map[string]string) {
+	str, exist := utils.LookupParam(param, localtype.ParamSnapshotReadAhead)
+	if !exist {
+		return
+	}
+	sectors, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		log.Errorf("[setSnapshotReadAhead]invalid %s %q", localtype.ParamSnapshotReadAhead, str)
+		return
+	}
+	changed, err := lv.SetReadAhead(sectors)
+	if err != nil {
+		log.ErrorS(err, "failed to set read ahead of snapshot lv", append(snapshotLogKeys(lv, snapContentName), "readAhead", sectors)...)
+		return
+	}
+	if changed {
+		log.InfoS("set read ahead of snapshot lv", append(snapshotLogKeys(lv, snapContentName), "readAhead", sectors)...)
+	}
+}
+
+// lvmVGFreeSpace returns free bytes and extent size of vg
+func lvmVGFreeSpace(vgName string) (free uint64, extentSize uint64, err error) {
+	vg, err := lvm.LookupVolumeGroup(vgName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if free, err = vg.BytesFree(); err != nil {
+		return 0, 0, err
+	}
+	if extentSize, err = vg.ExtentSize(); err != nil {
+		return 0, 0, err
+	}
+	return free, extentSize, nil
+}
+
+// lvmVGSize returns total bytes of vg
+func lvmVGSize(vgName string) (uint64, error) {
+	vg, err := lvm.LookupVolumeGroup(vgName)
+	if err != nil {
+		return 0, err
+	}
+	return vg.BytesTotal()
+}
+
+func getAllLocalSnapshotLV() (lvs []snapshotLV, err error) {
 	// get all vg names
-	lvs = make([]*lvm.LogicalVolume, 0)
+	lvs = make([]snapshotLV, 0)
 	vgNames, err := lvm.ListVolumeGroupNames()
 	if err != nil {
 		log.Errorf("[getAllLocalSnapshotLV]List volume group names error: %s", err.Error())
@@ -139,7 +599,9 @@ func getAllLocalSnapshotLV() (lvs []*lvm.LogicalVolume, err error) {
 				log.Errorf("[getAllLocalSnapshotLV]List logical volume %s error: %s", lvName, err.Error())
 				continue
 			}
-			if tmplv.IsSnapshot() {
+			// snapshot content of snapshot pending deletion is gone, and
+			// snapshot taken before resize has none
+			if tmplv.IsSnapshot() && !tmplv.HasTag(localtype.PendingDeletionLVTag) && !tmplv.HasTag(localtype.ExpansionSnapshotLVTag) {
 				lvs = append(lvs, tmplv)
 			}
 		}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	log "k8s.io/klog/v2"
+)
+
+// getSnapshotAlertThreshold returns usage ratio above which snapshot lv is
+// alerted, false if alert is off or the threshold is invalid
+func getSnapshotAlertThreshold(param map[string]string) (float64, bool) {
+	str, exist := utils.LookupParam(param, localtype.ParamSnapshotAlertThreshold)
+	if !exist {
+		return 0, false
+	}
+	threshold, err := strconv.ParseFloat(strings.ReplaceAll(str, "%", ""), 64)
+	if err != nil || threshold <= 0 || threshold > 100 {
+		log.Errorf("[getSnapshotAlertThreshold]invalid %s %q", localtype.ParamSnapshotAlertThreshold, str)
+		return 0, false
+	}
+	return threshold / 100, true
+}
+
+// alertSnapshotUsage records a warning event on snapshot content when usage of
+// snapshot lv crosses alert threshold, and returns whether usage is above it.
+// Snapshot lv is alerted again only after its usage falls below the threshold,
+// e.g. it is expanded. Alert never leads to expansion
+func (d *Discoverer) alertSnapshotUsage(lv snapshotLV, snapContent *volumesnapshotv1.VolumeSnapshotContent, param map[string]string) bool {
+	threshold, ok := getSnapshotAlertThreshold(param)
+	if !ok || lv.Usage() <= threshold {
+		return false
+	}
+	if d.snapshotAlerts[lv.Name()] {
+		return true
+	}
+	log.InfoS("snapshot lv exceeds alert threshold", append(snapshotLogKeys(lv, snapContent.Name), "usage", lv.Usage(), "alertThreshold", threshold)...)
+	d.recorder.Eventf(snapContent, corev1.EventTypeWarning, localtype.EventSnapshotUsageAlert, "usage %.1f%% of snapshot lv %s exceeds alert threshold %.1f%%",
+		lv.Usage()*100, utils.GetNameKey(lv.VGName(), lv.Name()), threshold*100)
+	return true
+}
@@ -0,0 +1,131 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_Alert(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		// usage of snapshot lv in every cycle
+		usages       []float64
+		wantAlerts   []bool
+		wantExpanded []string
+	}{
+		{
+			name: "test alert fires at its threshold without expansion",
+			params: map[string]string{
+				localtype.ParamSnapshotThreshold:      "85%",
+				localtype.ParamSnapshotAlertThreshold: "70%",
+			},
+			usages:       []float64{0.6, 0.75, 0.8, 0.6, 0.72},
+			wantAlerts:   []bool{false, true, false, false, true},
+			wantExpanded: []string{},
+		},
+		{
+			name: "test alert is independent of expansion",
+			params: map[string]string{
+				localtype.ParamSnapshotThreshold:      "85%",
+				localtype.ParamSnapshotAlertThreshold: "70%",
+			},
+			usages:       []float64{0.9},
+			wantAlerts:   []bool{true},
+			wantExpanded: []string{"snap-a"},
+		},
+		{
+			name: "test legacy key",
+			params: map[string]string{
+				localtype.ParamSnapshotThreshold:                            "85%",
+				localtype.LegacyParamKeyPrefix + "snapshot-alert-threshold": "70",
+			},
+			usages:       []float64{0.75},
+			wantAlerts:   []bool{true},
+			wantExpanded: []string{},
+		},
+		{
+			name: "test alert is off by default",
+			params: map[string]string{
+				localtype.ParamSnapshotThreshold: "85%",
+			},
+			usages:       []float64{0.75, 0.8},
+			wantAlerts:   []bool{false, false},
+			wantExpanded: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer fakeVGFreeSpace(16 * 1024 * 1024 * 1024)()
+			className := "test-snapshotclass"
+			fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+			_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+				ObjectMeta: metav1.ObjectMeta{Name: className},
+				Parameters: tt.params,
+			}, metav1.CreateOptions{})
+			_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-a"},
+				Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+					VolumeSnapshotClassName: &className,
+				},
+			}, metav1.CreateOptions{})
+			expanded := []string{}
+			lv := &fakeSnapshotLV{name: "snap-a", size: 1024 * 1024 * 1024, expanded: &expanded}
+			originList := listSnapshotLVs
+			listSnapshotLVs = func() ([]snapshotLV, error) { return []snapshotLV{lv}, nil }
+			defer func() { listSnapshotLVs = originList }()
+
+			recorder := record.NewFakeRecorder(10)
+			d := &Discoverer{
+				Configuration:  &common.Configuration{},
+				snapclient:     fakeSnapClient,
+				recorder:       recorder,
+				snapshotUsages: map[string]snapshotUsageRecord{},
+			}
+			for i, usage := range tt.usages {
+				lv.usage = usage
+				d.expandSnapshotLvmLVIfNeeded()
+				alerted := false
+				select {
+				case event := <-recorder.Events:
+					alerted = true
+					if !strings.Contains(event, "Warning "+localtype.EventSnapshotUsageAlert) || !strings.Contains(event, "open-local-pool-0/snap-a") {
+						t.Errorf("cycle %d: event = %s, want warning %s of snap-a", i, event, localtype.EventSnapshotUsageAlert)
+					}
+				default:
+				}
+				if alerted != tt.wantAlerts[i] {
+					t.Errorf("cycle %d: usage %v alerted = %v, want %v", i, usage, alerted, tt.wantAlerts[i])
+				}
+			}
+			if !reflect.DeepEqual(expanded, tt.wantExpanded) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, tt.wantExpanded)
+			}
+		})
+	}
+}
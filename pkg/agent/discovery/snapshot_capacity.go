@@ -0,0 +1,132 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"sync"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	log "k8s.io/klog/v2"
+)
+
+// setSnapshotAwareAvailable reports available of every vg in status minus
+// potential growth of its snapshot lvs if enabled, so that free space taken
+// by snapshots later is not counted as free now
+func (d *Discoverer) setSnapshotAwareAvailable(status *localv1alpha1.NodeLocalStorageStatus) {
+	if !d.SnapshotAwareCapacity {
+		return
+	}
+	vgs := status.NodeStorageInfo.VolumeGroups
+	for i := range vgs {
+		available := snapshotAwareAvailable(vgs[i])
+		vgs[i].SnapshotAwareAvailable = &available
+	}
+}
+
+// snapshotAwareAvailable returns available of vg minus growth headroom of its
+// snapshot lvs. Snapshot lv is expanded as COW grows, which never needs more
+// than size of its origin, so headroom is the gap up to origin size
+func snapshotAwareAvailable(vg localv1alpha1.VolumeGroup) uint64 {
+	sizes := make(map[string]uint64, len(vg.LogicalVolumes))
+	for _, lv := range vg.LogicalVolumes {
+		sizes[lv.Name] = lv.Total
+	}
+	var headroom uint64
+	for _, lv := range vg.LogicalVolumes {
+		if lv.Origin == "" {
+			continue
+		}
+		if origin, exist := sizes[lv.Origin]; exist && origin > lv.Total {
+			headroom += origin - lv.Total
+		}
+	}
+	if headroom >= vg.Available {
+		return 0
+	}
+	return vg.Available - headroom
+}
+
+// snapshotCapacityLimit is maxSnapshotSize resolved by the last discovery,
+// snapshot expansion keeps total size of snapshot lvs within it
+type snapshotCapacityLimit struct {
+	lock    sync.Mutex
+	limit   uint64
+	limited bool
+}
+
+func (l *snapshotCapacityLimit) set(limit uint64, limited bool) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limit, l.limited = limit, limited
+}
+
+// get returns false if total size of snapshot lvs is unlimited
+func (l *snapshotCapacityLimit) get() (uint64, bool) {
+	if l == nil {
+		return 0, false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit, l.limited
+}
+
+// setSnapshotCapacity reports total size of snapshot lvs against
+// maxSnapshotSize of nls, nothing is reported if it is not set. The last
+// resolved cap is kept if it fails to resolve
+func (d *Discoverer) setSnapshotCapacity(status *localv1alpha1.NodeLocalStorageStatus, maxSnapshotSize string) {
+	vgs := status.NodeStorageInfo.VolumeGroups
+	limit, limited, err := utils.SnapshotCapacityLimit(maxSnapshotSize, vgs)
+	if err != nil {
+		log.Warningf("resolve snapshot capacity limit error: %s", err.Error())
+		return
+	}
+	d.snapshotLimit.set(limit, limited)
+	if !limited {
+		return
+	}
+	capacity := &localv1alpha1.SnapshotCapacityStatus{
+		Limit:     limit,
+		Used:      utils.SnapshotCapacityUsed(vgs),
+		Condition: localv1alpha1.StorageReady,
+	}
+	if capacity.Used >= capacity.Limit {
+		capacity.Condition = localv1alpha1.StorageSnapshotLimitReached
+	}
+	status.NodeStorageInfo.SnapshotCapacity = capacity
+}
+
+// snapshotExpansionBudget returns the size snapshot lvs may still grow by
+// under the node cap, nil if unlimited
+func (d *Discoverer) snapshotExpansionBudget(lvs []snapshotLV) *uint64 {
+	limit, limited := d.snapshotLimit.get()
+	if !limited {
+		return nil
+	}
+	var used uint64
+	for _, lv := range lvs {
+		used += lv.SizeInBytes()
+	}
+	budget := uint64(0)
+	if used < limit {
+		budget = limit - used
+	}
+	return &budget
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func TestDiscoverer_setSnapshotAwareAvailable(t *testing.T) {
+	const gi = uint64(1024 * 1024 * 1024)
+	newStatus := func() *localv1alpha1.NodeLocalStorageStatus {
+		status := &localv1alpha1.NodeLocalStorageStatus{}
+		status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{
+			{
+				// snapshots of the same origin grow independently
+				Name:      "share",
+				Available: 100 * gi,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-a", Total: 50 * gi, Snapshots: []string{"snap-a1", "snap-a2"}},
+					{Name: "snap-a1", Total: 4 * gi, Origin: "local-a"},
+					{Name: "snap-a2", Total: 10 * gi, Origin: "local-a"},
+					{Name: "local-b", Total: 20 * gi},
+				},
+			},
+			{
+				// snapshot already as large as its origin has no headroom
+				Name:      "full",
+				Available: 10 * gi,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-c", Total: 8 * gi, Snapshots: []string{"snap-c"}},
+					{Name: "snap-c", Total: 8 * gi, Origin: "local-c"},
+				},
+			},
+			{
+				// headroom beyond available is reported as nothing left
+				Name:      "overcommitted",
+				Available: 10 * gi,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-d", Total: 100 * gi, Snapshots: []string{"snap-d"}},
+					{Name: "snap-d", Total: 4 * gi, Origin: "local-d"},
+				},
+			},
+			{
+				Name:      "nosnapshot",
+				Available: 30 * gi,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-e", Total: 10 * gi},
+				},
+			},
+		}
+		return status
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		// snapshot aware available by vg, nil if not reported
+		want map[string]uint64
+	}{
+		{
+			name:    "test disabled",
+			enabled: false,
+			want:    nil,
+		},
+		{
+			name:    "test enabled",
+			enabled: true,
+			want: map[string]uint64{
+				"share":         100*gi - (50*gi - 4*gi) - (50*gi - 10*gi),
+				"full":          10 * gi,
+				"overcommitted": 0,
+				"nosnapshot":    30 * gi,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{Configuration: &common.Configuration{SnapshotAwareCapacity: tt.enabled}}
+			status := newStatus()
+			raws := make(map[string]uint64)
+			for _, vg := range status.NodeStorageInfo.VolumeGroups {
+				raws[vg.Name] = vg.Available
+			}
+			d.setSnapshotAwareAvailable(status)
+			for _, vg := range status.NodeStorageInfo.VolumeGroups {
+				if raw := raws[vg.Name]; vg.Available != raw {
+					t.Errorf("available of vg %s = %d, raw available %d is changed", vg.Name, vg.Available, raw)
+				}
+				want, exist := tt.want[vg.Name]
+				if !exist {
+					if vg.SnapshotAwareAvailable != nil {
+						t.Errorf("snapshot aware available of vg %s = %d, want not reported", vg.Name, *vg.SnapshotAwareAvailable)
+					}
+					continue
+				}
+				if vg.SnapshotAwareAvailable == nil {
+					t.Errorf("snapshot aware available of vg %s is not reported, want %d", vg.Name, want)
+					continue
+				}
+				if *vg.SnapshotAwareAvailable != want {
+					t.Errorf("snapshot aware available of vg %s = %d, want %d", vg.Name, *vg.SnapshotAwareAvailable, want)
+				}
+				if *vg.SnapshotAwareAvailable > vg.Available {
+					t.Errorf("snapshot aware available of vg %s = %d, more than raw available %d", vg.Name, *vg.SnapshotAwareAvailable, vg.Available)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverer_setSnapshotCapacity(t *testing.T) {
+	const gi = uint64(1024 * 1024 * 1024)
+	newStatus := func(snapshotSize uint64) *localv1alpha1.NodeLocalStorageStatus {
+		status := &localv1alpha1.NodeLocalStorageStatus{}
+		status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{
+			{
+				Name:  "share",
+				Total: 100 * gi,
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "local-a", Total: 50 * gi},
+					{Name: "snap-a1", Total: snapshotSize, Origin: "local-a"},
+				},
+			},
+			{Name: "share-1", Total: 100 * gi},
+		}
+		return status
+	}
+	tests := []struct {
+		name            string
+		maxSnapshotSize string
+		snapshotSize    uint64
+		want            *localv1alpha1.SnapshotCapacityStatus
+		wantLimit       uint64
+		wantLimited     bool
+	}{
+		{
+			name:         "test unlimited",
+			snapshotSize: 10 * gi,
+		},
+		{
+			name:            "test below absolute cap",
+			maxSnapshotSize: "20Gi",
+			snapshotSize:    10 * gi,
+			want:            &localv1alpha1.SnapshotCapacityStatus{Limit: 20 * gi, Used: 10 * gi, Condition: localv1alpha1.StorageReady},
+			wantLimit:       20 * gi,
+			wantLimited:     true,
+		},
+		{
+			name:            "test percentage cap of all vgs reached",
+			maxSnapshotSize: "10%",
+			snapshotSize:    20 * gi,
+			want:            &localv1alpha1.SnapshotCapacityStatus{Limit: 20 * gi, Used: 20 * gi, Condition: localv1alpha1.StorageSnapshotLimitReached},
+			wantLimit:       20 * gi,
+			wantLimited:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{snapshotLimit: &snapshotCapacityLimit{limit: gi, limited: true}}
+			status := newStatus(tt.snapshotSize)
+			d.setSnapshotCapacity(status, tt.maxSnapshotSize)
+			if !reflect.DeepEqual(status.NodeStorageInfo.SnapshotCapacity, tt.want) {
+				t.Errorf("setSnapshotCapacity() = %+v, want %+v", status.NodeStorageInfo.SnapshotCapacity, tt.want)
+			}
+			if limit, limited := d.snapshotLimit.get(); limit != tt.wantLimit || limited != tt.wantLimited {
+				t.Errorf("snapshot limit = %d, %v, want %d, %v", limit, limited, tt.wantLimit, tt.wantLimited)
+			}
+		})
+	}
+
+	// invalid cap keeps the last resolved one
+	d := &Discoverer{snapshotLimit: &snapshotCapacityLimit{limit: gi, limited: true}}
+	status := newStatus(gi)
+	d.setSnapshotCapacity(status, "20 percent")
+	if status.NodeStorageInfo.SnapshotCapacity != nil {
+		t.Errorf("setSnapshotCapacity() reports %+v for invalid cap", status.NodeStorageInfo.SnapshotCapacity)
+	}
+	if limit, limited := d.snapshotLimit.get(); limit != gi || !limited {
+		t.Errorf("snapshot limit = %d, %v, want the last one kept", limit, limited)
+	}
+}
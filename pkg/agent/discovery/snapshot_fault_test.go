@@ -0,0 +1,132 @@
+//go:build faultinjection
+// +build faultinjection
+
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	"github.com/alibaba/open-local/pkg/utils/fault"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_getAllLocalSnapshotLV_InjectedFault(t *testing.T) {
+	errBusy := errors.New("Device or resource busy")
+	defer fault.Inject("vgs", fault.Fault{Err: errBusy})()
+	if _, err := getAllLocalSnapshotLV(); !errors.Is(err, errBusy) {
+		t.Errorf("getAllLocalSnapshotLV() error = %v, want %v", err, errBusy)
+	}
+	// expander gives up the cycle without listed snapshot lv
+	d := &Discoverer{Configuration: &common.Configuration{}, snapshotUsages: map[string]snapshotUsageRecord{}}
+	d.expandSnapshotLvmLVIfNeeded()
+	if len(d.snapshotUsages) != 0 {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() recorded usages %v of unlisted snapshot lv", d.snapshotUsages)
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_InjectedFault(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-fault"
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-fault"},
+		Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+			VolumeSnapshotClassName: &snapshotClassName,
+		},
+	}, metav1.CreateOptions{})
+
+	expanded := []string{}
+	lvs := []snapshotLV{&fakeSnapshotLV{name: "snap-fault", size: size, usage: 0.8, expanded: &expanded}}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	defer func() { listSnapshotLVs = originList }()
+	// free space of vg is got by lvm, which fails
+	errBusy := errors.New("Device or resource busy")
+	defer fault.Inject("vgs", fault.Fault{Err: errBusy, Times: 1})()
+
+	d := &Discoverer{
+		Configuration:  &common.Configuration{},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+	if len(expanded) != 0 {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() expanded %v without free space of vg", expanded)
+	}
+	if err := fault.Check(context.Background(), "vgs"); err != nil {
+		t.Errorf("free space of vg is not got by vgs, fault is left: %v", err)
+	}
+}
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_ExpandError(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-expand-error"
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"broken", "next", "other-vg"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &snapshotClassName,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	expanded := []string{}
+	lvs := []snapshotLV{
+		// the most urgent one fails, which must not block the others
+		&fakeSnapshotLV{name: "snap-broken", size: size, usage: 0.95, expanded: &expanded, expandErr: errors.New("Insufficient free space")},
+		&fakeSnapshotLV{name: "snap-next", size: size, usage: 0.9, expanded: &expanded},
+		&fakeSnapshotLV{name: "snap-other-vg", size: size, usage: 0.8, expanded: &expanded, vg: "open-local-pool-1"},
+	}
+	originList := listSnapshotLVs
+	listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+	defer func() { listSnapshotLVs = originList }()
+	defer fakeVGFreeSpace(1024 * size)()
+
+	d := &Discoverer{
+		Configuration:  &common.Configuration{},
+		snapclient:     fakeSnapClient,
+		snapshotUsages: map[string]snapshotUsageRecord{},
+	}
+	d.expandSnapshotLvmLVIfNeeded()
+	if want := []string{"snap-next", "snap-other-vg"}; !reflect.DeepEqual(expanded, want) {
+		t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, want)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// usageSample is used bytes of snapshot lv at a time
+type usageSample struct {
+	usedBytes uint64
+	timestamp time.Time
+}
+
+// usageRing keeps the latest usage samples of snapshot lv, the oldest is
+// overwritten once it is full so that memory is bounded by its capacity
+type usageRing struct {
+	samples []usageSample
+	// next is the index the next sample overwrites once ring is full
+	next int
+	// sizeInBytes is the size of lv at the last sample
+	sizeInBytes uint64
+}
+
+func newUsageRing(capacity int) *usageRing {
+	return &usageRing{samples: make([]usageSample, 0, capacity)}
+}
+
+func (r *usageRing) add(sample usageSample) {
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, sample)
+		return
+	}
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// ordered returns samples from the oldest to the latest
+func (r *usageRing) ordered() []usageSample {
+	ordered := make([]usageSample, 0, len(r.samples))
+	ordered = append(ordered, r.samples[r.next:]...)
+	return append(ordered, r.samples[:r.next]...)
+}
+
+func (r *usageRing) latest() (usageSample, bool) {
+	if len(r.samples) == 0 {
+		return usageSample{}, false
+	}
+	return r.samples[(r.next+len(r.samples)-1)%len(r.samples)], true
+}
+
+// snapshotTrendStore keeps usage samples of snapshot lvs sampled by snapshot
+// expansion, which are read by discovery to report fill trend in status
+type snapshotTrendStore struct {
+	lock     sync.Mutex
+	capacity int
+	interval time.Duration
+	// rings are keyed by vg/lv
+	rings map[string]*usageRing
+}
+
+// newSnapshotTrendStore returns nil if sampling is disabled
+func newSnapshotTrendStore(capacity int, interval time.Duration) *snapshotTrendStore {
+	if capacity <= 0 {
+		return nil
+	}
+	return &snapshotTrendStore{capacity: capacity, interval: interval, rings: make(map[string]*usageRing)}
+}
+
+// sample records usage of snapshot lvs at now unless the last sample is less
+// than interval ago, samples of snapshot lvs gone are dropped
+func (s *snapshotTrendStore) sample(lvs []snapshotLV, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rings := make(map[string]*usageRing, len(lvs))
+	for _, lv := range lvs {
+		key := utils.GetNameKey(lv.VGName(), lv.Name())
+		ring, exist := s.rings[key]
+		if !exist {
+			ring = newUsageRing(s.capacity)
+		}
+		rings[key] = ring
+		if last, ok := ring.latest(); ok && now.Sub(last.timestamp) < s.interval {
+			continue
+		}
+		ring.add(usageSample{usedBytes: uint64(lv.Usage() * float64(lv.SizeInBytes())), timestamp: now})
+		ring.sizeInBytes = lv.SizeInBytes()
+	}
+	s.rings = rings
+}
+
+// trend returns fill trend of snapshot lv name in vg, nil if it is never sampled
+func (s *snapshotTrendStore) trend(vgName, lvName string) *localv1alpha1.SnapshotUsageTrend {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ring, exist := s.rings[utils.GetNameKey(vgName, lvName)]
+	if !exist || len(ring.samples) == 0 {
+		return nil
+	}
+	trend := computeSnapshotUsageTrend(ring.ordered(), ring.sizeInBytes)
+	return &trend
+}
+
+// computeSnapshotUsageTrend fits used bytes of samples ordered by time with
+// least squares, the slope is the fill rate. Time to full is estimated from
+// the latest sample, it is absent if usage is flat or shrinking
+func computeSnapshotUsageTrend(samples []usageSample, sizeInBytes uint64) localv1alpha1.SnapshotUsageTrend {
+	latest := samples[len(samples)-1]
+	lastSampleTime := metav1.NewTime(latest.timestamp)
+	trend := localv1alpha1.SnapshotUsageTrend{
+		Samples:        int32(len(samples)),
+		Used:           latest.usedBytes,
+		LastSampleTime: &lastSampleTime,
+	}
+	rate := fillRate(samples)
+	if rate <= 0 {
+		return trend
+	}
+	trend.FillRate = uint64(rate)
+	var secondsToFull int64
+	if sizeInBytes > latest.usedBytes {
+		secondsToFull = int64(math.Ceil(float64(sizeInBytes-latest.usedBytes) / rate))
+	}
+	trend.SecondsToFull = &secondsToFull
+	return trend
+}
+
+// fillRate returns slope of used bytes over seconds, 0 if samples span no time
+func fillRate(samples []usageSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	// relative to the first sample to keep precision
+	origin := samples[0]
+	var sumX, sumY float64
+	for _, sample := range samples {
+		sumX += sample.timestamp.Sub(origin.timestamp).Seconds()
+		sumY += float64(sample.usedBytes) - float64(origin.usedBytes)
+	}
+	n := float64(len(samples))
+	meanX, meanY := sumX/n, sumY/n
+	var covariance, variance float64
+	for _, sample := range samples {
+		dx := sample.timestamp.Sub(origin.timestamp).Seconds() - meanX
+		dy := float64(sample.usedBytes) - float64(origin.usedBytes) - meanY
+		covariance += dx * dy
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0
+	}
+	return covariance / variance
+}
+
+// setSnapshotUsageTrends reports fill trend of every sampled snapshot lv in status
+func (d *Discoverer) setSnapshotUsageTrends(status *localv1alpha1.NodeLocalStorageStatus) {
+	if d.snapshotTrends == nil {
+		return
+	}
+	vgs := status.NodeStorageInfo.VolumeGroups
+	for i := range vgs {
+		for j := range vgs[i].LogicalVolumes {
+			lv := &vgs[i].LogicalVolumes[j]
+			if lv.Origin != "" {
+				lv.UsageTrend = d.snapshotTrends.trend(vgs[i].Name, lv.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func Test_computeSnapshotUsageTrend(t *testing.T) {
+	const mi = uint64(1024 * 1024)
+	start := time.Date(2021, 10, 21, 8, 0, 0, 0, time.UTC)
+	// series returns used bytes sampled every minute
+	series := func(used ...uint64) []usageSample {
+		samples := make([]usageSample, 0, len(used))
+		for i, u := range used {
+			samples = append(samples, usageSample{usedBytes: u, timestamp: start.Add(time.Duration(i) * time.Minute)})
+		}
+		return samples
+	}
+	tests := []struct {
+		name              string
+		samples           []usageSample
+		size              uint64
+		wantFillRate      uint64
+		wantSecondsToFull *int64
+	}{
+		{
+			name:    "test single sample",
+			samples: series(10 * mi),
+			size:    100 * mi,
+		},
+		{
+			// never full
+			name:    "test flat usage",
+			samples: series(10*mi, 10*mi, 10*mi, 10*mi),
+			size:    100 * mi,
+		},
+		{
+			// 6MiB per minute, 60MiB left
+			name:              "test linear growth",
+			samples:           series(10*mi, 16*mi, 22*mi, 28*mi, 34*mi, 40*mi),
+			size:              100 * mi,
+			wantFillRate:      6 * mi / 60,
+			wantSecondsToFull: int64Ptr(600),
+		},
+		{
+			// bursts are averaged by least squares
+			name:              "test noisy growth",
+			samples:           series(10*mi, 20*mi, 20*mi, 40*mi, 40*mi),
+			size:              100 * mi,
+			wantFillRate:      8 * mi / 60,
+			wantSecondsToFull: int64Ptr(450),
+		},
+		{
+			name:              "test already full",
+			samples:           series(90*mi, 100*mi),
+			size:              100 * mi,
+			wantFillRate:      10 * mi / 60,
+			wantSecondsToFull: int64Ptr(0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trend := computeSnapshotUsageTrend(tt.samples, tt.size)
+			if trend.Samples != int32(len(tt.samples)) || trend.Used != tt.samples[len(tt.samples)-1].usedBytes {
+				t.Errorf("computeSnapshotUsageTrend() samples = %d, used = %d", trend.Samples, trend.Used)
+			}
+			if trend.FillRate != tt.wantFillRate {
+				t.Errorf("computeSnapshotUsageTrend() fillRate = %d, want %d", trend.FillRate, tt.wantFillRate)
+			}
+			if (trend.SecondsToFull == nil) != (tt.wantSecondsToFull == nil) ||
+				(trend.SecondsToFull != nil && *trend.SecondsToFull != *tt.wantSecondsToFull) {
+				t.Errorf("computeSnapshotUsageTrend() secondsToFull = %v, want %v", secondsString(trend.SecondsToFull), secondsString(tt.wantSecondsToFull))
+			}
+		})
+	}
+}
+
+func Test_snapshotTrendStore(t *testing.T) {
+	const mi = uint64(1024 * 1024)
+	start := time.Date(2021, 10, 21, 8, 0, 0, 0, time.UTC)
+	store := newSnapshotTrendStore(3, time.Minute)
+	snap := &fakeSnapshotLV{name: "snap-a", size: 100 * mi}
+	other := &fakeSnapshotLV{name: "snap-b", size: 100 * mi}
+	for i := 0; i < 5; i++ {
+		snap.usage = float64(10*(i+1)) / 100
+		store.sample([]snapshotLV{snap, other}, start.Add(time.Duration(i)*time.Minute))
+		// sampled again within interval
+		store.sample([]snapshotLV{snap, other}, start.Add(time.Duration(i)*time.Minute+time.Second))
+	}
+	trend := store.trend("open-local-pool-0", "snap-a")
+	if trend == nil {
+		t.Fatalf("trend of snap-a is not sampled")
+	}
+	// only the latest 3 samples are kept
+	if trend.Samples != 3 || trend.Used != 50*mi || !trend.LastSampleTime.Time.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("trend of snap-a = %+v, want 3 samples with 50MiB used", trend)
+	}
+	if trend.FillRate != 10*mi/60 || trend.SecondsToFull == nil || *trend.SecondsToFull != 300 {
+		t.Errorf("trend of snap-a fillRate = %d, secondsToFull = %v", trend.FillRate, secondsString(trend.SecondsToFull))
+	}
+	// samples of removed snapshot are dropped
+	store.sample([]snapshotLV{snap}, start.Add(10*time.Minute))
+	if trend := store.trend("open-local-pool-0", "snap-b"); trend != nil {
+		t.Errorf("trend of removed snap-b = %+v, want nil", trend)
+	}
+	if store := newSnapshotTrendStore(0, time.Minute); store != nil {
+		t.Errorf("newSnapshotTrendStore() = %v, want nil if disabled", store)
+	}
+}
+
+func TestDiscoverer_setSnapshotUsageTrends(t *testing.T) {
+	start := time.Date(2021, 10, 21, 8, 0, 0, 0, time.UTC)
+	d := &Discoverer{snapshotTrends: newSnapshotTrendStore(10, 0)}
+	d.snapshotTrends.sample([]snapshotLV{&fakeSnapshotLV{name: "snap-a", size: 1024, usage: 0.5}}, start)
+	status := &localv1alpha1.NodeLocalStorageStatus{}
+	status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{{
+		Name: "open-local-pool-0",
+		LogicalVolumes: []localv1alpha1.LogicalVolume{
+			{Name: "local-a", Snapshots: []string{"snap-a"}},
+			{Name: "snap-a", Origin: "local-a"},
+		},
+	}}
+	d.setSnapshotUsageTrends(status)
+	lvs := status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes
+	if lvs[0].UsageTrend != nil {
+		t.Errorf("trend of origin = %+v, want nil", lvs[0].UsageTrend)
+	}
+	if trend := lvs[1].UsageTrend; trend == nil || trend.Used != 512 || trend.SecondsToFull != nil {
+		t.Errorf("trend of snap-a = %+v, want 512 bytes used without time to full", trend)
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func secondsString(i *int64) string {
+	if i == nil {
+		return "nil"
+	}
+	return time.Duration(*i * int64(time.Second)).String()
+}
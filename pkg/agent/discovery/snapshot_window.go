@@ -0,0 +1,77 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	"github.com/alibaba/open-local/pkg/utils"
+	log "k8s.io/klog/v2"
+)
+
+// snapshotExpansionSchedule is snapshotExpansionWindows of nls resolved by
+// the last discovery, snapshot expansion is not confined if it is empty
+type snapshotExpansionSchedule struct {
+	lock    sync.Mutex
+	windows []utils.TimeWindow
+}
+
+// set resolves windows of nls, the last resolved windows are kept if they
+// fail to resolve
+func (s *snapshotExpansionSchedule) set(values []string) {
+	if s == nil {
+		return
+	}
+	windows, err := utils.ParseTimeWindows(values)
+	if err != nil {
+		log.Warningf("resolve snapshot expansion windows error: %s", err.Error())
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.windows = windows
+}
+
+// inWindow is true if now falls in any window or no window is configured
+func (s *snapshotExpansionSchedule) inWindow(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.windows) == 0 || utils.InTimeWindows(s.windows, now)
+}
+
+// snapshotExpansionDue tells whether snapshot lv is to be expanded. Within
+// expansion windows it is once projected usage exceeds threshold, out of them
+// it is only once current usage exceeds emergency usage, so that lvextend of
+// many snapshots taken by backup is confined to windows while none overflows
+func (d *Discoverer) snapshotExpansionDue(lv snapshotLV, projectedUsage, threshold float64, inWindow bool) bool {
+	if inWindow {
+		return projectedUsage > threshold
+	}
+	emergency := d.SnapshotEmergencyUsage
+	if emergency <= 0 {
+		emergency = common.DefaultSnapshotEmergencyUsage
+	}
+	if emergency < threshold {
+		emergency = threshold
+	}
+	return lv.Usage() > emergency
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiscoverer_expandSnapshotLvmLVIfNeeded_Windows(t *testing.T) {
+	const size = 4 * 1024 * 1024 * 1024
+	snapshotClassName := "test-snapshotclass-windows"
+	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: snapshotClassName},
+		Parameters: map[string]string{
+			localtype.ParamReadonly:          "true",
+			localtype.ParamSnapshotThreshold: "70%",
+		},
+	}, metav1.CreateOptions{})
+	for _, id := range []string{"full", "rising", "above"} {
+		_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + id},
+			Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+				VolumeSnapshotClassName: &snapshotClassName,
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	tests := []struct {
+		name         string
+		windows      []string
+		emergency    float64
+		now          time.Time
+		wantExpanded []string
+	}{
+		{
+			name:         "test no window",
+			now:          time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+			wantExpanded: []string{"snap-full", "snap-rising", "snap-above"},
+		},
+		{
+			name:         "test in window",
+			windows:      []string{"01:00-05:00"},
+			now:          time.Date(2022, 6, 1, 3, 0, 0, 0, time.UTC),
+			wantExpanded: []string{"snap-full", "snap-rising", "snap-above"},
+		},
+		{
+			name:         "test out of window expands near full snapshot only",
+			windows:      []string{"01:00-05:00"},
+			now:          time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+			wantExpanded: []string{"snap-full"},
+		},
+		{
+			name:         "test out of window below emergency usage",
+			windows:      []string{"01:00-05:00"},
+			emergency:    0.97,
+			now:          time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+			wantExpanded: []string{},
+		},
+		{
+			name:         "test in window crossing midnight",
+			windows:      []string{"01:00-05:00", "22:00-00:30"},
+			now:          time.Date(2022, 6, 1, 0, 15, 0, 0, time.UTC),
+			wantExpanded: []string{"snap-full", "snap-rising", "snap-above"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded := []string{}
+			lvs := []snapshotLV{
+				// 95%, near full
+				&fakeSnapshotLV{name: "snap-full", size: size, usage: 0.95, expanded: &expanded},
+				// 60%, filled 30% in the last cycle
+				&fakeSnapshotLV{name: "snap-rising", size: size, usage: 0.6, expanded: &expanded},
+				// 80%, above threshold and stable
+				&fakeSnapshotLV{name: "snap-above", size: size, usage: 0.8, expanded: &expanded},
+			}
+			originList, originNow := listSnapshotLVs, timeNow
+			listSnapshotLVs = func() ([]snapshotLV, error) { return lvs, nil }
+			timeNow = func() time.Time { return tt.now }
+			defer func() { listSnapshotLVs, timeNow = originList, originNow }()
+			defer fakeVGFreeSpace(1024 * size)()
+
+			lastCycle := tt.now.Add(-60 * time.Second)
+			d := &Discoverer{
+				Configuration:    &common.Configuration{SnapshotProjectionWindow: 60, SnapshotEmergencyUsage: tt.emergency},
+				snapclient:       fakeSnapClient,
+				snapshotSchedule: &snapshotExpansionSchedule{},
+				snapshotUsages: map[string]snapshotUsageRecord{
+					"snap-full":   {usedBytes: 0.95 * size, timestamp: lastCycle},
+					"snap-rising": {usedBytes: 0.3 * size, timestamp: lastCycle},
+					"snap-above":  {usedBytes: 0.8 * size, timestamp: lastCycle},
+				},
+			}
+			d.snapshotSchedule.set(tt.windows)
+			d.expandSnapshotLvmLVIfNeeded()
+			if !reflect.DeepEqual(expanded, tt.wantExpanded) {
+				t.Errorf("expandSnapshotLvmLVIfNeeded() expanded = %v, want %v", expanded, tt.wantExpanded)
+			}
+		})
+	}
+}
+
+func Test_snapshotExpansionSchedule_set(t *testing.T) {
+	schedule := &snapshotExpansionSchedule{}
+	noon := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	schedule.set([]string{"01:00-05:00"})
+	if schedule.inWindow(noon) {
+		t.Errorf("inWindow(%v) = true, want false", noon)
+	}
+	// invalid windows keep the last resolved ones
+	schedule.set([]string{"noon"})
+	if schedule.inWindow(noon) {
+		t.Errorf("inWindow(%v) = true after invalid windows, want false", noon)
+	}
+	schedule.set(nil)
+	if !schedule.inWindow(noon) {
+		t.Errorf("inWindow(%v) = false without window, want true", noon)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+// minStatusRetryDelay is the minimum delay before a failed status update is retried
+const minStatusRetryDelay = time.Second
+
+// statusQueue coalesces status updates of nls. Only the latest status is kept
+// pending and it is written no sooner than interval after the last update,
+// so that rapid capacity changes collapse into one api call. Condition
+// changes are written at once
+type statusQueue struct {
+	lock     sync.Mutex
+	interval time.Duration
+	// update writes status of nls to api server
+	update func(nls *localv1alpha1.NodeLocalStorage) error
+	// pending is the latest status not written yet
+	pending *localv1alpha1.NodeLocalStorage
+	// updated is the status written last, nil before the first update
+	updated    *localv1alpha1.NodeLocalStorageStatus
+	lastUpdate time.Time
+	timer      *time.Timer
+	stopped    bool
+}
+
+func newStatusQueue(interval time.Duration, update func(nls *localv1alpha1.NodeLocalStorage) error) *statusQueue {
+	return &statusQueue{interval: interval, update: update}
+}
+
+// enqueue replaces pending status with that of nls. It is written at once if
+// immediate or the change is critical, and the error is returned. Otherwise
+// it is written later and failure is retried until a later update succeeds
+func (q *statusQueue) enqueue(nls *localv1alpha1.NodeLocalStorage, immediate bool) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending = nls.DeepCopy()
+	if immediate || q.interval == 0 || isCriticalStatusChange(q.updated, &nls.Status) {
+		return q.flushLocked()
+	}
+	q.scheduleLocked(time.Until(q.lastUpdate.Add(q.interval)))
+	return nil
+}
+
+// overlay returns nls with pending status, which is what agent publishes next
+func (q *statusQueue) overlay(nls *localv1alpha1.NodeLocalStorage) *localv1alpha1.NodeLocalStorage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.pending == nil {
+		return nls
+	}
+	nlsCopy := nls.DeepCopy()
+	nlsCopy.Status = *q.pending.Status.DeepCopy()
+	return nlsCopy
+}
+
+// lastUpdated returns the time status is written last, zero before the first update
+func (q *statusQueue) lastUpdated() time.Time {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.lastUpdate
+}
+
+// stop stops writing pending status later, immediate updates still work
+func (q *statusQueue) stop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.stopped = true
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+}
+
+func (q *statusQueue) scheduleLocked(delay time.Duration) {
+	if q.timer != nil || q.stopped {
+		return
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	q.timer = time.AfterFunc(delay, q.flush)
+}
+
+func (q *statusQueue) flush() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.timer = nil
+	if err := q.flushLocked(); err != nil {
+		log.Errorf("update status of nls error, retry later: %s", err.Error())
+	}
+}
+
+func (q *statusQueue) flushLocked() error {
+	if q.pending == nil {
+		return nil
+	}
+	if err := q.update(q.pending); err != nil {
+		delay := q.interval
+		if delay < minStatusRetryDelay {
+			delay = minStatusRetryDelay
+		}
+		q.scheduleLocked(delay)
+		return err
+	}
+	q.updated = q.pending.Status.DeepCopy()
+	q.lastUpdate = time.Now()
+	q.pending = nil
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	return nil
+}
+
+// isCriticalStatusChange returns true if status changes more than capacity
+// and io statistics, such as state of node storage, condition of vg, device
+// or mount point, storage filtered for scheduling, result of drift check or
+// build and config of agent
+func isCriticalStatusChange(updated, status *localv1alpha1.NodeLocalStorageStatus) bool {
+	if updated == nil {
+		return true
+	}
+	oldInfo, newInfo := updated.NodeStorageInfo, status.NodeStorageInfo
+	if oldInfo.Phase != newInfo.Phase || oldInfo.State.Type != newInfo.State.Type || oldInfo.State.Status != newInfo.State.Status {
+		return true
+	}
+	if driftState(oldInfo.StatusDrift) != driftState(newInfo.StatusDrift) {
+		return true
+	}
+	if activationPhase(oldInfo.LVActivation) != activationPhase(newInfo.LVActivation) {
+		return true
+	}
+	// new build or config of agent is verified at once on rollout
+	if !reflect.DeepEqual(oldInfo.Agent, newInfo.Agent) {
+		return true
+	}
+	return !reflect.DeepEqual(storageConditions(updated), storageConditions(status))
+}
+
+func driftState(drift *localv1alpha1.StatusDriftStatus) localv1alpha1.ConditionStatus {
+	if drift == nil {
+		return ""
+	}
+	return drift.State.Status
+}
+
+func activationPhase(activation *localv1alpha1.LVActivationStatus) localv1alpha1.LVActivationPhase {
+	if activation == nil {
+		return ""
+	}
+	return activation.Phase
+}
+
+// storageConditions returns conditions of storage reported in status keyed by
+// kind and name
+func storageConditions(status *localv1alpha1.NodeLocalStorageStatus) map[string]string {
+	conditions := map[string]string{}
+	for _, vg := range status.NodeStorageInfo.VolumeGroups {
+		conditions["vg/"+vg.Name] = fmt.Sprintf("%s,maintenance=%t", vg.Condition, vg.Maintenance)
+	}
+	for _, dev := range status.NodeStorageInfo.DeviceInfos {
+		conditions["device/"+dev.Name] = string(dev.Condition)
+	}
+	for _, mp := range status.NodeStorageInfo.MountPoints {
+		conditions["mountpoint/"+mp.Name] = string(mp.Condition)
+	}
+	for _, vg := range status.FilteredStorageInfo.VolumeGroups {
+		conditions["filtered/vg/"+vg] = ""
+	}
+	for _, dev := range status.FilteredStorageInfo.Devices {
+		conditions["filtered/device/"+dev] = ""
+	}
+	for _, mp := range status.FilteredStorageInfo.MountPoints {
+		conditions["filtered/mountpoint/"+mp] = ""
+	}
+	return conditions
+}
+
+// updateStatus writes status of nls. Status is owned by agent, so it is
+// written over nls changed since status is computed
+func (d *Discoverer) updateStatus(nls *localv1alpha1.NodeLocalStorage) error {
+	_, err := d.localclientset.CsiV1alpha1().NodeLocalStorages().UpdateStatus(context.Background(), nls, metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		return err
+	}
+	latest, err := d.localclientset.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), nls.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	latest.Status = *nls.Status.DeepCopy()
+	_, err = d.localclientset.CsiV1alpha1().NodeLocalStorages().UpdateStatus(context.Background(), latest, metav1.UpdateOptions{})
+	return err
+}
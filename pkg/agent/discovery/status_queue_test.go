@@ -0,0 +1,213 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// newStatusQueueTest returns discoverer whose status updates are coalesced
+// within interval, and a function counting status updates sent to api server
+func newStatusQueueTest(t *testing.T, interval time.Duration) (*Discoverer, *localfake.Clientset, func() int) {
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	localclient := localfake.NewSimpleClientset(nls)
+	var lock sync.Mutex
+	updates := 0
+	localclient.PrependReactor("update", "nodelocalstorages", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "status" {
+			lock.Lock()
+			updates++
+			lock.Unlock()
+		}
+		return false, nil, nil
+	})
+	d := NewDiscoverer(&common.Configuration{Nodename: "test-node"},
+		k8sfake.NewSimpleClientset(), localclient, nil, record.NewFakeRecorder(10))
+	d.statusQueue = newStatusQueue(interval, d.updateStatus)
+	t.Cleanup(d.statusQueue.stop)
+	return d, localclient, func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return updates
+	}
+}
+
+// statusQueueTestNLS returns nls with vg1 of available and condition
+func statusQueueTestNLS(available uint64, condition localv1alpha1.StorageConditionType) *localv1alpha1.NodeLocalStorage {
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	vg := driftTestVG(available)
+	vg.Condition = condition
+	nls.Status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{vg}
+	nls.Status.FilteredStorageInfo.VolumeGroups = []string{"vg1"}
+	return nls
+}
+
+func publishedVG(t *testing.T, localclient *localfake.Clientset) localv1alpha1.VolumeGroup {
+	nls, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get nls: %s", err.Error())
+	}
+	if len(nls.Status.NodeStorageInfo.VolumeGroups) != 1 {
+		t.Fatalf("vgs in status = %+v, want vg1 only", nls.Status.NodeStorageInfo.VolumeGroups)
+	}
+	return nls.Status.NodeStorageInfo.VolumeGroups[0]
+}
+
+func TestStatusQueue_Coalesce(t *testing.T) {
+	d, localclient, updates := newStatusQueueTest(t, 200*time.Millisecond)
+
+	const changes = 20
+	for i := 0; i < changes; i++ {
+		if err := d.statusQueue.enqueue(statusQueueTestNLS(uint64(900-i), localv1alpha1.StorageReady), false); err != nil {
+			t.Fatalf("enqueue() error = %v", err)
+		}
+	}
+	// the first status is written at once, the rest are coalesced
+	if got := updates(); got != 1 {
+		t.Errorf("status updates before interval = %d, want 1", got)
+	}
+	pending := func() bool {
+		d.statusQueue.lock.Lock()
+		defer d.statusQueue.lock.Unlock()
+		return d.statusQueue.pending != nil
+	}
+	for i := 0; pending(); i++ {
+		if i >= 200 {
+			t.Fatalf("pending status is not written in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := updates(); got >= changes || got < 2 {
+		t.Errorf("status updates of %d changes = %d, want fewer than changes and at least 2", changes, got)
+	}
+	if got := publishedVG(t, localclient); got.Available != 900-changes+1 {
+		t.Errorf("available of vg1 in status = %d, want %d", got.Available, 900-changes+1)
+	}
+}
+
+func TestStatusQueue_CriticalBypass(t *testing.T) {
+	d, localclient, updates := newStatusQueueTest(t, time.Hour)
+
+	if err := d.statusQueue.enqueue(statusQueueTestNLS(900, localv1alpha1.StorageReady), false); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	// capacity change waits for interval
+	if err := d.statusQueue.enqueue(statusQueueTestNLS(800, localv1alpha1.StorageReady), false); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	if got := updates(); got != 1 {
+		t.Errorf("status updates after capacity change = %d, want 1", got)
+	}
+	if got := publishedVG(t, localclient); got.Available != 900 {
+		t.Errorf("available of vg1 in status = %d, want coalesced 900", got.Available)
+	}
+	// condition change is written at once with the latest capacity
+	if err := d.statusQueue.enqueue(statusQueueTestNLS(0, localv1alpha1.StorageFull), false); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	if got := updates(); got != 2 {
+		t.Errorf("status updates after condition change = %d, want 2", got)
+	}
+	if got := publishedVG(t, localclient); got.Available != 0 || got.Condition != localv1alpha1.StorageFull {
+		t.Errorf("vg1 in status = %+v, want available 0 and condition %s", got, localv1alpha1.StorageFull)
+	}
+	// immediate update bypasses coalescing as well
+	if err := d.statusQueue.enqueue(statusQueueTestNLS(100, localv1alpha1.StorageFull), true); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	if got := publishedVG(t, localclient); updates() != 3 || got.Available != 100 {
+		t.Errorf("status updates = %d and available of vg1 = %d after immediate update, want 3 and 100", updates(), got.Available)
+	}
+}
+
+func Test_isCriticalStatusChange(t *testing.T) {
+	updated := statusQueueTestNLS(900, localv1alpha1.StorageReady).Status
+	tests := []struct {
+		name   string
+		change func(status *localv1alpha1.NodeLocalStorageStatus)
+		want   bool
+	}{
+		{
+			name: "test capacity change",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.VolumeGroups[0].Available = 100
+				status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes = []localv1alpha1.LogicalVolume{{Name: "lv1", Total: 800}}
+			},
+			want: false,
+		},
+		{
+			name: "test vg condition change",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.VolumeGroups[0].Condition = localv1alpha1.StorageMetadataLow
+			},
+			want: true,
+		},
+		{
+			name: "test vg maintenance change",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.VolumeGroups[0].Maintenance = true
+			},
+			want: true,
+		},
+		{
+			name: "test vg removed",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.VolumeGroups = nil
+				status.FilteredStorageInfo.VolumeGroups = nil
+			},
+			want: true,
+		},
+		{
+			name: "test device hot",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.DeviceInfos = []localv1alpha1.DeviceInfo{{Name: "/dev/sdb", Condition: localv1alpha1.StorageHot}}
+			},
+			want: true,
+		},
+		{
+			name: "test status drift detected",
+			change: func(status *localv1alpha1.NodeLocalStorageStatus) {
+				status.NodeStorageInfo.StatusDrift = &localv1alpha1.StatusDriftStatus{State: localv1alpha1.StorageState{Status: localv1alpha1.ConditionTrue}}
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := updated.DeepCopy()
+			tt.change(status)
+			if got := isCriticalStatusChange(&updated, status); got != tt.want {
+				t.Errorf("isCriticalStatusChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !isCriticalStatusChange(nil, &updated) {
+		t.Errorf("isCriticalStatusChange() of the first status = false, want true")
+	}
+}
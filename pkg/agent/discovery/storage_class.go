@@ -0,0 +1,43 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+// storageClassServiceability matches storage classes against storage in
+// status of nls, nothing is reported if storage classes fail to list so that
+// scheduler never filters node by a stale result
+func (d *Discoverer) storageClassServiceability(nls *localv1alpha1.NodeLocalStorage) *localv1alpha1.StorageClassServiceability {
+	list, err := d.kubeclientset.StorageV1().StorageClasses().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		log.Warningf("list storage classes error: %s", err.Error())
+		return nil
+	}
+	scs := make([]*storagev1.StorageClass, 0, len(list.Items))
+	for i := range list.Items {
+		scs = append(scs, &list.Items[i])
+	}
+	return utils.StorageClassServiceability(nls, scs)
+}
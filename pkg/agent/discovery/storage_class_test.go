@@ -0,0 +1,66 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDiscoverer_storageClassServiceability(t *testing.T) {
+	lvmClass := func(name, vgName string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: name},
+			Provisioner: localtype.ProvisionerName,
+			Parameters:  map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeLVM), localtype.ParamVGName: vgName},
+		}
+	}
+	client := kubefake.NewSimpleClientset(
+		lvmClass("open-local-lvm", ""),
+		lvmClass("open-local-pool-0", "pool-0"),
+		lvmClass("open-local-pool-1", "pool-1"),
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "nas"}, Provisioner: "nasplugin.csi.alibabacloud.com"},
+	)
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	nls.Status.FilteredStorageInfo.VolumeGroups = []string{"pool-0"}
+	d := &Discoverer{kubeclientset: client}
+
+	want := &localv1alpha1.StorageClassServiceability{
+		Serviceable:   []string{"open-local-lvm", "open-local-pool-0"},
+		Unserviceable: []string{"open-local-pool-1"},
+	}
+	if got := d.storageClassServiceability(nls); !reflect.DeepEqual(got, want) {
+		t.Errorf("storageClassServiceability() = %+v, want %+v", got, want)
+	}
+
+	// nothing is reported if storage classes fail to list
+	client.PrependReactor("list", "storageclasses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+	if got := d.storageClassServiceability(nls); got != nil {
+		t.Errorf("storageClassServiceability() = %+v with list error, want nil", got)
+	}
+}
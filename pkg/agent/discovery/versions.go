@@ -0,0 +1,56 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"os"
+	"strings"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	log "k8s.io/klog/v2"
+)
+
+const kernelReleaseFile = "/proc/sys/kernel/osrelease"
+
+// CollectVersions reads versions of lvm2, device-mapper and kernel once when
+// agent starts, they are reported in every status afterwards. A version
+// failed to read is left empty
+func (d *Discoverer) CollectVersions() {
+	versions := &localv1alpha1.StorageVersions{}
+	if v, err := d.readLVMVersion(); err != nil {
+		log.Warningf("[CollectVersions]failed to get lvm version: %s", err.Error())
+	} else {
+		versions.LVM = v.LVM
+		versions.Library = v.Library
+		versions.Driver = v.Driver
+	}
+	if release, err := d.readKernelRelease(); err != nil {
+		log.Warningf("[CollectVersions]failed to get kernel release: %s", err.Error())
+	} else {
+		versions.Kernel = release
+	}
+	log.Infof("[CollectVersions]lvm %s, library %s, driver %s, kernel %s", versions.LVM, versions.Library, versions.Driver, versions.Kernel)
+	d.versions = versions
+}
+
+func readKernelRelease() (string, error) {
+	content, err := os.ReadFile(kernelReleaseFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+)
+
+func TestDiscoverer_CollectVersions(t *testing.T) {
+	lvmVersion := lvm.Version{LVM: "2.03.11(2)", Library: "1.02.175", Driver: "4.45.0"}
+	tests := []struct {
+		name       string
+		lvmErr     error
+		kernelErr  error
+		wantResult *localv1alpha1.StorageVersions
+	}{
+		{
+			name:       "test all versions",
+			wantResult: &localv1alpha1.StorageVersions{LVM: "2.03.11(2)", Library: "1.02.175", Driver: "4.45.0", Kernel: "5.10.0"},
+		},
+		{
+			name:       "test lvm version failed",
+			lvmErr:     errors.New("lvm: command not found"),
+			wantResult: &localv1alpha1.StorageVersions{Kernel: "5.10.0"},
+		},
+		{
+			name:       "test kernel release failed",
+			kernelErr:  errors.New("no such file"),
+			wantResult: &localv1alpha1.StorageVersions{LVM: "2.03.11(2)", Library: "1.02.175", Driver: "4.45.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Discoverer{
+				readLVMVersion: func() (lvm.Version, error) {
+					if tt.lvmErr != nil {
+						return lvm.Version{}, tt.lvmErr
+					}
+					return lvmVersion, nil
+				},
+				readKernelRelease: func() (string, error) {
+					if tt.kernelErr != nil {
+						return "", tt.kernelErr
+					}
+					return "5.10.0", nil
+				},
+			}
+			d.CollectVersions()
+			if !reflect.DeepEqual(d.versions, tt.wantResult) {
+				t.Errorf("CollectVersions() = %+v, want %+v", d.versions, tt.wantResult)
+			}
+		})
+	}
+}
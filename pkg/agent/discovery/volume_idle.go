@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lvActivity is io counters of lv read in the last discovery and when io was
+// last observed on it
+type lvActivity struct {
+	reads  uint64
+	writes uint64
+	// idleSince is the time of the last discovery observing any io
+	idleSince time.Time
+	// lastWrite is the time of the last discovery observing writes, zero if
+	// no write is observed
+	lastWrite time.Time
+}
+
+// trackLVActivity compares io counters of every lv in status which is not a
+// snapshot with the last discovery if enabled. io is sampled once a discovery,
+// so times are estimated to the interval. Lv first seen by agent is taken as
+// idle since now, or since the time published in vgs by the last agent
+func (d *Discoverer) trackLVActivity(status *localv1alpha1.NodeLocalStorageStatus, published []localv1alpha1.VolumeGroup) error {
+	if !d.VolumeIdleTracking {
+		return nil
+	}
+	stats, err := d.readDiskStats()
+	if err != nil {
+		return fmt.Errorf("read diskstats error: %s", err.Error())
+	}
+	now := timeNow()
+	publishedLVs := make(map[string]localv1alpha1.LogicalVolume)
+	for _, vg := range published {
+		for _, lv := range vg.LogicalVolumes {
+			publishedLVs[utils.GetNameKey(vg.Name, lv.Name)] = lv
+		}
+	}
+	activities := make(map[string]*lvActivity)
+	for _, vg := range status.NodeStorageInfo.VolumeGroups {
+		for _, lv := range vg.LogicalVolumes {
+			if lv.Origin != "" {
+				continue
+			}
+			s, exist := stats[kernelDeviceName(filepath.Join("/dev", vg.Name, lv.Name))]
+			if !exist {
+				continue
+			}
+			key := utils.GetNameKey(vg.Name, lv.Name)
+			activity, tracked := d.lvActivities[key]
+			switch {
+			case !tracked:
+				activity = &lvActivity{idleSince: now}
+				if publishedLV, ok := publishedLVs[key]; ok {
+					if publishedLV.IdleSince != nil {
+						activity.idleSince = publishedLV.IdleSince.Time
+					}
+					if publishedLV.LastWriteTime != nil {
+						activity.lastWrite = publishedLV.LastWriteTime.Time
+					}
+				}
+			case s.ReadsCompleted < activity.reads || s.WritesCompleted < activity.writes:
+				// counters restart once device of lv is recreated, io in
+				// between is unknown
+			default:
+				if s.WritesCompleted > activity.writes {
+					activity.lastWrite = now
+					activity.idleSince = now
+				}
+				if s.ReadsCompleted > activity.reads {
+					activity.idleSince = now
+				}
+			}
+			activity.reads, activity.writes = s.ReadsCompleted, s.WritesCompleted
+			activities[key] = activity
+		}
+	}
+	d.lvActivities = activities
+	return nil
+}
+
+// setLVIdleSince reports idle since and last write of every tracked lv in status
+func (d *Discoverer) setLVIdleSince(status *localv1alpha1.NodeLocalStorageStatus) {
+	vgs := status.NodeStorageInfo.VolumeGroups
+	for i := range vgs {
+		for j := range vgs[i].LogicalVolumes {
+			lv := &vgs[i].LogicalVolumes[j]
+			activity, exist := d.lvActivities[utils.GetNameKey(vgs[i].Name, lv.Name)]
+			if !exist {
+				continue
+			}
+			idleSince := metav1.NewTime(activity.idleSince)
+			lv.IdleSince = &idleSince
+			if !activity.lastWrite.IsZero() {
+				lastWrite := metav1.NewTime(activity.lastWrite)
+				lv.LastWriteTime = &lastWrite
+			}
+		}
+	}
+}
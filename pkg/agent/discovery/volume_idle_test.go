@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiscoverer_trackLVActivity(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(cycle int) time.Time { return start.Add(time.Duration(cycle) * time.Hour) }
+	// published by the last agent before it restarts
+	publishedIdleSince := metav1.NewTime(start.Add(-24 * time.Hour))
+	published := []localv1alpha1.VolumeGroup{
+		{
+			Name: "share",
+			LogicalVolumes: []localv1alpha1.LogicalVolume{
+				{Name: "cold", IdleSince: &publishedIdleSince, LastWriteTime: &publishedIdleSince},
+			},
+		},
+	}
+	cycles := []struct {
+		name          string
+		stats         map[string]deviceutil.DiskStats
+		wantIdleSince time.Time
+		wantLastWrite time.Time
+	}{
+		{
+			name:          "first seen is idle since now",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 10, WritesCompleted: 10}},
+			wantIdleSince: at(0),
+		},
+		{
+			name:          "no io keeps idle since",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 10, WritesCompleted: 10}},
+			wantIdleSince: at(0),
+		},
+		{
+			name:          "read is access",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 20, WritesCompleted: 10}},
+			wantIdleSince: at(2),
+		},
+		{
+			name:          "write is access and modify",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 20, WritesCompleted: 30}},
+			wantIdleSince: at(3),
+			wantLastWrite: at(3),
+		},
+		{
+			name:          "idle after write",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 20, WritesCompleted: 30}},
+			wantIdleSince: at(3),
+			wantLastWrite: at(3),
+		},
+		{
+			name:          "reset counters are not io",
+			stats:         map[string]deviceutil.DiskStats{"hot": {ReadsCompleted: 1, WritesCompleted: 1}},
+			wantIdleSince: at(3),
+			wantLastWrite: at(3),
+		},
+	}
+	d := &Discoverer{Configuration: &common.Configuration{VolumeIdleTracking: true}}
+	cycle := 0
+	d.readDiskStats = func() (map[string]deviceutil.DiskStats, error) {
+		stats := map[string]deviceutil.DiskStats{
+			"cold": {ReadsCompleted: 5, WritesCompleted: 5},
+			"snap": {ReadsCompleted: uint64(cycle), WritesCompleted: uint64(cycle)},
+		}
+		for name, s := range cycles[cycle].stats {
+			stats[name] = s
+		}
+		return stats, nil
+	}
+	originNow, originName := timeNow, kernelDeviceName
+	timeNow = func() time.Time { return at(cycle) }
+	kernelDeviceName = filepath.Base
+	defer func() { timeNow, kernelDeviceName = originNow, originName }()
+
+	for cycle = range cycles {
+		status := &localv1alpha1.NodeLocalStorageStatus{}
+		status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{
+			{
+				Name: "share",
+				LogicalVolumes: []localv1alpha1.LogicalVolume{
+					{Name: "hot"},
+					{Name: "cold"},
+					{Name: "snap", Origin: "hot"},
+				},
+			},
+		}
+		if err := d.trackLVActivity(status, published); err != nil {
+			t.Fatalf("%s: trackLVActivity() error = %v", cycles[cycle].name, err)
+		}
+		d.setLVIdleSince(status)
+		lvs := status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes
+		hot := lvs[0]
+		if hot.IdleSince == nil || !hot.IdleSince.Time.Equal(cycles[cycle].wantIdleSince) {
+			t.Errorf("%s: idle since of hot lv = %v, want %v", cycles[cycle].name, hot.IdleSince, cycles[cycle].wantIdleSince)
+		}
+		if cycles[cycle].wantLastWrite.IsZero() != (hot.LastWriteTime == nil) ||
+			(hot.LastWriteTime != nil && !hot.LastWriteTime.Time.Equal(cycles[cycle].wantLastWrite)) {
+			t.Errorf("%s: last write of hot lv = %v, want %v", cycles[cycle].name, hot.LastWriteTime, cycles[cycle].wantLastWrite)
+		}
+		// idle time published before restart is kept while no io is observed
+		cold := lvs[1]
+		if cold.IdleSince == nil || !cold.IdleSince.Equal(&publishedIdleSince) || cold.LastWriteTime == nil || !cold.LastWriteTime.Equal(&publishedIdleSince) {
+			t.Errorf("%s: cold lv = %+v, want idle since %v", cycles[cycle].name, cold, publishedIdleSince)
+		}
+		if snap := lvs[2]; snap.IdleSince != nil || snap.LastWriteTime != nil {
+			t.Errorf("%s: snapshot lv is tracked: %+v", cycles[cycle].name, snap)
+		}
+	}
+}
+
+func TestDiscoverer_trackLVActivity_Disabled(t *testing.T) {
+	d := &Discoverer{
+		Configuration: &common.Configuration{},
+		readDiskStats: func() (map[string]deviceutil.DiskStats, error) {
+			t.Fatalf("diskstats is read while idle tracking is disabled")
+			return nil, nil
+		},
+	}
+	status := &localv1alpha1.NodeLocalStorageStatus{}
+	status.NodeStorageInfo.VolumeGroups = []localv1alpha1.VolumeGroup{
+		{Name: "share", LogicalVolumes: []localv1alpha1.LogicalVolume{{Name: "hot"}}},
+	}
+	if err := d.trackLVActivity(status, nil); err != nil {
+		t.Fatalf("trackLVActivity() error = %v", err)
+	}
+	d.setLVIdleSince(status)
+	if lv := status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes[0]; lv.IdleSince != nil {
+		t.Errorf("idle since is reported while disabled: %v", lv.IdleSince)
+	}
+}
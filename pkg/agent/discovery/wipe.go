@@ -0,0 +1,126 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	log "k8s.io/klog/v2"
+)
+
+// deviceWipes records devices being wiped, so that a device is wiped by one
+// goroutine at a time
+type deviceWipes struct {
+	lock    sync.Mutex
+	running map[string]bool
+}
+
+func (w *deviceWipes) start(dev string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.running[dev] {
+		return false
+	}
+	w.running[dev] = true
+	return true
+}
+
+func (w *deviceWipes) done(dev string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.running, dev)
+}
+
+// setDevicesWiping reports devices listed in wiping annotation of nls as
+// wiping, which are not allocated by scheduler
+func setDevicesWiping(newStatus *localv1alpha1.NodeLocalStorageStatus, nls *localv1alpha1.NodeLocalStorage) {
+	wiping := utils.WipingDevices(nls)
+	for i := range newStatus.NodeStorageInfo.DeviceInfos {
+		if utils.ContainsString(wiping, newStatus.NodeStorageInfo.DeviceInfos[i].Name) {
+			newStatus.NodeStorageInfo.DeviceInfos[i].Condition = localv1alpha1.StorageWiping
+		}
+	}
+}
+
+// wipeDevices starts wiping devices listed in wiping annotation of nls which
+// are not being wiped. Wiping outlives discovery, device failed to be wiped
+// is wiped again by the next discovery and so is device whose wiping is
+// interrupted by restart of agent
+func (d *Discoverer) wipeDevices(nls *localv1alpha1.NodeLocalStorage) {
+	if d.InventoryOnly {
+		return
+	}
+	for _, dev := range utils.WipingDevices(nls) {
+		if !d.wipes.start(dev) {
+			continue
+		}
+		go func(dev string) {
+			defer d.wipes.done(dev)
+			if err := d.wipeDevice(dev); err != nil {
+				msg := fmt.Sprintf("wipe device %s failed, it is wiped again later: %s", dev, err.Error())
+				log.Error(msg)
+				d.recorder.Event(nls, corev1.EventTypeWarning, localtype.EventWipeDeviceFailed, msg)
+			}
+		}(dev)
+	}
+}
+
+// wipeDevice zeroes device and verifies it reads back zeros, device is
+// removed from wiping annotation of nls only if verification passes
+func (d *Discoverer) wipeDevice(dev string) error {
+	_, deregister := utils.LongOperations.Register(utils.OperationTypeWipe, "", dev, nil, nil)
+	defer deregister()
+	// wipefs of device by lvmd never runs along with zeroing
+	unlock, err := lvm.LockDevice(context.Background(), dev)
+	if err != nil {
+		return fmt.Errorf("fail to lock device: %s", err.Error())
+	}
+	log.Infof("[wipeDevice]start to wipe device %s", dev)
+	zeroErr := d.zeroDevice(dev)
+	unlock()
+	if zeroErr != nil {
+		return fmt.Errorf("fail to zero device: %s", zeroErr.Error())
+	}
+	if err := d.verifyZeroed(dev); err != nil {
+		return fmt.Errorf("fail to verify device is zeroed: %s", err.Error())
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		nls, err := d.localclientset.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), d.Nodename, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !utils.SetDeviceWiping(nls, dev, false) {
+			return nil
+		}
+		_, err = d.localclientset.CsiV1alpha1().NodeLocalStorages().Update(context.Background(), nls, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("device is zeroed but fail to remove it from annotation %s: %s", localtype.AnnoWipingDevices, err.Error())
+	}
+	log.Infof("[wipeDevice]device %s is wiped and verified", dev)
+	return nil
+}
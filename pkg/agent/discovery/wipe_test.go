@@ -0,0 +1,140 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/agent/common"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	"github.com/alibaba/open-local/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeWipes blocks zeroing until released and fails verification of devices
+// not zeroed completely
+type fakeWipes struct {
+	lock    sync.Mutex
+	release chan struct{}
+	zeroed  map[string]int
+	dirty   map[string]bool
+}
+
+func (f *fakeWipes) zero(dev string) error {
+	<-f.release
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.zeroed[dev]++
+	return nil
+}
+
+func (f *fakeWipes) verify(dev string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.dirty[dev] {
+		delete(f.dirty, dev)
+		return errors.New("not zeroed at offset 0")
+	}
+	return nil
+}
+
+func waitWipes(t *testing.T, d *Discoverer) {
+	for i := 0; i < 500; i++ {
+		d.wipes.lock.Lock()
+		running := len(d.wipes.running)
+		d.wipes.lock.Unlock()
+		if running == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("wiping does not finish")
+}
+
+func TestDiscoverer_wipeDevices(t *testing.T) {
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test-node",
+		Annotations: map[string]string{localtype.AnnoWipingDevices: "/dev/sdb,/dev/sdc"},
+	}}
+	localclient := localfake.NewSimpleClientset(nls)
+	recorder := record.NewFakeRecorder(10)
+	d := NewDiscoverer(&common.Configuration{Nodename: "test-node"}, k8sfake.NewSimpleClientset(), localclient, nil, recorder)
+	fake := &fakeWipes{release: make(chan struct{}), zeroed: map[string]int{}, dirty: map[string]bool{"/dev/sdc": true}}
+	d.zeroDevice = fake.zero
+	d.verifyZeroed = fake.verify
+	getNLS := func() *localv1alpha1.NodeLocalStorage {
+		got, err := localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), "test-node", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get nls: %s", err.Error())
+		}
+		return got
+	}
+
+	// devices stay wiping while zeroing runs, discovery does not wipe twice
+	d.wipeDevices(nls)
+	d.wipeDevices(nls)
+	status := &localv1alpha1.NodeLocalStorageStatus{}
+	status.NodeStorageInfo.DeviceInfos = []localv1alpha1.DeviceInfo{
+		{Name: "/dev/sda", Condition: localv1alpha1.StorageReady},
+		{Name: "/dev/sdb", Condition: localv1alpha1.StorageReady},
+		{Name: "/dev/sdc", Condition: localv1alpha1.StorageReady},
+	}
+	setDevicesWiping(status, nls)
+	for _, dev := range status.NodeStorageInfo.DeviceInfos {
+		wantWiping := dev.Name != "/dev/sda"
+		if (dev.Condition == localv1alpha1.StorageWiping) != wantWiping {
+			t.Errorf("condition of %s = %s while zeroing", dev.Name, dev.Condition)
+		}
+	}
+	for _, dev := range []string{"/dev/sdb", "/dev/sdc"} {
+		if !utils.IsDeviceWiping(getNLS(), dev) {
+			t.Errorf("%s is not wiping while zeroing", dev)
+		}
+	}
+
+	// device failed verification is kept wiping
+	close(fake.release)
+	waitWipes(t, d)
+	if got := utils.WipingDevices(getNLS()); !reflect.DeepEqual(got, []string{"/dev/sdc"}) {
+		t.Errorf("wiping devices after the first wipe = %v, want [/dev/sdc]", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Logf("event: %s", event)
+	default:
+		t.Errorf("no event of failed wiping is recorded")
+	}
+
+	// and is wiped again by the next discovery
+	d.wipeDevices(getNLS())
+	waitWipes(t, d)
+	if got := utils.WipingDevices(getNLS()); len(got) != 0 {
+		t.Errorf("wiping devices after the second wipe = %v, want none", got)
+	}
+	if want := map[string]int{"/dev/sdb": 1, "/dev/sdc": 2}; !reflect.DeepEqual(fake.zeroed, want) {
+		t.Errorf("zeroed = %v, want %v", fake.zeroed, want)
+	}
+}
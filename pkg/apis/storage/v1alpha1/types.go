@@ -100,6 +100,48 @@ type VGList struct {
 	// +kubebuilder:validation:MaxItems=50
 	// +kubebuilder:validation:UniqueItems=false
 	Exclude []string `json:"exclude,omitempty"`
+	// MaxLogicalVolumes is the maximum number of open-local logical volumes in one VG,
+	// VG reaching the limit is treated as full. 0 means unlimited
+	// +kubebuilder:validation:Minimum=0
+	MaxLogicalVolumes int `json:"maxLogicalVolumes,omitempty"`
+	// MaxSnapshotSize is the maximum total size of snapshot LVs on the node,
+	// absolute such as 100Gi or percentage of total size of VGs such as 30%.
+	// New snapshots and expansions beyond it are refused. Empty means unlimited
+	// +kubebuilder:validation:MaxLength=32
+	MaxSnapshotSize string `json:"maxSnapshotSize,omitempty"`
+	// MinFreeSize is the free space always kept in every VG, absolute such as
+	// 10Gi or percentage of total size of the VG such as 5%. Scheduler
+	// excludes it from capacity and new volumes and expansions breaching it
+	// are refused. Empty means no floor
+	// +kubebuilder:validation:MaxLength=32
+	MinFreeSize string `json:"minFreeSize,omitempty"`
+	// SnapshotExpansionWindows are daily time windows in UTC such as
+	// 01:00-05:00, snapshot LVs are expanded by projected usage within them
+	// and only near full snapshot LVs are expanded out of them. Empty means
+	// snapshot LVs are expanded by projected usage all the time
+	// +kubebuilder:validation:MaxItems=24
+	// +kubebuilder:validation:UniqueItems=false
+	SnapshotExpansionWindows []string `json:"snapshotExpansionWindows,omitempty"`
+	// Maintenance is the list of VG names under maintenance, no new volume
+	// is placed on them while existing volumes keep working
+	// +kubebuilder:validation:MaxItems=50
+	// +kubebuilder:validation:UniqueItems=false
+	Maintenance []string `json:"maintenance,omitempty"`
+	// Labels are labels of VGs, storage class selects VGs by csi.aliyun.com/vg-selector
+	// +kubebuilder:validation:MaxItems=50
+	// +kubebuilder:validation:UniqueItems=false
+	Labels []VGLabels `json:"labels,omitempty"`
+}
+
+// VGLabels defines labels of a VG
+type VGLabels struct {
+	// Name is the name of VG
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=128
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Labels are matched by selector in storage class, e.g. pool: fast
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type MountPointList struct {
@@ -142,7 +184,25 @@ type VGToBeInited struct {
 	// which will be initialized as Physical Volume
 	// +kubebuilder:validation:MaxItems=50
 	// +kubebuilder:validation:UniqueItems=false
-	Devices []string `json:"devices"`
+	Devices []string `json:"devices,omitempty"`
+	// LoopFiles are backing files of loop devices initialized as Physical
+	// Volumes besides Devices if loop devices are enabled in agent, which is
+	// for testing and development only, never for production
+	// +kubebuilder:validation:MaxItems=50
+	// +kubebuilder:validation:UniqueItems=false
+	LoopFiles []LoopFile `json:"loopFiles,omitempty"`
+}
+
+type LoopFile struct {
+	// Path is the absolute path of backing file on node, which is created
+	// as sparse file if absent
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+	// Size is the size of backing file, such as 10Gi, existing file smaller
+	// than it is extended but never shrunk
+	// +kubebuilder:validation:MinLength=1
+	Size string `json:"size"`
 }
 
 type MountPointToBeInited struct {
@@ -179,6 +239,123 @@ type NodeStorageInfo struct {
 	// State is the last state of node local storage.
 	// +optional
 	State StorageState `json:"state,omitempty"`
+	// LVActivation is the progress of activating inactive logical volumes
+	// when agent starts
+	// +optional
+	LVActivation *LVActivationStatus `json:"lvActivation,omitempty"`
+	// StatusDrift is the result of the last self check comparing status with
+	// lvm state read again
+	// +optional
+	StatusDrift *StatusDriftStatus `json:"statusDrift,omitempty"`
+	// Versions is the versions of storage stack read when agent starts
+	// +optional
+	Versions *StorageVersions `json:"versions,omitempty"`
+	// Agent is the build and effective discovery config of agent
+	// +optional
+	Agent *AgentInfo `json:"agent,omitempty"`
+	// StorageClasses is open-local storage classes matched against VGs, mount
+	// points and devices of node in the last discovery
+	// +optional
+	StorageClasses *StorageClassServiceability `json:"storageClasses,omitempty"`
+	// SnapshotCapacity is total size of snapshot LVs against maxSnapshotSize,
+	// reported only if maxSnapshotSize is set
+	// +optional
+	SnapshotCapacity *SnapshotCapacityStatus `json:"snapshotCapacity,omitempty"`
+	// DiscoveryPaused is true while discovery and snapshot expansion are
+	// paused by annotation csi.aliyun.com/discovery-paused
+	// +optional
+	DiscoveryPaused bool `json:"discoveryPaused,omitempty"`
+}
+
+// StorageClassServiceability is whether node provides storage of the kind
+// open-local storage classes ask for, regardless of free space
+type StorageClassServiceability struct {
+	// Serviceable is names of storage classes whose VG, mount point or
+	// device of required media type is present on node
+	// +optional
+	Serviceable []string `json:"serviceable,omitempty"`
+	// Unserviceable is names of storage classes node never provides volumes
+	// of until its storage changes, scheduler filters such node out at once
+	// +optional
+	Unserviceable []string `json:"unserviceable,omitempty"`
+}
+
+// SnapshotCapacityStatus is total size of snapshot LVs on node against the cap
+type SnapshotCapacityStatus struct {
+	// Limit is maxSnapshotSize resolved in bytes
+	Limit uint64 `json:"limit"`
+	// Used is the total size of snapshot LVs on node
+	Used uint64 `json:"used"`
+	// Condition is SnapshotLimitReached if Used reaches Limit, in which case
+	// new snapshots are refused and snapshot expansions are deferred
+	Condition StorageConditionType `json:"condition,omitempty"`
+}
+
+// StorageVersions is the versions of lvm2, device-mapper and kernel on node
+type StorageVersions struct {
+	// LVM is the version of lvm2 tools, such as 2.03.11(2)
+	// +optional
+	LVM string `json:"lvm,omitempty"`
+	// Library is the version of libdevmapper
+	// +optional
+	Library string `json:"library,omitempty"`
+	// Driver is the version of device-mapper driver in kernel
+	// +optional
+	Driver string `json:"driver,omitempty"`
+	// Kernel is the kernel release
+	// +optional
+	Kernel string `json:"kernel,omitempty"`
+}
+
+// AgentInfo is the build of agent and hash of the discovery config in effect
+type AgentInfo struct {
+	// Version is the release version agent is built with
+	// +optional
+	Version string `json:"version,omitempty"`
+	// GitCommit is the git commit agent is built from
+	// +optional
+	GitCommit string `json:"gitCommit,omitempty"`
+	// ConfigHash is the hash of discovery flags of agent, excluding node
+	// name, and .spec.listConfig of the nls, equal on nodes running the same
+	// config
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+}
+
+// StatusDriftStatus is the result of checking status of volume groups against
+// lvm state
+type StatusDriftStatus struct {
+	// State is of type StatusDrift, whose status is True if status diverged
+	// from lvm state beyond tolerance at the last check and was re-published.
+	// LastHeartbeatTime is the time of the last check
+	State StorageState `json:"state"`
+	// DriftCount is the number of drifts detected since agent started
+	DriftCount int64 `json:"driftCount"`
+}
+
+type LVActivationPhase string
+
+const (
+	// LVActivationRunning means logical volumes are being activated
+	LVActivationRunning LVActivationPhase = "Running"
+	// LVActivationCompleted means every logical volume is processed
+	LVActivationCompleted LVActivationPhase = "Completed"
+)
+
+// LVActivationStatus is the progress of activating logical volumes
+type LVActivationStatus struct {
+	// Phase is Running or Completed
+	Phase LVActivationPhase `json:"phase,omitempty"`
+	// Total is the number of inactive logical volumes to activate
+	Total int `json:"total"`
+	// Activated is the number of logical volumes activated
+	Activated int `json:"activated"`
+	// Failed lists logical volumes failed to activate, in form of <vg>/<lv>
+	Failed []string `json:"failed,omitempty"`
+	// StartTime is the time activation started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time every logical volume was processed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 type UpdateStatus string
@@ -225,6 +402,9 @@ type VolumeGroup struct {
 	Name string `json:"name"`
 	// PhysicalVolumes are Unix block device nodes,
 	PhysicalVolumes []string `json:"physicalVolumes"`
+	// PhysicalVolumeSpaces is the size and free space of each PV, free space
+	// of VG may be spread on PVs
+	PhysicalVolumeSpaces []PhysicalVolumeSpace `json:"physicalVolumeSpaces,omitempty"`
 	// LogicalVolumes "Virtual/logical partition" that resides in a VG
 	LogicalVolumes []LogicalVolume `json:"logicalVolumes,omitempty"`
 	// Total is the VG size
@@ -233,8 +413,72 @@ type VolumeGroup struct {
 	Available uint64 `json:"available"`
 	// Allocatable is the free size for Filtered
 	Allocatable uint64 `json:"allocatable"`
+	// SnapshotAwareAvailable is Available minus the size snapshot LVs in VG may
+	// still grow to, reported only if snapshot aware capacity is enabled
+	SnapshotAwareAvailable *uint64 `json:"snapshotAwareAvailable,omitempty"`
+	// LargestFreeExtentRun is the size of the largest contiguous free space in VG
+	LargestFreeExtentRun uint64 `json:"largestFreeExtentRun,omitempty"`
+	// ExtentSize is the size of a single physical extent of VG
+	ExtentSize uint64 `json:"extentSize,omitempty"`
+	// ExtentCount is the number of physical extents of VG
+	ExtentCount uint64 `json:"extentCount,omitempty"`
+	// FreeExtentCount is the number of free physical extents of VG
+	FreeExtentCount uint64 `json:"freeExtentCount,omitempty"`
+	// AllocationPolicy is the allocation policy of VG, e.g. normal, contiguous or cling
+	AllocationPolicy string `json:"allocationPolicy,omitempty"`
+	// MetadataFree is the free size of VG metadata area
+	MetadataFree uint64 `json:"metadataFree,omitempty"`
+	// MetadataSize is the size of VG metadata area
+	MetadataSize uint64 `json:"metadataSize,omitempty"`
+	// LogicalVolumeCount is the number of open-local logical volumes in VG,
+	// informational only since lv limit is checked by counting pvs
+	LogicalVolumeCount int `json:"logicalVolumeCount,omitempty"`
+	// Maintenance is true if VG is under maintenance
+	Maintenance bool `json:"maintenance,omitempty"`
+	// LoopBacked is true if any PV of VG is a loop device, such VG is for
+	// testing and development only and must not be used in production
+	LoopBacked bool `json:"loopBacked,omitempty"`
 	// Condition is the condition for Volume group
 	Condition StorageConditionType `json:"condition,omitempty"`
+	// IOStats is reported only if io statistics collection is enabled
+	IOStats *VolumeGroupIOStats `json:"ioStats,omitempty"`
+}
+
+// PhysicalVolumeSpace is the size and free space of a physical volume in VG
+type PhysicalVolumeSpace struct {
+	// Name is the PV device node
+	Name string `json:"name"`
+	// Total is the PV size
+	Total uint64 `json:"total"`
+	// Available is the free size of PV
+	Available uint64 `json:"available"`
+	// FreeExtentCount is the number of free physical extents of PV
+	FreeExtentCount uint64 `json:"freeExtentCount,omitempty"`
+}
+
+// VolumeGroupIOStats is the io statistics of all physical volumes in VG,
+// counters are cumulative since boot and rates are of the last discovery interval
+type VolumeGroupIOStats struct {
+	// ReadsCompleted is the number of reads completed
+	ReadsCompleted uint64 `json:"readsCompleted"`
+	// WritesCompleted is the number of writes completed
+	WritesCompleted uint64 `json:"writesCompleted"`
+	// ReadBytes is the number of bytes read
+	ReadBytes uint64 `json:"readBytes"`
+	// WriteBytes is the number of bytes written
+	WriteBytes uint64 `json:"writeBytes"`
+	// IOTimeMilliseconds is the time spent doing io, summed over physical volumes
+	IOTimeMilliseconds uint64 `json:"ioTimeMilliseconds"`
+	// ReadIOPS is the number of reads per second
+	ReadIOPS uint64 `json:"readIOPS,omitempty"`
+	// WriteIOPS is the number of writes per second
+	WriteIOPS uint64 `json:"writeIOPS,omitempty"`
+	// ReadBytesPerSecond is the number of bytes read per second
+	ReadBytesPerSecond uint64 `json:"readBytesPerSecond,omitempty"`
+	// WriteBytesPerSecond is the number of bytes written per second
+	WriteBytesPerSecond uint64 `json:"writeBytesPerSecond,omitempty"`
+	// UtilizationPercent is the busy time percent of the busiest physical volume
+	UtilizationPercent int `json:"utilizationPercent,omitempty"`
 }
 
 // LogicalVolume is an alias for LVM LV
@@ -249,6 +493,45 @@ type LogicalVolume struct {
 	ReadOnly bool `json:"readOnly,omitempty"`
 	// Condition is the condition for LogicalVolume
 	Condition StorageConditionType `json:"condition,omitempty"`
+	// Origin is the origin LV of snapshot LV, empty if the LV is not a snapshot
+	Origin string `json:"origin,omitempty"`
+	// Snapshots are names of snapshot LVs whose origin is the LV
+	Snapshots []string `json:"snapshots,omitempty"`
+	// SnapshotDepth is the number of origins from the snapshot LV up to the LV which
+	// is not a snapshot, 0 if the LV is not a snapshot
+	SnapshotDepth int32 `json:"snapshotDepth,omitempty"`
+	// PVName is the name of PersistentVolume backed by the LV
+	PVName string `json:"pvName,omitempty"`
+	// PVCNamespace is the namespace of PersistentVolumeClaim bound to the PV
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+	// PVCName is the name of PersistentVolumeClaim bound to the PV
+	PVCName string `json:"pvcName,omitempty"`
+	// Pods are names of pods on the node using the PVC
+	Pods []string `json:"pods,omitempty"`
+	// UsageTrend is the fill trend of snapshot LV, absent if the LV is not a snapshot
+	// or agent does not sample snapshot usage
+	UsageTrend *SnapshotUsageTrend `json:"usageTrend,omitempty"`
+	// IdleSince is the estimated time since which no io is observed on the LV, absent if
+	// agent does not track idle volumes
+	IdleSince *metav1.Time `json:"idleSince,omitempty"`
+	// LastWriteTime is the estimated time of the last write observed on the LV, absent if
+	// agent does not track idle volumes
+	LastWriteTime *metav1.Time `json:"lastWriteTime,omitempty"`
+}
+
+// SnapshotUsageTrend is the fill trend of snapshot LV computed from its recent usage samples
+type SnapshotUsageTrend struct {
+	// Samples is the number of usage samples the trend is computed from
+	Samples int32 `json:"samples"`
+	// Used is the used size of snapshot LV at the last sample
+	Used uint64 `json:"used"`
+	// FillRate is the bytes per second the snapshot LV fills, rounded down, 0 if usage is flat
+	FillRate uint64 `json:"fillRate"`
+	// SecondsToFull is the estimated seconds from the last sample until the snapshot LV is full
+	// at its current size, absent if usage is flat
+	SecondsToFull *int64 `json:"secondsToFull,omitempty"`
+	// LastSampleTime is the time of the last sample
+	LastSampleTime *metav1.Time `json:"lastSampleTime,omitempty"`
 }
 
 // MountPoint is the mount point on a node
@@ -277,6 +560,9 @@ type MountPoint struct {
 type DeviceInfo struct {
 	// Name is the block device name
 	Name string `json:"name,omitempty"` /* /dev/sda*/
+	// Parent is the whole disk if the device is a partition
+	// +optional
+	Parent string `json:"parent,omitempty"`
 	// MediaType is the media type like ssd/hdd
 	MediaType string `json:"mediaType,omitempty"` /*ssd,hdd*/
 	// Total is the raw block device size
@@ -285,6 +571,23 @@ type DeviceInfo struct {
 	ReadOnly bool `json:"readOnly"`
 	// Condition is the condition for mount point
 	Condition StorageConditionType `json:"condition,omitempty"`
+	// Temperature is reported only if temperature monitoring is enabled and
+	// device reports it
+	Temperature *DeviceTemperature `json:"temperature,omitempty"`
+	// Serial is the serial number of whole disk, empty if disk exposes none
+	// +optional
+	Serial string `json:"serial,omitempty"`
+	// WWN is the world wide name(wwid) of whole disk, empty if disk exposes none
+	// +optional
+	WWN string `json:"wwn,omitempty"`
+}
+
+// DeviceTemperature is the temperature of device in celsius
+type DeviceTemperature struct {
+	// Current is the current temperature of device
+	Current int64 `json:"current"`
+	// Critical is the critical temperature of device, 0 means unknown
+	Critical int64 `json:"critical,omitempty"`
 }
 
 type StorageConditionType string
@@ -301,6 +604,36 @@ const (
 
 	// StorageFault means some disks are under disk failure
 	StorageFault StorageConditionType = "DiskFault"
+
+	// StorageMetadataLow means metadata area of VG is nearly full
+	StorageMetadataLow StorageConditionType = "MetadataLow"
+
+	// StorageSuspended means device-mapper target of LV is suspended, IO and
+	// lvm operations on it are blocked
+	StorageSuspended StorageConditionType = "Suspended"
+
+	// StoragePendingDeletion means snapshot LV failed to be removed because
+	// its origin is busy, it is removed by agent once origin is released
+	StoragePendingDeletion StorageConditionType = "PendingDeletion"
+
+	// StorageHot means temperature of device exceeds the configured threshold
+	StorageHot StorageConditionType = "DiskHot"
+
+	// StorageStatusDrift means status of node local storage diverged from
+	// lvm state of node
+	StorageStatusDrift StorageConditionType = "StatusDrift"
+
+	// StorageSnapshotLimitReached means total size of snapshot LVs on node
+	// reaches maxSnapshotSize
+	StorageSnapshotLimitReached StorageConditionType = "SnapshotLimitReached"
+
+	// StorageWiping means device released with wipe on delete is being zeroed
+	// or verified, it is not allocated until wiping completes
+	StorageWiping StorageConditionType = "Wiping"
+
+	// StorageInsufficientPrivileges means agent lacks privileges to run lvm
+	// and mount operations, it refuses every mutating operation
+	StorageInsufficientPrivileges StorageConditionType = "InsufficientPrivileges"
 )
 
 // The below types are used by kube_client and api_server.
@@ -26,9 +26,30 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentInfo) DeepCopyInto(out *AgentInfo) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentInfo.
+func (in *AgentInfo) DeepCopy() *AgentInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeviceInfo) DeepCopyInto(out *DeviceInfo) {
 	*out = *in
+	if in.Temperature != nil {
+		in, out := &in.Temperature, &out.Temperature
+		*out = new(DeviceTemperature)
+		**out = **in
+	}
 	return
 }
 
@@ -68,6 +89,22 @@ func (in *DeviceList) DeepCopy() *DeviceList {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceTemperature) DeepCopyInto(out *DeviceTemperature) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceTemperature.
+func (in *DeviceTemperature) DeepCopy() *DeviceTemperature {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceTemperature)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FilteredStorageInfo) DeepCopyInto(out *FilteredStorageInfo) {
 	*out = *in
@@ -119,6 +156,35 @@ func (in *GlobalConfig) DeepCopy() *GlobalConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LVActivationStatus) DeepCopyInto(out *LVActivationStatus) {
+	*out = *in
+	if in.Failed != nil {
+		in, out := &in.Failed, &out.Failed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LVActivationStatus.
+func (in *LVActivationStatus) DeepCopy() *LVActivationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LVActivationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ListConfig) DeepCopyInto(out *ListConfig) {
 	*out = *in
@@ -141,6 +207,29 @@ func (in *ListConfig) DeepCopy() *ListConfig {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LogicalVolume) DeepCopyInto(out *LogicalVolume) {
 	*out = *in
+	if in.Snapshots != nil {
+		in, out := &in.Snapshots, &out.Snapshots
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UsageTrend != nil {
+		in, out := &in.UsageTrend, &out.UsageTrend
+		*out = new(SnapshotUsageTrend)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IdleSince != nil {
+		in, out := &in.IdleSince, &out.IdleSince
+		*out = (*in).DeepCopy()
+	}
+	if in.LastWriteTime != nil {
+		in, out := &in.LastWriteTime, &out.LastWriteTime
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -154,6 +243,22 @@ func (in *LogicalVolume) DeepCopy() *LogicalVolume {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LoopFile) DeepCopyInto(out *LoopFile) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LoopFile.
+func (in *LoopFile) DeepCopy() *LoopFile {
+	if in == nil {
+		return nil
+	}
+	out := new(LoopFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MountPoint) DeepCopyInto(out *MountPoint) {
 	*out = *in
@@ -434,7 +539,9 @@ func (in *NodeStorageInfo) DeepCopyInto(out *NodeStorageInfo) {
 	if in.DeviceInfos != nil {
 		in, out := &in.DeviceInfos, &out.DeviceInfos
 		*out = make([]DeviceInfo, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.VolumeGroups != nil {
 		in, out := &in.VolumeGroups, &out.VolumeGroups
@@ -451,6 +558,36 @@ func (in *NodeStorageInfo) DeepCopyInto(out *NodeStorageInfo) {
 		}
 	}
 	in.State.DeepCopyInto(&out.State)
+	if in.LVActivation != nil {
+		in, out := &in.LVActivation, &out.LVActivation
+		*out = new(LVActivationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StatusDrift != nil {
+		in, out := &in.StatusDrift, &out.StatusDrift
+		*out = new(StatusDriftStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = new(StorageVersions)
+		**out = **in
+	}
+	if in.Agent != nil {
+		in, out := &in.Agent, &out.Agent
+		*out = new(AgentInfo)
+		**out = **in
+	}
+	if in.StorageClasses != nil {
+		in, out := &in.StorageClasses, &out.StorageClasses
+		*out = new(StorageClassServiceability)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SnapshotCapacity != nil {
+		in, out := &in.SnapshotCapacity, &out.SnapshotCapacity
+		*out = new(SnapshotCapacityStatus)
+		**out = **in
+	}
 	return
 }
 
@@ -464,6 +601,22 @@ func (in *NodeStorageInfo) DeepCopy() *NodeStorageInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PhysicalVolumeSpace) DeepCopyInto(out *PhysicalVolumeSpace) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PhysicalVolumeSpace.
+func (in *PhysicalVolumeSpace) DeepCopy() *PhysicalVolumeSpace {
+	if in == nil {
+		return nil
+	}
+	out := new(PhysicalVolumeSpace)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceToBeInited) DeepCopyInto(out *ResourceToBeInited) {
 	*out = *in
@@ -494,6 +647,47 @@ func (in *ResourceToBeInited) DeepCopy() *ResourceToBeInited {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotCapacityStatus) DeepCopyInto(out *SnapshotCapacityStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotCapacityStatus.
+func (in *SnapshotCapacityStatus) DeepCopy() *SnapshotCapacityStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotCapacityStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotUsageTrend) DeepCopyInto(out *SnapshotUsageTrend) {
+	*out = *in
+	if in.SecondsToFull != nil {
+		in, out := &in.SecondsToFull, &out.SecondsToFull
+		*out = new(int64)
+		**out = **in
+	}
+	if in.LastSampleTime != nil {
+		in, out := &in.LastSampleTime, &out.LastSampleTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotUsageTrend.
+func (in *SnapshotUsageTrend) DeepCopy() *SnapshotUsageTrend {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotUsageTrend)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SpdkConfig) DeepCopyInto(out *SpdkConfig) {
 	*out = *in
@@ -510,6 +704,49 @@ func (in *SpdkConfig) DeepCopy() *SpdkConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StatusDriftStatus) DeepCopyInto(out *StatusDriftStatus) {
+	*out = *in
+	in.State.DeepCopyInto(&out.State)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StatusDriftStatus.
+func (in *StatusDriftStatus) DeepCopy() *StatusDriftStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(StatusDriftStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageClassServiceability) DeepCopyInto(out *StorageClassServiceability) {
+	*out = *in
+	if in.Serviceable != nil {
+		in, out := &in.Serviceable, &out.Serviceable
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Unserviceable != nil {
+		in, out := &in.Unserviceable, &out.Unserviceable
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageClassServiceability.
+func (in *StorageClassServiceability) DeepCopy() *StorageClassServiceability {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageClassServiceability)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StorageState) DeepCopyInto(out *StorageState) {
 	*out = *in
@@ -534,6 +771,22 @@ func (in *StorageState) DeepCopy() *StorageState {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageVersions) DeepCopyInto(out *StorageVersions) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageVersions.
+func (in *StorageVersions) DeepCopy() *StorageVersions {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageVersions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UpdateStatusInfo) DeepCopyInto(out *UpdateStatusInfo) {
 	*out = *in
@@ -554,6 +807,29 @@ func (in *UpdateStatusInfo) DeepCopy() *UpdateStatusInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VGLabels) DeepCopyInto(out *VGLabels) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VGLabels.
+func (in *VGLabels) DeepCopy() *VGLabels {
+	if in == nil {
+		return nil
+	}
+	out := new(VGLabels)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VGList) DeepCopyInto(out *VGList) {
 	*out = *in
@@ -567,6 +843,23 @@ func (in *VGList) DeepCopyInto(out *VGList) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SnapshotExpansionWindows != nil {
+		in, out := &in.SnapshotExpansionWindows, &out.SnapshotExpansionWindows
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make([]VGLabels, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -588,6 +881,11 @@ func (in *VGToBeInited) DeepCopyInto(out *VGToBeInited) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LoopFiles != nil {
+		in, out := &in.LoopFiles, &out.LoopFiles
+		*out = make([]LoopFile, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -609,10 +907,27 @@ func (in *VolumeGroup) DeepCopyInto(out *VolumeGroup) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.PhysicalVolumeSpaces != nil {
+		in, out := &in.PhysicalVolumeSpaces, &out.PhysicalVolumeSpaces
+		*out = make([]PhysicalVolumeSpace, len(*in))
+		copy(*out, *in)
+	}
 	if in.LogicalVolumes != nil {
 		in, out := &in.LogicalVolumes, &out.LogicalVolumes
 		*out = make([]LogicalVolume, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SnapshotAwareAvailable != nil {
+		in, out := &in.SnapshotAwareAvailable, &out.SnapshotAwareAvailable
+		*out = new(uint64)
+		**out = **in
+	}
+	if in.IOStats != nil {
+		in, out := &in.IOStats, &out.IOStats
+		*out = new(VolumeGroupIOStats)
+		**out = **in
 	}
 	return
 }
@@ -626,3 +941,19 @@ func (in *VolumeGroup) DeepCopy() *VolumeGroup {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeGroupIOStats) DeepCopyInto(out *VolumeGroupIOStats) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeGroupIOStats.
+func (in *VolumeGroupIOStats) DeepCopy() *VolumeGroupIOStats {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeGroupIOStats)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -64,6 +64,10 @@ func (controller *Controller) addVGInfoToPVsForPod(ctx context.Context, podNameS
 			errorList = append(errorList, fmt.Errorf("failed to get PV %s for pvc %s with pod %s error: %s, retry after", name, utils.GetName(pvc.ObjectMeta), utils.GetName(pod.ObjectMeta), err.Error()))
 			continue
 		}
+		// leadership may be lost between patches of pvs of the same pod
+		if err := controller.leader.Check(); err != nil {
+			return err
+		}
 
 		err = controller.patchAllocateInfoToPV(ctx, pv, &pvcAllocated.PVAllocatedInfo)
 		if err != nil {
@@ -71,6 +75,10 @@ func (controller *Controller) addVGInfoToPVsForPod(ctx context.Context, podNameS
 		}
 
 	}
+	// patch aborted by losing leadership is not retried by this controller
+	if err := controller.leader.Check(); err != nil {
+		return err
+	}
 	if len(errorList) > 0 {
 		return fmt.Errorf("errorList:%+v", errorList)
 	}
@@ -21,6 +21,9 @@ import (
 // https://github.com/restic/restic/issues/1977
 // There's no way to let restic delete a repository completely. I think having a way to delete an entire repo is not so important...
 func (c *Controller) CleanUnusedResticRepo() {
+	if err := c.leader.Check(); err != nil {
+		return
+	}
 	c.mux.Lock()
 	defer c.mux.Unlock()
 	klog.Infof("CleanUnusedResticRepo: start clean unused restic repo...")
@@ -17,7 +17,6 @@ limitations under the License.
 package controller
 
 import (
-	"context"
 	"fmt"
 	"reflect"
 	"sync"
@@ -87,6 +86,8 @@ type Controller struct {
 
 	nlscName string
 	mux      *sync.Mutex
+	// leader gates operations on holding leadership
+	leader *leaderGate
 }
 
 type SyncNLSItem struct {
@@ -152,6 +153,7 @@ func NewController(
 		recorder:              eventRecorder,
 		nlscName:              nlscName,
 		mux:                   &sync.Mutex{},
+		leader:                newLeaderGate(),
 	}
 
 	klog.Info("Setting up event handlers")
@@ -229,14 +231,29 @@ func (c *Controller) processNextWorkItem() bool {
 	err := func(obj interface{}) error {
 		defer c.workqueue.Done(obj)
 
+		// dropped since the next leader resyncs everything on acquiring leadership
+		if err := c.leader.Check(); err != nil {
+			c.workqueue.Forget(obj)
+			klog.V(4).Infof("drop '%#v': %s", obj, err.Error())
+			return nil
+		}
+
 		switch item := obj.(type) {
 		case SyncNLSItem:
 			if err := c.syncHandler(item); err != nil {
+				if isLeadershipLost(err) {
+					c.workqueue.Forget(item)
+					return fmt.Errorf("abort SyncNLSItem '%#v': %s", item, err.Error())
+				}
 				c.workqueue.AddRateLimited(item)
 				return fmt.Errorf("error SyncNLSItem '%#v': %s, requeuing", item, err.Error())
 			}
 		case SyncPVByPodItem:
-			if err := c.addVGInfoToPVsForPod(context.Background(), item.podNameSpace, item.podName); err != nil {
+			if err := c.addVGInfoToPVsForPod(c.leader.Context(), item.podNameSpace, item.podName); err != nil {
+				if isLeadershipLost(err) {
+					c.workqueue.Forget(item)
+					return fmt.Errorf("abort SyncPVByPodItem '%#v': %s", item, err.Error())
+				}
 				c.workqueue.AddAfter(item, time.Millisecond*500)
 				return fmt.Errorf("error SyncPVByPodItem '%#v': %s, requeuing", item, err.Error())
 			}
@@ -276,6 +293,10 @@ func (c *Controller) syncHandler(item SyncNLSItem) error {
 
 	// step 2: handle
 	for _, name := range nlsNames {
+		// leadership may be lost in the middle of a full sync
+		if err := c.leader.Check(); err != nil {
+			return err
+		}
 		nls, err := c.nlsLister.Get(name)
 		// create nls if not found
 		if errors.IsNotFound(err) {
@@ -287,7 +308,7 @@ func (c *Controller) syncHandler(item SyncNLSItem) error {
 			if err != nil {
 				return err
 			}
-			_, createErr := c.localclientset.CsiV1alpha1().NodeLocalStorages().Create(context.Background(), nls, metav1.CreateOptions{})
+			_, createErr := c.localclientset.CsiV1alpha1().NodeLocalStorages().Create(c.leader.Context(), nls, metav1.CreateOptions{})
 			if createErr != nil {
 				return createErr
 			}
@@ -357,7 +378,7 @@ func (c *Controller) updateNLSIfNeeded(nls *localv1alpha1.NodeLocalStorage) erro
 
 	if !reflect.DeepEqual(nls, nlsUpdated) {
 		klog.Infof("nls %s need to be updated", nls.Name)
-		if _, err := c.localclientset.CsiV1alpha1().NodeLocalStorages().Update(context.Background(), nlsUpdated, metav1.UpdateOptions{}); err != nil {
+		if _, err := c.localclientset.CsiV1alpha1().NodeLocalStorages().Update(c.leader.Context(), nlsUpdated, metav1.UpdateOptions{}); err != nil {
 			return err
 		}
 	}
@@ -440,7 +461,12 @@ func (c *Controller) deleteNLSByNode(obj interface{}) {
 		utilruntime.HandleError(err)
 		return
 	}
-	if err = c.localclientset.CsiV1alpha1().NodeLocalStorages().Delete(context.Background(), nodeName, *metav1.NewDeleteOptions(1)); err != nil {
+	// nls of node deleted while not leading is deleted by resync
+	if err = c.leader.Check(); err != nil {
+		klog.V(4).Infof("skip deleting nls %s: %s", nodeName, err.Error())
+		return
+	}
+	if err = c.localclientset.CsiV1alpha1().NodeLocalStorages().Delete(c.leader.Context(), nodeName, *metav1.NewDeleteOptions(1)); err != nil {
 		klog.Errorf("Delete nls %s failed: %s", nodeName, err.Error())
 	}
 }
@@ -462,6 +488,9 @@ func (c *Controller) cleanOrphanSnapshotContents() {
 		return
 	}
 	for _, content := range allContents {
+		if err := c.leader.Check(); err != nil {
+			return
+		}
 		needAnnotation, err := c.isOrphanSnapshotContent(content)
 		if err != nil {
 			klog.Errorf("fail to check snapshot content %s is orphan: %s", content.Name, err.Error())
@@ -509,7 +538,7 @@ func (c *Controller) setAnnVolumeSnapshotBeingDeleted(content *snapshotapi.Volum
 		klog.Infof("setAnnVolumeSnapshotBeingDeleted: set annotation %s on content %s", AnnVolumeSnapshotBeingDeleted, content.Name)
 		metav1.SetMetaDataAnnotation(&content.ObjectMeta, AnnVolumeSnapshotBeingDeleted, "yes")
 	}
-	_, err := c.snapshotclientset.SnapshotV1().VolumeSnapshotContents().Update(context.TODO(), content, metav1.UpdateOptions{})
+	_, err := c.snapshotclientset.SnapshotV1().VolumeSnapshotContents().Update(c.leader.Context(), content, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
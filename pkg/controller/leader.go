@@ -0,0 +1,198 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// ErrNotLeader is returned by operations started or interrupted while the
+// controller does not hold leadership
+var ErrNotLeader = errors.New("controller is not the leader")
+
+// LeaderElectionConfig is the lease competed by controller replicas
+type LeaderElectionConfig struct {
+	Namespace     string
+	Name          string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// leaderGate gates every mutating operation of controller on holding
+// leadership, so that two replicas never act during a leader transition
+type leaderGate struct {
+	mux sync.RWMutex
+	// ctx is canceled once leadership is lost, nil if not leading
+	ctx context.Context
+}
+
+func newLeaderGate() *leaderGate {
+	// leading by default, leader election is optional
+	return &leaderGate{ctx: context.Background()}
+}
+
+func (g *leaderGate) acquire(ctx context.Context) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.ctx = ctx
+}
+
+func (g *leaderGate) release() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.ctx = nil
+}
+
+// Check returns ErrNotLeader if leadership is not held or lost already
+func (g *leaderGate) Check() error {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	if g.ctx == nil || g.ctx.Err() != nil {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// Context returns context of current leadership, which aborts in-flight api
+// calls once leadership is lost
+func (g *leaderGate) Context() context.Context {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	if g.ctx == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	return g.ctx
+}
+
+// isLeadershipLost returns true if err is caused by losing leadership, such
+// work is dropped instead of retried since the next leader resyncs it
+func isLeadershipLost(err error) bool {
+	return errors.Is(err, ErrNotLeader) || errors.Is(err, context.Canceled)
+}
+
+// RunWithLeaderElection runs controller as a candidate of the lease, work is
+// only done while leading and leadership is competed again once lost
+func (c *Controller) RunWithLeaderElection(workers int, config LeaderElectionConfig, stopCh <-chan struct{}) error {
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, config.Namespace, config.Name,
+		c.kubeclientset.CoreV1(), c.kubeclientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: config.Identity, EventRecorder: c.recorder})
+	if err != nil {
+		return fmt.Errorf("fail to create lease lock: %s", err.Error())
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: c.startLeading,
+			OnStoppedLeading: c.stopLeading,
+			OnNewLeader: func(identity string) {
+				klog.Infof("leader of %s/%s is %s", config.Namespace, config.Name, identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("fail to create leader elector: %s", err.Error())
+	}
+
+	c.leader.release()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	go func() {
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+	return c.Run(workers, stopCh)
+}
+
+// startLeading is called with context canceled when leadership is lost
+func (c *Controller) startLeading(ctx context.Context) {
+	klog.Info("started leading")
+	c.leader.acquire(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), c.nodeSynced, c.podSynced, c.nlsSynced) {
+		return
+	}
+	c.resync()
+}
+
+func (c *Controller) stopLeading() {
+	klog.Info("stopped leading, operations in flight are aborted")
+	c.leader.release()
+}
+
+// resync re-hydrates state from listers on acquiring leadership, events are
+// dropped while not leading, so every nls, allocation of pod and nls of
+// deleted node is handled again
+func (c *Controller) resync() {
+	c.enqueueNLSC(c.nlscName, "")
+
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("fail to list pods for resync: %s", err.Error())
+	}
+	for _, pod := range pods {
+		if localtype.GetAllocateInfoJSONFromPod(pod) == "" {
+			continue
+		}
+		c.workqueue.Add(SyncPVByPodItem{
+			podNameSpace: pod.Namespace,
+			podName:      pod.Name,
+		})
+	}
+
+	nlsList, err := c.nlsLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("fail to list nls for resync: %s", err.Error())
+	}
+	for _, nls := range nlsList {
+		if _, err := c.nodeLister.Get(nls.Name); !apierrors.IsNotFound(err) {
+			continue
+		}
+		if err := c.leader.Check(); err != nil {
+			return
+		}
+		klog.Infof("delete nls %s of deleted node", nls.Name)
+		if err := c.localclientset.CsiV1alpha1().NodeLocalStorages().Delete(c.leader.Context(), nls.Name, *metav1.NewDeleteOptions(1)); err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("Delete nls %s failed: %s", nls.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+)
+
+func countActions(actions []core.Action, verb, resource string) int {
+	count := 0
+	for _, action := range actions {
+		if action.Matches(verb, resource) {
+			count++
+		}
+	}
+	return count
+}
+
+func Test_leaderGate(t *testing.T) {
+	gate := newLeaderGate()
+	assert.NoError(t, gate.Check(), "leading by default")
+
+	gate.release()
+	assert.ErrorIs(t, gate.Check(), ErrNotLeader)
+	assert.Error(t, gate.Context().Err(), "context of non-leader must be canceled")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gate.acquire(ctx)
+	assert.NoError(t, gate.Check())
+	assert.NoError(t, gate.Context().Err())
+
+	// leadership lost before stopLeading is called
+	cancel()
+	assert.ErrorIs(t, gate.Check(), ErrNotLeader)
+	assert.Error(t, gate.Context().Err())
+}
+
+func Test_isLeadershipLost(t *testing.T) {
+	assert.True(t, isLeadershipLost(ErrNotLeader))
+	assert.True(t, isLeadershipLost(context.Canceled))
+	assert.False(t, isLeadershipLost(errors.New("conflict")))
+	assert.False(t, isLeadershipLost(nil))
+}
+
+func Test_SyncPVByPodItem_LeadershipLost(t *testing.T) {
+	pod := newPod("testNamespace", "testName")
+	pvcPVInfos := utils.TestPVCPVInfoList{
+		utils.GetTestPVCPVWithVG(),
+		utils.GetTestPVCPVDevice(),
+	}
+	pvcs := utils.CreateTestPersistentVolumeClaim(pvcPVInfos.GetTestPVCBounding())
+	lvmPVInfoWithoutVG := utils.GetTestPVCPVWithVG().PVBounding
+	lvmPVInfoWithoutVG.VgName = ""
+	devicePVInfoWithoutDeviceName := utils.GetTestPVCPVDevice().PVBounding
+	devicePVInfoWithoutDeviceName.DeviceName = ""
+	pvs := utils.CreateTestPersistentVolume([]utils.TestPVInfo{*lvmPVInfoWithoutVG, *devicePVInfoWithoutDeviceName})
+
+	f := newFixture(t)
+	c, _, k8sInformers := f.newController()
+	ctx := context.Background()
+	_ = k8sInformers.Core().V1().Pods().Informer().GetIndexer().Add(pod)
+	for _, pv := range pvs {
+		_, _ = f.kubeclient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+		_ = k8sInformers.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(pv)
+	}
+	for _, pvc := range pvcs {
+		_ = k8sInformers.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+	}
+
+	// leadership is lost while the first pv is being patched
+	f.kubeclient.PrependReactor("patch", "persistentvolumes", func(action core.Action) (bool, runtime.Object, error) {
+		c.leader.release()
+		return false, nil, nil
+	})
+
+	err := c.addVGInfoToPVsForPod(c.leader.Context(), pod.Namespace, pod.Name)
+	assert.ErrorIs(t, err, ErrNotLeader)
+	assert.Equal(t, 1, countActions(f.kubeclient.Actions(), "patch", "persistentvolumes"), "pv after losing leadership must not be patched")
+
+	// the aborted item is dropped instead of retried
+	c.workqueue.Add(SyncPVByPodItem{podNameSpace: pod.Namespace, podName: pod.Name})
+	assert.True(t, c.processNextWorkItem())
+	assert.Equal(t, 0, c.workqueue.Len())
+	assert.Equal(t, 1, countActions(f.kubeclient.Actions(), "patch", "persistentvolumes"), "no patch while not leading")
+}
+
+func Test_syncHandler_LeadershipLost(t *testing.T) {
+	f := newFixture(t)
+	nlsc := newNLSC("open-local")
+	f.nlscLister = append(f.nlscLister, nlsc)
+	f.localobjects = append(f.localobjects, nlsc)
+	for _, name := range []string{"node-0", "node-1"} {
+		node := newMasterNode(name)
+		f.nodeLister = append(f.nodeLister, node)
+		f.kubeobjects = append(f.kubeobjects, node)
+	}
+	c, _, _ := f.newController()
+
+	// leadership is lost while the first nls is being created
+	f.client.PrependReactor("create", "nodelocalstorages", func(action core.Action) (bool, runtime.Object, error) {
+		c.leader.release()
+		return false, nil, nil
+	})
+
+	err := c.syncHandler(SyncNLSItem{nlscName: nlsc.Name})
+	assert.ErrorIs(t, err, ErrNotLeader)
+	assert.Equal(t, 1, countActions(f.client.Actions(), "create", "nodelocalstorages"), "nls after losing leadership must not be created")
+}
+
+func Test_startLeading(t *testing.T) {
+	f := newFixture(t)
+	nlsc := newNLSC("open-local")
+	node := newMasterNode("node-0")
+	nls := newNLS("node-0")
+	orphanNLS := newNLS("deleted-node")
+	f.nlscLister = append(f.nlscLister, nlsc)
+	f.nodeLister = append(f.nodeLister, node)
+	f.nlsLister = append(f.nlsLister, nls, orphanNLS)
+	f.localobjects = append(f.localobjects, nlsc, nls, orphanNLS)
+	f.kubeobjects = append(f.kubeobjects, node)
+	c, _, k8sInformers := f.newController()
+	c.podSynced = alwaysReady
+	pod := newPod("testNamespace", "testName")
+	_ = k8sInformers.Core().V1().Pods().Informer().GetIndexer().Add(pod)
+
+	// events are dropped by standby
+	c.leader.release()
+	c.workqueue.Add(SyncNLSItem{nlscName: nlsc.Name, nlsName: nls.Name})
+	assert.True(t, c.processNextWorkItem())
+	assert.Equal(t, 0, c.workqueue.Len())
+	c.deleteNLSByNode(node)
+	assert.Empty(t, filterInformerActions(f.client.Actions()), "standby must not operate")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.startLeading(ctx)
+	assert.NoError(t, c.leader.Check())
+	assert.Equal(t, 2, c.workqueue.Len(), "full nls sync and pv allocation of pod are resynced")
+	_, err := f.client.CsiV1alpha1().NodeLocalStorages().Get(ctx, orphanNLS.Name, metav1.GetOptions{})
+	assert.Error(t, err, "nls of node deleted while not leading should be deleted")
+	_, err = f.client.CsiV1alpha1().NodeLocalStorages().Get(ctx, nls.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	c.stopLeading()
+	assert.ErrorIs(t, c.leader.Check(), ErrNotLeader)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/alibaba/open-local/pkg/csi/lib"
 	"github.com/alibaba/open-local/pkg/csi/test"
 	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/tracing"
 	"google.golang.org/grpc/test/bufconn"
 
 	"google.golang.org/grpc"
@@ -37,13 +38,25 @@ import (
 // Connection lvm connection interface
 type Connection interface {
 	GetVolume(ctx context.Context, volGroup string, volumeID string) (string, error)
-	CreateVolume(ctx context.Context, opt *LVMOptions) (string, error)
+	// DescribeVolume returns lv in volGroup, nil if it does not exist
+	DescribeVolume(ctx context.Context, volGroup string, volumeID string) (*lib.LogicalVolume, error)
+	AddVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error
+	RemoveVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error
+	// ListVolumes returns all lvs in volGroup
+	ListVolumes(ctx context.Context, volGroup string) ([]*lib.LogicalVolume, error)
+	// DescribeVolumeGroup returns vg named volGroup, nil if it does not exist
+	DescribeVolumeGroup(ctx context.Context, volGroup string) (*lib.VolumeGroup, error)
+	// CreateVolume returns command output and io alignment of the created lv
+	CreateVolume(ctx context.Context, opt *LVMOptions) (string, string, error)
 	DeleteVolume(ctx context.Context, volGroup string, volumeID string) error
-	CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error)
+	CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error)
 	DeleteSnapshot(ctx context.Context, volGroup string, snapVolumeID string, readonly bool, secrets map[string]string) error
 	ExpandVolume(ctx context.Context, volGroup string, volumeID string, size uint64) error
+	CloneVolume(ctx context.Context, src string, dest string, verifyChecksum bool) error
 	CleanPath(ctx context.Context, path string) error
 	CleanDevice(ctx context.Context, device string) error
+	// SetIOThrottling returns pods whose io throttling of the lv is set
+	SetIOThrottling(ctx context.Context, volGroup string, volumeID string, iops string, bps string) ([]string, error)
 	Close() error
 }
 
@@ -54,6 +67,10 @@ type LVMOptions struct {
 	Size        uint64   `json:"size,omitempty"`
 	Tags        []string `json:"tags,omitempty"`
 	Striping    bool     `json:"striping,omitempty"`
+	// Zero writes zeros across the lv after it is created
+	Zero bool `json:"zero,omitempty"`
+	// AllocationPolicy is passed to lvcreate --alloc
+	AllocationPolicy string `json:"allocationPolicy,omitempty"`
 }
 
 type workerConnection struct {
@@ -93,7 +110,7 @@ func connect(address string, timeout time.Duration) (*grpc.ClientConn, error) {
 	var bufDialerFunc func(context.Context, string) (net.Conn, error)
 	dialOptions := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(logGRPC),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor, logGRPC),
 	}
 	if test.Lis != nil {
 		bufDialerFunc = func(context.Context, string) (net.Conn, error) {
@@ -133,7 +150,7 @@ func connect(address string, timeout time.Duration) (*grpc.ClientConn, error) {
 	}
 }
 
-func (c *workerConnection) CreateVolume(ctx context.Context, opt *LVMOptions) (string, error) {
+func (c *workerConnection) CreateVolume(ctx context.Context, opt *LVMOptions) (string, string, error) {
 	client := lib.NewLVMClient(c.conn)
 	req := lib.CreateLVRequest{
 		VolumeGroup: opt.VolumeGroup,
@@ -141,26 +158,32 @@ func (c *workerConnection) CreateVolume(ctx context.Context, opt *LVMOptions) (s
 		Size:        opt.Size,
 		Tags:        opt.Tags,
 		Striping:    opt.Striping,
+		Zero:        opt.Zero,
+		// policy of vg if empty
+		AllocationPolicy: opt.AllocationPolicy,
 	}
 
 	rsp, err := client.CreateLV(ctx, &req)
 	if err != nil {
 		log.Errorf("Create Lvm with error: %s", err.Error())
-		return "", err
+		return "", "", err
 	}
 	log.V(6).Infof("Create Lvm with result: %+v", rsp.CommandOutput)
-	return rsp.GetCommandOutput(), nil
+	return rsp.GetCommandOutput(), rsp.GetIoAlignment(), nil
 }
 
-func (c *workerConnection) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error) {
+func (c *workerConnection) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error) {
 	client := lib.NewLVMClient(c.conn)
 
 	req := lib.CreateSnapshotRequest{
 		VgName:        vgName,
 		SnapshotName:  snapshotName,
 		SrcVolumeName: srcVolumeName,
+		SrcLvName:     srcLVName,
 		Readonly:      readonly,
 		RoInitSize:    roInitSize,
+		CowPvs:        cowPVs,
+		FsFreeze:      fsFreeze,
 		S3Secrets:     secrets,
 	}
 	rsp, err := client.CreateSnapshot(ctx, &req)
@@ -193,6 +216,85 @@ func (c *workerConnection) GetVolume(ctx context.Context, volGroup string, volum
 	return "", nil
 }
 
+func (c *workerConnection) DescribeVolume(ctx context.Context, volGroup string, volumeID string) (*lib.LogicalVolume, error) {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.ListLVRequest{
+		VolumeGroup: utils.GetNameKey(volGroup, volumeID),
+	}
+
+	rsp, err := client.ListLV(ctx, &req)
+	if err != nil {
+		log.Errorf("Describe Lvm with error: %s", err.Error())
+		return nil, err
+	}
+	for _, volume := range rsp.GetVolumes() {
+		if volume.Name == volumeID {
+			return volume, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *workerConnection) AddVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.AddTagLVRequest{
+		VolumeGroup: volGroup,
+		Name:        volumeID,
+		Tags:        tags,
+	}
+	response, err := client.AddTagLV(ctx, &req)
+	if err != nil {
+		log.Errorf("Add Lvm Tags with error: %s", err.Error())
+		return err
+	}
+	log.V(6).Infof("Add Lvm Tags with result: %v", response.GetCommandOutput())
+	return nil
+}
+
+func (c *workerConnection) RemoveVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.RemoveTagLVRequest{
+		VolumeGroup: volGroup,
+		Name:        volumeID,
+		Tags:        tags,
+	}
+	response, err := client.RemoveTagLV(ctx, &req)
+	if err != nil {
+		log.Errorf("Remove Lvm Tags with error: %s", err.Error())
+		return err
+	}
+	log.V(6).Infof("Remove Lvm Tags with result: %v", response.GetCommandOutput())
+	return nil
+}
+
+func (c *workerConnection) ListVolumes(ctx context.Context, volGroup string) ([]*lib.LogicalVolume, error) {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.ListLVRequest{
+		VolumeGroup: volGroup,
+	}
+	rsp, err := client.ListLV(ctx, &req)
+	if err != nil {
+		log.Errorf("List Lvm with error: %s", err.Error())
+		return nil, err
+	}
+	return rsp.GetVolumes(), nil
+}
+
+func (c *workerConnection) DescribeVolumeGroup(ctx context.Context, volGroup string) (*lib.VolumeGroup, error) {
+	client := lib.NewLVMClient(c.conn)
+	rsp, err := client.ListVG(ctx, &lib.ListVGRequest{})
+	if err != nil {
+		log.Errorf("List VG with error: %s", err.Error())
+		return nil, err
+	}
+	for _, vg := range rsp.GetVolumeGroups() {
+		if vg.Name == volGroup {
+			return vg, nil
+		}
+	}
+	return nil, nil
+}
+
 func (c *workerConnection) DeleteVolume(ctx context.Context, volGroup, volumeID string) error {
 	client := lib.NewLVMClient(c.conn)
 	req := lib.RemoveLVRequest{
@@ -225,6 +327,22 @@ func (c *workerConnection) DeleteSnapshot(ctx context.Context, volGroup string,
 	return err
 }
 
+func (c *workerConnection) CloneVolume(ctx context.Context, src string, dest string, verifyChecksum bool) error {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.CloneLVRequest{
+		SourceName:     src,
+		DestName:       dest,
+		VerifyChecksum: verifyChecksum,
+	}
+	response, err := client.CloneLV(ctx, &req)
+	if err != nil {
+		log.Errorf("fail to clone %s to %s: %s", src, dest, err.Error())
+		return err
+	}
+	log.V(6).Infof("clone %s to %s successfully with result: %s", src, dest, response.GetCommandOutput())
+	return err
+}
+
 func (c *workerConnection) CleanPath(ctx context.Context, path string) error {
 	client := lib.NewLVMClient(c.conn)
 	req := lib.CleanPathRequest{
@@ -269,9 +387,27 @@ func (c *workerConnection) ExpandVolume(ctx context.Context, volGroup string, vo
 	return err
 }
 
+func (c *workerConnection) SetIOThrottling(ctx context.Context, volGroup string, volumeID string, iops string, bps string) ([]string, error) {
+	client := lib.NewLVMClient(c.conn)
+	req := lib.SetIOThrottlingRequest{
+		VolumeGroup: volGroup,
+		Name:        volumeID,
+		Iops:        iops,
+		Bps:         bps,
+	}
+	response, err := client.SetIOThrottling(ctx, &req)
+	if err != nil {
+		log.Errorf("fail to set io throttling of %s/%s: %s", volGroup, volumeID, err.Error())
+		return nil, err
+	}
+	log.V(6).Infof("set io throttling of %s/%s successfully for pods %v", volGroup, volumeID, response.GetPods())
+	return response.GetPods(), nil
+}
+
+// logGRPC logs request and response, operation id in ctx is passed to lvmd
 func logGRPC(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	log.V(6).Infof("GRPC request: %s, %+v", method, req)
-	err := invoker(ctx, method, req, reply, cc, opts...)
-	log.V(6).Infof("GRPC response: %+v, %v", reply, err)
+	log.V(6).InfoS("GRPC request", utils.OperationLogKeys(ctx, "method", method, "request", req)...)
+	err := invoker(utils.InjectOperationID(ctx), method, req, reply, cc, opts...)
+	log.V(6).InfoS("GRPC response", utils.OperationLogKeys(ctx, "method", method, "response", reply, "err", err)...)
 	return err
 }
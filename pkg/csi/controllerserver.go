@@ -19,29 +19,38 @@ package csi
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alibaba/open-local/pkg"
 	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/csi/adapter"
 	"github.com/alibaba/open-local/pkg/csi/client"
+	"github.com/alibaba/open-local/pkg/csi/lib"
 	"github.com/alibaba/open-local/pkg/csi/server"
 	"github.com/alibaba/open-local/pkg/restic"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
 	"github.com/alibaba/open-local/pkg/signals"
 	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/docker/go-units"
 	timestamppb "github.com/golang/protobuf/ptypes/timestamp"
+	snapshotapi "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	kubeinformers "k8s.io/client-go/informers"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	log "k8s.io/klog/v2"
 )
 
@@ -56,6 +65,11 @@ type controllerServer struct {
 	pvcLister  corelisters.PersistentVolumeClaimLister
 	pvLister   corelisters.PersistentVolumeLister
 
+	// prealloc is nil if preallocation is disabled
+	prealloc *preallocPool
+	// leader is nil if sidecars run without leader election
+	leader *leaderGate
+
 	options *driverOptions
 }
 
@@ -110,6 +124,14 @@ func newControllerServer(options *driverOptions) *controllerServer {
 		adapter:            adapter.NewExtenderAdapter(),
 		options:            options,
 	}
+	if options.leaderElectionNamespace != "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("fail to get hostname: %s", err.Error())
+		}
+		cm.leader = newLeaderGate(options.kubeclient, options.leaderElectionNamespace, hostname, options.driverName)
+	}
+	cm.prealloc = newPreallocPool(options.lvPreallocSizes, options.lvPreallocCount, time.Duration(options.lvPreallocTTL)*time.Second, cm.getNodeConn, cm.preallocVGs, cm.checkVGForNewLV, cm.setPreallocatedLVs)
 	stopCh := signals.SetupSignalHandler()
 	kubeInformerFactory.Start(stopCh)
 	log.Info("Waiting for informer caches to sync")
@@ -123,13 +145,27 @@ func newControllerServer(options *driverOptions) *controllerServer {
 		log.Fatalf("failed to wait for caches to sync")
 	}
 	log.Info("informer sync successfully")
+	if cm.prealloc != nil {
+		cm.prealloc.leading = cm.leader.IsLeading
+		go cm.prealloc.run(stopCh)
+	}
+	if cm.leader != nil {
+		go cm.leader.run(stopCh, cm.startLeading)
+	}
 
 	return cm
 }
 
+// startLeading re-hydrates state left by the last leader: unassigned
+// preallocated lvs published in nls, including lvs the last leader reserved
+// but never created, are listed and published again by syncing pool at once
+func (cs *controllerServer) startLeading() {
+	cs.prealloc.resync()
+}
+
 // CreateVolume csi interface
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	log.V(4).Infof("CreateVolume: called with args %+v", *req)
+	log.V(4).Infof("CreateVolume: called with args %+v", stripSecrets(req))
 	volumeID := req.GetName()
 	// check request
 	if err := validateCreateVolumeRequest(req); err != nil {
@@ -138,6 +174,12 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	// get node name for client.Connection
 	parameters := req.GetParameters()
+	if code, err := checkVolumeSizeRange(req.GetCapacityRange().GetRequiredBytes(), parameters); err != nil {
+		return nil, status.Errorf(code, "CreateVolume: volume %s: %s", volumeID, err.Error())
+	}
+	if _, err := utils.GetReservedBlocksPercent(parameters); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: volume %s: %s", volumeID, err.Error())
+	}
 	volumeType := parameters[pkg.VolumeTypeKey]
 	pvcName := parameters[pkg.PVCName]
 	pvcNameSpace := parameters[pkg.PVCNameSpace]
@@ -148,11 +190,25 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "CreateVolume: fail to get pvc: %s", err.Error())
 	}
-	nodeName, exist := pvc.Annotations[pkg.AnnoSelectedNode]
-	if !exist {
-		return nil, status.Errorf(codes.Unimplemented, "CreateVolume: no annotation %s found in pvc %s. Check if volumeBindingMode of storageclass is WaitForFirstConsumer, cause we only support WaitForFirstConsumer mode", pkg.AnnoSelectedNode, utils.GetNameKey(pvcNameSpace, pvcName))
+	nodeName, err := cs.getSelectedNode(ctx, req, pvc)
+	if err != nil {
+		return nil, err
 	}
 	log.Infof("CreateVolume: starting to Create %s volume %s with: PVC(%s), nodeSelected(%s)", volumeType, volumeID, utils.GetNameKey(pvcNameSpace, pvcName), nodeName)
+	lvName := volumeID
+	var snapshotReserved int64
+	if volumeType == string(pkg.VolumeTypeLVM) {
+		if lvName, err = utils.RenderLVName(cs.options.lvNameTemplate, volumeID, pvcName, pvcNameSpace); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: fail to render lv name of volume %s: %s", volumeID, err.Error())
+		}
+		if snapshotReserved, err = utils.GetSnapshotReserveSize(req.GetCapacityRange().GetRequiredBytes(), parameters); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: invalid snapshot headroom of volume %s: %s", volumeID, err.Error())
+		}
+	}
+
+	if err := cs.leader.Check(ctx, sidecarProvisioner); err != nil {
+		return nil, err
+	}
 
 	// 若特定 volumeID 已在执行中
 	// 则立即返回
@@ -172,24 +228,40 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	// 通过与调度器互动
 	// 将重要的属性更新在 paramMap
+	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
 	schedulerName := cs.pvcPodSchedulerMap.Get(pvcNameSpace, pvcName)
-	if cs.schedulerArchMap.Get(schedulerName) == SchedulerArchExtender {
+	if adoptTarget, exist := pvc.Annotations[localtype.AnnotationPVCAdoptLVKey]; exist {
+		// 接管已有 lv, 不创建也不调度
+		if volumeType != string(pkg.VolumeTypeLVM) || req.GetVolumeContentSource() != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: only lvm volume without content source can adopt lv, volume %s", volumeID)
+		}
+		vgName, adoptedLVName, size, err := cs.adoptLV(ctx, conn, volumeID, nodeName, adoptTarget, req.GetCapacityRange(), parameters)
+		if err != nil {
+			return nil, err
+		}
+		paramMap[VgNameTag] = vgName
+		lvName = adoptedLVName
+		capacityBytes = size
+		snapshotReserved = 0
+	} else if cs.schedulerArchMap.Get(schedulerName) == SchedulerArchExtender {
 		log.Infof("CreateVolume: scheduler arch of pvc(%s) is %s", utils.GetNameKey(pvcNameSpace, pvcName), SchedulerArchExtender)
 		switch volumeType {
 		case string(pkg.VolumeTypeLVM):
 			// 若为只读快照，则直接退出
 			if utils.IsReadOnlySnapshotPVC2(pvc, cs.options.snapclient) {
+				// 全量拷贝的新 lv 建在 源PV 所在 VG, 需在调度器中预留容量
+				if utils.GetParam(parameters, localtype.ParamSnapshotFullCopy) == "true" {
+					if err := cs.reserveSnapshotCopy(nodeName, pvcName, pvcNameSpace); err != nil {
+						return nil, status.Errorf(scheduleErrorCode(err), "CreateVolume: fail to schedule full copy of snapshot %s: %s", volumeID, err.Error())
+					}
+				}
 				break
 			}
 
 			// 获取 vgName
 			paramMap, err = cs.scheduleLVMVolume(nodeName, pvcName, pvcNameSpace, parameters)
 			if err != nil {
-				code := codes.Internal
-				if strings.Contains(err.Error(), "Insufficient") {
-					code = codes.ResourceExhausted
-				}
-				return nil, status.Errorf(code, "CreateVolume: fail to schedule LVM %s: %s", volumeID, err.Error())
+				return nil, status.Errorf(scheduleErrorCode(err), "CreateVolume: fail to schedule LVM %s: %s", volumeID, err.Error())
 			}
 			vgName := paramMap[VgNameTag]
 			log.Infof("CreateVolume: schedule LVM %s with %s, %s", volumeID, nodeName, vgName)
@@ -197,49 +269,81 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 			if vgName == "" {
 				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: empty vgName in %s", volumeID)
 			}
-
+			// leadership may be lost while scheduling, the new leader creates
+			// the volume once it is retried
+			if err := cs.leader.Check(ctx, sidecarProvisioner); err != nil {
+				return nil, err
+			}
 			// create lv
 			options := &client.LVMOptions{}
-			options.Name = req.Name
 			options.VolumeGroup = vgName
+			options.Tags = []string{localtype.ManagedLVTag, createdForTag(volumeID)}
+			if utils.GetParam(parameters, localtype.ParamDiscardOnDelete) == "true" {
+				options.Tags = append(options.Tags, localtype.DiscardOnDeleteLVTag)
+			}
 			if value, ok := parameters[LvmTypeTag]; ok && value == StripingType {
 				options.Striping = true
 			}
 			options.Size = uint64(req.GetCapacityRange().GetRequiredBytes())
-			if lvName, err := conn.GetVolume(ctx, vgName, volumeID); err != nil {
-				return nil, status.Errorf(codes.Internal, "CreateVolume: fail to get lv %s from node %s: %s", req.Name, nodeName, err.Error())
+			options.Zero = utils.GetParam(parameters, localtype.ParamZeroFill) == "true"
+			options.AllocationPolicy = utils.GetParam(parameters, localtype.ParamAllocationPolicy)
+			assigned := ""
+			if req.GetVolumeContentSource() == nil && cs.prealloc.usable(options.Size, parameters) {
+				// assigned lv is tagged as created for the volume, lvmd
+				// renames it to lvName and reuses it below
+				var err error
+				assigned, err = cs.prealloc.assign(ctx, conn, nodeName, vgName, volumeID, lvName, options.Size, options.Tags)
+				if err != nil {
+					return nil, status.Errorf(codes.Internal, "CreateVolume: fail to assign preallocated lv to %s at node %s: %s", volumeID, nodeName, err.Error())
+				}
+			}
+			options.Name = lvName
+			if err := cs.checkDMNameOnNode(volumeID, nodeName, vgName, lvName); err != nil {
+				return nil, err
+			}
+			if existLVName, err := conn.GetVolume(ctx, vgName, lvName); err != nil {
+				return nil, status.Errorf(codes.Internal, "CreateVolume: fail to get lv %s from node %s: %s", lvName, nodeName, err.Error())
 			} else {
-				if lvName == "" {
+				if existLVName == "" && assigned != "" {
+					log.Infof("CreateVolume: preallocated lv %s at node %s is assigned to volume %s as %s", assigned, nodeName, volumeID, lvName)
+					if err := createLVOnNode(ctx, conn, options, nodeName, parameters); err != nil {
+						return nil, err
+					}
+				} else if existLVName == "" {
+					if err := cs.checkVGForNewLV(ctx, conn, nodeName, vgName, options.Size); err != nil {
+						return nil, err
+					}
 					log.Info("CreateVolume: volume %s not found, creating volume on node %s", volumeID, nodeName)
-					outstr, err := conn.CreateVolume(ctx, options)
-					if err != nil {
-						return nil, status.Errorf(codes.Internal, "CreateVolume: fail to create lv %s(options: %v): %s", utils.GetNameKey(vgName, volumeID), options, err.Error())
+					if err := createLVOnNode(ctx, conn, options, nodeName, parameters); err != nil {
+						return nil, err
+					}
+				} else if lv, err := conn.DescribeVolume(ctx, vgName, lvName); err != nil {
+					return nil, status.Errorf(codes.Internal, "CreateVolume: fail to describe lv %s from node %s: %s", lvName, nodeName, err.Error())
+				} else if reconcile, err := reconcileExistingLV(lv, options, volumeID); err != nil {
+					return nil, status.Errorf(codes.AlreadyExists, "CreateVolume: lv %s at node %s: %s", lvName, nodeName, err.Error())
+				} else if reconcile {
+					// the last request is running or interrupted, lvmd
+					// completes the lv without creating it twice
+					log.Infof("CreateVolume: lv %s at node %s is left by the last request of volume %s", lvName, nodeName, volumeID)
+					if err := createLVOnNode(ctx, conn, options, nodeName, parameters); err != nil {
+						return nil, err
 					}
-					log.Infof("CreateLvm: create lvm %s in node %s with response %s successfully", utils.GetNameKey(vgName, volumeID), nodeName, outstr)
 				} else {
-					log.Infof("CreateVolume: lv %s already created at node %s", req.Name, nodeName)
+					log.Infof("CreateVolume: lv %s already created at node %s", lvName, nodeName)
 				}
 			}
 		case string(pkg.VolumeTypeMountPoint):
 			var err error
 			paramMap, err = cs.scheduleMountpointVolume(nodeName, pvcName, pvcNameSpace, parameters)
 			if err != nil {
-				code := codes.Internal
-				if strings.Contains(err.Error(), "Insufficient") {
-					code = codes.ResourceExhausted
-				}
-				return nil, status.Errorf(code, "CreateVolume: fail to schedule mountpoint %s at node %s: %s", req.Name, nodeName, err.Error())
+				return nil, status.Errorf(scheduleErrorCode(err), "CreateVolume: fail to schedule mountpoint %s at node %s: %s", req.Name, nodeName, err.Error())
 			}
 			log.Infof("CreateVolume: create mountpoint %s at node %s successfully", req.Name, nodeName)
 		case string(pkg.VolumeTypeDevice):
 			var err error
 			paramMap, err = cs.scheduleDeviceVolume(nodeName, pvcName, pvcNameSpace, parameters)
 			if err != nil {
-				code := codes.Internal
-				if strings.Contains(err.Error(), "Insufficient") {
-					code = codes.ResourceExhausted
-				}
-				return nil, status.Errorf(code, "CreateVolume: fail to schedule device volume %s at node %s: %s", req.Name, nodeName, err.Error())
+				return nil, status.Errorf(scheduleErrorCode(err), "CreateVolume: fail to schedule device volume %s at node %s: %s", req.Name, nodeName, err.Error())
 			}
 			log.Infof("CreateVolume: create device %s at node %s successfully", req.Name, nodeName)
 		default:
@@ -253,6 +357,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	// 处理快照逻辑
 	isSnapshot := false
+	snapshotID := ""
 	if volumeSource := req.GetVolumeContentSource(); volumeSource != nil {
 		if volumeType == string(pkg.VolumeTypeLVM) {
 			// validate
@@ -267,7 +372,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 			if sourceSnapshot == nil {
 				return nil, status.Error(codes.InvalidArgument, "CreateVolume: fail to retrive snapshot from the volumeContentSource")
 			}
-			snapshotID := sourceSnapshot.GetSnapshotId()
+			snapshotID = sourceSnapshot.GetSnapshotId()
 			log.Infof("CreateVolume: snapshotID of volume %s is %s", volumeID, snapshotID)
 			// get src volume ID
 			snapContent, err := utils.GetVolumeSnapshotContent(cs.options.snapclient, snapshotID)
@@ -293,12 +398,39 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 				if vgName == "" {
 					return nil, status.Errorf(codes.Internal, "CreateVolume: fail to get vgName from pv %s", pv.Name)
 				}
+				// 块设备快照中没有文件系统, 以文件系统挂载会在数据上格式化
+				if isBlockVolume(pv) && !requestsBlockVolume(req.GetVolumeCapabilities()) {
+					return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: snapshot %s of block volume %s must be restored as block volume", snapshotID, srcVolumeID)
+				}
 				// 只读快照要求必须与 源PV 同 VG
 				paramMap[VgNameTag] = vgName
-				paramMap[localtype.ParamReadonly] = "true"
+				snapshotLVName, err := cs.getSnapshotLVName(snapshotID, snapContent)
+				if err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: fail to render lv name of snapshot %s: %s", snapshotID, err.Error())
+				}
+				if value, exist := utils.LookupParam(parameters, localtype.ParamSnapshotFullCopy); exist && value == "true" {
+					// 全量拷贝: 创建新 lv 并将快照数据拷贝进去
+					// 新 lv 与快照无依赖关系，按普通 lvm 卷处理
+					log.Infof("CreateVolume: copy data of snapshot %s to volume %s", snapshotID, volumeID)
+					verifyChecksum := utils.GetParam(parameters, localtype.ParamCloneVerifyChecksum) == "true"
+					if err := cs.copySnapshotToLV(ctx, conn, nodeName, volumeID, pv, snapshotLVName, lvName, req.GetCapacityRange().GetRequiredBytes(), verifyChecksum, parameters); err != nil {
+						return nil, err
+					}
+					delete(paramMap, localtype.ParamSnapshotID)
+				} else {
+					// 只读快照直接挂载快照 lv
+					paramMap[localtype.ParamReadonly] = "true"
+					if snapshotLVName != snapshotID {
+						lvName = snapshotLVName
+					}
+				}
 			} else {
 				// 读写快照需要获取 secret
 				log.Infof("pvc %s snapshot is rw", utils.GetNameKey(pvcNameSpace, pvcName))
+				// restic 按文件恢复, 只能恢复为文件系统
+				if requestsBlockVolume(req.GetVolumeCapabilities()) {
+					return nil, status.Errorf(codes.InvalidArgument, "CreateVolume: rw snapshot %s can not be restored as block volume", snapshotID)
+				}
 			}
 			log.Infof("CreateVolume: get restic snapshot snapshot volume %s info", volumeID)
 		} else {
@@ -309,11 +441,20 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	for key, value := range paramMap {
 		parameters[key] = value
 	}
+	// 记录快照预留空间, 调度器据此在 vg 中扣除, 随卷删除释放
+	// 只读快照不占用 vg 空间
+	if snapshotReserved > 0 && utils.GetParam(parameters, localtype.ParamReadonly) != "true" {
+		parameters[localtype.ParamSnapshotReservedSize] = strconv.FormatInt(snapshotReserved, 10)
+	}
 	parameters[pkg.AnnoSelectedNode] = nodeName
+	if volumeType == string(pkg.VolumeTypeLVM) && lvName != volumeID {
+		parameters[localtype.ParamLVName] = lvName
+	}
+	cs.completeVolumeContext(ctx, parameters, volumeType, nodeName, req.GetVolumeCapabilities())
 	response := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volumeID,
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			CapacityBytes: capacityBytes,
 			VolumeContext: parameters,
 			AccessibleTopology: []*csi.Topology{
 				{
@@ -328,24 +469,27 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		response.Volume.ContentSource = &csi.VolumeContentSource{
 			Type: &csi.VolumeContentSource_Snapshot{
 				Snapshot: &csi.VolumeContentSource_SnapshotSource{
-					SnapshotId: paramMap[localtype.ParamSnapshotID],
+					SnapshotId: snapshotID,
 				},
 			},
 		}
 	}
 
-	log.Infof("CreateVolume: create volume %s(size: %d) successfully", volumeID, req.GetCapacityRange().GetRequiredBytes())
+	log.Infof("CreateVolume: create volume %s(size: %d) successfully", volumeID, capacityBytes)
 	return response, nil
 }
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	log.V(4).Infof("DeleteVolume: called with args %+v", *req)
+	log.V(4).Infof("DeleteVolume: called with args %+v", stripSecrets(req))
 	// check request
 	if err := validateDeleteVolumeRequest(req); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "DeleteVolume: fail to validate DeleteVolumeRequest: %s", err.Error())
 	}
 
 	volumeID := req.GetVolumeId()
+	if err := cs.leader.Check(ctx, sidecarProvisioner); err != nil {
+		return nil, err
+	}
 	if ok := cs.inFlight.Insert(volumeID); !ok {
 		return nil, status.Errorf(codes.Aborted, VolumeOperationAlreadyExists, volumeID)
 	}
@@ -362,10 +506,10 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	isSnapshotReadOnly := false
 	if pv.Spec.CSI != nil {
 		attributes := pv.Spec.CSI.VolumeAttributes
-		if value, exist := attributes[localtype.ParamSnapshotID]; exist && value != "" {
+		if value, exist := utils.LookupParam(attributes, localtype.ParamSnapshotID); exist && value != "" {
 			isSnapshot = true
 		}
-		if value, exist := attributes[localtype.ParamReadonly]; exist && value == "true" {
+		if value, exist := utils.LookupParam(attributes, localtype.ParamReadonly); exist && value == "true" {
 			isSnapshotReadOnly = true
 		}
 	}
@@ -395,7 +539,7 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 			return &csi.DeleteVolumeResponse{}, nil
 		}
 
-		if lvName, err := conn.GetVolume(ctx, vgName, volumeID); err != nil {
+		if lvName, err := conn.GetVolume(ctx, vgName, utils.GetLVNameFromCsiPV(pv)); err != nil {
 			if strings.Contains(err.Error(), "Failed to find logical volume") {
 				log.Warningf("DeleteVolume: lvm volume not found, skip deleting %s", volumeID)
 				return &csi.DeleteVolumeResponse{}, nil
@@ -407,11 +551,21 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 			}
 		} else {
 			if lvName != "" {
-				log.Infof("DeleteVolume: found lv %s at node %s, now deleting", utils.GetNameKey(vgName, volumeID), nodeName)
-				if err := conn.DeleteVolume(ctx, vgName, volumeID); err != nil {
-					return nil, status.Errorf(codes.Internal, "DeleteVolume: fail to delete lv %s: %s", volumeID, err.Error())
+				log.Infof("DeleteVolume: found lv %s at node %s, now deleting", utils.GetNameKey(vgName, lvName), nodeName)
+				if utils.GetParam(pv.Spec.CSI.VolumeAttributes, localtype.ParamDeleteOrphanedSnapshots) == "true" {
+					// node removes orphaned snapshots of lv tagged before removing it
+					if err := conn.AddVolumeTags(ctx, vgName, lvName, []string{localtype.DeleteOrphanedSnapshotsLVTag}); err != nil {
+						return nil, status.Errorf(codes.Internal, "DeleteVolume: fail to tag lv %s to delete orphaned snapshots: %s", lvName, err.Error())
+					}
+				}
+				if err := conn.DeleteVolume(ctx, vgName, lvName); err != nil {
+					code := codes.Internal
+					if strings.Contains(err.Error(), server.ErrOriginHasSnapshots.Error()) {
+						code = codes.FailedPrecondition
+					}
+					return nil, status.Errorf(code, "DeleteVolume: fail to delete lv %s: %s", lvName, err.Error())
 				}
-				log.Infof("DeleteVolume: delete lv %s at node %s successfully", utils.GetNameKey(vgName, volumeID), nodeName)
+				log.Infof("DeleteVolume: delete lv %s at node %s successfully", utils.GetNameKey(vgName, lvName), nodeName)
 			} else {
 				log.Warningf("DeleteVolume: empty lv name, skip deleting %s", volumeID)
 				return &csi.DeleteVolumeResponse{}, nil
@@ -434,6 +588,14 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 		if err := conn.CleanDevice(ctx, device); err != nil {
 			return nil, status.Errorf(codes.Internal, "DeleteVolume: fail to delete device: %s", err.Error())
 		}
+		if pv.Spec.CSI != nil && utils.GetParam(pv.Spec.CSI.VolumeAttributes, localtype.ParamWipeOnDelete) == "true" {
+			// device is listed before pv is gone, so it is never seen free
+			// until agent verifies it is wiped
+			if err := cs.markDeviceWiping(ctx, nodeName, device); err != nil {
+				return nil, status.Errorf(codes.Internal, "DeleteVolume: fail to mark device %s wiping: %s", device, err.Error())
+			}
+			log.Infof("DeleteVolume: device %s at node %s is released to be wiped by agent", device, nodeName)
+		}
 		log.Infof("DeleteVolume: delete Device volume(%s) successfully", volumeID)
 	default:
 		return nil, status.Errorf(codes.InvalidArgument, "DeleteVolume: volumeType %s not supported %s", volumeType, volumeID)
@@ -447,7 +609,7 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 // 只读快照
 // 读写快照
 func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	log.V(4).Infof("CreateSnapshot: called with args %+v", *req)
+	log.V(4).Infof("CreateSnapshot: called with args %+v", stripSecrets(req))
 
 	// check request
 	snapshotName := req.GetName()
@@ -469,6 +631,9 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		return nil, status.Errorf(codes.Internal, "CreateSnapshot: fail to get vgName of pv %s", srcPV.Name)
 	}
 	log.Infof("CreateSnapshot: vg of snapshot %s is %s", snapshotName, vgName)
+	if srcPV.Spec.CSI != nil && isReadOnlySnapshotVolume(srcPV.Spec.CSI.VolumeAttributes) {
+		return nil, status.Errorf(codes.FailedPrecondition, "CreateSnapshot: source volume %s is a readonly snapshot, snapshot of snapshot is not supported", srcVolumeID)
+	}
 
 	// get nodeName
 	nodeName := utils.GetNodeNameFromCsiPV(srcPV)
@@ -476,6 +641,9 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		return nil, status.Errorf(codes.Internal, "CreateSnapshot: fail to get node name of pv %s", srcPV.Name)
 	}
 
+	if err := cs.leader.Check(ctx, sidecarSnapshotter); err != nil {
+		return nil, err
+	}
 	if ok := cs.inFlight.Insert(snapshotName); !ok {
 		return nil, status.Errorf(codes.Aborted, VolumeOperationAlreadyExists, snapshotName)
 	}
@@ -492,33 +660,57 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	var sizeBytes int64
 	readonly := false
-	if value, exist := req.Parameters[localtype.ParamReadonly]; exist && value == "true" {
+	if value, exist := utils.LookupParam(req.Parameters, localtype.ParamReadonly); exist && value == "true" {
 		readonly = true
 	}
+	// rw snapshot is backed up file by file from the mounted temp snapshot
+	if !readonly && isBlockVolume(srcPV) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: source volume %s is block mode, only readonly snapshot is supported", srcVolumeID)
+	}
+	// fsfreeze only makes sense for filesystem-backed origin volumes
+	fsFreeze := false
+	if value, exist := utils.LookupParam(req.Parameters, localtype.ParamSnapshotFsFreeze); exist && value == "true" {
+		if isBlockVolume(srcPV) {
+			log.Infof("CreateSnapshot: source volume %s is block mode, skip fsfreeze", srcVolumeID)
+		} else {
+			fsFreeze = true
+		}
+	}
+	cowPVs, err := utils.GetSnapshotCOWPVs(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: snapshot %s: %s", req.Name, err.Error())
+	}
 	if readonly {
 		// 只读快照
 		log.Infof("snapshot %s is readonly", snapshotName)
-		// get snapshot initial size from parameter
-		initialSize, _, _, err := getSnapshotInitialInfo(req.Parameters)
+		// get snapshot initial size from parameter, percentage is resolved by pv size
+		srcPVSize, _ := srcPV.Spec.Capacity.Storage().AsInt64()
+		initialSize, _, _, err := getSnapshotInitialInfo(req.Parameters, uint64(srcPVSize))
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "CreateSnapshot: get snapshot %s initial info error: %s", req.Name, err.Error())
 		}
 		// update initialSize if initialSize is bigger than pv request size
-		srcPVSize, _ := srcPV.Spec.Capacity.Storage().AsInt64()
 		if srcPVSize < int64(initialSize) {
 			initialSize = uint64(srcPVSize)
 		}
 
 		// create lvm snapshot
+		snapshotLVName, err := cs.getSnapshotLVName(snapshotName, nil)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot: fail to render lv name of snapshot %s: %s", snapshotName, err.Error())
+		}
 		var lvmName string
-		if lvmName, err = conn.GetVolume(ctx, vgName, snapshotName); err != nil {
+		if lvmName, err = conn.GetVolume(ctx, vgName, snapshotLVName); err != nil {
 			return nil, status.Errorf(codes.Internal, "CreateSnapshot: get lvm snapshot %s failed: %s", snapshotName, err.Error())
 		}
 		if lvmName == "" {
-			log.Infof("CreateSnapshot: ro snapshot %s not found, now creating with initialSize %d on node %s", utils.GetNameKey(vgName, snapshotName), snapshotName, initialSize, nodeName)
-			sizeBytes, err = conn.CreateSnapshot(ctx, vgName, snapshotName, srcVolumeID, true, int64(initialSize), nil)
+			if err := cs.checkSnapshotCapacity(ctx, nodeName, initialSize); err != nil {
+				return nil, err
+			}
+			log.Infof("CreateSnapshot: ro snapshot %s not found, now creating with initialSize %d on node %s", utils.GetNameKey(vgName, snapshotLVName), initialSize, nodeName)
+			sizeBytes, err = conn.CreateSnapshot(ctx, vgName, snapshotLVName, srcVolumeID, utils.GetLVNameFromCsiPV(srcPV), true, int64(initialSize), cowPVs, fsFreeze, nil)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "CreateSnapshot: create lvm snapshot %s failed: %s", snapshotName, err.Error())
+				return nil, status.Errorf(snapshotErrorCode(err), "CreateSnapshot: create lvm snapshot %s failed: %s", snapshotName, err.Error())
 			}
 			log.Infof("CreateSnapshot: create ro snapshot %s successfully", snapshotName)
 		} else {
@@ -531,9 +723,9 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	} else {
 		log.Infof("snapshot %s is readwrite, now creating...", snapshotName)
 		// create rw snapshot
-		sizeBytes, err = conn.CreateSnapshot(ctx, vgName, snapshotName, srcVolumeID, false, 0, req.Secrets)
+		sizeBytes, err = conn.CreateSnapshot(ctx, vgName, snapshotName, srcVolumeID, utils.GetLVNameFromCsiPV(srcPV), false, 0, cowPVs, fsFreeze, req.Secrets)
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "CreateSnapshot: fail to create snapshot %s: %s", snapshotName, err.Error())
+			return nil, status.Errorf(snapshotErrorCode(err), "CreateSnapshot: fail to create snapshot %s: %s", snapshotName, err.Error())
 		}
 		log.Infof("CreateSnapshot: create rw snapshot %s successfully", snapshotName)
 		if sizeBytes == 0 {
@@ -546,14 +738,329 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 	return cs.newCreateSnapshotResponse(snapshotName, req.SourceVolumeId, sizeBytes)
 }
 
+// isReadOnlySnapshotVolume checks whether volume mounts a readonly snapshot lv
+// directly rather than a lv of its own
+func isReadOnlySnapshotVolume(attributes map[string]string) bool {
+	return utils.GetParam(attributes, localtype.ParamSnapshotID) != "" && utils.GetParam(attributes, localtype.ParamReadonly) == "true"
+}
+
+// isBlockVolume checks whether pv is consumed as raw block device
+func isBlockVolume(pv *v1.PersistentVolume) bool {
+	return pv.Spec.VolumeMode != nil && *pv.Spec.VolumeMode == v1.PersistentVolumeBlock
+}
+
+// requestsBlockVolume checks whether volume is requested with block access
+func requestsBlockVolume(caps []*csi.VolumeCapability) bool {
+	for _, c := range caps {
+		if c.GetBlock() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotErrorCode returns code of snapshot error reported by node, message
+// of the error is all that is left after grpc
+func snapshotErrorCode(err error) codes.Code {
+	switch {
+	case strings.Contains(err.Error(), server.ErrSnapshotNoSpace.Error()):
+		return codes.ResourceExhausted
+	case strings.Contains(err.Error(), server.ErrSnapshotOfSnapshot.Error()), strings.Contains(err.Error(), server.ErrSnapshotCOWPVNotFound.Error()):
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// copySnapshotToLV creates lv on the node holding the snapshot and copies
+// the whole snapshot lv into it, the lv is removed if copy fails
+func (cs *controllerServer) copySnapshotToLV(ctx context.Context, conn client.Connection, nodeName, volumeID string, srcPV *v1.PersistentVolume, snapshotLVName, lvName string, requiredBytes int64, verifyChecksum bool, parameters map[string]string) error {
+	srcNodeName := utils.GetNodeNameFromCsiPV(srcPV)
+	if srcNodeName != nodeName {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume: snapshot of pv %s is on node %s, can not be copied to node %s", srcPV.Name, srcNodeName, nodeName)
+	}
+	vgName := utils.GetVGNameFromCsiPV(srcPV)
+	// snapshot lv has the same logical size as its origin
+	srcQuantity := srcPV.Spec.Capacity[v1.ResourceStorage]
+	if requiredBytes < srcQuantity.Value() {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume: requested size %d is smaller than logical size %d of snapshot lv %s", requiredBytes, srcQuantity.Value(), utils.GetNameKey(vgName, snapshotLVName))
+	}
+
+	// lv is tagged the same as lv created for volume without content source,
+	// so that it is reconciled, collected and discarded the same way
+	options := &client.LVMOptions{
+		Name:        lvName,
+		VolumeGroup: vgName,
+		Size:        uint64(requiredBytes),
+		Tags:        []string{localtype.ManagedLVTag, createdForTag(volumeID)},
+	}
+	if utils.GetParam(parameters, localtype.ParamDiscardOnDelete) == "true" {
+		options.Tags = append(options.Tags, localtype.DiscardOnDeleteLVTag)
+	}
+	existLVName, err := conn.GetVolume(ctx, vgName, lvName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: fail to get lv %s from node %s: %s", lvName, nodeName, err.Error())
+	}
+	if existLVName == "" {
+		if err := cs.checkVGForNewLV(ctx, conn, nodeName, vgName, uint64(requiredBytes)); err != nil {
+			return err
+		}
+		if err := createLVOnNode(ctx, conn, options, nodeName, parameters); err != nil {
+			return err
+		}
+	} else if lv, err := conn.DescribeVolume(ctx, vgName, lvName); err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: fail to describe lv %s from node %s: %s", lvName, nodeName, err.Error())
+	} else if reconcile, err := reconcileExistingLV(lv, options, volumeID); err != nil {
+		return status.Errorf(codes.AlreadyExists, "CreateVolume: lv %s at node %s: %s", lvName, nodeName, err.Error())
+	} else if reconcile {
+		if err := createLVOnNode(ctx, conn, options, nodeName, parameters); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("CreateVolume: lv %s already created at node %s", lvName, nodeName)
+	}
+
+	// copy again even if lv exists, since the last copy may be interrupted
+	src := filepath.Join("/dev", vgName, snapshotLVName)
+	dest := filepath.Join("/dev", vgName, lvName)
+	if err := conn.CloneVolume(ctx, src, dest, verifyChecksum); err != nil {
+		// partial or corrupted data must not be left to the next retry
+		if delErr := conn.DeleteVolume(ctx, vgName, lvName); delErr != nil {
+			log.Errorf("CreateVolume: fail to clean up lv %s at node %s: %s", utils.GetNameKey(vgName, lvName), nodeName, delErr.Error())
+		}
+		return status.Errorf(codes.Internal, "CreateVolume: fail to copy %s to %s at node %s: %s", src, dest, nodeName, err.Error())
+	}
+	log.Infof("CreateVolume: copy %s to %s at node %s successfully(verify checksum: %t)", src, dest, nodeName, verifyChecksum)
+	return nil
+}
+
+// checkDMNameOnNode checks that dm name of new lv fits in the limit and is not
+// used by lv of another pv at node, lv names rendered from long pvc names are
+// shortened with hash suffix and are never expected to collide
+func (cs *controllerServer) checkDMNameOnNode(volumeID, nodeName, vgName, lvName string) error {
+	if err := utils.ValidateDMName(vgName, lvName); err != nil {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume: volume %s: %s", volumeID, err.Error())
+	}
+	dmName := utils.DMName(vgName, lvName)
+	pvs, err := cs.pvLister.List(labels.Everything())
+	if err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: fail to list pv: %s", err.Error())
+	}
+	for _, pv := range pvs {
+		if pv.Name == volumeID || pv.Spec.CSI == nil || pv.Spec.CSI.VolumeAttributes[pkg.VolumeTypeKey] != string(pkg.VolumeTypeLVM) {
+			continue
+		}
+		if utils.GetNodeNameFromCsiPV(pv) == nodeName && utils.DMName(utils.GetVGNameFromCsiPV(pv), utils.GetLVNameFromCsiPV(pv)) == dmName {
+			return status.Errorf(codes.AlreadyExists, "CreateVolume: dm name %s of volume %s at node %s is already used by pv %s", dmName, volumeID, nodeName, pv.Name)
+		}
+	}
+	return nil
+}
+
+// adoptLV validates existing lv target(vg/lv) on node and tags it as managed
+// instead of creating a new one, lv already used by another pv is refused.
+// It returns vg, lv and size of the adopted lv
+func (cs *controllerServer) adoptLV(ctx context.Context, conn client.Connection, volumeID, nodeName, target string, capacityRange *csi.CapacityRange, parameters map[string]string) (string, string, int64, error) {
+	vgName, lvName, ok := strings.Cut(target, "/")
+	if !ok || vgName == "" || lvName == "" || strings.Contains(lvName, "/") {
+		return "", "", 0, status.Errorf(codes.InvalidArgument, "CreateVolume: invalid %s %q of volume %s, must be in the form of vg/lv", localtype.AnnotationPVCAdoptLVKey, target, volumeID)
+	}
+	if value, exist := parameters[VgNameTag]; exist && value != "" && value != vgName {
+		return "", "", 0, status.Errorf(codes.InvalidArgument, "CreateVolume: lv %s to adopt is not in vg %s of storage class", target, value)
+	}
+	// two pvcs adopting the same lv at the same time
+	adoptKey := "adopt/" + utils.GetNameKey(nodeName, target)
+	if !cs.inFlight.Insert(adoptKey) {
+		return "", "", 0, status.Errorf(codes.Aborted, VolumeOperationAlreadyExists, target)
+	}
+	defer cs.inFlight.Delete(adoptKey)
+
+	pvs, err := cs.pvLister.List(labels.Everything())
+	if err != nil {
+		return "", "", 0, status.Errorf(codes.Internal, "CreateVolume: fail to list pv: %s", err.Error())
+	}
+	for _, pv := range pvs {
+		if pv.Name == volumeID || pv.Spec.CSI == nil || pv.Spec.CSI.VolumeAttributes[pkg.VolumeTypeKey] != string(pkg.VolumeTypeLVM) {
+			continue
+		}
+		if utils.GetNodeNameFromCsiPV(pv) == nodeName && utils.GetVGNameFromCsiPV(pv) == vgName && utils.GetLVNameFromCsiPV(pv) == lvName {
+			return "", "", 0, status.Errorf(codes.FailedPrecondition, "CreateVolume: lv %s at node %s is already managed by pv %s", target, nodeName, pv.Name)
+		}
+	}
+
+	lv, err := conn.DescribeVolume(ctx, vgName, lvName)
+	if err != nil {
+		if strings.Contains(err.Error(), "Failed to find logical volume") || strings.Contains(err.Error(), "Volume group \""+vgName+"\" not found") {
+			return "", "", 0, status.Errorf(codes.NotFound, "CreateVolume: lv %s to adopt is not found at node %s: %s", target, nodeName, err.Error())
+		}
+		return "", "", 0, status.Errorf(codes.Internal, "CreateVolume: fail to get lv %s from node %s: %s", target, nodeName, err.Error())
+	}
+	if lv == nil {
+		return "", "", 0, status.Errorf(codes.NotFound, "CreateVolume: lv %s to adopt is not found at node %s", target, nodeName)
+	}
+	size := int64(lv.GetSize())
+	if size < capacityRange.GetRequiredBytes() || (capacityRange.GetLimitBytes() > 0 && size > capacityRange.GetLimitBytes()) {
+		return "", "", 0, status.Errorf(codes.OutOfRange, "CreateVolume: size %d of lv %s mismatches requested size %d(limit %d)", size, target, capacityRange.GetRequiredBytes(), capacityRange.GetLimitBytes())
+	}
+
+	managed := false
+	for _, tag := range lv.GetTags() {
+		if tag == localtype.ManagedLVTag {
+			managed = true
+			break
+		}
+	}
+	if !managed {
+		if err := conn.AddVolumeTags(ctx, vgName, lvName, []string{localtype.ManagedLVTag}); err != nil {
+			return "", "", 0, status.Errorf(codes.Internal, "CreateVolume: fail to tag lv %s at node %s: %s", target, nodeName, err.Error())
+		}
+	}
+	log.Infof("CreateVolume: adopt lv %s(size: %d) at node %s as volume %s", target, size, nodeName, volumeID)
+	return vgName, lvName, size, nil
+}
+
+// createLVOnNode creates lv by options at node, io alignment of lv is recorded
+// in parameters
+func createLVOnNode(ctx context.Context, conn client.Connection, options *client.LVMOptions, nodeName string, parameters map[string]string) error {
+	outstr, ioAlignment, err := conn.CreateVolume(ctx, options)
+	if err != nil {
+		code := codes.Internal
+		switch status.Code(err) {
+		case codes.Aborted, codes.ResourceExhausted, codes.InvalidArgument, codes.AlreadyExists:
+			code = status.Code(err)
+		}
+		return status.Errorf(code, "CreateVolume: fail to create lv %s(options: %v): %s", utils.GetNameKey(options.VolumeGroup, options.Name), options, err.Error())
+	}
+	log.Infof("CreateLvm: create lvm %s in node %s with response %s successfully", utils.GetNameKey(options.VolumeGroup, options.Name), nodeName, outstr)
+	if ioAlignment != "" {
+		parameters[localtype.ParamIOAlignment] = ioAlignment
+	}
+	return nil
+}
+
+func createdForTag(volumeID string) string {
+	return localtype.CreatedForLVTagPrefix + volumeID
+}
+
+// reconcileExistingLV tells whether existing lv of the volume is to be
+// completed by creating it again: lv to be zeroed still has zeroing tag, or
+// lv is created for the volume by a request which may never have returned or
+// assigned to the volume from preallocated pool. Lv created for the other
+// volume is an error, lv created by old version is taken as it is
+func reconcileExistingLV(lv *lib.LogicalVolume, options *client.LVMOptions, volumeID string) (bool, error) {
+	if lv == nil {
+		return false, nil
+	}
+	if options.Zero && hasLVTag(lv, localtype.ZeroingLVTag) {
+		return true, nil
+	}
+	for _, tag := range lv.GetTags() {
+		if !strings.HasPrefix(tag, localtype.CreatedForLVTagPrefix) {
+			continue
+		}
+		if tag != createdForTag(volumeID) {
+			return false, fmt.Errorf("lv is created for volume %s", strings.TrimPrefix(tag, localtype.CreatedForLVTagPrefix))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// checkVGForNewLV rejects creating new lv of size in vg which is under
+// maintenance, has full metadata area, would be left with less free space
+// than minFreeSize or already holds maxLogicalVolumes open-local lvs, the
+// count is taken from PVs and unassigned preallocated lvs rather than
+// NodeLocalStorage status to avoid racing with the agent
+func (cs *controllerServer) checkVGForNewLV(ctx context.Context, conn client.Connection, nodeName, vgName string, size uint64) error {
+	nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return status.Errorf(codes.Internal, "CreateVolume: fail to get nls %s: %s", nodeName, err.Error())
+	}
+	if utils.IsVGInMaintenance(nls, vgName) {
+		return status.Errorf(codes.ResourceExhausted, "CreateVolume: %s", errors.NewVGInMaintenanceError(vgName, nodeName).Error())
+	}
+	// lvcreate fails deep in lvm once vg metadata area is full
+	for _, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+		if vg.Name == vgName && utils.IsVGMetadataExhausted(vg.MetadataFree, vg.MetadataSize) {
+			return status.Errorf(codes.ResourceExhausted, "CreateVolume: %s", errors.NewVGMetadataExhaustedError(vg.MetadataFree, vg.MetadataSize, vgName, nodeName).Error())
+		}
+	}
+	if code, err := checkVGMinFree(ctx, conn, nls, nodeName, vgName, size); err != nil {
+		return status.Errorf(code, "CreateVolume: %s", err.Error())
+	}
+	lvLimit := int64(nls.Spec.ListConfig.VGs.MaxLogicalVolumes)
+	if lvLimit <= 0 {
+		return nil
+	}
+	pvs, err := cs.pvLister.List(labels.Everything())
+	if err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: fail to list pv: %s", err.Error())
+	}
+	// counted the same way as schedulers do
+	lvCount := utils.GetPreallocatedLVs(nls)[vgName].Count
+	for _, pv := range pvs {
+		if utils.IsLVOfVG(pv, vgName) && utils.GetNodeNameFromCsiPV(pv) == nodeName {
+			lvCount++
+		}
+	}
+	if utils.IsVGLVLimitReached(lvCount, lvLimit) {
+		return status.Errorf(codes.ResourceExhausted, "CreateVolume: %s", errors.NewInsufficientLVCountError(lvCount, lvLimit, vgName, nodeName).Error())
+	}
+	return nil
+}
+
+// checkVGMinFree returns error if growing space used in vg by growth leaves
+// free space of vg less than minFreeSize of nls, free space is taken from
+// lvmd since status of nls lags behind
+func checkVGMinFree(ctx context.Context, conn client.Connection, nls *localv1alpha1.NodeLocalStorage, nodeName, vgName string, growth uint64) (codes.Code, error) {
+	if nls == nil || strings.TrimSpace(nls.Spec.ListConfig.VGs.MinFreeSize) == "" {
+		return codes.OK, nil
+	}
+	vg, err := conn.DescribeVolumeGroup(ctx, vgName)
+	if err != nil {
+		return codes.Internal, fmt.Errorf("fail to get vg %s from node %s: %s", vgName, nodeName, err.Error())
+	}
+	if vg == nil {
+		return codes.Internal, fmt.Errorf("vg %s not found on node %s", vgName, nodeName)
+	}
+	minFree, err := utils.VGMinFree(nls, vg.Size)
+	if err != nil {
+		return codes.FailedPrecondition, fmt.Errorf("nls %s: %s", nodeName, err.Error())
+	}
+	if vg.FreeSize < growth || vg.FreeSize-growth < minFree {
+		return codes.ResourceExhausted, errors.NewVGMinFreeBreachedError(growth, vg.FreeSize, minFree, vgName, nodeName)
+	}
+	return codes.OK, nil
+}
+
+// getSnapshotLVName returns the lv name of snapshot rendered by lv name template,
+// snapContent will be fetched if not given
+func (cs *controllerServer) getSnapshotLVName(snapshotID string, snapContent *snapshotapi.VolumeSnapshotContent) (string, error) {
+	if cs.options.lvNameTemplate == "" || cs.options.lvNameTemplate == utils.DefaultLVNameTemplate {
+		return snapshotID, nil
+	}
+	if snapContent == nil {
+		var err error
+		if snapContent, err = utils.GetVolumeSnapshotContent(cs.options.snapclient, snapshotID); err != nil {
+			return "", fmt.Errorf("fail to get snapshot content of %s: %s", snapshotID, err.Error())
+		}
+	}
+	return utils.RenderLVName(cs.options.lvNameTemplate, snapshotID, snapContent.Spec.VolumeSnapshotRef.Name, snapContent.Spec.VolumeSnapshotRef.Namespace)
+}
+
 // DeleteSnapshot delete lvm snapshot
 func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	log.Infof("DeleteSnapshot: called with args %+v", *req)
+	log.Infof("DeleteSnapshot: called with args %+v", stripSecrets(req))
 	// check req
 	snapshotID := req.GetSnapshotId()
 	if len(snapshotID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot: Snapshot ID not provided")
 	}
+	if err := cs.leader.Check(ctx, sidecarSnapshotter); err != nil {
+		return nil, err
+	}
 
 	// get volumeID from snapshotcontent
 	snapContent, err := utils.GetVolumeSnapshotContent(cs.options.snapclient, snapshotID)
@@ -568,7 +1075,7 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 	// 判断是否为只读快照
 	readonly := false
-	if value, exist := snapshotClass.Parameters[localtype.ParamReadonly]; exist && value == "true" {
+	if value, exist := utils.LookupParam(snapshotClass.Parameters, localtype.ParamReadonly); exist && value == "true" {
 		readonly = true
 	}
 
@@ -581,7 +1088,7 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 	if readonly {
 		// 要求删除快照时 源pv 还在，不然快照删除不了
-		// 这里可以保证 源pv 在有快照的情况下 delete volume 会失败: logical volume ** has snapshot, please remove snapshot first
+		// 这里可以保证 源pv 在有快照的情况下 delete volume 会失败: logical volume has snapshots: lv ** has snapshots ...
 		pv, err := cs.pvLister.Get(srcVolumeID)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "DeleteSnapshot: fail to get pv: %s", err.Error())
@@ -603,13 +1110,17 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 		defer conn.Close()
 
 		// delete lvm snapshot
+		snapshotLVName, err := cs.getSnapshotLVName(snapshotID, snapContent)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "DeleteSnapshot: fail to render lv name of snapshot %s: %s", snapshotID, err.Error())
+		}
 		var lvmName string
-		if lvmName, err = conn.GetVolume(ctx, vgName, snapshotID); err != nil {
+		if lvmName, err = conn.GetVolume(ctx, vgName, snapshotLVName); err != nil {
 			return nil, status.Errorf(codes.Internal, "DeleteSnapshot: get lvm snapshot %s failed: %s", snapshotID, err.Error())
 		}
 		if lvmName != "" {
-			log.Infof("DeleteSnapshot: lvm ro snapshot %s found, now deleting...", snapshotID)
-			err := conn.DeleteSnapshot(ctx, vgName, snapshotID, true, nil)
+			log.Infof("DeleteSnapshot: lvm ro snapshot %s found, now deleting...", snapshotLVName)
+			err := conn.DeleteSnapshot(ctx, vgName, snapshotLVName, true, nil)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal, "DeleteSnapshot: delete lvm snapshot %s failed: %s", snapshotID, err.Error())
 			}
@@ -642,7 +1153,11 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 // ControllerExpandVolume expand volume
 func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	log.V(4).Infof("ControllerExpandVolume: called with args %+v", *req)
+	log.V(4).Infof("ControllerExpandVolume: called with args %+v", stripSecrets(req))
+
+	if err := cs.leader.Check(ctx, sidecarResizer); err != nil {
+		return nil, err
+	}
 
 	// Step 1: get vgName
 	volumeID := req.GetVolumeId()
@@ -660,6 +1175,12 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 
 	// Step 2: check whether the volume can be expanded
 	volSizeBytes := int64(req.GetCapacityRange().GetRequiredBytes())
+	// size range of storage class is recorded in volume attributes
+	if pv.Spec.CSI != nil {
+		if code, err := checkVolumeSizeRange(volSizeBytes, pv.Spec.CSI.VolumeAttributes); err != nil {
+			return nil, status.Errorf(code, "ControllerExpandVolume: volume %s: %s", volumeID, err.Error())
+		}
+	}
 
 	// Step 3: get grpc client
 	nodeName := utils.GetNodeNameFromCsiPV(pv)
@@ -672,17 +1193,134 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	}
 	defer conn.Close()
 
-	// Step 4: expand volume
-	if err := conn.ExpandVolume(ctx, vgName, volumeID, uint64(volSizeBytes)); err != nil {
-		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume: fail to expand lv %s: %s", utils.GetNameKey(vgName, volumeID), err.Error())
+	// Step 4: check free space floor of vg
+	lvName := utils.GetLVNameFromCsiPV(pv)
+	if code, err := cs.checkExpansionMinFree(ctx, conn, nodeName, vgName, lvName, uint64(volSizeBytes)); err != nil {
+		return nil, status.Errorf(code, "ControllerExpandVolume: volume %s: %s", volumeID, err.Error())
 	}
 
-	log.Infof("ControllerExpandVolume: expand lvm %s in node %s successfully", utils.GetNameKey(vgName, volumeID), nodeName)
+	// Step 5: expand volume
+	if err := conn.ExpandVolume(ctx, vgName, lvName, uint64(volSizeBytes)); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume: fail to expand lv %s: %s", utils.GetNameKey(vgName, lvName), err.Error())
+	}
+
+	log.Infof("ControllerExpandVolume: expand lvm %s in node %s successfully", utils.GetNameKey(vgName, lvName), nodeName)
 	return &csi.ControllerExpandVolumeResponse{CapacityBytes: volSizeBytes, NodeExpansionRequired: true}, nil
 }
 
+// checkExpansionMinFree checks floor of free space in vg against growth of
+// lv expanded to size, lv is described only if the floor is set
+func (cs *controllerServer) checkExpansionMinFree(ctx context.Context, conn client.Connection, nodeName, vgName, lvName string, size uint64) (codes.Code, error) {
+	nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return codes.OK, nil
+		}
+		return codes.Internal, fmt.Errorf("fail to get nls %s: %s", nodeName, err.Error())
+	}
+	if strings.TrimSpace(nls.Spec.ListConfig.VGs.MinFreeSize) == "" {
+		return codes.OK, nil
+	}
+	lv, err := conn.DescribeVolume(ctx, vgName, lvName)
+	if err != nil {
+		return codes.Internal, fmt.Errorf("fail to get lv %s from node %s: %s", utils.GetNameKey(vgName, lvName), nodeName, err.Error())
+	}
+	if lv == nil || lv.Size >= size {
+		return codes.OK, nil
+	}
+	return checkVGMinFree(ctx, conn, nls, nodeName, vgName, size-lv.Size)
+}
+
+// ControllerModifyVolume applies mutable parameters of volume attributes class
+// to an existing volume, only io throttling of lvm volume can be modified
+func (cs *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	log.V(4).Infof("ControllerModifyVolume: called with args %+v", stripSecrets(req))
+	if err := validateModifyVolumeRequest(req); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerModifyVolume: %s", err.Error())
+	}
+	volumeID := req.GetVolumeId()
+	if ok := cs.inFlight.Insert(volumeID); !ok {
+		return nil, status.Errorf(codes.Aborted, VolumeOperationAlreadyExists, volumeID)
+	}
+	defer func() {
+		cs.inFlight.Delete(volumeID)
+	}()
+
+	// Step 1: get lv of volume
+	pv, err := cs.pvLister.Get(volumeID)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "ControllerModifyVolume: volume %s not found", volumeID)
+		}
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to get pv: %s", err.Error())
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeAttributes[localtype.VolumeTypeKey] != string(localtype.VolumeTypeLVM) {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerModifyVolume: io throttling of volume %s is not supported, only LVM volume supports it", volumeID)
+	}
+	vgName := utils.GetVGNameFromCsiPV(pv)
+	if vgName == "" {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to get vgName of pv %s", pv.Name)
+	}
+	lvName := utils.GetLVNameFromCsiPV(pv)
+
+	// Step 2: limits not in request are left unchanged
+	iops, bps := utils.GetIOThrottlingFromCsiPV(pv)
+	if value, ok := req.GetMutableParameters()[localtype.VolumeIOPS]; ok {
+		iops = value
+	}
+	if value, ok := req.GetMutableParameters()[localtype.VolumeBPS]; ok {
+		bps = value
+	}
+
+	// Step 3: set io throttling of pods using the volume
+	nodeName := utils.GetNodeNameFromCsiPV(pv)
+	if nodeName == "" {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to get node name of pv %s", pv.Name)
+	}
+	conn, err := cs.getNodeConn(nodeName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to get grpc client at node %s: %s", nodeName, err.Error())
+	}
+	defer conn.Close()
+	pods, err := conn.SetIOThrottling(ctx, vgName, lvName, iops, bps)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to set io throttling of lv %s: %s", utils.GetNameKey(vgName, lvName), err.Error())
+	}
+
+	// Step 4: record limits in pv, so that they are set when volume is published again
+	if err := cs.recordIOThrottling(ctx, pv.Name, iops, bps); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: fail to record io throttling in pv %s: %s", pv.Name, err.Error())
+	}
+	log.Infof("ControllerModifyVolume: io throttling of volume %s is modified to iops %q, bps %q for pods %v", volumeID, iops, bps, pods)
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+func (cs *controllerServer) recordIOThrottling(ctx context.Context, pvName, iops, bps string) error {
+	pv, err := cs.options.kubeclient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = map[string]string{}
+	}
+	for key, value := range map[string]string{localtype.AnnotationPVIOPSKey: iops, localtype.AnnotationPVBPSKey: bps} {
+		if value == "" {
+			delete(pv.Annotations, key)
+		} else {
+			pv.Annotations[key] = value
+		}
+	}
+	_, err = cs.options.kubeclient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{})
+	return err
+}
+
+func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	log.V(4).Infof("ControllerGetVolume: called with args %+v", *req)
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	log.V(4).Infof("ControllerPublishVolume: called with args %+v", *req)
+	log.V(4).Infof("ControllerPublishVolume: called with args %+v", stripSecrets(req))
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
@@ -702,12 +1340,12 @@ func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolume
 }
 
 func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	log.V(4).Infof("ListSnapshots: called with args %+v", *req)
+	log.V(4).Infof("ListSnapshots: called with args %+v", stripSecrets(req))
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	log.V(4).Infof("ValidateVolumeCapabilities: called with args %+v", *req)
+	log.V(4).Infof("ValidateVolumeCapabilities: called with args %+v", stripSecrets(req))
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
@@ -810,6 +1448,59 @@ type PVAllocatedInfo struct {
 	VolumeType string `json:"volumeType"`
 }
 
+// getSelectedNode returns the node which volume must be provisioned on.
+// external-provisioner passes the selected node of pvc as the first preferred
+// topology, pvc in informer cache is refreshed if it does not match the hint,
+// and provisioning is aborted(and retried) rather than going to another node
+func (cs *controllerServer) getSelectedNode(ctx context.Context, req *csi.CreateVolumeRequest, pvc *v1.PersistentVolumeClaim) (string, error) {
+	preferredNode := getPreferredNode(req)
+	nodeName := pvc.Annotations[pkg.AnnoSelectedNode]
+	if preferredNode != "" && nodeName != preferredNode {
+		log.Warningf("CreateVolume: selected node %q of pvc %s in cache does not match preferred node %q, fetching it again", nodeName, utils.GetNameKey(pvc.Namespace, pvc.Name), preferredNode)
+		latest, err := cs.options.kubeclient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "CreateVolume: fail to get pvc: %s", err.Error())
+		}
+		nodeName = latest.Annotations[pkg.AnnoSelectedNode]
+	}
+	if nodeName == "" {
+		return "", status.Errorf(codes.Unimplemented, "CreateVolume: no annotation %s found in pvc %s. Check if volumeBindingMode of storageclass is WaitForFirstConsumer, cause we only support WaitForFirstConsumer mode", pkg.AnnoSelectedNode, utils.GetNameKey(pvc.Namespace, pvc.Name))
+	}
+	if preferredNode != "" && nodeName != preferredNode {
+		return "", status.Errorf(codes.Aborted, "CreateVolume: selected node %s of pvc %s does not match preferred node %s", nodeName, utils.GetNameKey(pvc.Namespace, pvc.Name), preferredNode)
+	}
+	return nodeName, nil
+}
+
+// getPreferredNode returns node name in the first preferred topology
+func getPreferredNode(req *csi.CreateVolumeRequest) string {
+	preferred := req.GetAccessibilityRequirements().GetPreferred()
+	if len(preferred) == 0 {
+		return ""
+	}
+	return preferred[0].GetSegments()[pkg.KubernetesNodeIdentityKey]
+}
+
+// scheduleErrorCode keeps schedule errors retryable, so that volume is never
+// provisioned on a node other than the selected one
+func scheduleErrorCode(err error) codes.Code {
+	if status.Code(err) == codes.Aborted {
+		return codes.Aborted
+	}
+	if strings.Contains(err.Error(), "Insufficient") {
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+// checkScheduledNode makes sure volume is scheduled to the selected node
+func checkScheduledNode(volumeInfo *pkg.BindingInfo, nodeSelected string) error {
+	if volumeInfo.Node != nodeSelected {
+		return status.Errorf(codes.Aborted, "%s volume of pvc %s is scheduled to node %s, but selected node is %s", volumeInfo.VolumeType, volumeInfo.PersistentVolumeClaim, volumeInfo.Node, nodeSelected)
+	}
+	return nil
+}
+
 func (cs *controllerServer) scheduleLVMVolume(nodeSelected, pvcName, pvcNameSpace string, parameters map[string]string) (map[string]string, error) {
 	vgName := ""
 	paraList := map[string]string{}
@@ -824,12 +1515,25 @@ func (cs *controllerServer) scheduleLVMVolume(nodeSelected, pvcName, pvcNameSpac
 		if volumeInfo.VgName == "" || volumeInfo.Node == "" {
 			return nil, status.Errorf(codes.InvalidArgument, "Lvm Schedule finished, but get empty: %v", volumeInfo)
 		}
+		if err := checkScheduledNode(volumeInfo, nodeSelected); err != nil {
+			return nil, err
+		}
 		vgName = volumeInfo.VgName
 	}
 	paraList[VgNameTag] = vgName
 	return paraList, nil
 }
 
+// reserveSnapshotCopy reserves size of full copy of readonly snapshot in the
+// scheduler, which allocates it in vg of the source volume
+func (cs *controllerServer) reserveSnapshotCopy(nodeSelected, pvcName, pvcNameSpace string) error {
+	volumeInfo, err := cs.adapter.ScheduleVolume(string(pkg.VolumeTypeLVM), pvcName, pvcNameSpace, "", nodeSelected)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "lvm schedule with error "+err.Error())
+	}
+	return checkScheduledNode(volumeInfo, nodeSelected)
+}
+
 func (cs *controllerServer) scheduleMountpointVolume(nodeSelected, pvcName, pvcNameSpace string, parameters map[string]string) (map[string]string, error) {
 	paraList := map[string]string{}
 	volumeInfo, err := cs.adapter.ScheduleVolume(string(pkg.VolumeTypeMountPoint), pvcName, pvcNameSpace, "", nodeSelected)
@@ -840,6 +1544,9 @@ func (cs *controllerServer) scheduleMountpointVolume(nodeSelected, pvcName, pvcN
 		log.Errorf("mountpoint Schedule finished, but get empty Disk: %v", volumeInfo)
 		return nil, status.Error(codes.InvalidArgument, "mountpoint schedule finish but Disk empty")
 	}
+	if err := checkScheduledNode(volumeInfo, nodeSelected); err != nil {
+		return nil, err
+	}
 	paraList[string(pkg.VolumeTypeMountPoint)] = volumeInfo.Disk
 	return paraList, nil
 }
@@ -854,10 +1561,108 @@ func (cs *controllerServer) scheduleDeviceVolume(nodeSelected, pvcName, pvcNameS
 		log.Errorf("Device Schedule finished, but get empty Disk: %v", volumeInfo)
 		return nil, status.Error(codes.InvalidArgument, "Device schedule finish but Disk empty")
 	}
+	if err := checkScheduledNode(volumeInfo, nodeSelected); err != nil {
+		return nil, err
+	}
 	paraList[string(pkg.VolumeTypeDevice)] = volumeInfo.Device
 	return paraList, nil
 }
 
+// completeVolumeContext records storage pool, media type and fsType chosen for
+// volume in volume context, so that node plugin uses them rather than deriving
+// them again. Attributes unknown to controller are left to node plugin, e.g. vg
+// of lvm volume scheduled by scheduling framework.
+func (cs *controllerServer) completeVolumeContext(ctx context.Context, volumeContext map[string]string, volumeType, nodeName string, volCaps []*csi.VolumeCapability) {
+	pool := ""
+	switch volumeType {
+	case string(pkg.VolumeTypeLVM):
+		pool = volumeContext[VgNameTag]
+	case string(pkg.VolumeTypeMountPoint):
+		pool = volumeContext[string(pkg.VolumeTypeMountPoint)]
+	case string(pkg.VolumeTypeDevice):
+		pool = volumeContext[string(pkg.VolumeTypeDevice)]
+	}
+	if pool != "" {
+		volumeContext[localtype.ParamStoragePool] = pool
+	}
+
+	// media type of lvm and mount point is known only if set in storage class
+	if _, exist := volumeContext[pkg.VolumeMediaType]; !exist && volumeType == string(pkg.VolumeTypeDevice) && pool != "" {
+		if mediaType := cs.getDeviceMediaType(ctx, nodeName, pool); mediaType != "" {
+			volumeContext[pkg.VolumeMediaType] = mediaType
+		}
+	}
+
+	// mount point volume is bind mounted, fsType makes no sense
+	if volumeType == string(pkg.VolumeTypeMountPoint) {
+		return
+	}
+	for _, volCap := range volCaps {
+		if mnt := volCap.GetMount(); mnt != nil {
+			fsType := mnt.GetFsType()
+			if fsType == "" {
+				fsType = DefaultFs
+			}
+			volumeContext[pkg.VolumeFSTypeKey] = fsType
+			return
+		}
+	}
+}
+
+// checkSnapshotCapacity rejects new snapshot of size bytes which takes total
+// size of snapshot lvs on node beyond maxSnapshotSize of nls
+func (cs *controllerServer) checkSnapshotCapacity(ctx context.Context, nodeName string, size uint64) error {
+	nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return status.Errorf(codes.Internal, "CreateSnapshot: fail to get nls %s: %s", nodeName, err.Error())
+	}
+	vgs := nls.Status.NodeStorageInfo.VolumeGroups
+	limit, limited, err := utils.SnapshotCapacityLimit(nls.Spec.ListConfig.VGs.MaxSnapshotSize, vgs)
+	if err != nil {
+		return status.Errorf(codes.Internal, "CreateSnapshot: nls %s: %s", nodeName, err.Error())
+	}
+	if !limited {
+		return nil
+	}
+	if used := utils.SnapshotCapacityUsed(vgs); used+size > limit {
+		return status.Errorf(codes.ResourceExhausted, "CreateSnapshot: %s", errors.NewSnapshotCapacityExceededError(size, used, limit, nodeName).Error())
+	}
+	return nil
+}
+
+// markDeviceWiping adds device to wiping annotation of nls of node
+func (cs *controllerServer) markDeviceWiping(ctx context.Context, nodeName, device string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !utils.SetDeviceWiping(nls, device, true) {
+			return nil
+		}
+		_, err = cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Update(ctx, nls, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// getDeviceMediaType returns media type of device reported in nls of node
+func (cs *controllerServer) getDeviceMediaType(ctx context.Context, nodeName, device string) string {
+	nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Warningf("fail to get nls %s for media type of device %s: %s", nodeName, device, err.Error())
+		return ""
+	}
+	for _, dev := range nls.Status.NodeStorageInfo.DeviceInfos {
+		if dev.Name == device {
+			return dev.MediaType
+		}
+	}
+	return ""
+}
+
 func validateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	volName := req.GetName()
 	if len(volName) == 0 {
@@ -878,6 +1683,22 @@ func validateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	return nil
 }
 
+// checkVolumeSizeRange checks size against minSize and maxSize of storage
+// class, it returns OutOfRange if size is out of range
+func checkVolumeSizeRange(size int64, params map[string]string) (codes.Code, error) {
+	minSize, maxSize, err := utils.GetVolumeSizeRange(params)
+	if err != nil {
+		return codes.InvalidArgument, fmt.Errorf("invalid size range of storage class: %s", err.Error())
+	}
+	if minSize > 0 && size < minSize {
+		return codes.OutOfRange, fmt.Errorf("requested size %d bytes is less than %s %s of storage class", size, localtype.VolumeMinSize, params[localtype.VolumeMinSize])
+	}
+	if maxSize > 0 && size > maxSize {
+		return codes.OutOfRange, fmt.Errorf("requested size %d bytes exceeds %s %s of storage class", size, localtype.VolumeMaxSize, params[localtype.VolumeMaxSize])
+	}
+	return codes.OK, nil
+}
+
 func validateDeleteVolumeRequest(req *csi.DeleteVolumeRequest) error {
 	if len(req.GetVolumeId()) == 0 {
 		return status.Error(codes.InvalidArgument, "Volume ID not provided")
@@ -885,6 +1706,23 @@ func validateDeleteVolumeRequest(req *csi.DeleteVolumeRequest) error {
 	return nil
 }
 
+// validateModifyVolumeRequest refuses parameters other than io throttling,
+// which can not be changed once volume is created, e.g. fsType and vgName
+func validateModifyVolumeRequest(req *csi.ControllerModifyVolumeRequest) error {
+	if len(req.GetVolumeId()) == 0 {
+		return fmt.Errorf("volume ID not provided")
+	}
+	for key, value := range req.GetMutableParameters() {
+		if key != localtype.VolumeIOPS && key != localtype.VolumeBPS {
+			return fmt.Errorf("parameter %s is immutable, only %s and %s can be modified", key, localtype.VolumeIOPS, localtype.VolumeBPS)
+		}
+		if limit, err := strconv.ParseUint(value, 10, 64); err != nil || limit == 0 {
+			return fmt.Errorf("invalid %s %q, must be a positive integer", key, value)
+		}
+	}
+	return nil
+}
+
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	hasSupport := func(cap *csi.VolumeCapability) bool {
 		for _, c := range VolumeCaps {
@@ -904,22 +1742,24 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	return foundAll
 }
 
-func getSnapshotInitialInfo(param map[string]string) (initialSize uint64, threshold float64, increaseSize uint64, err error) {
+// getSnapshotInitialInfo parses snapshot parameters, initial size and expansion
+// size in percentage are resolved by originSize
+func getSnapshotInitialInfo(param map[string]string, originSize uint64) (initialSize uint64, threshold float64, increaseSize uint64, err error) {
 	initialSize = localtype.DefaultSnapshotInitialSize
 	threshold = localtype.DefaultSnapshotThreshold
 	increaseSize = localtype.DefaultSnapshotExpansionSize
 	err = nil
 
 	// Step 1: get snapshot initial size
-	if str, exist := param[localtype.ParamSnapshotInitialSize]; exist {
-		size, err := units.RAMInBytes(str)
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotInitialSize); exist {
+		size, err := resolveSnapshotSize(str, originSize)
 		if err != nil {
 			return 0, 0, 0, status.Errorf(codes.Internal, "getSnapshotInitialInfo: get initialSize from snapshot annotation failed: %s", err.Error())
 		}
-		initialSize = uint64(size)
+		initialSize = size
 	}
 	// Step 2: get snapshot expand threshold
-	if str, exist := param[localtype.ParamSnapshotThreshold]; exist {
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotThreshold); exist {
 		str = strings.ReplaceAll(str, "%", "")
 		thr, err := strconv.ParseFloat(str, 64)
 		if err != nil {
@@ -928,13 +1768,22 @@ func getSnapshotInitialInfo(param map[string]string) (initialSize uint64, thresh
 		threshold = thr / 100
 	}
 	// Step 3: get snapshot increase size
-	if str, exist := param[localtype.ParamSnapshotExpansionSize]; exist {
-		size, err := units.RAMInBytes(str)
+	// size in percentage of vg is resolved by agent when snapshot is expanded
+	if str, exist := utils.LookupParam(param, localtype.ParamSnapshotExpansionSize); exist && !lvm.IsPercentSize(str) {
+		size, err := resolveSnapshotSize(str, originSize)
 		if err != nil {
 			return 0, 0, 0, status.Errorf(codes.Internal, "getSnapshotInitialInfo: get increase size from snapshot annotation failed: %s", err.Error())
 		}
-		increaseSize = uint64(size)
+		increaseSize = size
 	}
 	log.Infof("getSnapshotInitialInfo: initialSize(%d), threshold(%f), increaseSize(%d)", initialSize, threshold, increaseSize)
 	return
 }
+
+func resolveSnapshotSize(value string, originSize uint64) (uint64, error) {
+	size, err := utils.ParseSnapshotSize(value)
+	if err != nil {
+		return 0, err
+	}
+	return size.Resolve(originSize)
+}
@@ -17,14 +17,18 @@ limitations under the License.
 package csi
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/alibaba/open-local/pkg"
 	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/csi/adapter"
 	"github.com/alibaba/open-local/pkg/csi/client"
+	"github.com/alibaba/open-local/pkg/csi/lib"
 	"github.com/alibaba/open-local/pkg/csi/server"
 	fakelocalclientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
 	"github.com/alibaba/open-local/pkg/utils"
@@ -32,6 +36,8 @@ import (
 	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -98,16 +104,27 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 		pkg.AnnoSelectedNode: utils.NodeName4,
 	})
 	pvcPodSchedulerMap.Add(pvcSnapshotForExtender.Namespace, pvcSnapshotForExtender.Name, "default")
+	snapshotName := "test-snapshot"
+	// pvcFullCopyForExtender, data source is ro snapshot
+	pvcFullCopyForExtender := pvcSnapshotForExtender.DeepCopy()
+	pvcFullCopyForExtender.Name = "pvcFullCopyForExtender"
+	pvcFullCopyForExtender.Spec.DataSource.Name = snapshotName
+	pvcPodSchedulerMap.Add(pvcFullCopyForExtender.Namespace, pvcFullCopyForExtender.Name, "default")
+	// pvcBlockSnapshotForExtender, data source is ro snapshot of block volume
+	pvcBlockSnapshotForExtender := pvcFullCopyForExtender.DeepCopy()
+	pvcBlockSnapshotForExtender.Name = "pvcBlockSnapshotForExtender"
+	pvcPodSchedulerMap.Add(pvcBlockSnapshotForExtender.Namespace, pvcBlockSnapshotForExtender.Name, "default")
 	pvcs := []*corev1.PersistentVolumeClaim{
 		pvcForFW,
 		pvcWithouNodeNameForFW,
 		pvcForExtender,
 		pvcUnknown,
 		pvcSnapshotForExtender,
+		pvcFullCopyForExtender,
+		pvcBlockSnapshotForExtender,
 	}
 	pvName := "test-pv"
 	pvNameForSnapshot := "test-pv-snapshot"
-	snapshotName := "test-snapshot"
 	snapshotContentName := "test-content"
 	snapshotClassName := "test-snapshotclass"
 	pv := &corev1.PersistentVolume{
@@ -125,14 +142,38 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 			},
 			PersistentVolumeSource: corev1.PersistentVolumeSource{
 				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver: pkg.ProvisionerName,
 					VolumeAttributes: map[string]string{
 						pkg.ParamVGName:   "newVG",
 						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
 					},
 				},
 			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      pkg.KubernetesNodeIdentityKey,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{utils.NodeName4},
+								},
+							},
+						},
+					},
+				},
+			},
 		},
 	}
+	// block mode origin of snapshot
+	blockPVName := "test-block-pv"
+	blockContentName := "test-block-content"
+	blockPV := pv.DeepCopy()
+	blockPV.Name = blockPVName
+	blockPV.Spec.CSI.VolumeAttributes[pkg.ParamVGName] = "blockVG"
+	blockMode := corev1.PersistentVolumeBlock
+	blockPV.Spec.VolumeMode = &blockMode
 	// node
 	node := utils.CreateNode(&utils.TestNodeInfo{
 		NodeName:  utils.NodeName4,
@@ -168,6 +209,9 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 			},
 		},
 	}
+	blockVolumesnapshotcontent := volumesnapshotcontent.DeepCopy()
+	blockVolumesnapshotcontent.Name = blockContentName
+	blockVolumesnapshotcontent.Spec.Source.VolumeHandle = &blockPVName
 	volumesnapshotclass := &volumesnapshotv1.VolumeSnapshotClass{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: snapshotClassName,
@@ -204,11 +248,15 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 	if err := pvInformer.GetIndexer().Add(pv); err != nil {
 		t.Errorf("fail to add pvc: %s", err.Error())
 	}
+	if err := pvInformer.GetIndexer().Add(blockPV); err != nil {
+		t.Errorf("fail to add pv: %s", err.Error())
+	}
 	if err := nodeInformer.GetIndexer().Add(node); err != nil {
 		t.Errorf("fail to add node: %s", err.Error())
 	}
 	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshots("default").Create(context.Background(), volumesnapshot, metav1.CreateOptions{})
 	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), volumesnapshotcontent, metav1.CreateOptions{})
+	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), blockVolumesnapshotcontent, metav1.CreateOptions{})
 	_, _ = fakeSnapClient.SnapshotV1().VolumeSnapshotClasses().Create(context.Background(), volumesnapshotclass, metav1.CreateOptions{})
 
 	// pvcPodSchedulerMap
@@ -228,6 +276,56 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 		},
 	}
 
+	templatefields := testfields
+	templatefields.options = &driverOptions{
+		kubeclient:     fakeKubeClient,
+		snapclient:     fakeSnapClient,
+		localclient:    fakeLocalClient,
+		lvNameTemplate: "{ns}_{pvc}_{pv}",
+	}
+
+	// lv count limit of newVG on NodeName4
+	newVGListFields := func(vgs localv1alpha1.VGList) fields {
+		vgfields := testfields
+		vgfields.options = &driverOptions{
+			kubeclient: fakeKubeClient,
+			snapclient: fakeSnapClient,
+			localclient: fakelocalclientset.NewSimpleClientset(&localv1alpha1.NodeLocalStorage{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: utils.NodeName4,
+				},
+				Spec: localv1alpha1.NodeLocalStorageSpec{
+					NodeName: utils.NodeName4,
+					ListConfig: localv1alpha1.ListConfig{
+						VGs: vgs,
+					},
+				},
+			}),
+		}
+		return vgfields
+	}
+	newVGStatusFields := func(vg localv1alpha1.VolumeGroup) fields {
+		vgfields := testfields
+		vgfields.options = &driverOptions{
+			kubeclient: fakeKubeClient,
+			snapclient: fakeSnapClient,
+			localclient: fakelocalclientset.NewSimpleClientset(&localv1alpha1.NodeLocalStorage{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: utils.NodeName4,
+				},
+				Spec: localv1alpha1.NodeLocalStorageSpec{
+					NodeName: utils.NodeName4,
+				},
+				Status: localv1alpha1.NodeLocalStorageStatus{
+					NodeStorageInfo: localv1alpha1.NodeStorageInfo{
+						VolumeGroups: []localv1alpha1.VolumeGroup{vg},
+					},
+				},
+			}),
+		}
+		return vgfields
+	}
+
 	// CreateVolume: called with args {Name:yoda-a5c8ea42-9a10-4a0b-a399-8e41ba447b91 CapacityRange:required_bytes:10737418240  VolumeCapabilities:[mount:<fs_type:"ext4" > access_mode:<mode:SINGLE_NODE_WRITER > ] Parameters:map[csi.storage.k8s.io/pv/name:yoda-a5c8ea42-9a10-4a0b-a399-8e41ba447b91 csi.storage.k8s.io/pvc/name:minio-data-minio-1 csi.storage.k8s.io/pvc/namespace:default volumeType:LVM] Secrets:map[] VolumeContentSource:<nil> AccessibilityRequirements:requisite:<segments:<key:"kubernetes.io/hostname" value:"izrj91f4skdnkpv2z2grhcz" > > preferred:<segments:<key:"kubernetes.io/hostname" value:"izrj91f4skdnkpv2z2grhcz" > >  XXX_NoUnkeyedLiteral:{} XXX_unrecognized:[] XXX_sizecache:0}
 	tests := []struct {
 		name    string
@@ -369,6 +467,7 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.PVCName:          pvcForFW.Name,
 						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
 						pkg.AnnoSelectedNode: utils.NodeName4,
+						pkg.VolumeFSTypeKey:  "ext4",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -414,6 +513,101 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
 						pkg.AnnoSelectedNode: utils.NodeName4,
 						pkg.VGName:           "newVG",
+						pkg.ParamStoragePool: "newVG",
+						pkg.VolumeFSTypeKey:  "ext4",
+					},
+					AccessibleTopology: []*csi.Topology{
+						{
+							Segments: map[string]string{
+								pkg.KubernetesNodeIdentityKey: utils.NodeName4,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "extender success for lvm: lv name rendered by template",
+			fields: templatefields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: fmt.Sprintf("tmpl-%s", pvName),
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        fmt.Sprintf("tmpl-%s", pvName),
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: int64(150 * 1024 * 1024 * 1024),
+					VolumeId:      fmt.Sprintf("tmpl-%s", pvName),
+					VolumeContext: map[string]string{
+						pkg.PVName:           fmt.Sprintf("tmpl-%s", pvName),
+						pkg.PVCNameSpace:     pvcForExtender.Namespace,
+						pkg.PVCName:          pvcForExtender.Name,
+						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
+						pkg.AnnoSelectedNode: utils.NodeName4,
+						pkg.VGName:           "newVG",
+						pkg.ParamLVName:      fmt.Sprintf("%s_%s_tmpl-%s", pvcForExtender.Namespace, pvcForExtender.Name, pvName),
+						pkg.ParamStoragePool: "newVG",
+						pkg.VolumeFSTypeKey:  "ext4",
+					},
+					AccessibleTopology: []*csi.Topology{
+						{
+							Segments: map[string]string{
+								pkg.KubernetesNodeIdentityKey: utils.NodeName4,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "extender success for lvm: lv count below limit",
+			fields: newVGListFields(localv1alpha1.VGList{MaxLogicalVolumes: 2}),
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: fmt.Sprintf("limit-%s", pvName),
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        fmt.Sprintf("limit-%s", pvName),
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: int64(150 * 1024 * 1024 * 1024),
+					VolumeId:      fmt.Sprintf("limit-%s", pvName),
+					VolumeContext: map[string]string{
+						pkg.PVName:           fmt.Sprintf("limit-%s", pvName),
+						pkg.PVCNameSpace:     pvcForExtender.Namespace,
+						pkg.PVCName:          pvcForExtender.Name,
+						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
+						pkg.AnnoSelectedNode: utils.NodeName4,
+						pkg.VGName:           "newVG",
+						pkg.ParamStoragePool: "newVG",
+						pkg.VolumeFSTypeKey:  "ext4",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -425,6 +619,31 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "extender failed for lvm: lv count reaches limit",
+			fields: newVGListFields(localv1alpha1.VGList{MaxLogicalVolumes: 1}),
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: fmt.Sprintf("limit-%s", pvName),
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        fmt.Sprintf("limit-%s", pvName),
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:   "extender success for lvm: volume is already created",
 			fields: testfields,
@@ -458,6 +677,105 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
 						pkg.AnnoSelectedNode: utils.NodeName4,
 						pkg.VGName:           "newVG",
+						pkg.ParamStoragePool: "newVG",
+						pkg.VolumeFSTypeKey:  "ext4",
+					},
+					AccessibleTopology: []*csi.Topology{
+						{
+							Segments: map[string]string{
+								pkg.KubernetesNodeIdentityKey: utils.NodeName4,
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "extender failed for lvm: vg under maintenance",
+			fields: newVGListFields(localv1alpha1.VGList{Maintenance: []string{"newVG"}}),
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: fmt.Sprintf("maintenance-%s", pvName),
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        fmt.Sprintf("maintenance-%s", pvName),
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "extender failed for lvm: vg metadata exhausted",
+			fields: newVGStatusFields(localv1alpha1.VolumeGroup{Name: "newVG", MetadataFree: 1024, MetadataSize: 1044480, Condition: localv1alpha1.StorageMetadataLow}),
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: fmt.Sprintf("metadata-%s", pvName),
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        fmt.Sprintf("metadata-%s", pvName),
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "extender success for lvm: volume is already created in vg under maintenance",
+			fields: newVGListFields(localv1alpha1.VGList{Maintenance: []string{"newVG"}}),
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvName,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        pvName,
+						pkg.PVCNameSpace:  pvcForExtender.Namespace,
+						pkg.PVCName:       pvcForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			want: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: int64(150 * 1024 * 1024 * 1024),
+					VolumeId:      pvName,
+					VolumeContext: map[string]string{
+						pkg.PVName:           pvName,
+						pkg.PVCNameSpace:     pvcForExtender.Namespace,
+						pkg.PVCName:          pvcForExtender.Name,
+						pkg.VolumeTypeKey:    string(pkg.VolumeTypeLVM),
+						pkg.AnnoSelectedNode: utils.NodeName4,
+						pkg.VGName:           "newVG",
+						pkg.ParamStoragePool: "newVG",
+						pkg.VolumeFSTypeKey:  "ext4",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -503,6 +821,7 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.VolumeTypeKey:                string(pkg.VolumeTypeMountPoint),
 						pkg.AnnoSelectedNode:             utils.NodeName4,
 						string(pkg.VolumeTypeMountPoint): "/mnt/data/data-0",
+						pkg.ParamStoragePool:             "/mnt/data/data-0",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -548,6 +867,8 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.VolumeTypeKey:            string(pkg.VolumeTypeDevice),
 						pkg.AnnoSelectedNode:         utils.NodeName4,
 						string(pkg.VolumeTypeDevice): "/dev/sdd",
+						pkg.ParamStoragePool:         "/dev/sdd",
+						pkg.VolumeFSTypeKey:          "ext4",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -654,6 +975,8 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 						pkg.VGName:              "newVG",
 						pkg.ParamSnapshotID:     snapshotContentName,
 						pkg.ParamSourceVolumeID: pvName,
+						pkg.ParamStoragePool:    "newVG",
+						pkg.VolumeFSTypeKey:     "ext4",
 					},
 					AccessibleTopology: []*csi.Topology{
 						{
@@ -673,48 +996,989 @@ func Test_controllerServer_CreateVolume(t *testing.T) {
 			},
 			wantErr: false,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			cs := &controllerServer{
-				inFlight:           tt.fields.inFlight,
-				pvcPodSchedulerMap: tt.fields.pvcPodSchedulerMap,
-				schedulerArchMap:   tt.fields.schedulerArchMap,
-				nodeLister:         tt.fields.nodeLister,
-				podLister:          tt.fields.podLister,
-				pvcLister:          tt.fields.pvcLister,
-				pvLister:           tt.fields.pvLister,
-				adapter:            tt.fields.adapter,
-				options:            tt.fields.options,
-			}
-			got, err := cs.CreateVolume(tt.args.ctx, tt.args.req)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("controllerServer.CreateVolume() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("controllerServer.CreateVolume() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_controllerServer_DeleteVolume(t *testing.T) {
-	type args struct {
-		ctx context.Context
-		req *csi.DeleteVolumeRequest
-	}
-
-	pvNameLVM := "test-pv"
-	pvNameLVMForSnapshot := "test-pv-snapshot"
-	pvNameMountPoint := "test-pv-mp"
-	pvNameDevice := "test-pv-device"
-	pv := &corev1.PersistentVolume{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: pvNameLVM,
-		},
-		Spec: corev1.PersistentVolumeSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		{
+			name:   "extender success for snapshot of block volume",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvNameForSnapshot,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        pvNameForSnapshot,
+						pkg.PVCNameSpace:  pvcBlockSnapshotForExtender.Namespace,
+						pkg.PVCName:       pvcBlockSnapshotForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+						pkg.ParamReadonly: "true",
+					},
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: blockContentName,
+							},
+						},
+					},
+				},
+			},
+			want: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: int64(150 * 1024 * 1024 * 1024),
+					VolumeId:      pvNameForSnapshot,
+					VolumeContext: map[string]string{
+						pkg.PVName:              pvNameForSnapshot,
+						pkg.PVCNameSpace:        pvcBlockSnapshotForExtender.Namespace,
+						pkg.PVCName:             pvcBlockSnapshotForExtender.Name,
+						pkg.VolumeTypeKey:       string(pkg.VolumeTypeLVM),
+						pkg.ParamReadonly:       "true",
+						pkg.AnnoSelectedNode:    utils.NodeName4,
+						pkg.VGName:              "blockVG",
+						pkg.ParamSnapshotID:     blockContentName,
+						pkg.ParamSourceVolumeID: blockPVName,
+						pkg.ParamStoragePool:    "blockVG",
+					},
+					AccessibleTopology: []*csi.Topology{
+						{
+							Segments: map[string]string{
+								pkg.KubernetesNodeIdentityKey: utils.NodeName4,
+							},
+						},
+					},
+					ContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: blockContentName,
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "extender failed for snapshot of block volume: restored as filesystem",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvNameForSnapshot,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        pvNameForSnapshot,
+						pkg.PVCNameSpace:  pvcBlockSnapshotForExtender.Namespace,
+						pkg.PVCName:       pvcBlockSnapshotForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+						pkg.ParamReadonly: "true",
+					},
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: blockContentName,
+							},
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "extender failed for rw snapshot: restored as block",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvNameForSnapshot,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:        pvNameForSnapshot,
+						pkg.PVCNameSpace:  pvcSnapshotForExtender.Namespace,
+						pkg.PVCName:       pvcSnapshotForExtender.Name,
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: snapshotContentName,
+							},
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "extender success for snapshot full copy",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvNameForSnapshot,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(200 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:                pvNameForSnapshot,
+						pkg.PVCNameSpace:          pvcFullCopyForExtender.Namespace,
+						pkg.PVCName:               pvcFullCopyForExtender.Name,
+						pkg.VolumeTypeKey:         string(pkg.VolumeTypeLVM),
+						pkg.ParamSnapshotFullCopy: "true",
+					},
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: snapshotContentName,
+							},
+						},
+					},
+				},
+			},
+			want: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: int64(200 * 1024 * 1024 * 1024),
+					VolumeId:      pvNameForSnapshot,
+					VolumeContext: map[string]string{
+						pkg.PVName:                pvNameForSnapshot,
+						pkg.PVCNameSpace:          pvcFullCopyForExtender.Namespace,
+						pkg.PVCName:               pvcFullCopyForExtender.Name,
+						pkg.VolumeTypeKey:         string(pkg.VolumeTypeLVM),
+						pkg.ParamSnapshotFullCopy: "true",
+						pkg.AnnoSelectedNode:      utils.NodeName4,
+						pkg.VGName:                "newVG",
+						pkg.ParamSourceVolumeID:   pvName,
+						pkg.ParamStoragePool:      "newVG",
+						pkg.VolumeFSTypeKey:       "ext4",
+					},
+					AccessibleTopology: []*csi.Topology{
+						{
+							Segments: map[string]string{
+								pkg.KubernetesNodeIdentityKey: utils.NodeName4,
+							},
+						},
+					},
+					ContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: snapshotContentName,
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:   "extender failed for snapshot full copy: size smaller than snapshot",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateVolumeRequest{
+					Name: pvNameForSnapshot,
+					VolumeCapabilities: []*csi.VolumeCapability{
+						{
+							AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+							AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+						},
+					},
+					CapacityRange: &csi.CapacityRange{RequiredBytes: int64(100 * 1024 * 1024 * 1024)},
+					Parameters: map[string]string{
+						pkg.PVName:                pvNameForSnapshot,
+						pkg.PVCNameSpace:          pvcFullCopyForExtender.Namespace,
+						pkg.PVCName:               pvcFullCopyForExtender.Name,
+						pkg.VolumeTypeKey:         string(pkg.VolumeTypeLVM),
+						pkg.ParamSnapshotFullCopy: "true",
+					},
+					VolumeContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: snapshotContentName,
+							},
+						},
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				inFlight:           tt.fields.inFlight,
+				pvcPodSchedulerMap: tt.fields.pvcPodSchedulerMap,
+				schedulerArchMap:   tt.fields.schedulerArchMap,
+				nodeLister:         tt.fields.nodeLister,
+				podLister:          tt.fields.podLister,
+				pvcLister:          tt.fields.pvcLister,
+				pvLister:           tt.fields.pvLister,
+				adapter:            tt.fields.adapter,
+				options:            tt.fields.options,
+			}
+			got, err := cs.CreateVolume(tt.args.ctx, tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("controllerServer.CreateVolume() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("controllerServer.CreateVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeNodeAdapter schedules volume to node, or fails with err
+type fakeNodeAdapter struct {
+	node string
+	err  error
+}
+
+func (adapter *fakeNodeAdapter) ScheduleVolume(volumeType, pvcName, pvcNamespace, vgName, nodeID string) (*pkg.BindingInfo, error) {
+	if adapter.err != nil {
+		return nil, adapter.err
+	}
+	return &pkg.BindingInfo{
+		Node:                  adapter.node,
+		VgName:                "newVG",
+		VolumeType:            volumeType,
+		PersistentVolumeClaim: utils.GetNameKey(pvcNamespace, pvcName),
+	}, nil
+}
+
+func Test_controllerServer_CreateVolume_SelectedNode(t *testing.T) {
+	nodeHint := "node-hint"
+	pvcTemplate := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithoutVG().PVCPending})[0]
+	// selected node in informer cache is stale
+	pvcCached := pvcTemplate.DeepCopy()
+	pvcCached.Name = "pvcForExtender"
+	pvcCached.SetAnnotations(map[string]string{
+		pkg.AnnoSelectedNode: utils.NodeName4,
+	})
+	pvcLatest := pvcCached.DeepCopy()
+	pvcLatest.SetAnnotations(map[string]string{
+		pkg.AnnoSelectedNode: nodeHint,
+	})
+	pvcPodSchedulerMap := newPvcPodSchedulerMap()
+	pvcPodSchedulerMap.Add(pvcCached.Namespace, pvcCached.Name, "default")
+
+	ctx := context.Background()
+	fakeKubeClient := fakekubeclientset.NewSimpleClientset(pvcLatest)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakeKubeClient, 0)
+	nodeInformer := kubeInformerFactory.Core().V1().Nodes().Informer()
+	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	_ = pvcInformer.GetIndexer().Add(pvcCached)
+	for _, nodeName := range []string{utils.NodeName4, nodeHint} {
+		_ = nodeInformer.GetIndexer().Add(utils.CreateNode(&utils.TestNodeInfo{
+			NodeName:  nodeName,
+			IPAddress: "127.0.0.1",
+		}))
+	}
+
+	newRequest := func(preferredNode string) *csi.CreateVolumeRequest {
+		req := &csi.CreateVolumeRequest{
+			Name: "test-selected-node",
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+			Parameters: map[string]string{
+				pkg.PVName:        "test-selected-node",
+				pkg.PVCNameSpace:  pvcCached.Namespace,
+				pkg.PVCName:       pvcCached.Name,
+				pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+			},
+		}
+		if preferredNode != "" {
+			req.AccessibilityRequirements = &csi.TopologyRequirement{
+				Preferred: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							pkg.KubernetesNodeIdentityKey: preferredNode,
+						},
+					},
+				},
+			}
+		}
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		adapter  adapter.Adapter
+		req      *csi.CreateVolumeRequest
+		wantNode string
+		wantCode codes.Code
+	}{
+		{
+			name:     "test no preferred node, use selected node in cache",
+			adapter:  &fakeNodeAdapter{node: utils.NodeName4},
+			req:      newRequest(""),
+			wantNode: utils.NodeName4,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test selected node in cache is stale, use latest one",
+			adapter:  &fakeNodeAdapter{node: nodeHint},
+			req:      newRequest(nodeHint),
+			wantNode: nodeHint,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test preferred node mismatches selected node",
+			adapter:  &fakeNodeAdapter{node: "node-other"},
+			req:      newRequest("node-other"),
+			wantCode: codes.Aborted,
+		},
+		{
+			name:     "test scheduled to node other than selected node",
+			adapter:  &fakeNodeAdapter{node: utils.NodeName4},
+			req:      newRequest(nodeHint),
+			wantCode: codes.Aborted,
+		},
+		{
+			name:     "test selected node lacks capacity",
+			adapter:  &fakeNodeAdapter{err: fmt.Errorf("Insufficient LVM storage on node %s", nodeHint)},
+			req:      newRequest(nodeHint),
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				inFlight:           NewInFlight(),
+				pvcPodSchedulerMap: pvcPodSchedulerMap,
+				schedulerArchMap:   newSchedulerArchMap([]string{"default"}, []string{"ahe-scheduler"}),
+				nodeLister:         kubeInformerFactory.Core().V1().Nodes().Lister(),
+				pvcLister:          kubeInformerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+				pvLister:           kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+				adapter:            tt.adapter,
+				options: &driverOptions{
+					kubeclient:  fakeKubeClient,
+					localclient: fakelocalclientset.NewSimpleClientset(),
+				},
+			}
+			got, err := cs.CreateVolume(ctx, tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("controllerServer.CreateVolume() error = %v, want code %v", err, tt.wantCode)
+				return
+			}
+			if err != nil {
+				return
+			}
+			gotNode := got.Volume.AccessibleTopology[0].Segments[pkg.KubernetesNodeIdentityKey]
+			if gotNode != tt.wantNode || got.Volume.VolumeContext[pkg.AnnoSelectedNode] != tt.wantNode {
+				t.Errorf("controllerServer.CreateVolume() provisioned on node %s, want %s", gotNode, tt.wantNode)
+			}
+		})
+	}
+}
+
+// fakeCopyConnection copies data with cloneErr injected and records tags of
+// created lv and removed lv
+type fakeCopyConnection struct {
+	client.Connection
+	cloneErr       error
+	verifyChecksum bool
+	tags           []string
+	removed        []string
+}
+
+func (conn *fakeCopyConnection) GetVolume(ctx context.Context, volGroup string, volumeID string) (string, error) {
+	return "", nil
+}
+
+func (conn *fakeCopyConnection) CreateVolume(ctx context.Context, opt *client.LVMOptions) (string, string, error) {
+	conn.tags = opt.Tags
+	return "CreateVolume", "", nil
+}
+
+func (conn *fakeCopyConnection) CloneVolume(ctx context.Context, src string, dest string, verifyChecksum bool) error {
+	conn.verifyChecksum = verifyChecksum
+	return conn.cloneErr
+}
+
+func (conn *fakeCopyConnection) DeleteVolume(ctx context.Context, volGroup string, volumeID string) error {
+	conn.removed = append(conn.removed, utils.GetNameKey(volGroup, volumeID))
+	return nil
+}
+
+func Test_checkVolumeSizeRange(t *testing.T) {
+	gi := int64(1024 * 1024 * 1024)
+	params := map[string]string{
+		pkg.VolumeMinSize: "1Gi",
+		pkg.VolumeMaxSize: "100Gi",
+	}
+	tests := []struct {
+		name     string
+		size     int64
+		params   map[string]string
+		wantCode codes.Code
+	}{
+		{name: "test below min size", size: gi - 1, params: params, wantCode: codes.OutOfRange},
+		{name: "test above max size", size: 100*gi + 1, params: params, wantCode: codes.OutOfRange},
+		{name: "test min size", size: gi, params: params, wantCode: codes.OK},
+		{name: "test max size", size: 100 * gi, params: params, wantCode: codes.OK},
+		{name: "test unrestricted", size: 1000 * gi, params: map[string]string{}, wantCode: codes.OK},
+		{name: "test max size only", size: 1, params: map[string]string{pkg.VolumeMaxSize: "1Gi"}, wantCode: codes.OK},
+		{name: "test invalid size", size: gi, params: map[string]string{pkg.VolumeMinSize: "1G!"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := checkVolumeSizeRange(tt.size, tt.params)
+			if code != tt.wantCode || (err != nil) != (tt.wantCode != codes.OK) {
+				t.Errorf("checkVolumeSizeRange() = %v, %v, want %v", code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_CreateVolume_SizeRange(t *testing.T) {
+	params := map[string]string{
+		pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+		pkg.VolumeMinSize: "10Gi",
+		pkg.VolumeMaxSize: "100Gi",
+	}
+	for _, size := range []int64{1024 * 1024 * 1024, 200 * 1024 * 1024 * 1024} {
+		cs := &controllerServer{inFlight: NewInFlight()}
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: "test-pv",
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: size},
+			Parameters:    params,
+		})
+		if status.Code(err) != codes.OutOfRange {
+			t.Errorf("controllerServer.CreateVolume() of %d bytes error = %v, want OutOfRange", size, err)
+		}
+	}
+}
+
+func Test_controllerServer_completeVolumeContext(t *testing.T) {
+	mountCaps := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}
+	xfsCaps := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+		},
+	}
+	blockCaps := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		},
+	}
+	nls := &localv1alpha1.NodeLocalStorage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: utils.NodeName4,
+		},
+		Status: localv1alpha1.NodeLocalStorageStatus{
+			NodeStorageInfo: localv1alpha1.NodeStorageInfo{
+				DeviceInfos: []localv1alpha1.DeviceInfo{
+					{Name: "/dev/sdd", MediaType: string(pkg.MediaTypeSSD)},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		volumeType    pkg.VolumeType
+		volumeContext map[string]string
+		volCaps       []*csi.VolumeCapability
+		want          map[string]string
+	}{
+		{
+			name:          "lvm with default fsType",
+			volumeType:    pkg.VolumeTypeLVM,
+			volumeContext: map[string]string{pkg.VGName: "newVG"},
+			volCaps:       mountCaps,
+			want: map[string]string{
+				pkg.VGName:           "newVG",
+				pkg.ParamStoragePool: "newVG",
+				pkg.VolumeFSTypeKey:  DefaultFs,
+			},
+		},
+		{
+			name:          "lvm with media type in storage class",
+			volumeType:    pkg.VolumeTypeLVM,
+			volumeContext: map[string]string{pkg.VGName: "newVG", pkg.VolumeMediaType: string(pkg.MediaTypeHDD)},
+			volCaps:       xfsCaps,
+			want: map[string]string{
+				pkg.VGName:           "newVG",
+				pkg.VolumeMediaType:  string(pkg.MediaTypeHDD),
+				pkg.ParamStoragePool: "newVG",
+				pkg.VolumeFSTypeKey:  "xfs",
+			},
+		},
+		{
+			name:          "lvm scheduled by framework",
+			volumeType:    pkg.VolumeTypeLVM,
+			volumeContext: map[string]string{},
+			volCaps:       blockCaps,
+			want:          map[string]string{},
+		},
+		{
+			name:          "mountpoint without fsType",
+			volumeType:    pkg.VolumeTypeMountPoint,
+			volumeContext: map[string]string{string(pkg.VolumeTypeMountPoint): "/mnt/data/data-0"},
+			volCaps:       mountCaps,
+			want: map[string]string{
+				string(pkg.VolumeTypeMountPoint): "/mnt/data/data-0",
+				pkg.ParamStoragePool:             "/mnt/data/data-0",
+			},
+		},
+		{
+			name:          "device with media type from nls",
+			volumeType:    pkg.VolumeTypeDevice,
+			volumeContext: map[string]string{string(pkg.VolumeTypeDevice): "/dev/sdd"},
+			volCaps:       blockCaps,
+			want: map[string]string{
+				string(pkg.VolumeTypeDevice): "/dev/sdd",
+				pkg.ParamStoragePool:         "/dev/sdd",
+				pkg.VolumeMediaType:          string(pkg.MediaTypeSSD),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				options: &driverOptions{
+					localclient: fakelocalclientset.NewSimpleClientset(nls),
+				},
+			}
+			cs.completeVolumeContext(context.Background(), tt.volumeContext, string(tt.volumeType), utils.NodeName4, tt.volCaps)
+			if !reflect.DeepEqual(tt.volumeContext, tt.want) {
+				t.Errorf("controllerServer.completeVolumeContext() = %v, want %v", tt.volumeContext, tt.want)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_copySnapshotToLV(t *testing.T) {
+	srcPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pv",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("10Gi"),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.ParamVGName:   "newVG",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      pkg.KubernetesNodeIdentityKey,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{utils.NodeName4},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		verifyChecksum bool
+		parameters     map[string]string
+		cloneErr       error
+		wantErr        bool
+		wantTags       []string
+		wantRemoved    []string
+	}{
+		{
+			name:           "copy without verification",
+			verifyChecksum: false,
+			wantErr:        false,
+			wantTags:       []string{pkg.ManagedLVTag, pkg.CreatedForLVTagPrefix + "new-pv"},
+		},
+		{
+			name:           "copy with checksum matched",
+			verifyChecksum: true,
+			wantErr:        false,
+			wantTags:       []string{pkg.ManagedLVTag, pkg.CreatedForLVTagPrefix + "new-pv"},
+		},
+		{
+			name:           "copy with checksum mismatched",
+			verifyChecksum: true,
+			cloneErr:       fmt.Errorf("checksum mismatch after cloning /dev/newVG/test-content to /dev/newVG/new-pv"),
+			wantErr:        true,
+			wantTags:       []string{pkg.ManagedLVTag, pkg.CreatedForLVTagPrefix + "new-pv"},
+			wantRemoved:    []string{"newVG/new-pv"},
+		},
+		{
+			name:       "copy discarded on delete",
+			parameters: map[string]string{pkg.ParamDiscardOnDelete: "true"},
+			wantErr:    false,
+			wantTags:   []string{pkg.ManagedLVTag, pkg.CreatedForLVTagPrefix + "new-pv", pkg.DiscardOnDeleteLVTag},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				options: &driverOptions{
+					localclient: fakelocalclientset.NewSimpleClientset(),
+				},
+			}
+			conn := &fakeCopyConnection{cloneErr: tt.cloneErr}
+			parameters := map[string]string{}
+			for k, v := range tt.parameters {
+				parameters[k] = v
+			}
+			err := cs.copySnapshotToLV(context.Background(), conn, utils.NodeName4, "new-pv", srcPV, "test-content", "new-pv", 10*1024*1024*1024, tt.verifyChecksum, parameters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("controllerServer.copySnapshotToLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if conn.verifyChecksum != tt.verifyChecksum {
+				t.Errorf("controllerServer.copySnapshotToLV() verifyChecksum = %v, want %v", conn.verifyChecksum, tt.verifyChecksum)
+			}
+			if !reflect.DeepEqual(conn.tags, tt.wantTags) {
+				t.Errorf("controllerServer.copySnapshotToLV() tags = %v, want %v", conn.tags, tt.wantTags)
+			}
+			if !reflect.DeepEqual(conn.removed, tt.wantRemoved) {
+				t.Errorf("controllerServer.copySnapshotToLV() removed lv = %v, want %v", conn.removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_reserveSnapshotCopy(t *testing.T) {
+	tests := []struct {
+		name     string
+		adapter  adapter.Adapter
+		wantCode codes.Code
+	}{
+		{
+			name:     "test full copy reserved at selected node",
+			adapter:  &fakeNodeAdapter{node: utils.NodeName4},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test full copy reserved at other node",
+			adapter:  &fakeNodeAdapter{node: utils.NodeName1},
+			wantCode: codes.Aborted,
+		},
+		{
+			name:     "test source vg lacks capacity",
+			adapter:  &fakeNodeAdapter{err: fmt.Errorf("Insufficient LVM storage on node %s", utils.NodeName4)},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{adapter: tt.adapter}
+			err := cs.reserveSnapshotCopy(utils.NodeName4, "pvc-copy", utils.LocalNameSpace)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("controllerServer.reserveSnapshotCopy() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+// fakeAdoptConnection serves lvs listed in lvs and records tagged lv
+type fakeAdoptConnection struct {
+	client.Connection
+	lvs    map[string]*lib.LogicalVolume
+	tagged []string
+}
+
+func (conn *fakeAdoptConnection) DescribeVolume(ctx context.Context, volGroup string, volumeID string) (*lib.LogicalVolume, error) {
+	return conn.lvs[utils.GetNameKey(volGroup, volumeID)], nil
+}
+
+func (conn *fakeAdoptConnection) AddVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error {
+	conn.tagged = append(conn.tagged, utils.GetNameKey(volGroup, volumeID))
+	return nil
+}
+
+func Test_controllerServer_adoptLV(t *testing.T) {
+	gi := int64(1024 * 1024 * 1024)
+	managedPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "managed-pv",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.ParamVGName:   "newVG",
+						pkg.ParamLVName:   "managed-lv",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      pkg.KubernetesNodeIdentityKey,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{utils.NodeName4},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakekubeclientset.NewSimpleClientset(), 0)
+	_ = kubeInformerFactory.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(managedPV)
+
+	tests := []struct {
+		name       string
+		volumeID   string
+		target     string
+		params     map[string]string
+		wantCode   codes.Code
+		wantSize   int64
+		wantTagged []string
+	}{
+		{
+			name:       "test adopt unmanaged lv",
+			volumeID:   "new-pv",
+			target:     "newVG/legacy-lv",
+			params:     map[string]string{},
+			wantCode:   codes.OK,
+			wantSize:   10 * gi,
+			wantTagged: []string{"newVG/legacy-lv"},
+		},
+		{
+			name:     "test adopt lv larger than request",
+			volumeID: "new-pv",
+			target:   "newVG/large-lv",
+			params:   map[string]string{},
+			wantCode: codes.OK,
+			wantSize: 20 * gi,
+		},
+		{
+			name:     "test lv smaller than request",
+			volumeID: "new-pv",
+			target:   "newVG/small-lv",
+			params:   map[string]string{},
+			wantCode: codes.OutOfRange,
+		},
+		{
+			name:     "test lv managed by another pv",
+			volumeID: "new-pv",
+			target:   "newVG/managed-lv",
+			params:   map[string]string{},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "test retry of pv already adopting lv",
+			volumeID: "managed-pv",
+			target:   "newVG/managed-lv",
+			params:   map[string]string{},
+			wantCode: codes.OK,
+			wantSize: 10 * gi,
+		},
+		{
+			name:     "test lv not found",
+			volumeID: "new-pv",
+			target:   "newVG/missing-lv",
+			params:   map[string]string{},
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "test vg mismatches storage class",
+			volumeID: "new-pv",
+			target:   "newVG/legacy-lv",
+			params:   map[string]string{VgNameTag: "otherVG"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "test invalid target",
+			volumeID: "new-pv",
+			target:   "legacy-lv",
+			params:   map[string]string{},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				inFlight: NewInFlight(),
+				pvLister: kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+			}
+			conn := &fakeAdoptConnection{
+				lvs: map[string]*lib.LogicalVolume{
+					"newVG/legacy-lv":  {Name: "legacy-lv", Size: uint64(10 * gi)},
+					"newVG/large-lv":   {Name: "large-lv", Size: uint64(20 * gi), Tags: []string{pkg.ManagedLVTag}},
+					"newVG/small-lv":   {Name: "small-lv", Size: uint64(5 * gi)},
+					"newVG/managed-lv": {Name: "managed-lv", Size: uint64(10 * gi), Tags: []string{pkg.ManagedLVTag}},
+				},
+			}
+			vgName, lvName, size, err := cs.adoptLV(context.Background(), conn, tt.volumeID, utils.NodeName4, tt.target, &csi.CapacityRange{RequiredBytes: 10 * gi}, tt.params)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("controllerServer.adoptLV() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				if len(conn.tagged) != 0 {
+					t.Errorf("controllerServer.adoptLV() tagged lv %v on failure", conn.tagged)
+				}
+				return
+			}
+			if utils.GetNameKey(vgName, lvName) != tt.target || size != tt.wantSize {
+				t.Errorf("controllerServer.adoptLV() = %s/%s(size %d), want %s(size %d)", vgName, lvName, size, tt.target, tt.wantSize)
+			}
+			if !reflect.DeepEqual(conn.tagged, tt.wantTagged) {
+				t.Errorf("controllerServer.adoptLV() tagged lv = %v, want %v", conn.tagged, tt.wantTagged)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_checkDMNameOnNode(t *testing.T) {
+	usedPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "used-pv",
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.ParamVGName:   "newVG",
+						pkg.ParamLVName:   "used-lv",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      pkg.KubernetesNodeIdentityKey,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{utils.NodeName4},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakekubeclientset.NewSimpleClientset(), 0)
+	_ = kubeInformerFactory.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(usedPV)
+
+	tests := []struct {
+		name     string
+		volumeID string
+		nodeName string
+		lvName   string
+		wantCode codes.Code
+	}{
+		{
+			name:     "test unused dm name",
+			volumeID: "new-pv",
+			nodeName: utils.NodeName4,
+			lvName:   "new-lv",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test dm name used by another pv",
+			volumeID: "new-pv",
+			nodeName: utils.NodeName4,
+			lvName:   "used-lv",
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "test retry of pv using dm name",
+			volumeID: "used-pv",
+			nodeName: utils.NodeName4,
+			lvName:   "used-lv",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test dm name used at another node",
+			volumeID: "new-pv",
+			nodeName: utils.NodeName3,
+			lvName:   "used-lv",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "test dm name too long",
+			volumeID: "new-pv",
+			nodeName: utils.NodeName4,
+			lvName:   strings.Repeat("a-", 60),
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &controllerServer{
+				pvLister: kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+			}
+			if code := status.Code(cs.checkDMNameOnNode(tt.volumeID, tt.nodeName, "newVG", tt.lvName)); code != tt.wantCode {
+				t.Errorf("controllerServer.checkDMNameOnNode() code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_DeleteVolume(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		req *csi.DeleteVolumeRequest
+	}
+
+	pvNameLVM := "test-pv"
+	pvNameLVMForSnapshot := "test-pv-snapshot"
+	pvNameMountPoint := "test-pv-mp"
+	pvNameDevice := "test-pv-device"
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvNameLVM,
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
 			Capacity: corev1.ResourceList{
 				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse("150Gi"),
 			},
@@ -863,11 +2127,16 @@ func Test_controllerServer_DeleteVolume(t *testing.T) {
 			},
 		},
 	}
+	pvDeviceWipe := pvDevice.DeepCopy()
+	pvDeviceWipe.Name = pvNameDevice + "-wipe"
+	pvDeviceWipe.Spec.CSI.VolumeAttributes[string(pkg.VolumeTypeDevice)] = "/dev/sde"
+	pvDeviceWipe.Spec.CSI.VolumeAttributes[pkg.ParamWipeOnDelete] = "true"
 	pvs := []*corev1.PersistentVolume{
 		pv,
 		pvSnapshot,
 		pvMountPoint,
 		pvDevice,
+		pvDeviceWipe,
 	}
 	// node
 	node := utils.CreateNode(&utils.TestNodeInfo{
@@ -877,7 +2146,7 @@ func Test_controllerServer_DeleteVolume(t *testing.T) {
 
 	ctx := context.Background()
 	fakeKubeClient := fakekubeclientset.NewSimpleClientset()
-	fakeLocalClient := fakelocalclientset.NewSimpleClientset()
+	fakeLocalClient := fakelocalclientset.NewSimpleClientset(&localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: utils.NodeName4}})
 	fakeSnapClient := fakesnapclientset.NewSimpleClientset()
 
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakeKubeClient, 0)
@@ -980,6 +2249,18 @@ func Test_controllerServer_DeleteVolume(t *testing.T) {
 			want:    &csi.DeleteVolumeResponse{},
 			wantErr: false,
 		},
+		{
+			name:   "delete volume for device with wipe on delete",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.DeleteVolumeRequest{
+					VolumeId: pvDeviceWipe.Name,
+				},
+			},
+			want:    &csi.DeleteVolumeResponse{},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1004,6 +2285,14 @@ func Test_controllerServer_DeleteVolume(t *testing.T) {
 			}
 		})
 	}
+	// only device released with wipe on delete is left to agent
+	nls, err := fakeLocalClient.CsiV1alpha1().NodeLocalStorages().Get(ctx, utils.NodeName4, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("fail to get nls: %s", err.Error())
+	}
+	if got := utils.WipingDevices(nls); !reflect.DeepEqual(got, []string{"/dev/sde"}) {
+		t.Errorf("wiping devices = %v, want [/dev/sde]", got)
+	}
 }
 
 func Test_controllerServer_CreateSnapshot(t *testing.T) {
@@ -1052,6 +2341,16 @@ func Test_controllerServer_CreateSnapshot(t *testing.T) {
 			},
 		},
 	}
+	// volume mounting readonly snapshot lv of pv
+	roSnapshotPV := pv.DeepCopy()
+	roSnapshotPV.Name = "test-ro-snapshot-pv"
+	roSnapshotPV.Spec.CSI.VolumeAttributes[pkg.ParamSnapshotID] = "snap-test"
+	roSnapshotPV.Spec.CSI.VolumeAttributes[pkg.ParamReadonly] = "true"
+	// raw block volume
+	blockPV := pv.DeepCopy()
+	blockPV.Name = "test-block-pv"
+	blockMode := corev1.PersistentVolumeBlock
+	blockPV.Spec.VolumeMode = &blockMode
 	// node
 	node := utils.CreateNode(&utils.TestNodeInfo{
 		NodeName:  utils.NodeName4,
@@ -1075,6 +2374,12 @@ func Test_controllerServer_CreateSnapshot(t *testing.T) {
 	if err := pvInformer.GetIndexer().Add(pv); err != nil {
 		t.Errorf("fail to add pvc: %s", err.Error())
 	}
+	if err := pvInformer.GetIndexer().Add(roSnapshotPV); err != nil {
+		t.Errorf("fail to add pv: %s", err.Error())
+	}
+	if err := pvInformer.GetIndexer().Add(blockPV); err != nil {
+		t.Errorf("fail to add pv: %s", err.Error())
+	}
 	if err := nodeInformer.GetIndexer().Add(node); err != nil {
 		t.Errorf("fail to add node: %s", err.Error())
 	}
@@ -1143,7 +2448,74 @@ func Test_controllerServer_CreateSnapshot(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:   "invalid args: param",
+			name:   "invalid args: param",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateSnapshotRequest{
+					SourceVolumeId: pvName,
+					Name:           snapshotContentName,
+					Parameters: map[string]string{
+						pkg.ParamReadonly:          "true",
+						pkg.ParamSnapshotThreshold: "50,%",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "invalid args: param",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateSnapshotRequest{
+					SourceVolumeId: pvName,
+					Name:           snapshotContentName,
+					Parameters: map[string]string{
+						pkg.ParamReadonly:              "true",
+						pkg.ParamSnapshotExpansionSize: "1bGi",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "invalid args: snapshot cow pvs",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateSnapshotRequest{
+					SourceVolumeId: pvName,
+					Name:           snapshotContentName,
+					Parameters: map[string]string{
+						pkg.ParamReadonly:       "true",
+						pkg.ParamSnapshotCOWPVs: "open-local-pool-1",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "invalid args: snapshot of readonly snapshot",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.CreateSnapshotRequest{
+					SourceVolumeId: roSnapshotPV.Name,
+					Name:           snapshotContentName,
+					Parameters: map[string]string{
+						pkg.ParamReadonly: "true",
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "create snapshot success",
 			fields: testfields,
 			args: args{
 				ctx: context.Background(),
@@ -1151,44 +2523,48 @@ func Test_controllerServer_CreateSnapshot(t *testing.T) {
 					SourceVolumeId: pvName,
 					Name:           snapshotContentName,
 					Parameters: map[string]string{
-						pkg.ParamReadonly:          "true",
-						pkg.ParamSnapshotThreshold: "50,%",
+						pkg.ParamReadonly:              "true",
+						pkg.ParamSnapshotInitialSize:   "4Gi",
+						pkg.ParamSnapshotThreshold:     "50%",
+						pkg.ParamSnapshotExpansionSize: "1Gi",
 					},
 				},
 			},
-			want:    nil,
-			wantErr: true,
+			want: &csi.CreateSnapshotResponse{
+				Snapshot: &csi.Snapshot{
+					SizeBytes:      4294967296,
+					SnapshotId:     snapshotContentName,
+					SourceVolumeId: pvName,
+					ReadyToUse:     true,
+				},
+			},
+			wantErr: false,
 		},
 		{
-			name:   "invalid args: param",
+			name:   "invalid args: rw snapshot of block volume",
 			fields: testfields,
 			args: args{
 				ctx: context.Background(),
 				req: &csi.CreateSnapshotRequest{
-					SourceVolumeId: pvName,
+					SourceVolumeId: blockPV.Name,
 					Name:           snapshotContentName,
-					Parameters: map[string]string{
-						pkg.ParamReadonly:              "true",
-						pkg.ParamSnapshotExpansionSize: "1bGi",
-					},
 				},
 			},
 			want:    nil,
 			wantErr: true,
 		},
 		{
-			name:   "create snapshot success",
+			name:   "create snapshot success: block volume with fsfreeze",
 			fields: testfields,
 			args: args{
 				ctx: context.Background(),
 				req: &csi.CreateSnapshotRequest{
-					SourceVolumeId: pvName,
+					SourceVolumeId: blockPV.Name,
 					Name:           snapshotContentName,
 					Parameters: map[string]string{
-						pkg.ParamReadonly:              "true",
-						pkg.ParamSnapshotInitialSize:   "4Gi",
-						pkg.ParamSnapshotThreshold:     "50%",
-						pkg.ParamSnapshotExpansionSize: "1Gi",
+						pkg.ParamReadonly:            "true",
+						pkg.ParamSnapshotInitialSize: "4Gi",
+						pkg.ParamSnapshotFsFreeze:    "true",
 					},
 				},
 			},
@@ -1196,7 +2572,7 @@ func Test_controllerServer_CreateSnapshot(t *testing.T) {
 				Snapshot: &csi.Snapshot{
 					SizeBytes:      4294967296,
 					SnapshotId:     snapshotContentName,
-					SourceVolumeId: pvName,
+					SourceVolumeId: blockPV.Name,
 					ReadyToUse:     true,
 				},
 			},
@@ -1494,9 +2870,15 @@ func Test_controllerServer_ControllerExpandVolume(t *testing.T) {
 	kubeInformerFactory.Start(ctx.Done())
 	cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced, podInformer.HasSynced, pvcInformer.HasSynced, pvInformer.HasSynced)
 
+	pvWithMaxSize := pv.DeepCopy()
+	pvWithMaxSize.Name = "test-pv-max-size"
+	pvWithMaxSize.Spec.CSI.VolumeAttributes[pkg.VolumeMaxSize] = "200Gi"
+
 	// client
-	if err := pvInformer.GetIndexer().Add(pv); err != nil {
-		t.Errorf("fail to add pvc: %s", err.Error())
+	for _, pv := range []*corev1.PersistentVolume{pv, pvWithMaxSize} {
+		if err := pvInformer.GetIndexer().Add(pv); err != nil {
+			t.Errorf("fail to add pvc: %s", err.Error())
+		}
 	}
 	if err := nodeInformer.GetIndexer().Add(node); err != nil {
 		t.Errorf("fail to add node: %s", err.Error())
@@ -1518,6 +2900,22 @@ func Test_controllerServer_ControllerExpandVolume(t *testing.T) {
 			localclient: fakeLocalClient,
 		},
 	}
+	maintenancefields := testfields
+	maintenancefields.options = &driverOptions{
+		kubeclient: fakeKubeClient,
+		snapclient: fakeSnapClient,
+		localclient: fakelocalclientset.NewSimpleClientset(&localv1alpha1.NodeLocalStorage{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: utils.NodeName4,
+			},
+			Spec: localv1alpha1.NodeLocalStorageSpec{
+				NodeName: utils.NodeName4,
+				ListConfig: localv1alpha1.ListConfig{
+					VGs: localv1alpha1.VGList{Maintenance: []string{"newVG"}},
+				},
+			},
+		}),
+	}
 
 	tests := []struct {
 		name    string
@@ -1558,6 +2956,61 @@ func Test_controllerServer_ControllerExpandVolume(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:   "expand volume within max size successfully",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.ControllerExpandVolumeRequest{
+					VolumeId: pvWithMaxSize.Name,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 200 * 1024 * 1024 * 1024,
+					},
+				},
+			},
+			want: &csi.ControllerExpandVolumeResponse{
+				CapacityBytes:         200 * 1024 * 1024 * 1024,
+				NodeExpansionRequired: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:   "expand volume exceeding max size",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.ControllerExpandVolumeRequest{
+					VolumeId: pvWithMaxSize.Name,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 268435456000,
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "expand volume in vg under maintenance successfully",
+			fields: maintenancefields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.ControllerExpandVolumeRequest{
+					VolumeId: pvName,
+					CapacityRange: &csi.CapacityRange{
+						RequiredBytes: 268435456000,
+					},
+					VolumeCapability: &csi.VolumeCapability{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					},
+				},
+			},
+			want: &csi.ControllerExpandVolumeResponse{
+				CapacityBytes:         268435456000,
+				NodeExpansionRequired: true,
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1584,6 +3037,157 @@ func Test_controllerServer_ControllerExpandVolume(t *testing.T) {
 	}
 }
 
+func Test_controllerServer_ControllerModifyVolume(t *testing.T) {
+	pvName := "test-pv"
+	newPV := func(volumeType string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: pvName,
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						VolumeAttributes: map[string]string{
+							pkg.ParamVGName:     "newVG",
+							pkg.VolumeTypeKey:   volumeType,
+							pkg.VolumeFSTypeKey: "ext4",
+							pkg.VolumeIOPS:      "1024",
+							pkg.VolumeBPS:       "1048576",
+						},
+					},
+				},
+				NodeAffinity: &corev1.VolumeNodeAffinity{
+					Required: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchExpressions: []corev1.NodeSelectorRequirement{
+									{
+										Key:      pkg.KubernetesNodeIdentityKey,
+										Operator: corev1.NodeSelectorOpIn,
+										Values:   []string{utils.NodeName4},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	node := utils.CreateNode(&utils.TestNodeInfo{
+		NodeName:  utils.NodeName4,
+		IPAddress: "127.0.0.1",
+	})
+
+	tests := []struct {
+		name            string
+		pv              *corev1.PersistentVolume
+		volumeID        string
+		params          map[string]string
+		wantCode        codes.Code
+		wantAnnotations map[string]string
+	}{
+		{
+			name:     "modify iops",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.VolumeIOPS: "2048"},
+			wantCode: codes.OK,
+			wantAnnotations: map[string]string{
+				pkg.AnnotationPVIOPSKey: "2048",
+				pkg.AnnotationPVBPSKey:  "1048576",
+			},
+		},
+		{
+			name:     "modify iops and bps",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.VolumeIOPS: "2048", pkg.VolumeBPS: "2097152"},
+			wantCode: codes.OK,
+			wantAnnotations: map[string]string{
+				pkg.AnnotationPVIOPSKey: "2048",
+				pkg.AnnotationPVBPSKey:  "2097152",
+			},
+		},
+		{
+			name:     "refuse fsType",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.VolumeIOPS: "2048", pkg.VolumeFSTypeKey: "xfs"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "refuse vgName",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.ParamVGName: "otherVG"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "refuse invalid iops",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.VolumeIOPS: "0"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "refuse device volume",
+			pv:       newPV(string(pkg.VolumeTypeDevice)),
+			volumeID: pvName,
+			params:   map[string]string{pkg.VolumeIOPS: "2048"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "volume not found",
+			pv:       newPV(string(pkg.VolumeTypeLVM)),
+			volumeID: "pv-not-exist",
+			params:   map[string]string{pkg.VolumeIOPS: "2048"},
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeKubeClient := fakekubeclientset.NewSimpleClientset(tt.pv)
+			kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakeKubeClient, 0)
+			if err := kubeInformerFactory.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(tt.pv); err != nil {
+				t.Fatalf("fail to add pv: %s", err.Error())
+			}
+			if err := kubeInformerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(node); err != nil {
+				t.Fatalf("fail to add node: %s", err.Error())
+			}
+			cs := &controllerServer{
+				inFlight:   NewInFlight(),
+				nodeLister: kubeInformerFactory.Core().V1().Nodes().Lister(),
+				pvLister:   kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+				options: &driverOptions{
+					kubeclient: fakeKubeClient,
+				},
+			}
+			_, err := cs.ControllerModifyVolume(ctx, &csi.ControllerModifyVolumeRequest{
+				VolumeId:          tt.volumeID,
+				MutableParameters: tt.params,
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("controllerServer.ControllerModifyVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			pv, err := fakeKubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("fail to get pv: %s", err.Error())
+			}
+			if len(pv.Annotations) != 0 || len(tt.wantAnnotations) != 0 {
+				if !reflect.DeepEqual(pv.Annotations, tt.wantAnnotations) {
+					t.Errorf("annotations of pv = %v, want %v", pv.Annotations, tt.wantAnnotations)
+				}
+			}
+			// limits of storage class are never changed
+			if !reflect.DeepEqual(pv.Spec.CSI.VolumeAttributes, tt.pv.Spec.CSI.VolumeAttributes) {
+				t.Errorf("volume attributes of pv = %v, want %v", pv.Spec.CSI.VolumeAttributes, tt.pv.Spec.CSI.VolumeAttributes)
+			}
+		})
+	}
+}
+
 func Test_controllerServer_ValidateVolumeCapabilities(t *testing.T) {
 	type args struct {
 		ctx context.Context
@@ -1710,6 +3314,13 @@ func Test_controllerServer_ControllerGetCapabilities(t *testing.T) {
 							},
 						},
 					},
+					{
+						Type: &csi.ControllerServiceCapability_Rpc{
+							Rpc: &csi.ControllerServiceCapability_RPC{
+								Type: csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+							},
+						},
+					},
 				},
 			},
 			wantErr: false,
@@ -1789,3 +3400,290 @@ func Test_newControllerServer(t *testing.T) {
 		})
 	}
 }
+
+func Test_getSnapshotInitialInfo(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	tests := []struct {
+		name             string
+		param            map[string]string
+		originSize       uint64
+		wantInitialSize  uint64
+		wantIncreaseSize uint64
+		wantThreshold    float64
+		wantErr          bool
+	}{
+		{
+			name:             "test default",
+			param:            map[string]string{},
+			originSize:       100 * gi,
+			wantInitialSize:  localtype.DefaultSnapshotInitialSize,
+			wantIncreaseSize: localtype.DefaultSnapshotExpansionSize,
+			wantThreshold:    localtype.DefaultSnapshotThreshold,
+		},
+		{
+			name: "test expansion size in percentage of vg resolved by agent",
+			param: map[string]string{
+				localtype.ParamSnapshotInitialSize:   "8Gi",
+				localtype.ParamSnapshotExpansionSize: "+10%VG",
+			},
+			originSize:       100 * gi,
+			wantInitialSize:  8 * gi,
+			wantIncreaseSize: localtype.DefaultSnapshotExpansionSize,
+			wantThreshold:    localtype.DefaultSnapshotThreshold,
+		},
+		{
+			name: "test absolute size",
+			param: map[string]string{
+				localtype.ParamSnapshotInitialSize:   "8Gi",
+				localtype.ParamSnapshotThreshold:     "60%",
+				localtype.ParamSnapshotExpansionSize: "2Gi",
+			},
+			originSize:       100 * gi,
+			wantInitialSize:  8 * gi,
+			wantIncreaseSize: 2 * gi,
+			wantThreshold:    0.6,
+		},
+		{
+			name: "test legacy keys",
+			param: map[string]string{
+				"storage.oecp.io/snapshot-initial-size":        "8Gi",
+				"storage.oecp.io/snapshot-expansion-threshold": "60%",
+				"storage.oecp.io/snapshot-expansion-size":      "2Gi",
+			},
+			originSize:       100 * gi,
+			wantInitialSize:  8 * gi,
+			wantIncreaseSize: 2 * gi,
+			wantThreshold:    0.6,
+		},
+		{
+			name: "test percentage of origin size",
+			param: map[string]string{
+				localtype.ParamSnapshotInitialSize:   "20%",
+				localtype.ParamSnapshotExpansionSize: "5%",
+			},
+			originSize:       100 * gi,
+			wantInitialSize:  20 * gi,
+			wantIncreaseSize: 5 * gi,
+			wantThreshold:    localtype.DefaultSnapshotThreshold,
+		},
+		{
+			name: "test percentage mixed with absolute size",
+			param: map[string]string{
+				localtype.ParamSnapshotInitialSize:   "20%",
+				localtype.ParamSnapshotExpansionSize: "1Gi",
+			},
+			originSize:       10 * gi,
+			wantInitialSize:  2 * gi,
+			wantIncreaseSize: 1 * gi,
+			wantThreshold:    localtype.DefaultSnapshotThreshold,
+		},
+		{
+			name: "test invalid percentage",
+			param: map[string]string{
+				localtype.ParamSnapshotInitialSize: "120%",
+			},
+			originSize: 10 * gi,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initialSize, threshold, increaseSize, err := getSnapshotInitialInfo(tt.param, tt.originSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getSnapshotInitialInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if initialSize != tt.wantInitialSize || increaseSize != tt.wantIncreaseSize || threshold != tt.wantThreshold {
+				t.Errorf("getSnapshotInitialInfo() = %d, %f, %d, want %d, %f, %d", initialSize, threshold, increaseSize, tt.wantInitialSize, tt.wantThreshold, tt.wantIncreaseSize)
+			}
+		})
+	}
+}
+
+func Test_snapshotErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "test vg of origin lacks room",
+			err:  fmt.Errorf("fail to create snapshot: rpc error: code = ResourceExhausted desc = %s: vg open-local-pool-0 has 0 bytes free", server.ErrSnapshotNoSpace.Error()),
+			want: codes.ResourceExhausted,
+		},
+		{
+			name: "test origin is a snapshot",
+			err:  fmt.Errorf("fail to create snapshot: rpc error: code = FailedPrecondition desc = %s: origin lv vg/snap is a snapshot", server.ErrSnapshotOfSnapshot.Error()),
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "test other error",
+			err:  errors.New("fail to create snapshot: lvcreate failed"),
+			want: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snapshotErrorCode(tt.err); got != tt.want {
+				t.Errorf("snapshotErrorCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_checkSnapshotCapacity(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	newNLS := func(maxSnapshotSize string, snapshotSize uint64) *localv1alpha1.NodeLocalStorage {
+		return &localv1alpha1.NodeLocalStorage{
+			ObjectMeta: metav1.ObjectMeta{Name: utils.NodeName4},
+			Spec: localv1alpha1.NodeLocalStorageSpec{
+				NodeName:   utils.NodeName4,
+				ListConfig: localv1alpha1.ListConfig{VGs: localv1alpha1.VGList{MaxSnapshotSize: maxSnapshotSize}},
+			},
+			Status: localv1alpha1.NodeLocalStorageStatus{
+				NodeStorageInfo: localv1alpha1.NodeStorageInfo{
+					VolumeGroups: []localv1alpha1.VolumeGroup{{
+						Name:  "newVG",
+						Total: 100 * gi,
+						LogicalVolumes: []localv1alpha1.LogicalVolume{
+							{Name: "origin", Total: 50 * gi},
+							{Name: "snap-0", Origin: "origin", Total: snapshotSize},
+						},
+					}},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		nls      *localv1alpha1.NodeLocalStorage
+		size     uint64
+		wantCode codes.Code
+	}{
+		{name: "test no nls", size: 10 * gi, wantCode: codes.OK},
+		{name: "test unlimited", nls: newNLS("", 90*gi), size: 10 * gi, wantCode: codes.OK},
+		{name: "test within absolute cap", nls: newNLS("20Gi", 10*gi), size: 10 * gi, wantCode: codes.OK},
+		{name: "test past absolute cap", nls: newNLS("20Gi", 10*gi), size: 10*gi + 1, wantCode: codes.ResourceExhausted},
+		{name: "test within percentage cap", nls: newNLS("30%", 10*gi), size: 20 * gi, wantCode: codes.OK},
+		{name: "test past percentage cap", nls: newNLS("30%", 25*gi), size: 10 * gi, wantCode: codes.ResourceExhausted},
+		{name: "test invalid cap", nls: newNLS("30 percent", 0), size: gi, wantCode: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localClient := fakelocalclientset.NewSimpleClientset()
+			if tt.nls != nil {
+				localClient = fakelocalclientset.NewSimpleClientset(tt.nls)
+			}
+			cs := &controllerServer{options: &driverOptions{localclient: localClient}}
+			err := cs.checkSnapshotCapacity(context.Background(), utils.NodeName4, tt.size)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("checkSnapshotCapacity() code = %v, want %v, error %v", got, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+// fakeVGConnection serves vg newVG and lv test-pv
+type fakeVGConnection struct {
+	client.Connection
+	vg *lib.VolumeGroup
+	lv *lib.LogicalVolume
+}
+
+func (conn *fakeVGConnection) DescribeVolumeGroup(ctx context.Context, volGroup string) (*lib.VolumeGroup, error) {
+	if conn.vg == nil || conn.vg.Name != volGroup {
+		return nil, nil
+	}
+	return conn.vg, nil
+}
+
+func (conn *fakeVGConnection) DescribeVolume(ctx context.Context, volGroup string, volumeID string) (*lib.LogicalVolume, error) {
+	return conn.lv, nil
+}
+
+func Test_checkVGMinFree(t *testing.T) {
+	gi := uint64(1024 * 1024 * 1024)
+	conn := &fakeVGConnection{vg: &lib.VolumeGroup{Name: "newVG", Size: 200 * gi, FreeSize: 50 * gi}}
+	tests := []struct {
+		name        string
+		minFreeSize string
+		vgName      string
+		growth      uint64
+		wantCode    codes.Code
+	}{
+		{name: "no floor", vgName: "newVG", growth: 50 * gi, wantCode: codes.OK},
+		{name: "above absolute floor", minFreeSize: "10Gi", vgName: "newVG", growth: 40 * gi, wantCode: codes.OK},
+		{name: "crossing absolute floor", minFreeSize: "10Gi", vgName: "newVG", growth: 41 * gi, wantCode: codes.ResourceExhausted},
+		{name: "above percentage floor", minFreeSize: "5%", vgName: "newVG", growth: 40 * gi, wantCode: codes.OK},
+		{name: "crossing percentage floor", minFreeSize: "5%", vgName: "newVG", growth: 45 * gi, wantCode: codes.ResourceExhausted},
+		{name: "growth beyond free", minFreeSize: "1Gi", vgName: "newVG", growth: 60 * gi, wantCode: codes.ResourceExhausted},
+		{name: "vg not found", minFreeSize: "1Gi", vgName: "otherVG", growth: gi, wantCode: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: utils.NodeName4}}
+			nls.Spec.ListConfig.VGs.MinFreeSize = tt.minFreeSize
+			code, err := checkVGMinFree(context.Background(), conn, nls, utils.NodeName4, tt.vgName, tt.growth)
+			if code != tt.wantCode || (err != nil) != (tt.wantCode != codes.OK) {
+				t.Errorf("checkVGMinFree() = %v, %v, want code %v", code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_controllerServer_checkExpansionMinFree(t *testing.T) {
+	gi := uint64(1024 * 1024 * 1024)
+	nls := &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: utils.NodeName4}}
+	nls.Spec.ListConfig.VGs.MinFreeSize = "10Gi"
+	cs := &controllerServer{options: &driverOptions{localclient: fakelocalclientset.NewSimpleClientset(nls)}}
+	conn := &fakeVGConnection{
+		vg: &lib.VolumeGroup{Name: "newVG", Size: 200 * gi, FreeSize: 50 * gi},
+		lv: &lib.LogicalVolume{Name: "test-pv", Size: 100 * gi},
+	}
+	tests := []struct {
+		name     string
+		nodeName string
+		size     uint64
+		wantCode codes.Code
+	}{
+		{name: "growth above floor", nodeName: utils.NodeName4, size: 140 * gi, wantCode: codes.OK},
+		{name: "growth crossing floor", nodeName: utils.NodeName4, size: 141 * gi, wantCode: codes.ResourceExhausted},
+		{name: "no growth", nodeName: utils.NodeName4, size: 100 * gi, wantCode: codes.OK},
+		{name: "node without nls", nodeName: utils.NodeName3, size: 300 * gi, wantCode: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := cs.checkExpansionMinFree(context.Background(), conn, tt.nodeName, "newVG", "test-pv", tt.size)
+			if code != tt.wantCode || (err != nil) != (tt.wantCode != codes.OK) {
+				t.Errorf("controllerServer.checkExpansionMinFree() = %v, %v, want code %v", code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_reconcileExistingLV(t *testing.T) {
+	tests := []struct {
+		name    string
+		lv      *lib.LogicalVolume
+		zero    bool
+		want    bool
+		wantErr bool
+	}{
+		{name: "lv gone", lv: nil, want: false},
+		{name: "lv of old version", lv: &lib.LogicalVolume{Name: "pv-1", Tags: []string{pkg.ManagedLVTag}}, want: false},
+		{name: "lv left by interrupted request", lv: &lib.LogicalVolume{Name: "pv-1", Tags: []string{pkg.ManagedLVTag, createdForTag("pv-1")}}, want: true},
+		{name: "lv left unzeroed", lv: &lib.LogicalVolume{Name: "pv-1", Tags: []string{pkg.ManagedLVTag, pkg.ZeroingLVTag}}, zero: true, want: true},
+		{name: "lv of the other volume", lv: &lib.LogicalVolume{Name: "pv-1", Tags: []string{pkg.ManagedLVTag, createdForTag("pv-2")}}, wantErr: true},
+		{name: "preallocated lv", lv: &lib.LogicalVolume{Name: "prealloc-1", Tags: []string{pkg.ManagedLVTag, preallocAssigneeTag("pv-1")}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reconcileExistingLV(tt.lv, &client.LVMOptions{Zero: tt.zero}, "pv-1")
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("reconcileExistingLV() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -26,9 +26,9 @@ import (
 
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
 	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/tracing"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
-	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"k8s.io/client-go/kubernetes"
 	log "k8s.io/klog/v2"
@@ -44,6 +44,30 @@ type driverOptions struct {
 	mode                    string
 	extenderSchedulerNames  []string
 	frameworkSchedulerNames []string
+	lvNameTemplate          string
+	// formatTimeout is the timeout(second) of formatting volume, 0 means no timeout
+	formatTimeout int
+	// postProvisionHooks are executables run after volume is published
+	postProvisionHooks []string
+	// postProvisionHookTimeout is the timeout(second) of every hook, 0 means no timeout
+	postProvisionHookTimeout int
+	// fsckMode is one of FsckModeNone, FsckModeCheck and FsckModeRepair
+	fsckMode string
+	// fsckTimeout is the timeout(second) of every check or repair, 0 means no timeout
+	fsckTimeout int
+	// fsGroupPolicy is the fsGroupPolicy of CSIDriver, one of FSGroupPolicies
+	fsGroupPolicy string
+	// lvPreallocSizes are size classes of preallocated lvs, empty means
+	// preallocation is disabled
+	lvPreallocSizes []uint64
+	// lvPreallocCount is the number of unassigned lvs kept per vg and size class
+	lvPreallocCount int
+	// lvPreallocTTL is the time(second) unassigned lvs are kept after the
+	// size class is last requested
+	lvPreallocTTL int
+	// leaderElectionNamespace is the namespace of leases of sidecars, empty
+	// means sidecars run without leader election
+	leaderElectionNamespace string
 
 	kubeclient  kubernetes.Interface
 	localclient clientset.Interface
@@ -51,12 +75,19 @@ type driverOptions struct {
 }
 
 var defaultDriverOptions = driverOptions{
-	sysPath:                 "/host_sys",
-	cgroupDriver:            "systemd",
-	grpcConnectionTimeout:   DefaultConnectTimeout,
-	mode:                    "all",
-	extenderSchedulerNames:  []string{"default-scheduler"},
-	frameworkSchedulerNames: []string{},
+	sysPath:                  "/host_sys",
+	cgroupDriver:             "systemd",
+	grpcConnectionTimeout:    DefaultConnectTimeout,
+	mode:                     "all",
+	extenderSchedulerNames:   []string{"default-scheduler"},
+	frameworkSchedulerNames:  []string{},
+	lvNameTemplate:           utils.DefaultLVNameTemplate,
+	postProvisionHookTimeout: DefaultPostProvisionHookTimeout,
+	fsckMode:                 FsckModeNone,
+	fsckTimeout:              DefaultFsckTimeout,
+	fsGroupPolicy:            FSGroupPolicyReadWriteOnceWithFSType,
+	lvPreallocCount:          DefaultPreallocCount,
+	lvPreallocTTL:            DefaultPreallocTTL,
 }
 
 // Option configures a Driver
@@ -71,6 +102,20 @@ func NewDriver(driverName, nodeID, endpoint string, opts ...Option) *CSIPlugin {
 	for _, opt := range opts {
 		opt(driverOptions)
 	}
+	if err := utils.ValidateLVNameTemplate(driverOptions.lvNameTemplate); err != nil {
+		log.Fatalf("invalid lv name template: %s", err.Error())
+	}
+	if err := validatePostProvisionHooks(driverOptions.postProvisionHooks); err != nil {
+		log.Fatalf("invalid post-provision hook: %s", err.Error())
+	}
+	switch driverOptions.fsckMode {
+	case FsckModeNone, FsckModeCheck, FsckModeRepair:
+	default:
+		log.Fatalf("invalid fsck mode %q, must be %s, %s or %s", driverOptions.fsckMode, FsckModeNone, FsckModeCheck, FsckModeRepair)
+	}
+	if err := validateFSGroupPolicy(driverOptions.fsGroupPolicy); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
 	plugin := &CSIPlugin{
 		options: driverOptions,
 	}
@@ -104,15 +149,8 @@ func (plugin *CSIPlugin) Run() error {
 		return err
 	}
 
-	logErr := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		resp, err := handler(ctx, req)
-		if err != nil {
-			log.Errorf("GRPC error: %v", err)
-		}
-		return resp, err
-	}
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logErr),
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(tracedMethods, spanAttributes), logOperation),
 	}
 	plugin.srv = grpc.NewServer(opts...)
 
@@ -174,6 +212,57 @@ func WithFrameworkSchedulerNames(frameworkSchedulerNames []string) Option {
 	}
 }
 
+func WithLVNameTemplate(lvNameTemplate string) Option {
+	return func(o *driverOptions) {
+		o.lvNameTemplate = lvNameTemplate
+	}
+}
+
+func WithFormatTimeout(formatTimeout int) Option {
+	return func(o *driverOptions) {
+		o.formatTimeout = formatTimeout
+	}
+}
+
+func WithPostProvisionHooks(hooks []string, timeout int) Option {
+	return func(o *driverOptions) {
+		o.postProvisionHooks = hooks
+		o.postProvisionHookTimeout = timeout
+	}
+}
+
+func WithFsck(mode string, timeout int) Option {
+	return func(o *driverOptions) {
+		o.fsckMode = mode
+		o.fsckTimeout = timeout
+	}
+}
+
+// WithFSGroupPolicy must be the same as fsGroupPolicy of CSIDriver
+func WithFSGroupPolicy(policy string) Option {
+	return func(o *driverOptions) {
+		o.fsGroupPolicy = policy
+	}
+}
+
+// WithLVPrealloc keeps count unassigned lvs of every size class on vgs where
+// the size class is requested within ttl(second)
+func WithLVPrealloc(sizes []uint64, count int, ttl int) Option {
+	return func(o *driverOptions) {
+		o.lvPreallocSizes = sizes
+		o.lvPreallocCount = count
+		o.lvPreallocTTL = ttl
+	}
+}
+
+// WithLeaderElection gates controller operations on leases which sidecars
+// elect leader by in namespace
+func WithLeaderElection(namespace string) Option {
+	return func(o *driverOptions) {
+		o.leaderElectionNamespace = namespace
+	}
+}
+
 func WithKubeClient(kubeclient kubernetes.Interface) Option {
 	return func(o *driverOptions) {
 		o.kubeclient = kubeclient
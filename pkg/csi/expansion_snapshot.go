@@ -0,0 +1,179 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// ExpansionSnapshotRetainedCondition prefixes message of abnormal volume
+	// condition when snapshot taken before resize is kept for recovery
+	ExpansionSnapshotRetainedCondition = "ExpansionSnapshotRetained"
+
+	// expansionSnapshotSuffix is appended to lv name to name the snapshot
+	expansionSnapshotSuffix = "-expand-snap"
+)
+
+// expansionSnapshotName returns name of snapshot lv taken before filesystem
+// of lv is grown
+func expansionSnapshotName(lvName string) string {
+	return lvName + expansionSnapshotSuffix
+}
+
+// vgLVs is size of lvs in vg by name, along with size and free space of vg
+type vgLVs struct {
+	lvSizes map[string]uint64
+	size    uint64
+	free    uint64
+}
+
+// parseVGLVs parses lvs of a vg printed by lvs -o lv_name,lv_size,vg_size,vg_free
+func parseVGLVs(out string) (vgLVs, error) {
+	info := vgLVs{lvSizes: map[string]uint64{}}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return info, fmt.Errorf("invalid output of lvs: %q", line)
+		}
+		var sizes [3]uint64
+		for i := range sizes {
+			value, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return info, fmt.Errorf("invalid output of lvs: %q", line)
+			}
+			sizes[i] = value
+		}
+		info.lvSizes[fields[0]] = sizes[0]
+		info.size, info.free = sizes[1], sizes[2]
+	}
+	return info, nil
+}
+
+// takeExpansionSnapshot takes snapshot of lv before its filesystem is grown if
+// ParamExpansionSnapshotPercent of pv enables it, cow space of the snapshot
+// is taken from free space of vg beyond minFreeSize. Empty name is returned
+// if no snapshot is taken
+func (ns *nodeServer) takeExpansionSnapshot(ctx context.Context, pv *corev1.PersistentVolume, volumeID, vgName, lvName string) (string, error) {
+	if pv.Spec.CSI == nil {
+		return "", nil
+	}
+	params := pv.Spec.CSI.VolumeAttributes
+	if value, ok := utils.LookupParam(params, localtype.ParamExpansionSnapshotPercent); !ok || value == "" {
+		return "", nil
+	}
+	out, err := ns.osTool.RunCommand(fmt.Sprintf("%s --noheadings --nosuffix --units b -o lv_name,lv_size,vg_size,vg_free %s", lvm.Command("lvs"), vgName))
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "NodeExpandVolume: fail to list lvs of vg %s: %s", vgName, err.Error())
+	}
+	info, err := parseVGLVs(out)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "NodeExpandVolume: %s", err.Error())
+	}
+	snapshotName := expansionSnapshotName(lvName)
+	if _, exist := info.lvSizes[snapshotName]; exist {
+		// the snapshot is the only state of volume before the failed resize
+		message := fmt.Sprintf("%s: snapshot %s/%s taken before an earlier resize of filesystem is retained, recover the volume from it or remove it before expanding again", ExpansionSnapshotRetainedCondition, vgName, snapshotName)
+		ns.expansionConditions.Store(volumeID, message)
+		return "", status.Errorf(codes.FailedPrecondition, "NodeExpandVolume: volume %s: %s", volumeID, message)
+	}
+	lvSize, exist := info.lvSizes[lvName]
+	if !exist {
+		return "", status.Errorf(codes.NotFound, "NodeExpandVolume: lv %s/%s of volume %s not found", vgName, lvName, volumeID)
+	}
+	size, err := utils.GetExpansionSnapshotSize(int64(lvSize), params)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "NodeExpandVolume: %s", err.Error())
+	}
+	if size <= 0 {
+		return "", nil
+	}
+	minFree, err := ns.vgMinFree(ctx, info.size)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "NodeExpandVolume: %s", err.Error())
+	}
+	if info.free < uint64(size) || info.free-uint64(size) < minFree {
+		return "", status.Errorf(codes.ResourceExhausted, "NodeExpandVolume: no space for snapshot of volume %s before resize: %s",
+			volumeID, errors.NewVGMinFreeBreachedError(uint64(size), info.free, minFree, vgName, ns.options.nodeID).Error())
+	}
+	cmd := fmt.Sprintf("%s -s -n %s -L %db --addtag %s %s/%s", lvm.Command("lvcreate"), snapshotName, size, localtype.ExpansionSnapshotLVTag, vgName, lvName)
+	if _, err := ns.osTool.RunCommand(cmd); err != nil {
+		return "", status.Errorf(codes.Internal, "NodeExpandVolume: fail to take snapshot of volume %s before resize: %s", volumeID, err.Error())
+	}
+	log.Infof("NodeExpandVolume:: snapshot %s/%s of %d bytes is taken before resize of volume %s", vgName, snapshotName, size, volumeID)
+	return snapshotName, nil
+}
+
+// vgMinFree returns minFreeSize of vg of total bytes set in nls of node, node
+// without nls has no floor
+func (ns *nodeServer) vgMinFree(ctx context.Context, total uint64) (uint64, error) {
+	if ns.options.localclient == nil {
+		return 0, nil
+	}
+	nls, err := ns.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, ns.options.nodeID, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("fail to get nls %s: %s", ns.options.nodeID, err.Error())
+	}
+	return utils.VGMinFree(nls, total)
+}
+
+// finishExpansionSnapshot discards snapshot taken before resize if filesystem
+// is grown, otherwise the snapshot is retained and reported as condition of
+// volume so that the volume can be recovered by lvconvert --merge
+func (ns *nodeServer) finishExpansionSnapshot(volumeID, vgName, snapshotName string, resizeErr error) {
+	if snapshotName == "" {
+		if resizeErr == nil {
+			ns.expansionConditions.Delete(volumeID)
+		}
+		return
+	}
+	if resizeErr != nil {
+		message := fmt.Sprintf("%s: resize of filesystem failed, snapshot %s/%s taken before resize is retained for recovery: %s", ExpansionSnapshotRetainedCondition, vgName, snapshotName, resizeErr.Error())
+		log.Warningf("NodeExpandVolume:: volume %s: %s", volumeID, message)
+		ns.expansionConditions.Store(volumeID, message)
+		return
+	}
+	if _, err := ns.osTool.RunCommand(fmt.Sprintf("%s -f %s/%s", lvm.Command("lvremove"), vgName, snapshotName)); err != nil {
+		// filesystem is grown, the snapshot only blocks next expansion
+		message := fmt.Sprintf("%s: filesystem is resized but snapshot %s/%s taken before resize is not removed: %s", ExpansionSnapshotRetainedCondition, vgName, snapshotName, err.Error())
+		log.Errorf("NodeExpandVolume:: volume %s: %s", volumeID, message)
+		ns.expansionConditions.Store(volumeID, message)
+		return
+	}
+	ns.expansionConditions.Delete(volumeID)
+	log.Infof("NodeExpandVolume:: snapshot %s/%s taken before resize of volume %s is discarded", vgName, snapshotName, volumeID)
+}
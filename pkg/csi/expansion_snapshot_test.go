@@ -0,0 +1,173 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_parseVGLVs(t *testing.T) {
+	out := "  pv-1            10737418240 107374182400 53687091200\n  pv-1-expand-snap 1073741824 107374182400 53687091200\n"
+	info, err := parseVGLVs(out)
+	if err != nil {
+		t.Fatalf("parseVGLVs() error = %v", err)
+	}
+	if info.lvSizes["pv-1"] != 10737418240 || info.lvSizes["pv-1-expand-snap"] != 1073741824 || info.size != 107374182400 || info.free != 53687091200 {
+		t.Errorf("parseVGLVs() = %+v", info)
+	}
+	if _, err := parseVGLVs("  pv-1 10g 100g 50g\n"); err == nil {
+		t.Errorf("parseVGLVs() of sizes with unit, want error")
+	}
+}
+
+func Test_nodeServer_NodeExpandVolume_ExpansionSnapshot(t *testing.T) {
+	pvName := "test-expand-pv"
+	snapshotName := pvName + expansionSnapshotSuffix
+	lvs := func(withSnapshot bool, free uint64) func(cmd string) (string, error) {
+		return func(cmd string) (string, error) {
+			if !strings.Contains(cmd, " lvs ") {
+				return "", nil
+			}
+			out := fmt.Sprintf("  %s 214748364800 1099511627776 %d\n", pvName, free)
+			if withSnapshot {
+				out += fmt.Sprintf("  %s 21474836480 1099511627776 %d\n", snapshotName, free)
+			}
+			return out, nil
+		}
+	}
+	tests := []struct {
+		name          string
+		percent       string
+		runCommand    func(cmd string) (string, error)
+		resizeErr     error
+		wantCode      codes.Code
+		wantCommands  []string
+		wantResized   bool
+		wantCondition string
+	}{
+		{
+			name:        "test snapshot disabled",
+			runCommand:  lvs(false, 1<<40),
+			wantCode:    codes.OK,
+			wantResized: true,
+		},
+		{
+			name:       "test snapshot discarded after resize",
+			percent:    "10",
+			runCommand: lvs(false, 1<<40),
+			wantCode:   codes.OK,
+			wantCommands: []string{
+				"lvs --noheadings --nosuffix --units b -o lv_name,lv_size,vg_size,vg_free newVG",
+				"lvcreate -s -n test-expand-pv-expand-snap -L 21474836480b --addtag open-local.io/expansion-snapshot newVG/test-expand-pv",
+				"lvremove -f newVG/test-expand-pv-expand-snap",
+			},
+			wantResized: true,
+		},
+		{
+			name:       "test snapshot retained after failed resize",
+			percent:    "10",
+			runCommand: lvs(false, 1<<40),
+			resizeErr:  errors.New("resize2fs: Input/output error"),
+			wantCode:   codes.Internal,
+			wantCommands: []string{
+				"lvs --noheadings --nosuffix --units b -o lv_name,lv_size,vg_size,vg_free newVG",
+				"lvcreate -s -n test-expand-pv-expand-snap -L 21474836480b --addtag open-local.io/expansion-snapshot newVG/test-expand-pv",
+			},
+			wantResized:   true,
+			wantCondition: "ExpansionSnapshotRetained: resize of filesystem failed, snapshot newVG/test-expand-pv-expand-snap taken before resize is retained for recovery: resize2fs: Input/output error",
+		},
+		{
+			name:          "test resize refused while snapshot is retained",
+			percent:       "10",
+			runCommand:    lvs(true, 1<<40),
+			wantCode:      codes.FailedPrecondition,
+			wantCommands:  []string{"lvs --noheadings --nosuffix --units b -o lv_name,lv_size,vg_size,vg_free newVG"},
+			wantCondition: "ExpansionSnapshotRetained: snapshot newVG/test-expand-pv-expand-snap taken before an earlier resize of filesystem is retained, recover the volume from it or remove it before expanding again",
+		},
+		{
+			name:         "test no space for snapshot",
+			percent:      "10",
+			runCommand:   lvs(false, 1<<30),
+			wantCode:     codes.ResourceExhausted,
+			wantCommands: []string{"lvs --noheadings --nosuffix --units b -o lv_name,lv_size,vg_size,vg_free newVG"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := map[string]string{
+				pkg.VGName:        "newVG",
+				pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+			}
+			if tt.percent != "" {
+				attributes[pkg.ParamExpansionSnapshotPercent] = tt.percent
+			}
+			pv := &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: pvName},
+				Spec: corev1.PersistentVolumeSpec{
+					Capacity: corev1.ResourceList{
+						corev1.ResourceStorage: resource.MustParse("200Gi"),
+					},
+					PersistentVolumeSource: corev1.PersistentVolumeSource{
+						CSI: &corev1.CSIPersistentVolumeSource{VolumeAttributes: attributes},
+					},
+				},
+			}
+			osTool := &fakeOSTool{runCommand: tt.runCommand, resizeErr: tt.resizeErr}
+			ns := &nodeServer{
+				inFlight:       NewInFlight(),
+				formatInFlight: NewInFlight(),
+				osTool:         osTool,
+				options:        &driverOptions{kubeclient: fakekubeclientset.NewSimpleClientset(pv), nodeID: "node-1"},
+			}
+			_, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:      pvName,
+				VolumePath:    "/tmp/test-expand",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: int64(200 * 1024 * 1024 * 1024)},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("NodeExpandVolume() code = %v, want %v, error: %v", code, tt.wantCode, err)
+			}
+			var commands []string
+			for _, cmd := range osTool.commands {
+				commands = append(commands, strings.TrimPrefix(cmd, pkg.NsenterCmd+" "))
+			}
+			if tt.percent != "" && strings.Join(commands, "\n") != strings.Join(tt.wantCommands, "\n") {
+				t.Errorf("NodeExpandVolume() runs %q, want %q", commands, tt.wantCommands)
+			}
+			if resized := len(osTool.resizedDevices) > 0; resized != tt.wantResized {
+				t.Errorf("NodeExpandVolume() resized filesystem = %v, want %v", resized, tt.wantResized)
+			}
+			condition := ns.filesystemCondition(pvName)
+			if condition.Abnormal != (tt.wantCondition != "") || (tt.wantCondition != "" && condition.Message != tt.wantCondition) {
+				t.Errorf("volume condition = %+v, want %q", condition, tt.wantCondition)
+			}
+		})
+	}
+}
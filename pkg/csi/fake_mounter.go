@@ -13,6 +13,8 @@ import (
 type FakeSafeMounter struct {
 	mount.FakeMounter
 	testingexec.FakeExec
+	// mountOptions is options of mounts by target
+	mountOptions map[string][]string
 }
 
 // NewFakeSafeMounter creates a mount.SafeFormatAndMount instance suitable for use in unit tests.
@@ -47,6 +49,10 @@ func (f *FakeSafeMounter) Mount(source, target, fstype string, options []string)
 	} else if strings.Contains(target, "error_mount") {
 		return fmt.Errorf("fake Mount: target error")
 	}
+	if f.mountOptions == nil {
+		f.mountOptions = map[string][]string{}
+	}
+	f.mountOptions[target] = options
 
 	return nil
 }
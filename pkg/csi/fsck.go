@@ -0,0 +1,255 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	log "k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// FsckModeNone mounts filesystem without check
+	FsckModeNone = "none"
+	// FsckModeCheck checks filesystem read-only before mounting, corruption
+	// is reported as volume condition and filesystem is mounted as is
+	FsckModeCheck = "check"
+	// FsckModeRepair repairs corrupt filesystem found by check, staging fails
+	// if it is not repaired
+	FsckModeRepair = "repair"
+	// DefaultFsckTimeout is the default timeout(second) of every check or repair
+	DefaultFsckTimeout = 300
+
+	// FilesystemCorruptCondition prefixes message of abnormal volume condition
+	FilesystemCorruptCondition = "FilesystemCorrupt"
+
+	// maxFsckOutput is the tail of fsck output kept in volume condition
+	maxFsckOutput = 512
+)
+
+// fsckResult is the state of filesystem reported by fsck
+type fsckResult int
+
+const (
+	fsckClean fsckResult = iota
+	fsckCorrupt
+	fsckRepaired
+)
+
+// fsckCommand returns checker of fsType in read-only or repair mode, false if
+// fsType is not supported
+func fsckCommand(fsType string, repair bool) (string, []string, bool) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		if repair {
+			return "fsck." + fsType, []string{"-f", "-y"}, true
+		}
+		return "fsck." + fsType, []string{"-f", "-n"}, true
+	case "xfs":
+		if repair {
+			return "xfs_repair", nil, true
+		}
+		return "xfs_repair", []string{"-n"}, true
+	}
+	return "", nil, false
+}
+
+// fsckResultOf interprets exit status of checker, error is returned if the
+// checker can not tell the state of filesystem
+func fsckResultOf(fsType string, repair bool, exitStatus int) (fsckResult, error) {
+	if fsType == "xfs" {
+		// xfs_repair exits with 1 if corruption is found in no-modify mode,
+		// and 2 if log must be replayed by mounting
+		switch {
+		case exitStatus == 0 && repair:
+			return fsckRepaired, nil
+		case exitStatus == 0:
+			return fsckClean, nil
+		case exitStatus == 1 && !repair:
+			return fsckCorrupt, nil
+		}
+		return fsckClean, fmt.Errorf("xfs_repair exits with %d", exitStatus)
+	}
+	// e2fsck exit status is a bit mask: 1 errors corrected, 2 reboot needed,
+	// 4 errors left uncorrected, 8 and above operational error
+	switch {
+	case exitStatus >= 8:
+		return fsckClean, fmt.Errorf("fsck exits with %d", exitStatus)
+	case exitStatus&4 != 0:
+		return fsckCorrupt, nil
+	case exitStatus&3 != 0:
+		return fsckRepaired, nil
+	}
+	return fsckClean, nil
+}
+
+// checkFilesystemOnStage checks filesystem of lvm and device volume before it
+// is published. Volume not created yet, unformatted or mounted is skipped
+func (ns *nodeServer) checkFilesystemOnStage(ctx context.Context, req *csi.NodeStageVolumeRequest) error {
+	if ns.options.fsckMode == "" || ns.options.fsckMode == FsckModeNone || ns.spdkSupported {
+		return nil
+	}
+	if req.GetVolumeCapability().GetMount() == nil || req.GetVolumeContext()[DirectTag] == "true" {
+		return nil
+	}
+	var device string
+	var err error
+	switch req.GetVolumeContext()[VolumeTypeTag] {
+	case string(pkg.VolumeTypeLVM):
+		var vgName, lvName string
+		if isEncryptedVolume(req.GetVolumeContext()) {
+			device = luksDevicePath(req.GetVolumeId())
+		} else if vgName, lvName, err = ns.getLVOfVolume(req.GetVolumeContext(), req.GetVolumeId()); err == nil {
+			device = filepath.Join("/dev", vgName, lvName)
+		}
+	case string(pkg.VolumeTypeDevice):
+		device, err = ns.getSourceDevice(req.GetVolumeContext())
+	default:
+		return nil
+	}
+	if err != nil {
+		log.Warningf("checkFilesystemOnStage: skip checking volume %s: %s", req.GetVolumeId(), err.Error())
+		return nil
+	}
+	if device == "" {
+		return nil
+	}
+	if _, err := ns.osTool.Stat(device); err != nil {
+		// lv is created when volume is published for the first time
+		log.V(4).Infof("checkFilesystemOnStage: skip checking volume %s, device %s: %s", req.GetVolumeId(), device, err.Error())
+		return nil
+	}
+	return ns.checkFilesystem(ctx, req.GetVolumeId(), device)
+}
+
+// checkFilesystem runs read-only check on device and a repair pass in repair
+// mode if corruption is found. The condition of volume is updated by result
+func (ns *nodeServer) checkFilesystem(ctx context.Context, volumeID, device string) error {
+	fsType, err := ns.k8smounter.GetDiskFormat(device)
+	if err != nil {
+		log.Warningf("checkFilesystem: skip checking volume %s, fail to get format of device %s: %s", volumeID, device, err.Error())
+		return nil
+	}
+	if _, _, ok := fsckCommand(fsType, false); !ok {
+		return nil
+	}
+	if mounted, err := ns.isDeviceMounted(device); err != nil || mounted {
+		// fsck on mounted filesystem reports false corruption
+		log.Infof("checkFilesystem: skip checking volume %s, device %s is mounted or unknown: %v", volumeID, device, err)
+		return nil
+	}
+	result, out, err := ns.runFsck(ctx, device, fsType, false)
+	if err != nil {
+		log.Warningf("checkFilesystem: fail to check %s of volume %s on device %s: %s", fsType, volumeID, device, err.Error())
+		return nil
+	}
+	if result != fsckCorrupt {
+		ns.fsConditions.Delete(volumeID)
+		return nil
+	}
+	message := fmt.Sprintf("%s: %s on device %s is corrupt: %s", FilesystemCorruptCondition, fsType, device, out)
+	if id := utils.OperationIDFromContext(ctx); id != "" {
+		// operation finding the corruption, to look up its log
+		message = fmt.Sprintf("%s (%s=%s)", message, utils.LogKeyOperationID, id)
+	}
+	log.Warningf("checkFilesystem: volume %s: %s", volumeID, message)
+	ns.fsConditions.Store(volumeID, message)
+	if ns.options.fsckMode != FsckModeRepair {
+		return nil
+	}
+	result, out, err = ns.runFsck(ctx, device, fsType, true)
+	if err != nil || result == fsckCorrupt {
+		if err == nil {
+			err = errors.New(out)
+		}
+		ns.fsConditions.Store(volumeID, fmt.Sprintf("%s, repair failed: %s", message, err.Error()))
+		return status.Errorf(codes.FailedPrecondition, "NodeStageVolume: %s on device %s of volume %s is corrupt and not repaired: %s", fsType, device, volumeID, err.Error())
+	}
+	ns.fsConditions.Delete(volumeID)
+	log.Infof("checkFilesystem: %s on device %s of volume %s is repaired: %s", fsType, device, volumeID, out)
+	return nil
+}
+
+// runFsck runs checker of fsType within fsckTimeout, output is trimmed to its tail
+func (ns *nodeServer) runFsck(ctx context.Context, device, fsType string, repair bool) (fsckResult, string, error) {
+	cmd, args, _ := fsckCommand(fsType, repair)
+	if ns.options.fsckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ns.options.fsckTimeout)*time.Second)
+		defer cancel()
+	}
+	args = append(args, device)
+	log.Infof("runFsck: %s %s", cmd, strings.Join(args, " "))
+	output, err := ns.k8smounter.Exec.CommandContext(ctx, cmd, args...).CombinedOutput()
+	out := strings.TrimSpace(string(output))
+	if len(out) > maxFsckOutput {
+		out = "..." + out[len(out)-maxFsckOutput:]
+	}
+	exitStatus := 0
+	if err != nil {
+		var exitErr utilexec.ExitError
+		if ctx.Err() != nil || !errors.As(err, &exitErr) {
+			return fsckClean, out, fmt.Errorf("%s: %s", cmd, err.Error())
+		}
+		exitStatus = exitErr.ExitStatus()
+	}
+	result, err := fsckResultOf(fsType, repair, exitStatus)
+	return result, out, err
+}
+
+// isDeviceMounted reports whether device or the device it links to is mounted
+func (ns *nodeServer) isDeviceMounted(device string) (bool, error) {
+	mountPoints, err := ns.k8smounter.List()
+	if err != nil {
+		return false, err
+	}
+	devices := map[string]bool{device: true}
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		devices[resolved] = true
+	}
+	for _, mountPoint := range mountPoints {
+		if devices[mountPoint.Device] {
+			return true, nil
+		}
+		if resolved, err := filepath.EvalSymlinks(mountPoint.Device); err == nil && devices[resolved] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// filesystemCondition returns condition of volume found by the last check
+func (ns *nodeServer) filesystemCondition(volumeID string) *csi.VolumeCondition {
+	if message, exist := ns.fsConditions.Load(volumeID); exist {
+		return &csi.VolumeCondition{Abnormal: true, Message: message.(string)}
+	}
+	if message, exist := ns.expansionConditions.Load(volumeID); exist {
+		return &csi.VolumeCondition{Abnormal: true, Message: message.(string)}
+	}
+	return &csi.VolumeCondition{Abnormal: false, Message: "filesystem is healthy"}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func Test_fsckCommand(t *testing.T) {
+	tests := []struct {
+		fsType   string
+		repair   bool
+		wantCmd  string
+		wantArgs []string
+		wantOK   bool
+	}{
+		{fsType: "ext4", wantCmd: "fsck.ext4", wantArgs: []string{"-f", "-n"}, wantOK: true},
+		{fsType: "ext3", repair: true, wantCmd: "fsck.ext3", wantArgs: []string{"-f", "-y"}, wantOK: true},
+		{fsType: "xfs", wantCmd: "xfs_repair", wantArgs: []string{"-n"}, wantOK: true},
+		{fsType: "xfs", repair: true, wantCmd: "xfs_repair", wantOK: true},
+		{fsType: "btrfs", wantOK: false},
+		{fsType: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		cmd, args, ok := fsckCommand(tt.fsType, tt.repair)
+		if cmd != tt.wantCmd || !reflect.DeepEqual(args, tt.wantArgs) || ok != tt.wantOK {
+			t.Errorf("fsckCommand(%q, %v) = %s, %v, %v, want %s, %v, %v", tt.fsType, tt.repair, cmd, args, ok, tt.wantCmd, tt.wantArgs, tt.wantOK)
+		}
+	}
+}
+
+func Test_fsckResultOf(t *testing.T) {
+	tests := []struct {
+		fsType     string
+		repair     bool
+		exitStatus int
+		want       fsckResult
+		wantErr    bool
+	}{
+		{fsType: "ext4", exitStatus: 0, want: fsckClean},
+		{fsType: "ext4", exitStatus: 4, want: fsckCorrupt},
+		{fsType: "ext4", repair: true, exitStatus: 1, want: fsckRepaired},
+		{fsType: "ext4", repair: true, exitStatus: 5, want: fsckCorrupt},
+		{fsType: "ext4", exitStatus: 8, wantErr: true},
+		{fsType: "xfs", exitStatus: 0, want: fsckClean},
+		{fsType: "xfs", exitStatus: 1, want: fsckCorrupt},
+		{fsType: "xfs", repair: true, exitStatus: 0, want: fsckRepaired},
+		{fsType: "xfs", repair: true, exitStatus: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := fsckResultOf(tt.fsType, tt.repair, tt.exitStatus)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("fsckResultOf(%q, %v, %d) = %v, %v, want %v, wantErr %v", tt.fsType, tt.repair, tt.exitStatus, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// fakeCommand returns command action recording command line to calls
+func fakeCommand(calls *[]string, output string, err error) testingexec.FakeCommandAction {
+	return func(cmd string, args ...string) utilexec.Cmd {
+		*calls = append(*calls, strings.Join(append([]string{cmd}, args...), " "))
+		fakeCmd := &testingexec.FakeCmd{
+			CombinedOutputScript: []testingexec.FakeAction{
+				func() ([]byte, []byte, error) { return []byte(output), nil, err },
+			},
+		}
+		return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+	}
+}
+
+func Test_nodeServer_NodeStageVolume_Fsck(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "sdd")
+	if err := os.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	blkid := "blkid -p -s TYPE -s PTTYPE -o export " + device
+
+	type command struct {
+		output string
+		err    error
+	}
+	tests := []struct {
+		name          string
+		mode          string
+		fsType        string
+		mounted       bool
+		commands      []command
+		wantCalls     []string
+		wantCode      codes.Code
+		wantAbnormal  bool
+		wantCondition string
+		operationID   string
+	}{
+		{
+			name:      "test check disabled",
+			mode:      FsckModeNone,
+			fsType:    "ext4",
+			wantCalls: []string{},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test check clean ext4",
+			mode:      FsckModeCheck,
+			fsType:    "ext4",
+			commands:  []command{{output: "clean"}},
+			wantCalls: []string{blkid, "fsck.ext4 -f -n " + device},
+			wantCode:  codes.OK,
+		},
+		{
+			name:          "test check corrupt ext4",
+			mode:          FsckModeCheck,
+			fsType:        "ext4",
+			commands:      []command{{output: "Inode 12 has illegal blocks", err: testingexec.FakeExitError{Status: 4}}},
+			wantCalls:     []string{blkid, "fsck.ext4 -f -n " + device},
+			wantCode:      codes.OK,
+			wantAbnormal:  true,
+			wantCondition: "FilesystemCorrupt: ext4 on device " + device + " is corrupt: Inode 12 has illegal blocks",
+		},
+		{
+			name:          "test check corrupt xfs",
+			mode:          FsckModeCheck,
+			fsType:        "xfs",
+			commands:      []command{{output: "bad magic number", err: testingexec.FakeExitError{Status: 1}}},
+			wantCalls:     []string{blkid, "xfs_repair -n " + device},
+			wantCode:      codes.OK,
+			wantAbnormal:  true,
+			wantCondition: "FilesystemCorrupt: xfs on device " + device + " is corrupt: bad magic number",
+		},
+		{
+			name:          "test corrupt condition with operation id",
+			mode:          FsckModeCheck,
+			fsType:        "ext4",
+			commands:      []command{{output: "Inode 12 has illegal blocks", err: testingexec.FakeExitError{Status: 4}}},
+			wantCalls:     []string{blkid, "fsck.ext4 -f -n " + device},
+			wantCode:      codes.OK,
+			wantAbnormal:  true,
+			wantCondition: "is corrupt: Inode 12 has illegal blocks (operationID=test-device-pv)",
+			operationID:   "test-device-pv",
+		},
+		{
+			name:   "test repair corrupt xfs",
+			mode:   FsckModeRepair,
+			fsType: "xfs",
+			commands: []command{
+				{output: "bad magic number", err: testingexec.FakeExitError{Status: 1}},
+				{output: "done"},
+			},
+			wantCalls: []string{blkid, "xfs_repair -n " + device, "xfs_repair " + device},
+			wantCode:  codes.OK,
+		},
+		{
+			name:   "test repair failure aborts staging",
+			mode:   FsckModeRepair,
+			fsType: "ext4",
+			commands: []command{
+				{output: "Inode 12 has illegal blocks", err: testingexec.FakeExitError{Status: 4}},
+				{output: "UNEXPECTED INCONSISTENCY", err: testingexec.FakeExitError{Status: 4}},
+			},
+			wantCalls:     []string{blkid, "fsck.ext4 -f -n " + device, "fsck.ext4 -f -y " + device},
+			wantCode:      codes.FailedPrecondition,
+			wantAbnormal:  true,
+			wantCondition: "repair failed: UNEXPECTED INCONSISTENCY",
+		},
+		{
+			name:      "test skip mounted device",
+			mode:      FsckModeCheck,
+			fsType:    "ext4",
+			mounted:   true,
+			wantCalls: []string{blkid},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test skip unsupported filesystem",
+			mode:      FsckModeRepair,
+			fsType:    "btrfs",
+			wantCalls: []string{blkid},
+			wantCode:  codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			fakeExec := &testingexec.FakeExec{
+				CommandScript: []testingexec.FakeCommandAction{fakeCommand(&calls, "DEVNAME="+device+"\nTYPE="+tt.fsType, nil)},
+			}
+			for _, c := range tt.commands {
+				fakeExec.CommandScript = append(fakeExec.CommandScript, fakeCommand(&calls, c.output, c.err))
+			}
+			mountPoints := []mountutils.MountPoint{}
+			if tt.mounted {
+				mountPoints = append(mountPoints, mountutils.MountPoint{Device: device, Path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"})
+			}
+			ns := &nodeServer{
+				k8smounter: &mountutils.SafeFormatAndMount{Interface: mountutils.NewFakeMounter(mountPoints), Exec: fakeExec},
+				inFlight:   NewInFlight(),
+				osTool:     NewOSTool(),
+				options:    &driverOptions{fsckMode: tt.mode, fsckTimeout: DefaultFsckTimeout},
+			}
+			_, err := ns.NodeStageVolume(utils.WithOperationID(context.Background(), tt.operationID), &csi.NodeStageVolumeRequest{
+				VolumeId:          "test-device-pv",
+				StagingTargetPath: filepath.Join(dir, "globalmount"),
+				VolumeContext: map[string]string{
+					pkg.DeviceName:    device,
+					pkg.VolumeTypeKey: string(pkg.VolumeTypeDevice),
+				},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("NodeStageVolume() code = %v, want %v, error: %v", code, tt.wantCode, err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("NodeStageVolume() ran %v, want %v", calls, tt.wantCalls)
+			}
+			stats, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-device-pv", VolumePath: dir})
+			if err != nil {
+				t.Fatalf("NodeGetVolumeStats() error = %v", err)
+			}
+			condition := stats.GetVolumeCondition()
+			if condition.GetAbnormal() != tt.wantAbnormal || !strings.Contains(condition.GetMessage(), tt.wantCondition) {
+				t.Errorf("NodeGetVolumeStats() condition = %+v, want abnormal %v with %q", condition, tt.wantAbnormal, tt.wantCondition)
+			}
+		})
+	}
+}
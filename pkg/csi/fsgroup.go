@@ -0,0 +1,97 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// FSGroupPolicyReadWriteOnceWithFSType lets kubelet change ownership of
+	// volume recursively only if it is ReadWriteOnce with fsType, which is
+	// the default of kubelet
+	FSGroupPolicyReadWriteOnceWithFSType = "ReadWriteOnceWithFSType"
+	// FSGroupPolicyFile applies fsGroup to every volume. The driver advertises
+	// VOLUME_MOUNT_GROUP, so kubelet passes fsGroup to the driver instead of
+	// changing ownership recursively, and only the root of volume is changed
+	FSGroupPolicyFile = "File"
+	// FSGroupPolicyNone never applies fsGroup
+	FSGroupPolicyNone = "None"
+)
+
+// FSGroupPolicies are values of fsGroupPolicy of CSIDriver
+var FSGroupPolicies = []string{FSGroupPolicyReadWriteOnceWithFSType, FSGroupPolicyFile, FSGroupPolicyNone}
+
+func validateFSGroupPolicy(policy string) error {
+	for _, p := range FSGroupPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid fsGroup policy %q, must be one of %v", policy, FSGroupPolicies)
+}
+
+// nodeCapabilities returns capabilities of node service under fsGroup policy
+func nodeCapabilities(policy string) []*csi.NodeServiceCapability {
+	if policy != FSGroupPolicyFile {
+		return NodeCaps
+	}
+	return append(append([]*csi.NodeServiceCapability{}, NodeCaps...), &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{
+				Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+			},
+		},
+	})
+}
+
+// applyVolumeMountGroup applies fsGroup passed by kubelet to filesystem volume
+// published at target path. Files created later inherit group by setgid of
+// root, existing files are left as is, which spares recursive chown of volume
+func (ns *nodeServer) applyVolumeMountGroup(req *csi.NodePublishVolumeRequest) error {
+	mount := req.GetVolumeCapability().GetMount()
+	if ns.options.fsGroupPolicy != FSGroupPolicyFile || mount == nil || mount.GetVolumeMountGroup() == "" || req.GetReadonly() {
+		return nil
+	}
+	gid, err := strconv.Atoi(mount.GetVolumeMountGroup())
+	if err != nil || gid < 0 {
+		return fmt.Errorf("invalid volume mount group %q", mount.GetVolumeMountGroup())
+	}
+	if err := setDirGroup(req.GetTargetPath(), gid); err != nil {
+		return fmt.Errorf("fail to apply group %d to %s: %s", gid, req.GetTargetPath(), err.Error())
+	}
+	log.Infof("applyVolumeMountGroup: group of %s is set to %d", req.GetTargetPath(), gid)
+	return nil
+}
+
+// setDirGroup changes group of dir to gid and makes it group writable and
+// setgid as kubelet does for every directory
+func setDirGroup(dir string, gid int) error {
+	if err := os.Lchown(dir, -1, gid); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dir, info.Mode()|0070|os.ModeSetgid)
+}
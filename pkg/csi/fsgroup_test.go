@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+)
+
+func Test_nodeServer_NodeGetCapabilities_FSGroupPolicy(t *testing.T) {
+	tests := []struct {
+		policy         string
+		wantMountGroup bool
+	}{
+		{policy: FSGroupPolicyReadWriteOnceWithFSType},
+		{policy: FSGroupPolicyFile, wantMountGroup: true},
+		{policy: FSGroupPolicyNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ns := &nodeServer{options: &driverOptions{fsGroupPolicy: tt.policy}}
+			rsp, err := ns.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("NodeGetCapabilities() error = %v", err)
+			}
+			mountGroup := false
+			for _, c := range rsp.Capabilities {
+				if c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+					mountGroup = true
+				}
+			}
+			if mountGroup != tt.wantMountGroup {
+				t.Errorf("NodeGetCapabilities() VOLUME_MOUNT_GROUP = %v, want %v", mountGroup, tt.wantMountGroup)
+			}
+			if len(rsp.Capabilities) < len(NodeCaps) {
+				t.Errorf("NodeGetCapabilities() = %v, missing default capabilities", rsp.Capabilities)
+			}
+		})
+	}
+	if len(nodeCapabilities(FSGroupPolicyFile)) == len(NodeCaps) {
+		t.Errorf("nodeCapabilities() changes NodeCaps")
+	}
+}
+
+func Test_nodeServer_applyVolumeMountGroup(t *testing.T) {
+	gid := strconv.Itoa(os.Getgid())
+	tests := []struct {
+		name       string
+		policy     string
+		mountGroup string
+		readonly   bool
+		block      bool
+		wantSetgid bool
+		wantErr    bool
+	}{
+		{name: "test file policy", policy: FSGroupPolicyFile, mountGroup: gid, wantSetgid: true},
+		{name: "test file policy without group", policy: FSGroupPolicyFile},
+		{name: "test file policy of readonly volume", policy: FSGroupPolicyFile, mountGroup: gid, readonly: true},
+		{name: "test file policy of block volume", policy: FSGroupPolicyFile, mountGroup: gid, block: true},
+		{name: "test invalid group", policy: FSGroupPolicyFile, mountGroup: "staff", wantErr: true},
+		// kubelet never passes group to driver without VOLUME_MOUNT_GROUP
+		{name: "test default policy", policy: FSGroupPolicyReadWriteOnceWithFSType, mountGroup: gid},
+		{name: "test none policy", policy: FSGroupPolicyNone, mountGroup: gid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetPath := t.TempDir()
+			if err := os.Chmod(targetPath, 0750); err != nil {
+				t.Fatal(err)
+			}
+			volCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: tt.mountGroup}}}
+			if tt.block {
+				volCap = &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+			}
+			ns := &nodeServer{options: &driverOptions{fsGroupPolicy: tt.policy}}
+			err := ns.applyVolumeMountGroup(&csi.NodePublishVolumeRequest{TargetPath: targetPath, VolumeCapability: volCap, Readonly: tt.readonly})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyVolumeMountGroup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			info, err := os.Stat(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if setgid := info.Mode()&os.ModeSetgid != 0; setgid != tt.wantSetgid {
+				t.Errorf("applyVolumeMountGroup() mode = %v, want setgid %v", info.Mode(), tt.wantSetgid)
+			}
+			if tt.wantSetgid && info.Mode().Perm() != 0770 {
+				t.Errorf("applyVolumeMountGroup() mode = %v, want group writable", info.Mode())
+			}
+		})
+	}
+}
+
+func Test_validateFSGroupPolicy(t *testing.T) {
+	for _, policy := range FSGroupPolicies {
+		if err := validateFSGroupPolicy(policy); err != nil {
+			t.Errorf("validateFSGroupPolicy(%q) error = %v", policy, err)
+		}
+	}
+	if err := validateFSGroupPolicy("file"); err == nil {
+		t.Errorf("validateFSGroupPolicy(%q) error = nil, want error", "file")
+	}
+}
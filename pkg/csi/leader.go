@@ -0,0 +1,158 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	log "k8s.io/klog/v2"
+)
+
+// sidecar calls controller server, it elects leader by its own lease
+type sidecar string
+
+const (
+	sidecarProvisioner sidecar = "csi-provisioner"
+	sidecarResizer     sidecar = "csi-resizer"
+	sidecarSnapshotter sidecar = "csi-snapshotter"
+
+	// leaseCheckInterval is the interval lease of csi-provisioner is checked
+	// for change of leadership
+	leaseCheckInterval = 5 * time.Second
+)
+
+var leaseNameInvalidChars = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+// sidecarLeaseName returns name of lease which sidecar of driver competes for,
+// named the same way as sidecars do
+func sidecarLeaseName(s sidecar, driverName string) string {
+	name := leaseNameInvalidChars.ReplaceAllString(driverName, "-")
+	switch s {
+	case sidecarResizer:
+		return "external-resizer-" + name
+	case sidecarSnapshotter:
+		return "external-snapshotter-leader-" + name
+	}
+	return name
+}
+
+// leaderGate gates controller operations on holding lease by the sidecar of
+// this pod calling them, so that a replica whose sidecar lost leadership
+// never acts on volumes along with the new leader during leader transition.
+// Nil gate means sidecars run without leader election
+type leaderGate struct {
+	client    kubernetes.Interface
+	namespace string
+	// identity is the holder identity of sidecars of this pod, which is
+	// hostname of pod
+	identity string
+	leases   map[sidecar]string
+	now      func() time.Time
+
+	lock sync.Mutex
+	// leading is true if csi-provisioner held its lease when checked last
+	leading bool
+}
+
+func newLeaderGate(client kubernetes.Interface, namespace, identity, driverName string) *leaderGate {
+	return &leaderGate{
+		client:    client,
+		namespace: namespace,
+		identity:  identity,
+		leases: map[sidecar]string{
+			sidecarProvisioner: sidecarLeaseName(sidecarProvisioner, driverName),
+			sidecarResizer:     sidecarLeaseName(sidecarResizer, driverName),
+			sidecarSnapshotter: sidecarLeaseName(sidecarSnapshotter, driverName),
+		},
+		now: time.Now,
+	}
+}
+
+// Check returns Unavailable error unless sidecar s of this pod holds its
+// lease, sidecar retries the operation after that
+func (g *leaderGate) Check(ctx context.Context, s sidecar) error {
+	if g == nil {
+		return nil
+	}
+	name := g.leases[s]
+	lease, err := g.client.CoordinationV1().Leases(g.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "fail to get lease %s/%s of %s: %s", g.namespace, name, s, err.Error())
+	}
+	if !g.holds(lease) {
+		return status.Errorf(codes.Unavailable, "%s of %s is not the leader of lease %s/%s", s, g.identity, g.namespace, name)
+	}
+	return nil
+}
+
+// holds is true if lease is held by this pod and not expired
+func (g *leaderGate) holds(lease *coordinationv1.Lease) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity != g.identity || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return g.now().Before(spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+// IsLeading is true if csi-provisioner of this pod held its lease when
+// checked last, it is always true without leader election
+func (g *leaderGate) IsLeading() bool {
+	if g == nil {
+		return true
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.leading
+}
+
+// run checks lease of csi-provisioner every leaseCheckInterval,
+// startLeading is called once this pod acquires it
+func (g *leaderGate) run(stopCh <-chan struct{}, startLeading func()) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		g.check(startLeading)
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *leaderGate) check(startLeading func()) {
+	leading := g.Check(context.Background(), sidecarProvisioner) == nil
+	g.lock.Lock()
+	started := leading && !g.leading
+	if g.leading && !leading {
+		log.Infof("%s of %s stopped leading", sidecarProvisioner, g.identity)
+	}
+	g.leading = leading
+	g.lock.Unlock()
+	if started {
+		log.Infof("%s of %s started leading", sidecarProvisioner, g.identity)
+		startLeading()
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/adapter"
+	fakelocalclientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+const testLeaderNamespace = "kube-system"
+
+func newTestLease(name, holder string, renewed time.Time) *coordinationv1.Lease {
+	duration := int32(15)
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testLeaderNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+// setLeaseHolder makes lease name held by holder from now on
+func setLeaseHolder(t *testing.T, client kubernetes.Interface, name, holder string) {
+	lease := newTestLease(name, holder, time.Now())
+	if _, err := client.CoordinationV1().Leases(testLeaderNamespace).Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("fail to update lease %s: %s", name, err.Error())
+	}
+}
+
+func Test_sidecarLeaseName(t *testing.T) {
+	tests := []struct {
+		sidecar sidecar
+		want    string
+	}{
+		{sidecar: sidecarProvisioner, want: "local-csi-aliyun-com"},
+		{sidecar: sidecarResizer, want: "external-resizer-local-csi-aliyun-com"},
+		{sidecar: sidecarSnapshotter, want: "external-snapshotter-leader-local-csi-aliyun-com"},
+	}
+	for _, tt := range tests {
+		if got := sidecarLeaseName(tt.sidecar, DefaultDriverName); got != tt.want {
+			t.Errorf("sidecarLeaseName(%s) = %s, want %s", tt.sidecar, got, tt.want)
+		}
+	}
+}
+
+func Test_leaderGate_Check(t *testing.T) {
+	now := time.Now()
+	lease := sidecarLeaseName(sidecarProvisioner, DefaultDriverName)
+	tests := []struct {
+		name    string
+		lease   *coordinationv1.Lease
+		wantErr bool
+	}{
+		{name: "test held by this pod", lease: newTestLease(lease, "pod-a", now.Add(-time.Second))},
+		{name: "test held by other pod", lease: newTestLease(lease, "pod-b", now.Add(-time.Second)), wantErr: true},
+		{name: "test expired", lease: newTestLease(lease, "pod-a", now.Add(-time.Minute)), wantErr: true},
+		{name: "test lease of other sidecar", lease: newTestLease(sidecarLeaseName(sidecarResizer, DefaultDriverName), "pod-a", now), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := newLeaderGate(fakekubeclientset.NewSimpleClientset(tt.lease), testLeaderNamespace, "pod-a", DefaultDriverName)
+			gate.now = func() time.Time { return now }
+			err := gate.Check(context.Background(), sidecarProvisioner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && status.Code(err) != codes.Unavailable {
+				t.Errorf("Check() error = %v, want code %v", err, codes.Unavailable)
+			}
+		})
+	}
+
+	var gate *leaderGate
+	if err := gate.Check(context.Background(), sidecarProvisioner); err != nil || !gate.IsLeading() {
+		t.Errorf("gate without leader election is not leading: %v", err)
+	}
+}
+
+func Test_leaderGate_check(t *testing.T) {
+	lease := sidecarLeaseName(sidecarProvisioner, DefaultDriverName)
+	client := fakekubeclientset.NewSimpleClientset(newTestLease(lease, "pod-b", time.Now()))
+	gate := newLeaderGate(client, testLeaderNamespace, "pod-a", DefaultDriverName)
+	started := 0
+	startLeading := func() { started++ }
+
+	gate.check(startLeading)
+	if gate.IsLeading() || started != 0 {
+		t.Fatalf("gate is leading while lease is held by other pod")
+	}
+	setLeaseHolder(t, client, lease, "pod-a")
+	gate.check(startLeading)
+	gate.check(startLeading)
+	if !gate.IsLeading() || started != 1 {
+		t.Errorf("IsLeading() = %v and started %d times after acquiring lease, want leading once", gate.IsLeading(), started)
+	}
+	setLeaseHolder(t, client, lease, "pod-b")
+	gate.check(startLeading)
+	if gate.IsLeading() {
+		t.Errorf("gate is still leading after lease is lost")
+	}
+	setLeaseHolder(t, client, lease, "pod-a")
+	gate.check(startLeading)
+	if started != 2 {
+		t.Errorf("started %d times after acquiring lease again, want 2", started)
+	}
+}
+
+// leadershipLosingAdapter loses leadership of this pod while scheduling
+type leadershipLosingAdapter struct {
+	adapter.Adapter
+	lose func()
+}
+
+func (a *leadershipLosingAdapter) ScheduleVolume(volumeType, pvcName, pvcNamespace, vgName, nodeID string) (*pkg.BindingInfo, error) {
+	if a.lose != nil {
+		a.lose()
+	}
+	return a.Adapter.ScheduleVolume(volumeType, pvcName, pvcNamespace, vgName, nodeID)
+}
+
+func Test_controllerServer_CreateVolume_LeadershipLost(t *testing.T) {
+	pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithoutVG().PVCPending})[0]
+	pvc.Name = "pvc-leader"
+	pvc.SetAnnotations(map[string]string{pkg.AnnoSelectedNode: utils.NodeName4})
+	pvcPodSchedulerMap := newPvcPodSchedulerMap()
+	pvcPodSchedulerMap.Add(pvc.Namespace, pvc.Name, "default")
+	lease := sidecarLeaseName(sidecarProvisioner, DefaultDriverName)
+
+	newRequest := func(name string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: int64(150 * 1024 * 1024 * 1024)},
+			Parameters: map[string]string{
+				pkg.PVName:        name,
+				pkg.PVCNameSpace:  pvc.Namespace,
+				pkg.PVCName:       pvc.Name,
+				pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		holder   string
+		lose     bool
+		wantCode codes.Code
+	}{
+		{name: "test leader creates volume", holder: "pod-a", wantCode: codes.OK},
+		{name: "test not leader", holder: "pod-b", wantCode: codes.Unavailable},
+		{name: "test leadership lost while scheduling aborts create", holder: "pod-a", lose: true, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeKubeClient := fakekubeclientset.NewSimpleClientset(pvc, newTestLease(lease, tt.holder, time.Now()))
+			kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fakeKubeClient, 0)
+			_ = kubeInformerFactory.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			_ = kubeInformerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(utils.CreateNode(&utils.TestNodeInfo{
+				NodeName:  utils.NodeName4,
+				IPAddress: "127.0.0.1",
+			}))
+			scheduler := &leadershipLosingAdapter{Adapter: &fakeNodeAdapter{node: utils.NodeName4}}
+			if tt.lose {
+				scheduler.lose = func() { setLeaseHolder(t, fakeKubeClient, lease, "pod-b") }
+			}
+			cs := &controllerServer{
+				inFlight:           NewInFlight(),
+				pvcPodSchedulerMap: pvcPodSchedulerMap,
+				schedulerArchMap:   newSchedulerArchMap([]string{"default"}, []string{}),
+				nodeLister:         kubeInformerFactory.Core().V1().Nodes().Lister(),
+				pvcLister:          kubeInformerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+				pvLister:           kubeInformerFactory.Core().V1().PersistentVolumes().Lister(),
+				adapter:            scheduler,
+				leader:             newLeaderGate(fakeKubeClient, testLeaderNamespace, "pod-a", DefaultDriverName),
+				options: &driverOptions{
+					kubeclient:  fakeKubeClient,
+					localclient: fakelocalclientset.NewSimpleClientset(),
+				},
+			}
+			_, err := cs.CreateVolume(context.Background(), newRequest("pv-leader"))
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("controllerServer.CreateVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -36,6 +36,9 @@ type LVMClient interface {
 	RemoveVG(ctx context.Context, in *CreateVGRequest, opts ...grpc.CallOption) (*RemoveVGReply, error)
 	CleanPath(ctx context.Context, in *CleanPathRequest, opts ...grpc.CallOption) (*CleanPathReply, error)
 	CleanDevice(ctx context.Context, in *CleanDeviceRequest, opts ...grpc.CallOption) (*CleanDeviceReply, error)
+	SetIOThrottling(ctx context.Context, in *SetIOThrottlingRequest, opts ...grpc.CallOption) (*SetIOThrottlingReply, error)
+	ListOperations(ctx context.Context, in *ListOperationsRequest, opts ...grpc.CallOption) (*ListOperationsReply, error)
+	CancelOperation(ctx context.Context, in *CancelOperationRequest, opts ...grpc.CallOption) (*CancelOperationReply, error)
 }
 
 type lVMClient struct {
@@ -172,6 +175,33 @@ func (c *lVMClient) CleanDevice(ctx context.Context, in *CleanDeviceRequest, opt
 	return out, nil
 }
 
+func (c *lVMClient) SetIOThrottling(ctx context.Context, in *SetIOThrottlingRequest, opts ...grpc.CallOption) (*SetIOThrottlingReply, error) {
+	out := new(SetIOThrottlingReply)
+	err := c.cc.Invoke(ctx, "/proto.LVM/SetIOThrottling", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lVMClient) ListOperations(ctx context.Context, in *ListOperationsRequest, opts ...grpc.CallOption) (*ListOperationsReply, error) {
+	out := new(ListOperationsReply)
+	err := c.cc.Invoke(ctx, "/proto.LVM/ListOperations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lVMClient) CancelOperation(ctx context.Context, in *CancelOperationRequest, opts ...grpc.CallOption) (*CancelOperationReply, error) {
+	out := new(CancelOperationReply)
+	err := c.cc.Invoke(ctx, "/proto.LVM/CancelOperation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LVMServer is the server API for LVM service.
 // All implementations must embed UnimplementedLVMServer
 // for forward compatibility
@@ -190,6 +220,9 @@ type LVMServer interface {
 	RemoveVG(context.Context, *CreateVGRequest) (*RemoveVGReply, error)
 	CleanPath(context.Context, *CleanPathRequest) (*CleanPathReply, error)
 	CleanDevice(context.Context, *CleanDeviceRequest) (*CleanDeviceReply, error)
+	SetIOThrottling(context.Context, *SetIOThrottlingRequest) (*SetIOThrottlingReply, error)
+	ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsReply, error)
+	CancelOperation(context.Context, *CancelOperationRequest) (*CancelOperationReply, error)
 	mustEmbedUnimplementedLVMServer()
 }
 
@@ -239,6 +272,15 @@ func (UnimplementedLVMServer) CleanPath(context.Context, *CleanPathRequest) (*Cl
 func (UnimplementedLVMServer) CleanDevice(context.Context, *CleanDeviceRequest) (*CleanDeviceReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CleanDevice not implemented")
 }
+func (UnimplementedLVMServer) SetIOThrottling(context.Context, *SetIOThrottlingRequest) (*SetIOThrottlingReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetIOThrottling not implemented")
+}
+func (UnimplementedLVMServer) ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOperations not implemented")
+}
+func (UnimplementedLVMServer) CancelOperation(context.Context, *CancelOperationRequest) (*CancelOperationReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOperation not implemented")
+}
 func (UnimplementedLVMServer) mustEmbedUnimplementedLVMServer() {}
 
 // UnsafeLVMServer may be embedded to opt out of forward compatibility for this service.
@@ -504,6 +546,60 @@ func _LVM_CleanDevice_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LVM_SetIOThrottling_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetIOThrottlingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LVMServer).SetIOThrottling(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LVM/SetIOThrottling",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LVMServer).SetIOThrottling(ctx, req.(*SetIOThrottlingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LVM_ListOperations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOperationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LVMServer).ListOperations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LVM/ListOperations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LVMServer).ListOperations(ctx, req.(*ListOperationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LVM_CancelOperation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOperationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LVMServer).CancelOperation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LVM/CancelOperation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LVMServer).CancelOperation(ctx, req.(*CancelOperationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // LVM_ServiceDesc is the grpc.ServiceDesc for LVM service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -567,6 +663,18 @@ var LVM_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CleanDevice",
 			Handler:    _LVM_CleanDevice_Handler,
 		},
+		{
+			MethodName: "SetIOThrottling",
+			Handler:    _LVM_SetIOThrottling_Handler,
+		},
+		{
+			MethodName: "ListOperations",
+			Handler:    _LVM_ListOperations_Handler,
+		},
+		{
+			MethodName: "CancelOperation",
+			Handler:    _LVM_CancelOperation_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "lvm.proto",
@@ -147,6 +147,12 @@ func (t VolumeState) toProto() LogicalVolume_Attributes_State {
 	return LogicalVolume_Attributes_State(idx + 1)
 }
 
+// IsSuspended reports whether device-mapper target of volume is suspended,
+// operations on suspended volume hang until it is resumed
+func (t VolumeState) IsSuspended() bool {
+	return t == VolumeStateSuspended || t == VolumeStateInvalidSuspendedSnapshot || t == VolumeStateSuspendedSnapshotMergeFailed
+}
+
 // VolumeOpen is volume open
 type VolumeOpen rune
 
@@ -0,0 +1,164 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// luksFormatType is the blkid type of LUKS header
+	luksFormatType = "crypto_LUKS"
+	// luksMapperPrefix prefixes dm-crypt mapping of volume
+	luksMapperPrefix = "luks-"
+)
+
+var (
+	// replaced in unit test
+	luksMapperDir = "/dev/mapper"
+)
+
+// isEncryptedVolume reports whether volume is encrypted by LUKS
+func isEncryptedVolume(volumeContext map[string]string) bool {
+	return utils.GetParam(volumeContext, localtype.ParamEncrypted) == "true"
+}
+
+// luksMapperName returns name of dm-crypt mapping of volume, which is known
+// from volume id alone when volume is unstaged, long name is shortened with
+// hash suffix to fit in the limit of device-mapper
+func luksMapperName(volumeID string) string {
+	return utils.ShortenName(luksMapperPrefix+volumeID, utils.MaxDMNameLength)
+}
+
+// luksDevicePath returns device of decrypted volume
+func luksDevicePath(volumeID string) string {
+	return filepath.Join(luksMapperDir, luksMapperName(volumeID))
+}
+
+// isLUKSOpened reports whether dm-crypt mapping of volume exists
+func (ns *nodeServer) isLUKSOpened(volumeID string) bool {
+	_, err := ns.osTool.Stat(luksDevicePath(volumeID))
+	return err == nil
+}
+
+// openEncryptedVolume sets up dm-crypt mapping over lv of encrypted volume,
+// lv without any signature is formatted as LUKS first. Filesystem is created
+// on the decrypted device when volume is published
+func (ns *nodeServer) openEncryptedVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) error {
+	volumeContext := req.GetVolumeContext()
+	if !isEncryptedVolume(volumeContext) {
+		return nil
+	}
+	volumeID := req.GetVolumeId()
+	if volumeContext[VolumeTypeTag] != string(localtype.VolumeTypeLVM) || ns.spdkSupported || volumeContext[DirectTag] == "true" {
+		return status.Errorf(codes.InvalidArgument, "NodeStageVolume: encryption of volume %s is only supported for lvm volume", volumeID)
+	}
+	passphrase := req.GetSecrets()[localtype.EncryptionPassphraseKey]
+	if passphrase == "" {
+		return status.Errorf(codes.InvalidArgument, "NodeStageVolume: encrypted volume %s requires key %s in node stage secret, set csi.storage.k8s.io/node-stage-secret-name and csi.storage.k8s.io/node-stage-secret-namespace in storage class", volumeID, localtype.EncryptionPassphraseKey)
+	}
+	if ns.isLUKSOpened(volumeID) {
+		log.Infof("openEncryptedVolume: volume %s is already opened at %s", volumeID, luksDevicePath(volumeID))
+		return nil
+	}
+	vgName, lvName, err := ns.getLVOfVolume(volumeContext, volumeID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: fail to get lv of volume %s: %s", volumeID, err.Error())
+	}
+	device := filepath.Join("/dev", vgName, lvName)
+	format, err := ns.k8smounter.GetDiskFormat(device)
+	if err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: fail to get format of device %s: %s", device, err.Error())
+	}
+	switch format {
+	case luksFormatType:
+	case "":
+		log.Infof("openEncryptedVolume: formatting device %s of volume %s as LUKS", device, volumeID)
+		if err := ns.runCryptsetup(ctx, passphrase, "-q", "luksFormat", "--type", "luks2", "--key-file=-", device); err != nil {
+			return status.Errorf(codes.Internal, "NodeStageVolume: fail to format device %s of volume %s as LUKS: %s", device, volumeID, err.Error())
+		}
+	default:
+		// never wipe data written without encryption
+		return status.Errorf(codes.FailedPrecondition, "NodeStageVolume: device %s of encrypted volume %s is already formatted as %s", device, volumeID, format)
+	}
+	if err := ns.runCryptsetup(ctx, passphrase, "luksOpen", "--key-file=-", device, luksMapperName(volumeID)); err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: fail to open encrypted device %s of volume %s: %s", device, volumeID, err.Error())
+	}
+	log.Infof("openEncryptedVolume: device %s of volume %s is opened at %s", device, volumeID, luksDevicePath(volumeID))
+	return nil
+}
+
+// closeEncryptedVolume tears down dm-crypt mapping of volume if it exists
+func (ns *nodeServer) closeEncryptedVolume(ctx context.Context, volumeID string) error {
+	if !ns.isLUKSOpened(volumeID) {
+		return nil
+	}
+	if err := ns.runCryptsetup(ctx, "", "luksClose", luksMapperName(volumeID)); err != nil {
+		return status.Errorf(codes.Internal, "NodeUnstageVolume: fail to close encrypted volume %s: %s", volumeID, err.Error())
+	}
+	log.Infof("closeEncryptedVolume: encrypted volume %s is closed", volumeID)
+	return nil
+}
+
+// encryptedDevice returns decrypted device of encrypted volume opened when it
+// is staged, device of other volume is returned as is
+func (ns *nodeServer) encryptedDevice(volumeID string, volumeContext map[string]string, device string) (string, error) {
+	if !isEncryptedVolume(volumeContext) {
+		return device, nil
+	}
+	if !ns.isLUKSOpened(volumeID) {
+		return "", fmt.Errorf("encrypted volume %s is not opened, it must be staged with node stage secret first", volumeID)
+	}
+	return luksDevicePath(volumeID), nil
+}
+
+// resizeEncryptedVolume grows dm-crypt mapping of volume to its lv, false if
+// volume is not encrypted
+func (ns *nodeServer) resizeEncryptedVolume(ctx context.Context, volumeID string) (bool, error) {
+	if !ns.isLUKSOpened(volumeID) {
+		return false, nil
+	}
+	// volume key of luks2 is kept in kernel keyring, no passphrase is needed
+	if err := ns.runCryptsetup(ctx, "", "resize", luksMapperName(volumeID)); err != nil {
+		return true, fmt.Errorf("fail to resize encrypted volume %s: %s", volumeID, err.Error())
+	}
+	return true, nil
+}
+
+// runCryptsetup runs cryptsetup with passphrase written to stdin, which keeps
+// it out of command line and disk
+func (ns *nodeServer) runCryptsetup(ctx context.Context, passphrase string, args ...string) error {
+	log.Infof("runCryptsetup: cryptsetup %s", strings.Join(args, " "))
+	cmd := ns.k8smounter.Exec.CommandContext(ctx, "cryptsetup", args...)
+	if passphrase != "" {
+		cmd.SetStdin(strings.NewReader(passphrase))
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// fakeCommandWithStdin returns command action recording command line and
+// stdin, in form of "<command line> < <stdin>", to calls
+func fakeCommandWithStdin(calls *[]string, output string, err error) testingexec.FakeCommandAction {
+	return func(cmd string, args ...string) utilexec.Cmd {
+		call := strings.Join(append([]string{cmd}, args...), " ")
+		fakeCmd := &testingexec.FakeCmd{}
+		fakeCmd.CombinedOutputScript = []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				if fakeCmd.Stdin != nil {
+					stdin, _ := io.ReadAll(fakeCmd.Stdin)
+					call += " < " + string(stdin)
+				}
+				*calls = append(*calls, call)
+				return []byte(output), nil, err
+			},
+		}
+		return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+	}
+}
+
+func newLUKSTestNodeServer(t *testing.T, commands []testingexec.FakeCommandAction) *nodeServer {
+	originDir := luksMapperDir
+	luksMapperDir = t.TempDir()
+	t.Cleanup(func() { luksMapperDir = originDir })
+	return &nodeServer{
+		k8smounter: &mountutils.SafeFormatAndMount{
+			Interface: mountutils.NewFakeMounter(nil),
+			Exec:      &testingexec.FakeExec{CommandScript: commands},
+		},
+		inFlight: NewInFlight(),
+		osTool:   NewOSTool(),
+		options:  &driverOptions{fsckMode: FsckModeNone},
+	}
+}
+
+func Test_nodeServer_NodeStageVolume_Encrypted(t *testing.T) {
+	const (
+		volumeID = "local-2b5a6c6e"
+		device   = "/dev/open-local-pool-0/local-2b5a6c6e"
+	)
+	blkid := "blkid -p -s TYPE -s PTTYPE -o export " + device
+	luksFormat := "cryptsetup -q luksFormat --type luks2 --key-file=- " + device + " < secret"
+	luksOpen := "cryptsetup luksOpen --key-file=- " + device + " luks-" + volumeID + " < secret"
+
+	type command struct {
+		output string
+		err    error
+	}
+	tests := []struct {
+		name      string
+		secrets   map[string]string
+		opened    bool
+		commands  []command
+		wantCalls []string
+		wantCode  codes.Code
+	}{
+		{
+			name:    "test format and open new volume",
+			secrets: map[string]string{localtype.EncryptionPassphraseKey: "secret"},
+			commands: []command{
+				// blkid exits with 2 if no signature is found
+				{err: testingexec.FakeExitError{Status: 2}},
+				{},
+				{},
+			},
+			wantCalls: []string{blkid, luksFormat, luksOpen},
+			wantCode:  codes.OK,
+		},
+		{
+			name:    "test open formatted volume",
+			secrets: map[string]string{localtype.EncryptionPassphraseKey: "secret"},
+			commands: []command{
+				{output: "DEVNAME=" + device + "\nTYPE=crypto_LUKS"},
+				{},
+			},
+			wantCalls: []string{blkid, luksOpen},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test skip opened volume",
+			secrets:   map[string]string{localtype.EncryptionPassphraseKey: "secret"},
+			opened:    true,
+			wantCalls: []string{},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test missing secret",
+			secrets:   map[string]string{},
+			wantCalls: []string{},
+			wantCode:  codes.InvalidArgument,
+		},
+		{
+			name:    "test refuse unencrypted filesystem",
+			secrets: map[string]string{localtype.EncryptionPassphraseKey: "secret"},
+			commands: []command{
+				{output: "DEVNAME=" + device + "\nTYPE=ext4"},
+			},
+			wantCalls: []string{blkid},
+			wantCode:  codes.FailedPrecondition,
+		},
+		{
+			name:    "test wrong passphrase",
+			secrets: map[string]string{localtype.EncryptionPassphraseKey: "secret"},
+			commands: []command{
+				{output: "DEVNAME=" + device + "\nTYPE=crypto_LUKS"},
+				{output: "No key available with this passphrase.", err: testingexec.FakeExitError{Status: 2}},
+			},
+			wantCalls: []string{blkid, luksOpen},
+			wantCode:  codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			actions := []testingexec.FakeCommandAction{}
+			for _, c := range tt.commands {
+				actions = append(actions, fakeCommandWithStdin(&calls, c.output, c.err))
+			}
+			ns := newLUKSTestNodeServer(t, actions)
+			if tt.opened {
+				if err := os.WriteFile(luksDevicePath(volumeID), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/" + volumeID + "/globalmount",
+				VolumeContext: map[string]string{
+					localtype.VolumeTypeKey:  string(localtype.VolumeTypeLVM),
+					localtype.VGName:         "open-local-pool-0",
+					localtype.ParamEncrypted: "true",
+				},
+				Secrets: tt.secrets,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("NodeStageVolume() code = %v, want %v, error: %v", code, tt.wantCode, err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("NodeStageVolume() ran %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_nodeServer_NodeUnstageVolume_Encrypted(t *testing.T) {
+	const volumeID = "local-2b5a6c6e"
+	tests := []struct {
+		name      string
+		opened    bool
+		wantCalls []string
+	}{
+		{
+			name:      "test close opened volume",
+			opened:    true,
+			wantCalls: []string{"cryptsetup luksClose luks-" + volumeID},
+		},
+		{
+			name:      "test skip volume not opened",
+			wantCalls: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			ns := newLUKSTestNodeServer(t, []testingexec.FakeCommandAction{fakeCommandWithStdin(&calls, "", nil)})
+			if tt.opened {
+				if err := os.WriteFile(luksDevicePath(volumeID), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: volumeID}); err != nil {
+				t.Errorf("NodeUnstageVolume() error = %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("NodeUnstageVolume() ran %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_nodeServer_encryptedDevice(t *testing.T) {
+	const volumeID = "local-2b5a6c6e"
+	lvDevice := "/dev/open-local-pool-0/" + volumeID
+	ns := newLUKSTestNodeServer(t, nil)
+	encrypted := map[string]string{localtype.ParamEncrypted: "true"}
+
+	if got, err := ns.encryptedDevice(volumeID, map[string]string{}, lvDevice); err != nil || got != lvDevice {
+		t.Errorf("encryptedDevice() of plain volume = %s, %v, want %s", got, err, lvDevice)
+	}
+	if _, err := ns.encryptedDevice(volumeID, encrypted, lvDevice); err == nil {
+		t.Errorf("encryptedDevice() of volume not staged succeeds, want error")
+	}
+	if err := os.WriteFile(luksDevicePath(volumeID), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(luksMapperDir, "luks-"+volumeID)
+	if got, err := ns.encryptedDevice(volumeID, encrypted, lvDevice); err != nil || got != want {
+		t.Errorf("encryptedDevice() of staged volume = %s, %v, want %s", got, err, want)
+	}
+}
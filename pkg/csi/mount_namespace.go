@@ -0,0 +1,67 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"path/filepath"
+
+	log "k8s.io/klog/v2"
+	mountutils "k8s.io/mount-utils"
+)
+
+// hostPID is the init process of host, visible as agent runs with hostPID
+const hostPID = "1"
+
+// verifyMountNamespace confirms targetPath is a mountpoint in mount namespace
+// of csi plugin and, if they differ, in that of host where kubelet starts the
+// pod. A mount which fails to propagate is invisible to the pod, publishing
+// must not succeed then. Verification is skipped if mountinfo is unreadable
+func (ns *nodeServer) verifyMountNamespace(targetPath string) error {
+	selfInfos, err := ns.osTool.MountInfo("self")
+	if err != nil {
+		log.Warningf("verifyMountNamespace: skip verifying %s, fail to read mountinfo: %s", targetPath, err.Error())
+		return nil
+	}
+	selfNS, _ := ns.osTool.MountNamespace("self")
+	if !isMountPointInMountInfo(selfInfos, targetPath) {
+		return fmt.Errorf("target path %s is not a mountpoint in mount namespace %s of csi plugin, mount is lost after publishing", targetPath, selfNS)
+	}
+	hostNS, err := ns.osTool.MountNamespace(hostPID)
+	if err != nil || selfNS == "" || hostNS == selfNS {
+		return nil
+	}
+	hostInfos, err := ns.osTool.MountInfo(hostPID)
+	if err != nil {
+		log.Warningf("verifyMountNamespace: skip verifying %s in host mount namespace %s: %s", targetPath, hostNS, err.Error())
+		return nil
+	}
+	if !isMountPointInMountInfo(hostInfos, targetPath) {
+		return fmt.Errorf("target path %s is mounted in mount namespace %s of csi plugin but not in host mount namespace %s, check mountPropagation of kubelet dir is Bidirectional", targetPath, selfNS, hostNS)
+	}
+	return nil
+}
+
+func isMountPointInMountInfo(infos []mountutils.MountInfo, path string) bool {
+	path = filepath.Clean(path)
+	for _, info := range infos {
+		if filepath.Clean(info.MountPoint) == path {
+			return true
+		}
+	}
+	return false
+}
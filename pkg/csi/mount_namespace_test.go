@@ -0,0 +1,143 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mountutils "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func Test_nodeServer_verifyMountNamespace(t *testing.T) {
+	targetPath := "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"
+	mounted := []mountutils.MountInfo{{MountPoint: "/"}, {MountPoint: targetPath}}
+	unmounted := []mountutils.MountInfo{{MountPoint: "/"}}
+	tests := []struct {
+		name            string
+		mountInfo       map[string][]mountutils.MountInfo
+		mountNamespaces map[string]string
+		wantErr         bool
+	}{
+		{
+			name:            "test mounted in shared namespace",
+			mountInfo:       map[string][]mountutils.MountInfo{"self": mounted, hostPID: mounted},
+			mountNamespaces: map[string]string{"self": "mnt:[1]", hostPID: "mnt:[1]"},
+		},
+		{
+			name:            "test mounted in both namespaces",
+			mountInfo:       map[string][]mountutils.MountInfo{"self": mounted, hostPID: mounted},
+			mountNamespaces: map[string]string{"self": "mnt:[2]", hostPID: "mnt:[1]"},
+		},
+		{
+			name:            "test missing mountinfo entry",
+			mountInfo:       map[string][]mountutils.MountInfo{"self": unmounted, hostPID: unmounted},
+			mountNamespaces: map[string]string{"self": "mnt:[1]", hostPID: "mnt:[1]"},
+			wantErr:         true,
+		},
+		{
+			name:            "test mount not propagated to host",
+			mountInfo:       map[string][]mountutils.MountInfo{"self": mounted, hostPID: unmounted},
+			mountNamespaces: map[string]string{"self": "mnt:[2]", hostPID: "mnt:[1]"},
+			wantErr:         true,
+		},
+		{
+			name:            "test host namespace invisible",
+			mountInfo:       map[string][]mountutils.MountInfo{"self": mounted},
+			mountNamespaces: map[string]string{"self": "mnt:[2]"},
+		},
+		{
+			name: "test mountinfo unreadable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &nodeServer{osTool: &fakeOSTool{mountInfo: tt.mountInfo, mountNamespaces: tt.mountNamespaces}}
+			if err := ns.verifyMountNamespace(targetPath + "/"); (err != nil) != tt.wantErr {
+				t.Errorf("verifyMountNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nodeServer_NodePublishVolume_MountNamespace(t *testing.T) {
+	findmntAction := func() ([]byte, []byte, error) {
+		return []byte("TYPE=ext4"), []byte{}, nil
+	}
+	blkidAction := func() ([]byte, []byte, error) {
+		return []byte("DEVICE=/dev/sdd\nTYPE=ext4"), []byte{}, nil
+	}
+	targetPath := t.TempDir()
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-device-pv",
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			pkg.DeviceName:      "/dev/sdd",
+			pkg.VolumeTypeKey:   string(pkg.VolumeTypeDevice),
+			pkg.PVName:          "test-device-pv",
+			pkg.VolumeFSTypeKey: "ext4",
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+	tests := []struct {
+		name      string
+		mountInfo []mountutils.MountInfo
+		wantCode  codes.Code
+	}{
+		{
+			name:      "test target path in mountinfo",
+			mountInfo: []mountutils.MountInfo{{MountPoint: "/"}, {MountPoint: targetPath}},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test missing mountinfo entry fails publishing",
+			mountInfo: []mountutils.MountInfo{{MountPoint: "/"}},
+			wantCode:  codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &nodeServer{
+				k8smounter:           NewFakeSafeMounter([]testingexec.FakeAction{findmntAction, blkidAction}...),
+				ephemeralVolumeStore: NewMockVolumeStore(""),
+				inFlight:             NewInFlight(),
+				formatInFlight:       NewInFlight(),
+				osTool: &fakeOSTool{
+					mountInfo:       map[string][]mountutils.MountInfo{"self": tt.mountInfo},
+					mountNamespaces: map[string]string{"self": "mnt:[1]", hostPID: "mnt:[1]"},
+				},
+				options: &driverOptions{},
+			}
+			_, err := ns.NodePublishVolume(context.Background(), req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("NodePublishVolume() code = %v, want %v, error: %v", code, tt.wantCode, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "is not a mountpoint") {
+				t.Errorf("NodePublishVolume() error = %v, want missing mountpoint", err)
+			}
+		})
+	}
+}
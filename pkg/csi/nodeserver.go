@@ -19,21 +19,23 @@ package csi
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/alibaba/open-local/pkg"
 	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/server"
 	"github.com/alibaba/open-local/pkg/utils"
 	spdk "github.com/alibaba/open-local/pkg/utils/spdk"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/google/uuid"
 	volume "github.com/kata-containers/kata-containers/src/runtime/pkg/direct-volume"
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
@@ -49,9 +51,15 @@ type nodeServer struct {
 	k8smounter           *mountutils.SafeFormatAndMount
 	ephemeralVolumeStore Store
 	inFlight             *InFlight
+	formatInFlight       *InFlight
 	spdkSupported        bool
 	spdkclient           *spdk.SpdkClient
 	osTool               OSTool
+	// fsConditions records message of corrupt filesystem by volume id
+	fsConditions sync.Map
+	// expansionConditions records message of snapshot retained by failed
+	// resize of filesystem by volume id
+	expansionConditions sync.Map
 
 	options *driverOptions
 }
@@ -61,6 +69,10 @@ func newNodeServer(options *driverOptions) *nodeServer {
 	if err != nil {
 		log.Fatalf("fail to initialize ephemeral volume store: %s", err.Error())
 	}
+	// mounts of migrated quota directories are lost once node reboots
+	if err := server.RestoreQuotaMounts(server.DefaultQuotaMountsFile); err != nil {
+		log.Errorf("%s", err.Error())
+	}
 
 	ns := &nodeServer{
 		k8smounter: &mountutils.SafeFormatAndMount{
@@ -69,6 +81,7 @@ func newNodeServer(options *driverOptions) *nodeServer {
 		},
 		ephemeralVolumeStore: store,
 		inFlight:             NewInFlight(),
+		formatInFlight:       NewInFlight(),
 		spdkSupported:        false,
 		osTool:               NewOSTool(),
 		options:              options,
@@ -83,7 +96,7 @@ func newNodeServer(options *driverOptions) *nodeServer {
 // staging_target_path: /var/lib/kubelet/plugins/kubernetes.io/csi/pv/yoda-70597cb6-c08b-4bbb-8d41-c4afcfa91866/globalmount
 // target_path: /var/lib/kubelet/pods/2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f/volumes/kubernetes.io~csi/yoda-70597cb6-c08b-4bbb-8d41-c4afcfa91866/mount
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	log.V(4).Infof("NodePublishVolume: called with args %+v", *req)
+	log.V(4).Infof("NodePublishVolume: called with args %+v", stripSecrets(req))
 	// Step 1: check
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
@@ -158,7 +171,7 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		case *csi.VolumeCapability_Mount:
 			err := ns.mountLvmFS(ctx, req)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "NodePublishVolume(mountLvmFS): fail to mount lvm volume %s with path %s: %s", volumeID, targetPath, err.Error())
+				return nil, status.Errorf(formatErrorCode(err), "NodePublishVolume(mountLvmFS): fail to mount lvm volume %s with path %s: %s", volumeID, targetPath, err.Error())
 			}
 		}
 		if err := ns.setIOThrottling(ctx, req); err != nil {
@@ -179,12 +192,21 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		case *csi.VolumeCapability_Mount:
 			err := ns.mountDeviceVolumeFS(ctx, req)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "NodePublishVolume(FileSystem): fail to mount device volume %s with path %s: %s", volumeID, targetPath, err.Error())
+				return nil, status.Errorf(formatErrorCode(err), "NodePublishVolume(FileSystem): fail to mount device volume %s with path %s: %s", volumeID, targetPath, err.Error())
 			}
 		}
 	default:
 		return nil, status.Errorf(codes.Internal, "NodePublishVolume: unsupported volume %s with type %s", volumeID, volumeType)
 	}
+	if err := ns.verifyMountNamespace(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodePublishVolume: volume %s: %s", volumeID, err.Error())
+	}
+	if err := ns.applyVolumeMountGroup(req); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodePublishVolume: volume %s: %s", volumeID, err.Error())
+	}
+	if err := ns.runPostProvisionHooks(ctx, req, volumeType); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodePublishVolume: %s", err.Error())
+	}
 
 	log.Infof("NodePublishVolume: mount local volume %s to %s successfully", volumeID, targetPath)
 	return &csi.NodePublishVolumeResponse{}, nil
@@ -216,6 +238,7 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		}
 	}
 
+	ns.clearIOThrottling(targetPath)
 	if err := ns.osTool.CleanupMountPoint(targetPath, ns.k8smounter, true /*extensiveMountPointCheck*/); err != nil {
 		return nil, status.Errorf(codes.Internal, "NodeUnpublishVolume: fail to umount volume %s for path %s: %s", volumeID, targetPath, err.Error())
 	}
@@ -245,19 +268,28 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 }
 
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	log.V(4).Infof("NodeStageVolume: called with args %+v", *req)
+	log.V(4).Infof("NodeStageVolume: called with args %+v", stripSecrets(req))
+	if err := ns.openEncryptedVolume(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := ns.checkFilesystemOnStage(ctx, req); err != nil {
+		return nil, err
+	}
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	log.V(4).Infof("NodeUnstageVolume: called with args %+v", *req)
+	if err := ns.closeEncryptedVolume(ctx, req.GetVolumeId()); err != nil {
+		return nil, err
+	}
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 // called with args {VolumeId:yoda-7825af6f-ea0a-4047-8704-7576dfe8d201 VolumePath:/var/lib/kubelet/pods/2ba91d3a-1b97-4d72-9dd9-be98aebbfe62/volumes/kubernetes.io~csi/yoda-7825af6f-ea0a-4047-8704-7576dfe8d201/mount CapacityRange:required_bytes:21474836480  StagingTargetPath:/var/lib/kubelet/plugins/kubernetes.io/csi/pv/yoda-7825af6f-ea0a-4047-8704-7576dfe8d201/globalmount VolumeCapability:mount:<fs_type:"ext4" > access_mode:<mode:SINGLE_NODE_WRITER >  XXX_NoUnkeyedLiteral:{} XXX_unrecognized:[] XXX_sizecache:0}
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (
 	*csi.NodeExpandVolumeResponse, error) {
-	log.V(4).Infof("NodeExpandVolume: called with args %+v", *req)
+	log.V(4).Infof("NodeExpandVolume: called with args %+v", stripSecrets(req))
 	volumeID := req.VolumeId
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume: Volume ID not provided")
@@ -269,6 +301,10 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	expectSize := req.CapacityRange.RequiredBytes
 	if !ns.spdkSupported {
 		if err := ns.resizeVolume(ctx, volumeID, targetPath); err != nil {
+			// keep the code of refused resize, such as no space for snapshot
+			if s, ok := status.FromError(err); ok && s.Code() != codes.Internal {
+				return nil, err
+			}
 			return nil, status.Errorf(codes.Internal, "NodeExpandVolume: Resize local volume %s with error: %s", volumeID, err.Error())
 		}
 	} else {
@@ -283,7 +319,7 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	log.V(4).Infof("NodeGetCapabilities: called with args %+v", *req)
-	return &csi.NodeGetCapabilitiesResponse{Capabilities: NodeCaps}, nil
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: nodeCapabilities(ns.options.fsGroupPolicy)}, nil
 }
 
 func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
@@ -307,7 +343,12 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Errorf(codes.InvalidArgument, "NodeGetVolumeStats target local path %v is empty", targetPath)
 	}
 
-	return utils.GetMetrics(targetPath)
+	resp, err := utils.GetMetrics(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	resp.VolumeCondition = ns.filesystemCondition(req.GetVolumeId())
+	return resp, nil
 }
 
 func (ns *nodeServer) addDirectVolume(volumePath, device, fsType string) error {
@@ -418,10 +459,7 @@ func (ns *nodeServer) publishDirectVolume(ctx context.Context, req *csi.NodePubl
 	}
 
 	if mount {
-		fsType := volCap.GetMount().FsType
-		if len(fsType) == 0 {
-			fsType = DefaultFs
-		}
+		fsType := getFsType(req)
 
 		if err := ns.addDirectVolume(req.GetTargetPath(), device, fsType); err != nil {
 			log.Error("addDirectVolume failed: ", err.Error())
@@ -516,10 +554,7 @@ func (ns *nodeServer) publishSpdkVolume(ctx context.Context, req *csi.NodePublis
 
 	fsType := DefaultFs
 	if mount {
-		fsType = volCap.GetMount().FsType
-		if len(fsType) == 0 {
-			fsType = DefaultFs
-		}
+		fsType = getFsType(req)
 
 		if err := ns.addDirectVolume(targetPath, device, fsType); err != nil {
 			return status.Errorf(codes.Internal, "addDirectVolume failed: %s", err.Error())
@@ -573,17 +608,36 @@ func (ns *nodeServer) resizeVolume(ctx context.Context, volumeID, targetPath str
 			return status.Errorf(codes.Internal, "resizeVolume: Volume %s with vgname empty", pv.Name)
 		}
 
-		devicePath := filepath.Join("/dev", vgName, volumeID)
+		lvName := utils.GetLVNameFromCsiPV(pv)
+		devicePath := filepath.Join("/dev", vgName, lvName)
+		// filesystem of encrypted volume is on the decrypted device
+		if encrypted, err := ns.resizeEncryptedVolume(ctx, volumeID); err != nil {
+			return status.Errorf(codes.Internal, "NodeExpandVolume: %s", err.Error())
+		} else if encrypted {
+			devicePath = luksDevicePath(volumeID)
+		}
 
 		log.Infof("NodeExpandVolume:: volumeId: %s, devicePath: %s", volumeID, devicePath)
+		// block volume has no filesystem to resize, lv is expanded by controller
+		if isBlockVolume(pv) {
+			log.Infof("NodeExpandVolume:: volume %s is block mode, skip resizefs", volumeID)
+			return nil
+		}
 
+		snapshotName, err := ns.takeExpansionSnapshot(ctx, pv, volumeID, vgName, lvName)
+		if err != nil {
+			return err
+		}
 		ok, err := ns.osTool.ResizeFS(devicePath, targetPath)
 		if err != nil {
+			ns.finishExpansionSnapshot(volumeID, vgName, snapshotName, err)
 			return fmt.Errorf("NodeExpandVolume: Lvm Resize Error, volumeId: %s, devicePath: %s, volumePath: %s, err: %s", volumeID, devicePath, targetPath, err.Error())
 		}
 		if !ok {
+			ns.finishExpansionSnapshot(volumeID, vgName, snapshotName, fmt.Errorf("resizefs of %s is not done", devicePath))
 			return status.Errorf(codes.Internal, "NodeExpandVolume:: Lvm Resize failed, volumeId: %s, devicePath: %s, volumePath: %s", volumeID, devicePath, targetPath)
 		}
+		ns.finishExpansionSnapshot(volumeID, vgName, snapshotName, nil)
 		log.Infof("NodeExpandVolume:: lvm resizefs successful volumeId: %s, devicePath: %s, volumePath: %s", volumeID, devicePath, targetPath)
 		return nil
 	}
@@ -591,41 +645,37 @@ func (ns *nodeServer) resizeVolume(ctx context.Context, volumeID, targetPath str
 }
 
 func (ns *nodeServer) setIOThrottling(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
-	volCap := req.GetVolumeCapability()
 	targetPath := req.GetTargetPath()
 	volumeID := req.VolumeId
-	iops, iopsExist := req.VolumeContext[localtype.VolumeIOPS]
-	bps, bpsExist := req.VolumeContext[localtype.VolumeBPS]
-	if iopsExist || bpsExist {
+	iops, bps := ns.ioThrottlingOfVolume(ctx, volumeID, req.VolumeContext)
+	if iops != "" || bps != "" {
+		// volume is left unlimited rather than failed if io controller is not available
+		version := utils.DetectCgroupVersion(ns.options.sysPath)
+		if version == utils.CgroupNone {
+			log.Warningf("neither cgroup v2 io controller nor cgroup v1 blkio controller is found in %s, skip io throttling of volume %s", ns.options.sysPath, volumeID)
+			return nil
+		}
 		// get pod
 		var pod v1.Pod
-		var podUID string
-		switch volCap.GetAccessType().(type) {
-		case *csi.VolumeCapability_Block:
-			// /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/yoda-c018ff81-d346-452e-b7b8-a45f1d1c230e/76cf946e-d074-4455-a272-4d3a81264fab
-			podUID = strings.Split(targetPath, "/")[10]
-		case *csi.VolumeCapability_Mount:
-			// /var/lib/kubelet/pods/2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f/volumes/kubernetes.io~csi/yoda-70597cb6-c08b-4bbb-8d41-c4afcfa91866/mount
-			podUID = strings.Split(targetPath, "/")[5]
-		}
+		podUID := utils.PodUIDFromTargetPath(targetPath)
 		log.Infof("pod(volume id %s) uuid is %s", volumeID, podUID)
 		namespace := req.VolumeContext[localtype.PVCNameSpace]
 		// set ResourceVersion to 0
 		// https://arthurchiao.art/blog/k8s-reliability-list-data-zh/
 		pods, err := ns.options.kubeclient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+		if err != nil {
+			return status.Errorf(codes.Internal, "NodePublishVolume: failed to get pod(uuid: %s): %s", podUID, err.Error())
+		}
 		for _, podItem := range pods.Items {
 			if podItem.UID == types.UID(podUID) {
 				pod = podItem
 			}
 		}
-		if err != nil {
-			return status.Errorf(codes.Internal, "NodePublishVolume: failed to get pod(uuid: %s): %s", podUID, err.Error())
-		}
-		// pod qosClass and blkioPath
+		// pod qosClass and cgroup path
 		qosClass := pod.Status.QOSClass
-		blkioPath := fmt.Sprintf("%s/fs/cgroup/blkio/%s%s%s", ns.options.sysPath, utils.CgroupPathFormatter.ParentDir, utils.CgroupPathFormatter.QOSDirFn(qosClass), utils.CgroupPathFormatter.PodDirFn(qosClass, podUID))
+		cgroupPath := utils.PodCgroupDir(ns.options.sysPath, version, qosClass, podUID)
 		log.Infof("pod(volume id %s) qosClass: %s", volumeID, qosClass)
-		log.Infof("pod(volume id %s) blkio path: %s", volumeID, blkioPath)
+		log.Infof("pod(volume id %s) cgroup %s path: %s", volumeID, version, cgroupPath)
 		// get lv lvpath
 		// todo: not support device kind
 		lvpath, _, err := ns.createLV(ctx, req)
@@ -634,39 +684,50 @@ func (ns *nodeServer) setIOThrottling(ctx context.Context, req *csi.NodePublishV
 		}
 		stat := syscall.Stat_t{}
 		_ = syscall.Stat(lvpath, &stat)
-		maj := uint64(stat.Rdev / 256)
-		min := uint64(stat.Rdev % 256)
+		maj := uint64(unix.Major(uint64(stat.Rdev)))
+		min := uint64(unix.Minor(uint64(stat.Rdev)))
 		log.Infof("volume %s maj:min: %d:%d", volumeID, maj, min)
 		log.Infof("volume %s path: %s", volumeID, lvpath)
-		if iopsExist {
-			log.Infof("volume %s iops: %s", volumeID, iops)
-			cmdstr := fmt.Sprintf("echo %s > %s", fmt.Sprintf("%d:%d %s", maj, min, iops), fmt.Sprintf("%s/%s", blkioPath, localtype.IOPSReadFile))
-			_, err := exec.Command("sh", "-c", cmdstr).CombinedOutput()
-			if err != nil {
-				return status.Errorf(codes.Internal, "failed to write blkio file %s: %s", fmt.Sprintf("%s%s", blkioPath, localtype.IOPSReadFile), err.Error())
-			}
-			cmdstr = fmt.Sprintf("echo %s > %s", fmt.Sprintf("%d:%d %s", maj, min, iops), fmt.Sprintf("%s%s", blkioPath, localtype.IOPSWriteFile))
-			_, err = exec.Command("sh", "-c", cmdstr).CombinedOutput()
-			if err != nil {
-				return status.Errorf(codes.Internal, "failed to write blkio file %s: %s", fmt.Sprintf("%s%s", blkioPath, localtype.IOPSWriteFile), err.Error())
-			}
+		log.Infof("volume %s iops: %s, bps: %s", volumeID, iops, bps)
+		written, err := utils.WriteIOThrottleSettings(cgroupPath, utils.IOThrottleSettings(version, maj, min, iops, bps))
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to set io throttling of volume %s: %s", volumeID, err.Error())
 		}
-		if bpsExist {
-			log.Infof("volume %s bps: %s", volumeID, bps)
-			cmdstr := fmt.Sprintf("echo %s > %s", fmt.Sprintf("%d:%d %s", maj, min, bps), fmt.Sprintf("%s%s", blkioPath, localtype.BPSReadFile))
-			_, err := exec.Command("sh", "-c", cmdstr).CombinedOutput()
-			if err != nil {
-				return status.Errorf(codes.Internal, "failed to write blkio file %s: %s", fmt.Sprintf("%s%s", blkioPath, localtype.BPSReadFile), err.Error())
-			}
-			cmdstr = fmt.Sprintf("echo %s > %s", fmt.Sprintf("%d:%d %s", maj, min, bps), fmt.Sprintf("%s%s", blkioPath, localtype.BPSWriteFile))
-			_, err = exec.Command("sh", "-c", cmdstr).CombinedOutput()
-			if err != nil {
-				return status.Errorf(codes.Internal, "failed to write blkio file %s: %s", fmt.Sprintf("%s%s", blkioPath, localtype.BPSWriteFile), err.Error())
-			}
+		if !written {
+			log.Warningf("io throttling files are not found in %s, skip io throttling of volume %s", cgroupPath, volumeID)
 		}
 	}
 	return nil
 }
+
+// ioThrottlingOfVolume returns iops and bps of volume, volume context is
+// fixed once pv is created while limits modified later are recorded in pv
+func (ns *nodeServer) ioThrottlingOfVolume(ctx context.Context, volumeID string, volumeContext map[string]string) (string, string) {
+	iops := volumeContext[localtype.VolumeIOPS]
+	bps := volumeContext[localtype.VolumeBPS]
+	pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+	if err != nil {
+		log.Warningf("failed to get pv %s, io throttling in volume context is used: %s", volumeID, err.Error())
+		return iops, bps
+	}
+	return utils.GetIOThrottlingFromCsiPV(pv)
+}
+
+// clearIOThrottling removes io throttling set by setIOThrottling, it must be
+// called before target path is unmounted
+func (ns *nodeServer) clearIOThrottling(targetPath string) {
+	stat := syscall.Stat_t{}
+	if err := syscall.Stat(targetPath, &stat); err != nil {
+		return
+	}
+	// block volume is the device itself, otherwise it is the mounted filesystem
+	dev := uint64(stat.Dev)
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFBLK {
+		dev = uint64(stat.Rdev)
+	}
+	utils.ClearPodIOThrottling(ns.options.sysPath, uint64(unix.Major(dev)), uint64(unix.Minor(dev)), utils.PodUIDFromTargetPath(targetPath))
+}
+
 func (ns *nodeServer) checkSPDKSupport() {
 	for {
 		nls, err := ns.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(context.Background(), ns.options.nodeID, metav1.GetOptions{})
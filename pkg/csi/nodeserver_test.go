@@ -18,20 +18,30 @@ package csi
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/server"
 	fakelocalclientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	"github.com/alibaba/open-local/pkg/utils"
 	spdk "github.com/alibaba/open-local/pkg/utils/spdk"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	fakesnapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	kubeinformers "k8s.io/client-go/informers"
 	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	mountutils "k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
@@ -221,6 +231,31 @@ func Test_nodeServer_NodePublishVolume(t *testing.T) {
 			want:    &csi.NodePublishVolumeResponse{},
 			wantErr: false,
 		},
+		{
+			name:   "mount fs lvm with attributes from volume context successfully",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.NodePublishVolumeRequest{
+					VolumeId:   pvLVMName,
+					TargetPath: targetpath,
+					VolumeContext: map[string]string{
+						pkg.ParamVGName:     "newVG",
+						pkg.VolumeTypeKey:   string(pkg.VolumeTypeLVM),
+						pkg.VolumeFSTypeKey: "ext4",
+						// pv is not needed since vg is given
+						pkg.PVName: "pv-not-exist",
+					},
+					VolumeCapability: &csi.VolumeCapability{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					},
+				},
+			},
+			scripts: []testingexec.FakeAction{findmntAction, blkidAction},
+			want:    &csi.NodePublishVolumeResponse{},
+			wantErr: false,
+		},
 		{
 			name:   "mount block lvm successfully",
 			fields: testfields,
@@ -412,6 +447,31 @@ func Test_nodeServer_NodePublishVolume(t *testing.T) {
 			want:    &csi.NodePublishVolumeResponse{},
 			wantErr: false,
 		},
+		{
+			name:   "mount device with attributes from volume context successfully",
+			fields: testfields,
+			args: args{
+				ctx: context.Background(),
+				req: &csi.NodePublishVolumeRequest{
+					VolumeId:   pvDeviceName,
+					TargetPath: targetpath,
+					VolumeContext: map[string]string{
+						string(pkg.DeviceName): "/dev/sdd",
+						pkg.VolumeTypeKey:      string(pkg.VolumeTypeDevice),
+						pkg.VolumeFSTypeKey:    "ext4",
+						// pv is not needed since device is given
+						pkg.PVName: "pv-not-exist",
+					},
+					VolumeCapability: &csi.VolumeCapability{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					},
+				},
+			},
+			scripts: []testingexec.FakeAction{findmntAction, blkidAction},
+			want:    &csi.NodePublishVolumeResponse{},
+			wantErr: false,
+		},
 		{
 			name:   "mount block device successfully",
 			fields: testfields,
@@ -448,6 +508,7 @@ func Test_nodeServer_NodePublishVolume(t *testing.T) {
 				k8smounter:           NewFakeSafeMounter(tt.scripts...),
 				ephemeralVolumeStore: tt.fields.ephemeralVolumeStore,
 				inFlight:             tt.fields.inFlight,
+				formatInFlight:       NewInFlight(),
 				spdkSupported:        tt.fields.spdkSupported,
 				spdkclient:           tt.fields.spdkclient,
 				osTool:               tt.fields.osTool,
@@ -465,6 +526,207 @@ func Test_nodeServer_NodePublishVolume(t *testing.T) {
 	}
 }
 
+func Test_getFsType(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		volCap        *csi.VolumeCapability
+		want          string
+	}{
+		{
+			name:          "test fsType in volume context",
+			volumeContext: map[string]string{pkg.VolumeFSTypeKey: "xfs"},
+			volCap: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			},
+			want: "xfs",
+		},
+		{
+			name:          "test fsType in volume capability",
+			volumeContext: map[string]string{},
+			volCap: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+			},
+			want: "xfs",
+		},
+		{
+			name:          "test default fsType",
+			volumeContext: map[string]string{},
+			volCap: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			},
+			want: DefaultFs,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &csi.NodePublishVolumeRequest{VolumeContext: tt.volumeContext, VolumeCapability: tt.volCap}
+			if got := getFsType(req); got != tt.want {
+				t.Errorf("getFsType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nodeServer_formatAndMount(t *testing.T) {
+	device := "/dev/newVG/test-pv"
+	targetPath := "/var/lib/kubelet/pods/test/volumes/kubernetes.io~csi/test-pv/mount"
+
+	tests := []struct {
+		name          string
+		formatTimeout int
+		// deadline of the first request, 0 means no deadline
+		deadline    time.Duration
+		wantCode    codes.Code
+		wantUnmount bool
+	}{
+		{
+			name:        "test second format rejected while formatting",
+			wantCode:    codes.OK,
+			wantUnmount: false,
+		},
+		{
+			name:          "test format timeout",
+			formatTimeout: 1,
+			wantCode:      codes.DeadlineExceeded,
+			wantUnmount:   true,
+		},
+		{
+			name:        "test request canceled while formatting",
+			deadline:    100 * time.Millisecond,
+			wantCode:    codes.DeadlineExceeded,
+			wantUnmount: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			// blkid is the first command of FormatAndMount, block it as a slow mkfs
+			blkidAction := func() ([]byte, []byte, error) {
+				close(started)
+				<-release
+				return []byte("DEVICE=" + device + "\nTYPE=ext4"), []byte{}, nil
+			}
+			fsckAction := func() ([]byte, []byte, error) {
+				return []byte{}, []byte{}, nil
+			}
+			ns := &nodeServer{
+				k8smounter:     NewFakeSafeMounter(blkidAction, fsckAction),
+				formatInFlight: NewInFlight(),
+				options:        &driverOptions{formatTimeout: tt.formatTimeout},
+			}
+
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			result := make(chan error, 1)
+			go func() {
+				result <- ns.formatAndMount(ctx, device, targetPath, "ext4", nil, nil)
+			}()
+			<-started
+
+			// retried request never formats the device concurrently
+			if err := ns.formatAndMount(context.Background(), device, targetPath, "ext4", nil, nil); status.Code(err) != codes.Aborted {
+				t.Errorf("formatAndMount() of device being formatted error = %v, want code %v", err, codes.Aborted)
+			}
+
+			if tt.wantCode != codes.OK {
+				if err := <-result; status.Code(err) != tt.wantCode {
+					t.Errorf("formatAndMount() error = %v, want code %v", err, tt.wantCode)
+				}
+				// device is still guarded after the request fails
+				if err := ns.formatAndMount(context.Background(), device, targetPath, "ext4", nil, nil); status.Code(err) != codes.Aborted {
+					t.Errorf("formatAndMount() after timeout error = %v, want code %v", err, codes.Aborted)
+				}
+				close(release)
+			} else {
+				close(release)
+				if err := <-result; err != nil {
+					t.Errorf("formatAndMount() error = %v", err)
+				}
+			}
+
+			// wait for format exiting in background
+			for i := 0; !ns.formatInFlight.Insert(device); i++ {
+				if i >= 500 {
+					t.Fatalf("device %s is still guarded after format exits", device)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			ns.formatInFlight.Delete(device)
+			unmounted := false
+			for _, action := range ns.k8smounter.Interface.(*FakeSafeMounter).GetLog() {
+				if action.Action == mountutils.FakeActionUnmount && action.Target == targetPath {
+					unmounted = true
+				}
+			}
+			if unmounted != tt.wantUnmount {
+				t.Errorf("formatAndMount() unmounted = %v, want %v", unmounted, tt.wantUnmount)
+			}
+		})
+	}
+}
+
+func Test_customizedMkfsArgs(t *testing.T) {
+	striped := utils.IOAlignment{MinimumIOSize: 65536, OptimalIOSize: 262144}
+	tests := []struct {
+		name            string
+		fsType          string
+		alignment       utils.IOAlignment
+		reservedPercent string
+		want            []string
+	}{
+		{
+			name:   "test defaults of FormatAndMount",
+			fsType: "ext4",
+			want:   nil,
+		},
+		{
+			name:            "test ext4 reserved blocks",
+			fsType:          "ext4",
+			reservedPercent: "1",
+			want:            []string{"-F", "-m1"},
+		},
+		{
+			name:            "test ext4 reserved blocks with alignment",
+			fsType:          "ext4",
+			alignment:       striped,
+			reservedPercent: "0.5",
+			want:            []string{"-F", "-m0.5", "-E", "stride=16,stripe_width=64"},
+		},
+		{
+			name:      "test ext4 alignment only",
+			fsType:    "ext4",
+			alignment: striped,
+			want:      []string{"-F", "-m0", "-E", "stride=16,stripe_width=64"},
+		},
+		{
+			name:            "test xfs ignores reserved blocks",
+			fsType:          "xfs",
+			reservedPercent: "1",
+			want:            nil,
+		},
+		{
+			name:            "test xfs alignment unaffected by reserved blocks",
+			fsType:          "xfs",
+			alignment:       striped,
+			reservedPercent: "1",
+			want:            []string{"-d", "su=65536,sw=4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := customizedMkfsArgs(tt.fsType, tt.alignment, tt.reservedPercent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("customizedMkfsArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_nodeServer_NodeUnpublishVolume(t *testing.T) {
 	type fields struct {
 		ephemeralVolumeStore Store
@@ -651,6 +913,7 @@ func Test_nodeServer_NodeUnpublishVolume(t *testing.T) {
 				k8smounter:           NewFakeSafeMounter(tt.scripts...),
 				ephemeralVolumeStore: tt.fields.ephemeralVolumeStore,
 				inFlight:             tt.fields.inFlight,
+				formatInFlight:       NewInFlight(),
 				spdkSupported:        tt.fields.spdkSupported,
 				spdkclient:           tt.fields.spdkclient,
 				osTool:               tt.fields.osTool,
@@ -838,6 +1101,7 @@ func Test_nodeServer_NodeExpandVolume(t *testing.T) {
 				k8smounter:           tt.fields.k8smounter,
 				ephemeralVolumeStore: tt.fields.ephemeralVolumeStore,
 				inFlight:             tt.fields.inFlight,
+				formatInFlight:       NewInFlight(),
 				spdkSupported:        tt.fields.spdkSupported,
 				spdkclient:           tt.fields.spdkclient,
 				osTool:               tt.fields.osTool,
@@ -854,3 +1118,432 @@ func Test_nodeServer_NodeExpandVolume(t *testing.T) {
 		})
 	}
 }
+
+func Test_nodeServer_getLVOfVolume_Moved(t *testing.T) {
+	newPV := func(name string, annotations map[string]string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						VolumeAttributes: map[string]string{pkg.VGName: "vg-old"},
+					},
+				},
+			},
+		}
+	}
+	movedPV := newPV("pv-moved", map[string]string{
+		pkg.AnnotationPVAllocatedInfoKey: `{"vgName":"vg-new","volumeType":"LVM"}`,
+	})
+	movingPV := newPV("pv-moving", map[string]string{
+		pkg.AnnotationPVAllocatedInfoKey: `{"vgName":"vg-old","volumeType":"LVM"}`,
+		pkg.AnnotationPVMovingToKey:      "vg-new",
+	})
+	kubeClient := fakekubeclientset.NewSimpleClientset(movedPV, movingPV)
+	kubeClient.PrependReactor("get", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.GetAction).GetName() != "pv-unavailable" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewServiceUnavailable("fake error")
+	})
+	ns := &nodeServer{
+		osTool:  NewFakeOSTool(),
+		options: &driverOptions{kubeclient: kubeClient},
+	}
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		volumeID      string
+		wantVG        string
+		wantErr       bool
+	}{
+		{
+			name:          "test lv moved by rebalance",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-moved"},
+			volumeID:      "pv-moved",
+			wantVG:        "vg-new",
+		},
+		{
+			name:          "test vg of pv preferred to existing lv",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-moved"},
+			volumeID:      "snapshot-lv",
+			wantVG:        "vg-new",
+		},
+		{
+			name:          "test lv being moved",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-moving"},
+			volumeID:      "pv-moving",
+			wantErr:       true,
+		},
+		{
+			name:          "test pv not found",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-not-exist"},
+			volumeID:      "pv-not-exist",
+			wantVG:        "vg-old",
+		},
+		{
+			name:          "test pv unavailable but lv exists",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-unavailable"},
+			volumeID:      "snapshot-lv",
+			wantVG:        "vg-old",
+		},
+		{
+			name:          "test pv unavailable and lv missing",
+			volumeContext: map[string]string{pkg.VGName: "vg-old", pkg.PVName: "pv-unavailable"},
+			volumeID:      "pv-unavailable",
+			wantErr:       true,
+		},
+		{
+			name:          "test volume without pv name",
+			volumeContext: map[string]string{pkg.VGName: "vg-old"},
+			volumeID:      "pv-moved",
+			wantVG:        "vg-old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vgName, _, err := ns.getLVOfVolume(tt.volumeContext, tt.volumeID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("nodeServer.getLVOfVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if vgName != tt.wantVG {
+				t.Errorf("nodeServer.getLVOfVolume() vg = %s, want %s", vgName, tt.wantVG)
+			}
+		})
+	}
+}
+
+// movedLVOSTool reports devices as existing and records source of MountBlock
+type movedLVOSTool struct {
+	*fakeOSTool
+	devices map[string]bool
+	// sources of MountBlock by target
+	blockSources map[string]string
+}
+
+func (tool *movedLVOSTool) Stat(name string) (os.FileInfo, error) {
+	if tool.devices[name] {
+		return nil, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (tool *movedLVOSTool) MountBlock(source, target string, opts ...string) error {
+	tool.blockSources[target] = source
+	return tool.fakeOSTool.MountBlock(source, target, opts...)
+}
+
+// rebalanceExecutor runs lvm commands of rebalance, none of lvs is mounted
+type rebalanceExecutor struct {
+	cmds []string
+	// onCopy is called when data of lv is copied
+	onCopy func()
+}
+
+func (e *rebalanceExecutor) Run(ctx context.Context, cmd string) (string, error) {
+	e.cmds = append(e.cmds, cmd)
+	if strings.Contains(cmd, " dd ") && e.onCopy != nil {
+		e.onCopy()
+	}
+	return "", nil
+}
+
+func Test_nodeServer_NodePublishVolume_AfterRebalance(t *testing.T) {
+	pvName := "test-moved-pv"
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+			Annotations: map[string]string{
+				pkg.AnnotationPVAllocatedInfoKey: `{"vgName":"vg-old","volumeType":"LVM"}`,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "test-pvc"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.VGName:        "vg-old",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+		},
+	}
+	kubeClient := fakekubeclientset.NewSimpleClientset(pv)
+	source, target := filepath.Join("/dev", "vg-old", pvName), filepath.Join("/dev", "vg-new", pvName)
+	osTool := &movedLVOSTool{
+		fakeOSTool:   &fakeOSTool{},
+		devices:      map[string]bool{source: true},
+		blockSources: map[string]string{},
+	}
+	ns := &nodeServer{
+		k8smounter:           NewFakeSafeMounter(),
+		ephemeralVolumeStore: NewMockVolumeStore(""),
+		inFlight:             NewInFlight(),
+		formatInFlight:       NewInFlight(),
+		osTool:               osTool,
+		options:              &driverOptions{kubeclient: kubeClient},
+	}
+	publish := func(targetPath string) error {
+		_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   pvName,
+			TargetPath: targetPath,
+			VolumeContext: map[string]string{
+				pkg.VGName:        "vg-old",
+				pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+				pkg.PVName:        pvName,
+			},
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		})
+		return err
+	}
+
+	var errOnCopy error
+	executor := &rebalanceExecutor{onCopy: func() {
+		osTool.devices[target] = true
+		errOnCopy = publish("/tmp/test-moving-publish")
+	}}
+	server.SetExecutor(executor)
+	defer server.SetExecutor(server.LocalExecutor{})
+	r := &server.Rebalance{NodeName: "test-node", KubeClient: kubeClient}
+	move := server.RebalanceMove{
+		LV: server.RebalanceLV{VGName: "vg-old", Name: pvName, Size: 1024 * 1024 * 1024, PVName: pvName},
+		To: "vg-new",
+	}
+	if err := r.MoveLV(move); err != nil {
+		t.Fatalf("Rebalance.MoveLV() error = %v", err)
+	}
+	if errOnCopy == nil {
+		t.Errorf("nodeServer.NodePublishVolume() publishes volume being moved")
+	}
+
+	// lv is only in the target vg while volume attributes still name the source
+	delete(osTool.devices, source)
+	targetPath := "/tmp/test-moved-publish"
+	if err := publish(targetPath); err != nil {
+		t.Fatalf("nodeServer.NodePublishVolume() error = %v", err)
+	}
+	if len(osTool.commands) > 0 {
+		t.Errorf("nodeServer.NodePublishVolume() creates lv by %v", osTool.commands)
+	}
+	if mounted := osTool.blockSources[targetPath]; mounted != target {
+		t.Errorf("nodeServer.NodePublishVolume() mounts %s, want %s", mounted, target)
+	}
+}
+
+func Test_nodeServer_NodePublishVolume_BlockSnapshot(t *testing.T) {
+	pvName := "test-block-snapshot-pv"
+	blockMode := corev1.PersistentVolumeBlock
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			VolumeMode: &blockMode,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.VGName:        "newVG",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+		},
+	}
+	osTool := &fakeOSTool{}
+	// no command is expected, fake exec fails on any of them
+	mounter := NewFakeSafeMounter()
+	ns := &nodeServer{
+		k8smounter:           mounter,
+		ephemeralVolumeStore: NewMockVolumeStore(""),
+		inFlight:             NewInFlight(),
+		formatInFlight:       NewInFlight(),
+		osTool:               osTool,
+		options:              &driverOptions{kubeclient: fakekubeclientset.NewSimpleClientset(pv)},
+	}
+	targetPath := "/tmp/test-block-snapshot-publish"
+	_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   pvName,
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			pkg.VGName:          "newVG",
+			pkg.VolumeTypeKey:   string(pkg.VolumeTypeLVM),
+			pkg.PVName:          pvName,
+			pkg.ParamSnapshotID: "test-snapshot",
+			pkg.ParamReadonly:   "true",
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if err != nil {
+		t.Fatalf("nodeServer.NodePublishVolume() error = %v", err)
+	}
+	if opts := osTool.blockMountOptions[targetPath]; !reflect.DeepEqual(opts, []string{"bind", "ro"}) {
+		t.Errorf("mount options of snapshot lv = %v, want [bind ro]", opts)
+	}
+	if calls := mounter.Exec.(*FakeSafeMounter).CommandCalls; calls != 0 {
+		t.Errorf("%d commands are run on block snapshot, want none", calls)
+	}
+}
+
+func Test_nodeServer_NodePublishVolume_MountPropagation(t *testing.T) {
+	mountVolume := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	blockVolume := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		volCap        *csi.VolumeCapability
+		wantOptions   []string
+		wantErr       bool
+	}{
+		{
+			name: "mountpoint keeps default propagation",
+			volumeContext: map[string]string{
+				string(pkg.MPName): "/mnt/open-local/disk0",
+				pkg.VolumeTypeKey:  string(pkg.VolumeTypeMountPoint),
+			},
+			volCap:      mountVolume,
+			wantOptions: []string{"bind"},
+		},
+		{
+			name: "mountpoint with rshared",
+			volumeContext: map[string]string{
+				string(pkg.MPName):        "/mnt/open-local/disk0",
+				pkg.VolumeTypeKey:         string(pkg.VolumeTypeMountPoint),
+				pkg.ParamMountPropagation: "rshared",
+			},
+			volCap:      mountVolume,
+			wantOptions: []string{"bind", "rshared"},
+		},
+		{
+			name: "device block with rslave",
+			volumeContext: map[string]string{
+				string(pkg.VolumeTypeDevice): "/dev/sdd",
+				pkg.VolumeTypeKey:            string(pkg.VolumeTypeDevice),
+				pkg.ParamMountPropagation:    "rslave",
+			},
+			volCap:      blockVolume,
+			wantOptions: []string{"bind", "rslave"},
+		},
+		{
+			name: "lvm block with private",
+			volumeContext: map[string]string{
+				pkg.VGName:                "newVG",
+				pkg.VolumeTypeKey:         string(pkg.VolumeTypeLVM),
+				pkg.ParamMountPropagation: "private",
+			},
+			volCap:      blockVolume,
+			wantOptions: []string{"bind", "private"},
+		},
+		{
+			name: "unknown propagation",
+			volumeContext: map[string]string{
+				string(pkg.MPName):        "/mnt/open-local/disk0",
+				pkg.VolumeTypeKey:         string(pkg.VolumeTypeMountPoint),
+				pkg.ParamMountPropagation: "shared",
+			},
+			volCap:  mountVolume,
+			wantErr: true,
+		},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pv"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.VGName:        "newVG",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osTool := &fakeOSTool{}
+			mounter := NewFakeSafeMounter()
+			ns := &nodeServer{
+				k8smounter:           mounter,
+				ephemeralVolumeStore: NewMockVolumeStore(""),
+				inFlight:             NewInFlight(),
+				formatInFlight:       NewInFlight(),
+				osTool:               osTool,
+				options:              &driverOptions{kubeclient: fakekubeclientset.NewSimpleClientset(pv)},
+			}
+			targetPath := "/tmp/test-mount-propagation"
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "test-pv",
+				TargetPath:       targetPath,
+				VolumeContext:    tt.volumeContext,
+				VolumeCapability: tt.volCap,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nodeServer.NodePublishVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			opts := osTool.blockMountOptions[targetPath]
+			if tt.volCap.GetBlock() == nil {
+				opts = mounter.Interface.(*FakeSafeMounter).mountOptions[targetPath]
+			}
+			if !reflect.DeepEqual(opts, tt.wantOptions) {
+				t.Errorf("mount options = %v, want %v", opts, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func Test_nodeServer_NodeExpandVolume_Block(t *testing.T) {
+	pvName := "test-block-pv"
+	blockMode := corev1.PersistentVolumeBlock
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pvName,
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			VolumeMode: &blockMode,
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse("150Gi"),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{
+						pkg.VGName:        "newVG",
+						pkg.VolumeTypeKey: string(pkg.VolumeTypeLVM),
+					},
+				},
+			},
+		},
+	}
+	osTool := &fakeOSTool{}
+	ns := &nodeServer{
+		inFlight:       NewInFlight(),
+		formatInFlight: NewInFlight(),
+		osTool:         osTool,
+		options:        &driverOptions{kubeclient: fakekubeclientset.NewSimpleClientset(pv)},
+	}
+	_, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:      pvName,
+		VolumePath:    "/tmp/test-block-expand",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: int64(200 * 1024 * 1024 * 1024)},
+	})
+	if err != nil {
+		t.Fatalf("nodeServer.NodeExpandVolume() error = %v", err)
+	}
+	if len(osTool.resizedDevices) != 0 {
+		t.Errorf("filesystem of block volume is resized on %v", osTool.resizedDevices)
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alibaba/open-local/pkg"
 	localtype "github.com/alibaba/open-local/pkg"
 	"github.com/alibaba/open-local/pkg/csi/server"
 	"github.com/alibaba/open-local/pkg/restic"
 	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	spdk "github.com/alibaba/open-local/pkg/utils/spdk"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/google/uuid"
@@ -18,6 +22,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -25,21 +30,9 @@ import (
 )
 
 func (ns *nodeServer) createLV(ctx context.Context, req *csi.NodePublishVolumeRequest) (string, string, error) {
-	vgName := ""
-	ephemeralVolume := req.VolumeContext[pkg.Ephemeral] == "true"
-	if ephemeralVolume {
-		vgName = req.VolumeContext[pkg.VGName]
-	} else {
-		// parse vgname, consider invalid if empty
-		pvName := req.VolumeContext[pkg.PVName]
-		pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
-		if err != nil {
-			return "", "", fmt.Errorf("createLV: fail to get pv: %s", err.Error())
-		}
-		vgName = utils.GetVGNameFromCsiPV(pv)
-		if vgName == "" {
-			return "", "", status.Errorf(codes.Internal, "error with input vgName is empty, pv is %s", pvName)
-		}
+	vgName, volumeID, err := ns.getLVOfVolume(req.VolumeContext, req.GetVolumeId())
+	if err != nil {
+		return "", "", err
 	}
 
 	// parse lvm type
@@ -49,17 +42,11 @@ func (ns *nodeServer) createLV(ctx context.Context, req *csi.NodePublishVolumeRe
 	}
 	log.Infof("createLV: vg %s, volume %s, LVM Type %s", vgName, req.GetVolumeId(), lvmType)
 
-	volumeID := req.GetVolumeId()
-	var isSnapshot bool
-	if _, isSnapshot = req.VolumeContext[localtype.ParamSnapshotID]; isSnapshot {
-		if ro, exist := req.VolumeContext[localtype.ParamReadonly]; exist && ro == "true" {
-			// if volume is ro snapshot, then mount snapshot lv
-			log.Infof("createLV: volume %s is readonly snapshot, mount snapshot lv %s directly", volumeID, req.VolumeContext[localtype.ParamSnapshotID])
-			volumeID = req.VolumeContext[localtype.ParamSnapshotID]
-		}
-	}
 	devicePath := filepath.Join("/dev/", vgName, volumeID)
 	if _, err := ns.osTool.Stat(devicePath); os.IsNotExist(err) {
+		if err := utils.ValidateDMName(vgName, volumeID); err != nil {
+			return "", "", status.Errorf(codes.InvalidArgument, "createLV: %s", err.Error())
+		}
 		newDev, bdevName, err := ns.createVolume(req.VolumeContext, volumeID, vgName, lvmType)
 		if err != nil {
 			log.Errorf("createLV: create volume %s with error: %s", volumeID, err.Error())
@@ -76,6 +63,77 @@ func (ns *nodeServer) createLV(ctx context.Context, req *csi.NodePublishVolumeRe
 	return devicePath, "", nil
 }
 
+// getLVOfVolume returns vg and lv name of lvm volume, which is the snapshot
+// lv for readonly snapshot volume
+func (ns *nodeServer) getLVOfVolume(volumeContext map[string]string, volumeID string) (string, string, error) {
+	// vg chosen by controller or set in ephemeral volume attributes
+	vgName := volumeContext[pkg.VGName]
+	ephemeralVolume := volumeContext[pkg.Ephemeral] == "true"
+	if vgName == "" && !ephemeralVolume {
+		// parse vgname from pv, consider invalid if empty
+		pvName := volumeContext[pkg.PVName]
+		pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("getLVOfVolume: fail to get pv: %s", err.Error())
+		}
+		vgName = utils.GetVGNameFromCsiPV(pv)
+		if vgName == "" {
+			return "", "", status.Errorf(codes.Internal, "error with input vgName is empty, pv is %s", pvName)
+		}
+	}
+
+	var isSnapshot bool
+	if lvName, exist := volumeContext[localtype.ParamLVName]; exist && lvName != "" {
+		// lv name is rendered by lv name template
+		log.Infof("getLVOfVolume: lv name of volume %s is %s", volumeID, lvName)
+		volumeID = lvName
+	} else if _, isSnapshot = utils.LookupParam(volumeContext, localtype.ParamSnapshotID); isSnapshot {
+		if ro, exist := utils.LookupParam(volumeContext, localtype.ParamReadonly); exist && ro == "true" {
+			// if volume is ro snapshot, then mount snapshot lv
+			log.Infof("getLVOfVolume: volume %s is readonly snapshot, mount snapshot lv %s directly", volumeID, utils.GetParam(volumeContext, localtype.ParamSnapshotID))
+			volumeID = utils.GetParam(volumeContext, localtype.ParamSnapshotID)
+		}
+	}
+	if !ephemeralVolume && !isSnapshot {
+		var err error
+		if vgName, err = ns.allocatedVGOfVolume(volumeContext[pkg.PVName], vgName, volumeID); err != nil {
+			return "", "", err
+		}
+	}
+	return vgName, volumeID, nil
+}
+
+// allocatedVGOfVolume returns vg recorded in pv, which is preferred to vgName
+// in immutable volume attributes since lv may be moved to another vg by
+// rebalance. Volume is refused while its lv is being moved. If pv fails to be
+// got, vgName is kept only if lv exists in it, so that an empty lv is never
+// created in the vg lv is moved out of. Lv of pv not found is never moved
+func (ns *nodeServer) allocatedVGOfVolume(pvName, vgName, lvName string) (string, error) {
+	if pvName == "" {
+		return vgName, nil
+	}
+	pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Warningf("allocatedVGOfVolume: pv %s is not found, use vg %s", pvName, vgName)
+		return vgName, nil
+	}
+	if err != nil {
+		if _, statErr := ns.osTool.Stat(filepath.Join("/dev", vgName, lvName)); statErr == nil {
+			log.Warningf("allocatedVGOfVolume: fail to get pv %s, use vg %s where lv %s exists: %s", pvName, vgName, lvName, err.Error())
+			return vgName, nil
+		}
+		return "", status.Errorf(codes.Unavailable, "allocatedVGOfVolume: fail to get pv %s to find vg of lv %s: %s", pvName, lvName, err.Error())
+	}
+	if to, moving := pv.Annotations[localtype.AnnotationPVMovingToKey]; moving {
+		return "", status.Errorf(codes.Unavailable, "allocatedVGOfVolume: lv %s of pv %s is being moved to vg %s, retry later", lvName, pvName, to)
+	}
+	if allocated := utils.GetVGNameFromCsiPV(pv); allocated != "" && allocated != vgName {
+		log.Infof("allocatedVGOfVolume: lv %s of pv %s is moved from vg %s to %s", lvName, pvName, vgName, allocated)
+		return allocated, nil
+	}
+	return vgName, nil
+}
+
 func collectMountOptions(fsType string, mntFlags []string) []string {
 	var options []string
 	options = append(options, mntFlags...)
@@ -88,6 +146,19 @@ func collectMountOptions(fsType string, mntFlags []string) []string {
 	return options
 }
 
+// getMountPropagation returns mount option of ParamMountPropagation for bind
+// mount of volume, nil if it is not set so that mount keeps its default
+func getMountPropagation(volumeContext map[string]string) ([]string, error) {
+	value, ok := utils.LookupParam(volumeContext, localtype.ParamMountPropagation)
+	if !ok {
+		return nil, nil
+	}
+	if !utils.ContainsString(localtype.MountPropagations, value) {
+		return nil, fmt.Errorf("%s must be one of %v, got %q", localtype.ParamMountPropagation, localtype.MountPropagations, value)
+	}
+	return []string{value}, nil
+}
+
 // include normal lvm & aep lvm type
 func (ns *nodeServer) mountLvmFS(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
 	// target path
@@ -97,9 +168,12 @@ func (ns *nodeServer) mountLvmFS(ctx context.Context, req *csi.NodePublishVolume
 	if err != nil {
 		return err
 	}
+	if devicePath, err = ns.encryptedDevice(req.GetVolumeId(), req.GetVolumeContext(), devicePath); err != nil {
+		return fmt.Errorf("mountLvmFS: %s", err.Error())
+	}
 
 	isSnapshotReadOnly := false
-	if value, exist := req.VolumeContext[localtype.ParamReadonly]; exist && value == "true" {
+	if value, exist := utils.LookupParam(req.VolumeContext, localtype.ParamReadonly); exist && value == "true" {
 		isSnapshotReadOnly = true
 	}
 
@@ -116,11 +190,7 @@ func (ns *nodeServer) mountLvmFS(ctx context.Context, req *csi.NodePublishVolume
 	}
 	// mount if not mounted
 	if notMounted {
-		// fs type
-		fsType := req.GetVolumeCapability().GetMount().FsType
-		if len(fsType) == 0 {
-			fsType = DefaultFs
-		}
+		fsType := getFsType(req)
 		var options []string
 		if req.GetReadonly() || isSnapshotReadOnly {
 			options = append(options, "ro")
@@ -131,14 +201,21 @@ func (ns *nodeServer) mountLvmFS(ctx context.Context, req *csi.NodePublishVolume
 		options = append(options, mountFlags...)
 		options = append(options, collectMountOptions(fsType, options)...)
 
-		if err := ns.k8smounter.FormatAndMount(devicePath, targetPath, fsType, options); err != nil {
-			return fmt.Errorf("mountLvmFS: fail to format and mount volume(volume id:%s, device path: %s): %s", req.VolumeId, devicePath, err.Error())
+		if err := ns.formatAndMount(ctx, devicePath, targetPath, fsType, req.GetVolumeContext(), options); err != nil {
+			return wrapFormatError(err, "mountLvmFS: fail to format and mount volume(volume id:%s, device path: %s)", req.VolumeId, devicePath)
+		}
+
+		// 全量拷贝的快照数据中文件系统大小与快照一致，需扩容至 lv 大小
+		if value, exist := utils.LookupParam(req.VolumeContext, localtype.ParamSnapshotFullCopy); exist && value == "true" {
+			if _, err := ns.osTool.ResizeFS(devicePath, targetPath); err != nil {
+				return fmt.Errorf("mountLvmFS: fail to resize fs of volume(volume id:%s, device path: %s): %s", req.VolumeId, devicePath, err.Error())
+			}
 		}
 
 		// 判断是否为 restic 快照
 		// 将 s3 数据拷贝到 targetPath 中，完毕。
 		// 这里注意 param 的传递
-		snapshotID, isSnapshot := req.VolumeContext[localtype.ParamSnapshotID]
+		snapshotID, isSnapshot := utils.LookupParam(req.VolumeContext, localtype.ParamSnapshotID)
 		if isSnapshot && !isSnapshotReadOnly {
 			if !checkIfRestored(targetPath) {
 				log.Info("restore data to target path %s ...", targetPath)
@@ -164,7 +241,7 @@ func (ns *nodeServer) mountLvmFS(ctx context.Context, req *csi.NodePublishVolume
 					return err
 				}
 				// 写一个隐藏文件，标识是否已经restore过
-				srcVolomeID := req.VolumeContext[localtype.ParamSourceVolumeID]
+				srcVolomeID := utils.GetParam(req.VolumeContext, localtype.ParamSourceVolumeID)
 				s3URL := string(secret.Data[localtype.S3_URL])
 				s3AK := string(secret.Data[localtype.S3_AK])
 				s3SK := string(secret.Data[localtype.S3_SK])
@@ -205,6 +282,9 @@ func (ns *nodeServer) mountLvmBlock(ctx context.Context, req *csi.NodePublishVol
 	if err != nil {
 		return fmt.Errorf("mountLvmBlock: fail to create lv: %s", err.Error())
 	}
+	if devicePath, err = ns.encryptedDevice(req.GetVolumeId(), req.GetVolumeContext(), devicePath); err != nil {
+		return fmt.Errorf("mountLvmBlock: %s", err.Error())
+	}
 
 	// Step 2: check
 	// check if devicePath is block device
@@ -224,10 +304,16 @@ func (ns *nodeServer) mountLvmBlock(ctx context.Context, req *csi.NodePublishVol
 		return status.Errorf(codes.Internal, "mountLvmBlock: check if %s is mountpoint failed: %s", targetPath, err)
 	}
 	// Step 3: mount device
+	propagation, err := getMountPropagation(req.VolumeContext)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "mountLvmBlock: %s", err.Error())
+	}
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	// readonly snapshot lv is exposed directly, never writable
+	if req.GetReadonly() || utils.GetParam(req.VolumeContext, localtype.ParamReadonly) == "true" {
 		mountOptions = append(mountOptions, "ro")
 	}
+	mountOptions = append(mountOptions, propagation...)
 	if notMounted {
 		log.Infof("mountLvmBlock: mounting %s at %s", devicePath, targetPath)
 		if err := ns.osTool.EnsureBlock(targetPath); err != nil {
@@ -275,11 +361,16 @@ func (ns *nodeServer) mountMountPointVolume(ctx context.Context, req *csi.NodePu
 	}
 
 	// start to mount
+	propagation, err := getMountPropagation(req.VolumeContext)
+	if err != nil {
+		return fmt.Errorf("mountMountPointVolume: %s", err.Error())
+	}
 	mnt := req.VolumeCapability.GetMount()
 	options := append(mnt.MountFlags, "bind")
 	if req.Readonly {
 		options = append(options, "ro")
 	}
+	options = append(options, propagation...)
 	fsType := "ext4"
 	if mnt.FsType != "" {
 		fsType = mnt.FsType
@@ -293,12 +384,10 @@ func (ns *nodeServer) mountMountPointVolume(ctx context.Context, req *csi.NodePu
 
 func (ns *nodeServer) mountDeviceVolumeFS(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
 	targetPath := req.TargetPath
-	pvName := req.VolumeContext[pkg.PVName]
-	pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	sourceDevice, err := ns.getSourceDevice(req.VolumeContext)
 	if err != nil {
 		return err
 	}
-	sourceDevice := utils.GetDeviceNameFromCsiPV(pv)
 	if sourceDevice == "" {
 		return fmt.Errorf("mountDeviceVolumeFS: mount device %s with empty source path", req.VolumeId)
 	}
@@ -315,11 +404,7 @@ func (ns *nodeServer) mountDeviceVolumeFS(ctx context.Context, req *csi.NodePubl
 		return fmt.Errorf("mountDeviceVolumeFS: fail to check if %s is mounted: %s", targetPath, err.Error())
 	}
 	if notMounted {
-		// fs type
-		fsType := req.GetVolumeCapability().GetMount().FsType
-		if len(fsType) == 0 {
-			fsType = DefaultFs
-		}
+		fsType := getFsType(req)
 		var options []string
 		if req.GetReadonly() {
 			options = append(options, "ro")
@@ -330,8 +415,8 @@ func (ns *nodeServer) mountDeviceVolumeFS(ctx context.Context, req *csi.NodePubl
 		options = append(options, mountFlags...)
 		options = append(options, collectMountOptions(fsType, options)...)
 
-		if err := ns.k8smounter.FormatAndMount(sourceDevice, targetPath, fsType, options); err != nil {
-			return fmt.Errorf("mountDeviceVolumeFS: fail to format and mount volume(volume id:%s, device path: %s): %s", req.VolumeId, sourceDevice, err.Error())
+		if err := ns.formatAndMount(ctx, sourceDevice, targetPath, fsType, req.GetVolumeContext(), options); err != nil {
+			return wrapFormatError(err, "mountDeviceVolumeFS: fail to format and mount volume(volume id:%s, device path: %s)", req.VolumeId, sourceDevice)
 		}
 		log.Infof("mountDeviceVolumeFS: mount devicePath %s to targetPath %s successfully, options: %v", sourceDevice, targetPath, options)
 	}
@@ -342,12 +427,10 @@ func (ns *nodeServer) mountDeviceVolumeFS(ctx context.Context, req *csi.NodePubl
 func (ns *nodeServer) mountDeviceVolumeBlock(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
 	// Step 1: get targetPath and sourceDevice
 	targetPath := req.TargetPath
-	pvName := req.VolumeContext[pkg.PVName]
-	pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	sourceDevice, err := ns.getSourceDevice(req.VolumeContext)
 	if err != nil {
 		return err
 	}
-	sourceDevice := utils.GetDeviceNameFromCsiPV(pv)
 	log.Infof("mountDeviceVolumeBlock: targetPath %s, sourceDevice %s", targetPath, sourceDevice)
 
 	// Step 2: check if sourceDevice is block device
@@ -369,10 +452,15 @@ func (ns *nodeServer) mountDeviceVolumeBlock(ctx context.Context, req *csi.NodeP
 	}
 
 	// Step 4: mount device
+	propagation, err := getMountPropagation(req.VolumeContext)
+	if err != nil {
+		return fmt.Errorf("mountDeviceVolumeBlock: %s", err.Error())
+	}
 	mountOptions := []string{"bind"}
 	if req.GetReadonly() {
 		mountOptions = append(mountOptions, "ro")
 	}
+	mountOptions = append(mountOptions, propagation...)
 	if notMounted {
 		log.Infof("mountDeviceVolumeBlock: mounting %s at %s", sourceDevice, targetPath)
 		if err := ns.osTool.EnsureBlock(targetPath); err != nil {
@@ -420,7 +508,7 @@ func (ns *nodeServer) createVolume(volumeContext map[string]string, volumeID, vg
 
 	// check vg exist
 	if !ns.spdkSupported {
-		ckCmd := fmt.Sprintf("%s vgck %s", localtype.NsenterCmd, vgName)
+		ckCmd := fmt.Sprintf("%s %s", lvm.Command("vgck"), vgName)
 		_, err = ns.osTool.RunCommand(ckCmd)
 		if err != nil {
 			log.Errorf("createVolume:: VG is not exist: %s", vgName)
@@ -473,7 +561,11 @@ func (ns *nodeServer) createLvm(vgName, volumeID, lvmType, unit string, pvSize i
 		if pvNumber == 0 {
 			return fmt.Errorf("createVolume:: VG is exist: %s, bug get pv number as 0", vgName)
 		}
-		cmd := fmt.Sprintf("%s lvcreate -i %d -n %s -L %d%s %s", localtype.NsenterCmd, pvNumber, volumeID, pvSize, unit, vgName)
+		stripeArg := ""
+		if stripeSize := server.PVStripeSize(ns.options.sysPath, vgName); stripeSize > 0 {
+			stripeArg = fmt.Sprintf(" -I %dk", stripeSize/1024)
+		}
+		cmd := fmt.Sprintf("%s -i %d%s -n %s -L %d%s %s", lvm.Command("lvcreate"), pvNumber, stripeArg, volumeID, pvSize, unit, vgName)
 		_, err := ns.osTool.RunCommand(cmd)
 		if err != nil {
 			log.Errorf("createVolume:: lvcreate command %s error: %v", cmd, err)
@@ -481,7 +573,7 @@ func (ns *nodeServer) createLvm(vgName, volumeID, lvmType, unit string, pvSize i
 		}
 		log.Infof("Successful Create Striping LVM volume: %s, with command: %s", volumeID, cmd)
 	} else if lvmType == LinearType {
-		cmd := fmt.Sprintf("%s lvcreate -n %s -L %d%s -Wy -y %s", localtype.NsenterCmd, volumeID, pvSize, unit, vgName)
+		cmd := fmt.Sprintf("%s -n %s -L %d%s -Wy -y %s", lvm.Command("lvcreate"), volumeID, pvSize, unit, vgName)
 		_, err := ns.osTool.RunCommand(cmd)
 		if err != nil {
 			log.Errorf("createVolume:: lvcreate linear command %s error: %v", cmd, err)
@@ -493,7 +585,7 @@ func (ns *nodeServer) createLvm(vgName, volumeID, lvmType, unit string, pvSize i
 }
 
 func (ns *nodeServer) removeLVMByDevicePath(devicePath string) error {
-	cmd := fmt.Sprintf("%s lvremove -v -f %s", localtype.NsenterCmd, devicePath)
+	cmd := fmt.Sprintf("%s -v -f %s", lvm.Command("lvremove"), devicePath)
 	_, err := ns.osTool.RunCommand(cmd)
 	if err != nil {
 		log.Errorf("removeLVMByDevicePath:: lvremove command %s error: %v", cmd, err)
@@ -555,3 +647,175 @@ func labelRestored(path string) error {
 
 	return nil
 }
+
+// getSourceDevice returns device chosen by controller, or the one recorded
+// in pv for volume created before device is recorded in volume context
+func (ns *nodeServer) getSourceDevice(volumeContext map[string]string) (string, error) {
+	if device := volumeContext[string(pkg.VolumeTypeDevice)]; device != "" {
+		return device, nil
+	}
+	pvName := volumeContext[pkg.PVName]
+	pv, err := ns.options.kubeclient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return utils.GetDeviceNameFromCsiPV(pv), nil
+}
+
+// getFsType returns fsType chosen by controller, or derives it from volume
+// capability for volume created before fsType is recorded in volume context
+func getFsType(req *csi.NodePublishVolumeRequest) string {
+	if fsType := req.VolumeContext[pkg.VolumeFSTypeKey]; fsType != "" {
+		return fsType
+	}
+	if fsType := req.GetVolumeCapability().GetMount().GetFsType(); fsType != "" {
+		return fsType
+	}
+	return DefaultFs
+}
+
+// formatAndMount formats device if needed and mounts it to targetPath. Device
+// is guarded by formatInFlight until mkfs exits, so that a retried request
+// never formats the same device concurrently. mkfs can not be interrupted, on
+// timeout the request fails at once and the device is unmounted when mkfs
+// finishes in background, kubelet retries and mounts the formatted device.
+func (ns *nodeServer) formatAndMount(ctx context.Context, device, targetPath, fsType string, volumeContext map[string]string, options []string) error {
+	reservedPercent, err := utils.GetReservedBlocksPercent(volumeContext)
+	if err != nil {
+		return err
+	}
+
+	if ok := ns.formatInFlight.Insert(device); !ok {
+		return status.Errorf(codes.Aborted, "device %s is being formatted, try again later", device)
+	}
+
+	var mux sync.Mutex
+	abandoned := false
+	done := make(chan error, 1)
+	go func() {
+		defer ns.formatInFlight.Delete(device)
+		// mkfs can not be interrupted, so the operation is not cancellable
+		vg, lv := formatTarget(device)
+		_, deregister := utils.LongOperations.Register(utils.OperationTypeFormat, vg, lv, nil, nil)
+		defer deregister()
+		err := ns.formatCustomized(device, fsType, reservedPercent)
+		if err == nil {
+			err = ns.k8smounter.FormatAndMount(device, targetPath, fsType, options)
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if !abandoned {
+			done <- err
+			return
+		}
+		if err != nil {
+			log.Errorf("formatAndMount: fail to format and mount device %s after timeout: %s", device, err.Error())
+			return
+		}
+		if err := ns.k8smounter.Unmount(targetPath); err != nil {
+			log.Errorf("formatAndMount: fail to unmount %s after timeout: %s", targetPath, err.Error())
+			return
+		}
+		log.Infof("formatAndMount: device %s is formatted after timeout, unmount %s", device, targetPath)
+	}()
+
+	var timeout <-chan time.Time
+	if ns.options.formatTimeout > 0 {
+		timer := time.NewTimer(time.Duration(ns.options.formatTimeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	select {
+	case err := <-done:
+		return err
+	default:
+	}
+	abandoned = true
+	return status.Errorf(codes.DeadlineExceeded, "device %s is still being formatted, try again later", device)
+}
+
+// formatTarget returns vg and lv of lvm device /dev/<vg>/<lv>, or empty vg
+// and the device itself for other block device
+func formatTarget(device string) (string, string) {
+	dir := filepath.Dir(device)
+	if filepath.Dir(dir) == "/dev" && dir != "/dev/mapper" {
+		return filepath.Base(dir), filepath.Base(device)
+	}
+	return "", device
+}
+
+// formatCustomized formats unformatted device with stride and stripe width
+// aligned to io size hint of device and reserved blocks percentage of ext4
+// set in storage class, which FormatAndMount then mounts as is. Device
+// needing neither is left to FormatAndMount.
+func (ns *nodeServer) formatCustomized(device, fsType, reservedPercent string) error {
+	alignment, err := utils.ReadIOAlignment(ns.options.sysPath, device)
+	if err != nil {
+		log.V(4).Infof("formatCustomized: io alignment of device %s is unknown, format without alignment: %s", device, err.Error())
+		alignment = utils.IOAlignment{}
+	}
+	args := customizedMkfsArgs(fsType, alignment, reservedPercent)
+	if len(args) == 0 {
+		return nil
+	}
+	existingFormat, err := ns.k8smounter.GetDiskFormat(device)
+	if err != nil {
+		return fmt.Errorf("fail to get format of device %s: %s", device, err.Error())
+	}
+	if existingFormat != "" {
+		return nil
+	}
+	args = append(args, device)
+	log.Infof("formatCustomized: format device %s aligned to %s, args: %v", device, alignment, args)
+	out, err := ns.k8smounter.Exec.Command("mkfs."+fsType, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fail to format device %s: %s, output: %s", device, err.Error(), string(out))
+	}
+	return nil
+}
+
+// customizedMkfsArgs returns mkfs arguments besides device, nil if the
+// defaults of FormatAndMount suffice
+func customizedMkfsArgs(fsType string, alignment utils.IOAlignment, reservedPercent string) []string {
+	args := utils.MkfsAlignmentArgs(fsType, alignment)
+	reserved := utils.MkfsReservedBlocksArgs(fsType, reservedPercent)
+	if len(args) == 0 && len(reserved) == 0 {
+		return nil
+	}
+	// keep the same arguments as FormatAndMount besides customization
+	if strings.HasPrefix(fsType, "ext") {
+		if len(reserved) == 0 {
+			reserved = []string{"-m0"}
+		}
+		args = append(append([]string{"-F"}, reserved...), args...)
+	}
+	return args
+}
+
+// wrapFormatError keeps the code of error returned by formatAndMount to tell
+// kubelet the request may succeed later
+func wrapFormatError(err error, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if s, ok := status.FromError(err); ok {
+		return status.Errorf(s.Code(), "%s: %s", msg, s.Message())
+	}
+	return fmt.Errorf("%s: %s", msg, err.Error())
+}
+
+// formatErrorCode returns code of retryable format error, or codes.Internal
+func formatErrorCode(err error) codes.Code {
+	switch status.Code(err) {
+	case codes.Aborted, codes.DeadlineExceeded:
+		return status.Code(err)
+	}
+	return codes.Internal
+}
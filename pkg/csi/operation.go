@@ -0,0 +1,113 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	log "k8s.io/klog/v2"
+)
+
+// operationMethods are csi methods whose start and result are logged,
+// keyed by grpc full method
+var operationMethods = map[string]string{
+	"/csi.v1.Controller/CreateVolume":           "CreateVolume",
+	"/csi.v1.Controller/DeleteVolume":           "DeleteVolume",
+	"/csi.v1.Controller/CreateSnapshot":         "CreateSnapshot",
+	"/csi.v1.Controller/DeleteSnapshot":         "DeleteSnapshot",
+	"/csi.v1.Controller/ControllerExpandVolume": "ControllerExpandVolume",
+	"/csi.v1.Controller/ControllerModifyVolume": "ControllerModifyVolume",
+	"/csi.v1.Node/NodeStageVolume":              "NodeStageVolume",
+	"/csi.v1.Node/NodeUnstageVolume":            "NodeUnstageVolume",
+	"/csi.v1.Node/NodePublishVolume":            "NodePublishVolume",
+	"/csi.v1.Node/NodeUnpublishVolume":          "NodeUnpublishVolume",
+	"/csi.v1.Node/NodeExpandVolume":             "NodeExpandVolume",
+}
+
+// operationID returns id of operation of csi request, which is the volume
+// handle so that create, stage, publish, expand and delete of a volume share
+// the same id. Snapshot creation takes handle of source volume and deletion
+// takes snapshot id
+func operationID(req interface{}) string {
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		// name of request becomes handle of the volume
+		return r.GetName()
+	case *csi.CreateSnapshotRequest:
+		return r.GetSourceVolumeId()
+	case *csi.DeleteSnapshotRequest:
+		return r.GetSnapshotId()
+	case interface{ GetVolumeId() string }:
+		return r.GetVolumeId()
+	}
+	return ""
+}
+
+// logOperation puts operation id into ctx of csi request and logs start and
+// result of volume operations with it, errors of other methods are logged too
+func logOperation(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = utils.WithOperationID(ctx, operationID(req))
+	op, logged := operationMethods[info.FullMethod]
+	if !logged {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			log.ErrorS(err, "GRPC error", utils.OperationLogKeys(ctx, "method", info.FullMethod)...)
+		}
+		return resp, err
+	}
+	log.InfoS("operation started", utils.OperationLogKeys(ctx, utils.LogKeyOperation, op)...)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	keys := utils.OperationLogKeys(ctx, utils.LogKeyOperation, op, "code", status.Code(err).String(), "duration", time.Since(start).String())
+	if err != nil {
+		log.ErrorS(err, "operation failed", keys...)
+		return resp, err
+	}
+	log.InfoS("operation succeeded", keys...)
+	return resp, nil
+}
+
+// strippedSecret replaces value of secret in logged csi request
+const strippedSecret = "***stripped***"
+
+// stripSecrets returns csi request req for logging with values of its secrets,
+// such as luks passphrase and s3 credentials, replaced by strippedSecret. req
+// is left as it is
+func stripSecrets(req interface{}) interface{} {
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return req
+	}
+	secrets := v.Elem().FieldByName("Secrets")
+	if !secrets.IsValid() || secrets.Kind() != reflect.Map || secrets.Len() == 0 {
+		return v.Elem().Interface()
+	}
+	stripped := reflect.MakeMapWithSize(secrets.Type(), secrets.Len())
+	for _, key := range secrets.MapKeys() {
+		stripped.SetMapIndex(key, reflect.ValueOf(strippedSecret))
+	}
+	copied := reflect.New(v.Elem().Type()).Elem()
+	copied.Set(v.Elem())
+	copied.FieldByName("Secrets").Set(stripped)
+	return copied.Interface()
+}
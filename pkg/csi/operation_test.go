@@ -0,0 +1,154 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	logsjson "k8s.io/component-base/logs/json"
+	log "k8s.io/klog/v2"
+)
+
+// syncBuffer captures output of json logger
+type syncBuffer struct {
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Sync() error { return nil }
+
+func Test_operationID(t *testing.T) {
+	tests := []struct {
+		name string
+		req  interface{}
+		want string
+	}{
+		{name: "test CreateVolume", req: &csi.CreateVolumeRequest{Name: "pv-1"}, want: "pv-1"},
+		{name: "test DeleteVolume", req: &csi.DeleteVolumeRequest{VolumeId: "pv-1"}, want: "pv-1"},
+		{name: "test NodeStageVolume", req: &csi.NodeStageVolumeRequest{VolumeId: "pv-1"}, want: "pv-1"},
+		{name: "test NodePublishVolume", req: &csi.NodePublishVolumeRequest{VolumeId: "pv-1"}, want: "pv-1"},
+		{name: "test ControllerExpandVolume", req: &csi.ControllerExpandVolumeRequest{VolumeId: "pv-1"}, want: "pv-1"},
+		{name: "test CreateSnapshot", req: &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "pv-1"}, want: "pv-1"},
+		{name: "test DeleteSnapshot", req: &csi.DeleteSnapshotRequest{SnapshotId: "snap-1"}, want: "snap-1"},
+		{name: "test request without volume", req: &csi.NodeGetInfoRequest{}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := operationID(tt.req); got != tt.want {
+				t.Errorf("operationID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_logOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		req       interface{}
+		err       error
+		wantID    string
+		wantLines []string
+	}{
+		{
+			name:      "test CreateVolume",
+			method:    "/csi.v1.Controller/CreateVolume",
+			req:       &csi.CreateVolumeRequest{Name: "pv-1"},
+			wantID:    "pv-1",
+			wantLines: []string{"operation started", "controller step", "lvmd step", "operation succeeded"},
+		},
+		{
+			name:      "test failed NodeStageVolume",
+			method:    "/csi.v1.Node/NodeStageVolume",
+			req:       &csi.NodeStageVolumeRequest{VolumeId: "pv-2"},
+			err:       status.Error(codes.Internal, "mkfs failed"),
+			wantID:    "pv-2",
+			wantLines: []string{"operation started", "controller step", "lvmd step", "operation failed"},
+		},
+		{
+			name:      "test failed method not logged",
+			method:    "/csi.v1.Node/NodeGetVolumeStats",
+			req:       &csi.NodeGetVolumeStatsRequest{VolumeId: "pv-3"},
+			err:       status.Error(codes.NotFound, "not found"),
+			wantID:    "pv-3",
+			wantLines: []string{"controller step", "lvmd step", "GRPC error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &syncBuffer{}
+			log.SetLogger(logsjson.NewJSONLogger(buf))
+			defer log.SetLogger(nil)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				log.InfoS("controller step", utils.OperationLogKeys(ctx)...)
+				// lvmd sees operation id passed by grpc metadata
+				md, _ := metadata.FromOutgoingContext(utils.InjectOperationID(ctx))
+				lvmdCtx := utils.ExtractOperationID(metadata.NewIncomingContext(context.Background(), md))
+				log.InfoS("lvmd step", utils.OperationLogKeys(lvmdCtx, utils.LogKeyOperation, "CreateLV")...)
+				return nil, tt.err
+			}
+			_, err := logOperation(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if err != tt.err {
+				t.Errorf("logOperation() error = %v, want %v", err, tt.err)
+			}
+
+			lines := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				entry := map[string]interface{}{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("log line %q is not json: %s", line, err.Error())
+				}
+				lines = append(lines, entry["msg"].(string))
+				if entry[utils.LogKeyOperationID] != tt.wantID {
+					t.Errorf("log line %q has %s %v, want %s", entry["msg"], utils.LogKeyOperationID, entry[utils.LogKeyOperationID], tt.wantID)
+				}
+			}
+			if strings.Join(lines, ",") != strings.Join(tt.wantLines, ",") {
+				t.Errorf("log lines = %v, want %v", lines, tt.wantLines)
+			}
+		})
+	}
+}
+
+func Test_stripSecrets(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "pv-1",
+		Secrets:  map[string]string{"passphrase": "luks-secret"},
+	}
+	logged := fmt.Sprintf("%+v", stripSecrets(req))
+	if strings.Contains(logged, "luks-secret") || !strings.Contains(logged, strippedSecret) || !strings.Contains(logged, "pv-1") {
+		t.Errorf("stripSecrets() is logged as %s, want secret stripped", logged)
+	}
+	if req.Secrets["passphrase"] != "luks-secret" {
+		t.Errorf("secrets of request are modified: %v", req.Secrets)
+	}
+	// request without secrets is logged as it is
+	if got, want := fmt.Sprintf("%+v", stripSecrets(&csi.NodeUnstageVolumeRequest{VolumeId: "pv-1"})), fmt.Sprintf("%+v", csi.NodeUnstageVolumeRequest{VolumeId: "pv-1"}); got != want {
+		t.Errorf("stripSecrets() = %s, want %s", got, want)
+	}
+}
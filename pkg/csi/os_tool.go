@@ -1,6 +1,7 @@
 package csi
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -19,6 +20,10 @@ type OSTool interface {
 	EnsureBlock(target string) error
 	CleanupMountPoint(mountPath string, mounter mountutils.Interface, extensiveMountPointCheck bool) error
 	ResizeFS(devicePath string, deviceMountPath string) (bool, error)
+	// MountInfo parses /proc/<pid>/mountinfo, pid may be "self"
+	MountInfo(pid string) ([]mountutils.MountInfo, error)
+	// MountNamespace returns the mount namespace of pid, such as mnt:[4026531840]
+	MountNamespace(pid string) (string, error)
 }
 
 type osTool struct{}
@@ -64,7 +69,27 @@ func (tool *osTool) ResizeFS(devicePath string, deviceMountPath string) (bool, e
 	return resizer.Resize(devicePath, deviceMountPath)
 }
 
-type fakeOSTool struct{}
+func (tool *osTool) MountInfo(pid string) ([]mountutils.MountInfo, error) {
+	return mountutils.ParseMountInfo(fmt.Sprintf("/proc/%s/mountinfo", pid))
+}
+
+func (tool *osTool) MountNamespace(pid string) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%s/ns/mnt", pid))
+}
+
+type fakeOSTool struct {
+	// mountInfo and mountNamespaces by pid, read as missing if not set
+	mountInfo       map[string][]mountutils.MountInfo
+	mountNamespaces map[string]string
+	// options of MountBlock by target and devices passed to ResizeFS
+	blockMountOptions map[string][]string
+	resizedDevices    []string
+	// commands passed to RunCommand, output of runCommand if it is set
+	commands   []string
+	runCommand func(cmd string) (string, error)
+	// error returned by ResizeFS
+	resizeErr error
+}
 
 func NewFakeOSTool() OSTool {
 	return &fakeOSTool{}
@@ -86,6 +111,10 @@ func (tool *fakeOSTool) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (tool *fakeOSTool) RunCommand(cmd string) (string, error) {
+	tool.commands = append(tool.commands, cmd)
+	if tool.runCommand != nil {
+		return tool.runCommand(cmd)
+	}
 	return "", nil
 }
 
@@ -94,6 +123,10 @@ func (tool *fakeOSTool) IsBlockDevice(fullPath string) (bool, error) {
 }
 
 func (tool *fakeOSTool) MountBlock(source, target string, opts ...string) error {
+	if tool.blockMountOptions == nil {
+		tool.blockMountOptions = map[string][]string{}
+	}
+	tool.blockMountOptions[target] = opts
 	return nil
 }
 
@@ -106,5 +139,25 @@ func (tool *fakeOSTool) CleanupMountPoint(mountPath string, mounter mountutils.I
 }
 
 func (tool *fakeOSTool) ResizeFS(devicePath string, deviceMountPath string) (bool, error) {
+	tool.resizedDevices = append(tool.resizedDevices, devicePath)
+	if tool.resizeErr != nil {
+		return false, tool.resizeErr
+	}
 	return true, nil
 }
+
+func (tool *fakeOSTool) MountInfo(pid string) ([]mountutils.MountInfo, error) {
+	infos, ok := tool.mountInfo[pid]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return infos, nil
+}
+
+func (tool *fakeOSTool) MountNamespace(pid string) (string, error) {
+	ns, ok := tool.mountNamespaces[pid]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return ns, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	log "k8s.io/klog/v2"
+)
+
+// DefaultPostProvisionHookTimeout is the default timeout(second) of every
+// post-provision hook
+const DefaultPostProvisionHookTimeout = 30
+
+var (
+	// replaced in unit test
+	runPostProvisionHook = execPostProvisionHook
+)
+
+// validatePostProvisionHooks ensures every hook configured by operator is an
+// executable file by absolute path which is not writable by others
+func validatePostProvisionHooks(hooks []string) error {
+	for _, hook := range hooks {
+		if !filepath.IsAbs(hook) || filepath.Clean(hook) != hook {
+			return fmt.Errorf("post-provision hook %q must be a clean absolute path", hook)
+		}
+		info, err := os.Stat(hook)
+		if err != nil {
+			return fmt.Errorf("post-provision hook %s: %s", hook, err.Error())
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			return fmt.Errorf("post-provision hook %s is not an executable file", hook)
+		}
+		if info.Mode().Perm()&0002 != 0 {
+			return fmt.Errorf("post-provision hook %s must not be writable by others", hook)
+		}
+	}
+	return nil
+}
+
+// postProvisionHookArgs returns arguments of hook in the order: target path,
+// volume id, volume type, access type(mount or block), fs type, pvc namespace
+// and pvc name. Unknown ones are empty
+func postProvisionHookArgs(req *csi.NodePublishVolumeRequest, volumeType string) []string {
+	accessType, fsType := "mount", ""
+	switch volCap := req.GetVolumeCapability(); volCap.GetAccessType().(type) {
+	case *csi.VolumeCapability_Block:
+		accessType = "block"
+	case *csi.VolumeCapability_Mount:
+		fsType = volCap.GetMount().GetFsType()
+	}
+	if fsType == "" && accessType == "mount" {
+		fsType = req.GetVolumeContext()[localtype.VolumeFSTypeKey]
+	}
+	return []string{
+		req.GetTargetPath(),
+		req.GetVolumeId(),
+		volumeType,
+		accessType,
+		fsType,
+		req.GetVolumeContext()[localtype.PVCNameSpace],
+		req.GetVolumeContext()[localtype.PVCName],
+	}
+}
+
+// runPostProvisionHooks runs hooks in order after volume is published to
+// target path, publishing fails on the first failed hook. Hooks run again
+// when publishing is retried, so they must be idempotent
+func (ns *nodeServer) runPostProvisionHooks(ctx context.Context, req *csi.NodePublishVolumeRequest, volumeType string) error {
+	if len(ns.options.postProvisionHooks) == 0 {
+		return nil
+	}
+	args := postProvisionHookArgs(req, volumeType)
+	for _, hook := range ns.options.postProvisionHooks {
+		hookCtx, cancel := ctx, context.CancelFunc(func() {})
+		if ns.options.postProvisionHookTimeout > 0 {
+			hookCtx, cancel = context.WithTimeout(ctx, time.Duration(ns.options.postProvisionHookTimeout)*time.Second)
+		}
+		out, err := runPostProvisionHook(hookCtx, hook, args)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("post-provision hook %s of volume %s failed: %s: %s", hook, req.GetVolumeId(), err.Error(), strings.TrimSpace(string(out)))
+		}
+		log.Infof("runPostProvisionHooks: hook %s of volume %s succeeded: %s", hook, req.GetVolumeId(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// execPostProvisionHook runs hook without shell, so arguments are never
+// interpreted
+func execPostProvisionHook(ctx context.Context, hook string, args []string) ([]byte, error) {
+	return exec.CommandContext(ctx, hook, args...).CombinedOutput()
+}
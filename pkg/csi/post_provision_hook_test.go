@@ -0,0 +1,182 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func Test_nodeServer_NodePublishVolume_PostProvisionHook(t *testing.T) {
+	findmntAction := func() ([]byte, []byte, error) {
+		return []byte("TYPE=ext4"), []byte{}, nil
+	}
+	blkidAction := func() ([]byte, []byte, error) {
+		return []byte("DEVICE=/dev/sdd\nTYPE=ext4"), []byte{}, nil
+	}
+	targetPath := t.TempDir()
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-device-pv",
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			pkg.DeviceName:      "/dev/sdd",
+			pkg.VolumeTypeKey:   string(pkg.VolumeTypeDevice),
+			pkg.PVName:          "test-device-pv",
+			pkg.PVCNameSpace:    "default",
+			pkg.PVCName:         "test-pvc",
+			pkg.VolumeFSTypeKey: "xfs",
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+	wantArgs := []string{targetPath, "test-device-pv", "Device", "mount", "ext4", "default", "test-pvc"}
+
+	tests := []struct {
+		name      string
+		hooks     []string
+		failing   string
+		wantCalls []string
+		wantCode  codes.Code
+	}{
+		{
+			name:      "test no hook",
+			wantCalls: []string{},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test hooks run in order",
+			hooks:     []string{"/etc/open-local/hooks/chown", "/etc/open-local/hooks/selinux"},
+			wantCalls: []string{"/etc/open-local/hooks/chown", "/etc/open-local/hooks/selinux"},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "test failed hook aborts publishing",
+			hooks:     []string{"/etc/open-local/hooks/chown", "/etc/open-local/hooks/selinux"},
+			failing:   "/etc/open-local/hooks/chown",
+			wantCalls: []string{"/etc/open-local/hooks/chown"},
+			wantCode:  codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			origin := runPostProvisionHook
+			runPostProvisionHook = func(ctx context.Context, hook string, args []string) ([]byte, error) {
+				calls = append(calls, hook)
+				if !reflect.DeepEqual(args, wantArgs) {
+					t.Errorf("hook %s args = %v, want %v", hook, args, wantArgs)
+				}
+				if hook == tt.failing {
+					return []byte("permission denied"), errors.New("exit status 1")
+				}
+				return nil, nil
+			}
+			defer func() { runPostProvisionHook = origin }()
+
+			ns := &nodeServer{
+				k8smounter:           NewFakeSafeMounter([]testingexec.FakeAction{findmntAction, blkidAction}...),
+				ephemeralVolumeStore: NewMockVolumeStore(""),
+				inFlight:             NewInFlight(),
+				formatInFlight:       NewInFlight(),
+				osTool:               NewFakeOSTool(),
+				options:              &driverOptions{postProvisionHooks: tt.hooks, postProvisionHookTimeout: DefaultPostProvisionHookTimeout},
+			}
+			_, err := ns.NodePublishVolume(context.Background(), req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("NodePublishVolume() code = %v, want %v, error: %v", code, tt.wantCode, err)
+			}
+			if tt.failing != "" && (err == nil || !strings.Contains(err.Error(), "permission denied")) {
+				t.Errorf("NodePublishVolume() error = %v, want output of failed hook", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("NodePublishVolume() ran hooks %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_execPostProvisionHook(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "args")
+	hook := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" > "+output+"\n[ \"$2\" != fail ]\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePostProvisionHooks([]string{hook}); err != nil {
+		t.Fatalf("validatePostProvisionHooks() error = %v", err)
+	}
+	// arguments are never interpreted by shell
+	if _, err := execPostProvisionHook(context.Background(), hook, []string{"/mnt/a b", "$(id)"}); err != nil {
+		t.Fatalf("execPostProvisionHook() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "/mnt/a b $(id)\n" {
+		t.Errorf("execPostProvisionHook() hook got args %q", string(got))
+	}
+	if _, err := execPostProvisionHook(context.Background(), hook, []string{"/mnt/a", "fail"}); err == nil {
+		t.Errorf("execPostProvisionHook() error = nil, want exit error")
+	}
+}
+
+func Test_validatePostProvisionHooks(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "executable")
+	plain := filepath.Join(dir, "plain")
+	writable := filepath.Join(dir, "writable")
+	for path, mode := range map[string]os.FileMode{executable: 0755, plain: 0644, writable: 0777} {
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		// umask is not applied by chmod
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		hooks   []string
+		wantErr bool
+	}{
+		{name: "test no hook", hooks: nil},
+		{name: "test executable", hooks: []string{executable}},
+		{name: "test relative path", hooks: []string{"hooks/executable"}, wantErr: true},
+		{name: "test unclean path", hooks: []string{dir + "/../" + filepath.Base(dir) + "/executable"}, wantErr: true},
+		{name: "test not found", hooks: []string{filepath.Join(dir, "absent")}, wantErr: true},
+		{name: "test directory", hooks: []string{dir}, wantErr: true},
+		{name: "test not executable", hooks: []string{executable, plain}, wantErr: true},
+		{name: "test writable by others", hooks: []string{writable}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePostProvisionHooks(tt.hooks); (err != nil) != tt.wantErr {
+				t.Errorf("validatePostProvisionHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
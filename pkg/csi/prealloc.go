@@ -0,0 +1,520 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/client"
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// DefaultPreallocCount is the default number of unassigned lvs kept per
+	// vg and size class
+	DefaultPreallocCount = 2
+	// DefaultPreallocTTL is the default time(second) unassigned lvs are kept
+	// after the size class is last requested on the vg
+	DefaultPreallocTTL = 3600
+
+	// preallocLVNamePrefix is followed by creation time in unix nano
+	preallocLVNamePrefix = "prealloc-"
+	// preallocSyncInterval is the interval pool is refilled and reclaimed
+	preallocSyncInterval = 30 * time.Second
+)
+
+// ParsePreallocSizes parses size classes of preallocation such as 10Gi
+func ParsePreallocSizes(values []string) ([]uint64, error) {
+	sizes := []uint64{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size class %q of preallocation: %s", value, err.Error())
+		}
+		if quantity.Value() <= 0 {
+			return nil, fmt.Errorf("size class %q of preallocation must be positive", value)
+		}
+		sizes = append(sizes, uint64(quantity.Value()))
+	}
+	return sizes, nil
+}
+
+// preallocKey is a size class of a vg on node
+type preallocKey struct {
+	node string
+	vg   string
+	size uint64
+}
+
+// preallocPool keeps unassigned lvs of configured size classes on vgs where
+// the size class is requested recently. CreateVolume takes over one of them
+// by tags instead of waiting for lvcreate, the pool is refilled and lvs idle
+// for ttl are removed in background
+type preallocPool struct {
+	sizes []uint64
+	count int
+	ttl   time.Duration
+	// connect returns connection to lvmd of node
+	connect func(node string) (client.Connection, error)
+	// preallocVGs returns vgs of every node which hold preallocated lvs,
+	// lvs left by the last run of controller are reclaimed from them
+	preallocVGs func(ctx context.Context) (map[string][]string, error)
+	// checkNewLV returns error if new lv of size is not allowed in vg, the
+	// same check as lv created for volume
+	checkNewLV func(ctx context.Context, conn client.Connection, node, vg string, size uint64) error
+	// publish records unassigned lvs of vg in nls so that schedulers take
+	// them from capacity and lv limit of vg
+	publish func(ctx context.Context, node, vg string, lvs utils.PreallocatedLVs) error
+	// leading is false while controller is not the leader, pool is only
+	// synced by the leader. Nil means always leading
+	leading func() bool
+
+	lock sync.Mutex
+	// demands is the last time size class is requested
+	demands map[preallocKey]time.Time
+	// busy lvs are being assigned or removed
+	busy map[string]bool
+	// refill triggers sync after lv is assigned
+	refill chan struct{}
+}
+
+func newPreallocPool(sizes []uint64, count int, ttl time.Duration, connect func(node string) (client.Connection, error), preallocVGs func(ctx context.Context) (map[string][]string, error), checkNewLV func(ctx context.Context, conn client.Connection, node, vg string, size uint64) error, publish func(ctx context.Context, node, vg string, lvs utils.PreallocatedLVs) error) *preallocPool {
+	if len(sizes) == 0 || count <= 0 {
+		return nil
+	}
+	return &preallocPool{
+		sizes:       sizes,
+		count:       count,
+		ttl:         ttl,
+		connect:     connect,
+		preallocVGs: preallocVGs,
+		checkNewLV:  checkNewLV,
+		publish:     publish,
+		demands:     map[preallocKey]time.Time{},
+		busy:        map[string]bool{},
+		refill:      make(chan struct{}, 1),
+	}
+}
+
+// usable is true if lv of size created with parameters can be taken from
+// pool, lv with particular layout or content is always created
+func (p *preallocPool) usable(size uint64, parameters map[string]string) bool {
+	if p == nil || !p.hasSize(size) {
+		return false
+	}
+	if value, ok := parameters[LvmTypeTag]; ok && value == StripingType {
+		return false
+	}
+	return utils.GetParam(parameters, localtype.ParamZeroFill) != "true" && utils.GetParam(parameters, localtype.ParamAllocationPolicy) == ""
+}
+
+func (p *preallocPool) hasSize(size uint64) bool {
+	for _, s := range p.sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+func preallocatedTag(size uint64) string {
+	return localtype.PreallocatedLVTagPrefix + strconv.FormatUint(size, 10)
+}
+
+func preallocAssigneeTag(volumeID string) string {
+	return localtype.PreallocAssigneeLVTagPrefix + volumeID
+}
+
+func hasLVTag(lv *lib.LogicalVolume, tag string) bool {
+	for _, t := range lv.GetTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// preallocCreatedAt parses creation time from name of preallocated lv
+func preallocCreatedAt(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, preallocLVNamePrefix) {
+		return time.Time{}, false
+	}
+	nano, err := strconv.ParseInt(strings.TrimPrefix(name, preallocLVNamePrefix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nano), true
+}
+
+// isUnassigned is true for preallocated lv of size never taken by volume
+func isUnassigned(lv *lib.LogicalVolume, size uint64) bool {
+	if _, ok := preallocCreatedAt(lv.GetName()); !ok {
+		return false
+	}
+	return hasLVTag(lv, preallocatedTag(size)) && !hasLVTag(lv, localtype.ManagedLVTag)
+}
+
+func (p *preallocPool) markBusy(vg, name string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	key := utils.GetNameKey(vg, name)
+	if p.busy[key] {
+		return false
+	}
+	p.busy[key] = true
+	return true
+}
+
+func (p *preallocPool) unmarkBusy(vg, name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.busy, utils.GetNameKey(vg, name))
+}
+
+// assign takes over an unassigned lv of size in vg for volumeID, tags it with
+// tags of the request and returns its name, lv assigned by the last attempt
+// of volumeID is returned again. It returns empty name if lv named lvName
+// already exists or pool is empty
+func (p *preallocPool) assign(ctx context.Context, conn client.Connection, node, vg, volumeID, lvName string, size uint64, tags []string) (string, error) {
+	p.lock.Lock()
+	p.demands[preallocKey{node: node, vg: vg, size: size}] = time.Now()
+	p.lock.Unlock()
+
+	lvs, err := conn.ListVolumes(ctx, vg)
+	if err != nil {
+		return "", fmt.Errorf("fail to list lvs of vg %s: %s", vg, err.Error())
+	}
+	candidates := []string{}
+	for _, lv := range lvs {
+		if lv.GetName() == lvName {
+			return "", nil
+		}
+		if hasLVTag(lv, preallocAssigneeTag(volumeID)) {
+			// the last attempt is interrupted after lv is tagged
+			missing := []string{}
+			for _, tag := range tags {
+				if !hasLVTag(lv, tag) {
+					missing = append(missing, tag)
+				}
+			}
+			if len(missing) > 0 {
+				if err := conn.AddVolumeTags(ctx, vg, lv.GetName(), missing); err != nil {
+					return "", fmt.Errorf("fail to tag preallocated lv %s/%s: %s", vg, lv.GetName(), err.Error())
+				}
+			}
+			if hasLVTag(lv, preallocatedTag(size)) {
+				if err := conn.RemoveVolumeTags(ctx, vg, lv.GetName(), []string{preallocatedTag(size)}); err != nil {
+					return "", fmt.Errorf("fail to remove tag of preallocated lv %s/%s: %s", vg, lv.GetName(), err.Error())
+				}
+			}
+			return lv.GetName(), nil
+		}
+		if isUnassigned(lv, size) {
+			candidates = append(candidates, lv.GetName())
+		}
+	}
+	for _, name := range candidates {
+		if !p.markBusy(vg, name) {
+			continue
+		}
+		assigned, err := p.takeOver(ctx, conn, vg, name, volumeID, size, tags)
+		p.unmarkBusy(vg, name)
+		if err != nil || !assigned {
+			return "", err
+		}
+		log.Infof("[prealloc]lv %s/%s at node %s is assigned to volume %s", vg, name, node, volumeID)
+		p.resync()
+		return name, nil
+	}
+	return "", nil
+}
+
+// takeOver tags busy lv name with tags of the request and as assigned to
+// volumeID, it returns false if lv is removed or assigned since it is listed
+func (p *preallocPool) takeOver(ctx context.Context, conn client.Connection, vg, name, volumeID string, size uint64, tags []string) (bool, error) {
+	if unassigned, err := p.stillUnassigned(ctx, conn, vg, name, size); err != nil || !unassigned {
+		return false, err
+	}
+	tags = append(append([]string{}, tags...), preallocAssigneeTag(volumeID))
+	if !utils.ContainsString(tags, localtype.ManagedLVTag) {
+		tags = append(tags, localtype.ManagedLVTag)
+	}
+	if err := conn.AddVolumeTags(ctx, vg, name, tags); err != nil {
+		return false, fmt.Errorf("fail to tag preallocated lv %s/%s: %s", vg, name, err.Error())
+	}
+	if err := conn.RemoveVolumeTags(ctx, vg, name, []string{preallocatedTag(size)}); err != nil {
+		return false, fmt.Errorf("fail to remove tag of preallocated lv %s/%s: %s", vg, name, err.Error())
+	}
+	return true, nil
+}
+
+func (p *preallocPool) stillUnassigned(ctx context.Context, conn client.Connection, vg, name string, size uint64) (bool, error) {
+	lv, err := conn.DescribeVolume(ctx, vg, name)
+	if err != nil {
+		return false, fmt.Errorf("fail to describe preallocated lv %s/%s: %s", vg, name, err.Error())
+	}
+	return lv != nil && isUnassigned(lv, size), nil
+}
+
+// resync triggers sync at once
+func (p *preallocPool) resync() {
+	if p == nil {
+		return
+	}
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (p *preallocPool) isLeading() bool {
+	return p.leading == nil || p.leading()
+}
+
+func (p *preallocPool) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(preallocSyncInterval)
+	defer ticker.Stop()
+	for {
+		if p.isLeading() {
+			p.sync(context.Background(), time.Now())
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-p.refill:
+		}
+	}
+}
+
+// sync removes unassigned lvs idle for ttl and refills size classes
+// requested within ttl to count
+func (p *preallocPool) sync(ctx context.Context, now time.Time) {
+	p.lock.Lock()
+	demands := map[preallocKey]time.Time{}
+	targets := map[string]map[string]bool{}
+	for key, last := range p.demands {
+		if now.Sub(last) > p.ttl {
+			delete(p.demands, key)
+			continue
+		}
+		demands[key] = last
+		if targets[key.node] == nil {
+			targets[key.node] = map[string]bool{}
+		}
+		targets[key.node][key.vg] = true
+	}
+	p.lock.Unlock()
+
+	nodeVGs, err := p.preallocVGs(ctx)
+	if err != nil {
+		log.Warningf("[prealloc]fail to list vgs holding preallocated lvs: %s", err.Error())
+	}
+	for node, vgs := range nodeVGs {
+		if targets[node] == nil {
+			targets[node] = map[string]bool{}
+		}
+		for _, vg := range vgs {
+			targets[node][vg] = true
+		}
+	}
+
+	nodes := make([]string, 0, len(targets))
+	for node := range targets {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		conn, err := p.connect(node)
+		if err != nil {
+			log.Warningf("[prealloc]fail to connect to node %s: %s", node, err.Error())
+			continue
+		}
+		vgs := make([]string, 0, len(targets[node]))
+		for vg := range targets[node] {
+			vgs = append(vgs, vg)
+		}
+		sort.Strings(vgs)
+		for _, vg := range vgs {
+			if err := p.syncVG(ctx, conn, node, vg, demands, now); err != nil {
+				log.Warningf("[prealloc]fail to sync vg %s at node %s: %s", vg, node, err.Error())
+			}
+		}
+		conn.Close()
+	}
+}
+
+// syncVG syncs lvs of pool in vg, unassigned lvs are published before they
+// are created and published again as listed after sync
+func (p *preallocPool) syncVG(ctx context.Context, conn client.Connection, node, vg string, demands map[preallocKey]time.Time, now time.Time) error {
+	lvs, err := conn.ListVolumes(ctx, vg)
+	if err != nil {
+		return err
+	}
+	pooled := countUnassigned(lvs)
+	defer func() {
+		if lvs, err := conn.ListVolumes(ctx, vg); err != nil {
+			log.Warningf("[prealloc]fail to list lvs of vg %s at node %s: %s", vg, node, err.Error())
+		} else {
+			pooled = countUnassigned(lvs)
+		}
+		if err := p.publish(ctx, node, vg, pooled); err != nil {
+			log.Warningf("[prealloc]fail to publish preallocated lvs of vg %s at node %s: %s", vg, node, err.Error())
+		}
+	}()
+	seq := int64(0)
+	for _, size := range p.sizes {
+		key := preallocKey{node: node, vg: vg, size: size}
+		last, requested := demands[key]
+		kept := 0
+		for _, lv := range lvs {
+			if !isUnassigned(lv, size) {
+				continue
+			}
+			created, _ := preallocCreatedAt(lv.GetName())
+			if created.After(last) {
+				last = created
+			}
+			if now.Sub(last) <= p.ttl && kept < p.count {
+				kept++
+				continue
+			}
+			if !p.markBusy(vg, lv.GetName()) {
+				continue
+			}
+			p.reclaim(ctx, conn, node, vg, lv.GetName(), size)
+			p.unmarkBusy(vg, lv.GetName())
+		}
+		if !requested {
+			continue
+		}
+		for ; kept < p.count; kept++ {
+			if !p.isLeading() {
+				return fmt.Errorf("stop preallocating lv of size %d: controller is not the leader", size)
+			}
+			if err := p.checkNewLV(ctx, conn, node, vg, size); err != nil {
+				return fmt.Errorf("stop preallocating lv of size %d: %s", size, err.Error())
+			}
+			// schedulers must not place volumes on space of lv being created
+			pooled.Count++
+			pooled.Size += int64(size)
+			if err := p.publish(ctx, node, vg, pooled); err != nil {
+				return fmt.Errorf("fail to publish preallocated lvs: %s", err.Error())
+			}
+			seq++
+			options := &client.LVMOptions{
+				VolumeGroup: vg,
+				Name:        preallocLVNamePrefix + strconv.FormatInt(now.UnixNano()+seq, 10),
+				Size:        size,
+				Tags:        []string{preallocatedTag(size)},
+			}
+			if _, _, err := conn.CreateVolume(ctx, options); err != nil {
+				return fmt.Errorf("fail to create preallocated lv of size %d: %s", size, err.Error())
+			}
+			log.Infof("[prealloc]lv %s/%s of size %d is preallocated at node %s", vg, options.Name, size, node)
+		}
+	}
+	return nil
+}
+
+// countUnassigned returns number and size of unassigned lvs of pool in lvs
+func countUnassigned(lvs []*lib.LogicalVolume) utils.PreallocatedLVs {
+	pooled := utils.PreallocatedLVs{}
+	for _, lv := range lvs {
+		if _, ok := preallocCreatedAt(lv.GetName()); ok && utils.IsUnassignedPreallocatedLV(lv.GetTags()) {
+			pooled.Count++
+			pooled.Size += int64(lv.GetSize())
+		}
+	}
+	return pooled
+}
+
+// reclaim removes busy lv name if it is still unassigned
+func (p *preallocPool) reclaim(ctx context.Context, conn client.Connection, node, vg, name string, size uint64) {
+	if unassigned, err := p.stillUnassigned(ctx, conn, vg, name, size); err != nil || !unassigned {
+		if err != nil {
+			log.Warningf("[prealloc]%s", err.Error())
+		}
+		return
+	}
+	if err := conn.DeleteVolume(ctx, vg, name); err != nil {
+		log.Warningf("[prealloc]fail to remove idle lv %s/%s at node %s: %s", vg, name, node, err.Error())
+		return
+	}
+	log.Infof("[prealloc]idle lv %s/%s at node %s is removed", vg, name, node)
+}
+
+// preallocVGs returns vgs of every node which hold preallocated lvs reported
+// or published in nls
+func (cs *controllerServer) preallocVGs(ctx context.Context) (map[string][]string, error) {
+	nlsList, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodeVGs := map[string][]string{}
+	for _, nls := range nlsList.Items {
+		// vgs published may have lost their lvs
+		for vg := range utils.GetPreallocatedLVs(&nls) {
+			nodeVGs[nls.Name] = append(nodeVGs[nls.Name], vg)
+		}
+		for _, vg := range nls.Status.NodeStorageInfo.VolumeGroups {
+			for _, lv := range vg.LogicalVolumes {
+				if _, ok := preallocCreatedAt(lv.Name); ok {
+					nodeVGs[nls.Name] = append(nodeVGs[nls.Name], vg.Name)
+					break
+				}
+			}
+		}
+	}
+	return nodeVGs, nil
+}
+
+// setPreallocatedLVs records unassigned preallocated lvs of vg in nls of node,
+// node without nls is skipped
+func (cs *controllerServer) setPreallocatedLVs(ctx context.Context, nodeName, vgName string, lvs utils.PreallocatedLVs) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		nls, err := cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if !utils.SetPreallocatedLVs(nls, vgName, lvs) {
+			return nil
+		}
+		_, err = cs.options.localclient.CsiV1alpha1().NodeLocalStorages().Update(ctx, nls, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,311 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/client"
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
+)
+
+const gib = uint64(1024 * 1024 * 1024)
+
+// fakePreallocConnection serves lvs of vg "vg" kept in lvs, lvs published by
+// pool are recorded in published
+type fakePreallocConnection struct {
+	client.Connection
+	lvs       map[string]*lib.LogicalVolume
+	created   []string
+	deleted   []string
+	published []utils.PreallocatedLVs
+}
+
+func (conn *fakePreallocConnection) ListVolumes(ctx context.Context, volGroup string) ([]*lib.LogicalVolume, error) {
+	names := []string{}
+	for name := range conn.lvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lvs := []*lib.LogicalVolume{}
+	for _, name := range names {
+		lvs = append(lvs, conn.lvs[name])
+	}
+	return lvs, nil
+}
+
+func (conn *fakePreallocConnection) DescribeVolume(ctx context.Context, volGroup string, volumeID string) (*lib.LogicalVolume, error) {
+	return conn.lvs[volumeID], nil
+}
+
+func (conn *fakePreallocConnection) AddVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error {
+	lv, ok := conn.lvs[volumeID]
+	if !ok {
+		return fmt.Errorf("lv %s not found", volumeID)
+	}
+	lv.Tags = append(lv.Tags, tags...)
+	return nil
+}
+
+func (conn *fakePreallocConnection) RemoveVolumeTags(ctx context.Context, volGroup string, volumeID string, tags []string) error {
+	lv, ok := conn.lvs[volumeID]
+	if !ok {
+		return fmt.Errorf("lv %s not found", volumeID)
+	}
+	removed := map[string]bool{}
+	for _, t := range tags {
+		removed[t] = true
+	}
+	kept := []string{}
+	for _, t := range lv.Tags {
+		if !removed[t] {
+			kept = append(kept, t)
+		}
+	}
+	lv.Tags = kept
+	return nil
+}
+
+func (conn *fakePreallocConnection) CreateVolume(ctx context.Context, opt *client.LVMOptions) (string, string, error) {
+	conn.lvs[opt.Name] = &lib.LogicalVolume{Name: opt.Name, Size: opt.Size, Tags: opt.Tags}
+	conn.created = append(conn.created, opt.Name)
+	return "", "", nil
+}
+
+func (conn *fakePreallocConnection) DeleteVolume(ctx context.Context, volGroup string, volumeID string) error {
+	delete(conn.lvs, volumeID)
+	conn.deleted = append(conn.deleted, volumeID)
+	return nil
+}
+
+func (conn *fakePreallocConnection) Close() error {
+	return nil
+}
+
+func preallocLV(created time.Time, size uint64, tags ...string) *lib.LogicalVolume {
+	return &lib.LogicalVolume{
+		Name: fmt.Sprintf("%s%d", preallocLVNamePrefix, created.UnixNano()),
+		Size: size,
+		Tags: append([]string{preallocatedTag(size)}, tags...),
+	}
+}
+
+func newFakePreallocPool(conn *fakePreallocConnection, nodeVGs map[string][]string) *preallocPool {
+	return newPreallocPool([]uint64{10 * gib}, 2, time.Hour, func(node string) (client.Connection, error) {
+		return conn, nil
+	}, func(ctx context.Context) (map[string][]string, error) {
+		return nodeVGs, nil
+	}, func(ctx context.Context, conn client.Connection, node, vg string, size uint64) error {
+		return nil
+	}, func(ctx context.Context, node, vg string, lvs utils.PreallocatedLVs) error {
+		conn.published = append(conn.published, lvs)
+		return nil
+	})
+}
+
+func Test_ParsePreallocSizes(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []uint64
+		wantErr bool
+	}{
+		{values: nil, want: []uint64{}},
+		{values: []string{"10Gi", " 1Gi", ""}, want: []uint64{10 * gib, gib}},
+		{values: []string{"ten"}, wantErr: true},
+		{values: []string{"0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePreallocSizes(tt.values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePreallocSizes(%v) error = %v, wantErr %v", tt.values, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePreallocSizes(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func Test_preallocPool_usable(t *testing.T) {
+	pool := newFakePreallocPool(nil, nil)
+	tests := []struct {
+		name   string
+		pool   *preallocPool
+		size   uint64
+		params map[string]string
+		want   bool
+	}{
+		{name: "disabled", pool: nil, size: 10 * gib, want: false},
+		{name: "size class", pool: pool, size: 10 * gib, want: true},
+		{name: "other size", pool: pool, size: 5 * gib, want: false},
+		{name: "striping", pool: pool, size: 10 * gib, params: map[string]string{LvmTypeTag: StripingType}, want: false},
+		{name: "zero fill", pool: pool, size: 10 * gib, params: map[string]string{pkg.ParamZeroFill: "true"}, want: false},
+		{name: "allocation policy", pool: pool, size: 10 * gib, params: map[string]string{pkg.ParamAllocationPolicy: "contiguous"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.pool.usable(tt.size, tt.params); got != tt.want {
+			t.Errorf("%s: preallocPool.usable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_preallocPool_assign(t *testing.T) {
+	now := time.Now()
+	free := preallocLV(now, 10*gib)
+	small := preallocLV(now.Add(time.Second), 5*gib)
+	conn := &fakePreallocConnection{lvs: map[string]*lib.LogicalVolume{
+		free.Name:  free,
+		small.Name: small,
+		"exist-lv": {Name: "exist-lv", Size: 10 * gib, Tags: []string{pkg.ManagedLVTag}},
+	}}
+	pool := newFakePreallocPool(conn, nil)
+	tags := func(volumeID string) []string {
+		return []string{pkg.ManagedLVTag, createdForTag(volumeID), pkg.DiscardOnDeleteLVTag}
+	}
+
+	// lv of the volume already exists
+	if got, err := pool.assign(context.Background(), conn, "node1", "vg", "pv-1", "exist-lv", 10*gib, tags("pv-1")); err != nil || got != "" {
+		t.Fatalf("assign() of existing lv = %q, %v, want empty", got, err)
+	}
+	got, err := pool.assign(context.Background(), conn, "node1", "vg", "pv-2", "pv-2", 10*gib, tags("pv-2"))
+	if err != nil || got != free.Name {
+		t.Fatalf("assign() = %q, %v, want %q", got, err, free.Name)
+	}
+	wantTags := append(tags("pv-2"), preallocAssigneeTag("pv-2"))
+	if !reflect.DeepEqual(free.Tags, wantTags) {
+		t.Errorf("tags of assigned lv = %v, want %v", free.Tags, wantTags)
+	}
+	if utils.IsUnassignedPreallocatedLV(free.Tags) {
+		t.Errorf("assigned lv %s is still unassigned", free.Name)
+	}
+	// retry of the same volume gets the same lv, tags lost by the last
+	// attempt are added
+	free.Tags = []string{pkg.ManagedLVTag, preallocAssigneeTag("pv-2"), preallocatedTag(10 * gib)}
+	if got, err := pool.assign(context.Background(), conn, "node1", "vg", "pv-2", "pv-2", 10*gib, tags("pv-2")); err != nil || got != free.Name {
+		t.Errorf("assign() retry = %q, %v, want %q", got, err, free.Name)
+	}
+	wantTags = []string{pkg.ManagedLVTag, preallocAssigneeTag("pv-2"), createdForTag("pv-2"), pkg.DiscardOnDeleteLVTag}
+	if !reflect.DeepEqual(free.Tags, wantTags) {
+		t.Errorf("tags of lv assigned again = %v, want %v", free.Tags, wantTags)
+	}
+	// pool of the size class is empty
+	if got, err := pool.assign(context.Background(), conn, "node1", "vg", "pv-3", "pv-3", 10*gib, tags("pv-3")); err != nil || got != "" {
+		t.Errorf("assign() of empty pool = %q, %v, want empty", got, err)
+	}
+	if _, ok := pool.demands[preallocKey{node: "node1", vg: "vg", size: 10 * gib}]; !ok {
+		t.Errorf("demand of size class is not recorded")
+	}
+	select {
+	case <-pool.refill:
+	default:
+		t.Errorf("refill is not triggered after lv is assigned")
+	}
+}
+
+func Test_preallocPool_sync(t *testing.T) {
+	now := time.Now()
+	fresh := preallocLV(now.Add(-time.Minute), 10*gib)
+	idle := preallocLV(now.Add(-2*time.Hour), 10*gib)
+	assigned := preallocLV(now.Add(-3*time.Hour), 10*gib, pkg.ManagedLVTag, preallocAssigneeTag("pv-1"))
+	assigned.Tags = assigned.Tags[1:]
+
+	t.Run("refill", func(t *testing.T) {
+		conn := &fakePreallocConnection{lvs: map[string]*lib.LogicalVolume{fresh.Name: fresh}}
+		pool := newFakePreallocPool(conn, nil)
+		pool.demands[preallocKey{node: "node1", vg: "vg", size: 10 * gib}] = now.Add(-time.Minute)
+		pool.sync(context.Background(), now)
+		if len(conn.created) != 1 || len(conn.deleted) != 0 {
+			t.Fatalf("sync() created %v and deleted %v, want 1 created", conn.created, conn.deleted)
+		}
+		lv := conn.lvs[conn.created[0]]
+		if lv.Size != 10*gib || !isUnassigned(lv, 10*gib) {
+			t.Errorf("preallocated lv = %+v, want unassigned lv of 10Gi", lv)
+		}
+		// lv is published before it is created
+		want := utils.PreallocatedLVs{Count: 2, Size: int64(20 * gib)}
+		if !reflect.DeepEqual(conn.published, []utils.PreallocatedLVs{want, want}) {
+			t.Errorf("sync() published %v, want %v before and after lv is created", conn.published, want)
+		}
+		// pool is full
+		pool.sync(context.Background(), now)
+		if len(conn.created) != 1 {
+			t.Errorf("sync() of full pool created %v", conn.created)
+		}
+	})
+
+	t.Run("refill stops when vg rejects new lv", func(t *testing.T) {
+		conn := &fakePreallocConnection{lvs: map[string]*lib.LogicalVolume{}}
+		pool := newFakePreallocPool(conn, nil)
+		pool.checkNewLV = func(ctx context.Context, conn client.Connection, node, vg string, size uint64) error {
+			return fmt.Errorf("vg %s is full", vg)
+		}
+		pool.demands[preallocKey{node: "node1", vg: "vg", size: 10 * gib}] = now
+		pool.sync(context.Background(), now)
+		if len(conn.created) != 0 {
+			t.Errorf("sync() created %v rejected by vg", conn.created)
+		}
+		if !reflect.DeepEqual(conn.published, []utils.PreallocatedLVs{{}}) {
+			t.Errorf("sync() published %v, want empty pool", conn.published)
+		}
+	})
+
+	t.Run("refill stops when leadership is lost", func(t *testing.T) {
+		conn := &fakePreallocConnection{lvs: map[string]*lib.LogicalVolume{}}
+		pool := newFakePreallocPool(conn, nil)
+		pool.leading = func() bool { return false }
+		pool.demands[preallocKey{node: "node1", vg: "vg", size: 10 * gib}] = now
+		pool.sync(context.Background(), now)
+		if len(conn.created) != 0 {
+			t.Errorf("sync() created %v while not leading", conn.created)
+		}
+	})
+
+	t.Run("reclaim", func(t *testing.T) {
+		conn := &fakePreallocConnection{lvs: map[string]*lib.LogicalVolume{
+			fresh.Name:    fresh,
+			idle.Name:     idle,
+			assigned.Name: assigned,
+		}}
+		// demand expires and the controller restarts, lvs are found from nls
+		pool := newFakePreallocPool(conn, map[string][]string{"node1": {"vg"}})
+		pool.demands[preallocKey{node: "node1", vg: "vg", size: 10 * gib}] = now.Add(-2 * time.Hour)
+		pool.sync(context.Background(), now)
+		if !reflect.DeepEqual(conn.deleted, []string{idle.Name}) || len(conn.created) != 0 {
+			t.Errorf("sync() deleted %v and created %v, want %s deleted", conn.deleted, conn.created, idle.Name)
+		}
+		if want := (utils.PreallocatedLVs{Count: 1, Size: int64(10 * gib)}); !reflect.DeepEqual(conn.published, []utils.PreallocatedLVs{want}) {
+			t.Errorf("sync() published %v, want %v", conn.published, want)
+		}
+		if len(pool.demands) != 0 {
+			t.Errorf("expired demands %v are kept", pool.demands)
+		}
+		pool.sync(context.Background(), now.Add(2*time.Hour))
+		if !reflect.DeepEqual(conn.deleted, []string{idle.Name, fresh.Name}) {
+			t.Errorf("sync() deleted %v, want %s deleted after ttl", conn.deleted, fresh.Name)
+		}
+		if _, ok := conn.lvs[assigned.Name]; !ok {
+			t.Errorf("assigned lv %s is deleted", assigned.Name)
+		}
+	})
+}
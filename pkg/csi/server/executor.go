@@ -0,0 +1,127 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+)
+
+// DefaultRemoteLVMPort is the default ssh port of remote lvm host
+const DefaultRemoteLVMPort = 22
+
+// Executor runs shell commands of lvm operations, LvmCommads runs them on
+// local node by default
+type Executor interface {
+	Run(ctx context.Context, cmd string) (string, error)
+}
+
+// LocalExecutor runs commands on local node, in mount namespace of host
+// entered by nsenter
+type LocalExecutor struct{}
+
+// Run runs cmd on local node
+func (LocalExecutor) Run(ctx context.Context, cmd string) (string, error) {
+	return utils.RunContext(ctx, cmd)
+}
+
+// RemoteExecutor runs commands on remote lvm host over ssh, so that lvm
+// storage of host without open-local agent is provisioned by lvmd running
+// elsewhere. Host key must be in KnownHostsFile, key of unknown host is never
+// accepted
+type RemoteExecutor struct {
+	// Host is the ssh destination, user@host or host
+	Host string
+	// Port is the ssh port of Host
+	Port int
+	// KeyFile is the path of private key authenticating to Host
+	KeyFile string
+	// KnownHostsFile is the path of known_hosts file holding key of Host
+	KnownHostsFile string
+	// transport runs ssh command, can be replaced in unit test
+	transport func(ctx context.Context, cmd string) (string, error)
+}
+
+// NewRemoteExecutor returns RemoteExecutor of host, port 0 means
+// DefaultRemoteLVMPort
+func NewRemoteExecutor(host string, port int, keyFile, knownHostsFile string) (*RemoteExecutor, error) {
+	if host == "" {
+		return nil, errors.New("remote lvm host must not be empty")
+	}
+	if strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid remote lvm host %q", host)
+	}
+	if keyFile == "" || knownHostsFile == "" {
+		return nil, fmt.Errorf("key file and known_hosts file of remote lvm host %s must not be empty", host)
+	}
+	if port == 0 {
+		port = DefaultRemoteLVMPort
+	}
+	return &RemoteExecutor{
+		Host:           host,
+		Port:           port,
+		KeyFile:        keyFile,
+		KnownHostsFile: knownHostsFile,
+		transport:      utils.RunContext,
+	}, nil
+}
+
+// Run runs cmd on remote host. nsenter prefix of cmd is dropped since remote
+// shell already runs in namespaces of host
+func (e *RemoteExecutor) Run(ctx context.Context, cmd string) (string, error) {
+	return e.transport(ctx, e.sshCmd(cmd))
+}
+
+// sshCmd returns ssh command running cmd on remote host in batch mode
+func (e *RemoteExecutor) sshCmd(cmd string) string {
+	cmd = strings.TrimSpace(strings.ReplaceAll(cmd, localtype.NsenterCmd, ""))
+	args := []string{"ssh",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=" + shellQuote(e.KnownHostsFile),
+		"-i", shellQuote(e.KeyFile),
+		"-p", strconv.Itoa(e.Port),
+		shellQuote(e.Host),
+		"--", shellQuote(cmd)}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s as a single word of sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// lvmCmd returns shell command of lvm cmd, built like lvm commands of agent so
+// that lvmd honors the same lvm system dir and locking dir
+func lvmCmd(cmd string, args ...string) string {
+	return lvm.Command(cmd, args...)
+}
+
+// SetExecutor makes lvm operations run by executor
+func SetExecutor(executor Executor) {
+	cmdRunner = func(cmd string) (string, error) {
+		return executor.Run(context.Background(), cmd)
+	}
+	cmdContextRunner = executor.Run
+}
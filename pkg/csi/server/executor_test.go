@@ -0,0 +1,291 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeRemoteHost is the remote lvm host reached by ssh, it records commands
+// run on it
+type fakeRemoteHost struct {
+	// ssh command before the remote command
+	ssh  []string
+	cmds []string
+	// lv reported by lvs listing lvs
+	lvs string
+	// pv reported by pvs listing pvs of vg
+	pvs string
+}
+
+func (h *fakeRemoteHost) transport(ctx context.Context, cmd string) (string, error) {
+	idx := strings.Index(cmd, " -- ")
+	h.ssh = append(h.ssh, cmd[:idx])
+	quoted := cmd[idx+len(" -- "):]
+	remote := strings.ReplaceAll(quoted[1:len(quoted)-1], `'\''`, "'")
+	h.cmds = append(h.cmds, remote)
+	if strings.HasPrefix(remote, "lvs --units=b") {
+		return h.lvs, nil
+	}
+	if strings.HasPrefix(remote, "pvs --units=b --separator") {
+		return h.pvs, nil
+	}
+	return "", nil
+}
+
+func Test_RemoteExecutor_LvmCmd(t *testing.T) {
+	newLV := func(tags string) string {
+		return strings.Join([]string{"LVM2_LV_NAME='lv'", "LVM2_LV_SIZE='1024'", "LVM2_LV_UUID='uuid'", "LVM2_LV_ATTR='-wi-a-----'",
+			"LVM2_COPY_PERCENT=''", "LVM2_LV_KERNEL_MAJOR='253'", "LVM2_LV_KERNEL_MINOR='0'", "LVM2_LV_TAGS='" + tags + "'"}, localtype.Separator) + "\n"
+	}
+	newPV := func(name string) string {
+		return strings.Join([]string{"LVM2_PV_NAME='" + name + "'", "LVM2_PV_SIZE='4096'", "LVM2_PV_FREE='4096'", "LVM2_PV_UUID='uuid'",
+			"LVM2_PV_TAGS=''", "LVM2_VG_NAME='vg'"}, localtype.Separator)
+	}
+	listLV := `lvs --units=b --separator="<:SEP:>" --nosuffix --noheadings -o lv_name,lv_size,lv_uuid,lv_attr,copy_percent,lv_kernel_major,lv_kernel_minor,lv_tags --nameprefixes -a vg/lv`
+	listPV := `pvs --units=b --separator="<:SEP:>" --nosuffix --noheadings -o pv_name,pv_size,pv_free,pv_uuid,pv_tags,vg_name -S vg_name=vg --nameprefixes -a`
+	tests := []struct {
+		name     string
+		lvTags   string
+		op       func(cmd LvmCmd) error
+		wantCmds []string
+	}{
+		{
+			name: "test create lv",
+			op: func(cmd LvmCmd) error {
+				_, err := cmd.CreateLV(context.Background(), "vg", "lv", 1024, 0, []string{"tag"}, false, "")
+				return err
+			},
+			wantCmds: []string{"lvcreate -n lv -L 1024b -W y -y --add-tag tag vg"},
+		},
+		{
+			name: "test create striped lv with default stripe size",
+			op: func(cmd LvmCmd) error {
+				_, err := cmd.CreateLV(context.Background(), "vg", "lv", 1024, 0, nil, true, "")
+				return err
+			},
+			wantCmds: []string{listPV, "lvcreate -n lv -L 1024b -W y -y -i 2 vg"},
+		},
+		{
+			name: "test expand lv",
+			op: func(cmd LvmCmd) error {
+				_, err := cmd.ExpandLV(context.Background(), "vg", "lv", 2048)
+				return err
+			},
+			wantCmds: []string{listLV, "lvextend -L2048B vg/lv"},
+		},
+		{
+			name: "test remove lv",
+			op: func(cmd LvmCmd) error {
+				_, err := cmd.RemoveLV(context.Background(), "vg", "lv")
+				return err
+			},
+			wantCmds: []string{
+				listLV,
+				"lvs -o lv_name,lv_tags -S origin=lv,vg_name=vg --noheadings --nosuffix",
+				"lvremove -v -f vg/lv",
+			},
+		},
+		{
+			name:   "test remove lv discarded on delete without discard",
+			lvTags: localtype.DiscardOnDeleteLVTag,
+			op: func(cmd LvmCmd) error {
+				_, err := cmd.RemoveLV(context.Background(), "vg", "lv")
+				return err
+			},
+			wantCmds: []string{
+				listLV,
+				"lvs -o lv_name,lv_tags -S origin=lv,vg_name=vg --noheadings --nosuffix",
+				"lvremove -v -f vg/lv",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := &fakeRemoteHost{lvs: newLV(tt.lvTags), pvs: newPV("/dev/sda") + "\n" + newPV("/dev/sdb")}
+			executor, err := NewRemoteExecutor("root@10.0.0.1", 0, "/etc/open-local/id_rsa", "/etc/open-local/known_hosts")
+			if err != nil {
+				t.Fatalf("NewRemoteExecutor() error = %v", err)
+			}
+			executor.transport = host.transport
+			originRunner, originContextRunner := cmdRunner, cmdContextRunner
+			SetExecutor(executor)
+			defer func() { cmdRunner, cmdContextRunner = originRunner, originContextRunner }()
+
+			// sysfs of local node tells device 253:0 supports discard
+			sysPath := t.TempDir()
+			queue := filepath.Join(sysPath, "dev/block/253:0/queue")
+			if err := os.MkdirAll(queue, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(queue, "discard_max_bytes"), []byte("4096"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.op(&LvmCommads{sysPath: sysPath, remote: true}); err != nil {
+				t.Fatalf("op error = %v", err)
+			}
+			if !reflect.DeepEqual(host.cmds, tt.wantCmds) {
+				t.Errorf("remote cmds = %v, want %v", host.cmds, tt.wantCmds)
+			}
+			wantSSH := "ssh -o BatchMode=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile='/etc/open-local/known_hosts' -i '/etc/open-local/id_rsa' -p 22 'root@10.0.0.1'"
+			for _, ssh := range host.ssh {
+				if ssh != wantSSH {
+					t.Errorf("ssh = %s, want %s", ssh, wantSSH)
+				}
+			}
+		})
+	}
+}
+
+func Test_RemoteExecutor_LocalOnlyOps(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       func(svr Server) error
+		wantCode codes.Code
+	}{
+		{
+			name: "test rw snapshot refused",
+			op: func(svr Server) error {
+				_, err := svr.CreateSnapshot(context.Background(), &lib.CreateSnapshotRequest{VgName: "vg", SnapshotName: "snap", SrcVolumeName: "lv"})
+				return err
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "test io throttling refused",
+			op: func(svr Server) error {
+				_, err := svr.SetIOThrottling(context.Background(), &lib.SetIOThrottlingRequest{VolumeGroup: "vg", Name: "lv", Iops: "1024"})
+				return err
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := &fakeRemoteHost{}
+			executor, err := NewRemoteExecutor("root@10.0.0.1", 0, "/etc/open-local/id_rsa", "/etc/open-local/known_hosts")
+			if err != nil {
+				t.Fatalf("NewRemoteExecutor() error = %v", err)
+			}
+			executor.transport = host.transport
+			originRunner, originContextRunner := cmdRunner, cmdContextRunner
+			SetExecutor(executor)
+			defer func() { cmdRunner, cmdContextRunner = originRunner, originContextRunner }()
+
+			svr := NewServer(&LvmCommads{remote: true})
+			if err := tt.op(svr); status.Code(err) != tt.wantCode {
+				t.Errorf("op error = %v, want code %v", err, tt.wantCode)
+			}
+			if len(host.cmds) != 0 {
+				t.Errorf("remote cmds = %v, want none", host.cmds)
+			}
+		})
+	}
+
+	if _, err := (&LvmCommads{remote: true}).GetIOAlignment(context.Background(), "vg", "lv"); !errors.Is(err, ErrRemoteUnsupported) {
+		t.Errorf("GetIOAlignment() error = %v, want %v", err, ErrRemoteUnsupported)
+	}
+}
+
+func Test_NewRemoteExecutor(t *testing.T) {
+	tests := []struct {
+		name           string
+		host           string
+		keyFile        string
+		knownHostsFile string
+		wantErr        bool
+	}{
+		{
+			name:           "test valid host",
+			host:           "root@10.0.0.1",
+			keyFile:        "/key",
+			knownHostsFile: "/known_hosts",
+		},
+		{
+			name:           "test empty host",
+			keyFile:        "/key",
+			knownHostsFile: "/known_hosts",
+			wantErr:        true,
+		},
+		{
+			name:           "test host as ssh option",
+			host:           "-oProxyCommand=sh",
+			keyFile:        "/key",
+			knownHostsFile: "/known_hosts",
+			wantErr:        true,
+		},
+		{
+			name:    "test no known_hosts file",
+			host:    "root@10.0.0.1",
+			keyFile: "/key",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRemoteExecutor(tt.host, 0, tt.keyFile, tt.knownHostsFile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRemoteExecutor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_shellQuote(t *testing.T) {
+	for _, s := range []string{"lvs vg/lv", `lvs --separator="<:SEP:>"`, "echo 'a b' | sha256sum", "it's"} {
+		out, err := utils.Run("printf '%s' " + shellQuote(s))
+		if err != nil {
+			t.Fatalf("run quoted %s error = %v", s, err)
+		}
+		if out != s {
+			t.Errorf("shellQuote(%s) is unquoted by sh as %s", s, out)
+		}
+	}
+}
+
+func Test_LvmCommads_CommandConfig(t *testing.T) {
+	lvm.SetCommandConfig(lvm.CommandConfig{SystemDir: "/etc/open-local/lvm", LockingDir: "/run/open-local/lock"})
+	defer lvm.SetCommandConfig(lvm.CommandConfig{})
+	var cmds []string
+	originRunner := cmdRunner
+	cmdRunner = func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return "", nil
+	}
+	defer func() { cmdRunner = originRunner }()
+
+	if _, err := (&LvmCommads{}).CreateLV(context.Background(), "vg", "lv", 1024, 0, nil, false, ""); err != nil {
+		t.Fatalf("CreateLV() error = %v", err)
+	}
+	want := "LVM_SYSTEM_DIR=/etc/open-local/lvm " + localtype.NsenterCmd + " lvcreate --config 'global{locking_dir=\"/run/open-local/lock\"}' -n lv"
+	if len(cmds) != 1 || !strings.HasPrefix(cmds[0], want) {
+		t.Errorf("CreateLV() cmds = %v, want prefix %s", cmds, want)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"errors"
 
 	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
 	"golang.org/x/net/context"
 )
 
@@ -38,22 +39,31 @@ func (fake *FakeCommands) ListLV(listspec string) ([]*lib.LV, error) {
 		},
 	}, nil
 }
-func (fake *FakeCommands) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool) (string, error) {
+func (fake *FakeCommands) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool, allocation string) (string, error) {
 	if size == 0 {
 		return "", errors.New("size must be greater than 0")
 	}
 	return "CreateLV", nil
 }
+func (fake *FakeCommands) GetIOAlignment(ctx context.Context, vg string, name string) (utils.IOAlignment, error) {
+	return utils.IOAlignment{}, nil
+}
 func (fake *FakeCommands) RemoveLV(ctx context.Context, vg string, name string) (string, error) {
 	return "RemoveLV", nil
 }
-func (fake *FakeCommands) CloneLV(ctx context.Context, src, dest string) (string, error) {
+func (fake *FakeCommands) RenameLV(ctx context.Context, vg string, name string, newName string) (string, error) {
+	return "RenameLV", nil
+}
+func (fake *FakeCommands) CloneLV(ctx context.Context, src, dest string, verifyChecksum bool) (string, error) {
 	return "CloneLV", nil
 }
+func (fake *FakeCommands) ZeroLV(ctx context.Context, vg string, name string) (string, error) {
+	return "ZeroLV", nil
+}
 func (fake *FakeCommands) ExpandLV(ctx context.Context, vgName string, volumeId string, expectSize uint64) (string, error) {
 	return "ExpandLV", nil
 }
-func (fake *FakeCommands) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error) {
+func (fake *FakeCommands) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error) {
 	return 0, nil
 }
 func (fake *FakeCommands) RemoveSnapshot(ctx context.Context, vg string, name string, readonly bool) (string, error) {
@@ -85,3 +95,7 @@ func (fake *FakeCommands) CleanPath(ctx context.Context, path string) error {
 func (fake *FakeCommands) CleanDevice(ctx context.Context, device string) (string, error) {
 	return "CleanDevice", nil
 }
+
+func (fake *FakeCommands) SetIOThrottling(ctx context.Context, vg string, name string, iops string, bps string) ([]string, error) {
+	return []string{}, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	log "k8s.io/klog/v2"
+)
+
+// blockPublishDir is where kubelet publishes block volume for pods, in form
+// of <blockPublishDir>/<pv name>/<pod uid>
+const blockPublishDir = "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish"
+
+var (
+	// replaced in unit test
+	lvDeviceNumber   = deviceNumber
+	lvPublishedPaths = publishedPaths
+)
+
+// SetIOThrottling replaces io throttling of lv in cgroup of every pod which
+// the lv is published to, it takes effect without remounting the volume
+func (lvm *LvmCommads) SetIOThrottling(ctx context.Context, vg string, name string, iops string, bps string) ([]string, error) {
+	// pods using lv of remote lvm host do not run in cgroups of local node
+	if lvm.remote {
+		return nil, fmt.Errorf("io throttling: %w", ErrRemoteUnsupported)
+	}
+	dev := filepath.Join("/dev", vg, name)
+	maj, min, err := lvDeviceNumber(dev)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := lvPublishedPaths(dev, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find target paths of %s: %s", dev, err.Error())
+	}
+	podUIDs := map[string]bool{}
+	for _, target := range targets {
+		if isPodTargetPath(target) {
+			podUIDs[utils.PodUIDFromTargetPath(target)] = true
+		}
+	}
+	if len(podUIDs) == 0 {
+		log.Infof("lv %s is not published to any pod, skip io throttling", utils.GetNameKey(vg, name))
+		return nil, nil
+	}
+	// qos class of pod decides its cgroup path
+	pods, err := lvm.kubeclient.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector:   "spec.nodeName=" + os.Getenv("KUBE_NODE_NAME"),
+		ResourceVersion: "0",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %s", err.Error())
+	}
+	throttled := []string{}
+	for _, pod := range pods.Items {
+		if !podUIDs[string(pod.UID)] {
+			continue
+		}
+		podName := utils.GetNameKey(pod.Namespace, pod.Name)
+		written, err := utils.ModifyPodIOThrottling(lvm.sysPath, maj, min, pod.Status.QOSClass, string(pod.UID), iops, bps)
+		if err != nil {
+			return throttled, fmt.Errorf("failed to set io throttling of %s for pod %s: %s", dev, podName, err.Error())
+		}
+		if !written {
+			log.Warningf("io controller is not available for pod %s, skip io throttling of %s", podName, dev)
+			continue
+		}
+		throttled = append(throttled, podName)
+	}
+	sort.Strings(throttled)
+	return throttled, nil
+}
+
+// isPodTargetPath is true for target path of filesystem or block volume
+// published to pod, rather than staging path shared by pods
+func isPodTargetPath(path string) bool {
+	return strings.HasPrefix(path, "/var/lib/kubelet/pods/") || strings.HasPrefix(path, blockPublishDir+"/")
+}
+
+func deviceNumber(dev string) (uint64, uint64, error) {
+	stat := syscall.Stat_t{}
+	if err := syscall.Stat(dev, &stat); err != nil {
+		return 0, 0, err
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return 0, 0, fmt.Errorf("%s is not a block device", dev)
+	}
+	return uint64(unix.Major(uint64(stat.Rdev))), uint64(unix.Minor(uint64(stat.Rdev))), nil
+}
+
+// publishedPaths returns mount points of dev and the files kubelet publishes
+// block volume name with
+func publishedPaths(dev, name string) ([]string, error) {
+	// findmnt exits with 1 if nothing is found
+	out, err := cmdRunner(fmt.Sprintf("%s findmnt -n -o TARGET -S %s || true", localtype.NsenterCmd, dev))
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := filepath.Glob(filepath.Join(blockPublishDir, name, "*"))
+	if err != nil {
+		return nil, err
+	}
+	return append(strings.Fields(out), blocks...), nil
+}
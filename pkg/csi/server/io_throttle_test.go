@@ -0,0 +1,109 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLvmCommads_SetIOThrottling(t *testing.T) {
+	fsPodUID := "2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f"
+	blockPodUID := "76cf946e-d074-4455-a272-4d3a81264fab"
+	idlePodUID := "0f2d4d2e-6bc7-4a56-9d5b-3c1e2a1b7f00"
+	newPod := func(name, uid string, qosClass corev1.PodQOSClass) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)},
+			Status:     corev1.PodStatus{QOSClass: qosClass},
+		}
+	}
+	pods := []*corev1.Pod{
+		newPod("fs-pod", fsPodUID, corev1.PodQOSBurstable),
+		newPod("block-pod", blockPodUID, corev1.PodQOSGuaranteed),
+		newPod("idle-pod", idlePodUID, corev1.PodQOSBestEffort),
+	}
+
+	sysPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sysPath, "fs/cgroup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sysPath, "fs/cgroup/cgroup.controllers"), []byte("io"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ioMax := map[string]string{}
+	for _, pod := range pods {
+		dir := utils.PodCgroupDir(sysPath, utils.CgroupV2, pod.Status.QOSClass, string(pod.UID))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ioMax[pod.Name] = filepath.Join(dir, utils.CgroupV2IOMaxFile)
+		if err := os.WriteFile(ioMax[pod.Name], []byte("253:3 riops=1024 wiops=1024 rbps=max wbps=max"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lvDeviceNumber = func(dev string) (uint64, uint64, error) {
+		return 253, 3, nil
+	}
+	lvPublishedPaths = func(dev, name string) ([]string, error) {
+		return []string{
+			"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/" + name + "/globalmount",
+			"/var/lib/kubelet/pods/" + fsPodUID + "/volumes/kubernetes.io~csi/" + name + "/mount",
+			blockPublishDir + "/" + name + "/" + blockPodUID,
+		}, nil
+	}
+	defer func() {
+		lvDeviceNumber = deviceNumber
+		lvPublishedPaths = publishedPaths
+	}()
+
+	lvm := &LvmCommads{
+		kubeclient: fakekubeclientset.NewSimpleClientset(pods[0], pods[1], pods[2]),
+		sysPath:    sysPath,
+	}
+	got, err := lvm.SetIOThrottling(context.Background(), "vg", "yoda-70597cb6", "", "1048576")
+	if err != nil {
+		t.Fatalf("SetIOThrottling() error = %v", err)
+	}
+	if want := []string{"default/block-pod", "default/fs-pod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SetIOThrottling() = %v, want %v", got, want)
+	}
+	// pod not using the lv is left unchanged
+	wantIOMax := map[string]string{
+		"fs-pod":    "253:3 rbps=1048576 wbps=1048576",
+		"block-pod": "253:3 rbps=1048576 wbps=1048576",
+		"idle-pod":  "253:3 riops=1024 wiops=1024 rbps=max wbps=max",
+	}
+	for name, path := range ioMax {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != wantIOMax[name] {
+			t.Errorf("io.max of pod %s = %q, want %q", name, string(content), wantIOMax[name])
+		}
+	}
+}
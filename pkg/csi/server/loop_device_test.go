@@ -0,0 +1,100 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"golang.org/x/net/context"
+)
+
+// envLoopDeviceTest enables tests against real loop devices and lvm, which
+// requires root and lvm2 installed on the machine running tests
+const envLoopDeviceTest = "OPEN_LOCAL_LOOP_DEVICE_TEST"
+
+// Test_LoopDevice_ProvisionAndSnapshot provisions a volume and a readonly
+// snapshot of it on a vg backed by a loop device over a temp file
+func Test_LoopDevice_ProvisionAndSnapshot(t *testing.T) {
+	if os.Getenv(envLoopDeviceTest) != "true" {
+		t.Skipf("set %s=true to run against real loop devices as root", envLoopDeviceTest)
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("loop devices require root")
+	}
+	const (
+		vgName   = "open-local-loop-test"
+		lvName   = "local-loop-test"
+		snapName = "local-loop-test-snap"
+	)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "pv0.img")
+	dev, err := deviceutil.AttachLoopFile(file, 256<<20)
+	if err != nil {
+		t.Fatalf("AttachLoopFile() error = %v", err)
+	}
+	defer func() {
+		if err := deviceutil.DetachLoopDevice(dev); err != nil {
+			t.Errorf("DetachLoopDevice() error = %v", err)
+		}
+	}()
+	// attaching again reuses the loop device
+	if again, err := deviceutil.AttachLoopFile(file, 256<<20); err != nil || again != dev {
+		t.Fatalf("AttachLoopFile() again = %s, %v, want %s", again, err, dev)
+	}
+
+	pv, err := lvm.CreatePhysicalVolume(dev, false)
+	if err != nil {
+		t.Fatalf("CreatePhysicalVolume() error = %v", err)
+	}
+	defer pv.Remove()
+	vg, err := lvm.CreateVolumeGroup(vgName, []*lvm.PhysicalVolume{pv}, nil, false)
+	if err != nil {
+		t.Fatalf("CreateVolumeGroup() error = %v", err)
+	}
+	defer vg.Remove()
+	pvs, err := vg.ListPhysicalVolumeNames()
+	if err != nil || len(pvs) != 1 || !deviceutil.IsLoopDevice(pvs[0]) {
+		t.Fatalf("physical volumes of vg = %v, %v, want loop device %s", pvs, err, dev)
+	}
+
+	cmd := &LvmCommads{}
+	if _, err := cmd.CreateLV(ctx, vgName, lvName, 64<<20, 0, nil, false, ""); err != nil {
+		t.Fatalf("CreateLV() error = %v", err)
+	}
+	defer func() {
+		if _, err := cmd.RemoveLV(ctx, vgName, lvName); err != nil {
+			t.Errorf("RemoveLV() error = %v", err)
+		}
+	}()
+	if _, err := cmd.CreateSnapshot(ctx, vgName, snapName, lvName, "", true, 32<<20, nil, false, nil); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	snapshot, err := vg.LookupLogicalVolume(snapName)
+	if err != nil {
+		t.Fatalf("LookupLogicalVolume() error = %v", err)
+	}
+	if !snapshot.IsSnapshot() || snapshot.OriginLVName() != lvName {
+		t.Errorf("snapshot %s is not a snapshot of %s", snapName, lvName)
+	}
+	if _, err := cmd.RemoveSnapshot(ctx, vgName, snapName, true); err != nil {
+		t.Errorf("RemoveSnapshot() error = %v", err)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"unicode"
 
 	localtype "github.com/alibaba/open-local/pkg"
@@ -50,19 +51,52 @@ const (
 	ProjQuotaNamespacePrefix = "/mnt/quotapath.%s"
 )
 
+// cmdRunner runs shell command, can be replaced in unit test
+var cmdRunner = utils.Run
+
+// cmdContextRunner runs shell command which is killed once ctx is done, can be
+// replaced in unit test
+var cmdContextRunner = utils.RunContext
+
+var (
+	// ErrSnapshotOfSnapshot is returned if origin lv of snapshot is a snapshot itself
+	ErrSnapshotOfSnapshot = errors.New("snapshot of snapshot is not supported")
+	// ErrSnapshotNoSpace is returned if vg of origin lv lacks room for snapshot
+	ErrSnapshotNoSpace = errors.New("insufficient free space for snapshot in vg of origin lv")
+	// ErrSnapshotCOWPVNotFound is returned if pv to hold cow data of snapshot is not in vg of origin lv
+	ErrSnapshotCOWPVNotFound = errors.New("pv for snapshot cow data is not found in vg of origin lv")
+	// ErrInvalidAllocationPolicy is returned if allocation policy is not supported by lvcreate
+	ErrInvalidAllocationPolicy = errors.New("invalid allocation policy")
+	// ErrAllocationNoSpace is returned if free extents of vg can not satisfy allocation policy
+	ErrAllocationNoSpace = errors.New("insufficient free extents for allocation policy")
+	// ErrRemoteUnsupported is returned if operation on local files of the node
+	// is requested while lvm operations are run on remote lvm host
+	ErrRemoteUnsupported = errors.New("not supported on remote lvm host")
+	// ErrOriginHasSnapshots is returned if lv to be removed still has snapshots
+	ErrOriginHasSnapshots = errors.New("logical volume has snapshots")
+)
+
+// rwTempSnapshotSize is the size of temp snapshot backed up by restic
+const rwTempSnapshotSize = 4 * 1024 * 1024 * 1024
+
 type LvmCommads struct {
 	kubeclient kubernetes.Interface
 	snapclient snapshot.Interface
 	recorder   record.EventRecorder
+	// sysPath is the mountpoint of sysfs, where io alignment of device is read
+	sysPath string
+	// remote is true if lvm operations are run on remote lvm host by executor,
+	// sysfs, mounts and cgroups of local node are not those of the lv then
+	remote bool
 }
 
 // ListLV lists lvm volumes
 func (lvm *LvmCommads) ListLV(listspec string) ([]*lib.LV, error) {
 	lvs := []*lib.LV{}
-	cmdList := []string{localtype.NsenterCmd, "lvs", "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
+	cmdList := []string{lvmCmd("lvs"), "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
 		"-o", "lv_name,lv_size,lv_uuid,lv_attr,copy_percent,lv_kernel_major,lv_kernel_minor,lv_tags", "--nameprefixes", "-a", listspec}
 	cmd := strings.Join(cmdList, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		if strings.Contains(err.Error(), "Failed to find logical volume") {
 			return lvs, nil
@@ -86,11 +120,17 @@ func (lvm *LvmCommads) ListLV(listspec string) ([]*lib.LV, error) {
 }
 
 // CreateLV creates a new volume
-func (lvm *LvmCommads) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool) (string, error) {
+func (lvm *LvmCommads) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool, allocation string) (string, error) {
 	if size == 0 {
 		return "", errors.New("size must be greater than 0")
 	}
-	args := []string{localtype.NsenterCmd, "lvcreate", "-n", name, "-L", fmt.Sprintf("%db", size), "-W", "y", "-y"}
+	if allocation != "" && !utils.ContainsString(localtype.AllocationPolicies, allocation) {
+		return "", fmt.Errorf("%w %q, must be one of %v", ErrInvalidAllocationPolicy, allocation, localtype.AllocationPolicies)
+	}
+	args := []string{lvmCmd("lvcreate"), "-n", name, "-L", fmt.Sprintf("%db", size), "-W", "y", "-y"}
+	if allocation != "" {
+		args = append(args, "--alloc", allocation)
+	}
 	if mirrors > 0 {
 		args = append(args, "-m", fmt.Sprintf("%d", mirrors), "--nosync")
 	}
@@ -106,14 +146,75 @@ func (lvm *LvmCommads) CreateLV(ctx context.Context, vg string, name string, siz
 			return "", fmt.Errorf("could not create `striping` logical volume, not enough space")
 		}
 		args = append(args, "-i", strconv.Itoa(pvCount))
+		if lvm.remote {
+			log.Infof("stripe size of pvs in vg %s on remote lvm host is unknown, use default stripe size", vg)
+		} else if stripeSize := PVStripeSize(lvm.sysPath, vg); stripeSize > 0 {
+			args = append(args, "-I", fmt.Sprintf("%dk", stripeSize/1024))
+		}
 	}
 
 	args = append(args, vg)
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
+	// e.g. Insufficient suitable contiguous allocatable extents for logical volume
+	if err != nil && allocation != "" && strings.Contains(err.Error(), "Insufficient suitable") {
+		return "", fmt.Errorf("%w %s in vg %s, free space of vg may be fragmented: %s", ErrAllocationNoSpace, allocation, vg, err.Error())
+	}
 	return string(out), err
 }
 
+// GetIOAlignment returns io alignment of lv reported by kernel
+func (lvm *LvmCommads) GetIOAlignment(ctx context.Context, vg string, name string) (utils.IOAlignment, error) {
+	if lvm.remote {
+		return utils.IOAlignment{}, fmt.Errorf("io alignment: %w", ErrRemoteUnsupported)
+	}
+	return utils.ReadIOAlignment(lvm.sysPath, filepath.Join("/dev", vg, name))
+}
+
+// PVStripeSize returns stripe size aligned to minimum io size of pvs in vg, 0
+// means default of lvm. lvm aligns data of pv to optimal io size when pv is
+// created, misaligned pv is only warned since it can not be fixed in place.
+func PVStripeSize(sysPath, vg string) uint64 {
+	args := []string{lvmCmd("pvs"), "--units=b", "--nosuffix", "--noheadings", "-o", "pv_name,pe_start", "-S", fmt.Sprintf("vg_name=%s", vg)}
+	out, err := cmdRunner(strings.Join(args, " "))
+	if err != nil {
+		log.Warningf("fail to get pe_start of pvs in vg %s, use default stripe size: %s", vg, err.Error())
+		return 0
+	}
+	var stripeSize uint64
+	for pvName, peStart := range parsePEStarts(out) {
+		alignment, err := utils.ReadIOAlignment(sysPath, pvName)
+		if err != nil {
+			log.Warningf("fail to get io alignment of pv %s: %s", pvName, err.Error())
+			continue
+		}
+		if alignment.OptimalIOSize > 0 && peStart%alignment.OptimalIOSize != 0 {
+			log.Warningf("data of pv %s starts at %d, which is not aligned to optimal io size %d", pvName, peStart, alignment.OptimalIOSize)
+		}
+		if size := alignment.StripeSize(); size > stripeSize {
+			stripeSize = size
+		}
+	}
+	return stripeSize
+}
+
+// parsePEStarts parses output of pvs -o pv_name,pe_start
+func parsePEStarts(out string) map[string]uint64 {
+	peStarts := map[string]uint64{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		peStart, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		peStarts[fields[0]] = peStart
+	}
+	return peStarts
+}
+
 func getRequiredPVNumber(vgName string, lvSize uint64) (int, error) {
 	pvs, err := ListPV(vgName)
 	if err != nil {
@@ -156,41 +257,254 @@ func (lvm *LvmCommads) RemoveLV(ctx context.Context, vg string, name string) (st
 			return "", errors.New("volume is protected")
 		}
 	}
+	if lvs[0].Attributes.State.IsSuspended() {
+		return "", fmt.Errorf("logical volume %s is suspended, resume it by dmsetup resume first", utils.GetNameKey(vg, name))
+	}
 
-	// check if lv has snapshot
-	args := []string{localtype.NsenterCmd, "lvs", "-o", "lv_name,vg_name,origin", "-S", fmt.Sprintf("origin=%s,vg_name=%s", name, vg), "--noheadings", "--nosuffix"}
-	cmd := strings.Join(args, " ")
-	if out, err := utils.Run(cmd); err != nil {
-		return out, err
-	} else {
-		if strings.Contains(out, name) {
-			return "", fmt.Errorf("logical volume %s has snapshot, please remove snapshot first", utils.GetNameKey(vg, name))
-		}
+	if err := removeOrphanedSnapshots(vg, name, utils.ContainsString(lvs[0].Tags, localtype.DeleteOrphanedSnapshotsLVTag)); err != nil {
+		return "", err
 	}
 
-	args = []string{localtype.NsenterCmd, "lvremove", "-v", "-f", utils.GetNameKey(vg, name)}
-	cmd = strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	cmd := lvremoveCmd(vg, name, lvm.discardOnRemove(lvs[0]))
+	out, err := cmdRunner(cmd)
+	return string(out), err
+}
+
+// RenameLV renames lv vg/name to newName
+func (lvm *LvmCommads) RenameLV(ctx context.Context, vg string, name string, newName string) (string, error) {
+	out, err := cmdRunner(lvmCmd("lvrename", vg, name, newName))
 	return string(out), err
 }
 
-// CloneLV clones a volume via dd
-func (lvm *LvmCommads) CloneLV(ctx context.Context, src, dest string) (string, error) {
+// snapshotOfOrigin is snapshot lv of an origin lv
+type snapshotOfOrigin struct {
+	name string
+	tags []string
+}
+
+// orphaned tells whether snapshot is created by open-local and referenced by
+// no VolumeSnapshot, i.e. it is pending deletion or taken for expansion
+func (snap snapshotOfOrigin) orphaned() bool {
+	return utils.ContainsString(snap.tags, localtype.PendingDeletionLVTag) || utils.ContainsString(snap.tags, localtype.ExpansionSnapshotLVTag)
+}
+
+// listSnapshotsOfOrigin lists snapshot lvs of lv vg/name
+func listSnapshotsOfOrigin(vg, name string) ([]snapshotOfOrigin, error) {
+	args := []string{lvmCmd("lvs"), "-o", "lv_name,lv_tags", "-S", fmt.Sprintf("origin=%s,vg_name=%s", name, vg), "--noheadings", "--nosuffix"}
+	out, err := cmdRunner(strings.Join(args, " "))
+	if err != nil {
+		return nil, fmt.Errorf("fail to list snapshots of lv %s: %s, %s", utils.GetNameKey(vg, name), err.Error(), out)
+	}
+	var snapshots []snapshotOfOrigin
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		snap := snapshotOfOrigin{name: fields[0]}
+		if len(fields) > 1 {
+			snap.tags = strings.Split(fields[1], ",")
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, nil
+}
+
+// removeOrphanedSnapshots refuses to remove lv vg/name which still has
+// snapshots, naming all of them. If cascade is true, orphaned snapshots
+// managed by open-local are removed first and only the others are refused,
+// snapshots of users are never removed
+func removeOrphanedSnapshots(vg, name string, cascade bool) error {
+	snapshots, err := listSnapshotsOfOrigin(vg, name)
+	if err != nil {
+		return err
+	}
+	var blocking []string
+	for _, snap := range snapshots {
+		if !cascade || !snap.orphaned() {
+			blocking = append(blocking, snap.name)
+			continue
+		}
+		if out, err := cmdRunner(lvremoveCmd(vg, snap.name, false)); err != nil {
+			return fmt.Errorf("fail to remove orphaned snapshot %s of lv %s: %s, %s", snap.name, utils.GetNameKey(vg, name), err.Error(), out)
+		}
+		log.Infof("orphaned snapshot %s of lv %s is removed", snap.name, utils.GetNameKey(vg, name))
+	}
+	if len(blocking) > 0 {
+		return fmt.Errorf("%w: lv %s has snapshots %s, delete their VolumeSnapshots or remove them by lvremove first", ErrOriginHasSnapshots, utils.GetNameKey(vg, name), strings.Join(blocking, ", "))
+	}
+	return nil
+}
+
+// discardOnRemove tells whether extents of lv are discarded when it is
+// removed, which requires lv tagged with discard on delete and its device
+// supporting discard, so that it is a no-op on hdd
+func (lvm *LvmCommads) discardOnRemove(lv *lib.LV) bool {
+	if !utils.ContainsString(lv.Tags, localtype.DiscardOnDeleteLVTag) {
+		return false
+	}
+	if lvm.remote {
+		log.Infof("discard support of lv %s on remote lvm host is unknown, skip discarding", lv.Name)
+		return false
+	}
+	supported, err := utils.DiscardSupported(lvm.sysPath, uint64(lv.ActualDevMajNumber), uint64(lv.ActualDevMinNumber))
+	if err != nil {
+		log.Warningf("fail to check discard support of lv %s, skip discarding: %s", lv.Name, err.Error())
+		return false
+	}
+	if !supported {
+		log.Infof("device of lv %s does not support discard, skip discarding", lv.Name)
+	}
+	return supported
+}
+
+// lvremoveCmd returns command removing lv, freed extents are discarded by
+// lvm regardless of issue_discards of lvm.conf if discard is true
+func lvremoveCmd(vg, name string, discard bool) string {
+	args := []string{lvmCmd("lvremove"), "-v", "-f"}
+	if discard {
+		args = append(args, "--config", "devices/issue_discards=1")
+	}
+	args = append(args, utils.GetNameKey(vg, name))
+	return strings.Join(args, " ")
+}
+
+// CloneLV clones a volume via dd, data of dest is verified against src by
+// checksum after copying if verifyChecksum is true
+func (lvm *LvmCommads) CloneLV(ctx context.Context, src, dest string, verifyChecksum bool) (string, error) {
 	// FIXME(farcaller): bloody insecure. And broken.
 
-	args := []string{localtype.NsenterCmd, "dd", fmt.Sprintf("if=%s", src), fmt.Sprintf("of=%s", dest), "bs=4M"}
+	// copy is registered as long operation, which is not bound to ctx of
+	// request but can be cancelled via CancelOperation
+	opCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, deregister := utils.LongOperations.Register(utils.OperationTypeClone, filepath.Base(filepath.Dir(dest)), filepath.Base(dest), cancel, nil)
+	defer deregister()
+
+	args := []string{localtype.NsenterCmd, "dd", fmt.Sprintf("if=%s", src), fmt.Sprintf("of=%s", dest), "bs=4M", "conv=fsync"}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdContextRunner(opCtx, cmd)
+	if err != nil || !verifyChecksum {
+		return string(out), err
+	}
+	if err := verifyClonedData(opCtx, src, dest); err != nil {
+		return "", err
+	}
+	log.Infof("CloneLV: checksum of %s matches %s", dest, src)
 
-	return string(out), err
+	return string(out), nil
+}
+
+// verifyClonedData compares checksum of src with the same length of data at
+// the beginning of dest, since dest may be larger than src
+func verifyClonedData(ctx context.Context, src, dest string) error {
+	out, err := cmdContextRunner(ctx, fmt.Sprintf("%s blockdev --getsize64 %s", localtype.NsenterCmd, src))
+	if err != nil {
+		return fmt.Errorf("fail to get size of %s: %s", src, err.Error())
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return fmt.Errorf("fail to parse size of %s: %s", src, err.Error())
+	}
+	srcSum, err := deviceChecksum(ctx, src, size)
+	if err != nil {
+		return err
+	}
+	destSum, err := deviceChecksum(ctx, dest, size)
+	if err != nil {
+		return err
+	}
+	if srcSum != destSum {
+		return fmt.Errorf("checksum mismatch after cloning %s to %s: %s != %s", src, dest, srcSum, destSum)
+	}
+	return nil
+}
+
+// deviceChecksum returns sha256 checksum of the first size bytes of device
+func deviceChecksum(ctx context.Context, device string, size uint64) (string, error) {
+	out, err := cmdContextRunner(ctx, fmt.Sprintf("%s head -c %d %s | sha256sum", localtype.NsenterCmd, size, device))
+	if err != nil {
+		return "", fmt.Errorf("fail to calculate checksum of %s: %s", device, err.Error())
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("fail to calculate checksum of %s: empty output", device)
+	}
+	return fields[0], nil
+}
+
+// zeroChunkSize is the size zeroed by one blkdiscard, progress and
+// cancellation are checked between chunks
+const zeroChunkSize = 1024 * 1024 * 1024
+
+// ZeroLV writes zeros across lv via blkdiscard --zeroout, which is offloaded
+// to device if supported, and removes zeroing tag of lv once it is done
+func (lvm *LvmCommads) ZeroLV(ctx context.Context, vg string, name string) (string, error) {
+	dev := filepath.Join("/dev", vg, name)
+	out, err := cmdRunner(fmt.Sprintf("%s blockdev --getsize64 %s", localtype.NsenterCmd, dev))
+	if err != nil {
+		return "", fmt.Errorf("fail to get size of %s: %s", dev, err.Error())
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("fail to parse size of %s: %s", dev, err.Error())
+	}
+
+	// zeroing is registered as long operation like copy of clone, which is
+	// not bound to ctx of request but can be cancelled via CancelOperation
+	opCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var zeroed uint64
+	_, deregister := utils.LongOperations.Register(utils.OperationTypeZero, vg, name, cancel, func() string {
+		return zeroProgress(atomic.LoadUint64(&zeroed), size)
+	})
+	defer deregister()
+
+	for offset := uint64(0); offset < size; offset += zeroChunkSize {
+		if opCtx.Err() != nil {
+			return "", fmt.Errorf("zeroing of %s is cancelled, %s", dev, zeroProgress(offset, size))
+		}
+		length := size - offset
+		if length > zeroChunkSize {
+			length = zeroChunkSize
+		}
+		cmd := fmt.Sprintf("%s blkdiscard --zeroout --offset %d --length %d %s", localtype.NsenterCmd, offset, length, dev)
+		if _, err := cmdContextRunner(opCtx, cmd); err != nil {
+			return "", fmt.Errorf("fail to zero %s at offset %d: %s", dev, offset, err.Error())
+		}
+		atomic.StoreUint64(&zeroed, offset+length)
+		log.V(4).Infof("ZeroLV: %s of %s", zeroProgress(offset+length, size), dev)
+	}
+	cmd := fmt.Sprintf("%s --deltag %s %s", lvmCmd("lvchange"), localtype.ZeroingLVTag, utils.GetNameKey(vg, name))
+	if _, err := cmdRunner(cmd); err != nil {
+		return "", fmt.Errorf("fail to remove zeroing tag of %s: %s", dev, err.Error())
+	}
+	log.Infof("ZeroLV: %d bytes of %s are zeroed", size, dev)
+	return "", nil
+}
+
+func zeroProgress(zeroed, size uint64) string {
+	if size == 0 {
+		return "zeroed 0 of 0 bytes"
+	}
+	return fmt.Sprintf("zeroed %d of %d bytes (%d%%)", zeroed, size, zeroed*100/size)
 }
 
 // ExpandLV expand a volume
 func (lvm *LvmCommads) ExpandLV(ctx context.Context, vgName string, volumeId string, expectSize uint64) (string, error) {
+	// lvextend hangs on suspended volume
+	lvs, err := lvm.ListLV(utils.GetNameKey(vgName, volumeId))
+	if err != nil {
+		return "", fmt.Errorf("failed to list LVs: %v", err)
+	}
+	if len(lvs) == 1 && lvs[0].Attributes.State.IsSuspended() {
+		return "", fmt.Errorf("logical volume %s is suspended, resume it by dmsetup resume first", utils.GetNameKey(vgName, volumeId))
+	}
+
 	// resize lvm volume
 	// lvextend -L3G /dev/vgtest/lvm-5db74864-ea6b-11e9-a442-00163e07fb69
-	resizeCmd := fmt.Sprintf("%s lvextend -L%dB %s", localtype.NsenterCmd, expectSize, utils.GetNameKey(vgName, volumeId))
-	out, err := utils.Run(resizeCmd)
+	resizeCmd := fmt.Sprintf("%s -L%dB %s", lvmCmd("lvextend"), expectSize, utils.GetNameKey(vgName, volumeId))
+	out, err := cmdRunner(resizeCmd)
 	if err != nil {
 		return "", err
 	}
@@ -200,10 +514,10 @@ func (lvm *LvmCommads) ExpandLV(ctx context.Context, vgName string, volumeId str
 
 // ListVG get vg info
 func (lvm *LvmCommads) ListVG() ([]*lib.VG, error) {
-	args := []string{localtype.NsenterCmd, "vgs", "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
+	args := []string{lvmCmd("vgs"), "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
 		"-o", "vg_name,vg_size,vg_free,vg_uuid,vg_tags,pv_count", "--nameprefixes", "-a"}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("%s,%s", err.Error(), out)
 	}
@@ -223,10 +537,10 @@ func (lvm *LvmCommads) ListVG() ([]*lib.VG, error) {
 
 // ListPV get pv info in vg
 func ListPV(vgName string) ([]*lib.PV, error) {
-	args := []string{localtype.NsenterCmd, "pvs", "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
+	args := []string{lvmCmd("pvs"), "--units=b", fmt.Sprintf("--separator=\"%s\"", localtype.Separator), "--nosuffix", "--noheadings",
 		"-o", "pv_name,pv_size,pv_free,pv_uuid,pv_tags,vg_name", "-S", fmt.Sprintf("%s=%s", "vg_name", vgName), "--nameprefixes", "-a"}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("%s,%s", err.Error(), out)
 	}
@@ -245,13 +559,31 @@ func ListPV(vgName string) ([]*lib.PV, error) {
 }
 
 // CreateSnapshot creates a new volume snapshot
-func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error) {
+func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error) {
 	var sizeBytes int64
+	// rw snapshot is mounted and backed up on local node
+	if !readonly && lvm.remote {
+		return 0, fmt.Errorf("rw snapshot: %w", ErrRemoteUnsupported)
+	}
+	if srcLVName == "" {
+		srcLVName = srcVolumeName
+	}
+	snapshotSize := uint64(rwTempSnapshotSize)
+	if readonly {
+		snapshotSize = uint64(roInitSize)
+	}
+	if err := checkSnapshotOrigin(vgName, srcLVName, snapshotSize); err != nil {
+		return 0, err
+	}
+	if err := checkSnapshotCOWPVs(vgName, cowPVs, snapshotSize); err != nil {
+		return 0, err
+	}
 	if readonly {
 		// ro
-		args := []string{localtype.NsenterCmd, "lvcreate", "-s", "-n", snapshotName, "-L", fmt.Sprintf("%db", roInitSize), utils.GetNameKey(vgName, srcVolumeName), "-y"}
+		args := []string{lvmCmd("lvcreate"), "-s", "-n", snapshotName, "-L", fmt.Sprintf("%db", roInitSize)}
+		args = append(args, snapshotCOWArgs(vgName, srcLVName, cowPVs)...)
 		cmd := strings.Join(args, " ")
-		_, err := utils.Run(cmd)
+		_, err := runWithFsFreeze(vgName, srcLVName, fsFreeze, cmd)
 		if err != nil {
 			return 0, err
 		}
@@ -259,18 +591,19 @@ func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapsh
 		// rw
 		// create temp snapshot
 		// todo: 这里一个问题是 当出现备份过程中删除 yoda-agent 再启动后volumesnapshot会报错（永远无法ready to use）
-		log.Infof("create temp snapshot %s for volume %s", snapshotName, srcVolumeName)
-		args := []string{localtype.NsenterCmd, "lvcreate", "-s", "-n", snapshotName, "-L", "4G", utils.GetNameKey(vgName, srcVolumeName), "-y"}
+		log.Infof("create temp snapshot %s for volume %s(lv %s)", snapshotName, srcVolumeName, srcLVName)
+		args := []string{lvmCmd("lvcreate"), "-s", "-n", snapshotName, "-L", fmt.Sprintf("%db", rwTempSnapshotSize)}
+		args = append(args, snapshotCOWArgs(vgName, srcLVName, cowPVs)...)
 		cmd := strings.Join(args, " ")
-		out, err := utils.Run(cmd)
+		out, err := runWithFsFreeze(vgName, srcLVName, fsFreeze, cmd)
 		if err != nil {
 			return 0, fmt.Errorf("fail to run cmd %s: %s, %s", cmd, err.Error(), out)
 		}
 
 		defer func() {
-			args = []string{localtype.NsenterCmd, "lvremove", "-v", "-f", utils.GetNameKey(vgName, snapshotName)}
+			args = []string{lvmCmd("lvremove"), "-v", "-f", utils.GetNameKey(vgName, snapshotName)}
 			cmd := strings.Join(args, " ")
-			if out, err = utils.Run(cmd); err != nil {
+			if out, err = cmdRunner(cmd); err != nil {
 				log.Errorf("fail to remove temp snapshot lv %s: %s, %s", snapshotName, err.Error(), out)
 			}
 			log.Infof("delete temp snapshot %s", snapshotName)
@@ -293,7 +626,7 @@ func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapsh
 		log.Infof("mount temp snapshot lv /dev/%s/%s to %s", vgName, snapshotName, tempDir)
 		args = []string{"mount", fmt.Sprintf("/dev/%s/%s", vgName, snapshotName), tempDir}
 		cmd = strings.Join(args, " ")
-		out, err = utils.Run(cmd)
+		out, err = cmdRunner(cmd)
 		if err != nil {
 			return 0, fmt.Errorf("fail to run cmd %s: %s,%s", cmd, err.Error(), out)
 		}
@@ -302,7 +635,7 @@ func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapsh
 		defer func() {
 			args = []string{"umount", tempDir}
 			cmd = strings.Join(args, " ")
-			if _, err = utils.Run(cmd); err != nil {
+			if _, err = cmdRunner(cmd); err != nil {
 				log.Errorf("fail to umount temp dir %s", tempDir)
 			}
 			log.Infof("umount temp dir %s", tempDir)
@@ -339,6 +672,150 @@ func (lvm *LvmCommads) CreateSnapshot(ctx context.Context, vgName string, snapsh
 	return 0, nil
 }
 
+// checkSnapshotOrigin resolves the vg holding origin lv, snapshot is always
+// created in the vg of its origin and no other vg is tried. It fails if the
+// origin is a snapshot, or the vg has less than size bytes free
+func checkSnapshotOrigin(vgName, lvName string, size uint64) error {
+	args := []string{lvmCmd("lvs"), "--units=b", "--nosuffix", "--noheadings", fmt.Sprintf("--separator=\"%s\"", localtype.Separator),
+		"-o", "vg_name,lv_attr,vg_free", "-S", fmt.Sprintf("lv_name=%s", lvName)}
+	cmd := strings.Join(args, " ")
+	out, err := cmdRunner(cmd)
+	if err != nil {
+		return fmt.Errorf("fail to get origin lv %s: %s, %s", lvName, err.Error(), out)
+	}
+	var originVGs []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), localtype.Separator)
+		if len(fields) != 3 {
+			continue
+		}
+		originVG := strings.TrimSpace(fields[0])
+		if originVG != vgName {
+			originVGs = append(originVGs, originVG)
+			continue
+		}
+		if attr := strings.TrimSpace(fields[1]); attr != "" && (attr[0] == 's' || attr[0] == 'S') {
+			return fmt.Errorf("%w: origin lv %s is a snapshot", ErrSnapshotOfSnapshot, utils.GetNameKey(vgName, lvName))
+		}
+		free, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("fail to parse free size of vg %s: %q", vgName, fields[2])
+		}
+		if free < size {
+			return fmt.Errorf("%w: vg %s has %d bytes free but snapshot of lv %s requires %d bytes, free up vg %s or reduce %s",
+				ErrSnapshotNoSpace, vgName, free, lvName, size, vgName, localtype.ParamSnapshotInitialSize)
+		}
+		return nil
+	}
+	if len(originVGs) > 0 {
+		return fmt.Errorf("origin lv %s is in vg %s rather than vg %s of its volume, snapshot must be created in the vg of its origin", lvName, strings.Join(originVGs, ","), vgName)
+	}
+	return fmt.Errorf("origin lv %s is not found in vg %s", lvName, vgName)
+}
+
+// snapshotCOWArgs returns origin and pvs of lvcreate for snapshot, cow data is
+// allocated on cowPVs with cling policy, so that lvextend of agent keeps
+// using the same pvs. lvm allocates anywhere in vg if cowPVs is empty
+func snapshotCOWArgs(vgName, srcLVName string, cowPVs []string) []string {
+	args := []string{utils.GetNameKey(vgName, srcLVName)}
+	if len(cowPVs) > 0 {
+		args = append([]string{"--alloc", "cling"}, args...)
+		args = append(args, cowPVs...)
+	}
+	return append(args, "-y")
+}
+
+// checkSnapshotCOWPVs checks that every pv of cowPVs is in vg of origin lv and
+// they have size bytes free in total, cow data must be in the vg of its origin
+func checkSnapshotCOWPVs(vgName string, cowPVs []string, size uint64) error {
+	if len(cowPVs) == 0 {
+		return nil
+	}
+	args := []string{lvmCmd("pvs"), "--units=b", "--nosuffix", "--noheadings", fmt.Sprintf("--separator=\"%s\"", localtype.Separator),
+		"-o", "pv_name,vg_name,pv_free"}
+	cmd := strings.Join(args, " ")
+	out, err := cmdRunner(cmd)
+	if err != nil {
+		return fmt.Errorf("fail to get pvs: %s, %s", err.Error(), out)
+	}
+	pvFree := map[string]uint64{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), localtype.Separator)
+		if len(fields) != 3 || strings.TrimSpace(fields[1]) != vgName {
+			continue
+		}
+		free, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("fail to parse free size of pv %s: %q", fields[0], fields[2])
+		}
+		pvFree[strings.TrimSpace(fields[0])] = free
+	}
+	var total uint64
+	for _, pv := range cowPVs {
+		free, exist := pvFree[pv]
+		if !exist {
+			return fmt.Errorf("%w: pv %s is not in vg %s, snapshot cow data can only be in the vg of its origin", ErrSnapshotCOWPVNotFound, pv, vgName)
+		}
+		total += free
+	}
+	if total < size {
+		return fmt.Errorf("%w: pv %s of vg %s have %d bytes free but snapshot requires %d bytes, free up the pvs or reduce %s",
+			ErrSnapshotNoSpace, strings.Join(cowPVs, ","), vgName, total, size, localtype.ParamSnapshotInitialSize)
+	}
+	return nil
+}
+
+// runWithFsFreeze runs cmd, freezing the filesystem of the origin lv before and
+// thawing it after when fsFreeze is set. Thaw is always attempted once freeze
+// succeeded, even if cmd fails.
+func runWithFsFreeze(vgName string, lvName string, fsFreeze bool, cmd string) (out string, err error) {
+	if !fsFreeze {
+		return cmdRunner(cmd)
+	}
+	mountPoint, err := getLVMountPoint(vgName, lvName)
+	if err != nil {
+		return "", fmt.Errorf("fail to get mountpoint of lv %s: %s", utils.GetNameKey(vgName, lvName), err.Error())
+	}
+	if mountPoint == "" {
+		log.Infof("lv %s is not mounted, skip fsfreeze", utils.GetNameKey(vgName, lvName))
+		return cmdRunner(cmd)
+	}
+
+	log.Infof("freeze filesystem %s of lv %s", mountPoint, utils.GetNameKey(vgName, lvName))
+	freezeCmd := strings.Join([]string{localtype.NsenterCmd, "fsfreeze", "-f", mountPoint}, " ")
+	if _, err := cmdRunner(freezeCmd); err != nil {
+		return "", fmt.Errorf("fail to freeze filesystem %s: %s", mountPoint, err.Error())
+	}
+	defer func() {
+		thawCmd := strings.Join([]string{localtype.NsenterCmd, "fsfreeze", "-u", mountPoint}, " ")
+		if _, thawErr := cmdRunner(thawCmd); thawErr != nil {
+			log.Errorf("fail to thaw filesystem %s: %s", mountPoint, thawErr.Error())
+			if err == nil {
+				err = fmt.Errorf("fail to thaw filesystem %s: %s", mountPoint, thawErr.Error())
+			}
+			return
+		}
+		log.Infof("thaw filesystem %s of lv %s", mountPoint, utils.GetNameKey(vgName, lvName))
+	}()
+
+	return cmdRunner(cmd)
+}
+
+// getLVMountPoint returns the mountpoint of lv, or empty string if lv is not mounted
+func getLVMountPoint(vgName string, lvName string) (string, error) {
+	args := []string{localtype.NsenterCmd, "lsblk", "-n", "-o", "MOUNTPOINT", fmt.Sprintf("/dev/%s/%s", vgName, lvName)}
+	out, err := cmdRunner(strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
 // RemoveSnapshot removes a volume snapshot
 func (lvm *LvmCommads) RemoveSnapshot(ctx context.Context, vg string, name string, readonly bool) (string, error) {
 	if readonly {
@@ -353,24 +830,73 @@ func (lvm *LvmCommads) RemoveSnapshot(ctx context.Context, vg string, name strin
 			return "", fmt.Errorf("expected 1 LV, got %d", len(lvs))
 		}
 
-		args := []string{localtype.NsenterCmd, "lvremove", "-v", "-f", utils.GetNameKey(vg, name)}
+		args := []string{lvmCmd("lvremove"), "-v", "-f", utils.GetNameKey(vg, name)}
 		cmd := strings.Join(args, " ")
-		_, err = utils.Run(cmd)
+		_, err = cmdRunner(cmd)
 		if err != nil {
-			return "", err
+			return deferSnapshotRemoval(vg, name, err)
 		}
 	}
 	return "", nil
 }
 
+// originBusyMessages are messages of lvremove failed on snapshot whose origin
+// is open, device-mapper can not suspend or deactivate a busy origin
+var originBusyMessages = []string{
+	"Failed to suspend origin",
+	"Unable to deactivate open",
+}
+
+// snapshotOpenMessages are messages of lvremove failed because snapshot lv
+// itself is open, which is not retried by agent
+func snapshotOpenMessages(vg, name string) []string {
+	dmName := utils.DMName(vg, name)
+	return []string{
+		fmt.Sprintf("Logical volume %s in use", utils.GetNameKey(vg, name)),
+		fmt.Sprintf("Logical volume %s contains a filesystem in use", utils.GetNameKey(vg, name)),
+		fmt.Sprintf("Can't remove open logical volume \"%s\"", name),
+		fmt.Sprintf("Unable to deactivate open %s (", dmName),
+		fmt.Sprintf("Unable to deactivate open %s-cow (", dmName),
+	}
+}
+
+func isOriginBusyError(err error, vg, name string) bool {
+	for _, msg := range snapshotOpenMessages(vg, name) {
+		if strings.Contains(err.Error(), msg) {
+			return false
+		}
+	}
+	for _, msg := range originBusyMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferSnapshotRemoval tags snapshot lv failed to be removed because origin is
+// busy, agent retries removing it in every discovery. Other errors are returned
+func deferSnapshotRemoval(vg, name string, removeErr error) (string, error) {
+	if !isOriginBusyError(removeErr, vg, name) {
+		return "", removeErr
+	}
+	cmd := fmt.Sprintf("%s --addtag %s %s", lvmCmd("lvchange"), localtype.PendingDeletionLVTag, utils.GetNameKey(vg, name))
+	if _, err := cmdRunner(cmd); err != nil {
+		log.Errorf("[RemoveSnapshot]failed to mark snapshot %s/%s as pending deletion: %s", vg, name, err.Error())
+		return "", removeErr
+	}
+	log.Warningf("[RemoveSnapshot]origin of snapshot %s/%s is busy, it is removed later by agent: %s", vg, name, removeErr.Error())
+	return "lvm " + vg + "/" + name + " is pending deletion", nil
+}
+
 // CreateVG create volume group
 func (lvm *LvmCommads) CreateVG(ctx context.Context, name string, physicalVolume string, tags []string) (string, error) {
-	args := []string{localtype.NsenterCmd, "vgcreate", name, physicalVolume, "-v"}
+	args := []string{lvmCmd("vgcreate"), name, physicalVolume, "-v"}
 	for _, tag := range tags {
 		args = append(args, "--add-tag", tag)
 	}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 
 	return string(out), err
 }
@@ -397,9 +923,9 @@ func (lvm *LvmCommads) RemoveVG(ctx context.Context, name string) (string, error
 		}
 	}
 
-	args := []string{localtype.NsenterCmd, "vgremove", "-v", "-f", name}
+	args := []string{lvmCmd("vgremove"), "-v", "-f", name}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 
 	return string(out), err
 }
@@ -441,7 +967,7 @@ func (lvm *LvmCommads) CleanDevice(ctx context.Context, device string) (string,
 	args = append(args, device)
 
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 
 	return string(out), err
 }
@@ -458,15 +984,14 @@ func (lvm *LvmCommads) AddTagLV(ctx context.Context, vg string, name string, tag
 	}
 
 	args := make([]string, 0)
-	args = append(args, localtype.NsenterCmd)
-	args = append(args, "lvchange")
+	args = append(args, lvmCmd("lvchange"))
 	for _, tag := range tags {
 		args = append(args, "--addtag", tag)
 	}
 
 	args = append(args, utils.GetNameKey(vg, name))
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 
 	return string(out), err
 }
@@ -483,15 +1008,14 @@ func (lvm *LvmCommads) RemoveTagLV(ctx context.Context, vg string, name string,
 	}
 
 	args := make([]string, 0)
-	args = append(args, localtype.NsenterCmd)
-	args = append(args, "lvchange")
+	args = append(args, lvmCmd("lvchange"))
 	for _, tag := range tags {
 		args = append(args, "--deltag", tag)
 	}
 
 	args = append(args, utils.GetNameKey(vg, name))
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	return string(out), err
 }
 
@@ -503,7 +1027,7 @@ func (lvm *LvmCommads) CreateNameSpace(ctx context.Context, region string, name
 	}
 	args := []string{localtype.NsenterCmd, "ndctl", "create-namespace", "-r", region, "-s", fmt.Sprintf("%d", size), "-n", name}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	return string(out), err
 }
 
@@ -575,7 +1099,7 @@ func getTotalLimitKBFromCSV(in string) (totalLimit int64, err error) {
 func (lvm *LvmCommads) GetNamespaceAssignedQuota(namespace string) (int, error) {
 	args := []string{localtype.NsenterCmd, "repquota", "-P -O csv", fmt.Sprintf(ProjQuotaNamespacePrefix, namespace)}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		return 0, fmt.Errorf("failed to request namespace quota with error: %v", err)
 	}
@@ -611,7 +1135,7 @@ func checkSubpathProjQuotaEqual(projQuotaNamespacePath, projectID, blockHardLimi
 
 	args := []string{localtype.NsenterCmd, "repquota", "-P -O csv", projQuotaNamespacePath}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		return false, fmt.Errorf("failed to request namespace quota with error: %v", err)
 	}
@@ -630,7 +1154,7 @@ func (lvm *LvmCommads) SetSubpathProjQuota(ctx context.Context, projQuotaSubpath
 	projectID := ConvertString2int(filepath.Base(projQuotaSubpath))
 	args := []string{localtype.NsenterCmd, "setquota", "-P", fmt.Sprintf("%s %s %s 0 0 %s", projectID, blockHardlimit, blockHardlimit, filepath.Dir(projQuotaSubpath))}
 	cmd := strings.Join(args, " ")
-	_, err := utils.Run(cmd)
+	_, err := cmdRunner(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to set quota to subpath with error: %v", err)
 	}
@@ -645,7 +1169,7 @@ func (lvm *LvmCommads) SetSubpathProjQuota(ctx context.Context, projQuotaSubpath
 func (lvm *LvmCommads) RemoveProjQuotaSubpath(ctx context.Context, quotaSubpath string) (string, error) {
 	args := []string{localtype.NsenterCmd, "rm", "-rf", quotaSubpath}
 	cmd := strings.Join(args, " ")
-	out, err := utils.Run(cmd)
+	out, err := cmdRunner(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to remove proj quota subpath with error: %v", err)
 	}
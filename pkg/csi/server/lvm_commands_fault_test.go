@@ -0,0 +1,83 @@
+//go:build faultinjection
+// +build faultinjection
+
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils/fault"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_CreateLV_InjectedFault(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		allocation  string
+		wantCode    codes.Code
+		wantMessage string
+	}{
+		{
+			name:        "test no space in vg",
+			err:         errors.New(`Volume group "vg" has insufficient free space (0 extents): 256 required.`),
+			wantCode:    codes.Internal,
+			wantMessage: "insufficient free space",
+		},
+		{
+			name:        "test fragmented vg for contiguous allocation",
+			err:         errors.New("Insufficient suitable contiguous allocatable extents for logical volume lv: 256 more required"),
+			allocation:  "contiguous",
+			wantCode:    codes.ResourceExhausted,
+			wantMessage: "free space of vg may be fragmented",
+		},
+		{
+			name:        "test device busy",
+			err:         errors.New("Can't open /dev/vdb exclusively.  Mounted filesystem?"),
+			wantCode:    codes.Internal,
+			wantMessage: "Can't open /dev/vdb exclusively",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer fault.Inject("lvcreate", fault.Fault{Err: tt.err})()
+			s := NewServer(&LvmCommads{})
+			_, err := s.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "vg", Name: "lv", Size: 1024 * 1024 * 1024, AllocationPolicy: tt.allocation})
+			if status.Code(err) != tt.wantCode || !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("CreateLV() error = %v, want code %v with %q", err, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestServer_ExpandLV_InjectedFault(t *testing.T) {
+	// lv is listed as not found, then lvextend finds it in use
+	defer fault.Inject("lvs", fault.Fault{Err: errors.New(`Failed to find logical volume "vg/lv"`)})()
+	defer fault.Inject("lvextend", fault.Fault{Err: errors.New("Logical volume vg/lv in use.")})()
+	s := NewServer(&LvmCommads{})
+	_, err := s.ExpandLV(context.Background(), &lib.ExpandLVRequest{VolumeGroup: "vg", Name: "lv", Size: 2 * 1024 * 1024 * 1024})
+	if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("ExpandLV() error = %v, want Internal with lv in use", err)
+	}
+}
@@ -0,0 +1,649 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"golang.org/x/net/context"
+)
+
+// fakeRunner records every command and fails the one matching failOn
+type fakeRunner struct {
+	cmds []string
+	// full commands without nsenter prefix
+	full       []string
+	mountPoint string
+	failOn     string
+	// message of error returned by failOn, fake error if empty
+	failWith string
+	// checksums of devices read by head
+	checksums map[string]string
+	// origin lv reported by lvs
+	lvs string
+	// pvs reported by pvs
+	pvs string
+	// quota projects reported by repquota
+	repquota string
+	// mount source reported by findmnt
+	findmnt string
+}
+
+func (f *fakeRunner) run(cmd string) (string, error) {
+	fields := strings.Fields(strings.TrimPrefix(cmd, localtype.NsenterCmd))
+	name := strings.Join(fields[:2], " ")
+	f.cmds = append(f.cmds, name)
+	f.full = append(f.full, strings.Join(fields, " "))
+	if f.failOn != "" && name == f.failOn {
+		if f.failWith != "" {
+			return "", errors.New(f.failWith)
+		}
+		return "", errors.New("fake error")
+	}
+	switch fields[0] {
+	case "lsblk":
+		return f.mountPoint + "\n", nil
+	case "blockdev":
+		return "1024\n", nil
+	case "head":
+		return f.checksums[fields[3]] + "  -\n", nil
+	case "lvs":
+		return f.lvs, nil
+	case "pvs":
+		return f.pvs, nil
+	case "repquota":
+		return f.repquota, nil
+	case "findmnt":
+		return f.findmnt, nil
+	}
+	return "", nil
+}
+
+func (f *fakeRunner) runContext(ctx context.Context, cmd string) (string, error) {
+	return f.run(cmd)
+}
+
+func Test_LvmCommads_CreateSnapshot_FsFreeze(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsFreeze   bool
+		mountPoint string
+		failOn     string
+		wantCmds   []string
+		wantErr    bool
+	}{
+		{
+			name:       "test freeze disabled",
+			fsFreeze:   false,
+			mountPoint: "/mnt/test",
+			wantCmds:   []string{"lvs --units=b", "lvcreate -s"},
+			wantErr:    false,
+		},
+		{
+			name:       "test freeze before and thaw after snapshot",
+			fsFreeze:   true,
+			mountPoint: "/mnt/test",
+			wantCmds:   []string{"lvs --units=b", "lsblk -n", "fsfreeze -f", "lvcreate -s", "fsfreeze -u"},
+			wantErr:    false,
+		},
+		{
+			name:       "test thaw when snapshot failed",
+			fsFreeze:   true,
+			mountPoint: "/mnt/test",
+			failOn:     "lvcreate -s",
+			wantCmds:   []string{"lvs --units=b", "lsblk -n", "fsfreeze -f", "lvcreate -s", "fsfreeze -u"},
+			wantErr:    true,
+		},
+		{
+			name:       "test snapshot not taken when freeze failed",
+			fsFreeze:   true,
+			mountPoint: "/mnt/test",
+			failOn:     "fsfreeze -f",
+			wantCmds:   []string{"lvs --units=b", "lsblk -n", "fsfreeze -f"},
+			wantErr:    true,
+		},
+		{
+			name:       "test error when thaw failed",
+			fsFreeze:   true,
+			mountPoint: "/mnt/test",
+			failOn:     "fsfreeze -u",
+			wantCmds:   []string{"lvs --units=b", "lsblk -n", "fsfreeze -f", "lvcreate -s", "fsfreeze -u"},
+			wantErr:    true,
+		},
+		{
+			name:       "test skip freeze when origin not mounted",
+			fsFreeze:   true,
+			mountPoint: "",
+			wantCmds:   []string{"lvs --units=b", "lsblk -n", "lvcreate -s"},
+			wantErr:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{mountPoint: tt.mountPoint, failOn: tt.failOn, lvs: "  vg<:SEP:>-wi-ao----<:SEP:>10737418240\n"}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			lvm := &LvmCommads{}
+			_, err := lvm.CreateSnapshot(context.Background(), "vg", "snap", "origin", "", true, 1024, nil, tt.fsFreeze, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(runner.cmds, tt.wantCmds) {
+				t.Errorf("CreateSnapshot() cmds = %v, want %v", runner.cmds, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func Test_checkSnapshotOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		lvs     string
+		size    uint64
+		wantErr error
+		// substring of error message
+		wantMsg string
+	}{
+		{
+			name: "test origin in vg with enough space",
+			lvs:  "  vg<:SEP:>-wi-ao----<:SEP:>4294967296\n",
+			size: 4294967296,
+		},
+		{
+			name:    "test vg of origin lacks space",
+			lvs:     "  vg<:SEP:>-wi-ao----<:SEP:>1073741824\n",
+			size:    4294967296,
+			wantErr: ErrSnapshotNoSpace,
+			wantMsg: "vg vg has 1073741824 bytes free but snapshot of lv origin requires 4294967296 bytes",
+		},
+		{
+			name:    "test origin is a snapshot",
+			lvs:     "  vg<:SEP:>swi-a-s---<:SEP:>4294967296\n",
+			size:    1024,
+			wantErr: ErrSnapshotOfSnapshot,
+			wantMsg: "origin lv vg/origin is a snapshot",
+		},
+		{
+			name:    "test origin in another vg",
+			lvs:     "  other<:SEP:>-wi-ao----<:SEP:>4294967296\n",
+			size:    1024,
+			wantMsg: "origin lv origin is in vg other rather than vg vg",
+		},
+		{
+			name:    "test origin not found",
+			size:    1024,
+			wantMsg: "origin lv origin is not found in vg vg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{lvs: tt.lvs}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			err := checkSnapshotOrigin("vg", "origin", tt.size)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Errorf("checkSnapshotOrigin() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("checkSnapshotOrigin() error = %v, want %q", err, tt.wantMsg)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkSnapshotOrigin() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_LvmCommads_CreateSnapshot_NoSpace(t *testing.T) {
+	runner := &fakeRunner{lvs: "  vg<:SEP:>-wi-ao----<:SEP:>512\n"}
+	origin := cmdRunner
+	cmdRunner = runner.run
+	defer func() { cmdRunner = origin }()
+
+	lvm := &LvmCommads{}
+	if _, err := lvm.CreateSnapshot(context.Background(), "vg", "snap", "origin", "", true, 1024, nil, false, nil); !errors.Is(err, ErrSnapshotNoSpace) {
+		t.Errorf("CreateSnapshot() error = %v, want %v", err, ErrSnapshotNoSpace)
+	}
+	// no other vg is tried
+	if want := []string{"lvs --units=b"}; !reflect.DeepEqual(runner.cmds, want) {
+		t.Errorf("CreateSnapshot() cmds = %v, want %v", runner.cmds, want)
+	}
+}
+
+func Test_LvmCommads_CreateSnapshot_COWPVs(t *testing.T) {
+	pvs := "  /dev/sdb<:SEP:>vg<:SEP:>0\n  /dev/sdc<:SEP:>vg<:SEP:>2048\n  /dev/sdd<:SEP:>other<:SEP:>4096\n"
+	tests := []struct {
+		name       string
+		cowPVs     []string
+		wantErr    error
+		wantCreate string
+	}{
+		{
+			name:       "test cow data on pvs of vg",
+			cowPVs:     []string{"/dev/sdc"},
+			wantCreate: "lvcreate -s -n snap -L 1024b --alloc cling vg/origin /dev/sdc -y",
+		},
+		{
+			name:       "test anywhere in vg if unset",
+			wantCreate: "lvcreate -s -n snap -L 1024b vg/origin -y",
+		},
+		{
+			name:    "test pv in another vg",
+			cowPVs:  []string{"/dev/sdd"},
+			wantErr: ErrSnapshotCOWPVNotFound,
+		},
+		{
+			name:    "test pv not found",
+			cowPVs:  []string{"/dev/sde"},
+			wantErr: ErrSnapshotCOWPVNotFound,
+		},
+		{
+			name:    "test pvs lack space",
+			cowPVs:  []string{"/dev/sdb"},
+			wantErr: ErrSnapshotNoSpace,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{lvs: "  vg<:SEP:>-wi-ao----<:SEP:>10737418240\n", pvs: pvs}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			lvm := &LvmCommads{}
+			_, err := lvm.CreateSnapshot(context.Background(), "vg", "snap", "origin", "", true, 1024, tt.cowPVs, false, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CreateSnapshot() error = %v, want %v", err, tt.wantErr)
+				}
+				for _, cmd := range runner.cmds {
+					if cmd == "lvcreate -s" {
+						t.Errorf("CreateSnapshot() creates snapshot on validation failure")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateSnapshot() error = %v", err)
+			}
+			if got := runner.full[len(runner.full)-1]; got != tt.wantCreate {
+				t.Errorf("CreateSnapshot() runs %q, want %q", got, tt.wantCreate)
+			}
+		})
+	}
+}
+
+func Test_deferSnapshotRemoval(t *testing.T) {
+	busyErr := errors.New("Failed to run cmd: lvremove -v -f vg/snap, with out: device-mapper: suspend ioctl on (253:2) failed: Device or resource busy\n  Failed to suspend origin lv")
+	tests := []struct {
+		name     string
+		err      error
+		failOn   string
+		wantCmds []string
+		wantErr  bool
+	}{
+		{
+			name:     "test origin busy",
+			err:      busyErr,
+			wantCmds: []string{"lvchange --addtag"},
+		},
+		{
+			name:     "test origin real device open",
+			err:      errors.New("Failed to run cmd: lvremove -v -f vg/snap, with out: Unable to deactivate open vg-lv-real (253:3)"),
+			wantCmds: []string{"lvchange --addtag"},
+		},
+		{
+			name:    "test snapshot in use",
+			err:     errors.New("Failed to run cmd: lvremove -v -f vg/snap, with out: Logical volume vg/snap in use."),
+			wantErr: true,
+		},
+		{
+			name:    "test snapshot open",
+			err:     errors.New("Failed to run cmd: lvremove -v -f vg/snap, with out: Unable to deactivate open vg-snap (253:4)"),
+			wantErr: true,
+		},
+		{
+			name:     "test other error",
+			err:      errors.New("Failed to run cmd: lvremove -v -f vg/snap, with out: Volume group \"vg\" not found"),
+			wantCmds: nil,
+			wantErr:  true,
+		},
+		{
+			name:     "test tagging failed",
+			err:      busyErr,
+			failOn:   "lvchange --addtag",
+			wantCmds: []string{"lvchange --addtag"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{failOn: tt.failOn}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			_, err := deferSnapshotRemoval("vg", "snap", tt.err)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deferSnapshotRemoval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(runner.cmds, tt.wantCmds) {
+				t.Errorf("deferSnapshotRemoval() cmds = %v, want %v", runner.cmds, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func Test_removeOrphanedSnapshots(t *testing.T) {
+	snapshots := "  snap-user  \n  snap-pending  open-local.io/pending-deletion\n  snap-expansion  foo,open-local.io/expansion-snapshot\n"
+	tests := []struct {
+		name         string
+		lvs          string
+		cascade      bool
+		wantFull     []string
+		wantBlocking []string
+	}{
+		{
+			name:     "test no snapshot",
+			lvs:      "",
+			wantFull: []string{"lvs -o lv_name,lv_tags -S origin=origin,vg_name=vg --noheadings --nosuffix"},
+		},
+		{
+			name:         "test refuse by default",
+			lvs:          snapshots,
+			wantFull:     []string{"lvs -o lv_name,lv_tags -S origin=origin,vg_name=vg --noheadings --nosuffix"},
+			wantBlocking: []string{"snap-user", "snap-pending", "snap-expansion"},
+		},
+		{
+			name:    "test cascade keeps snapshot of user",
+			lvs:     snapshots,
+			cascade: true,
+			wantFull: []string{
+				"lvs -o lv_name,lv_tags -S origin=origin,vg_name=vg --noheadings --nosuffix",
+				"lvremove -v -f vg/snap-pending",
+				"lvremove -v -f vg/snap-expansion",
+			},
+			wantBlocking: []string{"snap-user"},
+		},
+		{
+			name:    "test cascade removes orphaned snapshots",
+			lvs:     "  snap-pending  open-local.io/pending-deletion\n",
+			cascade: true,
+			wantFull: []string{
+				"lvs -o lv_name,lv_tags -S origin=origin,vg_name=vg --noheadings --nosuffix",
+				"lvremove -v -f vg/snap-pending",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{lvs: tt.lvs}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			err := removeOrphanedSnapshots("vg", "origin", tt.cascade)
+			if len(tt.wantBlocking) == 0 {
+				if err != nil {
+					t.Errorf("removeOrphanedSnapshots() error = %v", err)
+				}
+			} else {
+				if !errors.Is(err, ErrOriginHasSnapshots) {
+					t.Fatalf("removeOrphanedSnapshots() error = %v, want %v", err, ErrOriginHasSnapshots)
+				}
+				if !strings.Contains(err.Error(), strings.Join(tt.wantBlocking, ", ")+",") {
+					t.Errorf("removeOrphanedSnapshots() error = %v, want blocking snapshots %v", err, tt.wantBlocking)
+				}
+			}
+			if !reflect.DeepEqual(runner.full, tt.wantFull) {
+				t.Errorf("removeOrphanedSnapshots() cmds = %v, want %v", runner.full, tt.wantFull)
+			}
+		})
+	}
+}
+
+func Test_LvmCommads_ZeroLV(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOn   string
+		wantCmds []string
+		wantErr  bool
+	}{
+		{
+			name:     "test zero lv",
+			wantCmds: []string{"blockdev --getsize64", "blkdiscard --zeroout", "lvchange --deltag"},
+		},
+		{
+			// zeroing tag is kept so that lv is zeroed again
+			name:     "test zeroing failed",
+			failOn:   "blkdiscard --zeroout",
+			wantCmds: []string{"blockdev --getsize64", "blkdiscard --zeroout"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{failOn: tt.failOn}
+			origin, originContext := cmdRunner, cmdContextRunner
+			cmdRunner, cmdContextRunner = runner.run, runner.runContext
+			defer func() { cmdRunner, cmdContextRunner = origin, originContext }()
+
+			lvm := &LvmCommads{}
+			_, err := lvm.ZeroLV(context.Background(), "vg", "lv")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ZeroLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(runner.cmds, tt.wantCmds) {
+				t.Errorf("ZeroLV() cmds = %v, want %v", runner.cmds, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func Test_LvmCommads_CreateLV_AllocationPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		allocation string
+		failWith   string
+		wantCmds   []string
+		wantErr    error
+	}{
+		{
+			name:     "test policy of vg",
+			wantCmds: []string{"lvcreate -n lv -L 1024b -W y -y vg"},
+		},
+		{
+			name:       "test contiguous",
+			allocation: "contiguous",
+			wantCmds:   []string{"lvcreate -n lv -L 1024b -W y -y --alloc contiguous vg"},
+		},
+		{
+			name:       "test unknown policy",
+			allocation: "inherit",
+			wantErr:    ErrInvalidAllocationPolicy,
+		},
+		{
+			name:       "test contiguous not satisfied",
+			allocation: "contiguous",
+			failWith:   "Insufficient suitable contiguous allocatable extents for logical volume lv: 256 more required",
+			wantCmds:   []string{"lvcreate -n lv -L 1024b -W y -y --alloc contiguous vg"},
+			wantErr:    ErrAllocationNoSpace,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{failWith: tt.failWith}
+			if tt.failWith != "" {
+				runner.failOn = "lvcreate -n"
+			}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			lvm := &LvmCommads{}
+			_, err := lvm.CreateLV(context.Background(), "vg", "lv", 1024, 0, nil, false, tt.allocation)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(runner.full, tt.wantCmds) {
+				t.Errorf("CreateLV() cmds = %v, want %v", runner.full, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func Test_LvmCommads_discardOnRemove(t *testing.T) {
+	tests := []struct {
+		name            string
+		tags            []string
+		discardMaxBytes string
+		want            bool
+		wantCmd         string
+	}{
+		{
+			name:            "test discard on ssd",
+			tags:            []string{localtype.ManagedLVTag, localtype.DiscardOnDeleteLVTag},
+			discardMaxBytes: "2147450880",
+			want:            true,
+			wantCmd:         localtype.NsenterCmd + " lvremove -v -f --config devices/issue_discards=1 vg/lv",
+		},
+		{
+			name:            "test discard on hdd",
+			tags:            []string{localtype.ManagedLVTag, localtype.DiscardOnDeleteLVTag},
+			discardMaxBytes: "0",
+			want:            false,
+			wantCmd:         localtype.NsenterCmd + " lvremove -v -f vg/lv",
+		},
+		{
+			name:            "test discard not enabled",
+			tags:            []string{localtype.ManagedLVTag},
+			discardMaxBytes: "2147450880",
+			want:            false,
+			wantCmd:         localtype.NsenterCmd + " lvremove -v -f vg/lv",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysPath := t.TempDir()
+			queue := filepath.Join(sysPath, "dev/block/253:3/queue")
+			if err := os.MkdirAll(queue, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(queue, "discard_max_bytes"), []byte(tt.discardMaxBytes+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			lvm := &LvmCommads{sysPath: sysPath}
+			lv := &lib.LV{Name: "lv", ActualDevMajNumber: 253, ActualDevMinNumber: 3, Tags: tt.tags}
+			got := lvm.discardOnRemove(lv)
+			if got != tt.want {
+				t.Errorf("discardOnRemove() = %v, want %v", got, tt.want)
+			}
+			if cmd := lvremoveCmd("vg", "lv", got); cmd != tt.wantCmd {
+				t.Errorf("lvremoveCmd() = %q, want %q", cmd, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func Test_zeroProgress(t *testing.T) {
+	if got, want := zeroProgress(512, 2048), "zeroed 512 of 2048 bytes (25%)"; got != want {
+		t.Errorf("zeroProgress() = %q, want %q", got, want)
+	}
+}
+
+func Test_LvmCommads_CloneLV_VerifyChecksum(t *testing.T) {
+	src := "/dev/vg/snap"
+	dest := "/dev/vg/lv"
+	tests := []struct {
+		name           string
+		verifyChecksum bool
+		checksums      map[string]string
+		failOn         string
+		wantCmds       []string
+		wantErr        bool
+	}{
+		{
+			name:           "test verification disabled",
+			verifyChecksum: false,
+			wantCmds:       []string{"dd if=" + src},
+			wantErr:        false,
+		},
+		{
+			name:           "test checksum matched",
+			verifyChecksum: true,
+			checksums:      map[string]string{src: "abc", dest: "abc"},
+			wantCmds:       []string{"dd if=" + src, "blockdev --getsize64", "head -c", "head -c"},
+			wantErr:        false,
+		},
+		{
+			name:           "test checksum mismatched",
+			verifyChecksum: true,
+			checksums:      map[string]string{src: "abc", dest: "abd"},
+			wantCmds:       []string{"dd if=" + src, "blockdev --getsize64", "head -c", "head -c"},
+			wantErr:        true,
+		},
+		{
+			name:           "test checksum not calculated when copy failed",
+			verifyChecksum: true,
+			failOn:         "dd if=" + src,
+			wantCmds:       []string{"dd if=" + src},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{failOn: tt.failOn, checksums: tt.checksums}
+			origin, originContext := cmdRunner, cmdContextRunner
+			cmdRunner, cmdContextRunner = runner.run, runner.runContext
+			defer func() { cmdRunner, cmdContextRunner = origin, originContext }()
+
+			lvm := &LvmCommads{}
+			_, err := lvm.CloneLV(context.Background(), src, dest, tt.verifyChecksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloneLV() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(runner.cmds, tt.wantCmds) {
+				t.Errorf("CloneLV() cmds = %v, want %v", runner.cmds, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func Test_parsePEStarts(t *testing.T) {
+	out := "  /dev/vdb 1048576\n  /dev/vdc 196608\n  unknown device\n"
+	want := map[string]uint64{"/dev/vdb": 1048576, "/dev/vdc": 196608}
+	if got := parsePEStarts(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePEStarts() = %v, want %v", got, want)
+	}
+}
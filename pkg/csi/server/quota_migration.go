@@ -0,0 +1,376 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// quotaMigrationStagingSuffix marks the directory where new lv is mounted
+	// while content of quota directory is copied
+	quotaMigrationStagingSuffix = ".migrating"
+	// quotaMigrationOldSuffix marks the quota directory moved aside, which is
+	// removed once lv is mounted in place
+	quotaMigrationOldSuffix = ".old"
+	// DefaultQuotaMountsFile records lvs mounted at migrated quota
+	// directories, csi plugin mounts them again after node reboots
+	DefaultQuotaMountsFile = "/var/lib/kubelet/open-local-quota-mounts.json"
+)
+
+// ErrQuotaPathMounted is returned if quota directory is a mount point already,
+// such as a migrated one
+var ErrQuotaPathMounted = errors.New("quota directory is a mount point already")
+
+// QuotaMigration moves content of a project quota directory into a dedicated
+// lv which is then mounted at the same path
+type QuotaMigration struct {
+	// QuotaPath is the quota directory, in form of /mnt/quotapath.<namespace>/<subpath>
+	QuotaPath string
+	VGName    string
+	LVName    string
+	// Size is the size of lv in bytes, 0 means the block hard limit of quota project
+	Size   uint64
+	FsType string
+	// MountsFile is the file recording mount of lv, empty means DefaultQuotaMountsFile
+	MountsFile string
+	// Progress receives a message before every step, may be nil
+	Progress func(msg string)
+}
+
+// QuotaMount is a lv mounted at a migrated quota directory
+type QuotaMount struct {
+	QuotaPath string `json:"quotaPath"`
+	Device    string `json:"device"`
+	FsType    string `json:"fsType"`
+}
+
+// migrationStep is an action of migration, undo reverts a done step and may
+// be nil if nothing needs to be reverted
+type migrationStep struct {
+	name string
+	do   func() error
+	undo func() error
+}
+
+// MigrateQuotaToLV migrates quota directory to lv. Pods using the directory
+// must be stopped. Every step done so far is reverted in reverse order if any
+// step fails, and the quota directory is left as it was. Mount of lv is
+// recorded in MountsFile, which is restored by RestoreQuotaMounts.
+func MigrateQuotaToLV(m *QuotaMigration) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if out, _ := cmdRunner(fmt.Sprintf("%s findmnt -n -o SOURCE -M %s", localtype.NsenterCmd, m.QuotaPath)); strings.TrimSpace(out) != "" {
+		return fmt.Errorf("%w: %s is mounted from %s", ErrQuotaPathMounted, m.QuotaPath, strings.TrimSpace(out))
+	}
+	nsPath := filepath.Dir(m.QuotaPath)
+	projectID := ConvertString2int(filepath.Base(m.QuotaPath))
+	out, err := cmdRunner(strings.Join([]string{localtype.NsenterCmd, "repquota", "-P -O csv", nsPath}, " "))
+	if err != nil {
+		return fmt.Errorf("failed to request quota of %s: %s", nsPath, err.Error())
+	}
+	softLimit, hardLimit, err := findBlockLimitByProjectID(out, projectID)
+	if err != nil {
+		return err
+	}
+	if m.Size == 0 {
+		hardLimitKB, err := strconv.ParseUint(strings.TrimSpace(hardLimit), 10, 64)
+		if err != nil || hardLimitKB == 0 {
+			return fmt.Errorf("quota project %s of %s has no block hard limit %q, size of lv is required", projectID, m.QuotaPath, hardLimit)
+		}
+		m.Size = hardLimitKB * 1024
+	}
+
+	dev := filepath.Join("/dev", m.VGName, m.LVName)
+	staging := m.QuotaPath + quotaMigrationStagingSuffix
+	old := m.QuotaPath + quotaMigrationOldSuffix
+	steps := []migrationStep{
+		{
+			name: fmt.Sprintf("create lv %s/%s of %d bytes", m.VGName, m.LVName, m.Size),
+			do:   lockedStep(lvmStep("lvcreate", "-n", m.LVName, "-L", fmt.Sprintf("%db", m.Size), "-W", "y", "-y", m.VGName), m.VGName),
+			undo: lockedStep(lvmStep("lvremove", "-f", m.VGName+"/"+m.LVName), m.VGName),
+		},
+		{
+			name: fmt.Sprintf("format %s with %s", dev, m.FsType),
+			do:   runStep("mkfs", "-t", m.FsType, dev),
+		},
+		{
+			name: fmt.Sprintf("create staging directory %s", staging),
+			do:   runStep("mkdir", "-p", staging),
+			undo: runStep("rmdir", staging),
+		},
+		{
+			name: fmt.Sprintf("mount %s at %s", dev, staging),
+			do:   runStep("mount", "-t", m.FsType, dev, staging),
+			undo: runStep("umount", staging),
+		},
+		{
+			// partly copied content is dropped along with lv on rollback
+			name: fmt.Sprintf("copy content of %s to %s", m.QuotaPath, staging),
+			do:   runStep("cp", "-a", m.QuotaPath+"/.", staging),
+		},
+		{
+			name: fmt.Sprintf("move %s to %s", m.QuotaPath, old),
+			do:   runStep("mv", "-T", m.QuotaPath, old),
+			undo: runStep("mv", "-T", old, m.QuotaPath),
+		},
+		{
+			name: fmt.Sprintf("create mount point %s", m.QuotaPath),
+			do:   runStep("mkdir", m.QuotaPath),
+			undo: runStep("rmdir", m.QuotaPath),
+		},
+		{
+			name: fmt.Sprintf("mount %s at %s", dev, m.QuotaPath),
+			do:   runStep("mount", "-t", m.FsType, dev, m.QuotaPath),
+			undo: runStep("umount", m.QuotaPath),
+		},
+		{
+			name: fmt.Sprintf("record mount of %s at %s in %s", dev, m.QuotaPath, m.MountsFile),
+			do: func() error {
+				return recordQuotaMount(m.MountsFile, QuotaMount{QuotaPath: m.QuotaPath, Device: dev, FsType: m.FsType})
+			},
+			undo: func() error { return forgetQuotaMount(m.MountsFile, m.QuotaPath) },
+		},
+		{
+			name: fmt.Sprintf("remove quota project %s of %s", projectID, nsPath),
+			do:   runStep("setquota", "-P", projectID, "0", "0", "0", "0", nsPath),
+			undo: runStep("setquota", "-P", projectID, softLimit, hardLimit, "0", "0", nsPath),
+		},
+	}
+	if err := runMigrationSteps(steps, m.progress); err != nil {
+		return err
+	}
+
+	// lv is in place, failure of cleanup only leaves garbage behind
+	cleanups := []migrationStep{
+		{name: fmt.Sprintf("unmount staging directory %s", staging), do: runStep("umount", staging)},
+		{name: fmt.Sprintf("remove staging directory %s", staging), do: runStep("rmdir", staging)},
+		{name: fmt.Sprintf("remove old quota directory %s", old), do: runStep("rm", "-rf", old)},
+	}
+	var cleanupErrs []string
+	for _, step := range cleanups {
+		m.progress(step.name)
+		if err := step.do(); err != nil {
+			log.Warningf("[MigrateQuotaToLV]failed to %s: %s", step.name, err.Error())
+			cleanupErrs = append(cleanupErrs, err.Error())
+		}
+	}
+	if len(cleanupErrs) > 0 {
+		return fmt.Errorf("%s is migrated to lv %s/%s, but cleanup failed: %s", m.QuotaPath, m.VGName, m.LVName, strings.Join(cleanupErrs, "; "))
+	}
+	return nil
+}
+
+func (m *QuotaMigration) validate() error {
+	quotaPath := filepath.Clean(m.QuotaPath)
+	nsPath := filepath.Dir(quotaPath)
+	if !strings.HasPrefix(nsPath, strings.TrimSuffix(ProjQuotaNamespacePrefix, "%s")) || filepath.Dir(nsPath) != "/mnt" {
+		return fmt.Errorf("quota directory %q must be in form of %s", m.QuotaPath, fmt.Sprintf(ProjQuotaPrefix, "<namespace>", "<subpath>"))
+	}
+	m.QuotaPath = quotaPath
+	if m.VGName == "" || m.LVName == "" {
+		return fmt.Errorf("vg and lv are required")
+	}
+	if m.FsType == "" {
+		m.FsType = localtype.VolumeFSTypeExt4
+	}
+	if m.MountsFile == "" {
+		m.MountsFile = DefaultQuotaMountsFile
+	}
+	if utils.StringsContains(localtype.SupportedFS, m.FsType) == -1 {
+		return fmt.Errorf("filesystem %s is not supported, must be one of %v", m.FsType, localtype.SupportedFS)
+	}
+	return nil
+}
+
+func (m *QuotaMigration) progress(msg string) {
+	log.Infof("[MigrateQuotaToLV]%s", msg)
+	if m.Progress != nil {
+		m.Progress(msg)
+	}
+}
+
+// runMigrationSteps runs steps in order, done steps are reverted in reverse
+// order once a step fails
+func runMigrationSteps(steps []migrationStep, progress func(msg string)) error {
+	for i, step := range steps {
+		progress(fmt.Sprintf("[%d/%d] %s", i+1, len(steps), step.name))
+		err := step.do()
+		if err == nil {
+			continue
+		}
+		var rollbackErrs []string
+		for j := i - 1; j >= 0; j-- {
+			if steps[j].undo == nil {
+				continue
+			}
+			log.Infof("[MigrateQuotaToLV]rollback: %s", steps[j].name)
+			if undoErr := steps[j].undo(); undoErr != nil {
+				log.Errorf("[MigrateQuotaToLV]failed to roll back %s: %s", steps[j].name, undoErr.Error())
+				rollbackErrs = append(rollbackErrs, fmt.Sprintf("%s: %s", steps[j].name, undoErr.Error()))
+			}
+		}
+		if len(rollbackErrs) > 0 {
+			return fmt.Errorf("failed to %s: %s, rollback failed: %s", step.name, err.Error(), strings.Join(rollbackErrs, "; "))
+		}
+		return fmt.Errorf("failed to %s: %s, migration is rolled back", step.name, err.Error())
+	}
+	return nil
+}
+
+// runStep returns a step action running command in host namespaces
+func runStep(args ...string) func() error {
+	return func() error {
+		_, err := cmdRunner(strings.Join(append([]string{localtype.NsenterCmd}, args...), " "))
+		return err
+	}
+}
+
+// lvmStep returns migration step running lvm cmd built by lvmCmd
+func lvmStep(cmd string, args ...string) func() error {
+	return func() error {
+		_, err := cmdRunner(lvmCmd(cmd, args...))
+		return err
+	}
+}
+
+// lockedStep returns step action running do with vgs locked and allowed by
+// the rate limit of mutating lvm operations, the same as operations of lvmd.
+// Vgs are locked in order of name so that steps locking the same vgs never
+// deadlock
+func lockedStep(do func() error, vgs ...string) func() error {
+	return func() error {
+		names := append([]string(nil), vgs...)
+		sort.Strings(names)
+		for i, vg := range names {
+			if i > 0 && vg == names[i-1] {
+				continue
+			}
+			unlock, err := lvm.LockVolumeGroup(context.Background(), vg)
+			if err != nil {
+				return fmt.Errorf("fail to lock vg %s: %s", vg, err.Error())
+			}
+			defer unlock()
+		}
+		if err := lvm.WaitMutatingOp(context.Background()); err != nil {
+			return err
+		}
+		return do()
+	}
+}
+
+// RestoreQuotaMounts mounts lvs recorded in file at their quota directories
+// again, mount point already mounted is skipped. It is run by csi plugin on
+// start, since mounts of migrated quota directories are lost after reboot
+func RestoreQuotaMounts(file string) error {
+	mounts, err := LoadQuotaMounts(file)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, mount := range mounts {
+		if out, _ := cmdRunner(fmt.Sprintf("%s findmnt -n -o SOURCE -M %s", localtype.NsenterCmd, mount.QuotaPath)); strings.TrimSpace(out) != "" {
+			continue
+		}
+		log.Infof("[RestoreQuotaMounts]mount %s at migrated quota directory %s", mount.Device, mount.QuotaPath)
+		if err := runStep("mount", "-t", mount.FsType, mount.Device, mount.QuotaPath)(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", mount.QuotaPath, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to restore mount of migrated quota directories: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LoadQuotaMounts returns mounts of migrated quota directories recorded in
+// file, which is empty if file does not exist
+func LoadQuotaMounts(file string) ([]QuotaMount, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to read quota mounts file %s: %s", file, err.Error())
+	}
+	var mounts []QuotaMount
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, fmt.Errorf("fail to parse quota mounts file %s: %s", file, err.Error())
+	}
+	return mounts, nil
+}
+
+// recordQuotaMount adds mount to file, replacing the one of the same quota directory
+func recordQuotaMount(file string, mount QuotaMount) error {
+	mounts, err := LoadQuotaMounts(file)
+	if err != nil {
+		return err
+	}
+	for i := range mounts {
+		if mounts[i].QuotaPath == mount.QuotaPath {
+			mounts[i] = mount
+			return saveQuotaMounts(file, mounts)
+		}
+	}
+	return saveQuotaMounts(file, append(mounts, mount))
+}
+
+// forgetQuotaMount removes mount of quota directory from file
+func forgetQuotaMount(file, quotaPath string) error {
+	mounts, err := LoadQuotaMounts(file)
+	if err != nil {
+		return err
+	}
+	left := make([]QuotaMount, 0, len(mounts))
+	for _, mount := range mounts {
+		if mount.QuotaPath != quotaPath {
+			left = append(left, mount)
+		}
+	}
+	return saveQuotaMounts(file, left)
+}
+
+// saveQuotaMounts writes mounts to a temporary file renamed to file, so that
+// file is never left partly written
+func saveQuotaMounts(file string, mounts []QuotaMount) error {
+	data, err := json.Marshal(mounts)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("fail to write quota mounts file %s: %s", tmp, err.Error())
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("fail to replace quota mounts file %s: %s", file, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+)
+
+func Test_MigrateQuotaToLV(t *testing.T) {
+	quotaPath := "/mnt/quotapath.default/pv-test"
+	repquota := fmt.Sprintf("Project,BlockStatus,FileStatus,BlockUsed,BlockSoftLimit,BlockHardLimit,BlockGrace,FileUsed,FileSoftLimit,FileHardLimit,FileGrace\n"+
+		"#0,ok,ok,0,0,0,,2,0,0,\n"+
+		"#%s,ok,ok,2048,1048576,1048576,,10,0,0,\n", ConvertString2int("pv-test"))
+	migrateCmds := []string{
+		"findmnt -n",
+		"repquota -P",
+		"lvcreate -n",
+		"mkfs -t",
+		"mkdir -p",
+		"mount -t",
+		"cp -a",
+		"mv -T",
+		"mkdir " + quotaPath,
+		"mount -t",
+		"setquota -P",
+	}
+	wantMount := QuotaMount{QuotaPath: quotaPath, Device: "/dev/vg/lv", FsType: "ext4"}
+	tests := []struct {
+		name     string
+		size     uint64
+		failOn   string
+		wantSize uint64
+		wantCmds []string
+		wantErr  bool
+		// mount recorded in mounts file
+		wantMounts []QuotaMount
+	}{
+		{
+			name:     "test copy and switch mount",
+			wantSize: 1048576 * 1024,
+			wantCmds: append(append([]string{}, migrateCmds...),
+				"umount "+quotaPath+quotaMigrationStagingSuffix,
+				"rmdir "+quotaPath+quotaMigrationStagingSuffix,
+				"rm -rf"),
+			wantMounts: []QuotaMount{wantMount},
+		},
+		{
+			name:     "test size specified",
+			size:     2 * 1024 * 1024 * 1024,
+			wantSize: 2 * 1024 * 1024 * 1024,
+			wantCmds: append(append([]string{}, migrateCmds...),
+				"umount "+quotaPath+quotaMigrationStagingSuffix,
+				"rmdir "+quotaPath+quotaMigrationStagingSuffix,
+				"rm -rf"),
+			wantMounts: []QuotaMount{wantMount},
+		},
+		{
+			name:     "test rollback when copy failed",
+			failOn:   "cp -a",
+			wantSize: 1048576 * 1024,
+			wantCmds: append(append([]string{}, migrateCmds[:7]...),
+				"umount "+quotaPath+quotaMigrationStagingSuffix,
+				"rmdir "+quotaPath+quotaMigrationStagingSuffix,
+				"lvremove -f"),
+			wantErr: true,
+		},
+		{
+			name:     "test rollback when quota project is not removed",
+			failOn:   "setquota -P",
+			wantSize: 1048576 * 1024,
+			wantCmds: append(append([]string{}, migrateCmds...),
+				"umount "+quotaPath,
+				"rmdir "+quotaPath,
+				"mv -T",
+				"umount "+quotaPath+quotaMigrationStagingSuffix,
+				"rmdir "+quotaPath+quotaMigrationStagingSuffix,
+				"lvremove -f"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{failOn: tt.failOn, repquota: repquota}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			var progress []string
+			mountsFile := filepath.Join(t.TempDir(), "quota-mounts.json")
+			m := &QuotaMigration{
+				QuotaPath:  quotaPath,
+				VGName:     "vg",
+				LVName:     "lv",
+				Size:       tt.size,
+				MountsFile: mountsFile,
+				Progress:   func(msg string) { progress = append(progress, msg) },
+			}
+			err := MigrateQuotaToLV(m)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MigrateQuotaToLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if m.Size != tt.wantSize {
+				t.Errorf("MigrateQuotaToLV() size = %d, want %d", m.Size, tt.wantSize)
+			}
+			if !reflect.DeepEqual(runner.cmds, tt.wantCmds) {
+				t.Errorf("MigrateQuotaToLV() cmds = %v, want %v", runner.cmds, tt.wantCmds)
+			}
+			if len(progress) == 0 {
+				t.Errorf("MigrateQuotaToLV() reports no progress")
+			}
+			mounts, err := LoadQuotaMounts(mountsFile)
+			if err != nil {
+				t.Fatalf("LoadQuotaMounts() error = %v", err)
+			}
+			if len(mounts)+len(tt.wantMounts) > 0 && !reflect.DeepEqual(mounts, tt.wantMounts) {
+				t.Errorf("MigrateQuotaToLV() recorded mounts = %v, want %v", mounts, tt.wantMounts)
+			}
+		})
+	}
+}
+
+func Test_RestoreQuotaMounts(t *testing.T) {
+	mountsFile := filepath.Join(t.TempDir(), "quota-mounts.json")
+	for _, mount := range []QuotaMount{
+		{QuotaPath: "/mnt/quotapath.default/pv-a", Device: "/dev/vg/lv-a", FsType: "ext4"},
+		{QuotaPath: "/mnt/quotapath.default/pv-b", Device: "/dev/vg/lv-b", FsType: "xfs"},
+	} {
+		if err := recordQuotaMount(mountsFile, mount); err != nil {
+			t.Fatalf("recordQuotaMount() error = %v", err)
+		}
+	}
+	runner := &fakeRunner{}
+	origin := cmdRunner
+	// pv-a is mounted already
+	cmdRunner = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "findmnt") && strings.HasSuffix(cmd, "pv-a") {
+			return "/dev/mapper/vg-lv--a\n", nil
+		}
+		return runner.run(cmd)
+	}
+	defer func() { cmdRunner = origin }()
+
+	if err := RestoreQuotaMounts(mountsFile); err != nil {
+		t.Fatalf("RestoreQuotaMounts() error = %v", err)
+	}
+	wantFull := []string{
+		"findmnt -n -o SOURCE -M /mnt/quotapath.default/pv-b",
+		"mount -t xfs /dev/vg/lv-b /mnt/quotapath.default/pv-b",
+	}
+	if !reflect.DeepEqual(runner.full, wantFull) {
+		t.Errorf("RestoreQuotaMounts() cmds = %v, want %v", runner.full, wantFull)
+	}
+
+	if err := forgetQuotaMount(mountsFile, "/mnt/quotapath.default/pv-a"); err != nil {
+		t.Fatalf("forgetQuotaMount() error = %v", err)
+	}
+	mounts, err := LoadQuotaMounts(mountsFile)
+	if err != nil {
+		t.Fatalf("LoadQuotaMounts() error = %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].QuotaPath != "/mnt/quotapath.default/pv-b" {
+		t.Errorf("LoadQuotaMounts() after forgetQuotaMount() = %v, want pv-b only", mounts)
+	}
+	if err := RestoreQuotaMounts(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("RestoreQuotaMounts() of missing file error = %v", err)
+	}
+}
+
+func Test_MigrateQuotaToLV_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration QuotaMigration
+		mounted   string
+		repquota  string
+		wantErr   error
+	}{
+		{
+			name:      "test path not in quota namespace",
+			migration: QuotaMigration{QuotaPath: "/var/lib/data", VGName: "vg", LVName: "lv"},
+		},
+		{
+			name:      "test unsupported filesystem",
+			migration: QuotaMigration{QuotaPath: "/mnt/quotapath.default/pv-test", VGName: "vg", LVName: "lv", FsType: "btrfs"},
+		},
+		{
+			name:      "test migrated already",
+			migration: QuotaMigration{QuotaPath: "/mnt/quotapath.default/pv-test", VGName: "vg", LVName: "lv"},
+			mounted:   "/dev/mapper/vg-lv",
+			wantErr:   ErrQuotaPathMounted,
+		},
+		{
+			name:      "test quota project not found",
+			migration: QuotaMigration{QuotaPath: "/mnt/quotapath.default/pv-test", VGName: "vg", LVName: "lv"},
+			repquota:  "#0,ok,ok,0,0,0,,2,0,0,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{repquota: tt.repquota, findmnt: tt.mounted}
+			origin := cmdRunner
+			cmdRunner = runner.run
+			defer func() { cmdRunner = origin }()
+
+			err := MigrateQuotaToLV(&tt.migration)
+			if err == nil {
+				t.Fatalf("MigrateQuotaToLV() error = nil, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("MigrateQuotaToLV() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, cmd := range runner.cmds {
+				if cmd == "lvcreate -n" {
+					t.Errorf("MigrateQuotaToLV() creates lv for invalid migration")
+				}
+			}
+		})
+	}
+}
+
+func Test_lockedStep(t *testing.T) {
+	unlock, err := lvm.LockVolumeGroup(context.Background(), "lockedStepVG")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() error = %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = lockedStep(func() error {
+			close(done)
+			return nil
+		}, "otherVG", "lockedStepVG", "otherVG")()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("lockedStep() runs while vg is locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("lockedStep() does not run after vg is unlocked")
+	}
+	// locks of step are released once it is done
+	unlock, err = lvm.LockVolumeGroup(context.Background(), "otherVG")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() error = %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,616 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	log "k8s.io/klog/v2"
+)
+
+// DefaultRebalanceThreshold is the largest difference of usage between vgs
+// considered balanced
+const DefaultRebalanceThreshold = 0.2
+
+// DefaultRebalanceStopTimeout is the default time waited for pods using a
+// mounted lv to stop before it is moved
+const DefaultRebalanceStopTimeout = 5 * time.Minute
+
+// rebalancePollInterval is the interval of checking whether lv to move is
+// unmounted, can be replaced in unit test
+var rebalancePollInterval = 2 * time.Second
+
+// RebalanceVG is a vg taking part in rebalance
+type RebalanceVG struct {
+	Name string
+	Size uint64
+	Free uint64
+}
+
+// Usage returns the ratio of allocated space of vg
+func (vg RebalanceVG) Usage() float64 {
+	if vg.Size == 0 {
+		return 0
+	}
+	return float64(vg.Size-vg.Free) / float64(vg.Size)
+}
+
+// RebalanceLV is a lv in vg taking part in rebalance
+type RebalanceLV struct {
+	VGName string
+	Name   string
+	Size   uint64
+	Tags   []string
+	// PVName is the open-local pv backed by lv
+	PVName string
+	// Unmovable is the reason why lv can not be moved, empty if movable
+	Unmovable string
+}
+
+// RebalanceMove moves a whole lv to vg To
+type RebalanceMove struct {
+	LV RebalanceLV
+	To string
+}
+
+func (m RebalanceMove) String() string {
+	return fmt.Sprintf("move lv %s of %d bytes (pv %s) from vg %s to vg %s", m.LV.Name, m.LV.Size, m.LV.PVName, m.LV.VGName, m.To)
+}
+
+// Rebalance evens out usage of vgs on a node by moving whole lvs between
+// them. pvmove only moves extents within a vg, so lv is copied to the other
+// vg and pv is switched to it. Pv is marked moving during the move so that
+// csi refuses to publish it, and pods using a mounted lv are evicted and the
+// move waits for lv to be unmounted before copying, evicted pods are
+// published again on the target vg once the mark is removed. Usage of pvs
+// within a vg is evened out by pvmove, which moves extents online. Steps
+// changing vgs lock them and are paced by the rate limit of mutating lvm
+// operations, the same as operations of lvmd.
+type Rebalance struct {
+	NodeName string
+	// VGNames are vgs to rebalance, at least two unless only extents are moved
+	VGNames []string
+	// Threshold is the largest difference of usage considered balanced
+	Threshold float64
+	// MaxMoves is the maximum number of lvs moved, and of extent moves in
+	// each vg, 0 means unlimited
+	MaxMoves int
+	// Execute moves lvs as planned, otherwise only the plan is returned
+	Execute bool
+	// MoveMounted moves mounted lvs by evicting pods using them, otherwise
+	// mounted lvs are left in place
+	MoveMounted bool
+	// StopTimeout is the time waited for a mounted lv to be unmounted after
+	// its pods are evicted, 0 means DefaultRebalanceStopTimeout
+	StopTimeout time.Duration
+	KubeClient  kubernetes.Interface
+	// Progress receives a message before every step, may be nil
+	Progress func(msg string)
+}
+
+// ImbalancedVGs returns the most and the least used vg, imbalanced is true if
+// their usage differs by more than threshold
+func ImbalancedVGs(vgs []RebalanceVG, threshold float64) (fullest, emptiest RebalanceVG, imbalanced bool) {
+	if len(vgs) < 2 {
+		return RebalanceVG{}, RebalanceVG{}, false
+	}
+	fullest, emptiest = vgs[0], vgs[0]
+	for _, vg := range vgs[1:] {
+		if vg.Usage() > fullest.Usage() {
+			fullest = vg
+		}
+		if vg.Usage() < emptiest.Usage() {
+			emptiest = vg
+		}
+	}
+	return fullest, emptiest, fullest.Usage()-emptiest.Usage() > threshold
+}
+
+// PlanRebalance plans moves of lv from the most used vg to the least used one
+// until usage of vgs is within threshold. Every move picks the movable lv
+// fitting in the least used vg which narrows the gap between the two vgs the
+// most, planning stops once no lv narrows it or maxMoves(0 means unlimited)
+// is reached. Every lv is moved at most once.
+func PlanRebalance(vgs []RebalanceVG, lvs []RebalanceLV, threshold float64, maxMoves int) []RebalanceMove {
+	state := make([]RebalanceVG, len(vgs))
+	copy(state, vgs)
+	sort.Slice(state, func(i, j int) bool { return state[i].Name < state[j].Name })
+	indexOf := map[string]int{}
+	for i, vg := range state {
+		indexOf[vg.Name] = i
+	}
+
+	moved := map[int]bool{}
+	var moves []RebalanceMove
+	for maxMoves <= 0 || len(moves) < maxMoves {
+		fullest, emptiest, imbalanced := ImbalancedVGs(state, threshold)
+		if !imbalanced {
+			break
+		}
+		best, bestGap := -1, fullest.Usage()-emptiest.Usage()
+		for i, lv := range lvs {
+			if moved[i] || lv.Unmovable != "" || lv.VGName != fullest.Name || lv.Size > emptiest.Free {
+				continue
+			}
+			from, to := fullest, emptiest
+			from.Free += lv.Size
+			to.Free -= lv.Size
+			if gap := math.Abs(from.Usage() - to.Usage()); gap < bestGap {
+				best, bestGap = i, gap
+			}
+		}
+		if best < 0 {
+			break
+		}
+		moved[best] = true
+		state[indexOf[fullest.Name]].Free += lvs[best].Size
+		state[indexOf[emptiest.Name]].Free -= lvs[best].Size
+		moves = append(moves, RebalanceMove{LV: lvs[best], To: emptiest.Name})
+	}
+	return moves
+}
+
+// unmovableReason returns why lv can not be moved, empty if movable. Mounted
+// lv is movable only if pods using it may be evicted
+func unmovableReason(isSnapshot, hasSnapshot bool, pvName string, mountPoints []string, moveMounted bool) string {
+	switch {
+	case isSnapshot:
+		return "snapshot lv"
+	case hasSnapshot:
+		return "lv has snapshot"
+	case pvName == "":
+		return "not backed by open-local pv of the node"
+	case len(mountPoints) > 0 && !moveMounted:
+		return fmt.Sprintf("mounted at %s, stop pods using it or move mounted lvs", strings.Join(mountPoints, ","))
+	}
+	return ""
+}
+
+// RunRebalance plans moves of lv to even out usage of vgs, the moves are done
+// in order if Execute is set. Every step of a move is reverted if any step
+// fails, and moves done before are kept. Moves done are returned along with
+// error.
+func RunRebalance(r *Rebalance) ([]RebalanceMove, error) {
+	if err := r.validate(2); err != nil {
+		return nil, err
+	}
+	pvs, err := r.lvmPVsOfNode()
+	if err != nil {
+		return nil, err
+	}
+	var vgs []RebalanceVG
+	var lvs []RebalanceLV
+	for _, vgName := range r.VGNames {
+		vg, vgLVs, err := lookupRebalanceVG(vgName, pvs, r.MoveMounted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up vg %s: %s", vgName, err.Error())
+		}
+		r.progress(fmt.Sprintf("vg %s: size %d bytes, free %d bytes, usage %.2f", vg.Name, vg.Size, vg.Free, vg.Usage()))
+		for _, lv := range vgLVs {
+			if lv.Unmovable != "" {
+				r.progress(fmt.Sprintf("lv %s/%s is not movable: %s", lv.VGName, lv.Name, lv.Unmovable))
+			}
+		}
+		vgs = append(vgs, vg)
+		lvs = append(lvs, vgLVs...)
+	}
+	if _, _, imbalanced := ImbalancedVGs(vgs, r.Threshold); !imbalanced {
+		r.progress(fmt.Sprintf("usage of vgs is within %.2f, nothing to move", r.Threshold))
+		return nil, nil
+	}
+	moves := PlanRebalance(vgs, lvs, r.Threshold, r.MaxMoves)
+	for i, move := range moves {
+		r.progress(fmt.Sprintf("plan %d/%d: %s", i+1, len(moves), move.String()))
+	}
+	if !r.Execute {
+		return moves, nil
+	}
+	for i, move := range moves {
+		r.progress(fmt.Sprintf("[move %d/%d] %s", i+1, len(moves), move.String()))
+		if err := r.MoveLV(move); err != nil {
+			return moves[:i], err
+		}
+	}
+	return moves, nil
+}
+
+func (r *Rebalance) validate(minVGs int) error {
+	if r.NodeName == "" {
+		return fmt.Errorf("node name is required")
+	}
+	if len(r.VGNames) < minVGs {
+		return fmt.Errorf("%d or more vgs are required, got %d", minVGs, len(r.VGNames))
+	}
+	seen := map[string]bool{}
+	for _, name := range r.VGNames {
+		if seen[name] {
+			return fmt.Errorf("vg %s is duplicated", name)
+		}
+		seen[name] = true
+	}
+	if r.Threshold <= 0 || r.Threshold >= 1 {
+		return fmt.Errorf("threshold %v must be in (0, 1)", r.Threshold)
+	}
+	if r.MaxMoves < 0 {
+		return fmt.Errorf("max moves %d must not be negative", r.MaxMoves)
+	}
+	if r.StopTimeout < 0 {
+		return fmt.Errorf("stop timeout %s must not be negative", r.StopTimeout)
+	}
+	return nil
+}
+
+// lvmPVsOfNode returns name of open-local lvm pvs on the node by vg/lv
+func (r *Rebalance) lvmPVsOfNode() (map[string]string, error) {
+	pvList, err := r.KubeClient.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pv: %s", err.Error())
+	}
+	pvs := map[string]string{}
+	for i := range pvList.Items {
+		pv := &pvList.Items[i]
+		if isLocal, volumeType := utils.IsOpenLocalPV(pv); !isLocal || volumeType != localtype.VolumeTypeLVM {
+			continue
+		}
+		if utils.GetNodeNameFromCsiPV(pv) != r.NodeName {
+			continue
+		}
+		pvs[filepath.Join(utils.GetVGNameFromCsiPV(pv), utils.GetLVNameFromCsiPV(pv))] = pv.Name
+	}
+	return pvs, nil
+}
+
+func lookupRebalanceVG(vgName string, pvs map[string]string, moveMounted bool) (RebalanceVG, []RebalanceLV, error) {
+	vg, err := lvm.LookupVolumeGroup(vgName)
+	if err != nil {
+		return RebalanceVG{}, nil, err
+	}
+	size, err := vg.BytesTotal()
+	if err != nil {
+		return RebalanceVG{}, nil, err
+	}
+	free, err := vg.BytesFree()
+	if err != nil {
+		return RebalanceVG{}, nil, err
+	}
+	names, err := vg.ListLogicalVolumeNames()
+	if err != nil {
+		return RebalanceVG{}, nil, err
+	}
+	var lvList []*lvm.LogicalVolume
+	hasSnapshot := map[string]bool{}
+	for _, name := range names {
+		lv, err := vg.LookupLogicalVolume(name)
+		if err != nil {
+			return RebalanceVG{}, nil, err
+		}
+		if lv.IsSnapshot() {
+			hasSnapshot[lv.OriginLVName()] = true
+		}
+		lvList = append(lvList, lv)
+	}
+	var lvs []RebalanceLV
+	for _, lv := range lvList {
+		dev := filepath.Join("/dev", vgName, lv.Name())
+		mountPoints, err := findMountPointsOf(dev)
+		if err != nil {
+			return RebalanceVG{}, nil, fmt.Errorf("failed to find mount points of %s: %s", dev, err.Error())
+		}
+		pvName := pvs[filepath.Join(vgName, lv.Name())]
+		lvs = append(lvs, RebalanceLV{
+			VGName:    vgName,
+			Name:      lv.Name(),
+			Size:      lv.SizeInBytes(),
+			Tags:      lv.Tags(),
+			PVName:    pvName,
+			Unmovable: unmovableReason(lv.IsSnapshot(), hasSnapshot[lv.Name()], pvName, mountPoints, moveMounted),
+		})
+	}
+	return RebalanceVG{Name: vgName, Size: size, Free: free}, lvs, nil
+}
+
+func findMountPointsOf(dev string) ([]string, error) {
+	// findmnt exits with 1 if nothing is found
+	out, err := cmdRunner(fmt.Sprintf("%s findmnt -n -o TARGET -S %s || [ $? -eq 1 ]", localtype.NsenterCmd, dev))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// MoveLV copies lv to the target vg, switches pv to it and removes the
+// source. Pv is marked moving first so that csi refuses to publish it, pods
+// using lv are evicted if it is mounted and MoveMounted is set, and lv is
+// copied once it is unmounted. The mark is removed after the move or its
+// rollback, so that evicted pods are published on the vg holding lv
+func (r *Rebalance) MoveLV(move RebalanceMove) error {
+	lv := move.LV
+	source := filepath.Join("/dev", lv.VGName, lv.Name)
+	target := filepath.Join("/dev", move.To, lv.Name)
+	lvcreate := []string{"-n", lv.Name, "-L", fmt.Sprintf("%db", lv.Size), "-W", "y", "-y"}
+	for _, tag := range lv.Tags {
+		lvcreate = append(lvcreate, "--addtag", tag)
+	}
+	steps := []migrationStep{
+		{
+			name: fmt.Sprintf("mark pv %s moving to vg %s", lv.PVName, move.To),
+			do:   func() error { return r.setPVMovingTo(lv.PVName, move.To) },
+			undo: func() error { return r.setPVMovingTo(lv.PVName, "") },
+		},
+		{
+			// pod may be started since plan is made, and none is started
+			// once pv is marked
+			name: fmt.Sprintf("stop pods using %s", source),
+			do:   func() error { return r.stopPodsUsing(lv.PVName, source) },
+		},
+		{
+			name: fmt.Sprintf("create lv %s/%s of %d bytes", move.To, lv.Name, lv.Size),
+			do:   lockedStep(lvmStep("lvcreate", append(lvcreate, move.To)...), move.To),
+			undo: lockedStep(lvmStep("lvremove", "-f", move.To+"/"+lv.Name), move.To),
+		},
+		{
+			// partly copied data is dropped along with lv on rollback
+			name: fmt.Sprintf("copy data of %s to %s", source, target),
+			do:   lockedStep(runStep("dd", "if="+source, "of="+target, "bs=4M", "conv=fsync", "status=none"), lv.VGName, move.To),
+		},
+		{
+			name: fmt.Sprintf("switch pv %s to vg %s", lv.PVName, move.To),
+			do:   func() error { return r.setPVAllocatedVG(lv.PVName, move.To) },
+			undo: func() error { return r.setPVAllocatedVG(lv.PVName, lv.VGName) },
+		},
+		{
+			name: fmt.Sprintf("remove lv %s/%s", lv.VGName, lv.Name),
+			do:   lockedStep(lvmStep("lvremove", "-f", lv.VGName+"/"+lv.Name), lv.VGName),
+		},
+	}
+	if err := runMigrationSteps(steps, r.progress); err != nil {
+		return err
+	}
+	// not a step, rolling back once source is removed loses data
+	r.progress(fmt.Sprintf("unmark pv %s", lv.PVName))
+	if err := r.setPVMovingTo(lv.PVName, ""); err != nil {
+		return fmt.Errorf("lv %s is moved to vg %s, but failed to unmark pv %s, remove annotation %s of it: %s", lv.Name, move.To, lv.PVName, localtype.AnnotationPVMovingToKey, err.Error())
+	}
+	return nil
+}
+
+// stopPodsUsing evicts pods on the node using pv until dev is unmounted. Pods
+// evicted are not started again before pv is unmarked, and eviction refused
+// by disruption budget is retried until StopTimeout
+func (r *Rebalance) stopPodsUsing(pvName, dev string) error {
+	mountPoints, err := findMountPointsOf(dev)
+	if err != nil {
+		return err
+	}
+	if len(mountPoints) == 0 {
+		return nil
+	}
+	if !r.MoveMounted {
+		return fmt.Errorf("%s is mounted at %s, stop pods using it or move mounted lvs", dev, strings.Join(mountPoints, ","))
+	}
+	timeout := r.StopTimeout
+	if timeout == 0 {
+		timeout = DefaultRebalanceStopTimeout
+	}
+	var evictErr error
+	err = wait.PollImmediate(rebalancePollInterval, timeout, func() (bool, error) {
+		if evictErr = r.evictPodsUsing(pvName); evictErr != nil {
+			log.Warningf("[Rebalance]%s, retry later", evictErr.Error())
+			return false, nil
+		}
+		mountPoints, err = findMountPointsOf(dev)
+		return len(mountPoints) == 0, err
+	})
+	if err == wait.ErrWaitTimeout {
+		if evictErr != nil {
+			return fmt.Errorf("%s is still mounted after %s: %s", dev, timeout, evictErr.Error())
+		}
+		return fmt.Errorf("%s is still mounted at %s after %s", dev, strings.Join(mountPoints, ","), timeout)
+	}
+	return err
+}
+
+// evictPodsUsing evicts pods on the node using pvc bound to pv, pods already
+// terminating are skipped
+func (r *Rebalance) evictPodsUsing(pvName string) error {
+	pv, err := r.KubeClient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	claim := pv.Spec.ClaimRef
+	if claim == nil {
+		return nil
+	}
+	pods, err := r.KubeClient.CoreV1().Pods(claim.Namespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", r.NodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of node %s: %s", r.NodeName, err.Error())
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != r.NodeName || pod.DeletionTimestamp != nil || !podUsesClaim(pod, claim.Name) {
+			continue
+		}
+		r.progress(fmt.Sprintf("evict pod %s/%s using pv %s", pod.Namespace, pod.Name, pvName))
+		eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		if err := r.KubeClient.CoreV1().Pods(pod.Namespace).Evict(context.Background(), eviction); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to evict pod %s/%s: %s", pod.Namespace, pod.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func podUsesClaim(pod *corev1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// setPVAllocatedVG records vg of pv in allocated info annotation, which is
+// preferred to volume attributes by csi and scheduler
+func (r *Rebalance) setPVAllocatedVG(pvName, vgName string) error {
+	pv, err := r.KubeClient.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	info, err := localtype.GetAllocatedInfoFromPVAnnotation(pv)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		info = &localtype.PVAllocatedInfo{VolumeType: string(localtype.VolumeTypeLVM)}
+	}
+	info.VGName = vgName
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return r.patchPVAnnotation(pvName, localtype.AnnotationPVAllocatedInfoKey, string(infoJSON))
+}
+
+// setPVMovingTo marks pv moving to vg, the mark is removed if vgName is empty
+func (r *Rebalance) setPVMovingTo(pvName, vgName string) error {
+	if vgName == "" {
+		return r.patchPVAnnotation(pvName, localtype.AnnotationPVMovingToKey, nil)
+	}
+	return r.patchPVAnnotation(pvName, localtype.AnnotationPVMovingToKey, vgName)
+}
+
+// patchPVAnnotation sets annotation of pv, which is removed if value is nil
+func (r *Rebalance) patchPVAnnotation(pvName, key string, value interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.KubeClient.CoreV1().PersistentVolumes().Patch(context.Background(), pvName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// ExtentMove moves extents of lv on pv From to pv To of the same vg
+type ExtentMove struct {
+	VGName string
+	LVName string
+	Size   uint64
+	From   string
+	To     string
+}
+
+func (m ExtentMove) String() string {
+	return fmt.Sprintf("move %d bytes of lv %s/%s from pv %s to pv %s", m.Size, m.VGName, m.LVName, m.From, m.To)
+}
+
+// PlanExtentMoves plans moves of extents of lvs between pvs of vg the same way
+// as PlanRebalance plans moves of lvs between vgs, extents of an lv on a pv
+// are moved together. Only extents of plain lvs are moved, pvmove does not
+// move snapshots and their origins
+func PlanExtentMoves(vgName string, pvs []lvm.PhysicalVolumeSpace, lvs []lvm.PhysicalVolumeLV, threshold float64, maxMoves int) []ExtentMove {
+	var containers []RebalanceVG
+	for _, pv := range pvs {
+		containers = append(containers, RebalanceVG{Name: pv.Name, Size: pv.Size, Free: pv.Free})
+	}
+	var parts []RebalanceLV
+	for _, lv := range lvs {
+		part := RebalanceLV{VGName: lv.PVName, Name: lv.LVName, Size: lv.Size}
+		if !strings.HasPrefix(lv.Attr, "-") {
+			part.Unmovable = fmt.Sprintf("not a plain lv, attr %s", lv.Attr)
+		}
+		parts = append(parts, part)
+	}
+	var moves []ExtentMove
+	for _, move := range PlanRebalance(containers, parts, threshold, maxMoves) {
+		moves = append(moves, ExtentMove{VGName: vgName, LVName: move.LV.Name, Size: move.LV.Size, From: move.LV.VGName, To: move.To})
+	}
+	return moves
+}
+
+// RunExtentRebalance plans moves of extents to even out usage of pvs within
+// each vg, the moves are done by pvmove in order if Execute is set. pvmove
+// moves extents online, lv stays in use. Moves done are returned along with
+// error.
+func RunExtentRebalance(r *Rebalance) ([]ExtentMove, error) {
+	if err := r.validate(1); err != nil {
+		return nil, err
+	}
+	var done []ExtentMove
+	for _, vgName := range r.VGNames {
+		vg, err := lvm.LookupVolumeGroup(vgName)
+		if err != nil {
+			return done, fmt.Errorf("failed to look up vg %s: %s", vgName, err.Error())
+		}
+		pvs, err := vg.PhysicalVolumeSpaces()
+		if err != nil {
+			return done, fmt.Errorf("failed to get pvs of vg %s: %s", vgName, err.Error())
+		}
+		lvs, err := vg.PhysicalVolumeLVs()
+		if err != nil {
+			return done, fmt.Errorf("failed to get lvs on pvs of vg %s: %s", vgName, err.Error())
+		}
+		moves := PlanExtentMoves(vgName, pvs, lvs, r.Threshold, r.MaxMoves)
+		if len(moves) == 0 {
+			r.progress(fmt.Sprintf("usage of pvs of vg %s is within %.2f, no extent to move", vgName, r.Threshold))
+			continue
+		}
+		for i, move := range moves {
+			r.progress(fmt.Sprintf("extent plan %d/%d: %s", i+1, len(moves), move.String()))
+		}
+		if !r.Execute {
+			done = append(done, moves...)
+			continue
+		}
+		for i, move := range moves {
+			r.progress(fmt.Sprintf("[pvmove %d/%d] %s", i+1, len(moves), move.String()))
+			if err := lockedStep(lvmStep("pvmove", "-n", move.LVName, move.From, move.To), vgName)(); err != nil {
+				return done, fmt.Errorf("failed to %s: %s", move.String(), err.Error())
+			}
+			done = append(done, move)
+		}
+	}
+	return done, nil
+}
+
+func (r *Rebalance) progress(msg string) {
+	log.Infof("[Rebalance]%s", msg)
+	if r.Progress != nil {
+		r.Progress(msg)
+	}
+}
@@ -0,0 +1,499 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const gib = uint64(1024 * 1024 * 1024)
+
+func Test_ImbalancedVGs(t *testing.T) {
+	tests := []struct {
+		name           string
+		vgs            []RebalanceVG
+		threshold      float64
+		wantFullest    string
+		wantEmptiest   string
+		wantImbalanced bool
+	}{
+		{
+			name: "test single vg",
+			vgs:  []RebalanceVG{{Name: "vg1", Size: 100 * gib, Free: 0}},
+		},
+		{
+			name: "test within threshold",
+			vgs: []RebalanceVG{
+				{Name: "vg1", Size: 100 * gib, Free: 40 * gib},
+				{Name: "vg2", Size: 200 * gib, Free: 100 * gib},
+			},
+			threshold:    0.2,
+			wantFullest:  "vg1",
+			wantEmptiest: "vg2",
+		},
+		{
+			name: "test usage ratio instead of used bytes",
+			vgs: []RebalanceVG{
+				{Name: "vg1", Size: 100 * gib, Free: 10 * gib},
+				{Name: "vg2", Size: 1000 * gib, Free: 500 * gib},
+				{Name: "vg3", Size: 200 * gib, Free: 100 * gib},
+			},
+			threshold:      0.2,
+			wantFullest:    "vg1",
+			wantEmptiest:   "vg2",
+			wantImbalanced: true,
+		},
+		{
+			name: "test empty vg",
+			vgs: []RebalanceVG{
+				{Name: "vg1", Size: 100 * gib, Free: 100 * gib},
+				{Name: "vg2", Size: 0, Free: 0},
+				{Name: "vg3", Size: 100 * gib, Free: 50 * gib},
+			},
+			threshold:      0.2,
+			wantFullest:    "vg3",
+			wantEmptiest:   "vg1",
+			wantImbalanced: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fullest, emptiest, imbalanced := ImbalancedVGs(tt.vgs, tt.threshold)
+			if fullest.Name != tt.wantFullest || emptiest.Name != tt.wantEmptiest || imbalanced != tt.wantImbalanced {
+				t.Errorf("ImbalancedVGs() = %s, %s, %v, want %s, %s, %v", fullest.Name, emptiest.Name, imbalanced, tt.wantFullest, tt.wantEmptiest, tt.wantImbalanced)
+			}
+		})
+	}
+}
+
+func Test_PlanRebalance(t *testing.T) {
+	vgs := []RebalanceVG{
+		{Name: "vg1", Size: 100 * gib, Free: 10 * gib},
+		{Name: "vg2", Size: 100 * gib, Free: 90 * gib},
+	}
+	tests := []struct {
+		name      string
+		vgs       []RebalanceVG
+		lvs       []RebalanceLV
+		maxMoves  int
+		wantMoves []string
+	}{
+		{
+			name: "test lv narrowing gap the most",
+			vgs:  vgs,
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-10g", Size: 10 * gib},
+				{VGName: "vg1", Name: "lv-40g", Size: 40 * gib},
+				{VGName: "vg1", Name: "lv-30g", Size: 30 * gib},
+			},
+			wantMoves: []string{"lv-40g->vg2"},
+		},
+		{
+			name: "test unmovable lv is skipped",
+			vgs:  vgs,
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-10g", Size: 10 * gib},
+				{VGName: "vg1", Name: "lv-40g", Size: 40 * gib, Unmovable: "lv has snapshot"},
+				{VGName: "vg1", Name: "lv-30g", Size: 30 * gib},
+			},
+			wantMoves: []string{"lv-30g->vg2"},
+		},
+		{
+			name: "test several moves until balanced",
+			vgs:  vgs,
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-a", Size: 15 * gib},
+				{VGName: "vg1", Name: "lv-b", Size: 15 * gib},
+				{VGName: "vg1", Name: "lv-c", Size: 15 * gib},
+				{VGName: "vg1", Name: "lv-d", Size: 15 * gib},
+			},
+			wantMoves: []string{"lv-a->vg2", "lv-b->vg2"},
+		},
+		{
+			name: "test max moves",
+			vgs:  vgs,
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-a", Size: 15 * gib},
+				{VGName: "vg1", Name: "lv-b", Size: 15 * gib},
+				{VGName: "vg1", Name: "lv-c", Size: 15 * gib},
+			},
+			maxMoves:  1,
+			wantMoves: []string{"lv-a->vg2"},
+		},
+		{
+			name: "test lv too large for free space",
+			vgs: []RebalanceVG{
+				{Name: "vg1", Size: 100 * gib, Free: 0},
+				{Name: "vg2", Size: 20 * gib, Free: 20 * gib},
+			},
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-big", Size: 60 * gib},
+				{VGName: "vg1", Name: "lv-small", Size: 10 * gib},
+			},
+			wantMoves: []string{"lv-small->vg2"},
+		},
+		{
+			name: "test no lv narrowing gap",
+			vgs:  vgs,
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-90g", Size: 90 * gib},
+			},
+		},
+		{
+			name: "test moves across three vgs",
+			vgs: []RebalanceVG{
+				{Name: "vg1", Size: 100 * gib, Free: 0},
+				{Name: "vg2", Size: 100 * gib, Free: 100 * gib},
+				{Name: "vg3", Size: 100 * gib, Free: 50 * gib},
+			},
+			lvs: []RebalanceLV{
+				{VGName: "vg1", Name: "lv-1", Size: 25 * gib},
+				{VGName: "vg1", Name: "lv-2", Size: 25 * gib},
+				{VGName: "vg1", Name: "lv-3", Size: 25 * gib},
+				{VGName: "vg3", Name: "lv-4", Size: 25 * gib},
+			},
+			wantMoves: []string{"lv-1->vg2", "lv-2->vg2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var moves []string
+			for _, move := range PlanRebalance(tt.vgs, tt.lvs, DefaultRebalanceThreshold, tt.maxMoves) {
+				moves = append(moves, move.LV.Name+"->"+move.To)
+			}
+			if !reflect.DeepEqual(moves, tt.wantMoves) {
+				t.Errorf("PlanRebalance() = %v, want %v", moves, tt.wantMoves)
+			}
+		})
+	}
+}
+
+func Test_unmovableReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		isSnapshot  bool
+		hasSnapshot bool
+		pvName      string
+		mountPoints []string
+		moveMounted bool
+		wantMovable bool
+	}{
+		{name: "test movable", pvName: "pv-1", wantMovable: true},
+		{name: "test snapshot lv", isSnapshot: true, pvName: "pv-1"},
+		{name: "test origin lv of snapshot", hasSnapshot: true, pvName: "pv-1"},
+		{name: "test lv without pv"},
+		{name: "test mounted lv", pvName: "pv-1", mountPoints: []string{"/var/lib/kubelet/pods/x/mount"}},
+		{name: "test mounted lv moved by evicting pods", pvName: "pv-1", mountPoints: []string{"/var/lib/kubelet/pods/x/mount"}, moveMounted: true, wantMovable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := unmovableReason(tt.isSnapshot, tt.hasSnapshot, tt.pvName, tt.mountPoints, tt.moveMounted)
+			if (reason == "") != tt.wantMovable {
+				t.Errorf("unmovableReason() = %q, want movable %v", reason, tt.wantMovable)
+			}
+		})
+	}
+}
+
+func newRebalancePV(name, vgName string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				localtype.AnnotationPVAllocatedInfoKey: `{"vgName":"` + vgName + `","volumeType":"LVM"}`,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "pvc-1"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{localtype.VGName: vgName},
+				},
+			},
+		},
+	}
+}
+
+func newRebalancePod(name, nodeName, claimName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			}},
+		},
+	}
+}
+
+func Test_Rebalance_MoveLV(t *testing.T) {
+	tests := []struct {
+		name        string
+		mounted     bool
+		moveMounted bool
+		// command failing, matched by prefix
+		failOn   string
+		evictErr error
+		wantCmds []string
+		wantVG   string
+		// vg pv is marked moving to while data is copied
+		wantMovingOnCopy string
+		wantEvicted      []string
+		wantErr          bool
+	}{
+		{
+			name:             "test move unmounted lv",
+			wantCmds:         []string{"findmnt -n -o", "lvcreate -n lv-1", "dd if=/dev/vg1/lv-1 of=/dev/vg2/lv-1", "lvremove -f vg1/lv-1"},
+			wantVG:           "vg2",
+			wantMovingOnCopy: "vg2",
+		},
+		{
+			name:             "test rollback when copy failed",
+			failOn:           "dd",
+			wantCmds:         []string{"findmnt -n -o", "lvcreate -n lv-1", "dd if=/dev/vg1/lv-1 of=/dev/vg2/lv-1", "lvremove -f vg2/lv-1"},
+			wantVG:           "vg1",
+			wantMovingOnCopy: "vg2",
+			wantErr:          true,
+		},
+		{
+			name:             "test rollback when source is not removed",
+			failOn:           "lvremove -f vg1/lv-1",
+			wantCmds:         []string{"findmnt -n -o", "lvcreate -n lv-1", "dd if=/dev/vg1/lv-1 of=/dev/vg2/lv-1", "lvremove -f vg1/lv-1", "lvremove -f vg2/lv-1"},
+			wantVG:           "vg1",
+			wantMovingOnCopy: "vg2",
+			wantErr:          true,
+		},
+		{
+			name:     "test mounted lv refused",
+			mounted:  true,
+			wantCmds: []string{"findmnt -n -o"},
+			wantVG:   "vg1",
+			wantErr:  true,
+		},
+		{
+			name:             "test mounted lv moved after pods evicted",
+			mounted:          true,
+			moveMounted:      true,
+			wantCmds:         []string{"findmnt -n -o", "findmnt -n -o", "lvcreate -n lv-1", "dd if=/dev/vg1/lv-1 of=/dev/vg2/lv-1", "lvremove -f vg1/lv-1"},
+			wantVG:           "vg2",
+			wantMovingOnCopy: "vg2",
+			wantEvicted:      []string{"pod-a"},
+		},
+		{
+			name:        "test mounted lv not unmounted in time",
+			mounted:     true,
+			moveMounted: true,
+			evictErr:    apierrors.NewTooManyRequests("cannot evict pod as it would violate the pod's disruption budget", 1),
+			wantCmds:    []string{"findmnt -n -o"},
+			wantVG:      "vg1",
+			wantErr:     true,
+		},
+	}
+	originInterval := rebalancePollInterval
+	rebalancePollInterval = 10 * time.Millisecond
+	defer func() { rebalancePollInterval = originInterval }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := k8sfake.NewSimpleClientset(
+				newRebalancePV("pv-1", "vg1"),
+				newRebalancePod("pod-a", "node-1", "pvc-1"),
+				newRebalancePod("pod-b", "node-2", "pvc-1"),
+				newRebalancePod("pod-c", "node-1", "pvc-2"),
+			)
+			var evicted []string
+			client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				if tt.evictErr != nil {
+					return true, nil, tt.evictErr
+				}
+				evicted = append(evicted, action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction).Name)
+				return true, nil, nil
+			})
+
+			runner := &fakeRunner{}
+			var movingOnCopy string
+			origin := cmdRunner
+			cmdRunner = func(cmd string) (string, error) {
+				out, err := runner.run(cmd)
+				full := runner.full[len(runner.full)-1]
+				if strings.HasPrefix(full, "dd") {
+					pv, _ := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+					movingOnCopy = pv.Annotations[localtype.AnnotationPVMovingToKey]
+				}
+				switch {
+				case tt.failOn != "" && strings.HasPrefix(full, tt.failOn):
+					return "", errors.New("fake error")
+				case strings.HasPrefix(full, "findmnt") && tt.mounted && len(evicted) == 0:
+					return "/var/lib/kubelet/pods/pod-a/volumes/kubernetes.io~csi/pv-1/mount\n", nil
+				}
+				return out, err
+			}
+			defer func() { cmdRunner = origin }()
+
+			r := &Rebalance{
+				NodeName:    "node-1",
+				MoveMounted: tt.moveMounted,
+				StopTimeout: 50 * time.Millisecond,
+				KubeClient:  client,
+				Progress:    func(msg string) {},
+			}
+			err := r.MoveLV(RebalanceMove{LV: RebalanceLV{VGName: "vg1", Name: "lv-1", Size: gib, PVName: "pv-1"}, To: "vg2"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MoveLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var cmds []string
+			for _, full := range runner.full {
+				fields := strings.Fields(full)
+				if len(fields) > 3 {
+					fields = fields[:3]
+				}
+				cmds = append(cmds, strings.Join(fields, " "))
+			}
+			if !reflect.DeepEqual(cmds, tt.wantCmds) {
+				t.Errorf("MoveLV() cmds = %v, want %v", cmds, tt.wantCmds)
+			}
+			if movingOnCopy != tt.wantMovingOnCopy {
+				t.Errorf("MoveLV() pv is marked moving to %q on copy, want %q", movingOnCopy, tt.wantMovingOnCopy)
+			}
+			if !reflect.DeepEqual(evicted, tt.wantEvicted) {
+				t.Errorf("MoveLV() evicted pods = %v, want %v", evicted, tt.wantEvicted)
+			}
+			pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pv: %v", err)
+			}
+			if vg := utils.GetVGNameFromCsiPV(pv); vg != tt.wantVG {
+				t.Errorf("MoveLV() vg of pv = %s, want %s", vg, tt.wantVG)
+			}
+			if to, moving := pv.Annotations[localtype.AnnotationPVMovingToKey]; moving {
+				t.Errorf("MoveLV() pv is still marked moving to %s", to)
+			}
+		})
+	}
+}
+
+func Test_Rebalance_setPVAllocatedVG(t *testing.T) {
+	withoutInfo := newRebalancePV("pv-without-info", "vg1")
+	withoutInfo.Annotations = nil
+	tests := []struct {
+		name     string
+		pvName   string
+		wantInfo localtype.PVAllocatedInfo
+		wantErr  bool
+	}{
+		{
+			name:     "test allocated info updated",
+			pvName:   "pv-1",
+			wantInfo: localtype.PVAllocatedInfo{VGName: "vg2", VolumeType: string(localtype.VolumeTypeLVM)},
+		},
+		{
+			name:     "test allocated info added",
+			pvName:   "pv-without-info",
+			wantInfo: localtype.PVAllocatedInfo{VGName: "vg2", VolumeType: string(localtype.VolumeTypeLVM)},
+		},
+		{
+			name:    "test pv not found",
+			pvName:  "pv-not-exist",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := k8sfake.NewSimpleClientset(newRebalancePV("pv-1", "vg1"), withoutInfo.DeepCopy())
+			r := &Rebalance{KubeClient: client}
+			err := r.setPVAllocatedVG(tt.pvName, "vg2")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setPVAllocatedVG() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), tt.pvName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pv: %v", err)
+			}
+			info, err := localtype.GetAllocatedInfoFromPVAnnotation(pv)
+			if err != nil || info == nil {
+				t.Fatalf("GetAllocatedInfoFromPVAnnotation() = %v, %v", info, err)
+			}
+			if *info != tt.wantInfo {
+				t.Errorf("setPVAllocatedVG() info = %+v, want %+v", *info, tt.wantInfo)
+			}
+			if vg := pv.Spec.CSI.VolumeAttributes[localtype.VGName]; vg != "vg1" {
+				t.Errorf("setPVAllocatedVG() changes volume attributes to vg %s", vg)
+			}
+		})
+	}
+}
+
+func Test_PlanExtentMoves(t *testing.T) {
+	pvs := []lvm.PhysicalVolumeSpace{
+		{Name: "/dev/sdb", Size: 100 * gib, Free: 0},
+		{Name: "/dev/sdc", Size: 100 * gib, Free: 100 * gib},
+	}
+	tests := []struct {
+		name      string
+		lvs       []lvm.PhysicalVolumeLV
+		wantMoves []ExtentMove
+	}{
+		{
+			name: "test extents of plain lv moved",
+			lvs: []lvm.PhysicalVolumeLV{
+				{PVName: "/dev/sdb", LVName: "lv-1", Attr: "-wi-ao----", Size: 50 * gib},
+				{PVName: "/dev/sdb", LVName: "lv-2", Attr: "-wi-ao----", Size: 50 * gib},
+			},
+			wantMoves: []ExtentMove{{VGName: "vg", LVName: "lv-1", Size: 50 * gib, From: "/dev/sdb", To: "/dev/sdc"}},
+		},
+		{
+			name: "test extents of snapshot and origin not moved",
+			lvs: []lvm.PhysicalVolumeLV{
+				{PVName: "/dev/sdb", LVName: "origin", Attr: "owi-aos---", Size: 50 * gib},
+				{PVName: "/dev/sdb", LVName: "snap", Attr: "swi-a-s---", Size: 20 * gib},
+				{PVName: "/dev/sdb", LVName: "lv-1", Attr: "-wi-ao----", Size: 30 * gib},
+			},
+			wantMoves: []ExtentMove{{VGName: "vg", LVName: "lv-1", Size: 30 * gib, From: "/dev/sdb", To: "/dev/sdc"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlanExtentMoves("vg", pvs, tt.lvs, DefaultRebalanceThreshold, 0); !reflect.DeepEqual(got, tt.wantMoves) {
+				t.Errorf("PlanExtentMoves() = %+v, want %+v", got, tt.wantMoves)
+			}
+		})
+	}
+}
@@ -17,9 +17,16 @@ limitations under the License.
 package server
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
+	localtype "github.com/alibaba/open-local/pkg"
 	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -28,11 +35,15 @@ import (
 
 type LvmCmd interface {
 	ListLV(listspec string) ([]*lib.LV, error)
-	CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool) (string, error)
+	CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool, allocation string) (string, error)
+	GetIOAlignment(ctx context.Context, vg string, name string) (utils.IOAlignment, error)
 	RemoveLV(ctx context.Context, vg string, name string) (string, error)
-	CloneLV(ctx context.Context, src, dest string) (string, error)
+	RenameLV(ctx context.Context, vg string, name string, newName string) (string, error)
+	CloneLV(ctx context.Context, src, dest string, verifyChecksum bool) (string, error)
+	// ZeroLV writes zeros across lv and removes its zeroing tag
+	ZeroLV(ctx context.Context, vg string, name string) (string, error)
 	ExpandLV(ctx context.Context, vgName string, volumeId string, expectSize uint64) (string, error)
-	CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error)
+	CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error)
 	RemoveSnapshot(ctx context.Context, vg string, name string, readonly bool) (string, error)
 	AddTagLV(ctx context.Context, vg string, name string, tags []string) (string, error)
 	RemoveTagLV(ctx context.Context, vg string, name string, tags []string) (string, error)
@@ -41,17 +52,70 @@ type LvmCmd interface {
 	RemoveVG(ctx context.Context, name string) (string, error)
 	CleanPath(ctx context.Context, path string) error
 	CleanDevice(ctx context.Context, device string) (string, error)
+	// SetIOThrottling returns pods whose io throttling of the lv is set
+	SetIOThrottling(ctx context.Context, vg string, name string, iops string, bps string) ([]string, error)
 }
 
 // Server lvm grpc server
 type Server struct {
 	lib.UnimplementedLVMServer
 	impl LvmCmd
+	// operations tracks long operations of the node
+	operations *utils.OperationTracker
+}
+
+// missingPrivileges is privileges lvmd lacks, checked when lvmd starts.
+// Mutating operations are refused at once rather than failing halfway
+var missingPrivileges []string
+
+// beginMutatingOp queues mutating operation until no other operation runs on
+// vg and it is allowed by the rate limit of lvm operations, the returned
+// function must be called when the operation is done
+func beginMutatingOp(ctx context.Context, op string, vg string) (func(), error) {
+	return beginLockedOp(ctx, op, "vg "+vg, func() (func(), error) {
+		return lvm.LockVolumeGroup(ctx, vg)
+	})
+}
+
+// beginDeviceOp is beginMutatingOp of operation on device out of any vg
+func beginDeviceOp(ctx context.Context, op string, device string) (func(), error) {
+	return beginLockedOp(ctx, op, "device "+device, func() (func(), error) {
+		return lvm.LockDevice(ctx, device)
+	})
+}
+
+func beginLockedOp(ctx context.Context, op string, target string, lock func() (func(), error)) (func(), error) {
+	if len(missingPrivileges) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is refused, lvmd lacks %s", op, strings.Join(missingPrivileges, ", "))
+	}
+	unlock, err := lock()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.Errorf(codes.Canceled, "%s is canceled while waiting for operation on %s: %v", op, target, err)
+		}
+		return nil, status.Errorf(codes.Internal, "%s fails to lock %s: %v", op, target, err)
+	}
+	if err := lvm.WaitMutatingOp(ctx); err != nil {
+		unlock()
+		return nil, status.Errorf(codes.Canceled, "%s is canceled while throttled: %v", op, err)
+	}
+	return unlock, nil
+}
+
+// vgOfDevicePath returns vg of lv device path /dev/<vg>/<lv>
+func vgOfDevicePath(path string) string {
+	return filepath.Base(filepath.Dir(path))
+}
+
+// lvLogKeys returns keys and values of structured log about lv operation,
+// with operation id of csi plugin in ctx
+func lvLogKeys(ctx context.Context, op, vg, lv string) []interface{} {
+	return utils.OperationLogKeys(ctx, utils.LogKeyOperation, op, utils.LogKeyVG, vg, utils.LogKeyLV, lv)
 }
 
 // NewServer new server
 func NewServer(cmd LvmCmd) Server {
-	return Server{impl: cmd}
+	return Server{impl: cmd, operations: utils.LongOperations}
 }
 
 // ListLV list lvm volume
@@ -71,33 +135,200 @@ func (s Server) ListLV(ctx context.Context, in *lib.ListLVRequest) (*lib.ListLVR
 	return &lib.ListLVReply{Volumes: pblvs}, nil
 }
 
-// CreateLV create lvm volume
+// CreateLV create lvm volume, lv to be zeroed is zeroed after vg is unlocked
 func (s Server) CreateLV(ctx context.Context, in *lib.CreateLVRequest) (*lib.CreateLVReply, error) {
-	log.V(6).Infof("Create LVM with: %+v", in)
-	out, err := s.impl.CreateLV(ctx, in.VolumeGroup, in.Name, in.Size, in.Mirrors, in.Tags, in.Striping)
+	keys := lvLogKeys(ctx, "CreateLV", in.VolumeGroup, in.Name)
+	reply, zero, err := s.createLV(ctx, in, keys)
+	if err != nil || !zero {
+		return reply, err
+	}
+	log.InfoS("zero lv", append(keys, "size", in.Size)...)
+	if _, err := s.impl.ZeroLV(ctx, in.VolumeGroup, in.Name); err != nil {
+		log.ErrorS(err, "failed to zero lv", keys...)
+		// lv partially zeroed is removed, it is created and zeroed again on
+		// retry. Zeroing outlives request, so does the removal
+		if unlock, lockErr := beginMutatingOp(context.Background(), "CreateLV", in.VolumeGroup); lockErr != nil {
+			log.ErrorS(lockErr, "failed to remove lv failed to be zeroed", keys...)
+		} else {
+			if _, rmErr := s.impl.RemoveLV(context.Background(), in.VolumeGroup, in.Name); rmErr != nil {
+				log.ErrorS(rmErr, "failed to remove lv failed to be zeroed", keys...)
+			}
+			unlock()
+		}
+		return nil, status.Errorf(codes.Internal, "failed to zero lv: %v", err)
+	}
+	log.InfoS("zero lv successfully", keys...)
+	return reply, nil
+}
+
+// createLV creates lv of the request unless it is left by an interrupted
+// request, zero tells whether the lv is still to be zeroed
+func (s Server) createLV(ctx context.Context, in *lib.CreateLVRequest, keys []interface{}) (*lib.CreateLVReply, bool, error) {
+	unlock, err := beginMutatingOp(ctx, "CreateLV", in.VolumeGroup)
 	if err != nil {
-		log.Errorf("Create LVM with error: %s", err.Error())
-		return nil, status.Errorf(codes.Internal, "failed to create lv: %v", err)
+		return nil, false, err
+	}
+	defer unlock()
+	if in.Zero && s.isZeroing(in.VolumeGroup, in.Name) {
+		return nil, false, status.Errorf(codes.Aborted, "lv %s is being zeroed", utils.GetNameKey(in.VolumeGroup, in.Name))
+	}
+	reply := &lib.CreateLVReply{}
+	zero := in.Zero
+	existing := s.getLV(in.VolumeGroup, in.Name)
+	owner := creatorTag(in.Tags)
+	if existing == nil && owner != "" {
+		// preallocated lv assigned to the volume is named by the request
+		renamed, err := s.renameAssignedLV(ctx, in.VolumeGroup, in.Name, owner, keys)
+		if err != nil {
+			return nil, false, err
+		}
+		existing = renamed
+	}
+	switch {
+	case existing == nil:
+	case in.Zero && hasLVTag(existing, localtype.ZeroingLVTag):
+		// zeroing does not survive restart of plugin, it starts over
+		log.InfoS("lv is left unzeroed, zero it again", keys...)
+	case owner != "" && hasLVTag(existing, owner):
+		// lv is created by the last request of the same volume which never
+		// returned, it is complete unless it is smaller than requested
+		if existing.Size < in.Size {
+			return nil, false, status.Errorf(codes.AlreadyExists, "lv %s of %d bytes exists, %d bytes requested", utils.GetNameKey(in.VolumeGroup, in.Name), existing.Size, in.Size)
+		}
+		log.InfoS("lv is left by interrupted request, reuse it", append(keys, "size", existing.Size)...)
+		zero = false
+	default:
+		return nil, false, status.Errorf(codes.AlreadyExists, "lv %s exists and is not created for the request", utils.GetNameKey(in.VolumeGroup, in.Name))
+	}
+	if existing == nil {
+		tags := in.Tags
+		if in.Zero {
+			tags = append(append([]string{}, in.Tags...), localtype.ZeroingLVTag)
+		}
+		log.V(6).InfoS("create lv", append(keys, "size", in.Size, "tags", tags, "striping", in.Striping, "allocation", in.AllocationPolicy)...)
+		out, err := s.impl.CreateLV(ctx, in.VolumeGroup, in.Name, in.Size, in.Mirrors, tags, in.Striping, in.AllocationPolicy)
+		if err != nil {
+			log.ErrorS(err, "failed to create lv", keys...)
+			code := codes.Internal
+			switch {
+			case errors.Is(err, ErrAllocationNoSpace):
+				code = codes.ResourceExhausted
+			case errors.Is(err, ErrInvalidAllocationPolicy):
+				code = codes.InvalidArgument
+			}
+			return nil, false, status.Errorf(code, "failed to create lv: %v", err)
+		}
+		log.V(6).InfoS("create lv successfully", append(keys, "output", out)...)
+		reply.CommandOutput = out
+	}
+	if alignment, err := s.impl.GetIOAlignment(ctx, in.VolumeGroup, in.Name); err != nil {
+		log.V(4).InfoS("io alignment of lv is unknown", append(keys, "reason", err.Error())...)
+	} else if alignment.NeedsAlignment() {
+		reply.IoAlignment = alignment.String()
+	}
+	return reply, zero, nil
+}
+
+// isZeroing is true if lv of vg is being zeroed by the other request
+func (s Server) isZeroing(vg, name string) bool {
+	for _, op := range s.operations.List() {
+		if op.Type == utils.OperationTypeZero && op.VG == vg && op.LV == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getLV returns lv of vg, nil if it does not exist
+func (s Server) getLV(vg, name string) *lib.LV {
+	lvs, err := s.impl.ListLV(utils.GetNameKey(vg, name))
+	if err != nil || len(lvs) != 1 {
+		return nil
+	}
+	return lvs[0]
+}
+
+// renameAssignedLV renames preallocated lv of vg assigned to the volume owning
+// the request, it returns nil if no lv is assigned
+func (s Server) renameAssignedLV(ctx context.Context, vg, name, owner string, keys []interface{}) (*lib.LV, error) {
+	lvs, err := s.impl.ListLV(vg)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list lvs of vg %s: %v", vg, err)
+	}
+	for _, lv := range lvs {
+		if lv.Name == name || !hasLVTag(lv, owner) || !hasLVTagPrefix(lv, localtype.PreallocAssigneeLVTagPrefix) {
+			continue
+		}
+		log.InfoS("rename preallocated lv assigned to the volume", append(keys, "from", lv.Name)...)
+		if _, err := s.impl.RenameLV(ctx, vg, lv.Name, name); err != nil {
+			log.ErrorS(err, "failed to rename preallocated lv", append(keys, "from", lv.Name)...)
+			return nil, status.Errorf(codes.Internal, "failed to rename preallocated lv %s to %s: %v", utils.GetNameKey(vg, lv.Name), name, err)
+		}
+		lv.Name = name
+		return lv, nil
+	}
+	return nil, nil
+}
+
+func hasLVTagPrefix(lv *lib.LV, prefix string) bool {
+	for _, t := range lv.Tags {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLVTag(lv *lib.LV, tag string) bool {
+	for _, t := range lv.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// creatorTag returns the tag naming volume the lv is created for, empty if
+// the request carries none
+func creatorTag(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, localtype.CreatedForLVTagPrefix) {
+			return tag
+		}
 	}
-	log.V(6).Infof("Create LVM Successful with result: %+v", out)
-	return &lib.CreateLVReply{CommandOutput: out}, nil
+	return ""
 }
 
 // RemoveLV remove lvm volume
 func (s Server) RemoveLV(ctx context.Context, in *lib.RemoveLVRequest) (*lib.RemoveLVReply, error) {
-	log.V(6).Infof("Remove LVM with: %+v", in)
+	unlock, err := beginMutatingOp(ctx, "RemoveLV", in.VolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	keys := lvLogKeys(ctx, "RemoveLV", in.VolumeGroup, in.Name)
+	log.V(6).InfoS("remove lv", keys...)
 	out, err := s.impl.RemoveLV(ctx, in.VolumeGroup, in.Name)
 	if err != nil {
-		log.Errorf("Remove LVM with error: %s", err.Error())
-		return nil, status.Errorf(codes.Internal, "failed to remove lv: %v", err)
+		log.ErrorS(err, "failed to remove lv", keys...)
+		code := codes.Internal
+		if errors.Is(err, ErrOriginHasSnapshots) {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Errorf(code, "failed to remove lv: %v", err)
 	}
-	log.V(6).Infof("Remove LVM Successful with result: %+v", out)
+	log.V(6).InfoS("remove lv successfully", append(keys, "output", out)...)
 	return &lib.RemoveLVReply{CommandOutput: out}, nil
 }
 
-// CloneLV clone lvm volume
+// CloneLV clone lvm volume, which locks vg of the destination lv
 func (s Server) CloneLV(ctx context.Context, in *lib.CloneLVRequest) (*lib.CloneLVReply, error) {
-	out, err := s.impl.CloneLV(ctx, in.SourceName, in.DestName)
+	unlock, err := beginMutatingOp(ctx, "CloneLV", vgOfDevicePath(in.DestName))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	out, err := s.impl.CloneLV(ctx, in.SourceName, in.DestName, in.VerifyChecksum)
 	if err != nil {
 		log.Errorf("Clone LVM with error: %s", err.Error())
 		return nil, status.Errorf(codes.Internal, "failed to clone lv: %v", err)
@@ -108,12 +339,18 @@ func (s Server) CloneLV(ctx context.Context, in *lib.CloneLVRequest) (*lib.Clone
 
 // ExpandLV expand lvm volume
 func (s Server) ExpandLV(ctx context.Context, in *lib.ExpandLVRequest) (*lib.ExpandLVReply, error) {
+	unlock, err := beginMutatingOp(ctx, "ExpandLV", in.VolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	keys := lvLogKeys(ctx, "ExpandLV", in.VolumeGroup, in.Name)
 	out, err := s.impl.ExpandLV(ctx, in.VolumeGroup, in.Name, in.Size)
 	if err != nil {
-		log.Errorf("Expand LVM with error: %s", err.Error())
+		log.ErrorS(err, "failed to expand lv", append(keys, "size", in.Size)...)
 		return nil, status.Errorf(codes.Internal, "failed to expand lv: %v", err)
 	}
-	log.V(6).Infof("Expand LVM with result: %+v", out)
+	log.V(6).InfoS("expand lv successfully", append(keys, "size", in.Size, "output", out)...)
 	return &lib.ExpandLVReply{CommandOutput: out}, nil
 }
 
@@ -135,28 +372,55 @@ func (s Server) ListVG(ctx context.Context, in *lib.ListVGRequest) (*lib.ListVGR
 
 // CreateSnapshot create lvm snapshot
 func (s Server) CreateSnapshot(ctx context.Context, in *lib.CreateSnapshotRequest) (*lib.CreateSnapshotReply, error) {
-	log.V(6).Infof("create snapshot with: %+v", in)
-	sizeBytes, err := s.impl.CreateSnapshot(ctx, in.VgName, in.SnapshotName, in.SrcVolumeName, in.Readonly, in.RoInitSize, in.S3Secrets)
+	unlock, err := beginMutatingOp(ctx, "CreateSnapshot", in.VgName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// S3Secrets of request must not be logged
+	keys := append(lvLogKeys(ctx, "CreateSnapshot", in.VgName, in.SrcLvName), utils.LogKeySnapshot, in.SnapshotName)
+	log.V(6).InfoS("create snapshot", append(keys, "readonly", in.Readonly, "roInitSize", in.RoInitSize, "cowPVs", in.CowPvs, "fsFreeze", in.FsFreeze)...)
+	sizeBytes, err := s.impl.CreateSnapshot(ctx, in.VgName, in.SnapshotName, in.SrcVolumeName, in.SrcLvName, in.Readonly, in.RoInitSize, in.CowPvs, in.FsFreeze, in.S3Secrets)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "fail to create snapshot %s: %s", in.SnapshotName, err.Error())
+		log.ErrorS(err, "failed to create snapshot", keys...)
+		code := codes.Internal
+		switch {
+		case errors.Is(err, ErrSnapshotNoSpace):
+			code = codes.ResourceExhausted
+		case errors.Is(err, ErrSnapshotOfSnapshot), errors.Is(err, ErrSnapshotCOWPVNotFound), errors.Is(err, ErrRemoteUnsupported):
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Errorf(code, "fail to create snapshot %s: %s", in.SnapshotName, err.Error())
 	}
-	log.V(6).Infof("create snapshot successfully with result: %+v", sizeBytes)
+	log.V(6).InfoS("create snapshot successfully", append(keys, "sizeBytes", sizeBytes)...)
 	return &lib.CreateSnapshotReply{SizeBytes: sizeBytes}, nil
 }
 
 // RemoveSnapshot remove lvm snapshot
 func (s Server) RemoveSnapshot(ctx context.Context, in *lib.RemoveSnapshotRequest) (*lib.RemoveSnapshotReply, error) {
-	log.V(6).Infof("Remove LVM Snapshot with: %+v", in)
+	unlock, err := beginMutatingOp(ctx, "RemoveSnapshot", in.VgName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	keys := append(lvLogKeys(ctx, "RemoveSnapshot", in.VgName, in.SnapshotName), utils.LogKeySnapshot, in.SnapshotName)
+	log.V(6).InfoS("remove snapshot", append(keys, "readonly", in.Readonly)...)
 	out, err := s.impl.RemoveSnapshot(ctx, in.VgName, in.SnapshotName, in.Readonly)
 	if err != nil {
+		log.ErrorS(err, "failed to remove snapshot", keys...)
 		return nil, status.Errorf(codes.Internal, "RemoveSnapshot: remove snapshot with error: %s", err.Error())
 	}
-	log.V(6).Infof("Remove LVM Snapshot Successful with result: %+v", out)
+	log.V(6).InfoS("remove snapshot successfully", append(keys, "output", out)...)
 	return &lib.RemoveSnapshotReply{CommandOutput: out}, nil
 }
 
 // CreateVG create volume group
 func (s Server) CreateVG(ctx context.Context, in *lib.CreateVGRequest) (*lib.CreateVGReply, error) {
+	unlock, err := beginMutatingOp(ctx, "CreateVG", in.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	out, err := s.impl.CreateVG(ctx, in.Name, in.PhysicalVolume, in.Tags)
 	if err != nil {
 		log.Errorf("Create VG with error: %s", err.Error())
@@ -168,6 +432,11 @@ func (s Server) CreateVG(ctx context.Context, in *lib.CreateVGRequest) (*lib.Cre
 
 // RemoveVG remove volume group
 func (s Server) RemoveVG(ctx context.Context, in *lib.CreateVGRequest) (*lib.RemoveVGReply, error) {
+	unlock, err := beginMutatingOp(ctx, "RemoveVG", in.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	out, err := s.impl.RemoveVG(ctx, in.Name)
 	if err != nil {
 		log.Errorf("Remove VG with error: %s", err.Error())
@@ -190,6 +459,11 @@ func (s Server) CleanPath(ctx context.Context, in *lib.CleanPathRequest) (*lib.C
 
 // CleanDevice wipefs
 func (s Server) CleanDevice(ctx context.Context, in *lib.CleanDeviceRequest) (*lib.CleanDeviceReply, error) {
+	unlock, err := beginDeviceOp(ctx, "CleanDevice", in.Device)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	out, err := s.impl.CleanDevice(ctx, in.Device)
 	if err != nil {
 		log.Errorf("failed to clean device %s: %s", in.Device, err.Error())
@@ -199,8 +473,63 @@ func (s Server) CleanDevice(ctx context.Context, in *lib.CleanDeviceRequest) (*l
 	return &lib.CleanDeviceReply{CommandOutput: fmt.Sprintf("clean device %s successfully with output: %s", in.Device, out)}, nil
 }
 
+// SetIOThrottling sets io throttling of lv in cgroup of pods using it
+func (s Server) SetIOThrottling(ctx context.Context, in *lib.SetIOThrottlingRequest) (*lib.SetIOThrottlingReply, error) {
+	keys := append(lvLogKeys(ctx, "SetIOThrottling", in.VolumeGroup, in.Name), "iops", in.Iops, "bps", in.Bps)
+	pods, err := s.impl.SetIOThrottling(ctx, in.VolumeGroup, in.Name, in.Iops, in.Bps)
+	if err != nil {
+		log.ErrorS(err, "failed to set io throttling of lv", keys...)
+		code := codes.Internal
+		if errors.Is(err, ErrRemoteUnsupported) {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Errorf(code, "failed to set io throttling of lv: %v", err)
+	}
+	log.InfoS("set io throttling of lv successfully", append(keys, "pods", pods)...)
+	return &lib.SetIOThrottlingReply{Pods: pods}, nil
+}
+
+// ListOperations lists long operations running on the node
+func (s Server) ListOperations(ctx context.Context, in *lib.ListOperationsRequest) (*lib.ListOperationsReply, error) {
+	now := time.Now()
+	reply := &lib.ListOperationsReply{}
+	for _, op := range s.operations.List() {
+		reply.Operations = append(reply.Operations, &lib.Operation{
+			Id:             op.ID,
+			Type:           op.Type,
+			VolumeGroup:    op.VG,
+			Name:           op.LV,
+			ElapsedSeconds: int64(now.Sub(op.StartTime).Seconds()),
+			Progress:       op.Progress,
+			Cancellable:    op.Cancellable,
+		})
+	}
+	return reply, nil
+}
+
+// CancelOperation cancels a long operation running on the node
+func (s Server) CancelOperation(ctx context.Context, in *lib.CancelOperationRequest) (*lib.CancelOperationReply, error) {
+	if err := s.operations.Cancel(in.Id); err != nil {
+		log.Errorf("failed to cancel operation %s: %s", in.Id, err.Error())
+		switch {
+		case errors.Is(err, utils.ErrOperationNotFound):
+			return nil, status.Errorf(codes.NotFound, "failed to cancel operation: %v", err)
+		case errors.Is(err, utils.ErrOperationNotCancellable):
+			return nil, status.Errorf(codes.FailedPrecondition, "failed to cancel operation: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to cancel operation: %v", err)
+	}
+	log.Infof("operation %s is cancelled", in.Id)
+	return &lib.CancelOperationReply{}, nil
+}
+
 // AddTagLV add tag
 func (s Server) AddTagLV(ctx context.Context, in *lib.AddTagLVRequest) (*lib.AddTagLVReply, error) {
+	unlock, err := beginMutatingOp(ctx, "AddTagLV", in.VolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	log, err := s.impl.AddTagLV(ctx, in.VolumeGroup, in.Name, in.Tags)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to add tags to lv: %v", err)
@@ -210,6 +539,11 @@ func (s Server) AddTagLV(ctx context.Context, in *lib.AddTagLVRequest) (*lib.Add
 
 // RemoveTagLV remove tag
 func (s Server) RemoveTagLV(ctx context.Context, in *lib.RemoveTagLVRequest) (*lib.RemoveTagLVReply, error) {
+	unlock, err := beginMutatingOp(ctx, "RemoveTagLV", in.VolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	log, err := s.impl.RemoveTagLV(ctx, in.VolumeGroup, in.Name, in.Tags)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to remove tags from lv: %v", err)
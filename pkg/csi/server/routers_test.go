@@ -0,0 +1,458 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Server_Throttle(t *testing.T) {
+	lvm.SetMutatingOpsLimit(20)
+	defer lvm.SetMutatingOpsLimit(0)
+	svr := NewServer(&FakeCommands{})
+
+	// read-only queries are never throttled
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if _, err := svr.ListLV(context.Background(), &lib.ListLVRequest{VolumeGroup: "newVG"}); err != nil {
+			t.Fatalf("ListLV() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("20 ListLV took %v, should not be throttled", elapsed)
+	}
+
+	// provisioning and expansion are queued rather than failed
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := svr.ExpandLV(context.Background(), &lib.ExpandLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 2048})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("throttled operation error = %v", err)
+		}
+	}
+	// the first op passes at once, the other 9 ops take 50ms each
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("10 mutating ops took %v, want about 450ms", elapsed)
+	}
+}
+
+func Test_Server_ListAndCancelOperations(t *testing.T) {
+	svr := NewServer(&FakeCommands{})
+	svr.operations = utils.NewOperationTracker()
+
+	// fake long operation runs until it is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	id, deregister := svr.operations.Register(utils.OperationTypeClone, "newVG", "lv", cancel, func() string { return "50%" })
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		defer deregister()
+		<-ctx.Done()
+	}()
+	_, deregisterFormat := svr.operations.Register(utils.OperationTypeFormat, "newVG", "lv2", nil, nil)
+	defer deregisterFormat()
+
+	reply, err := svr.ListOperations(context.Background(), &lib.ListOperationsRequest{})
+	if err != nil {
+		t.Fatalf("ListOperations() error = %v", err)
+	}
+	if len(reply.Operations) != 2 {
+		t.Fatalf("ListOperations() = %v, want 2 operations", reply.Operations)
+	}
+	op := reply.Operations[0]
+	if op.Id != id || op.Type != utils.OperationTypeClone || op.VolumeGroup != "newVG" || op.Name != "lv" || op.Progress != "50%" || !op.Cancellable {
+		t.Errorf("ListOperations() first operation = %v, want cancellable clone of newVG/lv with progress 50%%", op)
+	}
+	if reply.Operations[1].Cancellable {
+		t.Errorf("ListOperations() format operation is cancellable")
+	}
+
+	// format can not be cancelled and unknown operation is not found
+	if _, err := svr.CancelOperation(context.Background(), &lib.CancelOperationRequest{Id: reply.Operations[1].Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CancelOperation() of format error = %v, want FailedPrecondition", err)
+	}
+	if _, err := svr.CancelOperation(context.Background(), &lib.CancelOperationRequest{Id: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("CancelOperation() of unknown error = %v, want NotFound", err)
+	}
+
+	if _, err := svr.CancelOperation(context.Background(), &lib.CancelOperationRequest{Id: id}); err != nil {
+		t.Fatalf("CancelOperation() error = %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatalf("operation %s does not exit after cancelled", id)
+	}
+	reply, err = svr.ListOperations(context.Background(), &lib.ListOperationsRequest{})
+	if err != nil {
+		t.Fatalf("ListOperations() error = %v", err)
+	}
+	if len(reply.Operations) != 1 || reply.Operations[0].Type != utils.OperationTypeFormat {
+		t.Errorf("ListOperations() after cancel = %v, want the format operation only", reply.Operations)
+	}
+}
+
+// zeroRecorder records lvs created, zeroed and removed
+type zeroRecorder struct {
+	FakeCommands
+	created []string
+	tags    []string
+	zeroed  []string
+	removed []string
+	renamed []string
+	zeroErr error
+	// allocation policy requested and error of creation
+	allocation string
+	createErr  error
+	// lvs listed with zeroing tag
+	unzeroed map[string]bool
+	// lvs listed as they are
+	existing map[string]*lib.LV
+}
+
+func (r *zeroRecorder) ListLV(listspec string) ([]*lib.LV, error) {
+	if lv, ok := r.existing[listspec]; ok {
+		return []*lib.LV{lv}, nil
+	}
+	if r.unzeroed[listspec] {
+		return []*lib.LV{{Name: listspec, Tags: []string{localtype.ZeroingLVTag}}}, nil
+	}
+	return nil, nil
+}
+
+func (r *zeroRecorder) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool, allocation string) (string, error) {
+	r.allocation = allocation
+	if r.createErr != nil {
+		return "", r.createErr
+	}
+	r.created = append(r.created, vg+"/"+name)
+	r.tags = tags
+	return "CreateLV", nil
+}
+
+func (r *zeroRecorder) ZeroLV(ctx context.Context, vg string, name string) (string, error) {
+	r.zeroed = append(r.zeroed, vg+"/"+name)
+	return "", r.zeroErr
+}
+
+func (r *zeroRecorder) RemoveLV(ctx context.Context, vg string, name string) (string, error) {
+	r.removed = append(r.removed, vg+"/"+name)
+	return "RemoveLV", nil
+}
+
+func (r *zeroRecorder) RenameLV(ctx context.Context, vg string, name string, newName string) (string, error) {
+	r.renamed = append(r.renamed, vg+"/"+name+" "+newName)
+	return "RenameLV", nil
+}
+
+func Test_Server_CreateLV_Zero(t *testing.T) {
+	tests := []struct {
+		name        string
+		zero        bool
+		zeroErr     error
+		unzeroed    bool
+		wantCreated []string
+		wantTags    []string
+		wantZeroed  []string
+		wantRemoved []string
+		wantErr     bool
+	}{
+		{
+			name:        "test zeroing skipped",
+			zero:        false,
+			wantCreated: []string{"newVG/lv"},
+			wantTags:    []string{localtype.ManagedLVTag},
+		},
+		{
+			name:        "test zeroing enabled",
+			zero:        true,
+			wantCreated: []string{"newVG/lv"},
+			wantTags:    []string{localtype.ManagedLVTag, localtype.ZeroingLVTag},
+			wantZeroed:  []string{"newVG/lv"},
+		},
+		{
+			name:        "test zeroing failed",
+			zero:        true,
+			zeroErr:     errors.New("zeroing of /dev/newVG/lv is cancelled"),
+			wantCreated: []string{"newVG/lv"},
+			wantTags:    []string{localtype.ManagedLVTag, localtype.ZeroingLVTag},
+			wantZeroed:  []string{"newVG/lv"},
+			wantRemoved: []string{"newVG/lv"},
+			wantErr:     true,
+		},
+		{
+			name:       "test zeroing interrupted",
+			zero:       true,
+			unzeroed:   true,
+			wantZeroed: []string{"newVG/lv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &zeroRecorder{zeroErr: tt.zeroErr, unzeroed: map[string]bool{"newVG/lv": tt.unzeroed}}
+			svr := NewServer(recorder)
+			svr.operations = utils.NewOperationTracker()
+			_, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag}, Zero: tt.zero})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateLV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(recorder.created, tt.wantCreated) {
+				t.Errorf("CreateLV() created = %v, want %v", recorder.created, tt.wantCreated)
+			}
+			if tt.wantCreated != nil && !reflect.DeepEqual(recorder.tags, tt.wantTags) {
+				t.Errorf("CreateLV() tags = %v, want %v", recorder.tags, tt.wantTags)
+			}
+			if !reflect.DeepEqual(recorder.zeroed, tt.wantZeroed) {
+				t.Errorf("CreateLV() zeroed = %v, want %v", recorder.zeroed, tt.wantZeroed)
+			}
+			if !reflect.DeepEqual(recorder.removed, tt.wantRemoved) {
+				t.Errorf("CreateLV() removed = %v, want %v", recorder.removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func Test_Server_CreateLV_ZeroInProgress(t *testing.T) {
+	recorder := &zeroRecorder{unzeroed: map[string]bool{"newVG/lv": true}}
+	svr := NewServer(recorder)
+	svr.operations = utils.NewOperationTracker()
+	_, deregister := svr.operations.Register(utils.OperationTypeZero, "newVG", "lv", func() {}, nil)
+	defer deregister()
+
+	_, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024, Zero: true})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("CreateLV() error = %v, want Aborted", err)
+	}
+	if recorder.created != nil || recorder.zeroed != nil {
+		t.Errorf("CreateLV() created %v and zeroed %v while lv is being zeroed", recorder.created, recorder.zeroed)
+	}
+}
+
+func Test_Server_CreateLV_Interrupted(t *testing.T) {
+	owner := localtype.CreatedForLVTagPrefix + "pv-1"
+	tests := []struct {
+		name        string
+		existing    *lib.LV
+		zero        bool
+		wantCreated []string
+		wantZeroed  []string
+		wantCode    codes.Code
+	}{
+		{
+			name:        "test lv not created",
+			wantCreated: []string{"newVG/lv"},
+			wantCode:    codes.OK,
+		},
+		{
+			name:     "test lv created before crash",
+			existing: &lib.LV{Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner}},
+			zero:     true,
+			wantCode: codes.OK,
+		},
+		{
+			name:       "test lv created before crash and left unzeroed",
+			existing:   &lib.LV{Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner, localtype.ZeroingLVTag}},
+			zero:       true,
+			wantZeroed: []string{"newVG/lv"},
+			wantCode:   codes.OK,
+		},
+		{
+			name:     "test lv smaller than requested",
+			existing: &lib.LV{Name: "lv", Size: 512, Tags: []string{localtype.ManagedLVTag, owner}},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "test lv of the other volume",
+			existing: &lib.LV{Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag, localtype.CreatedForLVTagPrefix + "pv-2"}},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "test lv not created by open-local",
+			existing: &lib.LV{Name: "lv", Size: 1024},
+			wantCode: codes.AlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &zeroRecorder{existing: map[string]*lib.LV{}}
+			if tt.existing != nil {
+				recorder.existing["newVG/lv"] = tt.existing
+			}
+			svr := NewServer(recorder)
+			svr.operations = utils.NewOperationTracker()
+			_, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner}, Zero: tt.zero})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("CreateLV() error = %v, want code %v", err, tt.wantCode)
+			}
+			if !reflect.DeepEqual(recorder.created, tt.wantCreated) {
+				t.Errorf("CreateLV() created = %v, want %v", recorder.created, tt.wantCreated)
+			}
+			if !reflect.DeepEqual(recorder.zeroed, tt.wantZeroed) {
+				t.Errorf("CreateLV() zeroed = %v, want %v", recorder.zeroed, tt.wantZeroed)
+			}
+			if recorder.removed != nil {
+				t.Errorf("CreateLV() removed %v", recorder.removed)
+			}
+		})
+	}
+}
+
+func Test_Server_CreateLV_Preallocated(t *testing.T) {
+	owner := localtype.CreatedForLVTagPrefix + "pv-1"
+	tests := []struct {
+		name        string
+		vgLV        *lib.LV
+		wantRenamed []string
+		wantCreated []string
+	}{
+		{
+			name:        "test preallocated lv assigned to the volume",
+			vgLV:        &lib.LV{Name: "prealloc-1", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner, localtype.PreallocAssigneeLVTagPrefix + "pv-1"}},
+			wantRenamed: []string{"newVG/prealloc-1 lv"},
+		},
+		{
+			name:        "test preallocated lv assigned to the other volume",
+			vgLV:        &lib.LV{Name: "prealloc-1", Size: 1024, Tags: []string{localtype.ManagedLVTag, localtype.CreatedForLVTagPrefix + "pv-2", localtype.PreallocAssigneeLVTagPrefix + "pv-2"}},
+			wantCreated: []string{"newVG/lv"},
+		},
+		{
+			name:        "test lv of the volume not preallocated",
+			vgLV:        &lib.LV{Name: "other", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner}},
+			wantCreated: []string{"newVG/lv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &zeroRecorder{existing: map[string]*lib.LV{"newVG": tt.vgLV}}
+			svr := NewServer(recorder)
+			svr.operations = utils.NewOperationTracker()
+			if _, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024, Tags: []string{localtype.ManagedLVTag, owner}, Zero: true}); err != nil {
+				t.Fatalf("CreateLV() error = %v", err)
+			}
+			if !reflect.DeepEqual(recorder.renamed, tt.wantRenamed) {
+				t.Errorf("CreateLV() renamed = %v, want %v", recorder.renamed, tt.wantRenamed)
+			}
+			if !reflect.DeepEqual(recorder.created, tt.wantCreated) {
+				t.Errorf("CreateLV() created = %v, want %v", recorder.created, tt.wantCreated)
+			}
+			// preallocated lv is reused as it is
+			if tt.wantRenamed != nil && recorder.zeroed != nil {
+				t.Errorf("CreateLV() zeroed %v", recorder.zeroed)
+			}
+		})
+	}
+}
+
+func Test_Server_CreateLV_AllocationPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		createErr error
+		wantCode  codes.Code
+	}{
+		{
+			name:     "test contiguous",
+			wantCode: codes.OK,
+		},
+		{
+			name:      "test contiguous not satisfied",
+			createErr: fmt.Errorf("%w contiguous in vg newVG", ErrAllocationNoSpace),
+			wantCode:  codes.ResourceExhausted,
+		},
+		{
+			name:      "test unknown policy",
+			createErr: fmt.Errorf("%w %q", ErrInvalidAllocationPolicy, "contiguous"),
+			wantCode:  codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &zeroRecorder{createErr: tt.createErr}
+			svr := NewServer(recorder)
+			svr.operations = utils.NewOperationTracker()
+			_, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024, AllocationPolicy: "contiguous"})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("CreateLV() error = %v, want %v", err, tt.wantCode)
+			}
+			if recorder.allocation != "contiguous" {
+				t.Errorf("CreateLV() allocation = %q, want contiguous", recorder.allocation)
+			}
+		})
+	}
+}
+
+func Test_Server_MissingPrivileges(t *testing.T) {
+	missingPrivileges = []string{"CAP_SYS_ADMIN"}
+	defer func() { missingPrivileges = nil }()
+	svr := NewServer(&FakeCommands{})
+
+	if _, err := svr.ListLV(context.Background(), &lib.ListLVRequest{VolumeGroup: "newVG"}); err != nil {
+		t.Errorf("ListLV() error = %v, read-only query should not be refused", err)
+	}
+	if _, err := svr.CreateLV(context.Background(), &lib.CreateLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 1024}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CreateLV() error = %v, want FailedPrecondition", err)
+	}
+	if _, err := svr.ExpandLV(context.Background(), &lib.ExpandLVRequest{VolumeGroup: "newVG", Name: "lv", Size: 2048}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ExpandLV() error = %v, want FailedPrecondition", err)
+	}
+}
+
+func Test_Server_CloneLV_LocksVG(t *testing.T) {
+	svr := NewServer(&FakeCommands{})
+	unlock, err := lvm.LockVolumeGroup(context.Background(), "cloneVG")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := svr.CloneLV(ctx, &lib.CloneLVRequest{SourceName: "/dev/cloneVG/snap", DestName: "/dev/cloneVG/lv"}); status.Code(err) != codes.Canceled {
+		t.Errorf("CloneLV() error = %v, want Canceled while vg of destination is locked", err)
+	}
+	unlock()
+	if _, err := svr.CloneLV(context.Background(), &lib.CloneLVRequest{SourceName: "/dev/cloneVG/snap", DestName: "/dev/cloneVG/lv"}); err != nil {
+		t.Errorf("CloneLV() error = %v", err)
+	}
+}
@@ -21,11 +21,14 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alibaba/open-local/pkg/csi/lib"
 	"github.com/alibaba/open-local/pkg/csi/test"
 	clientset "github.com/alibaba/open-local/pkg/generated/clientset/versioned"
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/alibaba/open-local/pkg/utils/tracing"
 	"github.com/google/credstore/client"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
@@ -50,8 +53,9 @@ var (
 	lvmdPort string
 )
 
-// Start start lvmd
-func Start(port string) {
+// Start start lvmd, sysPath is the mountpoint of sysfs. Lvm operations are run
+// by executor if it is not nil, local node otherwise
+func Start(port string, sysPath string, executor Executor) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Errorf("Failed to build config: %v", err)
@@ -64,7 +68,12 @@ func Start(port string) {
 
 	var cmd LvmCmd
 	cmd = nil
-	if clientset != nil {
+	if executor != nil {
+		// clients are set below as for local node, operations on local files
+		// are refused
+		SetExecutor(executor)
+		cmd = &LvmCommads{remote: true}
+	} else if clientset != nil {
 		retry := 0
 
 		for {
@@ -102,6 +111,12 @@ func Start(port string) {
 		lvmCommads.kubeclient = kubeClient
 		lvmCommads.snapclient = snapClient
 		lvmCommads.recorder = eventRecorder
+		lvmCommads.sysPath = sysPath
+	}
+	if executor != nil {
+		log.Infof("lvm operations are run on remote lvm host")
+	} else if missingPrivileges = utils.MissingPrivileges(); len(missingPrivileges) > 0 {
+		log.Errorf("lvmd lacks %s, mutating operations are refused", strings.Join(missingPrivileges, ", "))
 	}
 	svr := NewServer(cmd)
 
@@ -161,7 +176,8 @@ func NewGRPCServer() (*grpc.Server, *client.CredstoreClient, error) {
 
 	grpcServer = grpc.NewServer(
 		grpc.UnaryInterceptor(
-			otgrpc.OpenTracingServerInterceptor(opentracing.GlobalTracer())))
+			otgrpc.OpenTracingServerInterceptor(opentracing.GlobalTracer())),
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(tracedMethods, spanAttributes), extractOperationID))
 
 	reflection.Register(grpcServer)
 	grpc_prometheus.Register(grpcServer)
@@ -169,6 +185,11 @@ func NewGRPCServer() (*grpc.Server, *client.CredstoreClient, error) {
 	return grpcServer, cc, nil
 }
 
+// extractOperationID puts operation id passed by csi plugin into ctx
+func extractOperationID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(utils.ExtractOperationID(ctx), req)
+}
+
 // GetLvmdPort get lvmd port
 func GetLvmdPort() string {
 	return lvmdPort
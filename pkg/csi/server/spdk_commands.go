@@ -26,6 +26,7 @@ import (
 	"strings"
 
 	"github.com/alibaba/open-local/pkg/csi/lib"
+	"github.com/alibaba/open-local/pkg/utils"
 	spdk "github.com/alibaba/open-local/pkg/utils/spdk"
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
@@ -60,8 +61,13 @@ func (cmd *SpdkCommands) ListLV(listspec string) ([]*lib.LV, error) {
 	return lvs, nil
 }
 
+// GetIOAlignment is not supported by spdk
+func (cmd *SpdkCommands) GetIOAlignment(ctx context.Context, vg string, name string) (utils.IOAlignment, error) {
+	return utils.IOAlignment{}, errors.New("io alignment is not supported by spdk")
+}
+
 // CreateLV creates a new logical volume and relevant vhost device
-func (cmd *SpdkCommands) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool) (string, error) {
+func (cmd *SpdkCommands) CreateLV(ctx context.Context, vg string, name string, size uint64, mirrors uint32, tags []string, striping bool, allocation string) (string, error) {
 	if size == 0 {
 		log.Error("CreateLV size is 0: size must be greater than 0")
 		return "", errors.New("size must be greater than 0")
@@ -103,11 +109,17 @@ func (cmd *SpdkCommands) RemoveLV(ctx context.Context, vg string, name string) (
 	return "", cmd.client.CleanBdev((*lv)[0].Name)
 }
 
-// CloneLV clones a logical volume
-func (cmd *SpdkCommands) CloneLV(ctx context.Context, src, dest string) (string, error) {
+// CloneLV clones a logical volume, verifyChecksum is ignored since spdk
+// clones lvol inside the blobstore rather than copying data
+func (cmd *SpdkCommands) CloneLV(ctx context.Context, src, dest string, verifyChecksum bool) (string, error) {
 	return cmd.client.CloneLV(src, dest)
 }
 
+// ZeroLV is not supported by SPDK
+func (cmd *SpdkCommands) ZeroLV(ctx context.Context, vg string, name string) (string, error) {
+	return "", errors.New("zeroing of spdk volume is not supported")
+}
+
 // ExpandLV expand a logical volume
 func (cmd *SpdkCommands) ExpandLV(ctx context.Context, vgName string, volumeId string, expectSize uint64) (string, error) {
 	volumeId = spdk.EnsureLVNameValid(volumeId)
@@ -136,8 +148,11 @@ func (cmd *SpdkCommands) ListVG() ([]*lib.VG, error) {
 }
 
 // CreateSnapshot creates a new volume snapshot
-func (cmd *SpdkCommands) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, readonly bool, roInitSize int64, secrets map[string]string) (int64, error) {
-	alias := vgName + "/" + srcVolumeName
+func (cmd *SpdkCommands) CreateSnapshot(ctx context.Context, vgName string, snapshotName string, srcVolumeName string, srcLVName string, readonly bool, roInitSize int64, cowPVs []string, fsFreeze bool, secrets map[string]string) (int64, error) {
+	if srcLVName == "" {
+		srcLVName = srcVolumeName
+	}
+	alias := vgName + "/" + srcLVName
 	// todo: need to know the sizeBytes of the snapshot
 	_, err := cmd.client.Snapshot(alias, snapshotName)
 	if err != nil {
@@ -235,7 +250,17 @@ func (cmd *SpdkCommands) AddTagLV(ctx context.Context, vg string, name string, t
 	return string("SPDK doesn't support add LV tag"), errors.New("SPDK doesn't support add LV tag")
 }
 
+// RenameLV renames lv
+func (cmd *SpdkCommands) RenameLV(ctx context.Context, vg string, name string, newName string) (string, error) {
+	return string("SPDK doesn't support rename LV"), errors.New("SPDK doesn't support rename LV")
+}
+
 // RemoveTagLV remove tag
 func (cmd *SpdkCommands) RemoveTagLV(ctx context.Context, vg string, name string, tags []string) (string, error) {
 	return string("SPDK doesn't support remove LV tag"), errors.New("SPDK doesn't support remove LV tag")
 }
+
+// SetIOThrottling set io throttling
+func (cmd *SpdkCommands) SetIOThrottling(ctx context.Context, vg string, name string, iops string, bps string) ([]string, error) {
+	return nil, errors.New("SPDK doesn't support io throttling")
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/alibaba/open-local/pkg/csi/lib"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	attrVG     = attribute.Key("lvm.vg")
+	attrLV     = attribute.Key("lvm.lv")
+	attrSize   = attribute.Key("lvm.size_bytes")
+	attrSource = attribute.Key("lvm.source")
+)
+
+// tracedMethods are lvmd methods executing lvm commands, keyed by grpc full
+// method. Listing and operation tracking are not traced
+var tracedMethods = map[string]string{
+	"/proto.LVM/CreateLV":        "lvm.CreateLV",
+	"/proto.LVM/RemoveLV":        "lvm.RemoveLV",
+	"/proto.LVM/CloneLV":         "lvm.CloneLV",
+	"/proto.LVM/ExpandLV":        "lvm.ExpandLV",
+	"/proto.LVM/CreateSnapshot":  "lvm.CreateSnapshot",
+	"/proto.LVM/RemoveSnapshot":  "lvm.RemoveSnapshot",
+	"/proto.LVM/AddTagLV":        "lvm.AddTagLV",
+	"/proto.LVM/RemoveTagLV":     "lvm.RemoveTagLV",
+	"/proto.LVM/CreateVG":        "lvm.CreateVG",
+	"/proto.LVM/RemoveVG":        "lvm.RemoveVG",
+	"/proto.LVM/CleanPath":       "lvm.CleanPath",
+	"/proto.LVM/CleanDevice":     "lvm.CleanDevice",
+	"/proto.LVM/SetIOThrottling": "lvm.SetIOThrottling",
+}
+
+// spanAttributes returns attributes of span for lvmd request
+func spanAttributes(req interface{}) []attribute.KeyValue {
+	switch r := req.(type) {
+	case *lib.CreateLVRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName()), attrSize.Int64(int64(r.GetSize()))}
+	case *lib.RemoveLVRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName())}
+	case *lib.CloneLVRequest:
+		return []attribute.KeyValue{attrSource.String(r.GetSourceName()), attrLV.String(r.GetDestName())}
+	case *lib.ExpandLVRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName()), attrSize.Int64(int64(r.GetSize()))}
+	case *lib.CreateSnapshotRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVgName()), attrLV.String(r.GetSnapshotName()), attrSource.String(r.GetSrcLvName())}
+	case *lib.RemoveSnapshotRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVgName()), attrLV.String(r.GetSnapshotName())}
+	case *lib.AddTagLVRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName())}
+	case *lib.RemoveTagLVRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName())}
+	case *lib.SetIOThrottlingRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetVolumeGroup()), attrLV.String(r.GetName())}
+	case *lib.CreateVGRequest:
+		return []attribute.KeyValue{attrVG.String(r.GetName())}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	attrVolumeName    = attribute.Key("csi.volume.name")
+	attrVolumeID      = attribute.Key("csi.volume.id")
+	attrRequiredBytes = attribute.Key("csi.volume.required_bytes")
+	attrTargetPath    = attribute.Key("csi.target_path")
+)
+
+// tracedMethods are csi methods traced by span, keyed by grpc full method
+var tracedMethods = map[string]string{
+	"/csi.v1.Controller/CreateVolume": "csi.CreateVolume",
+	"/csi.v1.Node/NodeStageVolume":    "csi.NodeStageVolume",
+	"/csi.v1.Node/NodePublishVolume":  "csi.NodePublishVolume",
+	"/csi.v1.Node/NodeExpandVolume":   "csi.NodeExpandVolume",
+}
+
+// spanAttributes returns attributes of span for csi request
+func spanAttributes(req interface{}) []attribute.KeyValue {
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		return []attribute.KeyValue{
+			attrVolumeName.String(r.GetName()),
+			attrRequiredBytes.Int64(r.GetCapacityRange().GetRequiredBytes()),
+		}
+	case *csi.NodeStageVolumeRequest:
+		return []attribute.KeyValue{attrVolumeID.String(r.GetVolumeId()), attrTargetPath.String(r.GetStagingTargetPath())}
+	case *csi.NodePublishVolumeRequest:
+		return []attribute.KeyValue{attrVolumeID.String(r.GetVolumeId()), attrTargetPath.String(r.GetTargetPath())}
+	case *csi.NodeExpandVolumeRequest:
+		return []attribute.KeyValue{
+			attrVolumeID.String(r.GetVolumeId()),
+			attrTargetPath.String(r.GetVolumePath()),
+			attrRequiredBytes.Int64(r.GetCapacityRange().GetRequiredBytes()),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/utils/tracing"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+func Test_tracedMethods(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	interceptor := tracing.UnaryServerInterceptor(tracedMethods, spanAttributes)
+
+	tests := []struct {
+		name      string
+		method    string
+		req       interface{}
+		wantSpan  string
+		wantAttrs []attribute.KeyValue
+	}{
+		{
+			name:     "test CreateVolume",
+			method:   "/csi.v1.Controller/CreateVolume",
+			req:      &csi.CreateVolumeRequest{Name: "pv-1", CapacityRange: &csi.CapacityRange{RequiredBytes: 1073741824}},
+			wantSpan: "csi.CreateVolume",
+			wantAttrs: []attribute.KeyValue{
+				attrVolumeName.String("pv-1"),
+				attrRequiredBytes.Int64(1073741824),
+			},
+		},
+		{
+			name:     "test NodeStageVolume",
+			method:   "/csi.v1.Node/NodeStageVolume",
+			req:      &csi.NodeStageVolumeRequest{VolumeId: "pv-1", StagingTargetPath: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"},
+			wantSpan: "csi.NodeStageVolume",
+			wantAttrs: []attribute.KeyValue{
+				attrVolumeID.String("pv-1"),
+				attrTargetPath.String("/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"),
+			},
+		},
+		{
+			name:     "test NodePublishVolume",
+			method:   "/csi.v1.Node/NodePublishVolume",
+			req:      &csi.NodePublishVolumeRequest{VolumeId: "pv-1", TargetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount"},
+			wantSpan: "csi.NodePublishVolume",
+			wantAttrs: []attribute.KeyValue{
+				attrVolumeID.String("pv-1"),
+				attrTargetPath.String("/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount"),
+			},
+		},
+		{
+			name:     "test NodeExpandVolume",
+			method:   "/csi.v1.Node/NodeExpandVolume",
+			req:      &csi.NodeExpandVolumeRequest{VolumeId: "pv-1", VolumePath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount", CapacityRange: &csi.CapacityRange{RequiredBytes: 2147483648}},
+			wantSpan: "csi.NodeExpandVolume",
+			wantAttrs: []attribute.KeyValue{
+				attrVolumeID.String("pv-1"),
+				attrTargetPath.String("/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount"),
+				attrRequiredBytes.Int64(2147483648),
+			},
+		},
+		{
+			name:   "test untraced method",
+			method: "/csi.v1.Identity/Probe",
+			req:    &csi.ProbeRequest{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			_, err := interceptor(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("interceptor() = %v", err)
+			}
+			spans := exporter.GetSpans()
+			if tt.wantSpan == "" {
+				if len(spans) != 0 {
+					t.Errorf("got spans %v, want none", spans)
+				}
+				return
+			}
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if spans[0].Name != tt.wantSpan {
+				t.Errorf("span name = %s, want %s", spans[0].Name, tt.wantSpan)
+			}
+			// attributes of request come before rpc attributes
+			if got := spans[0].Attributes[:len(tt.wantAttrs)]; !reflect.DeepEqual(got, tt.wantAttrs) {
+				t.Errorf("span attributes = %v, want %v", got, tt.wantAttrs)
+			}
+		})
+	}
+}
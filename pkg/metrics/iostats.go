@@ -0,0 +1,90 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// VolumeGroupIOStats exports io statistics reported by agent as counters,
+// which can not be set by CounterVec
+var VolumeGroupIOStats = newVGIOStatsCollector()
+
+type vgIOStatsCollector struct {
+	reads       *prometheus.Desc
+	writes      *prometheus.Desc
+	readBytes   *prometheus.Desc
+	writeBytes  *prometheus.Desc
+	ioTime      *prometheus.Desc
+	mutex       sync.RWMutex
+	statsByNode map[string]map[cache.ResourceName]nodelocalstorage.VolumeGroupIOStats
+}
+
+func newVGIOStatsCollector() *vgIOStatsCollector {
+	labels := []string{"nodename", "vgname"}
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("", Subsystem, name), help, labels, nil)
+	}
+	return &vgIOStatsCollector{
+		reads:       newDesc("volume_group_reads_total", "Reads completed by PVs of VG."),
+		writes:      newDesc("volume_group_writes_total", "Writes completed by PVs of VG."),
+		readBytes:   newDesc("volume_group_read_bytes_total", "Bytes read from PVs of VG."),
+		writeBytes:  newDesc("volume_group_written_bytes_total", "Bytes written to PVs of VG."),
+		ioTime:      newDesc("volume_group_io_time_seconds_total", "Time spent doing io by PVs of VG, summed over PVs."),
+		statsByNode: map[string]map[cache.ResourceName]nodelocalstorage.VolumeGroupIOStats{},
+	}
+}
+
+// update replaces io statistics with those in c
+func (collector *vgIOStatsCollector) update(c *cache.ClusterNodeCache) {
+	statsByNode := map[string]map[cache.ResourceName]nodelocalstorage.VolumeGroupIOStats{}
+	for nodeName := range c.Nodes {
+		if stats := c.GetNodeCache(nodeName).VGIOStats; len(stats) > 0 {
+			statsByNode[nodeName] = stats
+		}
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.statsByNode = statsByNode
+}
+
+func (collector *vgIOStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.reads
+	ch <- collector.writes
+	ch <- collector.readBytes
+	ch <- collector.writeBytes
+	ch <- collector.ioTime
+}
+
+func (collector *vgIOStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mutex.RLock()
+	defer collector.mutex.RUnlock()
+	for nodeName, stats := range collector.statsByNode {
+		for vgName, s := range stats {
+			labels := []string{nodeName, string(vgName)}
+			ch <- prometheus.MustNewConstMetric(collector.reads, prometheus.CounterValue, float64(s.ReadsCompleted), labels...)
+			ch <- prometheus.MustNewConstMetric(collector.writes, prometheus.CounterValue, float64(s.WritesCompleted), labels...)
+			ch <- prometheus.MustNewConstMetric(collector.readBytes, prometheus.CounterValue, float64(s.ReadBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(collector.writeBytes, prometheus.CounterValue, float64(s.WriteBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(collector.ioTime, prometheus.CounterValue, float64(s.IOTimeMilliseconds)/1000, labels...)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package metrics
 
 import (
 	"github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm/cache"
 	"github.com/alibaba/open-local/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -45,6 +46,14 @@ var (
 		},
 		[]string{"nodename", "vgname"},
 	)
+	VolumeGroupMetadataLow = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "volume_group_metadata_low",
+			Help:      "Is metadata area of VG nearly full.",
+		},
+		[]string{"nodename", "vgname"},
+	)
 	MountPointTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: Subsystem,
@@ -93,6 +102,64 @@ var (
 		},
 		[]string{"nodename", "name"},
 	)
+	// DiskTemperature is named open_local_disk_temperature_celsius, type is
+	// current or critical
+	DiskTemperature = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "open",
+			Subsystem: Subsystem,
+			Name:      "disk_temperature_celsius",
+			Help:      "Temperature of Device in celsius.",
+		},
+		[]string{"nodename", "name", "type"},
+	)
+	// StatusDrift is 1 if status of nls diverged from lvm state at the last
+	// check of agent, only nodes checked by agent are exported
+	StatusDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "status_drift",
+			Help:      "Is status of node local storage diverged from lvm state at the last check.",
+		},
+		[]string{"nodename"},
+	)
+	StatusDriftCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "status_drift_count",
+			Help:      "Number of status drifts detected since agent started.",
+		},
+		[]string{"nodename"},
+	)
+	// StorageVersionInfo is always 1, versions read by agent are exported in
+	// labels
+	StorageVersionInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "storage_version_info",
+			Help:      "Versions of lvm2, device-mapper and kernel on node.",
+		},
+		[]string{"nodename", "lvm", "library", "driver", "kernel"},
+	)
+	// SnapshotFillRate is the fill rate(bytes per second) of snapshot lv
+	// computed by agent from its recent usage samples
+	SnapshotFillRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "snapshot_fill_rate",
+			Help:      "Bytes per second snapshot LV fills.",
+		},
+		[]string{"nodename", "vgname", "lvname"},
+	)
+	// SnapshotSecondsToFull is only exported for snapshot lv whose usage grows
+	SnapshotSecondsToFull = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: Subsystem,
+			Name:      "snapshot_seconds_to_full",
+			Help:      "Estimated seconds until snapshot LV is full at its current size.",
+		},
+		[]string{"nodename", "vgname", "lvname"},
+	)
 	AllocatedNum = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: Subsystem,
@@ -129,13 +196,21 @@ func UpdateMetrics(c *cache.ClusterNodeCache) {
 	DeviceAvailable.Reset()
 	DeviceBind.Reset()
 	DeviceTotal.Reset()
+	DiskTemperature.Reset()
 	MountPointAvailable.Reset()
 	MountPointBind.Reset()
 	MountPointTotal.Reset()
 	VolumeGroupUsedByLocal.Reset()
 	VolumeGroupTotal.Reset()
+	VolumeGroupMetadataLow.Reset()
+	StatusDrift.Reset()
+	StatusDriftCount.Reset()
+	StorageVersionInfo.Reset()
+	SnapshotFillRate.Reset()
+	SnapshotSecondsToFull.Reset()
 	LocalPV.Reset()
 	InlineVolume.Reset()
+	VolumeGroupIOStats.update(c)
 
 	// metrics update
 	for nodeName := range c.Nodes {
@@ -143,6 +218,11 @@ func UpdateMetrics(c *cache.ClusterNodeCache) {
 		for vgname, info := range cache.VGs {
 			VolumeGroupTotal.WithLabelValues(nodeName, string(vgname)).Set(float64(info.Capacity))
 			VolumeGroupUsedByLocal.WithLabelValues(nodeName, string(vgname)).Set(float64(info.Requested))
+			if info.MetadataLow {
+				VolumeGroupMetadataLow.WithLabelValues(nodeName, string(vgname)).Set(1)
+			} else {
+				VolumeGroupMetadataLow.WithLabelValues(nodeName, string(vgname)).Set(0)
+			}
 		}
 		for mpname, info := range cache.MountPoints {
 			MountPointTotal.WithLabelValues(nodeName, string(mpname), string(info.MediaType)).Set(float64(info.Capacity))
@@ -162,6 +242,12 @@ func UpdateMetrics(c *cache.ClusterNodeCache) {
 				DeviceBind.WithLabelValues(nodeName, string(devicename)).Set(0)
 			}
 		}
+		for devicename, temp := range cache.DeviceTemperatures {
+			DiskTemperature.WithLabelValues(nodeName, string(devicename), "current").Set(float64(temp.Current))
+			if temp.Critical > 0 {
+				DiskTemperature.WithLabelValues(nodeName, string(devicename), "critical").Set(float64(temp.Critical))
+			}
+		}
 		var pvType, storageName string
 		for pvname, pv := range cache.LocalPVs {
 			switch pv.Spec.CSI.VolumeAttributes[pkg.VolumeTypeKey] {
@@ -197,6 +283,26 @@ func UpdateMetrics(c *cache.ClusterNodeCache) {
 			}
 		}
 
+		if drift := cache.StatusDrift; drift != nil {
+			if drift.State.Status == nodelocalstorage.ConditionTrue {
+				StatusDrift.WithLabelValues(nodeName).Set(1)
+			} else {
+				StatusDrift.WithLabelValues(nodeName).Set(0)
+			}
+			StatusDriftCount.WithLabelValues(nodeName).Set(float64(drift.DriftCount))
+		}
+		for vgname, trends := range cache.SnapshotTrends {
+			for lvname, trend := range trends {
+				SnapshotFillRate.WithLabelValues(nodeName, string(vgname), lvname).Set(float64(trend.FillRate))
+				if trend.SecondsToFull != nil {
+					SnapshotSecondsToFull.WithLabelValues(nodeName, string(vgname), lvname).Set(float64(*trend.SecondsToFull))
+				}
+			}
+		}
+		if versions := cache.Versions; versions != nil {
+			StorageVersionInfo.WithLabelValues(nodeName, versions.LVM, versions.Library, versions.Driver, versions.Kernel).Set(1)
+		}
+
 		AllocatedNum.WithLabelValues(nodeName).Set(float64(cache.AllocatedNum))
 	}
 }
@@ -27,7 +27,7 @@ import (
 	"github.com/alibaba/open-local/pkg/utils"
 	volumesnapshotinformers "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions/volumesnapshot/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/klog/v2"
@@ -36,6 +36,22 @@ import (
 const MinScore int = 0
 const MaxScore int = 10
 
+// vgCapacity returns the state of vg of cache deciding whether a new lv fits
+// in it, checked and scored the same way as scheduling framework
+func vgCapacity(vg cache.SharedResource) algorithm.VGCapacity {
+	return algorithm.VGCapacity{
+		Name:            vg.Name,
+		Allocatable:     vg.Capacity,
+		Requested:       vg.Requested,
+		LVCount:         vg.LVCount,
+		PreallocatedLVs: vg.PreallocatedLVs,
+		LVLimit:         vg.LVLimit,
+		Maintenance:     vg.Maintenance,
+		MetadataFree:    vg.MetadataFree,
+		MetadataSize:    vg.MetadataSize,
+	}
+}
+
 // AllocateLVMVolume contains two policy: BINPACK/SPREAD
 func AllocateLVMVolume(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, node *corev1.Node, ctx *algorithm.SchedulingContext) (fits bool, units []cache.AllocatedUnit, err error) {
 	if len(pvcs) <= 0 {
@@ -46,7 +62,7 @@ func AllocateLVMVolume(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, no
 	}
 	klog.Infof("allocating lvm volume for pod %s", utils.GetName(pod.ObjectMeta))
 
-	fits, units, err = ProcessLVMPVCPredicate(pvcs, node, ctx)
+	fits, units, err = ProcessLVMPVCPredicate(pod, pvcs, node, ctx)
 	if err != nil {
 		return
 	}
@@ -59,41 +75,54 @@ func AllocateLVMVolume(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, no
 	return true, units, nil
 }
 
-func ProcessLVMPVCPredicate(pvcs []*corev1.PersistentVolumeClaim, node *corev1.Node, ctx *algorithm.SchedulingContext) (fits bool, units []cache.AllocatedUnit, err error) {
+func ProcessLVMPVCPredicate(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, node *corev1.Node, ctx *algorithm.SchedulingContext) (fits bool, units []cache.AllocatedUnit, err error) {
 	pvcsWithVG, pvcsWithoutVG := DivideLVMPVCs(pvcs, ctx)
 	cacheVGsMap, err := GetNodeVGMap(node, ctx)
 	if err != nil {
 		return false, units, err
 	}
+	spread := localtype.IsVolumeSpreadByVG(pod)
+	if spread {
+		pvcsWithoutVG = sortPVCsByRequestedSize(pvcsWithoutVG, ctx)
+	}
+	// vg taken by volumes of pod, which is excluded if pod spreads its volumes
+	usedVGs := make(map[string]bool)
 
 	// process pvcsWithVG first
 	for _, pvc := range pvcsWithVG {
-		// pvc source 是只读快照，就 continue
+		vgName, err := GetVGNameOfLVMPVC(pvc, ctx)
+		if err != nil {
+			return false, units, err
+		}
+		// pvc source 是只读快照且不全量拷贝，就 continue
 		// todo: 没有判断 snapshot 是否存在
-		if utils.IsReadOnlySnapshotPVC(pvc, ctx.SnapshotInformers) {
-			klog.Infof("pvc %s is readonly, skip lvm predicating", pvc.Namespace, pvc.Name)
+		if vgName == "" {
+			klog.Infof("pvc %s is readonly, skip lvm predicating", utils.GetName(pvc.ObjectMeta))
 			continue
 		}
-		vgName, err := utils.GetVGNameFromPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		requestedSize, err := utils.GetLVMPVCAllocatedSize(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
 		if err != nil {
 			return false, units, err
 		}
-		requestedSize := utils.GetPVCRequested(pvc)
 
 		vg, ok := cacheVGsMap[cache.ResourceName(vgName)]
 		if !ok {
 			return false, units, errors.NewNoSuchVGError(vgName, node.GetName())
 		}
+		if spread && usedVGs[vgName] {
+			return false, units, errors.NewVolumeSpreadError(utils.PVCName(pvc), node.GetName())
+		}
 
-		freeSize := vg.Capacity - vg.Requested
-		klog.V(6).Infof("validating vg(name=%s,free=%d) for pvc(name=%s,requested=%d)", vgName, freeSize, pvc.Name, requestedSize)
+		klog.V(6).Infof("validating vg(name=%s,free=%d) for pvc(name=%s,requested=%d)", vgName, vg.Capacity-vg.Requested, pvc.Name, requestedSize)
 
-		if freeSize < requestedSize {
-			return false, units, errors.NewInsufficientLVMError(requestedSize, vg.Requested, vg.Capacity, vg.Name, node.GetName())
+		if err := algorithm.CheckVGFitsLV(vgCapacity(vg), requestedSize, node.GetName()); err != nil {
+			return false, units, err
 		}
 		tmp := cacheVGsMap[cache.ResourceName(vgName)]
 		tmp.Requested += requestedSize
+		tmp.LVCount++
 		cacheVGsMap[cache.ResourceName(vgName)] = tmp
+		usedVGs[vgName] = true
 		u := cache.AllocatedUnit{
 			NodeName:   node.Name,
 			VolumeType: localtype.VolumeTypeLVM,
@@ -108,7 +137,7 @@ func ProcessLVMPVCPredicate(pvcs []*corev1.PersistentVolumeClaim, node *corev1.N
 	}
 
 	// make a copy slice of cacheVGsMap
-	cacheVGsSlice := make([]cache.SharedResource, len(cacheVGsMap))
+	cacheVGsSlice := make([]cache.SharedResource, 0, len(cacheVGsMap))
 	for _, vg := range cacheVGsMap {
 		cacheVGsSlice = append(cacheVGsSlice, vg)
 	}
@@ -118,24 +147,54 @@ func ProcessLVMPVCPredicate(pvcs []*corev1.PersistentVolumeClaim, node *corev1.N
 	}
 	// process pvcsWithoutVG
 	for _, pvc := range pvcsWithoutVG {
-		requestedSize := utils.GetPVCRequested(pvc)
+		requestedSize, err := utils.GetLVMPVCAllocatedSize(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		if err != nil {
+			return false, units, err
+		}
+
+		vgSelector, err := utils.GetVGSelectorFromPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		if err != nil {
+			return false, units, err
+		}
 
 		// sort by available size
 		sort.Slice(cacheVGsSlice, func(i, j int) bool {
 			return (cacheVGsSlice[i].Capacity - cacheVGsSlice[i].Requested) < (cacheVGsSlice[j].Capacity - cacheVGsSlice[j].Requested)
 		})
 
+		// only vg matching selector of storage class is considered
+		candidates := make([]int, 0, len(cacheVGsSlice))
+		spreadExcluded := 0
 		for i, vg := range cacheVGsSlice {
-			freeSize := vg.Capacity - vg.Requested
-			klog.V(6).Infof("validating vg(name=%s,free=%d) for pvc(name=%s,requested=%d)", vg.Name, freeSize, pvc.Name, requestedSize)
+			if vgSelector.Empty() || vgSelector.Matches(labels.Set(vg.Labels)) {
+				if spread && usedVGs[string(vg.Name)] {
+					spreadExcluded++
+					continue
+				}
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			if spreadExcluded > 0 {
+				return false, units, errors.NewVolumeSpreadError(utils.PVCName(pvc), node.GetName())
+			}
+			return false, units, errors.NewNoMatchingVGError(vgSelector.String(), node.GetName())
+		}
+
+		for n, i := range candidates {
+			vg := cacheVGsSlice[i]
+			klog.V(6).Infof("validating vg(name=%s,free=%d) for pvc(name=%s,requested=%d)", vg.Name, vg.Capacity-vg.Requested, pvc.Name, requestedSize)
 
-			if freeSize < requestedSize {
-				if i == len(cacheVGsSlice)-1 {
-					return false, units, errors.NewInsufficientLVMError(requestedSize, vg.Requested, vg.Capacity, vg.Name, node.GetName())
+			// vg under maintenance or reaching lv limit is treated as full
+			if err := algorithm.CheckVGFitsLV(vgCapacity(vg), requestedSize, node.GetName()); err != nil {
+				if n == len(candidates)-1 {
+					return false, units, err
 				}
 				continue
 			}
 			cacheVGsSlice[i].Requested += requestedSize
+			cacheVGsSlice[i].LVCount++
+			usedVGs[string(vg.Name)] = true
 			u := cache.AllocatedUnit{
 				NodeName:   node.Name,
 				VolumeType: localtype.VolumeTypeLVM,
@@ -171,13 +230,12 @@ func HandleInlineLVMVolume(ctx *algorithm.SchedulingContext, node *corev1.Node,
 				return false, units, errors.NewNoSuchVGError(vgName, node.GetName())
 			}
 
-			freeSize := vg.Capacity - vg.Requested
-
-			if freeSize < requestedSize {
-				return false, units, errors.NewInsufficientLVMError(requestedSize, vg.Requested, vg.Capacity, vg.Name, node.GetName())
+			if err := algorithm.CheckVGFitsLV(vgCapacity(vg), requestedSize, node.GetName()); err != nil {
+				return false, units, err
 			}
 			tmp := cacheVGsMap[cache.ResourceName(vgName)]
 			tmp.Requested += requestedSize
+			tmp.LVCount++
 			cacheVGsMap[cache.ResourceName(vgName)] = tmp
 			u := cache.AllocatedUnit{
 				NodeName:   node.Name,
@@ -196,13 +254,21 @@ func HandleInlineLVMVolume(ctx *algorithm.SchedulingContext, node *corev1.Node,
 	return true, units, nil
 }
 
-// DivideLVMPVCs divide pvcs into pvcsWithVG and pvcsWithoutVG
+// DivideLVMPVCs divide pvcs into pvcsWithVG and pvcsWithoutVG, full copy of
+// readonly snapshot is taken as pvc with vg of the source volume
 func DivideLVMPVCs(pvcs []*corev1.PersistentVolumeClaim, ctx *algorithm.SchedulingContext) (pvcsWithVG, pvcsWithoutVG []*corev1.PersistentVolumeClaim) {
 	for _, pvc := range pvcs {
 		vgName, err := utils.GetVGNameFromPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
 		if err != nil {
 			return
 		}
+		if vgName == "" && utils.IsReadOnlySnapshotPVC(pvc, ctx.SnapshotInformers) {
+			// error is returned when pvcsWithVG is processed
+			if copyVG, err := GetVGNameOfLVMPVC(pvc, ctx); err != nil || copyVG != "" {
+				pvcsWithVG = append(pvcsWithVG, pvc)
+				continue
+			}
+		}
 		if vgName == "" {
 			pvcsWithoutVG = append(pvcsWithoutVG, pvc)
 		} else {
@@ -212,6 +278,44 @@ func DivideLVMPVCs(pvcs []*corev1.PersistentVolumeClaim, ctx *algorithm.Scheduli
 	return
 }
 
+// GetVGNameOfLVMPVC returns vg in which lv of pvc with vg is created. Readonly
+// snapshot pvc is mounted from the snapshot lv and takes no space, empty name
+// is returned for it, unless its storage class asks for full copy which is
+// created in vg of the source volume
+func GetVGNameOfLVMPVC(pvc *corev1.PersistentVolumeClaim, ctx *algorithm.SchedulingContext) (string, error) {
+	scLister := ctx.StorageV1Informers.StorageClasses().Lister()
+	if !utils.IsReadOnlySnapshotPVC(pvc, ctx.SnapshotInformers) {
+		return utils.GetVGNameFromPVC(pvc, scLister)
+	}
+	sc, err := utils.GetStorageClassFromPVC(pvc, scLister)
+	if err != nil {
+		return "", err
+	}
+	if sc == nil || utils.GetParam(sc.Parameters, localtype.ParamSnapshotFullCopy) != "true" {
+		return "", nil
+	}
+	snapshot, err := ctx.SnapshotInformers.VolumeSnapshots().Lister().VolumeSnapshots(pvc.Namespace).Get(pvc.Spec.DataSource.Name)
+	if err != nil {
+		return "", fmt.Errorf("get snapshot of pvc %s failed: %s", utils.GetName(pvc.ObjectMeta), err.Error())
+	}
+	if snapshot.Spec.Source.PersistentVolumeClaimName == nil {
+		return "", fmt.Errorf("snapshot %s of pvc %s has no source pvc", utils.GetNameKey(snapshot.Namespace, snapshot.Name), utils.GetName(pvc.ObjectMeta))
+	}
+	srcPVC, err := ctx.CoreV1Informers.PersistentVolumeClaims().Lister().PersistentVolumeClaims(pvc.Namespace).Get(*snapshot.Spec.Source.PersistentVolumeClaimName)
+	if err != nil {
+		return "", fmt.Errorf("get source pvc of snapshot %s failed: %s", utils.GetNameKey(snapshot.Namespace, snapshot.Name), err.Error())
+	}
+	srcPV, err := ctx.CoreV1Informers.PersistentVolumes().Lister().Get(srcPVC.Spec.VolumeName)
+	if err != nil {
+		return "", fmt.Errorf("get source pv of pvc %s failed: %s", utils.GetName(srcPVC.ObjectMeta), err.Error())
+	}
+	vgName := utils.GetVGNameFromCsiPV(srcPV)
+	if vgName == "" {
+		return "", fmt.Errorf("source pv %s of pvc %s has no vg", srcPV.Name, utils.GetName(pvc.ObjectMeta))
+	}
+	return vgName, nil
+}
+
 // GetNodeVGMap make a copy map of NodeCache VGs
 func GetNodeVGMap(node *corev1.Node, ctx *algorithm.SchedulingContext) (cacheVGsMap map[cache.ResourceName]cache.SharedResource, err error) {
 	nodeCache := ctx.ClusterNodeCache.GetNodeCache(node.Name)
@@ -267,14 +371,8 @@ func ProcessMPPVC(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, node *c
 	requestedHDDCount := int64(len(pvcsWithTypeHDD))
 
 	// process pvcsWithTypeSSD first.
-	if freeMPSSDCount < requestedSSDCount {
-		return false, units, errors.NewInsufficientMountPointCountError(
-			requestedSSDCount,
-			freeMPSSDCount,
-			totalCount,
-			localtype.MediaTypeSSD,
-			node.GetName(),
-		)
+	if err := algorithm.CheckExclusiveResourceCount(localtype.VolumeTypeMountPoint, localtype.MediaTypeSSD, requestedSSDCount, freeMPSSDCount, totalCount, node.GetName()); err != nil {
+		return false, units, err
 	}
 	fits, rstUnits, err := CheckExclusiveResourceMeetsPVCSize(localtype.VolumeTypeMountPoint, freeMPSSD, pvcsWithTypeSSD, node, ctx)
 	if err != nil {
@@ -286,15 +384,9 @@ func ProcessMPPVC(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, node *c
 	units = append(units, rstUnits...)
 
 	// process pvcsWithTypeHDD second.
-	if freeMPHDDCount < requestedHDDCount {
+	if err := algorithm.CheckExclusiveResourceCount(localtype.VolumeTypeMountPoint, localtype.MediaTypeHDD, requestedHDDCount, freeMPHDDCount, totalCount, node.GetName()); err != nil {
 		klog.Infof("there is no enough mount point volume on node %s, want(cnt) %d, actual(cnt) %d, type hdd", node.Name, requestedHDDCount, freeMPHDDCount)
-		return false, units, errors.NewInsufficientMountPointCountError(
-			requestedHDDCount,
-			freeMPHDDCount,
-			totalCount,
-			localtype.MediaTypeHDD,
-			node.GetName(),
-		)
+		return false, units, err
 	}
 	fits, rstUnits, err = CheckExclusiveResourceMeetsPVCSize(localtype.VolumeTypeMountPoint, freeMPHDD, pvcsWithTypeHDD, node, ctx)
 	if err != nil {
@@ -368,51 +460,33 @@ func CheckExclusiveResourceMeetsPVCSize(resource localtype.VolumeType, ers []cac
 		return ers[i].Capacity < ers[j].Capacity
 	})
 
+	requests := make([]int64, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		requests = append(requests, utils.GetPVCRequested(pvc))
+	}
+	capacities := make([]int64, 0, len(ers))
+	for _, er := range ers {
+		capacities = append(capacities, er.Capacity)
+	}
+	assigned, err := algorithm.FitExclusiveResources(resource, requests, capacities)
 	if err != nil {
 		return false, units, err
 	}
-	disksCount := int64(len(ers))
-	pvcsCount := int64(len(pvcs))
-
-	i := 0
-	var maxSize int64 = 0
-	for j, er := range ers {
-		if pvcsCount == 0 {
-			break
-		}
-		pvc := pvcs[i]
-		requestedSize := utils.GetPVCRequested(pvc)
-		if requestedSize > int64(maxSize) {
-			maxSize = requestedSize
-		}
-		klog.V(6).Infof("[CheckExclusiveResourceMeetsPVCSize]%s(%s) capacity=%d, pvc requestedSize=%d", er.Name, string(er.MediaType), er.Capacity, requestedSize)
-		if er.Capacity < requestedSize {
-			if j == int(disksCount)-1 {
-				return false, units, errors.NewInsufficientExclusiveResourceError(
-					resource,
-					requestedSize,
-					maxSize)
-			}
-			continue
-		}
-
+	for i, j := range assigned {
+		er := ers[j]
 		u := cache.AllocatedUnit{
 			NodeName:   node.Name,
 			VolumeType: resource,
-			Requested:  requestedSize,
+			Requested:  requests[i],
 			Allocated:  er.Capacity,
 			VgName:     "",
 			Device:     er.Name,
 			MountPoint: er.Name,
-			PVCName:    utils.PVCName(pvc),
+			PVCName:    utils.PVCName(pvcs[i]),
 		}
 
-		klog.V(6).Infof("found unit: %#v for pvc %#v", u, pvc)
+		klog.V(6).Infof("found unit: %#v for pvc %#v", u, pvcs[i])
 		units = append(units, u)
-		i++
-		if i == int(pvcsCount) {
-			break
-		}
 	}
 
 	return true, units, nil
@@ -439,7 +513,10 @@ func GetFreeDevice(node *corev1.Node, ctx *algorithm.SchedulingContext) (freeDev
 		return nil, nil, fmt.Errorf("node %s not found from cache", node.Name)
 	}
 
-	for _, device := range nodeCache.Devices {
+	for name, device := range nodeCache.Devices {
+		if nodeCache.WipingDevices[name] {
+			continue
+		}
 		if device.MediaType == localtype.MediaTypeSSD && !device.IsAllocated {
 			freeDeviceSSD = append(freeDeviceSSD, device)
 		} else if device.MediaType == localtype.MediaTypeHDD && !device.IsAllocated {
@@ -482,14 +559,8 @@ func ProcessDevicePVC(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, nod
 	requestedHDDCount := int64(len(pvcsWithTypeHDD))
 
 	// process pvcsWithTypeSSD first.
-	if freeDeviceSSDCount < requestedSSDCount {
-		return false, units, errors.NewInsufficientDeviceCountError(
-			requestedSSDCount,
-			freeDeviceSSDCount,
-			totalCount,
-			localtype.MediaTypeSSD,
-			node.GetName(),
-		)
+	if err := algorithm.CheckExclusiveResourceCount(localtype.VolumeTypeDevice, localtype.MediaTypeSSD, requestedSSDCount, freeDeviceSSDCount, totalCount, node.GetName()); err != nil {
+		return false, units, err
 	}
 	fits, rstUnits, err := CheckExclusiveResourceMeetsPVCSize(localtype.VolumeTypeDevice, freeDeviceSSD, pvcsWithTypeSSD, node, ctx)
 	if err != nil {
@@ -501,14 +572,8 @@ func ProcessDevicePVC(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim, nod
 	units = append(units, rstUnits...)
 
 	// process pvcsWithTypeHDD second.
-	if freeDeviceHDDCount < requestedHDDCount {
-		return false, units, errors.NewInsufficientDeviceCountError(
-			requestedHDDCount,
-			freeDeviceHDDCount,
-			totalCount,
-			localtype.MediaTypeHDD,
-			node.GetName(),
-		)
+	if err := algorithm.CheckExclusiveResourceCount(localtype.VolumeTypeDevice, localtype.MediaTypeHDD, requestedHDDCount, freeDeviceHDDCount, totalCount, node.GetName()); err != nil {
+		return false, units, err
 	}
 	fits, rstUnits, err = CheckExclusiveResourceMeetsPVCSize(localtype.VolumeTypeDevice, freeDeviceHDD, pvcsWithTypeHDD, node, ctx)
 	if err != nil {
@@ -575,36 +640,41 @@ func ProcessLVMPVCPriority(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim
 	if err != nil {
 		return false, units, err
 	}
+	spread := localtype.IsVolumeSpreadByVG(pod)
+	if spread {
+		pvcsWithoutVG = sortPVCsByRequestedSize(pvcsWithoutVG, ctx)
+	}
+	usedVGs := make(map[string]bool)
 
 	// process pvcsWithVG first
 	for _, pvc := range pvcsWithVG {
-		if utils.IsReadOnlySnapshotPVC(pvc, ctx.SnapshotInformers) {
-			klog.Infof("pvc %s is readonly, skip lvm prioritying", pvc.Namespace, pvc.Name)
-			continue
-		}
-		vgName, err := utils.GetVGNameFromPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		vgName, err := GetVGNameOfLVMPVC(pvc, ctx)
 		if err != nil {
 			return false, units, err
 		}
+		if vgName == "" {
+			klog.Infof("pvc %s is readonly, skip lvm prioritying", utils.GetName(pvc.ObjectMeta))
+			continue
+		}
 		if _, ok := cacheVGsMap[cache.ResourceName(vgName)]; !ok {
 			return false, units, fmt.Errorf("no vg named %s on node %s", vgName, node.Name)
 		}
+		if spread && usedVGs[vgName] {
+			return false, units, errors.NewVolumeSpreadError(utils.PVCName(pvc), node.GetName())
+		}
 
-		requestedSize := utils.GetPVCRequested(pvc)
-		freeSize := cacheVGsMap[cache.ResourceName(vgName)].Capacity - cacheVGsMap[cache.ResourceName(vgName)].Requested
-		quanFree := resource.NewQuantity(freeSize, resource.BinarySI)
-		quanReq := resource.NewQuantity(requestedSize, resource.BinarySI)
-		if freeSize < requestedSize {
-			if pod == nil {
-				return false, units, fmt.Errorf("not enough lv storage on %s/%s, requested size %s,  free size %s",
-					node.Name, vgName, quanReq.String(), quanFree.String())
-			}
-			return false, units, fmt.Errorf("not enough lv storage on %s/%s for pod %s, requested size %s,  free size %s",
-				node.Name, vgName, utils.GetName(pod.ObjectMeta), quanReq.String(), quanFree.String())
+		requestedSize, err := utils.GetLVMPVCAllocatedSize(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		if err != nil {
+			return false, units, err
+		}
+		if err := algorithm.CheckVGFitsLV(vgCapacity(cacheVGsMap[cache.ResourceName(vgName)]), requestedSize, node.GetName()); err != nil {
+			return false, units, err
 		}
 		tmp := cacheVGsMap[cache.ResourceName(vgName)]
 		tmp.Requested += requestedSize
+		tmp.LVCount++
 		cacheVGsMap[cache.ResourceName(vgName)] = tmp
+		usedVGs[vgName] = true
 		u := cache.AllocatedUnit{
 			NodeName:   node.Name,
 			VolumeType: localtype.VolumeTypeLVM,
@@ -620,20 +690,36 @@ func ProcessLVMPVCPriority(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim
 
 	// process pvcsWithoutVG(default strategy: Binpack)
 	for _, pvc := range pvcsWithoutVG {
-		switch localtype.SchedulerStrategy {
-		case localtype.StrategyBinpack:
-			fits, tmpunits, err := Binpack(pod, pvc, node, cacheVGsMap)
-			if !fits {
-				return false, units, err
-			}
-			units = append(units, tmpunits...)
-		case localtype.StrategySpread:
-			fits, tmpunits, err := Spread(pod, pvc, node, cacheVGsMap)
-			if !fits {
-				return false, units, err
+		requestedSize, err := utils.GetLVMPVCAllocatedSize(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		if err != nil {
+			return false, units, err
+		}
+		vgSelector, err := utils.GetVGSelectorFromPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		if err != nil {
+			return false, units, err
+		}
+		candidates := matchingVGs(cacheVGsMap, vgSelector)
+		if len(candidates) == 0 && !vgSelector.Empty() {
+			return false, units, errors.NewNoMatchingVGError(vgSelector.String(), node.GetName())
+		}
+		if spread {
+			candidates = excludeVGs(candidates, usedVGs)
+			if len(candidates) == 0 {
+				return false, units, errors.NewVolumeSpreadError(utils.PVCName(pvc), node.GetName())
 			}
-			units = append(units, tmpunits...)
 		}
+		tmpunits, err := allocateLVMPVCWithoutVG(pod, pvc, requestedSize, node, candidates)
+		// requested size of candidates is carried over to the next pvc
+		for name, vg := range candidates {
+			cacheVGsMap[name] = vg
+		}
+		if err != nil {
+			return false, units, err
+		}
+		for _, u := range tmpunits {
+			usedVGs[u.VgName] = true
+		}
+		units = append(units, tmpunits...)
 	}
 	if len(units) <= 0 {
 		return false, units, nil
@@ -641,41 +727,147 @@ func ProcessLVMPVCPriority(pod *corev1.Pod, pvcs []*corev1.PersistentVolumeClaim
 	return true, units, nil
 }
 
-func Binpack(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (fits bool, units []cache.AllocatedUnit, err error) {
+// allocateLVMPVCWithoutVG allocates requestedSize for pvc from cacheVGsMap by
+// consistent hashing if enabled and then scheduler strategy
+func allocateLVMPVCWithoutVG(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, requestedSize int64, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) ([]cache.AllocatedUnit, error) {
+	if localtype.VGConsistentHashing {
+		if fits, units := ConsistentHash(pvc, requestedSize, node, cacheVGsMap); fits {
+			return units, nil
+		}
+	}
+	switch localtype.SchedulerStrategy {
+	case localtype.StrategyBinpack:
+		fits, units, err := Binpack(pod, pvc, requestedSize, node, cacheVGsMap)
+		if !fits {
+			return nil, err
+		}
+		return units, nil
+	case localtype.StrategySpread:
+		fits, units, err := Spread(pod, pvc, requestedSize, node, cacheVGsMap)
+		if !fits {
+			return nil, err
+		}
+		return units, nil
+	}
+	return nil, nil
+}
+
+// matchingVGs returns vg whose labels match selector, which may be all vg
+func matchingVGs(cacheVGsMap map[cache.ResourceName]cache.SharedResource, selector labels.Selector) map[cache.ResourceName]cache.SharedResource {
+	if selector.Empty() {
+		return cacheVGsMap
+	}
+	matched := make(map[cache.ResourceName]cache.SharedResource)
+	for name, vg := range cacheVGsMap {
+		if selector.Matches(labels.Set(vg.Labels)) {
+			matched[name] = vg
+		}
+	}
+	return matched
+}
+
+// excludeVGs returns vg of cacheVGsMap which is not in used
+func excludeVGs(cacheVGsMap map[cache.ResourceName]cache.SharedResource, used map[string]bool) map[cache.ResourceName]cache.SharedResource {
+	left := make(map[cache.ResourceName]cache.SharedResource)
+	for name, vg := range cacheVGsMap {
+		if !used[string(name)] {
+			left[name] = vg
+		}
+	}
+	return left
+}
+
+// sortPVCsByRequestedSize returns pvcs with larger requested size first, a
+// large volume has fewer vg to choose from when volumes are spread
+func sortPVCsByRequestedSize(pvcs []*corev1.PersistentVolumeClaim, ctx *algorithm.SchedulingContext) []*corev1.PersistentVolumeClaim {
+	sizes := make(map[string]int64, len(pvcs))
+	for _, pvc := range pvcs {
+		// error is returned when the pvc is allocated
+		size, _ := utils.GetLVMPVCAllocatedSize(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+		sizes[utils.PVCName(pvc)] = size
+	}
+	sorted := make([]*corev1.PersistentVolumeClaim, len(pvcs))
+	copy(sorted, pvcs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sizes[utils.PVCName(sorted[i])] > sizes[utils.PVCName(sorted[j])]
+	})
+	return sorted
+}
+
+// ConsistentHash allocates requestedSize on the vg hashed by identity of pvc,
+// it does not fit if the hashed vg is short of space and caller falls back
+// to scheduler strategy
+func ConsistentHash(pvc *corev1.PersistentVolumeClaim, requestedSize int64, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (fits bool, units []cache.AllocatedUnit) {
+	names := make([]string, 0, len(cacheVGsMap))
+	for name := range cacheVGsMap {
+		names = append(names, string(name))
+	}
+	hashed := utils.ConsistentHash(utils.PVCName(pvc), names)
+	if hashed == "" {
+		return false, units
+	}
+	vg := cacheVGsMap[cache.ResourceName(hashed)]
+	if algorithm.CheckVGFitsLV(vgCapacity(vg), requestedSize, node.GetName()) != nil {
+		klog.V(4).Infof("hashed vg %s on node %s can't fit pvc %s, fall back to strategy %s", hashed, node.GetName(), utils.PVCName(pvc), localtype.SchedulerStrategy)
+		return false, units
+	}
+	vg.Requested += requestedSize
+	vg.LVCount++
+	cacheVGsMap[cache.ResourceName(hashed)] = vg
+	u := cache.AllocatedUnit{
+		NodeName:   node.Name,
+		VolumeType: localtype.VolumeTypeLVM,
+		Requested:  requestedSize,
+		Allocated:  requestedSize, // for LVM requested is always equal to allocated
+		VgName:     hashed,
+		Device:     "",
+		MountPoint: "",
+		PVCName:    utils.PVCName(pvc),
+	}
+	units = append(units, u)
+	return true, units
+}
+
+// Binpack allocates requestedSize, which includes snapshot headroom, for pvc
+// on vg with the least free size it fits in
+func Binpack(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, requestedSize int64, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (fits bool, units []cache.AllocatedUnit, err error) {
+	return allocateByFreeSize(pvc, requestedSize, node, cacheVGsMap, false)
+}
+
+// Spread allocates requestedSize, which includes snapshot headroom, for pvc
+// on vg with the most free size it fits in
+func Spread(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, requestedSize int64, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (fits bool, units []cache.AllocatedUnit, err error) {
+	return allocateByFreeSize(pvc, requestedSize, node, cacheVGsMap, true)
+}
+
+// allocateByFreeSize allocates requestedSize for pvc on the first vg fitting
+// it in order of free size, vg under maintenance or reaching lv limit is
+// skipped the same way as predicate
+func allocateByFreeSize(pvc *corev1.PersistentVolumeClaim, requestedSize int64, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource, mostFreeFirst bool) (fits bool, units []cache.AllocatedUnit, err error) {
 	if len(cacheVGsMap) == 0 {
-		return false, units, fmt.Errorf("no vg on node %s,", node.Name)
+		return false, units, errors.NewNoAvailableVGError(node.Name)
 	}
-	requestedSize := utils.GetPVCRequested(pvc)
 
 	// make a copy slice of cacheVGsMap
-	cacheVGsSlice := make([]cache.SharedResource, len(cacheVGsMap))
+	cacheVGsSlice := make([]cache.SharedResource, 0, len(cacheVGsMap))
 	for _, vg := range cacheVGsMap {
 		cacheVGsSlice = append(cacheVGsSlice, vg)
 	}
-
-	// sort from small to large according to free size
 	sort.Slice(cacheVGsSlice, func(i, j int) bool {
-		return (cacheVGsSlice[i].Capacity - cacheVGsSlice[i].Requested) < (cacheVGsSlice[j].Capacity - cacheVGsSlice[j].Requested)
+		freeI := cacheVGsSlice[i].Capacity - cacheVGsSlice[i].Requested
+		freeJ := cacheVGsSlice[j].Capacity - cacheVGsSlice[j].Requested
+		if mostFreeFirst {
+			return freeI > freeJ
+		}
+		return freeI < freeJ
 	})
-	for i, vg := range cacheVGsSlice {
-		freeSize := vg.Capacity - vg.Requested
-		quanFree := resource.NewQuantity(freeSize, resource.BinarySI)
-		quanReq := resource.NewQuantity(requestedSize, resource.BinarySI)
-		if freeSize < requestedSize {
-			if i == len(cacheVGsSlice)-1 {
-				if pod == nil {
-					return false, units, fmt.Errorf("[multipleVGs]not enough lv storage on %s, requested size %s, max free size[VG: %s] %s, strategiy %s. you need to expand the vg",
-						node.Name, quanReq.String(), vg.Name, quanFree.String(), localtype.SchedulerStrategy)
-
-				}
-				return false, units, fmt.Errorf("[multipleVGs]not enough lv storage on %s for pod %s, requested size %s, max free size[VG: %s] %s, strategiy %s. you need to expand the vg",
-					node.Name, utils.GetName(pod.ObjectMeta), quanReq.String(), vg.Name, quanFree.String(), localtype.SchedulerStrategy)
-			}
+	for _, vg := range cacheVGsSlice {
+		if err = algorithm.CheckVGFitsLV(vgCapacity(vg), requestedSize, node.GetName()); err != nil {
 			continue
 		}
-		tmp := cacheVGsMap[cache.ResourceName(vg.Name)]
-		tmp.Requested += requestedSize
-		cacheVGsMap[cache.ResourceName(vg.Name)] = tmp
+		vg.Requested += requestedSize
+		vg.LVCount++
+		cacheVGsMap[cache.ResourceName(vg.Name)] = vg
 		u := cache.AllocatedUnit{
 			NodeName:   node.Name,
 			VolumeType: localtype.VolumeTypeLVM,
@@ -687,91 +879,31 @@ func Binpack(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, node *corev1.No
 			PVCName:    utils.PVCName(pvc),
 		}
 		units = append(units, u)
-		break
+		return true, units, nil
 	}
-
-	return true, units, nil
-}
-
-func Spread(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, node *corev1.Node, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (fits bool, units []cache.AllocatedUnit, err error) {
-	if len(cacheVGsMap) == 0 {
-		return false, units, fmt.Errorf("no vg on node %s,", node.Name)
-	}
-	requestedSize := utils.GetPVCRequested(pvc)
-
-	// make a copy slice of cacheVGsMap
-	cacheVGsSlice := make([]cache.SharedResource, len(cacheVGsMap))
-	for _, vg := range cacheVGsMap {
-		cacheVGsSlice = append(cacheVGsSlice, vg)
-	}
-
-	// sort from large to small according to free size
-	sort.Slice(cacheVGsSlice, func(i, j int) bool {
-		return (cacheVGsSlice[i].Capacity - cacheVGsSlice[i].Requested) > (cacheVGsSlice[j].Capacity - cacheVGsSlice[j].Requested)
-	})
-	// the free size of cacheVGsSlice[0] is largest
-	freeSize := cacheVGsSlice[0].Capacity - cacheVGsSlice[0].Requested
-	quanFree := resource.NewQuantity(freeSize, resource.BinarySI)
-	quanReq := resource.NewQuantity(requestedSize, resource.BinarySI)
-	if freeSize < requestedSize {
-		if pod == nil {
-			return false, units, fmt.Errorf("[multipleVGs]not enough lv storage on %s/%s, requested size %s,  free size %s, strategiy %s. you need to expand the vg",
-				node.Name, cacheVGsSlice[0].Name, quanReq.String(), quanFree.String(), localtype.SchedulerStrategy)
-		}
-		return false, units, fmt.Errorf("[multipleVGs]not enough lv storage on %s/%s for pod %s, requested size %s,  free size %s, strategiy %s. you need to expand the vg",
-			node.Name, cacheVGsSlice[0].Name, utils.GetName(pod.ObjectMeta), quanReq.String(), quanFree.String(), localtype.SchedulerStrategy)
-	}
-	cacheVGsSlice[0].Requested += requestedSize
-	u := cache.AllocatedUnit{
-		NodeName:   node.Name,
-		VolumeType: localtype.VolumeTypeLVM,
-		Requested:  requestedSize,
-		Allocated:  requestedSize, // for LVM requested is always equal to allocated
-		VgName:     string(cacheVGsSlice[0].Name),
-		Device:     "",
-		MountPoint: "",
-		PVCName:    utils.PVCName(pvc),
-	}
-	units = append(units, u)
-
-	return true, units, nil
+	klog.V(4).Infof("no vg on node %s fits pvc %s by strategy %s: %s", node.Name, utils.PVCName(pvc), localtype.SchedulerStrategy, err.Error())
+	return false, units, err
 }
 
+// ScoreLVM scores node by vgs of units, which are taken by pod besides
+// requested size of cacheVGsMap
 func ScoreLVM(units []cache.AllocatedUnit, cacheVGsMap map[cache.ResourceName]cache.SharedResource) (score int) {
 	if len(units) == 0 {
 		return MinScore
 	}
-	// make a map store VG size pvcs used
-	// key: VG name
-	// value: used size
-	scoreMap := make(map[string]int64)
+	// vgs taken by units, indexed by name
+	index := make(map[string]int)
+	var vgs []algorithm.VGCapacity
 	for _, unit := range units {
-		if size, ok := scoreMap[unit.VgName]; ok {
-			size += unit.Allocated
-			scoreMap[unit.VgName] = size
-		} else {
-			scoreMap[unit.VgName] = unit.Allocated
-		}
-	}
-
-	// score
-	var scoref float64 = 0
-	count := 0
-	switch localtype.SchedulerStrategy {
-	case localtype.StrategyBinpack:
-		for vg, used := range scoreMap {
-			scoref += float64(used) / float64(cacheVGsMap[cache.ResourceName(vg)].Capacity)
-			count++
-		}
-	case localtype.StrategySpread:
-		for vg, used := range scoreMap {
-			scoref += (1.0 - float64(used)/float64(cacheVGsMap[cache.ResourceName(vg)].Capacity))
-			count++
+		i, ok := index[unit.VgName]
+		if !ok {
+			i = len(vgs)
+			index[unit.VgName] = i
+			vgs = append(vgs, vgCapacity(cacheVGsMap[cache.ResourceName(unit.VgName)]))
 		}
+		vgs[i].Requested += unit.Allocated
 	}
-	score = int(scoref / float64(count) * float64(MaxScore))
-
-	return
+	return algorithm.ScoreVGCapacity(localtype.SchedulerStrategy, vgs)
 }
 
 func ScoreMountPointVolume(
@@ -799,16 +931,7 @@ func ScoreMountPointVolume(
 }
 
 func ScoreMP(units []cache.AllocatedUnit) (score int) {
-	if len(units) == 0 {
-		return MinScore
-	}
-	// score
-	var scoref float64 = 0
-	for _, unit := range units {
-		scoref += float64(unit.Requested) / float64(unit.Allocated)
-	}
-	score = int(scoref / float64(len(units)) * float64(MaxScore))
-	return
+	return scoreExclusiveUnits(units)
 }
 
 func ScoreDeviceVolume(
@@ -837,17 +960,18 @@ func ScoreDeviceVolume(
 }
 
 func ScoreDevice(units []cache.AllocatedUnit) (score int) {
-	if len(units) == 0 {
-		return MinScore
-	}
-	// score
-	var scoref float64 = 0
+	return scoreExclusiveUnits(units)
+}
+
+// scoreExclusiveUnits scores node by mount points or devices of units
+func scoreExclusiveUnits(units []cache.AllocatedUnit) int {
+	requested := make([]int64, 0, len(units))
+	allocated := make([]int64, 0, len(units))
 	for _, unit := range units {
-		scoref += float64(unit.Requested) / float64(unit.Allocated)
+		requested = append(requested, unit.Requested)
+		allocated = append(allocated, unit.Allocated)
 	}
-
-	score = int(scoref / float64(len(units)) * float64(MaxScore))
-	return score
+	return algorithm.ScoreExclusiveCapacity(requested, allocated)
 }
 
 // If there is no readonly snapshot pvc, just return true
@@ -125,6 +125,50 @@ func (c *ClusterNodeCache) Assume(units []AllocatedUnit) (err error) {
 	return err
 }
 
+// Unassume reverts the allocated units which are assumed but will never be
+// provisioned, e.g. pod is rescheduled to another node
+func (c *ClusterNodeCache) Unassume(units []AllocatedUnit) (err error) {
+	for _, u := range units {
+		nodeCache := c.GetNodeCache(u.NodeName)
+		if nodeCache == nil {
+			return fmt.Errorf("node %s not found from cache when unassume", u.NodeName)
+		}
+		if _, ok := nodeCache.PVCRecordsByExtend[u.PVCName]; !ok {
+			continue
+		}
+		switch u.VolumeType {
+		case pkg.VolumeTypeLVM:
+			if vg, ok := nodeCache.VGs[ResourceName(u.VgName)]; ok {
+				vg.Requested -= u.Requested
+				if vg.Requested < 0 {
+					vg.Requested = 0
+				}
+				if vg.LVCount > 0 {
+					vg.LVCount--
+				}
+				nodeCache.VGs[ResourceName(u.VgName)] = vg
+			}
+		case pkg.VolumeTypeDevice:
+			if v, ok := nodeCache.Devices[ResourceName(u.Device)]; ok {
+				v.IsAllocated = false
+				nodeCache.Devices[ResourceName(u.Device)] = v
+			}
+		case pkg.VolumeTypeMountPoint:
+			if v, ok := nodeCache.MountPoints[ResourceName(u.MountPoint)]; ok {
+				v.IsAllocated = false
+				nodeCache.MountPoints[ResourceName(u.MountPoint)] = v
+			}
+		default:
+			return fmt.Errorf("invalid volumeType %s", u.VolumeType)
+		}
+		nodeCache.AllocatedNum -= 1
+		delete(nodeCache.PVCRecordsByExtend, u.PVCName)
+		log.V(6).Infof("unassume node cache successfully: node = %s, pvc = %s", nodeCache.NodeName, u.PVCName)
+		c.SetNodeCache(nodeCache)
+	}
+	return nil
+}
+
 func (c *ClusterNodeCache) assumeMountPointAllocatedUnit(unit AllocatedUnit, nodeCache *NodeCache) (*NodeCache, error) {
 	nodeCache.AllocatedNum += 1
 
@@ -153,17 +197,21 @@ func (c *ClusterNodeCache) assumeLVMAllocatedUnit(unit AllocatedUnit, nodeCache
 		if vg.Requested+unit.Requested > vg.Capacity {
 			return nil, fmt.Errorf("VG %s resource is not enough, requested = %d, actual left = %d", vg.Name, unit.Requested, vg.Capacity-vg.Requested)
 		}
+		if vg.Maintenance {
+			return nil, fmt.Errorf("VG %s is under maintenance", vg.Name)
+		}
+		if vg.IsLVLimitReached() {
+			return nil, fmt.Errorf("VG %s already has %d logical volumes, reaching the limit %d", vg.Name, vg.LVCount+vg.PreallocatedLVs, vg.LVLimit)
+		}
 	} else {
 		// vg is not found
 		return nil, fmt.Errorf("vg %s/%s is not found in cache, please retry later", nodeCache.NodeName, unit.VgName)
 	}
 	nodeCache.AllocatedNum += 1
 	nodeCache.PVCRecordsByExtend[unit.PVCName] = unit
-	nodeCache.VGs[ResourceName(vg.Name)] = SharedResource{
-		Name:      vg.Name,
-		Capacity:  vg.Capacity,
-		Requested: vg.Requested + unit.Requested,
-	}
+	vg.Requested += unit.Requested
+	vg.LVCount++
+	nodeCache.VGs[ResourceName(vg.Name)] = vg
 	log.V(6).Infof("assume node cache successfully: node = %s, vg = %s", nodeCache.NodeName, vg.Name)
 	c.SetNodeCache(nodeCache)
 	return nodeCache, nil
@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+)
+
+func TestClusterNodeCache_Unassume(t *testing.T) {
+	c := NewClusterNodeCache()
+	c.AddNodeCache(utils.CreateTestNodeLocalStorage2())
+	unit := AllocatedUnit{
+		NodeName:   utils.NodeName2,
+		VolumeType: pkg.VolumeTypeLVM,
+		Requested:  int64(10 * utils.LocalGi),
+		Allocated:  int64(10 * utils.LocalGi),
+		VgName:     utils.VGSSD,
+		PVCName:    "default/pvc-test",
+	}
+	if err := c.Assume([]AllocatedUnit{unit}); err != nil {
+		t.Fatalf("Assume() error = %v", err)
+	}
+	if got := c.GetNodeCache(utils.NodeName2).VGs[ResourceName(utils.VGSSD)].Requested; got != unit.Requested {
+		t.Fatalf("Requested after Assume() = %d, want %d", got, unit.Requested)
+	}
+	if got := c.GetNodeCache(utils.NodeName2).VGs[ResourceName(utils.VGSSD)].LVCount; got != 1 {
+		t.Fatalf("LVCount after Assume() = %d, want 1", got)
+	}
+	// unassume twice should revert only once
+	for i := 0; i < 2; i++ {
+		if err := c.Unassume([]AllocatedUnit{unit}); err != nil {
+			t.Fatalf("Unassume() error = %v", err)
+		}
+	}
+	nc := c.GetNodeCache(utils.NodeName2)
+	if got := nc.VGs[ResourceName(utils.VGSSD)].Requested; got != 0 {
+		t.Errorf("Requested after Unassume() = %d, want 0", got)
+	}
+	if got := nc.VGs[ResourceName(utils.VGSSD)].LVCount; got != 0 {
+		t.Errorf("LVCount after Unassume() = %d, want 0", got)
+	}
+	if _, ok := nc.PVCRecordsByExtend[unit.PVCName]; ok || nc.AllocatedNum != 0 {
+		t.Errorf("pvc %s is still recorded after Unassume(), AllocatedNum = %d", unit.PVCName, nc.AllocatedNum)
+	}
+}
+
+func TestClusterNodeCache_AssumeLVLimit(t *testing.T) {
+	nls := utils.CreateTestNodeLocalStorage2()
+	nls.Spec.ListConfig.VGs.MaxLogicalVolumes = 2
+	c := NewClusterNodeCache()
+	c.AddNodeCache(nls)
+	units := []AllocatedUnit{
+		{NodeName: utils.NodeName2, VolumeType: pkg.VolumeTypeLVM, Requested: int64(utils.LocalGi), VgName: utils.VGSSD, PVCName: "default/pvc-a"},
+		{NodeName: utils.NodeName2, VolumeType: pkg.VolumeTypeLVM, Requested: int64(utils.LocalGi), VgName: utils.VGSSD, PVCName: "default/pvc-b"},
+		{NodeName: utils.NodeName2, VolumeType: pkg.VolumeTypeLVM, Requested: int64(utils.LocalGi), VgName: utils.VGSSD, PVCName: "default/pvc-c"},
+	}
+	if err := c.Assume(units[:2]); err != nil {
+		t.Fatalf("Assume() error = %v", err)
+	}
+	// lvs of assumed pvcs are counted before they are created
+	if err := c.Assume(units[2:]); err == nil {
+		t.Fatalf("Assume() beyond lv limit error = nil")
+	}
+	if err := c.Unassume(units[:1]); err != nil {
+		t.Fatalf("Unassume() error = %v", err)
+	}
+	if err := c.Assume(units[2:]); err != nil {
+		t.Errorf("Assume() after Unassume() error = %v", err)
+	}
+}
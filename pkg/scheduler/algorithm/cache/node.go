@@ -32,12 +32,14 @@ func NewNodeCache(nodeName string) *NodeCache {
 	return &NodeCache{
 		rwLock: sync.RWMutex{},
 		NodeInfo: NodeInfo{
-			NodeName:     nodeName,
-			SupportSPDK:  false,
-			VGs:          make(map[ResourceName]SharedResource),
-			MountPoints:  make(map[ResourceName]ExclusiveResource),
-			Devices:      make(map[ResourceName]ExclusiveResource),
-			AllocatedNum: 0,
+			NodeName:           nodeName,
+			SupportSPDK:        false,
+			VGs:                make(map[ResourceName]SharedResource),
+			MountPoints:        make(map[ResourceName]ExclusiveResource),
+			Devices:            make(map[ResourceName]ExclusiveResource),
+			DeviceTemperatures: make(map[ResourceName]nodelocalstorage.DeviceTemperature),
+			VGIOStats:          make(map[ResourceName]nodelocalstorage.VolumeGroupIOStats),
+			AllocatedNum:       0,
 			// TODO(yuzhi.wx) using pv name may conflict, use pv uid later
 			LocalPVs:            make(map[string]corev1.PersistentVolume),
 			PVCRecordsByExtend:  make(map[string]AllocatedUnit),
@@ -63,7 +65,16 @@ func NewNodeCacheFromStorage(nodeLocal *nodelocalstorage.NodeLocalStorage) *Node
 			vgName, vgInfoMap[vgName].Total, vgInfoMap[vgName].Allocatable, vgInfoMap[vgName].Total-vgInfoMap[vgName].Available, newNodeCache.NodeName)
 		log.V(6).Infof("vg raw info:%#v", vgInfoMap[vgName])
 		log.V(6).Infof("cachedNode.VGs: %#v, is nil %t", newNodeCache.VGs, newNodeCache.VGs == nil)
-		vgResource := SharedResource{vgName, int64(vgInfoMap[vgName].Allocatable), 0}
+		vgResource := SharedResource{
+			Name:            vgName,
+			Capacity:        int64(utils.GetVGAllocatable(nodeLocal, vgInfoMap[vgName])),
+			Requested:       0,
+			LVCount:         0,
+			LVLimit:         int64(nodeLocal.Spec.ListConfig.VGs.MaxLogicalVolumes),
+			PreallocatedLVs: utils.GetPreallocatedLVs(nodeLocal)[vgName].Count,
+			Maintenance:     utils.IsVGInMaintenance(nodeLocal, vgName),
+			Labels:          utils.GetVGLabels(nodeLocal, vgName),
+		}
 		newNodeCache.VGs[ResourceName(vgName)] = vgResource
 		log.V(6).Infof("vgResource: %#v", vgResource)
 	}
@@ -91,6 +102,12 @@ func NewNodeCacheFromStorage(nodeLocal *nodelocalstorage.NodeLocalStorage) *Node
 		newNodeCache.Devices[ResourceName(deviceName)] = diskResource
 		log.V(6).Infof("diskResource: %#v", diskResource)
 	}
+	newNodeCache.DeviceTemperatures = deviceTemperatures(nodeLocal.Status.NodeStorageInfo.DeviceInfos)
+	newNodeCache.WipingDevices = wipingDevices(nodeLocal)
+	newNodeCache.VGIOStats = vgIOStats(nodeLocal)
+	newNodeCache.SnapshotTrends = snapshotTrends(nodeLocal)
+	newNodeCache.StatusDrift = nodeLocal.Status.NodeStorageInfo.StatusDrift.DeepCopy()
+	newNodeCache.Versions = nodeLocal.Status.NodeStorageInfo.Versions.DeepCopy()
 
 	// MountPoint
 	mpInfoMap := make(map[string]nodelocalstorage.MountPoint)
@@ -148,14 +165,35 @@ func (nc *NodeCache) UpdateNodeInfo(nodeLocal *nodelocalstorage.NodeLocalStorage
 		log.V(6).Infof("updatedName raw info:%#v", vgMapInfo[vg])
 		log.V(6).Infof("cachedNode.VGs: %#v, is nil %t", cacheNode.VGs, cacheNode.VGs == nil)
 		vgRequested := utils.GetVGRequested(nc.LocalPVs, vg)
-		vgResource := SharedResource{vg, int64(vgMapInfo[vg].Allocatable), vgRequested}
+		vgResource := SharedResource{
+			Name:            vg,
+			Capacity:        int64(utils.GetVGAllocatable(nodeLocal, vgMapInfo[vg])),
+			Requested:       vgRequested,
+			LVCount:         utils.GetVGLVCount(nc.LocalPVs, vg),
+			PreallocatedLVs: utils.GetPreallocatedLVs(nodeLocal)[vg].Count,
+			LVLimit:         int64(nodeLocal.Spec.ListConfig.VGs.MaxLogicalVolumes),
+			Maintenance:     utils.IsVGInMaintenance(nodeLocal, vg),
+			MetadataFree:    vgMapInfo[vg].MetadataFree,
+			MetadataSize:    vgMapInfo[vg].MetadataSize,
+			MetadataLow:     vgMapInfo[vg].Condition == nodelocalstorage.StorageMetadataLow,
+			Labels:          utils.GetVGLabels(nodeLocal, vg),
+		}
 		cacheNode.VGs[ResourceName(vg)] = vgResource
 		log.V(6).Infof("vgResource: %#v", vgResource)
 	}
 	for _, vg := range unchangedVGs {
 		// update the size if the updatedName got extended
 		v := cacheNode.VGs[ResourceName(vg)]
-		v.Capacity = int64(vgMapInfo[vg].Allocatable)
+		v.Capacity = int64(utils.GetVGAllocatable(nodeLocal, vgMapInfo[vg]))
+		// lv count is kept by pv, inline volume and assume events along with
+		// requested size, lv count reported by agent lags behind them
+		v.LVLimit = int64(nodeLocal.Spec.ListConfig.VGs.MaxLogicalVolumes)
+		v.PreallocatedLVs = utils.GetPreallocatedLVs(nodeLocal)[vg].Count
+		v.Maintenance = utils.IsVGInMaintenance(nodeLocal, vg)
+		v.MetadataFree = vgMapInfo[vg].MetadataFree
+		v.MetadataSize = vgMapInfo[vg].MetadataSize
+		v.MetadataLow = vgMapInfo[vg].Condition == nodelocalstorage.StorageMetadataLow
+		v.Labels = utils.GetVGLabels(nodeLocal, vg)
 		cacheNode.VGs[ResourceName(vg)] = v
 		log.V(6).Infof("updating existing volume group %q(total:%d,allocatable:%d,used:%d) on node cache %s",
 			vg, vgMapInfo[vg].Total, vgMapInfo[vg].Allocatable, vgMapInfo[vg].Total-vgMapInfo[vg].Available, cacheNode.NodeName)
@@ -166,11 +204,12 @@ func (nc *NodeCache) UpdateNodeInfo(nodeLocal *nodelocalstorage.NodeLocalStorage
 	}
 
 	for _, volumes := range nc.PodInlineVolumeInfo {
-		for _, volume := range volumes {
+		for i, volume := range volumes {
 			if !volume.Recorded {
-				volume.Recorded = true
+				volumes[i].Recorded = true
 				vg := nc.VGs[ResourceName(volume.VgName)]
 				vg.Requested += volume.VolumeSize
+				vg.LVCount++
 				nc.VGs[ResourceName(volume.VgName)] = vg
 			}
 		}
@@ -219,6 +258,12 @@ func (nc *NodeCache) UpdateNodeInfo(nodeLocal *nodelocalstorage.NodeLocalStorage
 			log.V(6).Infof("device %q has been deleted from cache", device)
 		}
 	}
+	cacheNode.DeviceTemperatures = deviceTemperatures(devices)
+	cacheNode.WipingDevices = wipingDevices(nodeLocal)
+	cacheNode.VGIOStats = vgIOStats(nodeLocal)
+	cacheNode.SnapshotTrends = snapshotTrends(nodeLocal)
+	cacheNode.StatusDrift = nodeLocal.Status.NodeStorageInfo.StatusDrift.DeepCopy()
+	cacheNode.Versions = nodeLocal.Status.NodeStorageInfo.Versions.DeepCopy()
 
 	// MountPoint
 	// get mountpoint from CR
@@ -275,6 +320,67 @@ func (nc *NodeCache) UpdateNodeInfo(nodeLocal *nodelocalstorage.NodeLocalStorage
 	return cacheNode
 }
 
+// deviceTemperatures returns temperature of devices reporting it
+func deviceTemperatures(devices []nodelocalstorage.DeviceInfo) map[ResourceName]nodelocalstorage.DeviceTemperature {
+	temps := make(map[ResourceName]nodelocalstorage.DeviceTemperature)
+	for _, d := range devices {
+		if d.Temperature != nil {
+			temps[ResourceName(d.Name)] = *d.Temperature
+		}
+	}
+	return temps
+}
+
+// wipingDevices returns filtered devices being wiped
+func wipingDevices(nodeLocal *nodelocalstorage.NodeLocalStorage) map[ResourceName]bool {
+	wiping := make(map[ResourceName]bool)
+	for _, device := range nodeLocal.Status.FilteredStorageInfo.Devices {
+		if utils.IsDeviceWiping(nodeLocal, device) {
+			wiping[ResourceName(device)] = true
+		}
+	}
+	return wiping
+}
+
+// vgIOStats returns io statistics of filtered vgs reporting it
+func vgIOStats(nodeLocal *nodelocalstorage.NodeLocalStorage) map[ResourceName]nodelocalstorage.VolumeGroupIOStats {
+	filtered := make(map[string]bool, len(nodeLocal.Status.FilteredStorageInfo.VolumeGroups))
+	for _, vgName := range nodeLocal.Status.FilteredStorageInfo.VolumeGroups {
+		filtered[vgName] = true
+	}
+	stats := make(map[ResourceName]nodelocalstorage.VolumeGroupIOStats)
+	for _, vg := range nodeLocal.Status.NodeStorageInfo.VolumeGroups {
+		if vg.IOStats != nil && filtered[vg.Name] {
+			stats[ResourceName(vg.Name)] = *vg.IOStats
+		}
+	}
+	return stats
+}
+
+// snapshotTrends collects fill trends of snapshot lvs in filtered vgs
+func snapshotTrends(nodeLocal *nodelocalstorage.NodeLocalStorage) map[ResourceName]map[string]nodelocalstorage.SnapshotUsageTrend {
+	filtered := make(map[string]bool, len(nodeLocal.Status.FilteredStorageInfo.VolumeGroups))
+	for _, vgName := range nodeLocal.Status.FilteredStorageInfo.VolumeGroups {
+		filtered[vgName] = true
+	}
+	trends := make(map[ResourceName]map[string]nodelocalstorage.SnapshotUsageTrend)
+	for _, vg := range nodeLocal.Status.NodeStorageInfo.VolumeGroups {
+		if !filtered[vg.Name] {
+			continue
+		}
+		for _, lv := range vg.LogicalVolumes {
+			if lv.UsageTrend == nil {
+				continue
+			}
+			if trends[ResourceName(vg.Name)] == nil {
+				trends[ResourceName(vg.Name)] = make(map[string]nodelocalstorage.SnapshotUsageTrend)
+			}
+			trends[ResourceName(vg.Name)][lv.Name] = *lv.UsageTrend.DeepCopy()
+		}
+	}
+	return trends
+}
+
 // AddLVM add lvm PV to cache
 // note: this function does not handle pv update event
 func (nc *NodeCache) AddLVM(pv *corev1.PersistentVolume) error {
@@ -298,8 +404,11 @@ func (nc *NodeCache) AddLVM(pv *corev1.PersistentVolume) error {
 			// TODO(huizhi.szh): when informer resync the cache, this function may be called again, this will be a bug,
 			// because it will do it one more time.
 			oldRequest := vg.Requested
-			s := pv.Spec.Capacity[corev1.ResourceStorage]
-			vg.Requested = oldRequest + s.Value()
+			vg.Requested = oldRequest + utils.GetLVMPVAllocatedSize(pv)
+			// lv of assumed pvc is counted when assuming
+			if !nc.IsPVAllocated(pv) {
+				vg.LVCount++
+			}
 			// Added to node cache
 			nc.AllocatedNum += 1
 			nc.VGs[ResourceName(vgName)] = vg
@@ -332,12 +441,13 @@ func (nc *NodeCache) UpdateLVM(old, pv *corev1.PersistentVolume) error {
 		if vg, ok := nc.VGs[ResourceName(vgName)]; ok {
 			// because it is already in cache, we only recalculate vg requested size and PV object
 			oldRequest := vg.Requested
-			newPVsize := pv.Spec.Capacity[corev1.ResourceStorage]
-			oldPVsize := old.Spec.Capacity[corev1.ResourceStorage]
+			newPVsize := utils.GetLVMPVAllocatedSize(pv)
+			oldPVsize := utils.GetLVMPVAllocatedSize(old)
 			if nc.IsPVAllocated(pv) {
-				vg.Requested = oldRequest + newPVsize.Value() - oldPVsize.Value()
+				vg.Requested = oldRequest + newPVsize - oldPVsize
 			} else {
-				vg.Requested = oldRequest + newPVsize.Value()
+				vg.Requested = oldRequest + newPVsize
+				vg.LVCount++
 				nc.AllocatedNum += 1
 			}
 			nc.VGs[ResourceName(vgName)] = vg
@@ -366,8 +476,10 @@ func (nc *NodeCache) RemoveLVM(pv *corev1.PersistentVolume) error {
 	}
 	if vg, ok := nc.VGs[ResourceName(vgName)]; ok {
 		oldUsed := vg.Requested
-		s := pv.Spec.Capacity[corev1.ResourceStorage]
-		vg.Requested = oldUsed - s.Value()
+		vg.Requested = oldUsed - utils.GetLVMPVAllocatedSize(pv)
+		if nc.IsPVAllocated(pv) && vg.LVCount > 0 {
+			vg.LVCount--
+		}
 		nc.AllocatedNum -= 1
 		nc.VGs[ResourceName(vgName)] = vg
 		log.V(6).Infof("[RemoveLVM]removed pv %s: VG info: old size => %d, new size => %d for vg %s ", pv.Name, oldUsed, vg.Requested, vgName)
@@ -508,6 +620,7 @@ func (nc *NodeCache) AddPodInlineVolumeInfo(pod *corev1.Pod) error {
 				// 更新 VGs
 				if vg, exist := nc.VGs[ResourceName(vgName)]; exist {
 					vg.Requested += size
+					vg.LVCount++
 					nc.VGs[ResourceName(vgName)] = vg
 					inlineVolumeInfo.Recorded = true
 				}
@@ -534,6 +647,9 @@ func (nc *NodeCache) DeletePodInlineVolumeInfo(pod *corev1.Pod) error {
 			// 更新 VGs
 			vg := nc.VGs[ResourceName(volume.VgName)]
 			vg.Requested -= volume.VolumeSize
+			if volume.Recorded && vg.LVCount > 0 {
+				vg.LVCount--
+			}
 			nc.VGs[ResourceName(volume.VgName)] = vg
 		}
 	}
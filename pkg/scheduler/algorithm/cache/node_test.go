@@ -0,0 +1,290 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNodeCache_LVLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		lvLimit int
+		// number of existing lvm pvs of vg ssd
+		lvCount int
+		// number of unassigned preallocated lvs of vg ssd
+		preallocated int64
+		want         bool
+	}{
+		{
+			name:    "test no lv limit",
+			lvLimit: 0,
+			lvCount: 5,
+			want:    false,
+		},
+		{
+			name:    "test lv count below limit",
+			lvLimit: 3,
+			lvCount: 2,
+			want:    false,
+		},
+		{
+			name:    "test lv count reaches limit",
+			lvLimit: 3,
+			lvCount: 3,
+			want:    true,
+		},
+		{
+			name:         "test preallocated lvs reach limit",
+			lvLimit:      3,
+			lvCount:      1,
+			preallocated: 2,
+			want:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := utils.CreateTestNodeLocalStorage2()
+			nls.Spec.ListConfig.VGs.MaxLogicalVolumes = tt.lvLimit
+			nc := NewNodeCacheFromStorage(nls)
+			for i := 0; i < tt.lvCount; i++ {
+				pv := utils.CreateTestPersistentVolume([]utils.TestPVInfo{{
+					VolumeName: fmt.Sprintf("pv-%d", i),
+					VolumeSize: "1Gi",
+					VolumeType: string(localtype.VolumeTypeLVM),
+					VgName:     utils.VGSSD,
+					NodeName:   utils.NodeName2,
+					PVStatus:   corev1.VolumeBound,
+					IsLocalPV:  true,
+				}})[0]
+				if err := nc.AddLVM(pv); err != nil {
+					t.Fatalf("AddLVM() error = %v", err)
+				}
+			}
+			// lv count reported by agent lags behind pvs and is not counted
+			updated := nls.DeepCopy()
+			for i := range updated.Status.NodeStorageInfo.VolumeGroups {
+				updated.Status.NodeStorageInfo.VolumeGroups[i].LogicalVolumeCount = 100
+			}
+			utils.SetPreallocatedLVs(updated, utils.VGSSD, utils.PreallocatedLVs{Count: tt.preallocated, Size: tt.preallocated * 1024})
+			nc = nc.UpdateNodeInfo(updated)
+			vg, ok := nc.VGs[ResourceName(utils.VGSSD)]
+			if !ok {
+				t.Fatalf("vg %s not found in node cache", utils.VGSSD)
+			}
+			if vg.LVCount != int64(tt.lvCount) {
+				t.Errorf("LVCount = %d, want %d", vg.LVCount, tt.lvCount)
+			}
+			if got := vg.IsLVLimitReached(); got != tt.want {
+				t.Errorf("IsLVLimitReached() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeCache_Maintenance(t *testing.T) {
+	tests := []struct {
+		name        string
+		maintenance []string
+		want        map[string]bool
+	}{
+		{
+			name:        "test no vg under maintenance",
+			maintenance: nil,
+			want:        map[string]bool{utils.VGSSD: false, utils.VGHDD: false},
+		},
+		{
+			name:        "test vg under maintenance",
+			maintenance: []string{utils.VGSSD},
+			want:        map[string]bool{utils.VGSSD: true, utils.VGHDD: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := utils.CreateTestNodeLocalStorage2()
+			nls.Spec.ListConfig.VGs.Maintenance = tt.maintenance
+			nodeCaches := map[string]*NodeCache{
+				"new":    NewNodeCacheFromStorage(nls),
+				"update": NewNodeCacheFromStorage(utils.CreateTestNodeLocalStorage2()).UpdateNodeInfo(nls),
+			}
+			for kind, nc := range nodeCaches {
+				for vgName, want := range tt.want {
+					vg, ok := nc.VGs[ResourceName(vgName)]
+					if !ok {
+						t.Fatalf("%s: vg %s not found in node cache", kind, vgName)
+					}
+					if vg.Maintenance != want {
+						t.Errorf("%s: maintenance of vg %s = %v, want %v", kind, vgName, vg.Maintenance, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNodeCache_MinFreeSize(t *testing.T) {
+	base := utils.CreateTestNodeLocalStorage2()
+	var vgInfo localv1alpha1.VolumeGroup
+	for _, vg := range base.Status.NodeStorageInfo.VolumeGroups {
+		if vg.Name == utils.VGSSD {
+			vgInfo = vg
+		}
+	}
+	tests := []struct {
+		name        string
+		minFreeSize string
+		want        int64
+	}{
+		{
+			name: "test no floor",
+			want: int64(vgInfo.Allocatable),
+		},
+		{
+			name:        "test absolute floor",
+			minFreeSize: "10Gi",
+			want:        int64(vgInfo.Allocatable) - 10*1024*1024*1024,
+		},
+		{
+			name:        "test percentage floor",
+			minFreeSize: "10%",
+			want:        int64(vgInfo.Allocatable - vgInfo.Total/10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := utils.CreateTestNodeLocalStorage2()
+			nls.Spec.ListConfig.VGs.MinFreeSize = tt.minFreeSize
+			nodeCaches := map[string]*NodeCache{
+				"new":    NewNodeCacheFromStorage(nls),
+				"update": NewNodeCacheFromStorage(utils.CreateTestNodeLocalStorage2()).UpdateNodeInfo(nls),
+			}
+			for kind, nc := range nodeCaches {
+				vg, ok := nc.VGs[ResourceName(utils.VGSSD)]
+				if !ok {
+					t.Fatalf("%s: vg %s not found in node cache", kind, utils.VGSSD)
+				}
+				if vg.Capacity != tt.want {
+					t.Errorf("%s: capacity of vg %s = %d, want %d", kind, utils.VGSSD, vg.Capacity, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeCache_DeviceTemperatures(t *testing.T) {
+	nls := utils.CreateTestNodeLocalStorage2()
+	// only /dev/sda reports temperature
+	nls.Status.NodeStorageInfo.DeviceInfos[0].Temperature = &localv1alpha1.DeviceTemperature{Current: 45, Critical: 70}
+	want := map[ResourceName]localv1alpha1.DeviceTemperature{
+		ResourceName(nls.Status.NodeStorageInfo.DeviceInfos[0].Name): {Current: 45, Critical: 70},
+	}
+	nodeCaches := map[string]*NodeCache{
+		"new":    NewNodeCacheFromStorage(nls),
+		"update": NewNodeCacheFromStorage(utils.CreateTestNodeLocalStorage2()).UpdateNodeInfo(nls),
+	}
+	for kind, nc := range nodeCaches {
+		if !reflect.DeepEqual(nc.DeviceTemperatures, want) {
+			t.Errorf("%s: DeviceTemperatures = %v, want %v", kind, nc.DeviceTemperatures, want)
+		}
+	}
+}
+
+func TestNodeCache_WipingDevices(t *testing.T) {
+	released := utils.CreateTestNodeLocalStorage2()
+	released.Status.FilteredStorageInfo.Devices = []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}
+	// /dev/sdb is released before agent reports it, /dev/sdc is reported
+	// wiping by agent and /dev/sdd is not filtered
+	released.Annotations = map[string]string{localtype.AnnoWipingDevices: "/dev/sdb,/dev/sdd"}
+	released.Status.NodeStorageInfo.DeviceInfos[2].Condition = localv1alpha1.StorageWiping
+	wiped := released.DeepCopy()
+	wiped.Annotations = nil
+	wiped.Status.NodeStorageInfo.DeviceInfos[2].Condition = localv1alpha1.StorageReady
+
+	tests := []struct {
+		name string
+		nls  *localv1alpha1.NodeLocalStorage
+		want map[ResourceName]bool
+	}{
+		{
+			name: "test wiping",
+			nls:  released,
+			want: map[ResourceName]bool{"/dev/sdb": true, "/dev/sdc": true},
+		},
+		{
+			name: "test wiping verified",
+			nls:  wiped,
+			want: map[ResourceName]bool{},
+		},
+	}
+	for _, tt := range tests {
+		nodeCaches := map[string]*NodeCache{
+			"new":    NewNodeCacheFromStorage(tt.nls),
+			"update": NewNodeCacheFromStorage(released).UpdateNodeInfo(tt.nls),
+		}
+		for kind, nc := range nodeCaches {
+			if !reflect.DeepEqual(nc.WipingDevices, tt.want) {
+				t.Errorf("%s %s: WipingDevices = %v, want %v", tt.name, kind, nc.WipingDevices, tt.want)
+			}
+		}
+	}
+}
+
+func TestNodeCache_VGIOStats(t *testing.T) {
+	nls := utils.CreateTestNodeLocalStorage2()
+	// only ssd vg reports io statistics
+	nls.Status.NodeStorageInfo.VolumeGroups[0].IOStats = &localv1alpha1.VolumeGroupIOStats{ReadsCompleted: 10, WriteBytes: 4096}
+	want := map[ResourceName]localv1alpha1.VolumeGroupIOStats{
+		ResourceName(nls.Status.NodeStorageInfo.VolumeGroups[0].Name): {ReadsCompleted: 10, WriteBytes: 4096},
+	}
+	nodeCaches := map[string]*NodeCache{
+		"new":    NewNodeCacheFromStorage(nls),
+		"update": NewNodeCacheFromStorage(utils.CreateTestNodeLocalStorage2()).UpdateNodeInfo(nls),
+	}
+	for kind, nc := range nodeCaches {
+		if !reflect.DeepEqual(nc.VGIOStats, want) {
+			t.Errorf("%s: VGIOStats = %v, want %v", kind, nc.VGIOStats, want)
+		}
+	}
+}
+
+func TestNodeCache_SnapshotTrends(t *testing.T) {
+	nls := utils.CreateTestNodeLocalStorage2()
+	vgName := nls.Status.NodeStorageInfo.VolumeGroups[0].Name
+	nls.Status.NodeStorageInfo.VolumeGroups[0].LogicalVolumes = []localv1alpha1.LogicalVolume{
+		{Name: "local-a", VGName: vgName},
+		{Name: "snap-a", VGName: vgName, Origin: "local-a", UsageTrend: &localv1alpha1.SnapshotUsageTrend{Samples: 2, Used: 512, FillRate: 8}},
+	}
+	want := map[ResourceName]map[string]localv1alpha1.SnapshotUsageTrend{
+		ResourceName(vgName): {"snap-a": {Samples: 2, Used: 512, FillRate: 8}},
+	}
+	nodeCaches := map[string]*NodeCache{
+		"new":    NewNodeCacheFromStorage(nls),
+		"update": NewNodeCacheFromStorage(utils.CreateTestNodeLocalStorage2()).UpdateNodeInfo(nls),
+	}
+	for kind, nc := range nodeCaches {
+		if !reflect.DeepEqual(nc.SnapshotTrends, want) {
+			t.Errorf("%s: SnapshotTrends = %v, want %v", kind, nc.SnapshotTrends, want)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"sync"
 
 	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	log "k8s.io/klog/v2"
@@ -42,7 +43,20 @@ type NodeInfo struct {
 	VGs         map[ResourceName]SharedResource
 	MountPoints map[ResourceName]ExclusiveResource
 	// Devices only contains the whitelist raw devices
-	Devices             map[ResourceName]ExclusiveResource
+	Devices map[ResourceName]ExclusiveResource
+	// DeviceTemperatures contains all raw devices reporting temperature
+	DeviceTemperatures map[ResourceName]nodelocalstorage.DeviceTemperature
+	// WipingDevices contains raw devices being wiped, which are not free
+	// even if no PV uses them
+	WipingDevices map[ResourceName]bool
+	// VGIOStats contains all vgs reporting io statistics
+	VGIOStats map[ResourceName]nodelocalstorage.VolumeGroupIOStats
+	// SnapshotTrends contains fill trends of snapshot lvs by vg and lv name
+	SnapshotTrends map[ResourceName]map[string]nodelocalstorage.SnapshotUsageTrend
+	// StatusDrift is the result of the last status drift check of agent, nil if never checked
+	StatusDrift *nodelocalstorage.StatusDriftStatus
+	// Versions is the versions of storage stack reported by agent
+	Versions            *nodelocalstorage.StorageVersions
 	AllocatedNum        int64
 	LocalPVs            map[string]corev1.PersistentVolume
 	PodInlineVolumeInfo map[string][]InlineVolumeInfo
@@ -99,6 +113,31 @@ type SharedResource struct {
 	Name      string `json:"name"`
 	Capacity  int64  `json:"capacity,string"`
 	Requested int64  `json:"requested,string"`
+	// LVCount is the number of open-local lv in VG
+	LVCount int64 `json:"lvCount,string"`
+	// PreallocatedLVs is the number of unassigned preallocated lv in VG
+	PreallocatedLVs int64 `json:"preallocatedLVs,string"`
+	// LVLimit is the maximum number of open-local lv in VG, 0 means unlimited
+	LVLimit int64 `json:"lvLimit,string"`
+	// Maintenance is true if VG is under maintenance
+	Maintenance bool `json:"maintenance,string"`
+	// MetadataFree and MetadataSize are usage of VG metadata area, 0 size means unknown
+	MetadataFree uint64 `json:"metadataFree,string"`
+	MetadataSize uint64 `json:"metadataSize,string"`
+	// MetadataLow is true if VG metadata area is reported nearly full
+	MetadataLow bool `json:"metadataLow,string"`
+	// Labels are labels of VG in nls spec, matched by vg selector of storage class
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// IsLVLimitReached returns true if no more lv can be created in VG
+func (r SharedResource) IsLVLimitReached() bool {
+	return utils.IsVGLVLimitReached(r.LVCount+r.PreallocatedLVs, r.LVLimit)
+}
+
+// IsMetadataExhausted returns true if VG metadata area has no room for a new lv
+func (r SharedResource) IsMetadataExhausted() bool {
+	return utils.IsVGMetadataExhausted(r.MetadataFree, r.MetadataSize)
 }
 
 type AllocatedUnit struct {
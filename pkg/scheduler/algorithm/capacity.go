@@ -0,0 +1,148 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
+	"github.com/alibaba/open-local/pkg/utils"
+)
+
+// VGCapacity is the state of vg deciding whether a new lv fits in it. Cache
+// of scheduler extender and of scheduling framework plugin convert their vg
+// to it, so that capacity predicate and score of both entry points are the same
+type VGCapacity struct {
+	Name string
+	// Allocatable is the size of vg available to open-local
+	Allocatable int64
+	// Requested is the size taken by lvs, including lvs being allocated
+	Requested       int64
+	LVCount         int64
+	PreallocatedLVs int64
+	// LVLimit is the maximum number of open-local lv in vg, 0 means unlimited
+	LVLimit      int64
+	Maintenance  bool
+	MetadataFree uint64
+	MetadataSize uint64
+}
+
+// CheckVGForNewLV returns a PredicateError if no new lv can be created in vg
+// whatever its size, which is vg under maintenance, with full metadata area
+// or holding as many lvs as the limit
+func CheckVGForNewLV(vg VGCapacity, nodeName string) error {
+	if vg.Maintenance {
+		return errors.NewVGInMaintenanceError(vg.Name, nodeName)
+	}
+	if utils.IsVGMetadataExhausted(vg.MetadataFree, vg.MetadataSize) {
+		return errors.NewVGMetadataExhaustedError(vg.MetadataFree, vg.MetadataSize, vg.Name, nodeName)
+	}
+	if utils.IsVGLVLimitReached(vg.LVCount+vg.PreallocatedLVs, vg.LVLimit) {
+		return errors.NewInsufficientLVCountError(vg.LVCount+vg.PreallocatedLVs, vg.LVLimit, vg.Name, nodeName)
+	}
+	return nil
+}
+
+// CheckVGFitsLV returns a PredicateError if a new lv of size does not fit in
+// vg, checking free size of vg first
+func CheckVGFitsLV(vg VGCapacity, size int64, nodeName string) error {
+	if vg.Allocatable-vg.Requested < size {
+		return errors.NewInsufficientLVMError(size, vg.Requested, vg.Allocatable, vg.Name, nodeName)
+	}
+	return CheckVGForNewLV(vg, nodeName)
+}
+
+// CheckExclusiveResourceCount returns a PredicateError if free mount points
+// or devices of media type are fewer than volumes requesting them
+func CheckExclusiveResourceCount(volumeType localtype.VolumeType, mediaType localtype.MediaType, requested, free, total int64, nodeName string) error {
+	if free >= requested {
+		return nil
+	}
+	if volumeType == localtype.VolumeTypeMountPoint {
+		return errors.NewInsufficientMountPointCountError(requested, free, total, mediaType, nodeName)
+	}
+	return errors.NewInsufficientDeviceCountError(requested, free, total, mediaType, nodeName)
+}
+
+// FitExclusiveResources assigns mount points or devices, which are taken by a
+// volume as a whole, to requested sizes of volumes. Both requests and
+// capacities are sorted from small to large by caller, so every volume takes
+// the smallest resource it fits in. It returns index in capacities of resource
+// assigned to every request, or a PredicateError if any request is left
+func FitExclusiveResources(volumeType localtype.VolumeType, requests, capacities []int64) ([]int, error) {
+	assigned := make([]int, 0, len(requests))
+	for i, capacity := range capacities {
+		if len(assigned) == len(requests) {
+			break
+		}
+		if capacity < requests[len(assigned)] {
+			continue
+		}
+		assigned = append(assigned, i)
+	}
+	if len(assigned) < len(requests) {
+		// requests are sorted, so the one left is the largest so far
+		requested := requests[len(assigned)]
+		return nil, errors.NewInsufficientExclusiveResourceError(volumeType, requested, requested)
+	}
+	return assigned, nil
+}
+
+// ScoreVGCapacity returns capacity score of node by vgs taking lvs of pod,
+// whose Requested counts the lvs. Binpack prefers vgs left fuller and spread
+// prefers vgs left emptier, any other strategy is taken as binpack
+func ScoreVGCapacity(strategy localtype.StrategyType, vgs []VGCapacity) int {
+	if len(vgs) == 0 {
+		return utils.MinScore
+	}
+	var scoref float64 = 0
+	for _, vg := range vgs {
+		used := float64(vg.Requested) / float64(vg.Allocatable)
+		if strategy == localtype.StrategySpread {
+			used = 1.0 - used
+		}
+		scoref += used
+	}
+	return int(scoref / float64(len(vgs)) * float64(utils.MaxScore))
+}
+
+// ScoreExclusiveCapacity returns capacity score of node by mount points or
+// devices taken by volumes of pod, which prefers resources wasting less of
+// their allocated size than requested
+func ScoreExclusiveCapacity(requested, allocated []int64) int {
+	if len(requested) == 0 {
+		return utils.MinScore
+	}
+	var scoref float64 = 0
+	for i := range requested {
+		scoref += float64(requested[i]) / float64(allocated[i])
+	}
+	return int(scoref / float64(len(requested)) * float64(utils.MaxScore))
+}
+
+// ScoreExclusiveCount returns count score of node by number of mount points
+// or devices requested by pod out of free ones before allocation. Binpack
+// prefers node left with fewer free ones and spread prefers the opposite, any
+// other strategy is taken as binpack
+func ScoreExclusiveCount(strategy localtype.StrategyType, requested, free int) int {
+	if requested <= 0 || free <= 0 {
+		return utils.MinScore
+	}
+	if strategy == localtype.StrategySpread {
+		return int((1.0 - float64(requested)/float64(free)) * float64(utils.MaxScore))
+	}
+	return int(float64(requested) * float64(utils.MaxScore) / float64(free))
+}
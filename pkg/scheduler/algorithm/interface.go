@@ -18,6 +18,7 @@ package algorithm
 
 import (
 	"sync"
+	"time"
 
 	"github.com/alibaba/open-local/pkg"
 
@@ -53,6 +54,11 @@ type SchedulingContext struct {
 	LocalStorageInformer   nodelocalstorageinformer.Interface
 	SnapshotInformers      volumesnapshotinformers.Interface
 	NodeAntiAffinityWeight *pkg.NodeAntiAffinityWeight
+	// NodeStorageStalenessWindow is the duration after which nls not refreshed
+	// by discovery accepts no new volume, 0 means disabled
+	NodeStorageStalenessWindow time.Duration
+	// ReplicaAffinity boosts nodes hosting other replicas of the workload
+	ReplicaAffinity pkg.ReplicaAffinity
 }
 
 func NewSchedulingContext(
@@ -0,0 +1,86 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	localstoragelisters "github.com/alibaba/open-local/pkg/generated/listers/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
+	"github.com/alibaba/open-local/pkg/utils"
+)
+
+// NodeFilter is the checks of node before capacity shared by predicate of
+// scheduler extender and Filter of scheduling framework plugin, so that both
+// entry points reject the same node for the same reason
+type NodeFilter struct {
+	NLSLister localstoragelisters.NodeLocalStorageLister
+	SCLister  storagelisters.StorageClassLister
+	// StalenessWindow is the duration after which nls not refreshed by
+	// discovery accepts no new volume, 0 means disabled
+	StalenessWindow time.Duration
+}
+
+// NewNodeFilter returns NodeFilter of scheduling context of extender
+func NewNodeFilter(ctx *SchedulingContext) *NodeFilter {
+	return &NodeFilter{
+		NLSLister:       ctx.LocalStorageInformer.NodeLocalStorages().Lister(),
+		SCLister:        ctx.StorageV1Informers.StorageClasses().Lister(),
+		StalenessWindow: ctx.NodeStorageStalenessWindow,
+	}
+}
+
+// Filter returns a PredicateError if node does not fit pod requiring local
+// volumes, pvcs are pending local pvcs of pod. Checks are done in order of
+// freshness of nls, fault domain and storage classes reported by agent, the
+// first failure is returned. Any other error aborts scheduling
+func (f *NodeFilter) Filter(node *corev1.Node, pvcs []*corev1.PersistentVolumeClaim, now time.Time) error {
+	nls, err := f.NLSLister.Get(node.Name)
+	if err != nil {
+		// node without nls is handled by capacity check
+		nls = nil
+	}
+	if err := CheckNodeStorageFreshness(nls, f.StalenessWindow, now); err != nil {
+		return err
+	}
+	if err := CheckNodeDomain(node, pvcs, f.SCLister); err != nil {
+		return err
+	}
+	return CheckNodeStorageClasses(nls, pvcs)
+}
+
+// CheckNodeStorageFreshness returns a PredicateError if status of nls is not
+// refreshed by discovery within window
+func CheckNodeStorageFreshness(nls *localv1alpha1.NodeLocalStorage, window time.Duration, now time.Time) error {
+	if !utils.IsNodeStorageStale(nls, window, now) {
+		return nil
+	}
+	return errors.NewNodeStorageStaleError(nls.Name, nls.Status.NodeStorageInfo.State.LastHeartbeatTime.Time, window)
+}
+
+// ScoreNodeWithoutLocalVolume returns score of node for pod requiring no
+// local volume, which prefers node without open-local storage
+func ScoreNodeWithoutLocalVolume(isLocalNode bool) int {
+	if isLocalNode {
+		return utils.MinScore
+	}
+	return utils.MaxScore
+}
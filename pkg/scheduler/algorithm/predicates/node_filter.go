@@ -0,0 +1,44 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package predicates
+
+import (
+	"time"
+
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeFilterPredicate filters out the node whose storage status is stale, not
+// in fault domain or reporting storage class unserviceable for pending local
+// pvcs of pod, by the same checks as Filter of scheduling framework plugin
+func NodeFilterPredicate(ctx *algorithm.SchedulingContext, pod *corev1.Pod, node *corev1.Node) (bool, error) {
+	err, lvmPVCs, mpPVCs, devicePVCs := algorithm.GetPodPvcs(pod, ctx, true)
+	if err != nil {
+		return false, err
+	}
+	pvcs := append(append(append([]*corev1.PersistentVolumeClaim{}, lvmPVCs...), mpPVCs...), devicePVCs...)
+	containInlineVolume, _ := utils.ContainInlineVolumes(pod)
+	if len(pvcs) == 0 && !containInlineVolume {
+		return true, nil
+	}
+	if err := algorithm.NewNodeFilter(ctx).Filter(node, pvcs, time.Now()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
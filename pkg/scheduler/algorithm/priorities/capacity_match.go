@@ -41,12 +41,9 @@ func CapacityMatch(ctx *algorithm.SchedulingContext, pod *corev1.Pod, node *core
 	// if pod has no open-local pvc, it should be scheduled to non Open-Local nodes
 	if len(lvmPVCs) <= 0 && len(mpPVCs) <= 0 && len(devicePVCs) <= 0 && !containInlineVolume {
 		log.Infof("no open-local volume request on pod %s, skipped", pod.Name)
-		if algorithm.IsLocalNode(node.Name, ctx) {
-			log.Infof("node %s is open-local node, so pod %s gets minimal score %d", node.Name, pod.Name, utils.MinScore)
-			return utils.MinScore, nil
-		}
-		log.Infof("node %s is not open-local node, so pod %s gets max score %d", node.Name, pod.Name, utils.MaxScore)
-		return utils.MaxScore, nil
+		score := algorithm.ScoreNodeWithoutLocalVolume(algorithm.IsLocalNode(node.Name, ctx))
+		log.Infof("pod %s gets score %d on node %s", pod.Name, score, node.Name)
+		return score, nil
 	}
 
 	trace.Step("Computing ScoreLVMVolume")
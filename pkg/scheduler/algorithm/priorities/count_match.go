@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"time"
 
+	localtype "github.com/alibaba/open-local/pkg"
 	"github.com/alibaba/open-local/pkg/utils"
 
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
@@ -42,44 +43,43 @@ func CountMatch(ctx *algorithm.SchedulingContext, pod *corev1.Pod, node *corev1.
 	if nc == nil {
 		return 0, fmt.Errorf("failed to get node cache by name %s", node.Name)
 	}
-	var scoreMP, scoreDevice int
-	freeMPCount, err := freeMountPoints(nc)
-	if len(mpPVCs) > 0 && freeMPCount > 0 {
-		if err != nil {
-			return 0, err
-		}
-		scoreMP = int(float64(len(mpPVCs)) * float64(utils.MaxScore) / float64(freeMPCount))
+	// score is averaged over kinds of resource requested by pod
+	var score, kinds int
+	if len(mpPVCs) > 0 {
+		scoreMP := algorithm.ScoreExclusiveCount(localtype.SchedulerStrategy, len(mpPVCs), freeMountPoints(nc))
 		log.Infof("[CountMatch]node %s got %d out of %d", node.Name, scoreMP, utils.MaxScore)
+		score += scoreMP
+		kinds++
 	}
-	freeDeviceCount, err := freeDevices(nc)
-	if len(devicePVCs) > 0 && freeDeviceCount > 0 {
-		if err != nil {
-			return 0, err
-		}
-		scoreDevice = int(float64(len(devicePVCs)) * float64(utils.MaxScore) / float64(freeDeviceCount))
+	if len(devicePVCs) > 0 {
+		scoreDevice := algorithm.ScoreExclusiveCount(localtype.SchedulerStrategy, len(devicePVCs), freeDevices(nc))
 		log.Infof("[CountMatch]node %s got %d out of %d", node.Name, scoreDevice, utils.MaxScore)
+		score += scoreDevice
+		kinds++
+	}
+	if kinds == 0 {
+		return utils.MinScore, nil
 	}
-	return (scoreMP + scoreDevice) / 2.0, nil
+	return score / kinds, nil
 }
 
-func freeMountPoints(nc *cache.NodeCache) (int, error) {
-
-	freeMPs := make([]cache.ExclusiveResource, 0)
+func freeMountPoints(nc *cache.NodeCache) int {
+	free := 0
 	for _, mp := range nc.MountPoints {
 		if !mp.IsAllocated {
-			freeMPs = append(freeMPs, mp)
+			free++
 		}
 	}
-	return len(freeMPs), nil
+	return free
 }
 
-func freeDevices(nc *cache.NodeCache) (int, error) {
-	freeDevices := make([]cache.ExclusiveResource, 0)
-	for _, device := range nc.Devices {
-		if !device.IsAllocated {
-			freeDevices = append(freeDevices, device)
+// freeDevices returns number of devices neither allocated nor being wiped
+func freeDevices(nc *cache.NodeCache) int {
+	free := 0
+	for name, device := range nc.Devices {
+		if !device.IsAllocated && !nc.WipingDevices[name] {
+			free++
 		}
 	}
-	return len(freeDevices), nil
-
+	return free
 }
@@ -45,14 +45,8 @@ func NodeAntiAffinity(ctx *algorithm.SchedulingContext, pod *corev1.Pod, node *c
 
 	// currently ,we only take FREE mount point and devices into account
 	isLocal := algorithm.IsLocalNode(node.Name, ctx)
-	freeMPCount, err := freeMountPoints(nc)
-	if err != nil {
-		return 0, err
-	}
-	freeDeviceCount, err := freeDevices(nc)
-	if err != nil {
-		return 0, err
-	}
+	freeMPCount := freeMountPoints(nc)
+	freeDeviceCount := freeDevices(nc)
 	volumeTypeAntiFound := make(map[pkg.VolumeType]bool)
 	for volumeType, weight := range ctx.NodeAntiAffinityWeight.Items(false) {
 		if weight <= 0 {
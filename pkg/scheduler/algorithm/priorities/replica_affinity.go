@@ -0,0 +1,53 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorities
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	log "k8s.io/klog/v2"
+	utiltrace "k8s.io/utils/trace"
+)
+
+// ReplicaAffinity prefers the node already running other replicas of the workload of pod,
+// it only scores nodes passing predicates so capacity is never overridden
+func ReplicaAffinity(ctx *algorithm.SchedulingContext, pod *corev1.Pod, node *corev1.Node) (int, error) {
+	if !ctx.ReplicaAffinity.Enabled() {
+		return utils.MinScore, nil
+	}
+	trace := utiltrace.New(fmt.Sprintf("Scheduling[ReplicaAffinity] %s", utils.GetName(pod.ObjectMeta)))
+	defer trace.LogIfLong(50 * time.Millisecond)
+	err, lvmPVCs, mpPVCs, devicePVCs := algorithm.GetPodPvcs(pod, ctx, true)
+	if err != nil {
+		return utils.MinScore, err
+	}
+	if len(lvmPVCs)+len(mpPVCs)+len(devicePVCs) <= 0 {
+		return utils.MinScore, nil
+	}
+	pods, err := ctx.CoreV1Informers.Pods().Lister().Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return utils.MinScore, err
+	}
+	score := utils.ReplicaAffinityScore(ctx.ReplicaAffinity, pod, pods, node.Name)
+	log.Infof("[ReplicaAffinity]node %s got %d out of %d", node.Name, score, utils.MaxScore)
+	return score, nil
+}
@@ -19,14 +19,18 @@ package algorithm
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	storagev1informers "k8s.io/client-go/informers/storage/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	storagelisters "k8s.io/client-go/listers/storage/v1"
 
 	"github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm/cache"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
 	"github.com/alibaba/open-local/pkg/utils"
 	log "k8s.io/klog/v2"
 )
@@ -45,18 +49,26 @@ func GetPodUnboundPvcs(pvc *corev1.PersistentVolumeClaim, ctx *SchedulingContext
 	lvmPVCs []*corev1.PersistentVolumeClaim,
 	mpPVCs []*corev1.PersistentVolumeClaim,
 	devicePVCs []*corev1.PersistentVolumeClaim) {
+	pod, err := GetPodOfPVC(pvc, ctx)
+	if err != nil {
+		return
+	}
+	return GetPodPvcs(pod, ctx, true)
+}
+
+// GetPodOfPVC returns the pod using pvc recorded in PvcPod mapping
+func GetPodOfPVC(pvc *corev1.PersistentVolumeClaim, ctx *SchedulingContext) (*corev1.Pod, error) {
 	pvcName := utils.PVCName(pvc)
 	podName := ctx.ClusterNodeCache.PvcMapping.PvcPod[pvcName]
 	if podName == "" {
-		return fmt.Errorf("pod associated with pvc %s is not yet in PvcPod mapping", pvcName), lvmPVCs, mpPVCs, devicePVCs
+		return nil, fmt.Errorf("pod associated with pvc %s is not yet in PvcPod mapping", pvcName)
 	}
-	var pod *corev1.Pod
-	pod, err = ctx.CoreV1Informers.Pods().Lister().Pods(strings.Split(podName, "/")[0]).Get(strings.Split(podName, "/")[1])
+	pod, err := ctx.CoreV1Informers.Pods().Lister().Pods(strings.Split(podName, "/")[0]).Get(strings.Split(podName, "/")[1])
 	if err != nil {
 		log.Errorf("failed to get pod by name %s: %s", podName, err.Error())
-		return
+		return nil, err
 	}
-	return GetPodPvcs(pod, ctx, true)
+	return pod, nil
 }
 
 func GetAllPodPvcs(pod *corev1.Pod, ctx *SchedulingContext) ([]*corev1.PersistentVolumeClaim, error) {
@@ -136,6 +148,53 @@ func IsLocalNode(nodeName string, ctx *SchedulingContext) bool {
 	return false
 }
 
+// CheckNodeStorageFresh returns a PredicateError if status of nls on node is
+// stale, so that no volume is provisioned against out of date capacity
+func CheckNodeStorageFresh(nodeName string, ctx *SchedulingContext) error {
+	if ctx.NodeStorageStalenessWindow <= 0 {
+		return nil
+	}
+	nls, err := ctx.LocalStorageInformer.NodeLocalStorages().Lister().Get(nodeName)
+	if err != nil {
+		// node without nls is handled by capacity check
+		return nil
+	}
+	return CheckNodeStorageFreshness(nls, ctx.NodeStorageStalenessWindow, time.Now())
+}
+
+// CheckNodeDomain returns a PredicateError if node is not in fault domain
+// required by any of pvcs, which is checked before capacity
+func CheckNodeDomain(node *corev1.Node, pvcs []*corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) error {
+	for _, pvc := range pvcs {
+		selector, err := utils.GetDomainSelectorFromPVC(pvc, scLister)
+		if err != nil {
+			return err
+		}
+		if selector.Empty() || selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		return errors.NewNodeDomainMismatchError(node.Name, utils.PVCName(pvc), selector.String(), utils.NodeDomain(node, selector))
+	}
+	return nil
+}
+
+// CheckNodeStorageClasses returns a PredicateError if storage class of any of
+// pvcs is reported unserviceable by agent of node, which is checked before
+// capacity. Storage class not matched by agent yet is left to capacity check
+func CheckNodeStorageClasses(nls *localv1alpha1.NodeLocalStorage, pvcs []*corev1.PersistentVolumeClaim) error {
+	if nls == nil || nls.Status.NodeStorageInfo.StorageClasses == nil {
+		return nil
+	}
+	unserviceable := nls.Status.NodeStorageInfo.StorageClasses.Unserviceable
+	for _, pvc := range pvcs {
+		if pvc.Spec.StorageClassName == nil || !utils.ContainsString(unserviceable, *pvc.Spec.StorageClassName) {
+			continue
+		}
+		return errors.NewStorageClassUnserviceableError(nls.Name, utils.PVCName(pvc), *pvc.Spec.StorageClassName)
+	}
+	return nil
+}
+
 func ExtractPVCKey(pv *corev1.PersistentVolume) (string, error) {
 	if pv.Spec.ClaimRef == nil {
 		return "", fmt.Errorf("nil ClaimRef for pv %s", pv.Name)
@@ -18,6 +18,7 @@ package errors
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/alibaba/open-local/pkg"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -115,6 +116,298 @@ func NewInsufficientLVMError(requested, used, capacity int64, vgName string, nod
 	}
 }
 
+// InsufficientLVCountError means number of logical volumes in vg reaches the limit
+type InsufficientLVCountError struct {
+	lvCount  int64
+	lvLimit  int64
+	nodeName string
+	vgName   string
+	resource pkg.VolumeType
+}
+
+func (e *InsufficientLVCountError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, vg is %s, vg logical volume count %d reaches the limit %d",
+		e.resource, e.nodeName, e.vgName, e.lvCount, e.lvLimit)
+}
+
+func (e *InsufficientLVCountError) Error() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, vg is %s, vg logical volume count %d reaches the limit %d",
+		e.resource, e.nodeName, e.vgName, e.lvCount, e.lvLimit)
+}
+
+func NewInsufficientLVCountError(lvCount, lvLimit int64, vgName string, nodeName string) *InsufficientLVCountError {
+	return &InsufficientLVCountError{
+		resource: pkg.VolumeTypeLVM,
+		lvCount:  lvCount,
+		lvLimit:  lvLimit,
+		vgName:   vgName,
+		nodeName: nodeName,
+	}
+}
+
+// VGInMaintenanceError means vg is under maintenance and accepts no new volume
+type VGInMaintenanceError struct {
+	nodeName string
+	vgName   string
+	resource pkg.VolumeType
+}
+
+func (e *VGInMaintenanceError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, vg %s is under maintenance", e.resource, e.nodeName, e.vgName)
+}
+
+func (e *VGInMaintenanceError) Error() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, vg %s is under maintenance", e.resource, e.nodeName, e.vgName)
+}
+
+func NewVGInMaintenanceError(vgName string, nodeName string) *VGInMaintenanceError {
+	return &VGInMaintenanceError{
+		resource: pkg.VolumeTypeLVM,
+		vgName:   vgName,
+		nodeName: nodeName,
+	}
+}
+
+// SnapshotCapacityExceededError means new snapshot exceeds maxSnapshotSize of node
+type SnapshotCapacityExceededError struct {
+	requested uint64
+	used      uint64
+	limit     uint64
+	nodeName  string
+	resource  pkg.VolumeType
+}
+
+func (e *SnapshotCapacityExceededError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s snapshot capacity on node %s, requested %d, used %d, maxSnapshotSize %d",
+		e.resource, e.nodeName, e.requested, e.used, e.limit)
+}
+
+func (e *SnapshotCapacityExceededError) Error() string {
+	return fmt.Sprintf("Insufficient %s snapshot capacity on node %s, requested %d, used %d, maxSnapshotSize %d",
+		e.resource, e.nodeName, e.requested, e.used, e.limit)
+}
+
+func NewSnapshotCapacityExceededError(requested, used, limit uint64, nodeName string) *SnapshotCapacityExceededError {
+	return &SnapshotCapacityExceededError{
+		resource:  pkg.VolumeTypeLVM,
+		requested: requested,
+		used:      used,
+		limit:     limit,
+		nodeName:  nodeName,
+	}
+}
+
+// VGMinFreeBreachedError means new volume or expansion leaves less free space
+// than minFreeSize in vg
+type VGMinFreeBreachedError struct {
+	requested uint64
+	free      uint64
+	minFree   uint64
+	vgName    string
+	nodeName  string
+	resource  pkg.VolumeType
+}
+
+func (e *VGMinFreeBreachedError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s storage in vg(%s) on node %s, requested %d, free %d, minFreeSize %d",
+		e.resource, e.vgName, e.nodeName, e.requested, e.free, e.minFree)
+}
+
+func (e *VGMinFreeBreachedError) Error() string {
+	return fmt.Sprintf("Insufficient %s storage in vg(%s) on node %s, requested %d, free %d, minFreeSize %d",
+		e.resource, e.vgName, e.nodeName, e.requested, e.free, e.minFree)
+}
+
+func NewVGMinFreeBreachedError(requested, free, minFree uint64, vgName, nodeName string) *VGMinFreeBreachedError {
+	return &VGMinFreeBreachedError{
+		resource:  pkg.VolumeTypeLVM,
+		requested: requested,
+		free:      free,
+		minFree:   minFree,
+		vgName:    vgName,
+		nodeName:  nodeName,
+	}
+}
+
+// NoMatchingVGError means no vg on node matches vg selector of storage class
+type NoMatchingVGError struct {
+	nodeName string
+	selector string
+	resource pkg.VolumeType
+}
+
+func (e *NoMatchingVGError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, no vg matches selector %q", e.resource, e.nodeName, e.selector)
+}
+
+func (e *NoMatchingVGError) Error() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, no vg matches selector %q", e.resource, e.nodeName, e.selector)
+}
+
+func NewNoMatchingVGError(selector string, nodeName string) *NoMatchingVGError {
+	return &NoMatchingVGError{
+		resource: pkg.VolumeTypeLVM,
+		selector: selector,
+		nodeName: nodeName,
+	}
+}
+
+// VolumeSpreadError means no vg on node is left for pvc once other volumes of
+// the pod are placed, the pod requires its volumes in distinct vgs
+type VolumeSpreadError struct {
+	nodeName string
+	pvcName  string
+	resource pkg.VolumeType
+}
+
+func (e *VolumeSpreadError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, no vg distinct from other volumes of the pod for pvc %s", e.resource, e.nodeName, e.pvcName)
+}
+
+func (e *VolumeSpreadError) Error() string {
+	return fmt.Sprintf("Insufficient %s storage on node %s, no vg distinct from other volumes of the pod for pvc %s", e.resource, e.nodeName, e.pvcName)
+}
+
+func NewVolumeSpreadError(pvcName string, nodeName string) *VolumeSpreadError {
+	return &VolumeSpreadError{
+		resource: pkg.VolumeTypeLVM,
+		pvcName:  pvcName,
+		nodeName: nodeName,
+	}
+}
+
+// VGMetadataExhaustedError means metadata area of vg is full and no more lv can be created
+type VGMetadataExhaustedError struct {
+	metadataFree uint64
+	metadataSize uint64
+	nodeName     string
+	vgName       string
+	resource     pkg.VolumeType
+}
+
+func (e *VGMetadataExhaustedError) GetReason() string {
+	return fmt.Sprintf("Insufficient %s metadata on node %s, vg is %s, vg metadata free %d bytes of %d bytes, no more logical volume can be created",
+		e.resource, e.nodeName, e.vgName, e.metadataFree, e.metadataSize)
+}
+
+func (e *VGMetadataExhaustedError) Error() string {
+	return e.GetReason()
+}
+
+func NewVGMetadataExhaustedError(metadataFree, metadataSize uint64, vgName string, nodeName string) *VGMetadataExhaustedError {
+	return &VGMetadataExhaustedError{
+		resource:     pkg.VolumeTypeLVM,
+		metadataFree: metadataFree,
+		metadataSize: metadataSize,
+		vgName:       vgName,
+		nodeName:     nodeName,
+	}
+}
+
+// InsufficientContiguousLVMError means vg has no contiguous free space as large as requested
+type InsufficientContiguousLVMError struct {
+	requested      int64
+	largestFreeRun int64
+	nodeName       string
+	vgName         string
+	resource       pkg.VolumeType
+}
+
+func (e *InsufficientContiguousLVMError) GetReason() string {
+	requested := resource.NewQuantity(e.requested, resource.BinarySI)
+	largestFreeRun := resource.NewQuantity(e.largestFreeRun, resource.BinarySI)
+	return fmt.Sprintf("Insufficient contiguous %s storage on node %s, vg is %s, pvc requested %s, vg largest contiguous free space %s",
+		e.resource, e.nodeName, e.vgName, requested.String(), largestFreeRun.String())
+}
+
+func (e *InsufficientContiguousLVMError) Error() string {
+	return e.GetReason()
+}
+
+func NewInsufficientContiguousLVMError(requested, largestFreeRun int64, vgName string, nodeName string) *InsufficientContiguousLVMError {
+	return &InsufficientContiguousLVMError{
+		resource:       pkg.VolumeTypeLVM,
+		requested:      requested,
+		largestFreeRun: largestFreeRun,
+		vgName:         vgName,
+		nodeName:       nodeName,
+	}
+}
+
+// NodeStorageStaleError means status of nls is not refreshed by discovery in
+// time, capacity in it may be out of date
+type NodeStorageStaleError struct {
+	nodeName      string
+	lastHeartbeat time.Time
+	window        time.Duration
+}
+
+func (e *NodeStorageStaleError) GetReason() string {
+	return fmt.Sprintf("storage status of node %s is stale, open-local agent may be down", e.nodeName)
+}
+
+func (e *NodeStorageStaleError) Error() string {
+	return fmt.Sprintf("storage status of node %s is last discovered at %s, older than %s", e.nodeName, e.lastHeartbeat.Format(time.RFC3339), e.window)
+}
+
+func NewNodeStorageStaleError(nodeName string, lastHeartbeat time.Time, window time.Duration) *NodeStorageStaleError {
+	return &NodeStorageStaleError{
+		nodeName:      nodeName,
+		lastHeartbeat: lastHeartbeat,
+		window:        window,
+	}
+}
+
+// NodeDomainMismatchError means node is not in fault domain required by pvc,
+// it is reported regardless of capacity of node
+type NodeDomainMismatchError struct {
+	nodeName string
+	pvcName  string
+	required string
+	domain   string
+}
+
+func (e *NodeDomainMismatchError) GetReason() string {
+	return fmt.Sprintf("node is not in domain %s required by pvc %s", e.required, e.pvcName)
+}
+
+func (e *NodeDomainMismatchError) Error() string {
+	return fmt.Sprintf("node %s in domain %s does not match domain %s required by pvc %s", e.nodeName, e.domain, e.required, e.pvcName)
+}
+
+func NewNodeDomainMismatchError(nodeName, pvcName, required, domain string) *NodeDomainMismatchError {
+	return &NodeDomainMismatchError{
+		nodeName: nodeName,
+		pvcName:  pvcName,
+		required: required,
+		domain:   domain,
+	}
+}
+
+// StorageClassUnserviceableError means agent of node reports storage class of
+// pvc unserviceable, node has no vg, mount point or device it asks for
+type StorageClassUnserviceableError struct {
+	nodeName     string
+	pvcName      string
+	storageClass string
+}
+
+func (e *StorageClassUnserviceableError) GetReason() string {
+	return fmt.Sprintf("node has no storage of class %s required by pvc %s", e.storageClass, e.pvcName)
+}
+
+func (e *StorageClassUnserviceableError) Error() string {
+	return fmt.Sprintf("storage class %s of pvc %s is unserviceable on node %s", e.storageClass, e.pvcName, e.nodeName)
+}
+
+func NewStorageClassUnserviceableError(nodeName, pvcName, storageClass string) *StorageClassUnserviceableError {
+	return &StorageClassUnserviceableError{
+		nodeName:     nodeName,
+		pvcName:      pvcName,
+		storageClass: storageClass,
+	}
+}
+
 type InsufficientDeviceCountError struct {
 	requestedCount int64
 	availableCount int64
@@ -17,6 +17,7 @@ limitations under the License.
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -26,10 +27,14 @@ import (
 
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	log "k8s.io/klog/v2"
 )
 
-const schedulingPVCPrefix = "/apis/scheduling/:namespace/persistentvolumeclaims/:name"
+const (
+	schedulingPVCPrefix = "/apis/scheduling/:namespace/persistentvolumeclaims/:name"
+	capacityPreviewPath = "/apis/preview/capacity"
+)
 
 func AddSchedulingApis(router *httprouter.Router, ctx *algorithm.SchedulingContext) {
 	router.POST(schedulingPVCPrefix, DebugLogging(SchedulingPVCWrap(ctx), schedulingPVCPrefix))
@@ -66,6 +71,39 @@ func SchedulingPVCWrap(ctx *algorithm.SchedulingContext) httprouter.Handle {
 	}
 }
 
+func AddCapacityPreview(router *httprouter.Router, ctx *algorithm.SchedulingContext) {
+	router.GET(capacityPreviewPath, DebugLogging(CapacityPreviewWrap(ctx), capacityPreviewPath))
+}
+
+// CapacityPreviewWrap returns nodes and storage a volume of storageClass and
+// size would be scheduled to, e.g. /apis/preview/capacity?storageClass=open-local-lvm&size=10Gi
+func CapacityPreviewWrap(ctx *algorithm.SchedulingContext) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		scName := r.URL.Query().Get("storageClass")
+		if utils.IsEmpty(scName) {
+			utils.HttpResponse(w, http.StatusBadRequest, []byte("storageClass can not be empty"))
+			return
+		}
+		size, err := resource.ParseQuantity(r.URL.Query().Get("size"))
+		if err != nil {
+			err = fmt.Errorf("invalid size %q: %s", r.URL.Query().Get("size"), err.Error())
+			utils.HttpResponse(w, http.StatusBadRequest, []byte(err.Error()))
+			return
+		}
+		preview, err := apis.PreviewCapacity(ctx, scName, size.Value())
+		if err != nil {
+			log.Errorf("failed to preview capacity of storage class %s: %s", scName, err.Error())
+			code := http.StatusInternalServerError
+			if errors.Is(err, apis.ErrInvalidPreview) {
+				code = http.StatusBadRequest
+			}
+			utils.HttpResponse(w, code, []byte(err.Error()))
+			return
+		}
+		utils.HttpJSON(w, http.StatusOK, preview)
+	}
+}
+
 func AddGetNodeCache(router *httprouter.Router, ctx *algorithm.SchedulingContext) {
 	router.POST(cachePath, DebugLogging(apis.CacheRoute(ctx), cachePath))
 }
@@ -0,0 +1,234 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/algo"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/cache"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/predicates"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/priorities"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	log "k8s.io/klog/v2"
+)
+
+// ErrInvalidPreview is returned if storage class or size of preview is invalid
+var ErrInvalidPreview = errors.New("invalid capacity preview")
+
+const (
+	// the preview claim and pod never exist in cluster
+	previewNamespace = "open-local-preview"
+	previewName      = "capacity-preview"
+)
+
+// CapacityPreview is the placement a volume of storage class and size would
+// get under current capacity and reservations
+type CapacityPreview struct {
+	StorageClass string `json:"storageClass"`
+	Size         int64  `json:"size"`
+	// Candidates are nodes able to hold the volume, sorted by score from high to low
+	Candidates []PreviewCandidate `json:"candidates"`
+	// Unschedulable is the reason why volume does not fit, keyed by node
+	Unschedulable map[string]string `json:"unschedulable,omitempty"`
+}
+
+// PreviewCandidate is a node able to hold the volume and the storage where
+// the volume would be allocated
+type PreviewCandidate struct {
+	Node       string `json:"node"`
+	VolumeType string `json:"volumeType"`
+	VgName     string `json:"vgName,omitempty"`
+	MountPoint string `json:"mountPoint,omitempty"`
+	Device     string `json:"device,omitempty"`
+	// Score is the sum of extender priorities, as scheduler gets for the node
+	Score int64 `json:"score"`
+}
+
+// PreviewCapacity runs predicates, priorities and allocation of the extender
+// for a volume of storage class scName and size on every node in cluster. It
+// has no side effect, nothing is reserved in cache
+func PreviewCapacity(ctx *algorithm.SchedulingContext, scName string, size int64) (*CapacityPreview, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: size %d must be positive", ErrInvalidPreview, size)
+	}
+	if _, err := ctx.StorageV1Informers.StorageClasses().Lister().Get(scName); err != nil {
+		return nil, fmt.Errorf("%w: failed to get storage class %s: %s", ErrInvalidPreview, scName, err.Error())
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: previewName, Namespace: previewNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &scName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *resource.NewQuantity(size, resource.BinarySI)},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+	isLocal, volumeType := utils.IsLocalPVC(pvc, ctx.StorageV1Informers.StorageClasses().Lister())
+	if !isLocal {
+		return nil, fmt.Errorf("%w: storage class %s is not provisioned by open-local", ErrInvalidPreview, scName)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: previewName, Namespace: previewNamespace},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name:         previewName,
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: previewName}},
+			}},
+		},
+	}
+	previewCtx := &algorithm.SchedulingContext{
+		ClusterNodeCache:           ctx.ClusterNodeCache,
+		CoreV1Informers:            previewCoreInformers{Interface: ctx.CoreV1Informers, pvc: pvc},
+		StorageV1Informers:         ctx.StorageV1Informers,
+		LocalStorageInformer:       ctx.LocalStorageInformer,
+		SnapshotInformers:          ctx.SnapshotInformers,
+		NodeAntiAffinityWeight:     ctx.NodeAntiAffinityWeight,
+		NodeStorageStalenessWindow: ctx.NodeStorageStalenessWindow,
+	}
+
+	nodes, err := ctx.CoreV1Informers.Nodes().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	// consistent with reservations made by SchedulingPVC
+	ctx.CtxLock.RLock()
+	defer ctx.CtxLock.RUnlock()
+
+	preview := &CapacityPreview{StorageClass: scName, Size: size, Candidates: []PreviewCandidate{}, Unschedulable: map[string]string{}}
+	for _, node := range nodes {
+		fits, failReasons, err := predicates.Predicates(previewCtx, predicates.DefaultPredicateFuncs, pod, node)
+		if err != nil {
+			preview.Unschedulable[node.Name] = err.Error()
+			continue
+		}
+		if !fits {
+			preview.Unschedulable[node.Name] = strings.Join(failReasons, ",")
+			continue
+		}
+		// storage is picked the same way as SchedulingPVC does once node is selected
+		unit, err := previewAllocate(previewCtx, volumeType, pvc, node)
+		if err != nil {
+			preview.Unschedulable[node.Name] = err.Error()
+			continue
+		}
+		candidate := PreviewCandidate{
+			Node:       node.Name,
+			VolumeType: string(unit.VolumeType),
+			VgName:     unit.VgName,
+			MountPoint: unit.MountPoint,
+			Device:     unit.Device,
+		}
+		for _, pri := range priorities.DefaultPrioritizeFuncs {
+			score, err := pri(previewCtx, pod, node)
+			if err != nil {
+				// same as prioritize of extender, the failed priority is ignored
+				log.Errorf("[PreviewCapacity]failed to prioritize node %s: %s", node.Name, err.Error())
+				continue
+			}
+			candidate.Score += int64(score)
+		}
+		preview.Candidates = append(preview.Candidates, candidate)
+	}
+	sort.SliceStable(preview.Candidates, func(i, j int) bool {
+		return preview.Candidates[i].Score > preview.Candidates[j].Score
+	})
+	return preview, nil
+}
+
+func previewAllocate(ctx *algorithm.SchedulingContext, volumeType localtype.VolumeType, pvc *corev1.PersistentVolumeClaim, node *corev1.Node) (*cache.AllocatedUnit, error) {
+	pvcs := []*corev1.PersistentVolumeClaim{pvc}
+	var units []cache.AllocatedUnit
+	var err error
+	switch volumeType {
+	case localtype.VolumeTypeLVM:
+		_, units, err = algo.ScoreLVMVolume(nil, pvcs, node, ctx)
+	case localtype.VolumeTypeMountPoint:
+		_, units, err = algo.ScoreMountPointVolume(nil, pvcs, node, ctx)
+	case localtype.VolumeTypeDevice:
+		_, units, err = algo.ScoreDeviceVolume(nil, pvcs, node, ctx)
+	default:
+		return nil, fmt.Errorf("unsupported volume type %s", volumeType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(units) != 1 {
+		return nil, fmt.Errorf("no storage on node %s is allocatable", node.Name)
+	}
+	return &units[0], nil
+}
+
+// previewCoreInformers serves the preview claim along with claims in cluster,
+// so that predicates and priorities of pod run for the claim unchanged
+type previewCoreInformers struct {
+	corev1informers.Interface
+	pvc *corev1.PersistentVolumeClaim
+}
+
+func (i previewCoreInformers) PersistentVolumeClaims() corev1informers.PersistentVolumeClaimInformer {
+	return previewPVCInformer{PersistentVolumeClaimInformer: i.Interface.PersistentVolumeClaims(), pvc: i.pvc}
+}
+
+type previewPVCInformer struct {
+	corev1informers.PersistentVolumeClaimInformer
+	pvc *corev1.PersistentVolumeClaim
+}
+
+func (i previewPVCInformer) Lister() corelisters.PersistentVolumeClaimLister {
+	return previewPVCLister{PersistentVolumeClaimLister: i.PersistentVolumeClaimInformer.Lister(), pvc: i.pvc}
+}
+
+type previewPVCLister struct {
+	corelisters.PersistentVolumeClaimLister
+	pvc *corev1.PersistentVolumeClaim
+}
+
+func (l previewPVCLister) PersistentVolumeClaims(namespace string) corelisters.PersistentVolumeClaimNamespaceLister {
+	return previewPVCNamespaceLister{
+		PersistentVolumeClaimNamespaceLister: l.PersistentVolumeClaimLister.PersistentVolumeClaims(namespace),
+		namespace:                            namespace,
+		pvc:                                  l.pvc,
+	}
+}
+
+type previewPVCNamespaceLister struct {
+	corelisters.PersistentVolumeClaimNamespaceLister
+	namespace string
+	pvc       *corev1.PersistentVolumeClaim
+}
+
+func (l previewPVCNamespaceLister) Get(name string) (*corev1.PersistentVolumeClaim, error) {
+	if l.namespace == l.pvc.Namespace && name == l.pvc.Name {
+		return l.pvc, nil
+	}
+	return l.PersistentVolumeClaimNamespaceLister.Get(name)
+}
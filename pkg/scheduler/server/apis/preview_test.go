@@ -0,0 +1,236 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apis
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	localfake "github.com/alibaba/open-local/pkg/generated/clientset/versioned/fake"
+	localinformers "github.com/alibaba/open-local/pkg/generated/informers/externalversions"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/predicates"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/priorities"
+	"github.com/alibaba/open-local/pkg/utils"
+	volumesnapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	volumesnapshotinformersfactory "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+func createTestSchedulingContext(t *testing.T) *algorithm.SchedulingContext {
+	weights, err := utils.ParseWeight("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8sInformerFactory := kubeinformers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(), 0)
+	localInformerFactory := localinformers.NewSharedInformerFactory(localfake.NewSimpleClientset(), 0)
+	snapshotInformerFactory := volumesnapshotinformersfactory.NewSharedInformerFactory(volumesnapshotfake.NewSimpleClientset(), 0)
+	ctx := algorithm.NewSchedulingContext(
+		k8sInformerFactory.Core().V1(),
+		k8sInformerFactory.Storage().V1(),
+		localInformerFactory.Csi().V1alpha1(),
+		snapshotInformerFactory.Snapshot().V1(),
+		weights,
+	)
+	for _, nls := range utils.CreateTestNodeLocalStorage() {
+		_ = ctx.LocalStorageInformer.NodeLocalStorages().Informer().GetIndexer().Add(nls)
+		_ = ctx.CoreV1Informers.Nodes().Informer().GetIndexer().Add(utils.CreateNode(&utils.TestNodeInfo{NodeName: nls.Name}))
+		ctx.ClusterNodeCache.AddNodeCache(nls)
+	}
+	for _, sc := range utils.CreateTestStorageClass() {
+		_ = ctx.StorageV1Informers.StorageClasses().Informer().GetIndexer().Add(sc)
+	}
+	return ctx
+}
+
+// schedule runs the extender for a pod with a single pvc of scName and size,
+// it returns scores of nodes that fit and binding info on the highest one
+func schedule(t *testing.T, ctx *algorithm.SchedulingContext, scName, size string) (map[string]int, *localtype.BindingInfo) {
+	pvcInfo := utils.TestPVCInfo{
+		PVCName:      "pvc-preview-check",
+		PVCNameSpace: utils.LocalNameSpace,
+		Size:         size,
+		SCName:       scName,
+		PVCStatus:    corev1.ClaimPending,
+	}
+	pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{pvcInfo})[0]
+	pod := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "pod-preview-check",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos:     []*utils.TestPVCInfo{&pvcInfo},
+	})
+	_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+	_ = ctx.CoreV1Informers.Pods().Informer().GetIndexer().Add(pod)
+
+	nodeNames := append([]string{}, utils.NodeNamesAll...)
+	filtered, err := predicates.NewPredicate(ctx).Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	if err != nil {
+		t.Fatalf("predicate failed: %s", err.Error())
+	}
+	scores := map[string]int{}
+	if len(*filtered.NodeNames) == 0 {
+		return scores, nil
+	}
+	priorityList, err := priorities.NewPrioritize(ctx).Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: filtered.NodeNames})
+	if err != nil {
+		t.Fatalf("prioritize failed: %s", err.Error())
+	}
+	var selected string
+	for _, host := range *priorityList {
+		scores[host.Host] = int(host.Score)
+		if selected == "" || int(host.Score) > scores[selected] {
+			selected = host.Host
+		}
+	}
+
+	// provisioner requests storage once scheduler selected the node
+	pvc.Annotations = map[string]string{localtype.AnnoSelectedNode: selected}
+	_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Update(pvc)
+	ctx.ClusterNodeCache.PvcMapping.PutPod(utils.GetName(pod.ObjectMeta), []*corev1.PersistentVolumeClaim{pvc})
+	node, _ := ctx.CoreV1Informers.Nodes().Lister().Get(selected)
+	binding, err := SchedulingPVC(ctx, pvc, node)
+	if err != nil {
+		t.Fatalf("failed to schedule pvc on %s: %s", selected, err.Error())
+	}
+	return scores, binding
+}
+
+func TestPreviewCapacity(t *testing.T) {
+	tests := []struct {
+		name           string
+		scName         string
+		size           string
+		wantCandidates bool
+	}{
+		{
+			name:           "test lvm with vg",
+			scName:         utils.SCLVMWithVG,
+			size:           "150Gi",
+			wantCandidates: true,
+		},
+		{
+			name:           "test lvm without vg",
+			scName:         utils.SCLVMWithoutVG,
+			size:           "40Gi",
+			wantCandidates: true,
+		},
+		{
+			name:           "test mount point",
+			scName:         utils.SCWithMP,
+			size:           "10Gi",
+			wantCandidates: true,
+		},
+		{
+			name:           "test device",
+			scName:         utils.SCWithDevice,
+			size:           "100Gi",
+			wantCandidates: true,
+		},
+		{
+			name:           "test no capacity",
+			scName:         utils.SCLVMWithVG,
+			size:           "100Ti",
+			wantCandidates: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := createTestSchedulingContext(t)
+			size := resource.MustParse(tt.size)
+
+			before, _ := json.Marshal(ctx.ClusterNodeCache)
+			preview, err := PreviewCapacity(ctx, tt.scName, size.Value())
+			if err != nil {
+				t.Fatalf("PreviewCapacity() error = %v", err)
+			}
+			after, _ := json.Marshal(ctx.ClusterNodeCache)
+			if string(before) != string(after) {
+				t.Errorf("PreviewCapacity() changed cache:\n%s\nwant\n%s", after, before)
+			}
+			if got := len(preview.Candidates) > 0; got != tt.wantCandidates {
+				t.Fatalf("PreviewCapacity() has candidates %t, want %t", got, tt.wantCandidates)
+			}
+			if len(preview.Candidates)+len(preview.Unschedulable) != len(utils.NodeNamesAll) {
+				t.Errorf("PreviewCapacity() returns %d candidates and %d unschedulable nodes, want %d nodes",
+					len(preview.Candidates), len(preview.Unschedulable), len(utils.NodeNamesAll))
+			}
+
+			scores, binding := schedule(t, ctx, tt.scName, tt.size)
+			previewScores := map[string]int{}
+			for _, candidate := range preview.Candidates {
+				previewScores[candidate.Node] = int(candidate.Score)
+			}
+			if len(previewScores) != len(scores) {
+				t.Errorf("preview scores = %v, scheduler scores = %v", previewScores, scores)
+			}
+			for node, score := range scores {
+				if previewScore, ok := previewScores[node]; !ok || previewScore != score {
+					t.Errorf("preview scores = %v, scheduler scores = %v", previewScores, scores)
+					break
+				}
+			}
+			if binding == nil {
+				return
+			}
+			top := preview.Candidates[0]
+			if top.Node != binding.Node || top.VgName != binding.VgName || top.MountPoint != binding.Disk ||
+				top.Device != binding.Device || top.VolumeType != binding.VolumeType {
+				t.Errorf("preview top candidate = %+v, scheduler binding = %+v", top, *binding)
+			}
+		})
+	}
+}
+
+func TestPreviewCapacity_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		scName string
+		size   int64
+	}{
+		{
+			name:   "test non-existent storage class",
+			scName: "sc-not-exist",
+			size:   int64(utils.LocalGi),
+		},
+		{
+			name:   "test storage class not of open-local",
+			scName: utils.SCNoLocal,
+			size:   int64(utils.LocalGi),
+		},
+		{
+			name:   "test non-positive size",
+			scName: utils.SCLVMWithVG,
+			size:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := createTestSchedulingContext(t)
+			if _, err := PreviewCapacity(ctx, tt.scName, tt.size); !errors.Is(err, ErrInvalidPreview) {
+				t.Errorf("PreviewCapacity() error = %v, want %v", err, ErrInvalidPreview)
+			}
+		})
+	}
+}
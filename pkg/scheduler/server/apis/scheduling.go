@@ -54,16 +54,24 @@ func SchedulingPVC(ctx *algorithm.SchedulingContext, pvc *corev1.PersistentVolum
 	}
 
 	if ctx.ClusterNodeCache.BindingInfo.IsPVCExists(pvcName) {
-		log.Infof("%s is already allocated, returning existing", pvcName)
-		return unitsToBinding(
-			[]*corev1.PersistentVolumeClaim{pvc},
-			[]cache.AllocatedUnit{*ctx.ClusterNodeCache.BindingInfo[pvcName]}), nil
+		unit := *ctx.ClusterNodeCache.BindingInfo[pvcName]
+		if unit.NodeName == node.Name || pvc.Spec.VolumeName != "" {
+			log.Infof("%s is already allocated, returning existing", pvcName)
+			return unitsToBinding([]*corev1.PersistentVolumeClaim{pvc}, []cache.AllocatedUnit{unit}), nil
+		}
+		// pod is rescheduled to another node after pvc was allocated,
+		// the allocation on the previous node must not be returned
+		log.Warningf("%s was allocated on node %s, but now selected node is %s, reallocating", pvcName, unit.NodeName, node.Name)
 	}
 	if !ctx.ClusterNodeCache.PvcMapping.IsPodPvcReady(pvc) {
 		msg := fmt.Sprintf("pvc %s is not eligible for provisioning as related pvcs are still pending", pvcName)
 		log.Info(msg)
 		return nil, fmt.Errorf(msg)
 	}
+	if err := algorithm.CheckNodeStorageFresh(node.Name, ctx); err != nil {
+		log.Errorf("refuse to schedule pvc %s: %s", pvcName, err.Error())
+		return nil, err
+	}
 	err, lvmPVCs, mpPVCs, devicePVCs := algorithm.GetPodUnboundPvcs(pvc, ctx)
 	if err != nil {
 		log.Errorf("failed to get pod unbound pvcs: %s", err.Error())
@@ -75,9 +83,14 @@ func SchedulingPVC(ctx *algorithm.SchedulingContext, pvc *corev1.PersistentVolum
 		log.Info(msg)
 		return nil, fmt.Errorf(msg)
 	}
+	// pod is required to spread its volumes in distinct vgs
+	pod, err := algorithm.GetPodOfPVC(pvc, ctx)
+	if err != nil {
+		return nil, err
+	}
 	var allocatedUnits []cache.AllocatedUnit
 	trace.Step("Computing ScoreLVMVolume")
-	if _, lvmUnits, err := algo.ScoreLVMVolume(nil /*do we need pod here*/, lvmPVCs, node, ctx); err != nil {
+	if _, lvmUnits, err := algo.ScoreLVMVolume(pod, lvmPVCs, node, ctx); err != nil {
 		err = fmt.Errorf("failed to allocate local storage for pvc %s: %s", utils.GetName(pvc.ObjectMeta), err.Error())
 		log.Errorf(err.Error())
 		return nil, err
@@ -118,6 +131,11 @@ func SchedulingPVC(ctx *algorithm.SchedulingContext, pvc *corev1.PersistentVolum
 	log.V(6).Infof("allocatedUnits of pvc %s: %+v", pvcName, allocatedUnits)
 	for _, unit := range allocatedUnits {
 		newUnit := unit
+		if stale, ok := ctx.ClusterNodeCache.BindingInfo[newUnit.PVCName]; ok && stale.NodeName != newUnit.NodeName {
+			if err := ctx.ClusterNodeCache.Unassume([]cache.AllocatedUnit{*stale}); err != nil {
+				log.Errorf("failed to unassume stale allocation of pvc %s on node %s: %s", newUnit.PVCName, stale.NodeName, err.Error())
+			}
+		}
 		ctx.ClusterNodeCache.BindingInfo[newUnit.PVCName] = &newUnit
 		if unit.PVCName == utils.PVCName(pvc) {
 			targetAllocateUnits = append(targetAllocateUnits, unit)
@@ -0,0 +1,237 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apis
+
+import (
+	"sort"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/predicates"
+	"github.com/alibaba/open-local/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestSchedulingPVC_VolumeSpread(t *testing.T) {
+	tests := []struct {
+		name      string
+		spread    bool
+		sizes     []string
+		wantNodes []string
+	}{
+		{
+			name:      "test volumes not spread",
+			sizes:     []string{"10Gi", "10Gi"},
+			wantNodes: []string{utils.NodeName1, utils.NodeName2, utils.NodeName3},
+		},
+		{
+			name:      "test node with one vg filtered out",
+			spread:    true,
+			sizes:     []string{"10Gi", "10Gi"},
+			wantNodes: []string{utils.NodeName1, utils.NodeName2},
+		},
+		{
+			name:      "test volumes of different size",
+			spread:    true,
+			sizes:     []string{"90Gi", "200Gi"},
+			wantNodes: []string{utils.NodeName1, utils.NodeName2},
+		},
+		{
+			name:      "test node without vg for larger volume filtered out",
+			spread:    true,
+			sizes:     []string{"150Gi", "600Gi"},
+			wantNodes: []string{utils.NodeName2},
+		},
+		{
+			name:   "test more volumes than vg",
+			spread: true,
+			sizes:  []string{"10Gi", "10Gi", "10Gi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := createTestSchedulingContext(t)
+			var pvcInfos []utils.TestPVCInfo
+			for i, size := range tt.sizes {
+				pvcInfos = append(pvcInfos, utils.TestPVCInfo{
+					PVCName:      "pvc-spread-" + string(rune('a'+i)),
+					PVCNameSpace: utils.LocalNameSpace,
+					Size:         size,
+					SCName:       utils.SCLVMWithoutVG,
+					PVCStatus:    corev1.ClaimPending,
+				})
+			}
+			podInfo := &utils.TestPodInfo{
+				PodName:      "pod-spread",
+				PodNameSpace: utils.LocalNameSpace,
+				PodStatus:    corev1.PodPending,
+			}
+			for i := range pvcInfos {
+				podInfo.PVCInfos = append(podInfo.PVCInfos, &pvcInfos[i])
+			}
+			pod := utils.CreatePod(podInfo)
+			if tt.spread {
+				pod.Annotations = map[string]string{localtype.AnnotationPodVolumeSpreadKey: localtype.VolumeSpreadVG}
+			}
+			pvcs := utils.CreateTestPersistentVolumeClaim(pvcInfos)
+			for _, pvc := range pvcs {
+				_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			}
+			_ = ctx.CoreV1Informers.Pods().Informer().GetIndexer().Add(pod)
+
+			nodeNames := append([]string{}, utils.NodeNamesAll...)
+			filtered, err := predicates.NewPredicate(ctx).Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+			if err != nil {
+				t.Fatalf("predicate failed: %s", err.Error())
+			}
+			gotNodes := append([]string{}, *filtered.NodeNames...)
+			sort.Strings(gotNodes)
+			if len(gotNodes) != len(tt.wantNodes) {
+				t.Fatalf("filtered nodes = %v, want %v", gotNodes, tt.wantNodes)
+			}
+			for i := range gotNodes {
+				if gotNodes[i] != tt.wantNodes[i] {
+					t.Fatalf("filtered nodes = %v, want %v", gotNodes, tt.wantNodes)
+				}
+			}
+			if !tt.spread || len(gotNodes) == 0 {
+				return
+			}
+
+			// provisioner allocates all volumes of pod on the selected node
+			selected := gotNodes[0]
+			for _, pvc := range pvcs {
+				pvc.Annotations = map[string]string{localtype.AnnoSelectedNode: selected}
+				_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Update(pvc)
+			}
+			ctx.ClusterNodeCache.PvcMapping.PutPod(utils.GetName(pod.ObjectMeta), pvcs)
+			node, _ := ctx.CoreV1Informers.Nodes().Lister().Get(selected)
+			if _, err := SchedulingPVC(ctx, pvcs[0], node); err != nil {
+				t.Fatalf("failed to schedule pvc on %s: %s", selected, err.Error())
+			}
+			vgs := map[string]string{}
+			for _, pvc := range pvcs {
+				unit, ok := ctx.ClusterNodeCache.BindingInfo[utils.PVCName(pvc)]
+				if !ok {
+					t.Fatalf("pvc %s is not allocated", utils.PVCName(pvc))
+				}
+				if other, ok := vgs[unit.VgName]; ok {
+					t.Errorf("pvc %s and %s are both allocated in vg %s", other, utils.PVCName(pvc), unit.VgName)
+				}
+				vgs[unit.VgName] = utils.PVCName(pvc)
+			}
+		})
+	}
+}
+
+func TestSchedulingPVC_SnapshotFullCopy(t *testing.T) {
+	tests := []struct {
+		name  string
+		srcVG string
+	}{
+		{
+			name:  "test full copy of source volume in hdd",
+			srcVG: utils.VGHDD,
+		},
+		{
+			name:  "test full copy of source volume in ssd",
+			srcVG: utils.VGSSD,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := createTestSchedulingContext(t)
+			sc := &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "sc-full-copy"},
+				Provisioner: localtype.ProvisionerNameYoda,
+				Parameters: map[string]string{
+					"volumeType":                    string(localtype.VolumeTypeLVM),
+					localtype.ParamSnapshotFullCopy: "true",
+				},
+			}
+			_ = ctx.StorageV1Informers.StorageClasses().Informer().GetIndexer().Add(sc)
+			srcPVC := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{{
+				PVCName:      "pvc-src",
+				PVCNameSpace: utils.LocalNameSpace,
+				VolumeName:   "pv-src",
+				Size:         "10Gi",
+				SCName:       utils.SCLVMWithoutVG,
+				NodeName:     utils.NodeName1,
+				PVCStatus:    corev1.ClaimBound,
+			}})[0]
+			srcPV := utils.CreateTestPersistentVolume([]utils.TestPVInfo{{
+				VolumeName: "pv-src",
+				VolumeSize: "10Gi",
+				VolumeType: string(localtype.VolumeTypeLVM),
+				VgName:     tt.srcVG,
+				NodeName:   utils.NodeName1,
+				PVStatus:   corev1.VolumeBound,
+				IsLocalPV:  true,
+			}})[0]
+			_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(srcPVC)
+			_ = ctx.CoreV1Informers.PersistentVolumes().Informer().GetIndexer().Add(srcPV)
+			_ = ctx.SnapshotInformers.VolumeSnapshotClasses().Informer().GetIndexer().Add(utils.CreateVolumeSnapshotClass(&utils.TestVolumeSnapshotClassInfo{
+				Name:       "snapclass-ro",
+				Parameters: map[string]string{localtype.ParamReadonly: "true"},
+			}))
+			_ = ctx.SnapshotInformers.VolumeSnapshots().Informer().GetIndexer().Add(utils.CreateVolumeSnapshot(&utils.TestVolumeSnapshotInfo{
+				SnapshotName:      "snap-src",
+				SnapshotClassName: "snapclass-ro",
+				SrcPVCName:        "pvc-src",
+			}))
+
+			pvcInfo := utils.TestPVCInfo{
+				PVCName:      "pvc-copy",
+				PVCNameSpace: utils.LocalNameSpace,
+				Size:         "10Gi",
+				SCName:       sc.Name,
+				NodeName:     utils.NodeName1,
+				PVCStatus:    corev1.ClaimPending,
+				IsSnapshot:   true,
+				SnapName:     "snap-src",
+			}
+			pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{pvcInfo})[0]
+			pod := utils.CreatePod(&utils.TestPodInfo{
+				PodName:      "pod-copy",
+				PodNameSpace: utils.LocalNameSpace,
+				PodStatus:    corev1.PodPending,
+				PVCInfos:     []*utils.TestPVCInfo{&pvcInfo},
+			})
+			_ = ctx.CoreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			_ = ctx.CoreV1Informers.Pods().Informer().GetIndexer().Add(pod)
+			ctx.ClusterNodeCache.PvcMapping.PutPod(utils.GetName(pod.ObjectMeta), []*corev1.PersistentVolumeClaim{pvc})
+
+			node, _ := ctx.CoreV1Informers.Nodes().Lister().Get(utils.NodeName1)
+			if _, err := SchedulingPVC(ctx, pvc, node); err != nil {
+				t.Fatalf("failed to schedule pvc on %s: %s", utils.NodeName1, err.Error())
+			}
+			unit, ok := ctx.ClusterNodeCache.BindingInfo[utils.PVCName(pvc)]
+			if !ok {
+				t.Fatalf("pvc %s is not allocated", utils.PVCName(pvc))
+			}
+			if unit.VgName != tt.srcVG {
+				t.Errorf("pvc %s is allocated in vg %s, want %s", utils.PVCName(pvc), unit.VgName, tt.srcVG)
+			}
+			if unit.Requested != 10*1024*1024*1024 {
+				t.Errorf("pvc %s requested %d, want %d", utils.PVCName(pvc), unit.Requested, 10*1024*1024*1024)
+			}
+		})
+	}
+}
@@ -55,6 +55,8 @@ func NewExtenderServer(
 	volumesnapshotInformerFactory volumesnapshotinformers.SharedInformerFactory,
 	port int32,
 	weights *pkg.NodeAntiAffinityWeight,
+	stalenessWindow time.Duration,
+	replicaAffinity pkg.ReplicaAffinity,
 ) *ExtenderServer {
 	corev1Informers := kubeInformerFactory.Core().V1()
 	storagev1Informers := kubeInformerFactory.Storage().V1()
@@ -68,6 +70,8 @@ func NewExtenderServer(
 		snapshotInformers,
 		weights,
 	)
+	Ctx.NodeStorageStalenessWindow = stalenessWindow
+	Ctx.ReplicaAffinity = replicaAffinity
 
 	informersSyncd := make([]clientgocache.InformerSynced, 0)
 
@@ -171,9 +175,17 @@ func (e *ExtenderServer) InitRouter() {
 		metrics.MountPointTotal,
 		metrics.DeviceTotal,
 		metrics.VolumeGroupUsedByLocal,
+		metrics.VolumeGroupMetadataLow,
+		metrics.StatusDrift,
+		metrics.StatusDriftCount,
+		metrics.StorageVersionInfo,
+		metrics.VolumeGroupIOStats,
+		metrics.SnapshotFillRate,
+		metrics.SnapshotSecondsToFull,
 		metrics.MountPointAvailable,
 		metrics.DeviceAvailable,
 		metrics.DeviceBind,
+		metrics.DiskTemperature,
 		metrics.MountPointBind,
 		metrics.AllocatedNum,
 		metrics.LocalPV,
@@ -188,6 +200,7 @@ func (e *ExtenderServer) InitRouter() {
 	AddPredicate(router, *predicates.NewPredicate(e.Ctx))
 	AddPrioritize(router, *priorities.NewPrioritize(e.Ctx))
 	AddSchedulingApis(router, e.Ctx)
+	AddCapacityPreview(router, e.Ctx)
 
 	go func() {
 		if e.port > 0 {
@@ -52,6 +52,28 @@ func (info *PodLocalVolumeInfo) HaveLocalVolumes() bool {
 	return info.LVMPVCsNotROSnapshot.HaveLocalVolumes() || info.LVMPVCsROSnapshot.HaveLocalVolumes() || info.DevicePVCs.HaveLocalVolumes() || info.InlineVolumes.HaveLocalVolumes()
 }
 
+// PVCs returns pending pvcs of pod
+func (info *PodLocalVolumeInfo) PVCs() []*corev1.PersistentVolumeClaim {
+	pvcs := []*corev1.PersistentVolumeClaim{}
+	if info == nil {
+		return pvcs
+	}
+	if info.LVMPVCsNotROSnapshot != nil {
+		for _, pvcInfo := range append(append([]*LVMPVCInfo{}, info.LVMPVCsNotROSnapshot.LVMPVCsWithVgNameNotAllocated...), info.LVMPVCsNotROSnapshot.LVMPVCsWithoutVgNameNotAllocated...) {
+			pvcs = append(pvcs, pvcInfo.PVC)
+		}
+	}
+	for _, pvcInfo := range info.LVMPVCsROSnapshot {
+		pvcs = append(pvcs, pvcInfo.PVC)
+	}
+	if info.DevicePVCs != nil {
+		for _, pvcInfo := range append(append([]*DevicePVCInfo{}, info.DevicePVCs.SSDDevicePVCs...), info.DevicePVCs.HDDDevicePVCs...) {
+			pvcs = append(pvcs, pvcInfo.PVC)
+		}
+	}
+	return pvcs
+}
+
 /*
 use by event handler
 */
@@ -5,12 +5,13 @@ import (
 	"sync"
 
 	"github.com/alibaba/open-local/pkg"
-	"github.com/alibaba/open-local/pkg/scheduler/errors"
 
 	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
 	"github.com/alibaba/open-local/pkg/utils"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/klog/v2"
 )
@@ -173,6 +174,8 @@ type NodeStorageAllocatedCache struct {
 	inlineVolumeAllocatedDetails map[string] /*nodeName*/ NodeInlineVolumeAllocatedDetails
 	pvAllocatedDetails           *PVAllocatedDetails
 	pvcInfosMap                  map[string] /*pvcKey*/ *PVCInfo
+	// reservations are reserved but not yet bound, released by ReconcileReservations if leaked
+	reservations map[string] /*podUid*/ *NodeAllocateState
 	sync.RWMutex
 }
 
@@ -184,6 +187,7 @@ func NewNodeStorageAllocatedCache(strategyType pkg.StrategyType) *NodeStorageAll
 		inlineVolumeAllocatedDetails: map[string]NodeInlineVolumeAllocatedDetails{},
 		pvcInfosMap:                  map[string]*PVCInfo{},
 		pvAllocatedDetails:           NewPVAllocatedDetails(),
+		reservations:                 map[string]*NodeAllocateState{},
 	}
 	cache.lvmPVAllocator = NewLVMCommonPVAllocator(cache)
 	cache.inlineVolumeAllocator = NewInlineVolumeAllocator(cache)
@@ -192,6 +196,14 @@ func NewNodeStorageAllocatedCache(strategyType pkg.StrategyType) *NodeStorageAll
 	return cache
 }
 
+// EnableVGConsistentHashing makes lvm pvc without vg name try the vg hashed by
+// its identity first, it must be called before the cache is used
+func (c *NodeStorageAllocatedCache) EnableVGConsistentHashing() {
+	if allocator, ok := c.lvmPVAllocator.(*lvmCommonPVAllocator); ok {
+		allocator.scheduleStrategy = NewVGScheduleConsistentHashStrategy(allocator.scheduleStrategy)
+	}
+}
+
 func (c *NodeStorageAllocatedCache) GetNodeStorageStateCopy(nodeName string) *NodeStorageState {
 	c.RLock()
 	defer c.RUnlock()
@@ -277,6 +289,10 @@ func (c *NodeStorageAllocatedCache) Reserve(preAllocateState *NodeAllocateState,
 		return err
 	}
 
+	// resource of reservation pod is handed over, not owned by the pod
+	if reservationPodUid == "" {
+		c.reservations[preAllocateState.PodUid] = preAllocateState
+	}
 	return nil
 }
 
@@ -291,15 +307,65 @@ func (c *NodeStorageAllocatedCache) Unreserve(reservedAllocateState *NodeAllocat
 		klog.Errorf("revert fail for node(%s), storage not init by NLS", reservedAllocateState.NodeName)
 		return
 	}
-	c.lvmPVAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.Units)
-	c.inlineVolumeAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.PodUid, reservedAllocateState.Units.InlineVolumeAllocateUnits)
-	c.deviceAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.Units)
+	c.unreserve(reservedAllocateState)
 	if reservationPodUid != "" {
 		err := c.inlineVolumeAllocator.reserve(reservedAllocateState.NodeName, reservationPodUid, reservationPodUnits)
 		klog.Errorf("reserve reservationPod(%s) fail : ", reservationPodUid, err)
 	}
 }
 
+func (c *NodeStorageAllocatedCache) unreserve(reservedAllocateState *NodeAllocateState) {
+	c.lvmPVAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.Units)
+	c.inlineVolumeAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.PodUid, reservedAllocateState.Units.InlineVolumeAllocateUnits)
+	c.deviceAllocator.unreserve(reservedAllocateState.NodeName, reservedAllocateState.Units)
+	delete(c.reservations, reservedAllocateState.PodUid)
+}
+
+/*
+ReconcileReservations cross-checks outstanding reservations against pods:
+  - pod no longer exists or is scheduled to another node: release the reservation
+  - pod is scheduled to the reserved node: reservation is taken over by pv/pvc/pod events, stop tracking it
+
+returns the number of released reservations
+*/
+func (c *NodeStorageAllocatedCache) ReconcileReservations(podLister corelisters.PodLister) (int, error) {
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		return 0, fmt.Errorf("list pods fail: %s", err.Error())
+	}
+	podsByUid := make(map[string]*corev1.Pod, len(pods))
+	for _, pod := range pods {
+		podsByUid[string(pod.UID)] = pod
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	released := 0
+	for podUid, reserved := range c.reservations {
+		pod, exist := podsByUid[podUid]
+		if exist && pod.Spec.NodeName == "" {
+			continue
+		}
+		if exist && pod.Spec.NodeName == reserved.NodeName {
+			delete(c.reservations, podUid)
+			continue
+		}
+		nodeState, ok := c.states[reserved.NodeName]
+		if !ok || !nodeState.InitedByNLS {
+			delete(c.reservations, podUid)
+			continue
+		}
+		if exist {
+			klog.Infof("release stale reservation of pod(%s) on node(%s), pod is scheduled to node(%s)", podUid, reserved.NodeName, pod.Spec.NodeName)
+		} else {
+			klog.Infof("release stale reservation of pod(%s) on node(%s), pod no longer exists", podUid, reserved.NodeName)
+		}
+		c.unreserve(reserved)
+		released++
+	}
+	return released, nil
+}
+
 func (c *NodeStorageAllocatedCache) AddNodeStorage(nodeLocal *nodelocalstorage.NodeLocalStorage) {
 	c.Lock()
 	defer c.Unlock()
@@ -584,13 +650,12 @@ func allocateVgState(nodeName, vgName string, nodeState *NodeStorageState, size
 		return err
 	}
 
-	// free size
-	poolFreeSize := vgState.Allocatable - vgState.Requested
-	if poolFreeSize < size {
-		return errors.NewInsufficientLVMError(size, int64(vgState.Requested), int64(vgState.Allocatable), vgName, nodeName)
+	if err := vgState.checkLV(nodeName, size); err != nil {
+		return err
 	}
 	// 更新临时 cache
 	vgState.Requested += size
+	vgState.allocateLV()
 	return nil
 }
 
@@ -412,6 +412,7 @@ func Test_UpdatePod(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   int64(10 * utils.LocalGi),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -468,6 +469,7 @@ func Test_UpdatePod(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   int64(10 * utils.LocalGi),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -18,6 +18,7 @@ package cache
 import (
 	localtype "github.com/alibaba/open-local/pkg"
 	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils"
 	"k8s.io/klog/v2"
 )
 
@@ -28,6 +29,8 @@ type DeviceResourcePool struct {
 	Requested   int64
 	MediaType   localtype.MediaType
 	IsAllocated bool
+	// Wiping device is not free even if it is not allocated
+	Wiping bool
 }
 
 func NewDeviceResourcePoolForAllocate(deviceName string) *DeviceResourcePool {
@@ -57,6 +60,7 @@ func (d *DeviceResourcePool) UpdateByNLS(new *DeviceResourcePool) {
 	d.Total = new.Total
 	d.Allocatable = new.Allocatable
 	d.MediaType = new.MediaType
+	d.Wiping = new.Wiping
 	if d.IsAllocated {
 		d.Requested = d.Allocatable
 	}
@@ -73,6 +77,7 @@ func (d *DeviceResourcePool) DeepCopy() *DeviceResourcePool {
 		Requested:   d.Requested,
 		MediaType:   d.MediaType,
 		IsAllocated: d.IsAllocated,
+		Wiping:      d.Wiping,
 	}
 	return copy
 }
@@ -136,6 +141,7 @@ func (h *DeviceHandler) CreateStatesByNodeLocal(nodeLocal *nodelocalstorage.Node
 			continue
 		}
 		diskResource := NewDeviceResourcePoolFromDeviceInfo(device)
+		diskResource.Wiping = utils.IsDeviceWiping(nodeLocal, deviceName)
 		states[deviceName] = diskResource
 		klog.V(6).Infof("initDeviceStorage, add diskResource success: %#v", diskResource)
 	}
@@ -20,7 +20,7 @@ import (
 	"sort"
 
 	localtype "github.com/alibaba/open-local/pkg"
-	"github.com/alibaba/open-local/pkg/scheduler/errors"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 	"github.com/alibaba/open-local/pkg/utils"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
@@ -255,7 +255,7 @@ func (allocator *devicePVAllocator) preAllocate(nodeName string, podVolumeInfos
 
 	var result []PVAllocated
 
-	sddAllocateUnits, err := processDeviceByMediaType(nodeName, devicePVCS.SSDDevicePVCs, freeSSD)
+	sddAllocateUnits, err := processDeviceByMediaType(nodeName, localtype.MediaTypeSSD, devicePVCS.SSDDevicePVCs, freeSSD, len(nodeStateClone.DeviceStates))
 	if len(sddAllocateUnits) > 0 {
 		result = append(result, sddAllocateUnits...)
 	}
@@ -263,7 +263,7 @@ func (allocator *devicePVAllocator) preAllocate(nodeName string, podVolumeInfos
 		return result, err
 	}
 
-	hddAllocateUnits, err := processDeviceByMediaType(nodeName, devicePVCS.HDDDevicePVCs, freeHDD)
+	hddAllocateUnits, err := processDeviceByMediaType(nodeName, localtype.MediaTypeHDD, devicePVCS.HDDDevicePVCs, freeHDD, len(nodeStateClone.DeviceStates))
 	if len(hddAllocateUnits) > 0 {
 		result = append(result, hddAllocateUnits...)
 	}
@@ -323,6 +323,11 @@ func (allocator *devicePVAllocator) reserve(nodeName string, nodeUnits *NodeAllo
 			return err
 		}
 
+		if deviceState.Wiping {
+			err := fmt.Errorf("reserveDevicePVC fail, device(%s) is being wiped on node %s", unit.DeviceName, nodeName)
+			return err
+		}
+
 		if deviceState.Allocatable < unit.Requested {
 			err := fmt.Errorf("reserveDevicePVC fail, device(%s) allocatable small than pvc(%s) request on node %s", unit.DeviceName, utils.GetNameKey(unit.PVCNamespace, unit.PVCName), nodeName)
 			return err
@@ -408,6 +413,9 @@ func (allocator *devicePVAllocator) revertDeviceByPVCIfNeed(nodeName, pvcNameSpa
 func getFreeDevice(nodeStateClone *NodeStorageState) (freeDeviceSSD, freeDeviceHDD []*DeviceResourcePool) {
 
 	for _, deviceState := range nodeStateClone.DeviceStates {
+		if deviceState.Wiping {
+			continue
+		}
 		if deviceState.MediaType == localtype.MediaTypeSSD && !deviceState.IsAllocated {
 			freeDeviceSSD = append(freeDeviceSSD, deviceState)
 		} else if deviceState.MediaType == localtype.MediaTypeHDD && !deviceState.IsAllocated {
@@ -418,14 +426,16 @@ func getFreeDevice(nodeStateClone *NodeStorageState) (freeDeviceSSD, freeDeviceH
 	return
 }
 
-func processDeviceByMediaType(nodeName string, devicePVCs []*DevicePVCInfo, freeDeviceStates []*DeviceResourcePool) ([]PVAllocated, error) {
+// processDeviceByMediaType allocates free devices of media type to device
+// pvcs, total is the number of devices on node
+func processDeviceByMediaType(nodeName string, mediaType localtype.MediaType, devicePVCs []*DevicePVCInfo, freeDeviceStates []*DeviceResourcePool, total int) ([]PVAllocated, error) {
 	pvcsCount := len(devicePVCs)
 	if pvcsCount <= 0 {
 		return nil, nil
 	}
 
-	if pvcsCount > len(freeDeviceStates) {
-		return nil, fmt.Errorf("free device not enough for device PVCs")
+	if err := algorithm.CheckExclusiveResourceCount(localtype.VolumeTypeDevice, mediaType, int64(pvcsCount), int64(len(freeDeviceStates)), int64(total), nodeName); err != nil {
+		return nil, err
 	}
 
 	sort.Slice(devicePVCs, func(i, j int) bool {
@@ -435,14 +445,23 @@ func processDeviceByMediaType(nodeName string, devicePVCs []*DevicePVCInfo, free
 		return freeDeviceStates[i].Allocatable < freeDeviceStates[j].Allocatable
 	})
 
-	units := make([]PVAllocated, 0, pvcsCount)
-	i := 0
-	var pvcInfo *DevicePVCInfo
+	requests := make([]int64, 0, pvcsCount)
+	for _, pvcInfo := range devicePVCs {
+		requests = append(requests, pvcInfo.Request)
+	}
+	capacities := make([]int64, 0, len(freeDeviceStates))
 	for _, disk := range freeDeviceStates {
-		pvcInfo = devicePVCs[i]
-		if disk.IsAllocated || disk.Allocatable < pvcInfo.Request {
-			continue
-		}
+		capacities = append(capacities, disk.Allocatable)
+	}
+	assigned, err := algorithm.FitExclusiveResources(localtype.VolumeTypeDevice, requests, capacities)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]PVAllocated, 0, pvcsCount)
+	for i, j := range assigned {
+		pvcInfo := devicePVCs[i]
+		disk := freeDeviceStates[j]
 		//change cache
 		disk.IsAllocated = true
 		disk.Requested = disk.Allocatable
@@ -460,13 +479,6 @@ func processDeviceByMediaType(nodeName string, devicePVCs []*DevicePVCInfo, free
 
 		klog.V(6).Infof("found unit: %#v for pvc %#v", u, utils.PVCName(pvcInfo.PVC))
 		units = append(units, u)
-		i++
-		if i == int(pvcsCount) {
-			return units, nil
-		}
 	}
-	return nil, errors.NewInsufficientExclusiveResourceError(
-		localtype.VolumeTypeDevice,
-		pvcInfo.Request,
-		pvcInfo.Request)
+	return units, nil
 }
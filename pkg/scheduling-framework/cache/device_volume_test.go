@@ -1054,3 +1054,35 @@ func Test_device_pvDelete(t *testing.T) {
 		})
 	}
 }
+
+func Test_device_wiping(t *testing.T) {
+	released := utils.CreateTestNodeLocalStorage3()
+	released.Annotations = map[string]string{localtype.AnnoWipingDevices: "/dev/sdc"}
+	wiped := utils.CreateTestNodeLocalStorage3()
+	units := &NodeAllocateUnits{DevicePVCAllocateUnits: []*DeviceTypePVAllocated{{
+		DeviceName: "/dev/sdc",
+		BasePVAllocated: BasePVAllocated{
+			PVCName:      "pvc-device",
+			PVCNamespace: "default",
+			NodeName:     released.Name,
+			Requested:    int64(100 * utils.LocalGi),
+		},
+	}}}
+
+	cache := CreateTestCache()
+	allocator := NewDevicePVAllocator(cache)
+	// device released with wipe on delete is not free
+	cache.AddNodeStorage(released)
+	assert.True(t, cache.states[released.Name].DeviceStates["/dev/sdc"].Wiping, "device is wiping")
+	freeSSD, freeHDD := getFreeDevice(cache.states[released.Name].DeepCopy())
+	assert.Empty(t, freeSSD, "no free ssd")
+	assert.Empty(t, freeHDD, "wiping device is not free")
+	assert.Error(t, allocator.reserve(released.Name, units), "wiping device is not reserved")
+
+	// and is free once agent verifies it is wiped
+	cache.UpdateNodeStorage(released, wiped)
+	assert.False(t, cache.states[released.Name].DeviceStates["/dev/sdc"].Wiping, "device is wiped")
+	_, freeHDD = getFreeDevice(cache.states[released.Name].DeepCopy())
+	assert.Len(t, freeHDD, 1, "wiped device is free")
+	assert.NoError(t, allocator.reserve(released.Name, units), "wiped device is reserved")
+}
@@ -138,6 +138,7 @@ func (allocator *inlineVolumeAllocator) podAdd(pod *corev1.Pod) {
 				nodeStorageState.VGStates[vgName] = NewVGState(vgName)
 			}
 			nodeStorageState.VGStates[vgName].Requested += size
+			nodeStorageState.VGStates[vgName].allocateLV()
 			podDetails = append(podDetails, allocateInfo)
 		}
 	}
@@ -171,6 +172,7 @@ func (allocator *inlineVolumeAllocator) podDelete(pod *corev1.Pod) {
 	for _, volume := range *podDetails {
 		if vgState, exist := nodeStorageState.VGStates[volume.VgName]; exist {
 			vgState.Requested = vgState.Requested - volume.VolumeSize
+			vgState.releaseLV()
 		}
 	}
 	delete(nodeDetails, string(pod.UID))
@@ -316,6 +318,7 @@ func (allocator *inlineVolumeAllocator) revertInlineVolumesStorage(nodeName, pod
 		}
 
 		vgState.Requested = vgState.Requested - detail.Allocated
+		vgState.releaseLV()
 		klog.V(6).Infof("unreserve for inlineVolume (%#v) success, current vgState: %#v", detail, vgState)
 	}
 
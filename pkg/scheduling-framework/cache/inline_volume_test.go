@@ -89,6 +89,7 @@ func Test_reserveInlineVolumes(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   inlineVolumeNormal.VolumeSize,
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -195,6 +196,7 @@ func Test_reserveInlineVolumes(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   inlineVolumeNormal.VolumeSize,
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
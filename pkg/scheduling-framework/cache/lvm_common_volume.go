@@ -17,11 +17,14 @@ package cache
 
 import (
 	"fmt"
+	"sort"
 
 	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
 	"github.com/alibaba/open-local/pkg/utils"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/klog/v2"
 )
@@ -34,6 +37,10 @@ type LVMPVCInfo struct {
 	VGName  string
 	Request int64
 	PVC     *corev1.PersistentVolumeClaim
+	// RequireContiguous is true if lv must be allocated from contiguous free space
+	RequireContiguous bool
+	// VGSelector selects vg by labels for pvc without vg name, nil selects all vg
+	VGSelector labels.Selector
 }
 
 var _ PVCInfos = &LVMCommonPVCInfos{}
@@ -41,6 +48,8 @@ var _ PVCInfos = &LVMCommonPVCInfos{}
 type LVMCommonPVCInfos struct {
 	LVMPVCsWithVgNameNotAllocated    []*LVMPVCInfo //local lvm PVC have vgName and  had not allocated before
 	LVMPVCsWithoutVgNameNotAllocated []*LVMPVCInfo //local lvm PVC have no vgName and had not allocated before
+	// SpreadByVG is true if every pvc of pod must be allocated in a distinct vg
+	SpreadByVG bool
 }
 
 func NewLVMCommonPVCInfos() *LVMCommonPVCInfos {
@@ -60,6 +69,8 @@ func (info *LVMCommonPVCInfos) HaveLocalVolumes() bool {
 type LVMPVAllocated struct {
 	BasePVAllocated
 	VGName string
+	// SnapshotReserved is snapshot headroom reserved in vg, included in Requested
+	SnapshotReserved int64
 }
 
 // pv bounding status: have pvcName, other status may have no pvcName
@@ -76,8 +87,9 @@ func NewLVMPVAllocatedFromPV(pv *corev1.PersistentVolume, vgName, nodeName strin
 		return allocated
 	}
 
-	allocated.Requested = request.Value()
-	allocated.Allocated = request.Value()
+	allocated.SnapshotReserved = utils.GetSnapshotReservedSizeFromCsiPV(pv)
+	allocated.Requested = request.Value() + allocated.SnapshotReserved
+	allocated.Allocated = allocated.Requested
 
 	pvcName, pvcNamespace := utils.PVCNameFromPV(pv)
 	if pvcName != "" {
@@ -103,8 +115,9 @@ func (lvm *LVMPVAllocated) DeepCopy() PVAllocated {
 		return nil
 	}
 	return &LVMPVAllocated{
-		BasePVAllocated: *lvm.BasePVAllocated.DeepCopy(),
-		VGName:          lvm.VGName,
+		BasePVAllocated:  *lvm.BasePVAllocated.DeepCopy(),
+		VGName:           lvm.VGName,
+		SnapshotReserved: lvm.SnapshotReserved,
 	}
 }
 
@@ -149,6 +162,10 @@ func (allocator *lvmCommonPVAllocator) pvcAdd(nodeName string, pvc *corev1.Persi
 	}
 
 	maxRequest := utils.GetPVCRequested(pvc)
+	// snapshot headroom reserved when provisioning stays after expansion
+	if lvmAllocated, ok := oldPVDetail.(*LVMPVAllocated); ok {
+		maxRequest += lvmAllocated.SnapshotReserved
+	}
 	//max(pvcRequest,pvRequest)
 	if maxRequest < oldPVDetail.GetBasePVAllocated().Requested {
 		maxRequest = oldPVDetail.GetBasePVAllocated().Requested
@@ -239,6 +256,9 @@ func (allocator *lvmCommonPVAllocator) pvAdd(nodeName string, pv *corev1.Persist
 
 	// 	resolve allocated duplicate for allocate pvc by scheduler
 	allocator.revertIfNeed(nodeName, new.PVCNamespace, new.PVCName)
+	if old == nil && utils.IsLVOfVG(pv, vgName) {
+		vgState.allocateLV()
+	}
 
 	//request:pvc 100Gi pv 200Gi,allocate: 200Gi => request:pvc 100Gi pv 100Gi,allocate: 100Gi can success
 	if deltaAllocate == 0 {
@@ -273,6 +293,9 @@ func (allocator *lvmCommonPVAllocator) pvDelete(nodeName string, pv *corev1.Pers
 	}
 
 	allocator.updateVGRequestByDelta(nodeName, vgName, -old.GetBasePVAllocated().Allocated)
+	if utils.IsLVOfVG(pv, vgName) {
+		allocator.cache.states[nodeName].VGStates[vgName].releaseLV()
+	}
 	allocator.cache.pvAllocatedDetails.DeleteByPV(old)
 }
 
@@ -287,10 +310,29 @@ func (allocator *lvmCommonPVAllocator) prefilter(scLister storagelisters.Storage
 		return error
 	}
 
+	requireContiguous, err := utils.IsContiguousRequiredPVC(lvmPVC, scLister)
+	if err != nil {
+		return fmt.Errorf("get contiguous requirement from PVC(%s) error: %s", utils.PVCName(lvmPVC), err.Error())
+	}
+
+	request, err := utils.GetLVMPVCAllocatedSize(lvmPVC, scLister)
+	if err != nil {
+		return fmt.Errorf("get allocated size from PVC(%s) error: %s", utils.PVCName(lvmPVC), err.Error())
+	}
+
+	vgSelector, err := utils.GetVGSelectorFromPVC(lvmPVC, scLister)
+	if err != nil {
+		return fmt.Errorf("get vg selector from PVC(%s) error: %s", utils.PVCName(lvmPVC), err.Error())
+	}
+
 	lvmPVCInfo := &LVMPVCInfo{
-		PVC:     lvmPVC,
-		Request: utils.GetPVCRequested(lvmPVC),
-		VGName:  vgName,
+		PVC:               lvmPVC,
+		Request:           request,
+		VGName:            vgName,
+		RequireContiguous: requireContiguous,
+	}
+	if !vgSelector.Empty() {
+		lvmPVCInfo.VGSelector = vgSelector
 	}
 
 	if podVolumeInfos.LVMPVCsNotROSnapshot == nil {
@@ -320,13 +362,26 @@ func (allocator *lvmCommonPVAllocator) preAllocate(nodeName string, podVolumeInf
 	}
 
 	infos := podVolumeInfos.LVMPVCsNotROSnapshot
+	// vg taken by volumes of pod, which is excluded if pod spreads its volumes
+	usedVGs := make(map[string]bool)
 
 	for _, pvcInfo := range infos.LVMPVCsWithVgNameNotAllocated {
+		if infos.SpreadByVG && usedVGs[pvcInfo.VGName] {
+			return allocateUnits, errors.NewVolumeSpreadError(utils.PVCName(pvcInfo.PVC), nodeName)
+		}
 
 		err := allocateVgState(nodeName, pvcInfo.VGName, nodeStateClone, pvcInfo.Request)
 		if err != nil {
 			return allocateUnits, err
 		}
+		if pvcInfo.RequireContiguous {
+			vgState := nodeStateClone.VGStates[pvcInfo.VGName]
+			if err := vgState.checkContiguous(nodeName, pvcInfo.Request); err != nil {
+				return allocateUnits, err
+			}
+			vgState.allocateContiguous(pvcInfo.Request)
+		}
+		usedVGs[pvcInfo.VGName] = true
 
 		allocateUnits = append(allocateUnits, &LVMPVAllocated{
 			BasePVAllocated: BasePVAllocated{
@@ -350,18 +405,68 @@ func (allocator *lvmCommonPVAllocator) preAllocate(nodeName string, podVolumeInf
 		vgStateList = append(vgStateList, nodeStateClone.VGStates[key])
 	}
 
+	pvcInfos := infos.LVMPVCsWithoutVgNameNotAllocated
+	if infos.SpreadByVG {
+		pvcInfos = sortPVCInfosByRequest(pvcInfos)
+	}
 	// process pvcsWithoutVG
-	for _, pvcInfo := range infos.LVMPVCsWithoutVgNameNotAllocated {
-
-		allocateUnit, err := allocator.scheduleStrategy.AllocateForPVCWithoutVgName(nodeName, &vgStateList, pvcInfo)
+	for _, pvcInfo := range pvcInfos {
+		candidates := matchingVGStates(vgStateList, pvcInfo.VGSelector)
+		if len(candidates) == 0 {
+			return allocateUnits, errors.NewNoMatchingVGError(pvcInfo.VGSelector.String(), nodeName)
+		}
+		if infos.SpreadByVG {
+			candidates = excludeVGStates(candidates, usedVGs)
+			if len(candidates) == 0 {
+				return allocateUnits, errors.NewVolumeSpreadError(utils.PVCName(pvcInfo.PVC), nodeName)
+			}
+		}
+		allocateUnit, err := allocator.scheduleStrategy.AllocateForPVCWithoutVgName(nodeName, &candidates, pvcInfo)
 		if err != nil {
 			return allocateUnits, err
 		}
+		usedVGs[allocateUnit.VGName] = true
 		allocateUnits = append(allocateUnits, allocateUnit)
 	}
 	return allocateUnits, nil
 }
 
+// excludeVGStates returns vg not in used
+func excludeVGStates(vgStates []*VGStoragePool, used map[string]bool) []*VGStoragePool {
+	left := make([]*VGStoragePool, 0, len(vgStates))
+	for _, vg := range vgStates {
+		if !used[vg.Name] {
+			left = append(left, vg)
+		}
+	}
+	return left
+}
+
+// sortPVCInfosByRequest returns pvc infos with larger request first, a large
+// volume has fewer vg to choose from when volumes are spread
+func sortPVCInfosByRequest(pvcInfos []*LVMPVCInfo) []*LVMPVCInfo {
+	sorted := make([]*LVMPVCInfo, len(pvcInfos))
+	copy(sorted, pvcInfos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Request > sorted[j].Request
+	})
+	return sorted
+}
+
+// matchingVGStates returns vg whose labels match selector, all vg if selector is nil
+func matchingVGStates(vgStates []*VGStoragePool, selector labels.Selector) []*VGStoragePool {
+	if selector == nil || selector.Empty() {
+		return vgStates
+	}
+	matched := make([]*VGStoragePool, 0, len(vgStates))
+	for _, vg := range vgStates {
+		if selector.Matches(labels.Set(vg.Labels)) {
+			matched = append(matched, vg)
+		}
+	}
+	return matched
+}
+
 func (allocator *lvmCommonPVAllocator) allocateInfo(detail PVAllocated) *localtype.PVCAllocateInfo {
 	allocateDetail, ok := detail.(*LVMPVAllocated)
 	if !ok {
@@ -418,8 +523,12 @@ func (allocator *lvmCommonPVAllocator) reserve(nodeName string, nodeUnits *NodeA
 			err := fmt.Errorf("reserveLVMPVC fail, volumeGroup(%s) have not enough space for pvc(%s) on node %s", unit.VGName, utils.GetNameKey(unit.PVCNamespace, unit.PVCName), nodeName)
 			return err
 		}
+		if err := vgState.checkNewLV(nodeName); err != nil {
+			return fmt.Errorf("reserveLVMPVC fail, volumeGroup(%s) can not hold new lv for pvc(%s): %s", unit.VGName, utils.GetNameKey(unit.PVCNamespace, unit.PVCName), err.Error())
+		}
 
 		vgState.Requested = vgState.Requested + unit.Requested
+		vgState.allocateLV()
 		unit.Allocated = unit.Requested
 		allocator.cache.pvAllocatedDetails.AssumeByPVC(unit.DeepCopy())
 		klog.V(6).Infof("reserve for lvm pvc unit (%#v) success, current vgState: %#v", unit, vgState)
@@ -482,6 +591,7 @@ func (allocator *lvmCommonPVAllocator) revertIfNeed(nodeName, pvcNameSpace, pvcN
 	}
 
 	vgState.Requested = vgState.Requested - lvmAllocated.Allocated
+	vgState.releaseLV()
 	allocator.cache.pvAllocatedDetails.DeleteByPVC(utils.GetNameKey(pvcNameSpace, pvcName))
 	klog.V(6).Infof("revert for pvc (%s) success, current vgState: %#v", utils.GetNameKey(pvcNameSpace, pvcName), vgState)
 }
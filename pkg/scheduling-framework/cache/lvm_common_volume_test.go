@@ -112,6 +112,7 @@ func Test_lvm_reserve(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVCRequested(pvcsWithVGPending),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -795,6 +796,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -847,6 +849,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -899,6 +902,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       0,
 								Allocatable: 0,
 								Requested:   utils.GetPVSize(pvBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{},
@@ -944,6 +948,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBoundingExpandSmall),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -998,6 +1003,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBoundingLarge),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1062,6 +1068,7 @@ func Test_lvm_pvUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1246,6 +1253,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVCRequested(pvcBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1351,6 +1359,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVCRequested(pvcBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1404,6 +1413,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(0),
 								Allocatable: int64(0),
 								Requested:   utils.GetPVCRequested(pvcBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{},
@@ -1450,6 +1460,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVCRequested(pvcBoundingTooLarge),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1547,6 +1558,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVSize(pvBounding),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1644,6 +1656,7 @@ func Test_lvm_pvcUpdate(t *testing.T) {
 								Total:       int64(300 * utils.LocalGi),
 								Allocatable: int64(300 * utils.LocalGi),
 								Requested:   utils.GetPVCRequested(pvcBoundingExpand),
+								LVCount:     1,
 							},
 						},
 						DeviceStates: map[string]*DeviceResourcePool{
@@ -1850,3 +1863,215 @@ func Test_DeleteLVM(t *testing.T) {
 		})
 	}
 }
+
+func Test_lvm_pvAddDelete_SnapshotReserve(t *testing.T) {
+	nodeLocal := utils.CreateTestNodeLocalStorage3()
+
+	pvcBounding := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCBounding})[0]
+	pvBounding := utils.CreateTestPersistentVolume([]utils.TestPVInfo{*utils.GetTestPVCPVWithVG().PVBounding})[0]
+	pvBounding.Spec.CSI.VolumeAttributes[localtype.ParamSnapshotReservedSize] = fmt.Sprintf("%d", 30*utils.LocalGi)
+
+	cache := CreateTestCache()
+	allocator := NewLVMCommonPVAllocator(cache)
+	cache.AddNodeStorage(nodeLocal)
+	cache.addPVCInfo(pvcBounding)
+
+	// headroom is allocated from vg along with the volume
+	allocator.pvAdd(utils.NodeName3, pvBounding)
+	vgState := cache.states[utils.NodeName3].VGStates[utils.VGSSD]
+	assert.Equal(t, int64(180*utils.LocalGi), vgState.Requested, "check vg requested after pv added")
+	assert.Equal(t, int64(120*utils.LocalGi), vgState.Allocatable-vgState.Requested, "check vg free after pv added")
+	pvDetail := cache.pvAllocatedDetails.GetByPV(pvBounding.Name)
+	assert.NotNil(t, pvDetail)
+	assert.Equal(t, int64(180*utils.LocalGi), pvDetail.GetBasePVAllocated().Allocated, "check pv allocated")
+
+	// bound pvc event keeps headroom
+	allocator.pvcAdd(utils.NodeName3, pvcBounding, pvBounding.Name)
+	assert.Equal(t, int64(180*utils.LocalGi), vgState.Requested, "check vg requested after pvc bound")
+
+	// headroom is released along with the volume
+	allocator.pvDelete(utils.NodeName3, pvBounding)
+	assert.Equal(t, int64(0), vgState.Requested, "check vg requested after pv deleted")
+	assert.Nil(t, cache.pvAllocatedDetails.GetByPV(pvBounding.Name))
+}
+
+func Test_lvm_preAllocate_VGSelector(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	newNodeState := func() *NodeStorageState {
+		return &NodeStorageState{
+			VGStates: VGStates{
+				"vg-fast-small": {Name: "vg-fast-small", Total: 100 * gi, Allocatable: 100 * gi, Labels: map[string]string{"pool": "fast"}},
+				"vg-fast-big":   {Name: "vg-fast-big", Total: 300 * gi, Allocatable: 300 * gi, Labels: map[string]string{"pool": "fast"}},
+				"vg-slow":       {Name: "vg-slow", Total: 50 * gi, Allocatable: 50 * gi, Labels: map[string]string{"pool": "slow"}},
+				"vg-unlabeled":  {Name: "vg-unlabeled", Total: 10 * gi, Allocatable: 10 * gi},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		selector string
+		requests []int64
+		wantVGs  []string
+		wantErr  bool
+	}{
+		{
+			name:     "test no selector picks from all vg",
+			requests: []int64{5 * gi},
+			wantVGs:  []string{"vg-unlabeled"},
+		},
+		{
+			name:     "test selector picks from matching vg only",
+			selector: "pool=fast",
+			requests: []int64{5 * gi, 200 * gi, 90 * gi},
+			wantVGs:  []string{"vg-fast-small", "vg-fast-big", "vg-fast-small"},
+		},
+		{
+			name:     "test set based selector",
+			selector: "pool in (slow,fast)",
+			requests: []int64{20 * gi},
+			wantVGs:  []string{"vg-slow"},
+		},
+		{
+			name:     "test matching vg can not fit",
+			selector: "pool=slow",
+			requests: []int64{60 * gi},
+			wantErr:  true,
+		},
+		{
+			name:     "test no vg matches",
+			selector: "pool=archive",
+			requests: []int64{1 * gi},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := utils.ParseVGSelector(map[string]string{localtype.ParamVGSelector: tt.selector})
+			assert.NoError(t, err)
+			infos := NewLVMCommonPVCInfos()
+			for i, request := range tt.requests {
+				pvcInfo := newStrategyTestPVCInfo(fmt.Sprintf("pvc-%d", i), request)
+				if tt.selector != "" {
+					pvcInfo.VGSelector = selector
+				}
+				infos.LVMPVCsWithoutVgNameNotAllocated = append(infos.LVMPVCsWithoutVgNameNotAllocated, pvcInfo)
+			}
+			allocator := &lvmCommonPVAllocator{scheduleStrategy: NewVGScheduleBinpackStrategy()}
+			units, err := allocator.preAllocate("node-1", &PodLocalVolumeInfo{LVMPVCsNotROSnapshot: infos}, newNodeState())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			gotVGs := []string{}
+			for _, unit := range units {
+				gotVGs = append(gotVGs, unit.(*LVMPVAllocated).VGName)
+			}
+			assert.Equal(t, tt.wantVGs, gotVGs)
+		})
+	}
+}
+
+func Test_lvm_preAllocate_VolumeSpread(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	newNodeState := func(sizes ...int64) *NodeStorageState {
+		state := &NodeStorageState{VGStates: VGStates{}}
+		for i, size := range sizes {
+			name := fmt.Sprintf("vg-%d", i)
+			state.VGStates[name] = &VGStoragePool{Name: name, Total: size * gi, Allocatable: size * gi}
+		}
+		return state
+	}
+	tests := []struct {
+		name     string
+		spread   bool
+		vgSizes  []int64
+		vgNames  []string
+		requests []int64
+		wantErr  bool
+	}{
+		{
+			name:     "test one vg without spread",
+			vgSizes:  []int64{100},
+			requests: []int64{10 * gi, 10 * gi},
+		},
+		{
+			name:     "test one vg",
+			spread:   true,
+			vgSizes:  []int64{100},
+			requests: []int64{10 * gi, 10 * gi},
+			wantErr:  true,
+		},
+		{
+			name:     "test two vg",
+			spread:   true,
+			vgSizes:  []int64{100, 200},
+			requests: []int64{10 * gi, 10 * gi},
+		},
+		{
+			name:     "test three vg",
+			spread:   true,
+			vgSizes:  []int64{100, 200, 300},
+			requests: []int64{10 * gi, 10 * gi},
+		},
+		{
+			name:     "test larger volume allocated first",
+			spread:   true,
+			vgSizes:  []int64{100, 300},
+			requests: []int64{50 * gi, 150 * gi},
+		},
+		{
+			name:     "test two vg but only one fits",
+			spread:   true,
+			vgSizes:  []int64{5, 200},
+			requests: []int64{10 * gi, 10 * gi},
+			wantErr:  true,
+		},
+		{
+			name:     "test vg name taken by other volume",
+			spread:   true,
+			vgSizes:  []int64{100, 200},
+			vgNames:  []string{"vg-1"},
+			requests: []int64{10 * gi},
+		},
+		{
+			name:     "test same vg name of two volumes",
+			spread:   true,
+			vgSizes:  []int64{100, 200},
+			vgNames:  []string{"vg-1", "vg-1"},
+			requests: []int64{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos := NewLVMCommonPVCInfos()
+			infos.SpreadByVG = tt.spread
+			for i, vgName := range tt.vgNames {
+				pvcInfo := newStrategyTestPVCInfo(fmt.Sprintf("pvc-vg-%d", i), 10*gi)
+				pvcInfo.VGName = vgName
+				infos.LVMPVCsWithVgNameNotAllocated = append(infos.LVMPVCsWithVgNameNotAllocated, pvcInfo)
+			}
+			for i, request := range tt.requests {
+				infos.LVMPVCsWithoutVgNameNotAllocated = append(infos.LVMPVCsWithoutVgNameNotAllocated, newStrategyTestPVCInfo(fmt.Sprintf("pvc-%d", i), request))
+			}
+			allocator := &lvmCommonPVAllocator{scheduleStrategy: NewVGScheduleSpreadStrategy()}
+			units, err := allocator.preAllocate("node-1", &PodLocalVolumeInfo{LVMPVCsNotROSnapshot: infos}, newNodeState(tt.vgSizes...))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.vgNames)+len(tt.requests), len(units))
+			if !tt.spread {
+				return
+			}
+			vgs := map[string]bool{}
+			for _, unit := range units {
+				vgName := unit.(*LVMPVAllocated).VGName
+				assert.False(t, vgs[vgName], "vg %s is allocated twice", vgName)
+				vgs[vgName] = true
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 	"github.com/alibaba/open-local/pkg/utils"
 	"k8s.io/klog/v2"
 )
@@ -31,10 +32,10 @@ type VGScheduleStrategy interface {
 }
 
 type vgSortFunc func(vgStateList []*VGStoragePool)
-type vgScoreWeightFunc func(allocatedVG VGStoragePool) float64
 
 var _ VGScheduleStrategy = &vgScheduleBinpackStrategy{}
 var _ VGScheduleStrategy = &vgScheduleSpreadStrategy{}
+var _ VGScheduleStrategy = &vgScheduleConsistentHashStrategy{}
 
 func GetVGScheduleStrategy(strategy pkg.StrategyType) VGScheduleStrategy {
 	switch strategy {
@@ -48,8 +49,7 @@ func GetVGScheduleStrategy(strategy pkg.StrategyType) VGScheduleStrategy {
 }
 
 type vgScheduleBinpackStrategy struct {
-	vgSortFunc      vgSortFunc
-	scoreWeightFunc vgScoreWeightFunc
+	vgSortFunc vgSortFunc
 }
 
 func NewVGScheduleBinpackStrategy() *vgScheduleBinpackStrategy {
@@ -59,9 +59,6 @@ func NewVGScheduleBinpackStrategy() *vgScheduleBinpackStrategy {
 				return (vgStateList[i].Allocatable - vgStateList[i].Requested) < (vgStateList[j].Allocatable - vgStateList[j].Requested)
 			})
 		},
-		scoreWeightFunc: func(allocatedVG VGStoragePool) float64 {
-			return float64(allocatedVG.Requested) / float64(allocatedVG.Allocatable)
-		},
 	}
 }
 
@@ -70,12 +67,11 @@ func (s *vgScheduleBinpackStrategy) AllocateForPVCWithoutVgName(nodeName string,
 }
 
 func (s *vgScheduleBinpackStrategy) ScoreByCapacity(nodeAllocate *NodeAllocateState) (score int64) {
-	return scoreByCapacity(nodeAllocate, s.scoreWeightFunc)
+	return scoreByCapacity(nodeAllocate, pkg.StrategyBinpack)
 }
 
 type vgScheduleSpreadStrategy struct {
-	vgSortFunc      vgSortFunc
-	scoreWeightFunc vgScoreWeightFunc
+	vgSortFunc vgSortFunc
 }
 
 func NewVGScheduleSpreadStrategy() *vgScheduleSpreadStrategy {
@@ -85,9 +81,6 @@ func NewVGScheduleSpreadStrategy() *vgScheduleSpreadStrategy {
 				return (vgStateList[i].Allocatable - vgStateList[i].Requested) > (vgStateList[j].Allocatable - vgStateList[j].Requested)
 			})
 		},
-		scoreWeightFunc: func(allocatedVG VGStoragePool) float64 {
-			return 1.0 - float64(allocatedVG.Requested)/float64(allocatedVG.Allocatable)
-		},
 	}
 }
 
@@ -96,7 +89,47 @@ func (s *vgScheduleSpreadStrategy) AllocateForPVCWithoutVgName(nodeName string,
 }
 
 func (s *vgScheduleSpreadStrategy) ScoreByCapacity(nodeAllocate *NodeAllocateState) (score int64) {
-	return scoreByCapacity(nodeAllocate, s.scoreWeightFunc)
+	return scoreByCapacity(nodeAllocate, pkg.StrategySpread)
+}
+
+// vgScheduleConsistentHashStrategy tries the vg hashed by identity of pvc
+// first, so that retries of the same pvc land on the same vg, and falls back
+// to strategy by free size if the hashed vg can't fit
+type vgScheduleConsistentHashStrategy struct {
+	fallback VGScheduleStrategy
+}
+
+func NewVGScheduleConsistentHashStrategy(fallback VGScheduleStrategy) *vgScheduleConsistentHashStrategy {
+	return &vgScheduleConsistentHashStrategy{
+		fallback: fallback,
+	}
+}
+
+func (s *vgScheduleConsistentHashStrategy) AllocateForPVCWithoutVgName(nodeName string, vgStates *[]*VGStoragePool, pvcInfo *LVMPVCInfo) (*LVMPVAllocated, error) {
+	if vgStates != nil && pvcInfo.PVC != nil {
+		names := make([]string, 0, len(*vgStates))
+		for _, vg := range *vgStates {
+			names = append(names, vg.Name)
+		}
+		hashed := utils.ConsistentHash(utils.PVCName(pvcInfo.PVC), names)
+		for _, vg := range *vgStates {
+			if vg.Name != hashed {
+				continue
+			}
+			candidates := []*VGStoragePool{vg}
+			allocated, err := allocatePVCWithoutVgName(nodeName, &candidates, pvcInfo, func([]*VGStoragePool) {})
+			if err == nil {
+				return allocated, nil
+			}
+			klog.V(4).Infof("hashed vg %s on node %s can't fit pvc %s, fall back to strategy by free size", hashed, nodeName, utils.PVCName(pvcInfo.PVC))
+			break
+		}
+	}
+	return s.fallback.AllocateForPVCWithoutVgName(nodeName, vgStates, pvcInfo)
+}
+
+func (s *vgScheduleConsistentHashStrategy) ScoreByCapacity(nodeAllocate *NodeAllocateState) (score int64) {
+	return s.fallback.ScoreByCapacity(nodeAllocate)
 }
 
 func allocatePVCWithoutVgName(nodeName string, vgStates *[]*VGStoragePool, pvcInfo *LVMPVCInfo, vgSortFunc vgSortFunc) (*LVMPVAllocated, error) {
@@ -114,10 +147,18 @@ func allocatePVCWithoutVgName(nodeName string, vgStates *[]*VGStoragePool, pvcIn
 		poolFreeSize := vg.Allocatable - vg.Requested
 		klog.V(6).Infof("validating node(%s) vg(name=%s,free=%d) for pvc(name=%s,requested=%d)", nodeName, vg.Name, poolFreeSize, utils.PVCName(pvcInfo.PVC), pvcInfo.Request)
 
-		if poolFreeSize < pvcInfo.Request {
+		// vg under maintenance or reaching lv limit is treated as full
+		if vg.checkLV(nodeName, pvcInfo.Request) != nil {
 			continue
 		}
+		if pvcInfo.RequireContiguous {
+			if vg.checkContiguous(nodeName, pvcInfo.Request) != nil {
+				continue
+			}
+			vgStateList[j].allocateContiguous(pvcInfo.Request)
+		}
 		vgStateList[j].Requested += pvcInfo.Request
+		vgStateList[j].allocateLV()
 		return &LVMPVAllocated{
 			BasePVAllocated: BasePVAllocated{
 				PVCName:      pvcInfo.PVC.Name,
@@ -140,7 +181,7 @@ func vgStateListToString(vgStates []*VGStoragePool) string {
 	return info
 }
 
-func scoreByCapacity(nodeAllocate *NodeAllocateState, scoreWeightFunc vgScoreWeightFunc) (score int64) {
+func scoreByCapacity(nodeAllocate *NodeAllocateState, strategy pkg.StrategyType) (score int64) {
 	if nodeAllocate == nil || nodeAllocate.Units == nil || nodeAllocate.NodeStorageAllocatedByUnits == nil {
 		return int64(utils.MinScore)
 	}
@@ -167,15 +208,11 @@ func scoreByCapacity(nodeAllocate *NodeAllocateState, scoreWeightFunc vgScoreWei
 		}
 	}
 
-	// score
-	var scoref float64 = 0
-	count := 0
+	vgs := make([]algorithm.VGCapacity, 0, len(allocateVGs))
 	for _, allocatedVG := range allocateVGs {
-		scoref += scoreWeightFunc(*allocatedVG)
-		count++
+		vgs = append(vgs, allocatedVG.capacity())
 	}
-	score = int64(scoref / float64(count) * float64(utils.MaxScore))
-	return
+	return int64(algorithm.ScoreVGCapacity(strategy, vgs))
 }
 
 func GetDeviceScheduleStrategy(strategy pkg.StrategyType) DeviceScheduleStrategy {
@@ -196,58 +233,36 @@ type DeviceScheduleStrategy interface {
 var _ DeviceScheduleStrategy = &DeviceScheduleBinpackStrategy{}
 var _ DeviceScheduleStrategy = &DeviceScheduleSpreadStrategy{}
 
-type scoreByCountFunc func(allocateCount, freeCount int) (score int64)
-
-type DeviceScheduleBinpackStrategy struct {
-	scoreCountFunc scoreByCountFunc
-}
+type DeviceScheduleBinpackStrategy struct{}
 
 func NewDeviceScheduleBinpackStrategy() *DeviceScheduleBinpackStrategy {
-	return &DeviceScheduleBinpackStrategy{
-		scoreCountFunc: func(allocateCount, freeCount int) (score int64) {
-			return int64(float64(allocateCount) * float64(utils.MaxScore) / float64(freeCount))
-		},
-	}
+	return &DeviceScheduleBinpackStrategy{}
 }
 
 func (s *DeviceScheduleBinpackStrategy) ScoreByCount(nodeAllocate *NodeAllocateState) (score int64) {
-	return scoreDeviceCount(nodeAllocate, s.scoreCountFunc)
-
+	return scoreDeviceCount(nodeAllocate, pkg.StrategyBinpack)
 }
 
-type DeviceScheduleSpreadStrategy struct {
-	scoreCountFunc scoreByCountFunc
-}
+type DeviceScheduleSpreadStrategy struct{}
 
-func NewDeviceScheduleSpreadStrategy() *DeviceScheduleBinpackStrategy {
-	return &DeviceScheduleBinpackStrategy{
-		scoreCountFunc: func(allocateCount, freeCount int) (score int64) {
-			score = int64((1.0 - float64(allocateCount)/float64(freeCount)) * float64(utils.MaxScore))
-			return
-		},
-	}
+func NewDeviceScheduleSpreadStrategy() *DeviceScheduleSpreadStrategy {
+	return &DeviceScheduleSpreadStrategy{}
 }
 
 func (s *DeviceScheduleSpreadStrategy) ScoreByCount(nodeAllocate *NodeAllocateState) (score int64) {
-	return scoreDeviceCount(nodeAllocate, s.scoreCountFunc)
-
+	return scoreDeviceCount(nodeAllocate, pkg.StrategySpread)
 }
 
-func scoreDeviceCount(nodeAllocate *NodeAllocateState, scoreCountFunc scoreByCountFunc) (score int64) {
+func scoreDeviceCount(nodeAllocate *NodeAllocateState, strategy pkg.StrategyType) (score int64) {
 
 	var deviceFreeCountBeforeAllocate = 0
 	for _, state := range nodeAllocate.NodeStorageAllocatedByUnits.DeviceStates {
-		if !state.IsAllocated {
+		if !state.IsAllocated && !state.Wiping {
 			deviceFreeCountBeforeAllocate += 1
 		}
 	}
 	deviceAllocatedCountThisTime := len(nodeAllocate.Units.DevicePVCAllocateUnits)
 	deviceFreeCountBeforeAllocate += deviceAllocatedCountThisTime
 
-	if deviceAllocatedCountThisTime <= 0 || deviceFreeCountBeforeAllocate <= 0 {
-		return int64(utils.MinScore)
-	}
-	score = scoreCountFunc(deviceAllocatedCountThisTime, deviceFreeCountBeforeAllocate)
-	return
-
+	return int64(algorithm.ScoreExclusiveCount(strategy, deviceAllocatedCountThisTime, deviceFreeCountBeforeAllocate))
 }
@@ -0,0 +1,104 @@
+/*
+Copyright 2022/9/14 Alibaba Cloud.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alibaba/open-local/pkg/utils"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newStrategyTestVGStates(free map[string]int64) []*VGStoragePool {
+	vgStates := make([]*VGStoragePool, 0, len(free))
+	for name, size := range free {
+		vgStates = append(vgStates, &VGStoragePool{Name: name, Total: size, Allocatable: size})
+	}
+	return vgStates
+}
+
+func newStrategyTestPVCInfo(name string, request int64) *LVMPVCInfo {
+	return &LVMPVCInfo{
+		Request: request,
+		PVC:     &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}},
+	}
+}
+
+func Test_vgScheduleConsistentHashStrategy(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	vgFree := map[string]int64{"vg-a": 100 * gi, "vg-b": 200 * gi, "vg-c": 300 * gi}
+	vgNames := []string{"vg-a", "vg-b", "vg-c"}
+	strategy := NewVGScheduleConsistentHashStrategy(NewVGScheduleBinpackStrategy())
+
+	hashedToOthers := 0
+	for i := 0; i < 10; i++ {
+		pvcInfo := newStrategyTestPVCInfo(fmt.Sprintf("pvc-%d", i), 10*gi)
+		hashed := utils.ConsistentHash(utils.PVCName(pvcInfo.PVC), vgNames)
+		if hashed != "vg-a" {
+			hashedToOthers++
+		}
+		// repeated creates of the same pvc pick the same vg in spite of order of vgs
+		for retry := 0; retry < 3; retry++ {
+			vgStates := newStrategyTestVGStates(vgFree)
+			allocated, err := strategy.AllocateForPVCWithoutVgName("node-1", &vgStates, pvcInfo)
+			assert.NoError(t, err)
+			assert.Equal(t, hashed, allocated.VGName, "pvc %s retry %d", pvcInfo.PVC.Name, retry)
+			for _, vg := range vgStates {
+				if vg.Name == hashed {
+					assert.Equal(t, 10*gi, int(vg.Requested))
+				} else {
+					assert.Equal(t, 0, int(vg.Requested))
+				}
+			}
+		}
+	}
+	// binpack alone picks vg-a for all of them
+	assert.NotZero(t, hashedToOthers)
+
+	// hashed vg can't fit, fall back to binpack
+	for i := 0; i < 10; i++ {
+		pvcInfo := newStrategyTestPVCInfo(fmt.Sprintf("pvc-%d", i), 150*gi)
+		hashed := utils.ConsistentHash(utils.PVCName(pvcInfo.PVC), vgNames)
+		vgStates := newStrategyTestVGStates(vgFree)
+		allocated, err := strategy.AllocateForPVCWithoutVgName("node-1", &vgStates, pvcInfo)
+		assert.NoError(t, err)
+		if hashed == "vg-a" {
+			assert.Equal(t, "vg-b", allocated.VGName, "pvc %s", pvcInfo.PVC.Name)
+		} else {
+			assert.Equal(t, hashed, allocated.VGName, "pvc %s", pvcInfo.PVC.Name)
+		}
+	}
+
+	// vg under maintenance is treated as full
+	pvcInfo := newStrategyTestPVCInfo("pvc-0", 10*gi)
+	hashed := utils.ConsistentHash(utils.PVCName(pvcInfo.PVC), vgNames)
+	vgStates := newStrategyTestVGStates(vgFree)
+	for _, vg := range vgStates {
+		vg.Maintenance = vg.Name == hashed
+	}
+	allocated, err := strategy.AllocateForPVCWithoutVgName("node-1", &vgStates, pvcInfo)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashed, allocated.VGName)
+
+	// no vg fits at all
+	vgStates = newStrategyTestVGStates(vgFree)
+	_, err = strategy.AllocateForPVCWithoutVgName("node-1", &vgStates, newStrategyTestPVCInfo("pvc-0", 400*gi))
+	assert.Error(t, err)
+}
@@ -17,6 +17,9 @@ package cache
 
 import (
 	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/scheduler/errors"
+	"github.com/alibaba/open-local/pkg/utils"
 	"k8s.io/klog/v2"
 )
 
@@ -25,6 +28,21 @@ type VGStoragePool struct {
 	Total       int64
 	Allocatable int64
 	Requested   int64
+	// LVCount is the number of open-local lv in VG
+	LVCount int64
+	// PreallocatedLVs is the number of unassigned preallocated lv in VG
+	PreallocatedLVs int64
+	// LVLimit is the maximum number of open-local lv in VG, 0 means unlimited
+	LVLimit int64
+	// Maintenance is true if VG is under maintenance
+	Maintenance bool
+	// LargestFreeRun is the size of the largest contiguous free space in VG, 0 means unknown
+	LargestFreeRun int64
+	// MetadataFree and MetadataSize are usage of VG metadata area, 0 size means unknown
+	MetadataFree uint64
+	MetadataSize uint64
+	// Labels are labels of VG in nls spec, matched by vg selector of storage class
+	Labels map[string]string
 }
 
 func NewVGState(vgName string) *VGStoragePool {
@@ -33,8 +51,21 @@ func NewVGState(vgName string) *VGStoragePool {
 	}
 }
 
-func NewVGStateFromVGInfo(vgInfo nodelocalstorage.VolumeGroup) *VGStoragePool {
-	return &VGStoragePool{Name: vgInfo.Name, Total: int64(vgInfo.Total), Allocatable: int64(vgInfo.Allocatable), Requested: 0}
+func NewVGStateFromVGInfo(vgInfo nodelocalstorage.VolumeGroup, nodeLocal *nodelocalstorage.NodeLocalStorage) *VGStoragePool {
+	return &VGStoragePool{
+		Name:            vgInfo.Name,
+		Total:           int64(vgInfo.Total),
+		Allocatable:     int64(utils.GetVGAllocatable(nodeLocal, vgInfo)),
+		Requested:       0,
+		LVCount:         0,
+		LVLimit:         int64(nodeLocal.Spec.ListConfig.VGs.MaxLogicalVolumes),
+		PreallocatedLVs: utils.GetPreallocatedLVs(nodeLocal)[vgInfo.Name].Count,
+		Maintenance:     utils.IsVGInMaintenance(nodeLocal, vgInfo.Name),
+		LargestFreeRun:  int64(vgInfo.LargestFreeExtentRun),
+		MetadataFree:    vgInfo.MetadataFree,
+		MetadataSize:    vgInfo.MetadataSize,
+		Labels:          utils.GetVGLabels(nodeLocal, vgInfo.Name),
+	}
 }
 
 func (vg *VGStoragePool) UpdateByNLS(new *VGStoragePool) {
@@ -43,6 +74,91 @@ func (vg *VGStoragePool) UpdateByNLS(new *VGStoragePool) {
 	}
 	vg.Total = new.Total
 	vg.Allocatable = new.Allocatable
+	// lv count is kept by allocations along with requested size, lv count
+	// reported by agent lags behind them
+	vg.LVLimit = new.LVLimit
+	vg.PreallocatedLVs = new.PreallocatedLVs
+	vg.Maintenance = new.Maintenance
+	vg.LargestFreeRun = new.LargestFreeRun
+	vg.MetadataFree = new.MetadataFree
+	vg.MetadataSize = new.MetadataSize
+	vg.Labels = new.Labels
+}
+
+// allocateLV counts a lv to be created in VG
+func (vg *VGStoragePool) allocateLV() {
+	if vg != nil {
+		vg.LVCount++
+	}
+}
+
+// releaseLV uncounts a lv of VG which is reverted or deleted
+func (vg *VGStoragePool) releaseLV() {
+	if vg != nil && vg.LVCount > 0 {
+		vg.LVCount--
+	}
+}
+
+// capacity returns the state of VG deciding whether a new lv fits in it,
+// checked and scored the same way as scheduler extender
+func (vg *VGStoragePool) capacity() algorithm.VGCapacity {
+	return algorithm.VGCapacity{
+		Name:            vg.Name,
+		Allocatable:     vg.Allocatable,
+		Requested:       vg.Requested,
+		LVCount:         vg.LVCount,
+		PreallocatedLVs: vg.PreallocatedLVs,
+		LVLimit:         vg.LVLimit,
+		Maintenance:     vg.Maintenance,
+		MetadataFree:    vg.MetadataFree,
+		MetadataSize:    vg.MetadataSize,
+	}
+}
+
+// checkNewLV returns error if no new lv can be created in VG, which is
+// under maintenance, has full metadata area or holds as many lvs as the limit
+func (vg *VGStoragePool) checkNewLV(nodeName string) error {
+	if vg == nil {
+		return nil
+	}
+	return algorithm.CheckVGForNewLV(vg.capacity(), nodeName)
+}
+
+// checkLV returns error if a new lv of size does not fit in VG
+func (vg *VGStoragePool) checkLV(nodeName string, size int64) error {
+	return algorithm.CheckVGFitsLV(vg.capacity(), size, nodeName)
+}
+
+// checkContiguous returns error if VG has no contiguous free space for a lv of size
+func (vg *VGStoragePool) checkContiguous(nodeName string, size int64) error {
+	if vg == nil || vg.LargestFreeRun <= 0 {
+		return nil
+	}
+	if vg.LargestFreeRun < size {
+		return errors.NewInsufficientContiguousLVMError(size, vg.LargestFreeRun, vg.Name, nodeName)
+	}
+	return nil
+}
+
+// allocateContiguous counts a lv of size to be created in the largest
+// contiguous free space, which is the worst case for other lvs
+func (vg *VGStoragePool) allocateContiguous(size int64) {
+	if vg == nil || vg.LargestFreeRun <= 0 {
+		return
+	}
+	vg.LargestFreeRun -= size
+	if vg.LargestFreeRun <= 0 {
+		// keep it known as full rather than unknown
+		vg.LargestFreeRun = 1
+	}
+}
+
+// IsLVLimitReached returns true if no more lv can be created in VG
+func (vg *VGStoragePool) IsLVLimitReached() bool {
+	if vg == nil {
+		return false
+	}
+	return utils.IsVGLVLimitReached(vg.LVCount+vg.PreallocatedLVs, vg.LVLimit)
 }
 
 func (vg *VGStoragePool) DeepCopy() *VGStoragePool {
@@ -50,10 +166,23 @@ func (vg *VGStoragePool) DeepCopy() *VGStoragePool {
 		return nil
 	}
 	copy := &VGStoragePool{
-		Name:        vg.Name,
-		Total:       vg.Total,
-		Allocatable: vg.Allocatable,
-		Requested:   vg.Requested,
+		Name:            vg.Name,
+		Total:           vg.Total,
+		Allocatable:     vg.Allocatable,
+		Requested:       vg.Requested,
+		LVCount:         vg.LVCount,
+		PreallocatedLVs: vg.PreallocatedLVs,
+		LVLimit:         vg.LVLimit,
+		Maintenance:     vg.Maintenance,
+		LargestFreeRun:  vg.LargestFreeRun,
+		MetadataFree:    vg.MetadataFree,
+		MetadataSize:    vg.MetadataSize,
+	}
+	if vg.Labels != nil {
+		copy.Labels = make(map[string]string, len(vg.Labels))
+		for k, v := range vg.Labels {
+			copy.Labels[k] = v
+		}
 	}
 	return copy
 }
@@ -102,7 +231,7 @@ func (h *VGHandler) CreateStatesByNodeLocal(nodeLocal *nodelocalstorage.NodeLoca
 			continue
 		}
 
-		vgResource := NewVGStateFromVGInfo(vgInfo)
+		vgResource := NewVGStateFromVGInfo(vgInfo, nodeLocal)
 		states[vgName] = vgResource
 		klog.V(6).Infof("initVGStorage, add vgResource success: %#v", vgResource)
 	}
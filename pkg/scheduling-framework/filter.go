@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm/algo"
 	"github.com/alibaba/open-local/pkg/scheduler/errors"
 	"github.com/alibaba/open-local/pkg/scheduling-framework/cache"
@@ -29,6 +30,7 @@ import (
 
 func (plugin *LocalPlugin) getPodLocalVolumeInfos(pod *corev1.Pod) (*cache.PodLocalVolumeInfo, error) {
 	volumeInfos := cache.NewPodLocalVolumeInfo()
+	volumeInfos.LVMPVCsNotROSnapshot.SpreadByVG = pkg.IsVolumeSpreadByVG(pod)
 	//inlineVolume
 	err := plugin.cache.PrefilterInlineVolumes(pod, volumeInfos)
 	if err != nil {
@@ -66,6 +68,15 @@ func (plugin *LocalPlugin) getPodLocalVolumeInfos(pod *corev1.Pod) (*cache.PodLo
 	return volumeInfos, nil
 }
 
+// nodeFilter returns checks of node shared with predicate of scheduler extender
+func (plugin *LocalPlugin) nodeFilter() *algorithm.NodeFilter {
+	return &algorithm.NodeFilter{
+		NLSLister:       plugin.localInformers.NodeLocalStorages().Lister(),
+		SCLister:        plugin.scLister,
+		StalenessWindow: plugin.nodeStorageStalenessWindow,
+	}
+}
+
 func (plugin *LocalPlugin) getInlineVolumeAllocates(pod *corev1.Pod) ([]*cache.InlineVolumeAllocated, error) {
 	var inlineVolumeAllocates []*cache.InlineVolumeAllocated
 
@@ -17,17 +17,24 @@ package plugin
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/alibaba/open-local/pkg"
 	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/predicates"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm/priorities"
 	"github.com/alibaba/open-local/pkg/scheduling-framework/cache"
 	"github.com/alibaba/open-local/pkg/utils"
 	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
 )
 
@@ -450,6 +457,7 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithVGPending),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -488,6 +496,7 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithVGPending),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -580,6 +589,7 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 										Total:       int64(500 * utils.LocalGi),
 										Allocatable: int64(500 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVGPending),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{},
@@ -612,6 +622,7 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVGPending),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -650,6 +661,7 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVGPending),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -717,6 +729,942 @@ func Test_Filter_LVMPVC_NotROSnapshot(t *testing.T) {
 	}
 }
 
+func Test_Filter_LVMPVC_LVLimit(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	podWithoutVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithoutVG().PVCPending,
+		},
+	})
+	pvcWithVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+	pvcWithoutVG := utils.GetTestPVCPVWithoutVG()
+	pvcWithoutVG.PVCPending.Size = "160Gi"
+	pvcWithoutVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*pvcWithoutVG.PVCPending})[0]
+
+	type args struct {
+		pod *corev1.Pod
+	}
+	type fields struct {
+		pvcs map[string]*corev1.PersistentVolumeClaim
+		// lv limit and number of existing lvm pvs of vg ssd on NodeName2
+		lvLimit int
+		lvCount int
+	}
+
+	tests := []struct {
+		name          string
+		args          args
+		fields        fields
+		expectStatus  framework.Code
+		expectVG      string
+		expectLVCount int64
+	}{
+		{
+			name: "test pod with pvc use sc have vg, vg below lv limit",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithVGPending): pvcWithVGPending,
+				},
+				lvLimit: 3,
+				lvCount: 2,
+			},
+			expectStatus:  framework.Success,
+			expectVG:      utils.VGSSD,
+			expectLVCount: 3,
+		},
+		{
+			name: "test pod with pvc use sc have vg, vg reaches lv limit",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithVGPending): pvcWithVGPending,
+				},
+				lvLimit: 3,
+				lvCount: 3,
+			},
+			expectStatus: framework.Unschedulable,
+		},
+		{
+			name: "test pod with pvc use sc without vg, vg reaches lv limit",
+			args: args{
+				pod: podWithoutVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithoutVGPending): pvcWithoutVGPending,
+				},
+				lvLimit: 3,
+				lvCount: 3,
+			},
+			expectStatus:  framework.Success,
+			expectVG:      utils.VGHDD,
+			expectLVCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			for _, pvc := range tt.fields.pvcs {
+				_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+				_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			}
+			oldNLS := utils.CreateTestNodeLocalStorage2()
+			newNLS := oldNLS.DeepCopy()
+			newNLS.Spec.ListConfig.VGs.MaxLogicalVolumes = tt.fields.lvLimit
+			// lv count reported by agent lags behind pvs and is not counted
+			for i := range newNLS.Status.NodeStorageInfo.VolumeGroups {
+				newNLS.Status.NodeStorageInfo.VolumeGroups[i].LogicalVolumeCount = 100
+			}
+			plugin.OnNodeLocalStorageUpdate(oldNLS, newNLS)
+			for i := 0; i < tt.fields.lvCount; i++ {
+				pv := utils.CreateTestPersistentVolume([]utils.TestPVInfo{{
+					VolumeName: fmt.Sprintf("pv-existing-%d", i),
+					VolumeSize: "1Gi",
+					VolumeType: string(localtype.VolumeTypeLVM),
+					VgName:     utils.VGSSD,
+					NodeName:   utils.NodeName2,
+					PVStatus:   corev1.VolumeBound,
+					IsLocalPV:  true,
+				}})[0]
+				plugin.OnPVAdd(pv)
+			}
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, tt.args.pod)
+
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				gotStatus := plugin.Filter(context.Background(), cycleState, tt.args.pod, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			allocateState, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			if tt.expectVG == "" {
+				assert.False(t, exist)
+				return
+			}
+			assert.True(t, exist)
+			assert.Equal(t, tt.expectVG, allocateState.Units.LVMPVCAllocateUnits[0].VGName)
+			assert.Equal(t, tt.expectLVCount, allocateState.NodeStorageAllocatedByUnits.VGStates[tt.expectVG].LVCount)
+		})
+	}
+}
+
+func Test_Filter_LVMPVC_Maintenance(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	podWithoutVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithoutVG().PVCPending,
+		},
+	})
+	pvcWithVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+	pvcWithoutVG := utils.GetTestPVCPVWithoutVG()
+	pvcWithoutVG.PVCPending.Size = "160Gi"
+	pvcWithoutVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*pvcWithoutVG.PVCPending})[0]
+
+	type args struct {
+		pod *corev1.Pod
+	}
+	type fields struct {
+		pvcs map[string]*corev1.PersistentVolumeClaim
+		// vgs under maintenance on NodeName2
+		maintenance []string
+	}
+
+	tests := []struct {
+		name         string
+		args         args
+		fields       fields
+		expectStatus framework.Code
+		expectVG     string
+	}{
+		{
+			name: "test pod with pvc use sc have vg, vg not under maintenance",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithVGPending): pvcWithVGPending,
+				},
+				maintenance: []string{utils.VGHDD},
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGSSD,
+		},
+		{
+			name: "test pod with pvc use sc have vg, vg under maintenance",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithVGPending): pvcWithVGPending,
+				},
+				maintenance: []string{utils.VGSSD},
+			},
+			expectStatus: framework.Unschedulable,
+		},
+		{
+			name: "test pod with pvc use sc without vg, vg under maintenance",
+			args: args{
+				pod: podWithoutVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithoutVGPending): pvcWithoutVGPending,
+				},
+				maintenance: []string{utils.VGSSD},
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGHDD,
+		},
+		{
+			name: "test pod with pvc use sc without vg, all vgs under maintenance",
+			args: args{
+				pod: podWithoutVG,
+			},
+			fields: fields{
+				pvcs: map[string]*corev1.PersistentVolumeClaim{
+					utils.PVCName(pvcWithoutVGPending): pvcWithoutVGPending,
+				},
+				maintenance: []string{utils.VGSSD, utils.VGHDD},
+			},
+			expectStatus: framework.Unschedulable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			for _, pvc := range tt.fields.pvcs {
+				_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+				_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			}
+			oldNLS := utils.CreateTestNodeLocalStorage2()
+			newNLS := oldNLS.DeepCopy()
+			newNLS.Spec.ListConfig.VGs.Maintenance = tt.fields.maintenance
+			plugin.OnNodeLocalStorageUpdate(oldNLS, newNLS)
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, tt.args.pod)
+
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				gotStatus := plugin.Filter(context.Background(), cycleState, tt.args.pod, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			allocateState, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			if tt.expectVG == "" {
+				assert.False(t, exist)
+				return
+			}
+			assert.True(t, exist)
+			assert.Equal(t, tt.expectVG, allocateState.Units.LVMPVCAllocateUnits[0].VGName)
+		})
+	}
+}
+
+func Test_Filter_NodeStorageStale(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	pvcWithVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+	podWithoutLocalPVC := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutLocalPVC",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+	})
+
+	tests := []struct {
+		name         string
+		pod          *corev1.Pod
+		window       time.Duration
+		heartbeats   []time.Duration
+		expectStatus []framework.Code
+	}{
+		{
+			name:         "test stale node storage blocks provisioning until discovery resumes",
+			pod:          podWithVG,
+			window:       2 * time.Minute,
+			heartbeats:   []time.Duration{10 * time.Minute, 0},
+			expectStatus: []framework.Code{framework.Unschedulable, framework.Success},
+		},
+		{
+			name:         "test node storage within staleness window",
+			pod:          podWithVG,
+			window:       2 * time.Minute,
+			heartbeats:   []time.Duration{time.Minute},
+			expectStatus: []framework.Code{framework.Success},
+		},
+		{
+			name:         "test staleness check disabled",
+			pod:          podWithVG,
+			window:       0,
+			heartbeats:   []time.Duration{10 * time.Minute},
+			expectStatus: []framework.Code{framework.Success},
+		},
+		{
+			name:         "test pod without local pvc on stale node",
+			pod:          podWithoutLocalPVC,
+			window:       2 * time.Minute,
+			heartbeats:   []time.Duration{10 * time.Minute},
+			expectStatus: []framework.Code{framework.Success},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			plugin.nodeStorageStalenessWindow = tt.window
+			nodeInfos := prepare(plugin)
+			_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvcWithVGPending.Namespace).Create(context.Background(), pvcWithVGPending, metav1.CreateOptions{})
+			_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvcWithVGPending)
+
+			for i, age := range tt.heartbeats {
+				// discovery refreshes heartbeat of nls on NodeName2
+				nls := utils.CreateTestNodeLocalStorage2()
+				heartbeat := metav1.NewTime(time.Now().Add(-age))
+				nls.Status.NodeStorageInfo.State.LastHeartbeatTime = &heartbeat
+				_ = plugin.localInformers.NodeLocalStorages().Informer().GetIndexer().Update(nls)
+
+				cycleState := framework.NewCycleState()
+				plugin.PreFilter(context.Background(), cycleState, tt.pod)
+				for _, node := range nodeInfos {
+					if node.Node().Name != utils.NodeName2 {
+						continue
+					}
+					gotStatus := plugin.Filter(context.Background(), cycleState, tt.pod, node)
+					assert.Equal(t, tt.expectStatus[i], gotStatus.Code(), "heartbeat %s ago", age)
+				}
+			}
+		})
+	}
+}
+
+func Test_Filter_NodeDomain(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	const domainKey = "topology.open-local.io/power-domain"
+
+	tests := []struct {
+		name           string
+		scSelector     string
+		pvcSelector    string
+		nodeLabels     map[string]string
+		expectStatus   framework.Code
+		expectDomain   string
+		expectAllocate bool
+	}{
+		{
+			name:           "test no domain required",
+			nodeLabels:     map[string]string{domainKey: "pd-b"},
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+		{
+			name:           "test node in required domain",
+			scSelector:     domainKey + "=pd-a",
+			nodeLabels:     map[string]string{domainKey: "pd-a"},
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+		{
+			name:         "test node in other domain is filtered with enough capacity",
+			scSelector:   domainKey + " in (pd-a,pd-c)",
+			nodeLabels:   map[string]string{domainKey: "pd-b"},
+			expectStatus: framework.Unschedulable,
+			expectDomain: domainKey + "=pd-b",
+		},
+		{
+			name:         "test node without domain label",
+			scSelector:   domainKey + "=pd-a",
+			expectStatus: framework.Unschedulable,
+			expectDomain: domainKey + "=<none>",
+		},
+		{
+			name:           "test pvc annotation overrides storage class",
+			scSelector:     domainKey + "=pd-a",
+			pvcSelector:    domainKey + "=pd-b",
+			nodeLabels:     map[string]string{domainKey: "pd-b"},
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+			if tt.pvcSelector != "" {
+				pvc.Annotations = map[string]string{localtype.ParamDomainSelector: tt.pvcSelector}
+			}
+			_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+			_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			if tt.scSelector != "" {
+				sc, err := plugin.scLister.Get(*pvc.Spec.StorageClassName)
+				assert.NoError(t, err)
+				sc = sc.DeepCopy()
+				sc.Parameters[localtype.ParamDomainSelector] = tt.scSelector
+				_ = plugin.storageV1Informers.StorageClasses().Informer().GetIndexer().Update(sc)
+			}
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, podWithVG)
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				node.Node().Labels = tt.nodeLabels
+				gotStatus := plugin.Filter(context.Background(), cycleState, podWithVG, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+				if tt.expectDomain != "" {
+					assert.Contains(t, gotStatus.Message(), "in domain "+tt.expectDomain)
+				}
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			_, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			assert.Equal(t, tt.expectAllocate, exist)
+		})
+	}
+}
+
+func Test_Filter_StorageClassUnserviceable(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+
+	tests := []struct {
+		name           string
+		storageClasses *localv1alpha1.StorageClassServiceability
+		expectStatus   framework.Code
+		expectAllocate bool
+	}{
+		{
+			name:           "test not reported by agent",
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+		{
+			name:           "test serviceable",
+			storageClasses: &localv1alpha1.StorageClassServiceability{Serviceable: []string{utils.SCLVMWithVG}},
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+		{
+			name:           "test not matched by agent yet",
+			storageClasses: &localv1alpha1.StorageClassServiceability{Unserviceable: []string{utils.SCWithDevice}},
+			expectStatus:   framework.Success,
+			expectAllocate: true,
+		},
+		{
+			name:           "test unserviceable is filtered with enough capacity",
+			storageClasses: &localv1alpha1.StorageClassServiceability{Unserviceable: []string{utils.SCLVMWithVG}},
+			expectStatus:   framework.Unschedulable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+			_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+			_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			nls, err := plugin.localInformers.NodeLocalStorages().Lister().Get(utils.NodeName2)
+			assert.NoError(t, err)
+			nls = nls.DeepCopy()
+			nls.Status.NodeStorageInfo.StorageClasses = tt.storageClasses
+			_ = plugin.localInformers.NodeLocalStorages().Informer().GetIndexer().Update(nls)
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, podWithVG)
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				gotStatus := plugin.Filter(context.Background(), cycleState, podWithVG, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+				if tt.expectStatus == framework.Unschedulable {
+					assert.Contains(t, gotStatus.Message(), "storage class "+utils.SCLVMWithVG)
+				}
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			_, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			assert.Equal(t, tt.expectAllocate, exist)
+		})
+	}
+}
+
+// createTestExtender returns predicate and prioritize of scheduler extender
+// sharing informers with plugin, so both see the same cluster snapshot
+func createTestExtender(plugin *LocalPlugin, nodes []*corev1.Node) (*predicates.Predicate, *priorities.Prioritize) {
+	ctx := algorithm.NewSchedulingContext(plugin.coreV1Informers, plugin.storageV1Informers, plugin.localInformers, plugin.snapshotInformers, plugin.nodeAntiAffinityWeight)
+	ctx.NodeStorageStalenessWindow = plugin.nodeStorageStalenessWindow
+	for _, node := range nodes {
+		_ = plugin.coreV1Informers.Nodes().Informer().GetIndexer().Add(node)
+		if nls, err := plugin.localInformers.NodeLocalStorages().Lister().Get(node.Name); err == nil {
+			ctx.ClusterNodeCache.AddNodeCache(nls)
+		}
+	}
+	return predicates.NewPredicate(ctx), priorities.NewPrioritize(ctx)
+}
+
+func Test_Filter_SameAsExtender(t *testing.T) {
+	const domainKey = "topology.open-local.io/power-domain"
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	podWithoutLocalPVC := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutLocalPVC",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos:     []*utils.TestPVCInfo{utils.GetTestPVCPVNotLocal().PVCPending},
+	})
+
+	plugin := CreateTestPlugin()
+	plugin.nodeStorageStalenessWindow = 2 * time.Minute
+	nodeInfos := prepare(plugin)
+	for _, pvc := range utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending, *utils.GetTestPVCPVNotLocal().PVCPending}) {
+		_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+		_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+	}
+	sc, err := plugin.scLister.Get(utils.SCLVMWithVG)
+	assert.NoError(t, err)
+	sc = sc.DeepCopy()
+	sc.Parameters[localtype.ParamDomainSelector] = domainKey + "=pd-a"
+	_ = plugin.storageV1Informers.StorageClasses().Informer().GetIndexer().Update(sc)
+
+	// NodeName1 reports storage class unserviceable, NodeName3 is stale and
+	// NodeName4 is out of fault domain
+	for _, nodeName := range []string{utils.NodeName1, utils.NodeName3} {
+		nls, err := plugin.localInformers.NodeLocalStorages().Lister().Get(nodeName)
+		assert.NoError(t, err)
+		nls = nls.DeepCopy()
+		if nodeName == utils.NodeName1 {
+			nls.Status.NodeStorageInfo.StorageClasses = &localv1alpha1.StorageClassServiceability{Unserviceable: []string{utils.SCLVMWithVG}}
+		} else {
+			heartbeat := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+			nls.Status.NodeStorageInfo.State.LastHeartbeatTime = &heartbeat
+		}
+		_ = plugin.localInformers.NodeLocalStorages().Informer().GetIndexer().Update(nls)
+	}
+	var nodes []*corev1.Node
+	var nodeNames []string
+	for _, nodeInfo := range nodeInfos {
+		node := nodeInfo.Node()
+		node.Labels = map[string]string{domainKey: "pd-a"}
+		if node.Name == utils.NodeName4 {
+			node.Labels[domainKey] = "pd-b"
+		}
+		nodes = append(nodes, node)
+		nodeNames = append(nodeNames, node.Name)
+	}
+	predicate, prioritize := createTestExtender(plugin, nodes)
+
+	tests := []struct {
+		pod          *corev1.Pod
+		expectPassed []string
+	}{
+		{pod: podWithVG, expectPassed: []string{utils.NodeName2}},
+		{pod: podWithoutLocalPVC, expectPassed: nodeNames},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pod.Name, func(t *testing.T) {
+			assertFilterSameAsExtender(t, plugin, nodeInfos, predicate, prioritize, tt.pod, tt.expectPassed)
+		})
+	}
+}
+
+// Test_Filter_SameAsExtender_Capacity checks nodes rejected or scored by
+// capacity of vg and device, NodeName1 is short of VGSSD, VGSSD of NodeName3
+// is under maintenance, NodeName4 reports no storage and only NodeName3 has
+// hdd device
+func Test_Filter_SameAsExtender_Capacity(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos:     []*utils.TestPVCInfo{utils.GetTestPVCPVWithVG().PVCPending},
+	})
+	podWithoutVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos:     []*utils.TestPVCInfo{utils.GetTestPVCPVWithoutVG().PVCPending},
+	})
+	podWithDevice := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithDevice",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos:     []*utils.TestPVCInfo{utils.GetTestPVCPVDevice().PVCPending},
+	})
+
+	plugin := CreateTestPlugin()
+	nodeInfos := prepare(plugin)
+	for _, pvc := range utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending, *utils.GetTestPVCPVWithoutVG().PVCPending, *utils.GetTestPVCPVDevice().PVCPending}) {
+		_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+		_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+	}
+	oldNLS, err := plugin.localInformers.NodeLocalStorages().Lister().Get(utils.NodeName3)
+	assert.NoError(t, err)
+	newNLS := oldNLS.DeepCopy()
+	newNLS.Spec.ListConfig.VGs.Maintenance = []string{utils.VGSSD}
+	_ = plugin.localInformers.NodeLocalStorages().Informer().GetIndexer().Update(newNLS)
+	plugin.OnNodeLocalStorageUpdate(oldNLS, newNLS)
+
+	var nodes []*corev1.Node
+	for _, nodeInfo := range nodeInfos {
+		nodes = append(nodes, nodeInfo.Node())
+	}
+	predicate, prioritize := createTestExtender(plugin, nodes)
+
+	tests := []struct {
+		pod          *corev1.Pod
+		expectPassed []string
+	}{
+		{pod: podWithVG, expectPassed: []string{utils.NodeName2}},
+		{pod: podWithoutVG, expectPassed: []string{utils.NodeName1, utils.NodeName2}},
+		{pod: podWithDevice, expectPassed: []string{utils.NodeName3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pod.Name, func(t *testing.T) {
+			assertFilterSameAsExtender(t, plugin, nodeInfos, predicate, prioritize, tt.pod, tt.expectPassed)
+		})
+	}
+}
+
+// assertFilterSameAsExtender asserts that Filter of plugin passes the same
+// nodes as predicate of extender, and Score of plugin scores them the same
+// as prioritize of extender
+func assertFilterSameAsExtender(t *testing.T, plugin *LocalPlugin, nodeInfos []*framework.NodeInfo, predicate *predicates.Predicate, prioritize *priorities.Prioritize, pod *corev1.Pod, expectPassed []string) {
+	var names []string
+	for _, nodeInfo := range nodeInfos {
+		names = append(names, nodeInfo.Node().Name)
+	}
+	extenderFilter, err := predicate.Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &names})
+	assert.NoError(t, err)
+
+	cycleState := framework.NewCycleState()
+	plugin.PreFilter(context.Background(), cycleState, pod)
+	var passed []string
+	for _, nodeInfo := range nodeInfos {
+		nodeName := nodeInfo.Node().Name
+		gotStatus := plugin.Filter(context.Background(), cycleState, pod, nodeInfo)
+		if gotStatus.IsSuccess() {
+			passed = append(passed, nodeName)
+			continue
+		}
+		// reasons are worded by each entry point
+		assert.Contains(t, extenderFilter.FailedNodes, nodeName)
+	}
+	assert.ElementsMatch(t, expectPassed, passed)
+	assert.ElementsMatch(t, *extenderFilter.NodeNames, passed)
+
+	extenderScores, err := prioritize.Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &passed})
+	assert.NoError(t, err)
+	for _, hostScore := range *extenderScores {
+		gotScore, gotStatus := plugin.Score(context.Background(), cycleState, pod, hostScore.Host)
+		assert.True(t, gotStatus.IsSuccess())
+		assert.Equal(t, hostScore.Score, gotScore, "node %s", hostScore.Host)
+	}
+}
+
+func Test_Filter_LVMPVC_Contiguous(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	podWithoutVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithoutVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithoutVG().PVCPending,
+		},
+	})
+	pvcWithVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+	pvcWithoutVG := utils.GetTestPVCPVWithoutVG()
+	pvcWithoutVG.PVCPending.Size = "160Gi"
+	pvcWithoutVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*pvcWithoutVG.PVCPending})[0]
+
+	type args struct {
+		pod *corev1.Pod
+	}
+	type fields struct {
+		pvc               *corev1.PersistentVolumeClaim
+		requireContiguous bool
+		// largest contiguous free space of vgs on NodeName2
+		largestFreeRun map[string]uint64
+	}
+
+	tests := []struct {
+		name         string
+		args         args
+		fields       fields
+		expectStatus framework.Code
+		expectVG     string
+	}{
+		{
+			name: "test pod with pvc use sc have vg, fragmented vg without contiguous required",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvc:               pvcWithVGPending,
+				requireContiguous: false,
+				largestFreeRun:    map[string]uint64{utils.VGSSD: 100 * utils.LocalGi},
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGSSD,
+		},
+		{
+			name: "test pod with pvc use sc have vg, fragmented vg with contiguous required",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvc:               pvcWithVGPending,
+				requireContiguous: true,
+				largestFreeRun:    map[string]uint64{utils.VGSSD: 100 * utils.LocalGi},
+			},
+			expectStatus: framework.Unschedulable,
+		},
+		{
+			name: "test pod with pvc use sc have vg, enough contiguous space",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvc:               pvcWithVGPending,
+				requireContiguous: true,
+				largestFreeRun:    map[string]uint64{utils.VGSSD: 180 * utils.LocalGi},
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGSSD,
+		},
+		{
+			name: "test pod with pvc use sc have vg, contiguous space not reported",
+			args: args{
+				pod: podWithVG,
+			},
+			fields: fields{
+				pvc:               pvcWithVGPending,
+				requireContiguous: true,
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGSSD,
+		},
+		{
+			name: "test pod with pvc use sc without vg, skip fragmented vg",
+			args: args{
+				pod: podWithoutVG,
+			},
+			fields: fields{
+				pvc:               pvcWithoutVGPending,
+				requireContiguous: true,
+				largestFreeRun:    map[string]uint64{utils.VGSSD: 100 * utils.LocalGi, utils.VGHDD: 750 * utils.LocalGi},
+			},
+			expectStatus: framework.Success,
+			expectVG:     utils.VGHDD,
+		},
+		{
+			name: "test pod with pvc use sc without vg, all vgs fragmented",
+			args: args{
+				pod: podWithoutVG,
+			},
+			fields: fields{
+				pvc:               pvcWithoutVGPending,
+				requireContiguous: true,
+				largestFreeRun:    map[string]uint64{utils.VGSSD: 150 * utils.LocalGi, utils.VGHDD: 100 * utils.LocalGi},
+			},
+			expectStatus: framework.Unschedulable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			pvc := tt.fields.pvc
+			_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+			_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			if tt.fields.requireContiguous {
+				sc, err := plugin.scLister.Get(*pvc.Spec.StorageClassName)
+				assert.NoError(t, err)
+				sc = sc.DeepCopy()
+				sc.Parameters[localtype.ParamRequireContiguous] = "true"
+				_ = plugin.storageV1Informers.StorageClasses().Informer().GetIndexer().Update(sc)
+			}
+			oldNLS := utils.CreateTestNodeLocalStorage2()
+			newNLS := oldNLS.DeepCopy()
+			for i, vg := range newNLS.Status.NodeStorageInfo.VolumeGroups {
+				newNLS.Status.NodeStorageInfo.VolumeGroups[i].LargestFreeExtentRun = tt.fields.largestFreeRun[vg.Name]
+			}
+			plugin.OnNodeLocalStorageUpdate(oldNLS, newNLS)
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, tt.args.pod)
+
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				gotStatus := plugin.Filter(context.Background(), cycleState, tt.args.pod, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			allocateState, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			if tt.expectVG == "" {
+				assert.False(t, exist)
+				return
+			}
+			assert.True(t, exist)
+			assert.Equal(t, tt.expectVG, allocateState.Units.LVMPVCAllocateUnits[0].VGName)
+		})
+	}
+}
+
+func Test_Filter_LVMPVC_SnapshotReserve(t *testing.T) {
+	podWithVG := utils.CreatePod(&utils.TestPodInfo{
+		PodName:      "podWithVG",
+		PodNameSpace: utils.LocalNameSpace,
+		PodStatus:    corev1.PodPending,
+		PVCInfos: []*utils.TestPVCInfo{
+			utils.GetTestPVCPVWithVG().PVCPending,
+		},
+	})
+	pvcWithVGPending := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*utils.GetTestPVCPVWithVG().PVCPending})[0]
+
+	tests := []struct {
+		name           string
+		reservePercent string
+		expectStatus   framework.Code
+		expectAllocate int64
+	}{
+		{
+			name:           "test pvc without snapshot headroom",
+			reservePercent: "",
+			expectStatus:   framework.Success,
+			expectAllocate: int64(150 * utils.LocalGi),
+		},
+		{
+			name:           "test snapshot headroom allocated with pvc",
+			reservePercent: "20",
+			expectStatus:   framework.Success,
+			expectAllocate: int64(180 * utils.LocalGi),
+		},
+		{
+			name:           "test snapshot headroom exceeds free space of vg",
+			reservePercent: "50",
+			expectStatus:   framework.Unschedulable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := CreateTestPlugin()
+			nodeInfos := prepare(plugin)
+			pvc := pvcWithVGPending
+			_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+			_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+			if tt.reservePercent != "" {
+				sc, err := plugin.scLister.Get(*pvc.Spec.StorageClassName)
+				assert.NoError(t, err)
+				sc = sc.DeepCopy()
+				sc.Parameters[localtype.ParamSnapshotReservePercent] = tt.reservePercent
+				_ = plugin.storageV1Informers.StorageClasses().Informer().GetIndexer().Update(sc)
+			}
+			nls := utils.CreateTestNodeLocalStorage2()
+			plugin.OnNodeLocalStorageUpdate(nls, nls.DeepCopy())
+
+			cycleState := framework.NewCycleState()
+			plugin.PreFilter(context.Background(), cycleState, podWithVG)
+
+			for _, node := range nodeInfos {
+				if node.Node().Name != utils.NodeName2 {
+					continue
+				}
+				gotStatus := plugin.Filter(context.Background(), cycleState, podWithVG, node)
+				assert.Equal(t, tt.expectStatus, gotStatus.Code())
+			}
+
+			gotDataState, err := plugin.getState(cycleState)
+			assert.NoError(t, err)
+			allocateState, exist := gotDataState.allocateStateByNode[utils.NodeName2]
+			if tt.expectAllocate == 0 {
+				assert.False(t, exist)
+				return
+			}
+			assert.True(t, exist)
+			assert.Equal(t, tt.expectAllocate, allocateState.Units.LVMPVCAllocateUnits[0].Allocated)
+			assert.Equal(t, tt.expectAllocate, allocateState.NodeStorageAllocatedByUnits.VGStates[utils.VGSSD].Requested)
+		})
+	}
+}
+
 func Test_Filter_LVMPVC_Snapshot(t *testing.T) {
 	podWithSnapshot := utils.CreatePod(&utils.TestPodInfo{
 		PodName:      "podWithVG",
@@ -988,6 +1936,7 @@ func Test_Filter_inlineVolume(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -1024,6 +1973,7 @@ func Test_Filter_inlineVolume(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
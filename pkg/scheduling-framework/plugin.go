@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"time"
 
 	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 
@@ -19,7 +20,9 @@ import (
 	volumesnapshotinformersfactory "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions"
 	volumesnapshotinformers "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions/volumesnapshot/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	storagev1informers "k8s.io/client-go/informers/storage/v1"
 	"k8s.io/client-go/kubernetes"
@@ -83,14 +86,36 @@ type LocalPlugin struct {
 	snapClientSet  volumesnapshot.Interface
 
 	cache *cache.NodeStorageAllocatedCache
+
+	// nodeStorageStalenessWindow is the duration after which nls not refreshed
+	// by discovery accepts no new volume, 0 means disabled
+	nodeStorageStalenessWindow time.Duration
+	// replicaAffinity boosts nodes hosting other replicas of the workload
+	replicaAffinity localtype.ReplicaAffinity
 }
 
 const PluginName = "Open-Local"
 
+// DefaultReservationReconcileInterval is the default interval(second) of releasing stale reservations
+const DefaultReservationReconcileInterval = 60
+
 type OpenLocalArg struct {
 	KubeConfigPath       string `json:"kubeConfigPath,omitempty"`
 	SchedulerStrategy    string `json:"schedulerStrategy,omitempty"`
 	NodeAntiAffinityConf string `json:"nodeAntiAffinityConf,omitempty"`
+	// ReservationReconcileInterval is the interval(second) of releasing stale reservations, use default if not positive
+	ReservationReconcileInterval int `json:"reservationReconcileInterval,omitempty"`
+	// NodeStorageStalenessWindow is the duration(second) after which node whose nls is not refreshed by agent
+	// accepts no new local volume, use default if 0 and disabled if negative
+	NodeStorageStalenessWindow int `json:"nodeStorageStalenessWindow,omitempty"`
+	// VGConsistentHashing makes lvm pvc without vg name try the vg hashed by its namespace and name
+	// first, and fall back to schedulerStrategy if the hashed vg can't fit
+	VGConsistentHashing bool `json:"vgConsistentHashing,omitempty"`
+	// ReplicaAffinityWeight in range [0, 10] is added to score of node already running other replicas of
+	// the workload of pod with local volumes, 0 means disabled
+	ReplicaAffinityWeight int `json:"replicaAffinityWeight,omitempty"`
+	// ReplicaAffinityLabel identifies replicas by the same value of this pod label instead of controller
+	ReplicaAffinityLabel string `json:"replicaAffinityLabel,omitempty"`
 }
 
 var _ = framework.PreFilterPlugin(&LocalPlugin{})
@@ -142,6 +167,10 @@ func NewLocalPluginWithKubeconfig(configuration runtime.Object, f framework.Hand
 	if err != nil {
 		return nil, err
 	}
+	replicaAffinity, err := utils.ParseReplicaAffinity(args.ReplicaAffinityWeight, args.ReplicaAffinityLabel)
+	if err != nil {
+		return nil, err
+	}
 
 	// client
 	localClient, err := localclientset.NewForConfig(cfg)
@@ -160,6 +189,9 @@ func NewLocalPluginWithKubeconfig(configuration runtime.Object, f framework.Hand
 
 	strategyType := getStrategyType(args.SchedulerStrategy)
 	nodeCache := cache.NewNodeStorageAllocatedCache(strategyType)
+	if args.VGConsistentHashing {
+		nodeCache.EnableVGConsistentHashing()
+	}
 
 	localPlugin := &LocalPlugin{
 		handle:                 f,
@@ -176,6 +208,9 @@ func NewLocalPluginWithKubeconfig(configuration runtime.Object, f framework.Hand
 		kubeClientSet:     f.ClientSet(),
 		localClientSet:    localClient,
 		snapClientSet:     snapClient,
+
+		nodeStorageStalenessWindow: getNodeStorageStalenessWindow(args.NodeStorageStalenessWindow),
+		replicaAffinity:            replicaAffinity,
 	}
 	snapshotInformerFactory.Snapshot().V1().VolumeSnapshots().Informer()
 	snapshotInformerFactory.Snapshot().V1().VolumeSnapshotContents().Informer()
@@ -211,9 +246,37 @@ func NewLocalPluginWithKubeconfig(configuration runtime.Object, f framework.Hand
 	snapshotInformerFactory.Start(cxt.Done())
 	snapshotInformerFactory.WaitForCacheSync(cxt.Done())
 
+	reconcileInterval := args.ReservationReconcileInterval
+	if reconcileInterval <= 0 {
+		reconcileInterval = DefaultReservationReconcileInterval
+	}
+	go wait.Until(localPlugin.reconcileReservations, time.Duration(reconcileInterval)*time.Second, cxt.Done())
+
 	return localPlugin, nil
 }
 
+func getNodeStorageStalenessWindow(window int) time.Duration {
+	if window == 0 {
+		window = localtype.DefaultNodeStorageStalenessWindow
+	}
+	if window < 0 {
+		return 0
+	}
+	return time.Duration(window) * time.Second
+}
+
+// reconcileReservations releases reservations leaked by pods deleted before bind
+func (plugin *LocalPlugin) reconcileReservations() {
+	released, err := plugin.cache.ReconcileReservations(plugin.coreV1Informers.Pods().Lister())
+	if err != nil {
+		klog.Errorf("reconcile reservations fail: %s", err.Error())
+		return
+	}
+	if released > 0 {
+		klog.Infof("released %d stale reservations", released)
+	}
+}
+
 // Name returns name of the plugin. It is used in logs, etc.
 func (plugin *LocalPlugin) Name() string {
 	return PluginName
@@ -247,6 +310,17 @@ func (plugin *LocalPlugin) Filter(ctx context.Context, state *framework.CycleSta
 		return framework.NewStatus(framework.Success)
 	}
 
+	// node is filtered by the same checks as predicate of scheduler extender
+	// before capacity is considered
+	if err := plugin.nodeFilter().Filter(nodeInfo.Node(), podVolumeInfo.PVCs(), time.Now()); err != nil {
+		if _, ok := err.(errors.PredicateError); !ok {
+			klog.Errorf("filter node fail: nodeName:%s, podUid:%s, err: %s", nodeName, pod.UID, err.Error())
+			return framework.AsStatus(err)
+		}
+		klog.V(4).Infof("filter fail: node %s for pod %s, err: %s", nodeName, pod.UID, err.Error())
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+
 	fits, err := plugin.filterBySnapshot(nodeName, podVolumeInfo.LVMPVCsROSnapshot)
 	if err != nil {
 		if _, ok := err.(errors.PredicateError); !ok {
@@ -280,17 +354,30 @@ func (plugin *LocalPlugin) Score(ctx context.Context, state *framework.CycleStat
 		return 0, framework.NewStatus(framework.Unschedulable, err.Error())
 	}
 	if allocateInfo == nil || !allocateInfo.Units.HaveLocalUnits() {
-		if plugin.cache.IsLocalNode(nodeName) {
-			return int64(utils.MinScore), framework.NewStatus(framework.Success)
-		}
-		return int64(utils.MaxScore), framework.NewStatus(framework.Success)
+		return int64(algorithm.ScoreNodeWithoutLocalVolume(plugin.cache.IsLocalNode(nodeName))), framework.NewStatus(framework.Success)
 	}
 
 	if allocateInfo.NodeStorageAllocatedByUnits == nil {
 		return int64(utils.MinScore), framework.NewStatus(framework.Success)
 	}
 
-	return plugin.scorer.Score(allocateInfo), framework.NewStatus(framework.Success)
+	return plugin.scorer.Score(allocateInfo) + plugin.scoreByReplicaAffinity(pod, nodeName), framework.NewStatus(framework.Success)
+}
+
+// scoreByReplicaAffinity only adds to nodes passing filter, it never makes a
+// node without enough storage schedulable
+func (plugin *LocalPlugin) scoreByReplicaAffinity(pod *corev1.Pod, nodeName string) int64 {
+	if !plugin.replicaAffinity.Enabled() {
+		return int64(utils.MinScore)
+	}
+	pods, err := plugin.coreV1Informers.Pods().Lister().Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list pods of namespace %s for replica affinity err: %s", pod.Namespace, err.Error())
+		return int64(utils.MinScore)
+	}
+	score := int64(utils.ReplicaAffinityScore(plugin.replicaAffinity, pod, pods, nodeName))
+	klog.V(4).Infof("[ReplicaAffinity]node %s got %d for pod %s", nodeName, score, pod.UID)
+	return score
 }
 
 // PVC which will be bound as a staticBindings at step volume_binding.PreBind, finally allocate by pv and no need revert by pvc
@@ -490,6 +490,7 @@ func Test_Reserve_LVMPVC_NotROSnapshot(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   utils.GetPVCRequested(pvcWithVGPending),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -561,6 +562,7 @@ func Test_Reserve_LVMPVC_NotROSnapshot(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   utils.GetPVCRequested(pvcWithoutVGPending),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -634,6 +636,7 @@ func Test_Reserve_LVMPVC_NotROSnapshot(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   utils.GetPVSize(pvwithoutVGBounding),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -688,6 +691,7 @@ func Test_Reserve_LVMPVC_NotROSnapshot(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   utils.GetPVSize(pvwithoutVGLargeBounding),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -862,6 +866,7 @@ func Test_Reserve_inlineVolume(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   getSize("150Gi"),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -1574,6 +1579,7 @@ func Test_Unreserve(t *testing.T) {
 							Total:       int64(300 * utils.LocalGi),
 							Allocatable: int64(300 * utils.LocalGi),
 							Requested:   utils.GetPVSize(pvsBounding["pv-"+utils.PVCWithoutVG]),
+							LVCount:     1,
 						},
 					},
 					DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -1861,3 +1867,85 @@ func Test_Reservation(t *testing.T) {
 	nodeStorageUnreserve := plugin.cache.GetNodeStorageStateCopy(nodeName)
 	assert.Equal(t, nodeStorageByReservation, nodeStorageUnreserve, "nodeStorage should not change after unreserve pod")
 }
+
+func Test_ReconcileReservations(t *testing.T) {
+
+	pvcWithoutVG := utils.GetTestPVCPVWithoutVG()
+	pvcWithoutVG.PVBounding.VgName = utils.VGSSD
+
+	complexPVCPVInfos := utils.TestPVCPVInfoList{
+		utils.GetTestPVCPVWithVG(),
+		utils.GetTestPVCPVDevice(),
+		pvcWithoutVG,
+		utils.GetTestPVCPVNotLocal(), //not local pv
+	}
+
+	//stalePod is deleted before bind, so never in pod lister
+	stalePod := createPod(complexPVCPVInfos)
+	//validPod only has inlineVolume
+	validPod := createPod(nil)
+	validPod.UID = "validPod"
+	validPod.Name = "validPod"
+
+	pvcsPending := createPVC(complexPVCPVInfos.GetTestPVCPending())
+	nodeName := utils.NodeName3
+
+	plugin := CreateTestPlugin()
+	prepare(plugin)
+	for _, pvc := range pvcsPending {
+		_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+		_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+		plugin.OnPVCAdd(pvc)
+	}
+	_ = plugin.coreV1Informers.Pods().Informer().GetIndexer().Add(validPod)
+
+	for _, pod := range []*corev1.Pod{stalePod, validPod} {
+		cycleState := framework.NewCycleState()
+		gotStatus := plugin.PreFilter(context.Background(), cycleState, pod)
+		assert.Equal(t, framework.Success, gotStatus.Code(), "pod(%s) should prefilter success!", pod.Name)
+		gotStatus = plugin.Reserve(context.Background(), cycleState, pod, nodeName)
+		assert.Equal(t, framework.Success, gotStatus.Code(), "pod(%s) should reserve success!", pod.Name)
+	}
+
+	//only inlineVolume of validPod remains after reconcile
+	expectNodeStorage := &cache.NodeStorageState{
+		VGStates: map[string]*cache.VGStoragePool{
+			utils.VGSSD: {
+				Name:        utils.VGSSD,
+				Total:       int64(300 * utils.LocalGi),
+				Allocatable: int64(300 * utils.LocalGi),
+				Requested:   int64(10 * utils.LocalGi),
+				LVCount:     1,
+			},
+		},
+		DeviceStates: map[string]*cache.DeviceResourcePool{
+			"/dev/sdc": {
+				Name:        "/dev/sdc",
+				Total:       int64(150 * utils.LocalGi),
+				Allocatable: int64(150 * utils.LocalGi),
+				Requested:   0,
+				MediaType:   localtype.MediaTypeHDD,
+				IsAllocated: false,
+			},
+		},
+		InitedByNLS: true,
+	}
+
+	released, err := plugin.cache.ReconcileReservations(plugin.coreV1Informers.Pods().Lister())
+	assert.NoError(t, err, "reconcile reservations")
+	assert.Equal(t, 1, released, "only reservation of stalePod should be released")
+	assert.Equal(t, expectNodeStorage, plugin.cache.GetNodeStorageStateCopy(nodeName), "check node storage after reconcile")
+	for _, pvc := range pvcsPending {
+		assert.Nil(t, plugin.cache.GetPVCAllocatedDetailCopy(pvc.Namespace, pvc.Name), "pvc(%s) reserved by stalePod should be released", pvc.Name)
+	}
+	assert.NotEmpty(t, plugin.cache.GetPodInlineVolumeDetailsCopy(nodeName, string(validPod.UID)), "reservation of validPod should remain")
+
+	//validPod scheduled to reserved node, reservation is taken over by pod event
+	scheduledPod := validPod.DeepCopy()
+	scheduledPod.Spec.NodeName = nodeName
+	_ = plugin.coreV1Informers.Pods().Informer().GetIndexer().Update(scheduledPod)
+	released, err = plugin.cache.ReconcileReservations(plugin.coreV1Informers.Pods().Lister())
+	assert.NoError(t, err, "reconcile reservations")
+	assert.Equal(t, 0, released, "reservation of scheduled pod should not be released")
+	assert.Equal(t, expectNodeStorage, plugin.cache.GetNodeStorageStateCopy(nodeName), "check node storage after pod scheduled")
+}
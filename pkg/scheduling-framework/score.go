@@ -20,6 +20,7 @@ import (
 	"k8s.io/klog/v2"
 
 	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/scheduler/algorithm"
 	"github.com/alibaba/open-local/pkg/scheduling-framework/cache"
 )
 
@@ -102,16 +103,13 @@ func NewDeviceScorer(strategy localtype.StrategyType, nodeAntiAffinityWeight *lo
 }
 func (scorer *DeviceScorer) ScoreByCapacity(nodeAllocate *cache.NodeAllocateState) (score int64) {
 	units := nodeAllocate.Units.DevicePVCAllocateUnits
-	if len(units) <= 0 {
-		return int64(utils.MinScore)
-	}
-
-	var scoref float64 = 0
+	requested := make([]int64, 0, len(units))
+	allocated := make([]int64, 0, len(units))
 	for _, unit := range units {
-		scoref += float64(unit.Requested) / float64(unit.Allocated)
+		requested = append(requested, unit.Requested)
+		allocated = append(allocated, unit.Allocated)
 	}
-	score = int64(scoref / float64(len(units)) * float64(utils.MaxScore))
-	return
+	return int64(algorithm.ScoreExclusiveCapacity(requested, allocated))
 }
 
 func (scorer *DeviceScorer) ScoreByCount(nodeAllocate *cache.NodeAllocateState) (score int64) {
@@ -121,7 +119,7 @@ func (scorer *DeviceScorer) ScoreByCount(nodeAllocate *cache.NodeAllocateState)
 func (scorer *DeviceScorer) ScoreByNodeAntiAffinity(nodeAllocate *cache.NodeAllocateState) (score int64) {
 	var freeDeviceCount = 0
 	for _, state := range nodeAllocate.NodeStorageAllocatedByUnits.DeviceStates {
-		if !state.IsAllocated {
+		if !state.IsAllocated && !state.Wiping {
 			freeDeviceCount += 1
 		}
 	}
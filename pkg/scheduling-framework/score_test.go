@@ -17,6 +17,7 @@ package plugin
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"testing"
 
@@ -28,6 +29,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
 )
 
@@ -180,6 +182,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(100 * utils.LocalGi),
 										Allocatable: int64(100 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -218,6 +221,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -256,6 +260,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -316,6 +321,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -352,6 +358,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -427,12 +434,14 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
 										Total:       int64(750 * utils.LocalGi),
 										Allocatable: int64(750 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{},
@@ -474,6 +483,7 @@ func Test_score_volumeGroup_binPack(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   getSize("250Gi"),
+										LVCount:     2,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -646,6 +656,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(500 * utils.LocalGi),
 										Allocatable: int64(500 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{},
@@ -684,6 +695,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(750 * utils.LocalGi),
 										Allocatable: int64(750 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{},
@@ -716,6 +728,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -776,6 +789,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
@@ -812,6 +826,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -887,12 +902,14 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(200 * utils.LocalGi),
 										Allocatable: int64(200 * utils.LocalGi),
 										Requested:   getSize("150Gi"),
+										LVCount:     1,
 									},
 									utils.VGHDD: {
 										Name:        utils.VGHDD,
 										Total:       int64(750 * utils.LocalGi),
 										Allocatable: int64(750 * utils.LocalGi),
 										Requested:   utils.GetPVCRequested(pvcWithoutVG),
+										LVCount:     1,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{},
@@ -934,6 +951,7 @@ func Test_score_volumeGroup_spread(t *testing.T) {
 										Total:       int64(300 * utils.LocalGi),
 										Allocatable: int64(300 * utils.LocalGi),
 										Requested:   getSize("250Gi"),
+										LVCount:     2,
 									},
 								},
 								DeviceStates: map[string]*cache.DeviceResourcePool{
@@ -1424,3 +1442,81 @@ func Test_score_device(t *testing.T) {
 		})
 	}
 }
+
+func Test_score_replicaAffinity(t *testing.T) {
+	pvcInfo := utils.GetTestPVCPVWithoutVG().PVCPending
+	pvc := utils.CreateTestPersistentVolumeClaim([]utils.TestPVCInfo{*pvcInfo})[0]
+
+	controller := true
+	replicaPod := func(name, nodeName, owner string) *corev1.Pod {
+		pod := utils.CreatePod(&utils.TestPodInfo{
+			PodName:      name,
+			PodNameSpace: utils.LocalNameSpace,
+			PodStatus:    corev1.PodRunning,
+			NodeName:     nodeName,
+		})
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: owner, UID: types.UID(owner), Controller: &controller}}
+		return pod
+	}
+	pod := replicaPod("db-2", "", "db")
+	pod.Status.Phase = corev1.PodPending
+	pod.Spec.Volumes = utils.CreatePod(&utils.TestPodInfo{PVCInfos: []*utils.TestPVCInfo{pvcInfo}}).Spec.Volumes
+
+	// scoreNodes returns score of nodes passing filter and names of all nodes
+	scoreNodes := func(affinity localtype.ReplicaAffinity, pods []*corev1.Pod) (map[string]int64, []string) {
+		plugin := CreateTestPlugin()
+		nodeInfos := prepare(plugin)
+		plugin.replicaAffinity = affinity
+		_, _ = plugin.kubeClientSet.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+		_ = plugin.coreV1Informers.PersistentVolumeClaims().Informer().GetIndexer().Add(pvc)
+		for _, p := range pods {
+			_ = plugin.coreV1Informers.Pods().Informer().GetIndexer().Add(p)
+		}
+
+		cycleState := framework.NewCycleState()
+		plugin.PreFilter(context.Background(), cycleState, pod)
+		scores := map[string]int64{}
+		var names []string
+		for _, node := range nodeInfos {
+			names = append(names, node.Node().Name)
+			if !plugin.Filter(context.Background(), cycleState, pod, node).IsSuccess() {
+				continue
+			}
+			score, status := plugin.Score(context.Background(), cycleState, pod, node.Node().Name)
+			assert.True(t, status.IsSuccess(), "score node %s", node.Node().Name)
+			scores[node.Node().Name] = score
+		}
+		return scores, names
+	}
+
+	disabled, names := scoreNodes(localtype.ReplicaAffinity{}, nil)
+	var filtered []string
+	for _, name := range names {
+		if _, ok := disabled[name]; ok {
+			filtered = append(filtered, name)
+		}
+	}
+	if len(filtered) < 2 || len(filtered) == len(names) {
+		t.Fatalf("want some but not all nodes %v passing filter, got %v", names, filtered)
+	}
+	siblingNode, otherNode := filtered[0], filtered[1]
+
+	// replicas on nodes failing filter must not make them schedulable
+	pods := []*corev1.Pod{replicaPod("db-0", siblingNode, "db"), replicaPod("web-0", otherNode, "web")}
+	for i, name := range names {
+		if _, ok := disabled[name]; !ok {
+			pods = append(pods, replicaPod(fmt.Sprintf("db-full-%d", i), name, "db"))
+		}
+	}
+	weight := 5
+	enabled, _ := scoreNodes(localtype.ReplicaAffinity{Weight: weight}, pods)
+	assert.Equal(t, len(disabled), len(enabled), "replica affinity must not change filter result")
+	for name, score := range disabled {
+		want := score
+		if name == siblingNode {
+			want += int64(weight)
+		}
+		assert.Equal(t, want, enabled[name], "score of node %s", name)
+	}
+	assert.Greater(t, enabled[siblingNode]-enabled[otherNode], disabled[siblingNode]-disabled[otherNode], "node with sibling replica should score higher")
+}
@@ -34,6 +34,9 @@ const (
 	Gi          uint64 = 1024 * 1024 * 1024
 	Mi          uint64 = 1024 * 1024
 	DefaultPort int32  = 23000
+	// DefaultNodeStorageStalenessWindow is the duration(second) after which
+	// status of nls not refreshed by discovery is treated as stale
+	DefaultNodeStorageStalenessWindow = 300
 
 	StrategyBinpack StrategyType = "binpack"
 	StrategySpread  StrategyType = "spread"
@@ -43,6 +46,34 @@ const (
 	ProvisionerName     string = "local.csi.aliyun.com"
 	SchedulerName       string = "open-local-scheduler"
 
+	// ManagedLVTag is the lvm tag of logical volumes created by open-local
+	ManagedLVTag = "open-local.io/managed"
+	// PendingDeletionLVTag is the lvm tag of snapshot logical volumes failed
+	// to be removed because origin is busy, agent retries removing them
+	PendingDeletionLVTag = "open-local.io/pending-deletion"
+	// ZeroingLVTag is the lvm tag of logical volumes being zeroed, it is
+	// removed once the whole lv is written with zeros
+	ZeroingLVTag = "open-local.io/zeroing"
+	// ExpansionSnapshotLVTag is the lvm tag of snapshot logical volumes taken
+	// before filesystem is grown, it is kept for recovery if resize fails
+	ExpansionSnapshotLVTag = "open-local.io/expansion-snapshot"
+	// PreallocatedLVTagPrefix is the prefix of lvm tag of unassigned
+	// preallocated logical volumes, followed by size of the size class
+	PreallocatedLVTagPrefix = "open-local.io/preallocated="
+	// PreallocAssigneeLVTagPrefix is the prefix of lvm tag of preallocated
+	// logical volumes assigned to a volume, followed by volume id
+	PreallocAssigneeLVTagPrefix = "open-local.io/assigned-to="
+	// CreatedForLVTagPrefix is the prefix of lvm tag of logical volumes
+	// created by CreateVolume, followed by volume id. It tells lv left by an
+	// interrupted request of the volume from lv of the other volume
+	CreatedForLVTagPrefix = "open-local.io/created-for="
+	// DiscardOnDeleteLVTag is the lvm tag of logical volumes whose extents
+	// are discarded when they are removed
+	DiscardOnDeleteLVTag = "open-local.io/discard-on-delete"
+	// DeleteOrphanedSnapshotsLVTag is the lvm tag of logical volumes whose
+	// orphaned snapshots managed by open-local are removed with them
+	DeleteOrphanedSnapshotsLVTag = "open-local.io/delete-orphaned-snapshots"
+
 	EnvLogLevel = "LogLevel"
 	LogPanic    = "Panic"
 	LogFatal    = "Fatal"
@@ -61,6 +92,10 @@ const (
 	VolumeFSTypeXFS           = "xfs"
 	VolumeIOPS                = "iops"
 	VolumeBPS                 = "bps"
+	// VolumeMinSize and VolumeMaxSize are the range of volume size allowed by
+	// storage class, e.g. 1Gi, unset means unrestricted
+	VolumeMinSize = "minSize"
+	VolumeMaxSize = "maxSize"
 
 	BPSReadFile   = "blkio.throttle.read_bps_device"
 	BPSWriteFile  = "blkio.throttle.write_bps_device"
@@ -78,9 +113,104 @@ const (
 	DefaultSnapshotInitialSize   = 4 * 1024 * 1024 * 1024
 	DefaultSnapshotThreshold     = 0.5
 	DefaultSnapshotExpansionSize = 1 * 1024 * 1024 * 1024
-	ParamSnapshotInitialSize     = "csi.aliyun.com/snapshot-initial-size"
-	ParamSnapshotThreshold       = "csi.aliyun.com/snapshot-expansion-threshold"
-	ParamSnapshotExpansionSize   = "csi.aliyun.com/snapshot-expansion-size"
+	// ParamKeyPrefix is the domain of keys of parameters, volume attributes
+	// and annotations owned by open-local
+	ParamKeyPrefix = "csi.aliyun.com/"
+	// LegacyParamKeyPrefix is the domain of keys of former releases, which is
+	// deprecated but still honored
+	LegacyParamKeyPrefix       = "storage.oecp.io/"
+	ParamSnapshotInitialSize   = ParamKeyPrefix + "snapshot-initial-size"
+	ParamSnapshotThreshold     = ParamKeyPrefix + "snapshot-expansion-threshold"
+	ParamSnapshotExpansionSize = ParamKeyPrefix + "snapshot-expansion-size"
+	ParamSnapshotFsFreeze      = ParamKeyPrefix + "snapshot-fsfreeze"
+	ParamSnapshotFullCopy      = ParamKeyPrefix + "snapshot-full-copy"
+	ParamCloneVerifyChecksum   = ParamKeyPrefix + "clone-verify-checksum"
+	// ParamSnapshotOriginGrowthRatio is the expected bytes of COW per byte written
+	// to origin, snapshot is pre-expanded by origin writes of the last cycle
+	// multiplied by the ratio if it is larger than expansion size
+	ParamSnapshotOriginGrowthRatio = ParamKeyPrefix + "snapshot-origin-growth-ratio"
+	// ParamSnapshotReadAhead is read ahead in 512-byte sectors set on snapshot
+	// lv to reduce copy-on-write traffic, 0 disables read ahead
+	ParamSnapshotReadAhead = ParamKeyPrefix + "snapshot-read-ahead"
+	// ParamSnapshotAlertThreshold is the usage of snapshot lv in percentage
+	// above which a warning event is recorded on its VolumeSnapshotContent,
+	// independent of expansion threshold. Alert is off if it is not set
+	ParamSnapshotAlertThreshold = ParamKeyPrefix + "snapshot-alert-threshold"
+	// ParamSnapshotCOWPVs is the pvs in vg of origin lv, separated by comma,
+	// where cow data of snapshot lv is allocated, such as faster or cheaper
+	// disks added to the vg. lvm never puts cow data in another vg
+	ParamSnapshotCOWPVs = ParamKeyPrefix + "snapshot-cow-pvs"
+	// ParamStoragePool is the storage which volume is allocated from: vg for
+	// LVM, mount point path for MountPoint and device path for Device
+	ParamStoragePool = ParamKeyPrefix + "storage-pool"
+	// ParamRequireContiguous requires vg to have contiguous free space as
+	// large as the requested volume when scheduling
+	ParamRequireContiguous = ParamKeyPrefix + "require-contiguous"
+	// ParamAllocationPolicy is passed to lvcreate --alloc to control placement
+	// of extents of lvm volume, contiguous also implies ParamRequireContiguous
+	ParamAllocationPolicy = ParamKeyPrefix + "allocation-policy"
+	// ParamVGSelector is the label selector of vg, e.g. pool=fast, lvm volume
+	// without vgName is only allocated from vg whose labels in nls spec match
+	ParamVGSelector = ParamKeyPrefix + "vg-selector"
+	// ParamDomainSelector is the label selector of node, e.g.
+	// topology.open-local.io/power-domain=pd-a, local volume is only placed on
+	// node in the matching fault domain. Annotation of pvc with the same key
+	// overrides parameter of storage class
+	ParamDomainSelector = ParamKeyPrefix + "domain-selector"
+	// ParamSnapshotReservePercent reserves the percentage of volume size in vg
+	// as snapshot headroom when provisioning
+	ParamSnapshotReservePercent = ParamKeyPrefix + "snapshot-reserve-percent"
+	// ParamSnapshotReservedSize records bytes of snapshot headroom reserved
+	// for the volume, it is released along with the volume
+	ParamSnapshotReservedSize = ParamKeyPrefix + "snapshot-reserved-size"
+	// ParamExpansionSnapshotPercent is the percentage of volume size taken
+	// from vg as cow space of a snapshot of lvm volume before its filesystem
+	// is grown in NodeExpandVolume, 0 or unset takes no snapshot
+	ParamExpansionSnapshotPercent = ParamKeyPrefix + "expansion-snapshot-percent"
+	// ParamIOAlignment records minimum and optimal io size of lv reported by
+	// node when lv is created, filesystem is aligned to it when formatting
+	ParamIOAlignment = ParamKeyPrefix + "io-alignment"
+	// ParamReservedBlocksPercent is the percentage of filesystem blocks reserved
+	// for root when formatting ext4, the same as mkfs.ext4 -m, other
+	// filesystems ignore it
+	ParamReservedBlocksPercent = ParamKeyPrefix + "ext4-reserved-blocks-percent"
+	// ParamEncrypted encrypts lvm volume by LUKS with passphrase in node stage
+	// secret of storage class
+	ParamEncrypted = ParamKeyPrefix + "encrypted"
+	// ParamZeroFill writes zeros across lvm volume when it is created, so that
+	// there is no first-write penalty of thin or lazily initialized storage
+	ParamZeroFill = ParamKeyPrefix + "zero-fill"
+	// ParamWipeOnDelete zeroes device volume when it is deleted, device is not
+	// allocated again until agent verifies it reads back zeros
+	ParamWipeOnDelete = ParamKeyPrefix + "wipe-on-delete"
+	// ParamDiscardOnDelete discards extents of lvm volume when it is removed,
+	// which is skipped if device of the volume does not support discard
+	ParamDiscardOnDelete = ParamKeyPrefix + "discard-on-delete"
+	// ParamMountPropagation is the propagation of bind mount of volume in
+	// NodePublishVolume, propagation of the parent mount is kept if unset
+	ParamMountPropagation = ParamKeyPrefix + "mount-propagation"
+	// ParamDeleteOrphanedSnapshots removes snapshots of lvm volume managed by
+	// open-local but referenced by no VolumeSnapshot, e.g. snapshots pending
+	// deletion, before the volume is deleted. Any other snapshot still blocks
+	// the deletion
+	ParamDeleteOrphanedSnapshots = ParamKeyPrefix + "delete-orphaned-snapshots"
+	// AnnoWipingDevices is the annotation of nls listing devices released with
+	// wipe on delete, separated by comma, agent removes device once it is wiped
+	AnnoWipingDevices = ParamKeyPrefix + "wiping-devices"
+	// AnnoPreallocatedLVs is the annotation of nls recording unassigned
+	// preallocated lvs of every vg in json, set by csi controller. They are
+	// bound to no pv, so schedulers take them from capacity and lv limit of
+	// vg by the annotation rather than by status reported by agent
+	AnnoPreallocatedLVs = ParamKeyPrefix + "preallocated-lvs"
+	// AnnoDiscoveryPaused is the annotation of nls pausing discovery and
+	// snapshot expansion of agent if it is "true", csi keeps working
+	AnnoDiscoveryPaused = ParamKeyPrefix + "discovery-paused"
+	// EncryptionPassphraseKey is the key of LUKS passphrase in node stage secret
+	EncryptionPassphraseKey = "encryptionPassphrase"
+
+	// VGMetadataMinFree is the free metadata area lvm needs to commit a new
+	// lv, each lv takes about 1KiB in vg metadata
+	VGMetadataMinFree = 8 * 1024
 
 	// VolumeType MUST BE case sensitive
 	VolumeTypeMountPoint VolumeType = "MountPoint"
@@ -95,16 +225,17 @@ const (
 	// This annotation is added to a PVC that has been triggered by scheduler to
 	// be dynamically provisioned. Its value is the name of the selected node.
 	AnnoSelectedNode                     = "volume.kubernetes.io/selected-node"
-	LabelReschduleTimestamp              = "csi.aliyun.com/reschdule-timestamp"
+	LabelReschduleTimestamp              = ParamKeyPrefix + "reschdule-timestamp"
 	EnvForceCreateVG                     = "Force_Create_VG"
 	PendingWithoutScheduledFieldSelector = "status.phase=Pending,spec.nodeName="
 	TriggerPendingPodCycle               = time.Second * 300
 
-	ParamSnapshotID       = "csi.aliyun.com/snapshot-id"
-	ParamReadonly         = "csi.aliyun.com/readonly"
-	ParamSourceVolumeID   = "csi.aliyun.com/source-volume-id"
+	ParamSnapshotID       = ParamKeyPrefix + "snapshot-id"
+	ParamReadonly         = ParamKeyPrefix + "readonly"
+	ParamSourceVolumeID   = ParamKeyPrefix + "source-volume-id"
 	ParamVGName           = "vgName"
 	ParamLVSize           = "size"
+	ParamLVName           = "lvName"
 	EnvSnapshotPrefix     = "SNAPSHOT_PREFIX"
 	EnvExpandSnapInterval = "Expand_Snapshot_Interval"
 	DefaultSnapshotPrefix = "snap"
@@ -137,7 +268,11 @@ const (
 	Lvm2PVTagsTag = "LVM2_PV_TAGS"
 
 	// EVENT
-	EventCreateVGFailed = "CreateVGFailed"
+	EventCreateVGFailed         = "CreateVGFailed"
+	EventAttachLoopDeviceFailed = "AttachLoopDeviceFailed"
+	EventWipeDeviceFailed       = "WipeDeviceFailed"
+	EventStatusDrift            = "StatusDrift"
+	EventSnapshotUsageAlert     = "SnapshotUsageAlert"
 
 	NsenterCmd = "nsenter --mount=/proc/1/ns/mnt --ipc=/proc/1/ns/ipc --net=/proc/1/ns/net --uts=/proc/1/ns/uts "
 
@@ -146,14 +281,45 @@ const (
 		- update by schedulerFramework prebind
 		- read by schedulerFramework eventHandlers onPodAdd/Update
 	*/
-	AnnotationPodPVCAllocatedNeedMigrateKey = "csi.aliyun.com/pod-pvc-migrate"
+	AnnotationPodPVCAllocatedNeedMigrateKey = ParamKeyPrefix + "pod-pvc-migrate"
 
 	/*
 		record: vgName to PV
 		- update by nsl controller
 		- read by csi: nodeServer publishVolume
 	*/
-	AnnotationPVAllocatedInfoKey = "csi.aliyun.com/pv-allocated"
+	AnnotationPVAllocatedInfoKey = ParamKeyPrefix + "pv-allocated"
+
+	/*
+		record: lv of pv is being moved to the vg in value
+		- set and removed by rebalance
+		- read by csi: nodeServer publishVolume, which refuses to publish until removed
+	*/
+	AnnotationPVMovingToKey = ParamKeyPrefix + "moving-to"
+
+	/*
+		record: io throttling modified after pv is created
+		- update by csi: controllerServer modifyVolume
+		- read by csi: nodeServer publishVolume
+	*/
+	AnnotationPVIOPSKey = ParamKeyPrefix + "iops"
+	AnnotationPVBPSKey  = ParamKeyPrefix + "bps"
+
+	/*
+		record: failure domain across which lvm volumes of the pod are spread
+		- set by user on pod
+		- read by scheduler: extender predicate/priority and schedulerFramework filter
+	*/
+	AnnotationPodVolumeSpreadKey = ParamKeyPrefix + "volume-spread"
+	// VolumeSpreadVG places every lvm volume of the pod in a distinct vg
+	VolumeSpreadVG = "vg"
+
+	/*
+		record: existing lv adopted as the volume of pvc, in the form of vg/lv
+		- set by user on pvc
+		- read by csi: controllerServer createVolume
+	*/
+	AnnotationPVCAdoptLVKey = ParamKeyPrefix + "adopt-lv"
 
 	AnnDeletionSecretRefName      = "snapshot.storage.kubernetes.io/deletion-secret-name"
 	AnnDeletionSecretRefNamespace = "snapshot.storage.kubernetes.io/deletion-secret-namespace"
@@ -172,8 +338,14 @@ var (
 		VolumeTypeDevice,
 		VolumeTypeQuota,
 	}
-	SupportedFS       = []string{VolumeFSTypeExt3, VolumeFSTypeExt4, VolumeFSTypeXFS}
-	SchedulerStrategy = StrategyBinpack
+	SupportedFS = []string{VolumeFSTypeExt3, VolumeFSTypeExt4, VolumeFSTypeXFS}
+	// AllocationPolicies are values of ParamAllocationPolicy supported by lvcreate
+	AllocationPolicies = []string{AllocationPolicyContiguous, "cling", "normal", "anywhere"}
+	SchedulerStrategy  = StrategyBinpack
+	// MountPropagations are values of ParamMountPropagation
+	MountPropagations = []string{"rshared", "rslave", "private"}
+	// VGConsistentHashing makes lvm volume without vgName try the vg hashed by its pvc first
+	VGConsistentHashing = false
 
 	S3_URL            = "s3URL"
 	S3_AK             = "s3AK"
@@ -268,6 +440,14 @@ func GetAllocateInfoJSONFromPod(pod *corev1.Pod) string {
 	return pod.Annotations[AnnotationPodPVCAllocatedNeedMigrateKey]
 }
 
+// IsVolumeSpreadByVG returns true if lvm volumes of pod must be placed in distinct vgs
+func IsVolumeSpreadByVG(pod *corev1.Pod) bool {
+	if pod == nil || pod.Annotations == nil {
+		return false
+	}
+	return pod.Annotations[AnnotationPodVolumeSpreadKey] == VolumeSpreadVG
+}
+
 func VolumeTypeFromString(s string) (VolumeType, error) {
 	for _, v := range ValidVolumeType {
 		if string(v) == s {
@@ -277,6 +457,24 @@ func VolumeTypeFromString(s string) (VolumeType, error) {
 	return "", fmt.Errorf("invalid Local Volume type: %q, valid values are %s", s, ValidVolumeType)
 }
 
+// AllocationPolicyContiguous allocates all extents of lv adjacent to each other
+const AllocationPolicyContiguous = "contiguous"
+
+// ReplicaAffinity boosts score of nodes already hosting other replicas of the
+// workload of pod, so that local volumes of a workload land close together
+type ReplicaAffinity struct {
+	// Weight is added to score of node hosting a replica, 0 means disabled
+	Weight int
+	// Label identifies replicas by the same value of this pod label, pods
+	// without it fall back to the same controller owner reference
+	Label string
+}
+
+// Enabled returns true if replica affinity takes part in scoring
+func (a ReplicaAffinity) Enabled() bool {
+	return a.Weight > 0
+}
+
 type NodeAntiAffinityWeight struct {
 	weights map[VolumeType]int
 }
@@ -0,0 +1,144 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsBlockSize is the block size of ext and xfs created by mkfs
+	fsBlockSize = 4096
+	// maxStripeSize is the largest stripe size of lvm, the default extent size
+	maxStripeSize = 4 * 1024 * 1024
+)
+
+// IOAlignment is the io size hint of block device reported by kernel, raid
+// and striped lv report chunk size as minimum and full stripe as optimal
+type IOAlignment struct {
+	MinimumIOSize uint64
+	OptimalIOSize uint64
+}
+
+// String returns alignment in the form recorded in volume context
+func (a IOAlignment) String() string {
+	return fmt.Sprintf("minimum_io_size=%d,optimal_io_size=%d", a.MinimumIOSize, a.OptimalIOSize)
+}
+
+// NeedsAlignment is false for device without hint beyond filesystem block,
+// such as a single ssd reporting 4KiB minimum io size
+func (a IOAlignment) NeedsAlignment() bool {
+	return a.MinimumIOSize > fsBlockSize || a.OptimalIOSize > fsBlockSize
+}
+
+// StripeSize returns the stripe size for striped lv, 0 means default of lvm.
+// lvm requires stripe size of power of 2 no larger than extent size
+func (a IOAlignment) StripeSize() uint64 {
+	size := a.MinimumIOSize
+	if size <= fsBlockSize || size > maxStripeSize || size&(size-1) != 0 {
+		return 0
+	}
+	return size
+}
+
+// stripeWidth returns optimal io size in unit of stride, 1 if optimal io size
+// is not a multiple of stride
+func (a IOAlignment) stripeWidth(stride uint64) uint64 {
+	if a.OptimalIOSize == 0 || a.OptimalIOSize%stride != 0 || a.OptimalIOSize < stride {
+		return 1
+	}
+	return a.OptimalIOSize / stride
+}
+
+// MkfsAlignmentArgs returns mkfs arguments of stride and stripe width aligned
+// to device, nil if device needs no alignment or fsType is not supported
+func MkfsAlignmentArgs(fsType string, a IOAlignment) []string {
+	if !a.NeedsAlignment() {
+		return nil
+	}
+	stride := a.MinimumIOSize
+	if stride < fsBlockSize {
+		stride = fsBlockSize
+	}
+	if stride%fsBlockSize != 0 {
+		return nil
+	}
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		// in unit of filesystem block
+		strideBlocks := stride / fsBlockSize
+		return []string{"-E", fmt.Sprintf("stride=%d,stripe_width=%d", strideBlocks, strideBlocks*a.stripeWidth(stride))}
+	case "xfs":
+		// su in bytes and sw in unit of su
+		return []string{"-d", fmt.Sprintf("su=%d,sw=%d", stride, a.stripeWidth(stride))}
+	}
+	return nil
+}
+
+// ReadIOAlignment reads io size hint of block device dev from sysfs
+func ReadIOAlignment(sysPath, dev string) (IOAlignment, error) {
+	stat := syscall.Stat_t{}
+	if err := syscall.Stat(dev, &stat); err != nil {
+		return IOAlignment{}, err
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return IOAlignment{}, fmt.Errorf("%s is not a block device", dev)
+	}
+	return readQueueAlignment(sysPath, uint64(unix.Major(uint64(stat.Rdev))), uint64(unix.Minor(uint64(stat.Rdev))))
+}
+
+func readQueueAlignment(sysPath string, maj, min uint64) (IOAlignment, error) {
+	queue := filepath.Join(sysPath, "dev/block", fmt.Sprintf("%d:%d", maj, min), "queue")
+	minimum, err := readUintFile(filepath.Join(queue, "minimum_io_size"))
+	if err != nil {
+		return IOAlignment{}, err
+	}
+	optimal, err := readUintFile(filepath.Join(queue, "optimal_io_size"))
+	if err != nil {
+		return IOAlignment{}, err
+	}
+	return IOAlignment{MinimumIOSize: minimum, OptimalIOSize: optimal}, nil
+}
+
+// DiscardSupported tells whether block device of the major and minor number
+// accepts discard, which is false for most of hdd
+func DiscardSupported(sysPath string, maj, min uint64) (bool, error) {
+	maxBytes, err := readUintFile(filepath.Join(sysPath, "dev/block", fmt.Sprintf("%d:%d", maj, min), "queue", "discard_max_bytes"))
+	if err != nil {
+		return false, err
+	}
+	return maxBytes > 0, nil
+}
+
+func readUintFile(path string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid content %q of %s", string(content), path)
+	}
+	return value, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_MkfsAlignmentArgs(t *testing.T) {
+	tests := []struct {
+		name           string
+		minimumIOSize  string
+		optimalIOSize  string
+		fsType         string
+		want           []string
+		wantStripeSize uint64
+	}{
+		{
+			name:          "test ssd without hint",
+			minimumIOSize: "4096",
+			optimalIOSize: "0",
+			fsType:        "ext4",
+			want:          nil,
+		},
+		{
+			name:           "test ext4 on striped lv of 4 pvs",
+			minimumIOSize:  "65536",
+			optimalIOSize:  "262144",
+			fsType:         "ext4",
+			want:           []string{"-E", "stride=16,stripe_width=64"},
+			wantStripeSize: 65536,
+		},
+		{
+			name:           "test xfs on striped lv of 4 pvs",
+			minimumIOSize:  "65536",
+			optimalIOSize:  "262144",
+			fsType:         "xfs",
+			want:           []string{"-d", "su=65536,sw=4"},
+			wantStripeSize: 65536,
+		},
+		{
+			name:          "test ext4 on ssd with optimal io size only",
+			minimumIOSize: "4096",
+			optimalIOSize: "131072",
+			fsType:        "ext4",
+			want:          []string{"-E", "stride=1,stripe_width=32"},
+		},
+		{
+			name:           "test raid whose optimal io size is not full stripes",
+			minimumIOSize:  "524288",
+			optimalIOSize:  "786433",
+			fsType:         "ext4",
+			want:           []string{"-E", "stride=128,stripe_width=128"},
+			wantStripeSize: 524288,
+		},
+		{
+			name:           "test unsupported filesystem",
+			minimumIOSize:  "65536",
+			optimalIOSize:  "262144",
+			fsType:         "btrfs",
+			want:           nil,
+			wantStripeSize: 65536,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysPath := t.TempDir()
+			queue := filepath.Join(sysPath, "dev/block/253:3/queue")
+			if err := os.MkdirAll(queue, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(queue, "minimum_io_size"), []byte(tt.minimumIOSize+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(queue, "optimal_io_size"), []byte(tt.optimalIOSize+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			alignment, err := readQueueAlignment(sysPath, 253, 3)
+			if err != nil {
+				t.Fatalf("readQueueAlignment() error = %v", err)
+			}
+			if got := MkfsAlignmentArgs(tt.fsType, alignment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MkfsAlignmentArgs() = %v, want %v", got, tt.want)
+			}
+			if got := alignment.StripeSize(); got != tt.wantStripeSize {
+				t.Errorf("StripeSize() = %d, want %d", got, tt.wantStripeSize)
+			}
+		})
+	}
+}
+
+func Test_DiscardSupported(t *testing.T) {
+	tests := []struct {
+		name            string
+		discardMaxBytes string
+		want            bool
+		wantErr         bool
+	}{
+		{name: "test ssd", discardMaxBytes: "2147450880", want: true},
+		{name: "test hdd", discardMaxBytes: "0", want: false},
+		{name: "test unknown device", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysPath := t.TempDir()
+			if tt.discardMaxBytes != "" {
+				queue := filepath.Join(sysPath, "dev/block/253:3/queue")
+				if err := os.MkdirAll(queue, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(queue, "discard_max_bytes"), []byte(tt.discardMaxBytes+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := DiscardSupported(sysPath, 253, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiscardSupported() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DiscardSupported() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
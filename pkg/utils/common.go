@@ -18,6 +18,7 @@ limitations under the License.
 package utils
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -28,10 +29,12 @@ import (
 	"os/exec"
 	"reflect"
 	"strings"
+	"syscall"
 	"time"
 
 	localtype "github.com/alibaba/open-local/pkg"
 	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/utils/fault"
 	csilib "github.com/container-storage-interface/spec/lib/go/csi"
 	snapshotapi "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	snapshot "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
@@ -44,6 +47,7 @@ import (
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	storagev1informers "k8s.io/client-go/informers/storage/v1"
 	"k8s.io/client-go/kubernetes"
@@ -206,6 +210,22 @@ func GetMountPointFromCsiPV(pv *corev1.PersistentVolume) string {
 	return ""
 }
 
+// GetIOThrottlingFromCsiPV returns iops and bps of pv, limits modified by
+// ControllerModifyVolume override those of storage class
+func GetIOThrottlingFromCsiPV(pv *corev1.PersistentVolume) (iops string, bps string) {
+	if pv.Spec.CSI != nil {
+		iops = pv.Spec.CSI.VolumeAttributes[localtype.VolumeIOPS]
+		bps = pv.Spec.CSI.VolumeAttributes[localtype.VolumeBPS]
+	}
+	if value, ok := pv.Annotations[localtype.AnnotationPVIOPSKey]; ok {
+		iops = value
+	}
+	if value, ok := pv.Annotations[localtype.AnnotationPVBPSKey]; ok {
+		bps = value
+	}
+	return iops, bps
+}
+
 func GetNodeNameFromCsiPV(pv *corev1.PersistentVolume) string {
 	if pv.Spec.NodeAffinity == nil {
 		log.Errorf("pv %s with nil nodeAffinity", pv.Name)
@@ -250,6 +270,90 @@ func GetVGRequested(localPVs map[string]corev1.PersistentVolume, vgName string)
 	return requested
 }
 
+// IsLVOfVG returns true if pv is backed by its own open-local lv in VG
+// vgName, which counts toward lv limit of the VG. ro snapshot pv uses snapshot
+// lv rather than creating a new one
+func IsLVOfVG(pv *corev1.PersistentVolume, vgName string) bool {
+	if isLocal, volumeType := IsOpenLocalPV(pv); !isLocal || volumeType != localtype.VolumeTypeLVM {
+		return false
+	}
+	if IsReadOnlyPV(pv) {
+		return false
+	}
+	return GetVGNameFromCsiPV(pv) == vgName
+}
+
+// GetVGLVCount returns number of open-local lvs of localPVs in VG vgName
+func GetVGLVCount(localPVs map[string]corev1.PersistentVolume, vgName string) (count int64) {
+	for _, pv := range localPVs {
+		if IsLVOfVG(&pv, vgName) {
+			count++
+		}
+	}
+	return count
+}
+
+// IsVGLVLimitReached returns true if number of open-local lv in VG reaches
+// lvLimit, lvLimit 0 means unlimited
+func IsVGLVLimitReached(lvCount, lvLimit int64) bool {
+	return lvLimit > 0 && lvCount >= lvLimit
+}
+
+// IsVGMetadataLow returns true if free metadata area of VG is less than
+// threshold(ratio) of its size
+func IsVGMetadataLow(free, size uint64, threshold float64) bool {
+	return size > 0 && float64(free) < float64(size)*threshold
+}
+
+// IsVGMetadataExhausted returns true if metadata area of VG has no room for
+// a new lv, size 0 means metadata usage is not reported
+func IsVGMetadataExhausted(free, size uint64) bool {
+	return size > 0 && free < localtype.VGMetadataMinFree
+}
+
+// IsVGInMaintenance returns true if vg is listed in maintenance of nls spec
+func IsVGInMaintenance(nls *nodelocalstorage.NodeLocalStorage, vgName string) bool {
+	if nls == nil {
+		return false
+	}
+	return ContainsString(nls.Spec.ListConfig.VGs.Maintenance, vgName)
+}
+
+// IsDiscoveryPaused returns true if discovery of node is paused by annotation of nls
+func IsDiscoveryPaused(nls *nodelocalstorage.NodeLocalStorage) bool {
+	if nls == nil {
+		return false
+	}
+	return nls.Annotations[localtype.AnnoDiscoveryPaused] == "true"
+}
+
+// GetVGLabels returns labels of vg in nls spec, nil if vg has no label
+func GetVGLabels(nls *nodelocalstorage.NodeLocalStorage, vgName string) map[string]string {
+	if nls == nil {
+		return nil
+	}
+	for _, vg := range nls.Spec.ListConfig.VGs.Labels {
+		if vg.Name == vgName {
+			return vg.Labels
+		}
+	}
+	return nil
+}
+
+// IsNodeStorageStale returns true if status of nls is not refreshed by
+// discovery within window. nls never discovered is not stale as it has no
+// capacity to provision, and window not positive disables the check
+func IsNodeStorageStale(nls *nodelocalstorage.NodeLocalStorage, window time.Duration, now time.Time) bool {
+	if nls == nil || window <= 0 {
+		return false
+	}
+	heartbeat := nls.Status.NodeStorageInfo.State.LastHeartbeatTime
+	if heartbeat == nil {
+		return false
+	}
+	return now.Sub(heartbeat.Time) > window
+}
+
 // CheckDiskOptions excludes mp which is readyonly or with unsupported fs type
 func CheckMountPointOptions(mp *nodelocalstorage.MountPoint) bool {
 	if mp == nil {
@@ -352,17 +456,33 @@ func HashStorageSpec(obj interface{}) uint64 {
 	return 0
 }
 
-// HashWithoutState remove the state field then compare
+// HashWithoutState remove the state field then compare, vg policy in spec and
+// wiping devices in annotation are included so that they take effect without
+// waiting for status update
 func HashWithoutState(storage *nodelocalstorage.NodeLocalStorage) uint64 {
 	if storage == nil {
 		return 0
 	}
-	cloned := storage.DeepCopy().Status
-	cloned.NodeStorageInfo.State = nodelocalstorage.StorageState{}
-	cloned.FilteredStorageInfo.UpdateStatus = nodelocalstorage.UpdateStatusInfo{}
+	cloned := storage.DeepCopy()
+	cloned.Status.NodeStorageInfo.State = nodelocalstorage.StorageState{}
+	cloned.Status.FilteredStorageInfo.UpdateStatus = nodelocalstorage.UpdateStatusInfo{}
 	hash := fnv.New32a()
 
-	hashutil.DeepHashObject(hash, cloned)
+	hashutil.DeepHashObject(hash, struct {
+		Status            nodelocalstorage.NodeLocalStorageStatus
+		MaxLogicalVolumes int
+		Maintenance       []string
+		MinFreeSize       string
+		WipingDevices     []string
+		PreallocatedLVs   map[string]PreallocatedLVs
+	}{
+		Status:            cloned.Status,
+		MaxLogicalVolumes: cloned.Spec.ListConfig.VGs.MaxLogicalVolumes,
+		Maintenance:       cloned.Spec.ListConfig.VGs.Maintenance,
+		MinFreeSize:       cloned.Spec.ListConfig.VGs.MinFreeSize,
+		WipingDevices:     WipingDevices(cloned),
+		PreallocatedLVs:   GetPreallocatedLVs(cloned),
+	})
 	return uint64(hash.Sum32())
 }
 
@@ -431,6 +551,47 @@ func GetVGNameFromPVC(pvc *corev1.PersistentVolumeClaim, scLister storagelisters
 	return vgName, nil
 }
 
+// GetVGSelectorFromPVC returns label selector of vg in storage class of pvc,
+// which is labels.Everything() if not set
+func GetVGSelectorFromPVC(pvc *corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) (labels.Selector, error) {
+	sc, err := GetStorageClassFromPVC(pvc, scLister)
+	if err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		return labels.Everything(), nil
+	}
+	return ParseVGSelector(sc.Parameters)
+}
+
+// ParseVGSelector parses csi.aliyun.com/vg-selector of parameters in form of
+// label selector, e.g. pool=fast,tier in (a,b)
+func ParseVGSelector(params map[string]string) (labels.Selector, error) {
+	value, exist := LookupParam(params, localtype.ParamVGSelector)
+	if !exist {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %s", localtype.ParamVGSelector, value, err.Error())
+	}
+	return selector, nil
+}
+
+// IsContiguousRequiredPVC returns true if storage class of pvc requires
+// contiguous free space for the volume, so does contiguous allocation policy
+func IsContiguousRequiredPVC(pvc *corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) (bool, error) {
+	sc, err := GetStorageClassFromPVC(pvc, scLister)
+	if err != nil {
+		return false, err
+	}
+	if sc == nil {
+		return false, nil
+	}
+	return GetParam(sc.Parameters, localtype.ParamRequireContiguous) == "true" ||
+		GetParam(sc.Parameters, localtype.ParamAllocationPolicy) == localtype.AllocationPolicyContiguous, nil
+}
+
 func GetMediaTypeFromPVC(pvc *corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) (localtype.MediaType, error) {
 	sc, err := GetStorageClassFromPVC(pvc, scLister)
 	if err != nil {
@@ -650,6 +811,9 @@ type CommandRunFunc func(cmd string) (string, error)
 
 // Run run shell command
 func Run(cmd string) (string, error) {
+	if err := fault.Check(context.Background(), cmd); err != nil {
+		return "", fmt.Errorf("Failed to run cmd: %s, with out: %w, with error: injected fault", cmd, err)
+	}
 	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + string(out) + ", with error: " + err.Error())
@@ -657,6 +821,40 @@ func Run(cmd string) (string, error) {
 	return string(out), nil
 }
 
+// RunContext runs cmd like Run and kills the whole process group of cmd once
+// ctx is done, since killing sh alone leaves its children running
+func RunContext(ctx context.Context, cmd string) (string, error) {
+	if err := fault.Check(ctx, cmd); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("cmd %s is cancelled: %w", cmd, ctx.Err())
+		}
+		return "", fmt.Errorf("Failed to run cmd: %s, with out: %w, with error: injected fault", cmd, err)
+	}
+	c := exec.Command("sh", "-c", cmd)
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	c.Stderr = out
+	if err := c.Start(); err != nil {
+		return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with error: " + err.Error())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", fmt.Errorf("Failed to run cmd: " + cmd + ", with out: " + out.String() + ", with error: " + err.Error())
+		}
+		return out.String(), nil
+	case <-ctx.Done():
+		_ = syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		<-done
+		return "", fmt.Errorf("cmd %s is cancelled: %w", cmd, ctx.Err())
+	}
+}
+
 // GetMetrics get path metric
 func GetMetrics(path string) (*csilib.NodeGetVolumeStatsResponse, error) {
 	if path == "" {
@@ -815,7 +1013,7 @@ func IsBlockDevice(fullPath string) (bool, error) {
 func IsReadOnlyPV(pv *corev1.PersistentVolume) bool {
 	if pv.Spec.CSI != nil {
 		attributes := pv.Spec.CSI.VolumeAttributes
-		if value, exist := attributes[localtype.ParamReadonly]; exist && value == "true" {
+		if value, exist := LookupParam(attributes, localtype.ParamReadonly); exist && value == "true" {
 			return true
 		}
 	}
@@ -860,7 +1058,7 @@ func IsSnapshotClassReadOnly(className string, snapInformer volumesnapshotinform
 		log.Warningf("fail to get snapshotClass %s(may have been deleted): %s", className, err.Error())
 		return false
 	}
-	if value, exist := snapshotClass.Parameters[localtype.ParamReadonly]; exist && value == "true" {
+	if value, exist := LookupParam(snapshotClass.Parameters, localtype.ParamReadonly); exist && value == "true" {
 		return true
 	}
 	return false
@@ -872,7 +1070,7 @@ func IsSnapshotClassReadOnly2(className string, snapClient snapshot.Interface) b
 		log.Warningf("fail to get snapshotClass %s(may have been deleted): %s", className, err.Error())
 		return false
 	}
-	if value, exist := snapshotClass.Parameters[localtype.ParamReadonly]; exist && value == "true" {
+	if value, exist := LookupParam(snapshotClass.Parameters, localtype.ParamReadonly); exist && value == "true" {
 		return true
 	}
 	return false
@@ -0,0 +1,50 @@
+//go:build faultinjection
+// +build faultinjection
+
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alibaba/open-local/pkg/utils/fault"
+)
+
+func TestRun_InjectedFault(t *testing.T) {
+	defer fault.Inject("lvcreate", fault.Fault{Err: syscall.ENOSPC})()
+	// command never runs, the fault is returned like failure of command
+	_, err := Run("lvcreate -n lv -L 1024b vg")
+	if !errors.Is(err, syscall.ENOSPC) || !strings.Contains(err.Error(), "Failed to run cmd: lvcreate") {
+		t.Errorf("Run() error = %v, want failure of lvcreate with ENOSPC", err)
+	}
+}
+
+func TestRunContext_InjectedTimeout(t *testing.T) {
+	defer fault.Inject("lvextend", fault.Fault{Delay: time.Minute})()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := RunContext(ctx, "lvextend -L1024B vg/lv")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "is cancelled") {
+		t.Errorf("RunContext() error = %v, want cancelled by deadline", err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"hash/fnv"
+)
+
+// ConsistentHash picks one of candidates for key by rendezvous hashing, the
+// same key always gets the same candidate regardless of order of candidates,
+// and only keys of a removed candidate move when candidates change. It
+// returns empty string if there is no candidate.
+func ConsistentHash(key string, candidates []string) string {
+	var picked string
+	var highest uint64
+	for _, candidate := range candidates {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(key))
+		// separator keeps key "a"+"bc" from colliding with "ab"+"c"
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(candidate))
+		weight := hash.Sum64()
+		if picked == "" || weight > highest || (weight == highest && candidate < picked) {
+			picked, highest = candidate, weight
+		}
+	}
+	return picked
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ConsistentHash(t *testing.T) {
+	vgs := []string{"vg-a", "vg-b", "vg-c", "vg-d"}
+	reversed := []string{"vg-d", "vg-c", "vg-b", "vg-a"}
+	picked := map[string]int{}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("default/pvc-%d", i)
+		vg := ConsistentHash(key, vgs)
+		if vg == "" {
+			t.Fatalf("ConsistentHash(%q) returns no vg", key)
+		}
+		if again := ConsistentHash(key, vgs); again != vg {
+			t.Errorf("ConsistentHash(%q) = %s, then %s", key, vg, again)
+		}
+		if got := ConsistentHash(key, reversed); got != vg {
+			t.Errorf("ConsistentHash(%q) of reversed vgs = %s, want %s", key, got, vg)
+		}
+		// only keys of removed vg move
+		var rest []string
+		for _, name := range vgs {
+			if name != "vg-c" {
+				rest = append(rest, name)
+			}
+		}
+		if got := ConsistentHash(key, rest); vg != "vg-c" && got != vg {
+			t.Errorf("ConsistentHash(%q) without vg-c = %s, want %s", key, got, vg)
+		}
+		picked[vg]++
+	}
+	// keys are spread over all vgs
+	for _, vg := range vgs {
+		if picked[vg] == 0 {
+			t.Errorf("no key is hashed to %s: %v", vg, picked)
+		}
+	}
+	if got := ConsistentHash("default/pvc", nil); got != "" {
+		t.Errorf("ConsistentHash() of no candidate = %q, want empty", got)
+	}
+}
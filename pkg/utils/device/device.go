@@ -19,6 +19,7 @@ package device
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -75,10 +76,35 @@ func GetBlockInfo(sysPath, blockName string) (Device, error) {
 	device.MediaType = media
 	device.Total = total
 	device.ReadOnly = ro
+	device.Serial = readFirstAttribute(blockPath, serialAttributes)
+	device.WWN = readFirstAttribute(blockPath, wwnAttributes)
 
 	return device, nil
 }
 
+// serialAttributes are sysfs files of serial number, device/serial of nvme
+// and scsi disks, serial of virtio disks
+var serialAttributes = []string{"device/serial", "serial"}
+
+// wwnAttributes are sysfs files of wwid, wwid of nvme namespaces and
+// device/wwid of scsi disks
+var wwnAttributes = []string{"wwid", "device/wwid"}
+
+// readFirstAttribute returns trimmed content of the first non-empty file of
+// names under blockPath, empty if disk exposes none of them
+func readFirstAttribute(blockPath string, names []string) string {
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(blockPath, name))
+		if err != nil {
+			continue
+		}
+		if value := strings.TrimSpace(string(b)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
 func GetPartitionsInfo(sysPath, blockName string) ([]Device, error) {
 	var devices []Device
 
@@ -88,6 +114,10 @@ func GetPartitionsInfo(sysPath, blockName string) ([]Device, error) {
 		return nil, err
 	}
 	for _, dir := range dirs {
+		// sysfs marks partition by file partition, holding its number
+		if _, err := os.Stat(filepath.Join(blockPath, dir.Name(), "partition")); err != nil {
+			continue
+		}
 		if strings.HasPrefix(dir.Name(), blockName) {
 			var device Device
 			var media string
@@ -131,6 +161,7 @@ func GetPartitionsInfo(sysPath, blockName string) ([]Device, error) {
 
 			device.Name = fmt.Sprintf("/dev/%s", partName)
 			device.IsPartition = true
+			device.Parent = fmt.Sprintf("/dev/%s", blockName)
 			device.MediaType = media
 			device.Total = total
 			device.ReadOnly = ro
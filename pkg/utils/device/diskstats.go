@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DiskStatsPath is where kernel reports io statistics of block devices
+	DiskStatsPath = "/proc/diskstats"
+	// sectorSize is the unit of sectors in diskstats regardless of device
+	sectorSize = 512
+	// diskStatsMinFields is the number of fields kernel reports since 2.6
+	diskStatsMinFields = 14
+)
+
+// DiskStats is the cumulative io statistics of a block device since boot
+type DiskStats struct {
+	ReadsCompleted  uint64
+	SectorsRead     uint64
+	WritesCompleted uint64
+	SectorsWritten  uint64
+	// IOTimeMs is the time(millisecond) spent doing io
+	IOTimeMs uint64
+}
+
+// DiskRate is the io rate of a block device between two snapshots
+type DiskRate struct {
+	ReadIOPS            float64
+	WriteIOPS           float64
+	ReadBytesPerSecond  float64
+	WriteBytesPerSecond float64
+	// Utilization is the ratio of time device is busy, in [0, 1]
+	Utilization float64
+}
+
+// ReadDiskStatsFunc returns io statistics of block devices by kernel name
+type ReadDiskStatsFunc func() (map[string]DiskStats, error)
+
+// ReadBytes returns bytes read from device
+func (s DiskStats) ReadBytes() uint64 {
+	return s.SectorsRead * sectorSize
+}
+
+// WriteBytes returns bytes written to device
+func (s DiskStats) WriteBytes() uint64 {
+	return s.SectorsWritten * sectorSize
+}
+
+// Rate returns io rate from prev to s within seconds, false if counters of
+// device are reset or wrapped, e.g. device is detached and attached again
+func (s DiskStats) Rate(prev DiskStats, seconds float64) (DiskRate, bool) {
+	if seconds <= 0 ||
+		s.ReadsCompleted < prev.ReadsCompleted || s.SectorsRead < prev.SectorsRead ||
+		s.WritesCompleted < prev.WritesCompleted || s.SectorsWritten < prev.SectorsWritten ||
+		s.IOTimeMs < prev.IOTimeMs {
+		return DiskRate{}, false
+	}
+	rate := DiskRate{
+		ReadIOPS:            float64(s.ReadsCompleted-prev.ReadsCompleted) / seconds,
+		WriteIOPS:           float64(s.WritesCompleted-prev.WritesCompleted) / seconds,
+		ReadBytesPerSecond:  float64(s.ReadBytes()-prev.ReadBytes()) / seconds,
+		WriteBytesPerSecond: float64(s.WriteBytes()-prev.WriteBytes()) / seconds,
+		Utilization:         float64(s.IOTimeMs-prev.IOTimeMs) / 1000 / seconds,
+	}
+	// io time of merged requests may exceed wall time slightly
+	if rate.Utilization > 1 {
+		rate.Utilization = 1
+	}
+	return rate, true
+}
+
+// ParseDiskStats parses content of /proc/diskstats, fields are documented
+// in Documentation/admin-guide/iostats.rst of kernel
+func ParseDiskStats(content string) (map[string]DiskStats, error) {
+	stats := make(map[string]DiskStats)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < diskStatsMinFields {
+			return nil, fmt.Errorf("invalid line %q of diskstats", line)
+		}
+		var values [5]uint64
+		for i, index := range []int{3, 5, 7, 9, 12} {
+			value, err := strconv.ParseUint(fields[index], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid field %d of %s in diskstats: %s", index+1, fields[2], err.Error())
+			}
+			values[i] = value
+		}
+		stats[fields[2]] = DiskStats{
+			ReadsCompleted:  values[0],
+			SectorsRead:     values[1],
+			WritesCompleted: values[2],
+			SectorsWritten:  values[3],
+			IOTimeMs:        values[4],
+		}
+	}
+	return stats, nil
+}
+
+// ReadDiskStats reads io statistics of all block devices from /proc/diskstats
+func ReadDiskStats() (map[string]DiskStats, error) {
+	content, err := os.ReadFile(DiskStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDiskStats(string(content))
+}
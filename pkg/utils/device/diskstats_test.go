@@ -0,0 +1,139 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	// fields of kernel 5.5+, 4.18+ and 2.6+
+	diskStatsBefore = `   8       0 sda 1000 10 2048 500 2000 20 4096 800 0 1200 1300 0 0 0 0 0 0
+   8      16 sdb 100 0 800 50 200 0 1600 80 0 400 130
+ 253       0 dm-0 500 0 1024 250 1000 0 2048 400 0 600 650 0 0 0 0
+`
+	diskStatsAfter = `   8       0 sda 1500 10 4096 700 3000 20 12288 1200 0 6200 1900 0 0 0 0 0 0
+   8      16 sdb 100 0 800 50 200 0 1600 80 0 400 130
+ 253       0 dm-0 200 0 400 100 300 0 600 120 0 100 200 0 0 0 0
+`
+)
+
+func Test_ParseDiskStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]DiskStats
+		wantErr bool
+	}{
+		{
+			name:    "test all formats",
+			content: diskStatsBefore,
+			want: map[string]DiskStats{
+				"sda":  {ReadsCompleted: 1000, SectorsRead: 2048, WritesCompleted: 2000, SectorsWritten: 4096, IOTimeMs: 1200},
+				"sdb":  {ReadsCompleted: 100, SectorsRead: 800, WritesCompleted: 200, SectorsWritten: 1600, IOTimeMs: 400},
+				"dm-0": {ReadsCompleted: 500, SectorsRead: 1024, WritesCompleted: 1000, SectorsWritten: 2048, IOTimeMs: 600},
+			},
+		},
+		{
+			name:    "test empty",
+			content: "",
+			want:    map[string]DiskStats{},
+		},
+		{
+			name:    "test truncated line",
+			content: "8 0 sda 1000 10 2048",
+			wantErr: true,
+		},
+		{
+			name:    "test invalid field",
+			content: "8 0 sda 1000 10 x 500 2000 20 4096 800 0 1200 1300",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDiskStats(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDiskStats() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDiskStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_DiskStats_Rate(t *testing.T) {
+	before, err := ParseDiskStats(diskStatsBefore)
+	if err != nil {
+		t.Fatalf("ParseDiskStats() error = %v", err)
+	}
+	after, err := ParseDiskStats(diskStatsAfter)
+	if err != nil {
+		t.Fatalf("ParseDiskStats() error = %v", err)
+	}
+	tests := []struct {
+		device  string
+		seconds float64
+		want    DiskRate
+		wantOK  bool
+	}{
+		{
+			device:  "sda",
+			seconds: 10,
+			// 500 reads of 2048 sectors and 1000 writes of 8192 sectors in 10s, busy 5s
+			want:   DiskRate{ReadIOPS: 50, WriteIOPS: 100, ReadBytesPerSecond: 104857.6, WriteBytesPerSecond: 419430.4, Utilization: 0.5},
+			wantOK: true,
+		},
+		{
+			device:  "sda",
+			seconds: 4,
+			// busy time exceeds wall time
+			want:   DiskRate{ReadIOPS: 125, WriteIOPS: 250, ReadBytesPerSecond: 262144, WriteBytesPerSecond: 1048576, Utilization: 1},
+			wantOK: true,
+		},
+		{
+			device:  "sdb",
+			seconds: 10,
+			want:    DiskRate{},
+			wantOK:  true,
+		},
+		{
+			// counters are reset by reattaching
+			device:  "dm-0",
+			seconds: 10,
+			wantOK:  false,
+		},
+		{
+			device:  "sda",
+			seconds: 0,
+			wantOK:  false,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := after[tt.device].Rate(before[tt.device], tt.seconds)
+		if ok != tt.wantOK {
+			t.Errorf("Rate() of %s in %vs ok = %v, want %v", tt.device, tt.seconds, ok, tt.wantOK)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Rate() of %s in %vs = %+v, want %+v", tt.device, tt.seconds, got, tt.want)
+		}
+	}
+}
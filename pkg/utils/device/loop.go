@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+	log "k8s.io/klog/v2"
+)
+
+// LoopDevicePrefix is the prefix of loop device nodes
+const LoopDevicePrefix = "/dev/loop"
+
+// loopFilePathRegexp accepts plain absolute path only since backing file is
+// passed to losetup in shell
+var loopFilePathRegexp = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+var (
+	// replaced in unit test
+	runLoopCommand utils.CommandRunFunc = utils.Run
+)
+
+// IsLoopDevice returns true if dev is a loop device node, e.g. /dev/loop0
+func IsLoopDevice(dev string) bool {
+	suffix := strings.TrimPrefix(dev, LoopDevicePrefix)
+	if suffix == dev || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLoopFile validates backing file of loop device and returns its size in bytes
+func ParseLoopFile(path, size string) (uint64, error) {
+	if !loopFilePathRegexp.MatchString(path) || filepath.Clean(path) != path {
+		return 0, fmt.Errorf("backing file %q must be a clean absolute path of letters, digits, '.', '_', '-' and '/'", path)
+	}
+	if strings.HasPrefix(path, "/dev/") || strings.HasPrefix(path, "/proc/") || strings.HasPrefix(path, "/sys/") {
+		return 0, fmt.Errorf("backing file %s must be a regular file, not under /dev, /proc or /sys", path)
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q of backing file %s: %s", size, path, err.Error())
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("size %q of backing file %s must be positive", size, path)
+	}
+	return uint64(quantity.Value()), nil
+}
+
+// AttachLoopFile returns the loop device attached over backing file path. The
+// file is created sparse of size if absent and is extended but never shrunk
+// to size if present. A file attached already keeps its loop device, so it is
+// safe to call again, e.g. to attach the file once more after reboot
+func AttachLoopFile(path string, size uint64) (string, error) {
+	if _, err := runLoopCommand(fmt.Sprintf("%s mkdir -p %s", localtype.NsenterCmd, filepath.Dir(path))); err != nil {
+		return "", err
+	}
+	// '>' extends file to at least size and creates it if absent
+	if _, err := runLoopCommand(fmt.Sprintf("%s truncate --size='>%d' %s", localtype.NsenterCmd, size, path)); err != nil {
+		return "", err
+	}
+	out, err := runLoopCommand(fmt.Sprintf("%s losetup --noheadings --output NAME --associated %s", localtype.NsenterCmd, path))
+	if err != nil {
+		return "", err
+	}
+	for _, dev := range strings.Fields(out) {
+		if IsLoopDevice(dev) {
+			return dev, nil
+		}
+	}
+	out, err = runLoopCommand(fmt.Sprintf("%s losetup --find --show %s", localtype.NsenterCmd, path))
+	if err != nil {
+		return "", err
+	}
+	dev := strings.TrimSpace(out)
+	if !IsLoopDevice(dev) {
+		return "", fmt.Errorf("unexpected output of losetup over %s: %q", path, out)
+	}
+	log.Infof("[AttachLoopFile]loop device %s is attached over %s", dev, path)
+	return dev, nil
+}
+
+// DetachLoopDevice detaches loop device dev, backing file is left as is
+func DetachLoopDevice(dev string) error {
+	if !IsLoopDevice(dev) {
+		return fmt.Errorf("%s is not a loop device", dev)
+	}
+	_, err := runLoopCommand(fmt.Sprintf("%s losetup --detach %s", localtype.NsenterCmd, dev))
+	return err
+}
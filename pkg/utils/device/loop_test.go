@@ -0,0 +1,191 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_IsLoopDevice(t *testing.T) {
+	tests := []struct {
+		dev  string
+		want bool
+	}{
+		{dev: "/dev/loop0", want: true},
+		{dev: "/dev/loop12", want: true},
+		{dev: "/dev/loop", want: false},
+		{dev: "/dev/loop-control", want: false},
+		{dev: "/dev/vdb", want: false},
+		{dev: "loop0", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsLoopDevice(tt.dev); got != tt.want {
+			t.Errorf("IsLoopDevice(%s) = %v, want %v", tt.dev, got, tt.want)
+		}
+	}
+}
+
+func Test_ParseLoopFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		size    string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "test valid", path: "/var/lib/open-local/loop/pv0.img", size: "10Gi", want: 10 << 30},
+		{name: "test relative path", path: "loop/pv0.img", size: "10Gi", wantErr: true},
+		{name: "test unclean path", path: "/var/lib/../pv0.img", size: "10Gi", wantErr: true},
+		{name: "test shell metacharacter", path: "/tmp/pv0.img;reboot", size: "10Gi", wantErr: true},
+		{name: "test device path", path: "/dev/vdb", size: "10Gi", wantErr: true},
+		{name: "test invalid size", path: "/tmp/pv0.img", size: "ten", wantErr: true},
+		{name: "test zero size", path: "/tmp/pv0.img", size: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLoopFile(tt.path, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLoopFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLoopFile() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeLosetup records commands and answers losetup like a host where
+// attached maps backing files to loop devices
+type fakeLosetup struct {
+	attached map[string]string
+	next     string
+	cmds     []string
+}
+
+func (f *fakeLosetup) run(cmd string) (string, error) {
+	f.cmds = append(f.cmds, cmd)
+	fields := strings.Fields(cmd)
+	path := fields[len(fields)-1]
+	switch {
+	case strings.Contains(cmd, "losetup --noheadings --output NAME --associated"):
+		if dev, ok := f.attached[path]; ok {
+			return dev + "\n", nil
+		}
+		return "", nil
+	case strings.Contains(cmd, "losetup --find --show"):
+		if f.next == "" {
+			return "", fmt.Errorf("losetup: cannot find an unused loop device")
+		}
+		f.attached[path] = f.next
+		return f.next + "\n", nil
+	}
+	return "", nil
+}
+
+func (f *fakeLosetup) ran(sub string) bool {
+	for _, cmd := range f.cmds {
+		if strings.Contains(cmd, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_AttachLoopFile(t *testing.T) {
+	origin := runLoopCommand
+	defer func() { runLoopCommand = origin }()
+	tests := []struct {
+		name     string
+		attached map[string]string
+		next     string
+		wantDev  string
+		wantFind bool
+		wantErr  bool
+	}{
+		{
+			name:     "test attach new",
+			attached: map[string]string{},
+			next:     "/dev/loop3",
+			wantDev:  "/dev/loop3",
+			wantFind: true,
+		},
+		{
+			name:     "test reuse attached",
+			attached: map[string]string{"/var/lib/open-local/pv0.img": "/dev/loop1"},
+			next:     "/dev/loop3",
+			wantDev:  "/dev/loop1",
+			wantFind: false,
+		},
+		{
+			name:     "test no free loop device",
+			attached: map[string]string{},
+			wantFind: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeLosetup{attached: tt.attached, next: tt.next}
+			runLoopCommand = f.run
+			dev, err := AttachLoopFile("/var/lib/open-local/pv0.img", 1<<30)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AttachLoopFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if dev != tt.wantDev {
+				t.Errorf("AttachLoopFile() = %s, want %s", dev, tt.wantDev)
+			}
+			if !f.ran("mkdir -p /var/lib/open-local") || !f.ran("truncate --size='>1073741824' /var/lib/open-local/pv0.img") {
+				t.Errorf("backing file is not ensured, commands %v", f.cmds)
+			}
+			if got := f.ran("losetup --find --show"); got != tt.wantFind {
+				t.Errorf("losetup --find ran %v, want %v", got, tt.wantFind)
+			}
+		})
+	}
+}
+
+func Test_AttachLoopFile_UnexpectedOutput(t *testing.T) {
+	origin := runLoopCommand
+	defer func() { runLoopCommand = origin }()
+	runLoopCommand = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "--find") {
+			return "losetup: warning\n", nil
+		}
+		return "", nil
+	}
+	if _, err := AttachLoopFile("/tmp/pv0.img", 1<<30); err == nil {
+		t.Errorf("AttachLoopFile() expects error on unexpected output of losetup")
+	}
+}
+
+func Test_DetachLoopDevice(t *testing.T) {
+	origin := runLoopCommand
+	defer func() { runLoopCommand = origin }()
+	f := &fakeLosetup{attached: map[string]string{}}
+	runLoopCommand = f.run
+	if err := DetachLoopDevice("/dev/vdb"); err == nil {
+		t.Errorf("DetachLoopDevice() expects error on non loop device")
+	}
+	if err := DetachLoopDevice("/dev/loop2"); err != nil {
+		t.Errorf("DetachLoopDevice() error = %v", err)
+	}
+	if !f.ran("losetup --detach /dev/loop2") {
+		t.Errorf("loop device is not detached, commands %v", f.cmds)
+	}
+}
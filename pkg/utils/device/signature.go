@@ -0,0 +1,154 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+)
+
+const (
+	// SignatureTypePrefix marks a signature recognized by blkid, e.g. type:ceph_bluestore
+	SignatureTypePrefix = "type:"
+	// SignatureMagicPrefix marks magic bytes in hex at byte offset, e.g. magic:0:4c554b53
+	SignatureMagicPrefix = "magic:"
+
+	// maxMagicLength limits magic bytes read from device
+	maxMagicLength = 512
+)
+
+// Signature is an on-disk marker showing that device is in use
+type Signature struct {
+	// Type is the TYPE or PTTYPE reported by blkid
+	Type string
+	// Offset is the byte offset of Magic in device
+	Offset int64
+	// Magic is the bytes expected at Offset
+	Magic []byte
+	// spec is the text the signature parsed from
+	spec string
+}
+
+func (s Signature) String() string {
+	return s.spec
+}
+
+// ProbeTypeFunc returns types of signatures on device recognized by blkid
+type ProbeTypeFunc func(dev string) ([]string, error)
+
+// ParseSignatures parses signatures in form of type:<blkid type> or
+// magic:<offset>:<hex bytes>
+func ParseSignatures(specs []string) ([]Signature, error) {
+	var signatures []Signature
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(spec, SignatureTypePrefix):
+			sigType := strings.TrimPrefix(spec, SignatureTypePrefix)
+			if sigType == "" {
+				return nil, fmt.Errorf("signature %q has empty type", spec)
+			}
+			signatures = append(signatures, Signature{Type: sigType, spec: spec})
+		case strings.HasPrefix(spec, SignatureMagicPrefix):
+			parts := strings.Split(strings.TrimPrefix(spec, SignatureMagicPrefix), ":")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("signature %q must be in form of %s<offset>:<hex bytes>", spec, SignatureMagicPrefix)
+			}
+			offset, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil || offset < 0 {
+				return nil, fmt.Errorf("signature %q has invalid offset %q", spec, parts[0])
+			}
+			magic, err := hex.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("signature %q has invalid hex bytes: %s", spec, err.Error())
+			}
+			if len(magic) == 0 || len(magic) > maxMagicLength {
+				return nil, fmt.Errorf("signature %q must have 1 to %d magic bytes", spec, maxMagicLength)
+			}
+			signatures = append(signatures, Signature{Offset: offset, Magic: magic, spec: spec})
+		default:
+			return nil, fmt.Errorf("signature %q must start with %q or %q", spec, SignatureTypePrefix, SignatureMagicPrefix)
+		}
+	}
+	return signatures, nil
+}
+
+// FindSignature returns the first signature found on device, ok is false if
+// device carries none of signatures
+func FindSignature(dev string, signatures []Signature, probeType ProbeTypeFunc) (sig Signature, ok bool, err error) {
+	var types []string
+	probed := false
+	for _, s := range signatures {
+		if s.Type == "" {
+			continue
+		}
+		if !probed {
+			if types, err = probeType(dev); err != nil {
+				return Signature{}, false, err
+			}
+			probed = true
+		}
+		for _, t := range types {
+			if t == s.Type {
+				return s, true, nil
+			}
+		}
+	}
+
+	var f *os.File
+	for _, s := range signatures {
+		if len(s.Magic) == 0 {
+			continue
+		}
+		if f == nil {
+			if f, err = os.Open(dev); err != nil {
+				return Signature{}, false, err
+			}
+			defer f.Close()
+		}
+		buf := make([]byte, len(s.Magic))
+		// device smaller than offset does not carry the signature
+		if n, _ := f.ReadAt(buf, s.Offset); n == len(buf) && bytes.Equal(buf, s.Magic) {
+			return s, true, nil
+		}
+	}
+	return Signature{}, false, nil
+}
+
+// ProbeType returns TYPE and PTTYPE of device by blkid
+func ProbeType(dev string) ([]string, error) {
+	cmd := fmt.Sprintf("%s blkid -p -s TYPE -s PTTYPE -o value %s", localtype.NsenterCmd, dev)
+	out, err := utils.Run(cmd)
+	if err != nil {
+		// blkid exits with 2 if no signature is found
+		if strings.Contains(err.Error(), "exit status 2") {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
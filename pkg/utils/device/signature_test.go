@@ -0,0 +1,144 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseSignatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name:    "test empty",
+			specs:   nil,
+			wantLen: 0,
+			wantErr: false,
+		},
+		{
+			name:    "test type and magic",
+			specs:   []string{"type:ceph_bluestore", "magic:1024:4f4c4d41524b"},
+			wantLen: 2,
+			wantErr: false,
+		},
+		{
+			name:    "test empty type",
+			specs:   []string{"type:"},
+			wantErr: true,
+		},
+		{
+			name:    "test invalid offset",
+			specs:   []string{"magic:-1:4f4c"},
+			wantErr: true,
+		},
+		{
+			name:    "test invalid hex",
+			specs:   []string{"magic:0:xyz"},
+			wantErr: true,
+		},
+		{
+			name:    "test missing magic",
+			specs:   []string{"magic:0"},
+			wantErr: true,
+		},
+		{
+			name:    "test unknown prefix",
+			specs:   []string{"label:data"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignatures(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSignatures() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("ParseSignatures() got %d signatures, want %d", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func Test_FindSignature(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "disk")
+	data := make([]byte, 4096)
+	copy(data[1024:], []byte("OLMARK"))
+	if err := os.WriteFile(dev, data, 0644); err != nil {
+		t.Fatalf("write device error: %s", err.Error())
+	}
+	probeType := func(string) ([]string, error) {
+		return []string{"ceph_bluestore"}, nil
+	}
+
+	tests := []struct {
+		name      string
+		specs     []string
+		wantFound bool
+		wantSpec  string
+	}{
+		{
+			name:      "test magic matched",
+			specs:     []string{"magic:1024:4f4c4d41524b"},
+			wantFound: true,
+			wantSpec:  "magic:1024:4f4c4d41524b",
+		},
+		{
+			name:      "test magic at other offset",
+			specs:     []string{"magic:0:4f4c4d41524b"},
+			wantFound: false,
+		},
+		{
+			name:      "test magic beyond device size",
+			specs:     []string{"magic:8192:4f4c"},
+			wantFound: false,
+		},
+		{
+			name:      "test blkid type matched",
+			specs:     []string{"type:xfs", "type:ceph_bluestore"},
+			wantFound: true,
+			wantSpec:  "type:ceph_bluestore",
+		},
+		{
+			name:      "test no signature matched",
+			specs:     []string{"type:xfs", "magic:0:4f4c"},
+			wantFound: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signatures, err := ParseSignatures(tt.specs)
+			if err != nil {
+				t.Fatalf("ParseSignatures() error = %v", err)
+			}
+			sig, found, err := FindSignature(dev, signatures, probeType)
+			if err != nil {
+				t.Fatalf("FindSignature() error = %v", err)
+			}
+			if found != tt.wantFound || sig.String() != tt.wantSpec {
+				t.Errorf("FindSignature() = %v, %v, want %v, %v", sig, found, tt.wantSpec, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+// kelvinOffset converts temperature in kelvin reported by nvme to celsius
+const kelvinOffset = 273
+
+// Temperature is the temperature of device in celsius
+type Temperature struct {
+	// Current is the composite temperature reported by device
+	Current int64
+	// Critical is the temperature above which device may be damaged, 0 means unknown
+	Critical int64
+}
+
+// ReadTemperatureFunc returns temperature of device, nil if device reports none
+type ReadTemperatureFunc func(dev string) (*Temperature, error)
+
+// nvmeSmartLog is the part of `nvme smart-log -o json` in use, in kelvin
+type nvmeSmartLog struct {
+	Temperature *int64 `json:"temperature"`
+}
+
+// nvmeIDCtrl is the part of `nvme id-ctrl -o json` in use, in kelvin
+type nvmeIDCtrl struct {
+	CriticalTemperature int64 `json:"cctemp"`
+}
+
+// smartctlInfo is the part of `smartctl -A -j` in use, in celsius
+type smartctlInfo struct {
+	Temperature *struct {
+		Current *int64 `json:"current"`
+		// DriveTrip is reported by scsi disks, OpLimitMax by ata disks
+		DriveTrip  int64 `json:"drive_trip"`
+		OpLimitMax int64 `json:"op_limit_max"`
+	} `json:"temperature"`
+}
+
+// ParseNvmeTemperature parses temperature from output of nvme smart-log and
+// id-ctrl in json, idCtrl may be empty if critical temperature is unknown
+func ParseNvmeTemperature(smartLog, idCtrl []byte) (*Temperature, error) {
+	var smart nvmeSmartLog
+	if err := json.Unmarshal(smartLog, &smart); err != nil {
+		return nil, fmt.Errorf("invalid nvme smart log: %s", err.Error())
+	}
+	// 0 kelvin is reported by controller without temperature sensor
+	if smart.Temperature == nil || *smart.Temperature == 0 {
+		return nil, nil
+	}
+	temp := &Temperature{Current: *smart.Temperature - kelvinOffset}
+	if len(idCtrl) > 0 {
+		var ctrl nvmeIDCtrl
+		if err := json.Unmarshal(idCtrl, &ctrl); err != nil {
+			return nil, fmt.Errorf("invalid nvme controller identify: %s", err.Error())
+		}
+		if ctrl.CriticalTemperature > 0 {
+			temp.Critical = ctrl.CriticalTemperature - kelvinOffset
+		}
+	}
+	return temp, nil
+}
+
+// ParseSmartctlTemperature parses temperature from output of smartctl -A -j
+func ParseSmartctlTemperature(out []byte) (*Temperature, error) {
+	var info smartctlInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("invalid smartctl output: %s", err.Error())
+	}
+	if info.Temperature == nil || info.Temperature.Current == nil {
+		return nil, nil
+	}
+	temp := &Temperature{Current: *info.Temperature.Current, Critical: info.Temperature.DriveTrip}
+	if temp.Critical == 0 {
+		temp.Critical = info.Temperature.OpLimitMax
+	}
+	return temp, nil
+}
+
+// ReadTemperature reads temperature of device by nvme-cli for nvme devices
+// and by smartctl for others
+func ReadTemperature(dev string) (*Temperature, error) {
+	if strings.HasPrefix(filepath.Base(dev), "nvme") {
+		smartLog, err := runJSON(fmt.Sprintf("%s nvme smart-log -o json %s", localtype.NsenterCmd, dev))
+		if err != nil {
+			return nil, err
+		}
+		// critical temperature is optional
+		idCtrl, _ := runJSON(fmt.Sprintf("%s nvme id-ctrl -o json %s", localtype.NsenterCmd, dev))
+		return ParseNvmeTemperature(smartLog, idCtrl)
+	}
+	out, err := runJSON(fmt.Sprintf("%s smartctl -A -j %s", localtype.NsenterCmd, dev))
+	if err != nil {
+		return nil, err
+	}
+	return ParseSmartctlTemperature(out)
+}
+
+// runJSON returns stdout of cmd. smartctl exits with non-zero bitmask even
+// if json is printed, so stdout is kept as long as it is not empty
+func runJSON(cmd string) ([]byte, error) {
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok && len(strings.TrimSpace(string(out))) > 0 {
+			return out, nil
+		}
+		return nil, fmt.Errorf("failed to run %s: %s", cmd, err.Error())
+	}
+	return out, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	nvmeSmartLogOutput = `{
+  "critical_warning" : 0,
+  "temperature" : 312,
+  "avail_spare" : 100,
+  "spare_thresh" : 10,
+  "percent_used" : 1,
+  "data_units_read" : 4521363,
+  "data_units_written" : 9683101,
+  "power_on_hours" : 2277,
+  "unsafe_shutdowns" : 18
+}`
+	nvmeIDCtrlOutput = `{
+  "vid" : 5197,
+  "sn" : "S4EWNX0R123456",
+  "mn" : "Samsung SSD 970 EVO Plus 1TB",
+  "wctemp" : 358,
+  "cctemp" : 358
+}`
+	// controller without temperature sensor
+	nvmeSmartLogNoSensor = `{
+  "critical_warning" : 0,
+  "temperature" : 0,
+  "avail_spare" : 100
+}`
+	smartctlATAOutput = `{
+  "json_format_version": [1, 0],
+  "smartctl": {"version": [7, 2], "exit_status": 0},
+  "device": {"name": "/dev/sda", "type": "sat", "protocol": "ATA"},
+  "ata_smart_attributes": {
+    "revision": 1,
+    "table": [
+      {"id": 194, "name": "Temperature_Celsius", "value": 65, "raw": {"value": 35, "string": "35"}}
+    ]
+  },
+  "temperature": {"current": 35, "op_limit_max": 70}
+}`
+	smartctlSCSIOutput = `{
+  "json_format_version": [1, 0],
+  "smartctl": {"version": [7, 2], "exit_status": 0},
+  "device": {"name": "/dev/sdb", "type": "scsi", "protocol": "SCSI"},
+  "temperature": {"current": 41, "drive_trip": 65}
+}`
+	// virtual disk without smart support
+	smartctlNoTemperature = `{
+  "json_format_version": [1, 0],
+  "smartctl": {
+    "version": [7, 2],
+    "messages": [{"string": "/dev/vdb: Unable to detect device type", "severity": "error"}],
+    "exit_status": 1
+  }
+}`
+)
+
+func Test_ParseNvmeTemperature(t *testing.T) {
+	tests := []struct {
+		name     string
+		smartLog string
+		idCtrl   string
+		want     *Temperature
+		wantErr  bool
+	}{
+		{
+			name:     "test current and critical",
+			smartLog: nvmeSmartLogOutput,
+			idCtrl:   nvmeIDCtrlOutput,
+			want:     &Temperature{Current: 39, Critical: 85},
+		},
+		{
+			name:     "test critical unknown",
+			smartLog: nvmeSmartLogOutput,
+			want:     &Temperature{Current: 39},
+		},
+		{
+			name:     "test missing temperature",
+			smartLog: nvmeSmartLogNoSensor,
+			idCtrl:   nvmeIDCtrlOutput,
+			want:     nil,
+		},
+		{
+			name:     "test invalid output",
+			smartLog: "NVMe status: INVALID_FIELD",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNvmeTemperature([]byte(tt.smartLog), []byte(tt.idCtrl))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseNvmeTemperature() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNvmeTemperature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseSmartctlTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    *Temperature
+		wantErr bool
+	}{
+		{
+			name: "test ata disk",
+			out:  smartctlATAOutput,
+			want: &Temperature{Current: 35, Critical: 70},
+		},
+		{
+			name: "test scsi disk",
+			out:  smartctlSCSIOutput,
+			want: &Temperature{Current: 41, Critical: 65},
+		},
+		{
+			name: "test missing temperature",
+			out:  smartctlNoTemperature,
+			want: nil,
+		},
+		{
+			name:    "test invalid output",
+			out:     "smartctl: command not found",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSmartctlTemperature([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSmartctlTemperature() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSmartctlTemperature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,7 +20,12 @@ package device
 type Device struct {
 	Name        string
 	IsPartition bool
-	ReadOnly    bool
-	MediaType   string
-	Total       uint64
+	// Parent is the whole disk of partition
+	Parent    string
+	ReadOnly  bool
+	MediaType string
+	Total     uint64
+	// Serial and WWN identify whole disk, empty if disk exposes none
+	Serial string
+	WWN    string
 }
@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// WipeVerifySamples is the number of blocks read back to verify wiping
+	WipeVerifySamples = 64
+	// wipeVerifyBlockSize is the size of each block read back
+	wipeVerifyBlockSize = 64 * 1024
+)
+
+var (
+	// replaced in unit test
+	runWipeCommand utils.CommandRunFunc = utils.Run
+)
+
+// ZeroDevice writes zeros across device by blkdiscard --zeroout, which is
+// offloaded to device if supported
+func ZeroDevice(dev string) error {
+	if _, err := os.Stat(dev); err != nil {
+		return err
+	}
+	if _, err := runWipeCommand(fmt.Sprintf("%s blkdiscard --zeroout %s", localtype.NsenterCmd, dev)); err != nil {
+		return err
+	}
+	log.Infof("[ZeroDevice]device %s is zeroed", dev)
+	return nil
+}
+
+// VerifyZeroed reads back samples blocks spread evenly across dev, the first
+// and the last block included, and returns error if any byte is not zero
+func VerifyZeroed(dev string, samples int) error {
+	f, err := os.Open(dev)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("fail to get size of %s: %s", dev, err.Error())
+	}
+	zeros := make([]byte, wipeVerifyBlockSize)
+	buf := make([]byte, wipeVerifyBlockSize)
+	for _, offset := range sampleOffsets(size, wipeVerifyBlockSize, samples) {
+		length := int64(wipeVerifyBlockSize)
+		if offset+length > size {
+			length = size - offset
+		}
+		n, err := f.ReadAt(buf[:length], offset)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("fail to read %s at offset %d: %s", dev, offset, err.Error())
+		}
+		if !bytes.Equal(buf[:n], zeros[:n]) {
+			return fmt.Errorf("%s is not zeroed at offset %d", dev, offset)
+		}
+	}
+	return nil
+}
+
+// sampleOffsets returns offsets of at most samples blocks spread evenly
+// across size, aligned to block
+func sampleOffsets(size, block int64, samples int) []int64 {
+	if size <= 0 || samples <= 0 {
+		return nil
+	}
+	last := (size - 1) / block
+	if samples == 1 || last == 0 {
+		return []int64{0}
+	}
+	if int64(samples) > last+1 {
+		samples = int(last + 1)
+	}
+	offsets := make([]int64, 0, samples)
+	for i := 0; i < samples; i++ {
+		offsets = append(offsets, last*int64(i)/int64(samples-1)*block)
+	}
+	return offsets
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_sampleOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		samples int
+		want    []int64
+	}{
+		{name: "test empty device", size: 0, samples: 4},
+		{name: "test single block", size: 10, samples: 4, want: []int64{0}},
+		{name: "test fewer blocks than samples", size: 3 * 10, samples: 8, want: []int64{0, 10, 20}},
+		{name: "test first and last block", size: 101 * 10, samples: 3, want: []int64{0, 500, 1000}},
+		{name: "test partial last block", size: 95, samples: 2, want: []int64{0, 90}},
+	}
+	for _, tt := range tests {
+		if got := sampleOffsets(tt.size, 10, tt.samples); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: sampleOffsets() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_VerifyZeroed(t *testing.T) {
+	const size = 16*wipeVerifyBlockSize + 100
+	tests := []struct {
+		name    string
+		dirty   int64
+		wantErr bool
+	}{
+		{name: "test zeroed", dirty: -1},
+		{name: "test dirty first block", dirty: 1, wantErr: true},
+		{name: "test dirty partial last block", dirty: size - 1, wantErr: true},
+		{name: "test dirty block not sampled", dirty: 3*wipeVerifyBlockSize - 1},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "dev")
+		data := make([]byte, size)
+		if tt.dirty >= 0 {
+			data[tt.dirty] = 0xff
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// samples at block 0, 4, 8, 12 and 16
+		if err := VerifyZeroed(path, 5); (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyZeroed() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := VerifyZeroed(filepath.Join(t.TempDir(), "absent"), 5); err == nil {
+		t.Errorf("VerifyZeroed() of absent device returns no error")
+	}
+}
+
+func Test_ZeroDevice(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "dev")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cmds []string
+	origin := runWipeCommand
+	defer func() { runWipeCommand = origin }()
+	runWipeCommand = func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return "", nil
+	}
+	if err := ZeroDevice(dev); err != nil {
+		t.Fatalf("ZeroDevice() error = %v", err)
+	}
+	if len(cmds) != 1 || !strings.HasSuffix(cmds[0], "blkdiscard --zeroout "+dev) {
+		t.Errorf("ZeroDevice() runs %v", cmds)
+	}
+	if err := ZeroDevice(filepath.Join(t.TempDir(), "absent")); err == nil {
+		t.Errorf("ZeroDevice() of absent device returns no error")
+	}
+}
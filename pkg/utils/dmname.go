@@ -0,0 +1,62 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// device-mapper limits the length of dm name to 127 characters(DM_NAME_LEN - 1)
+	MaxDMNameLength = 127
+	// length of hash suffix of shortened name
+	nameHashLength = 10
+	// shortened name keeps at least one character of the original name
+	minShortenedNameLength = nameHashLength + 2
+)
+
+// ShortenName returns name itself if it is no longer than max, otherwise a
+// prefix of name suffixed with hash of the whole name. It is deterministic,
+// and names sharing a long prefix are still distinct after shortening.
+// max less than minShortenedNameLength is treated as minShortenedNameLength
+func ShortenName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	if max < minShortenedNameLength {
+		max = minShortenedNameLength
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:max-nameHashLength-1] + "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// DMName returns name of device-mapper device of lv, '-' in vg and lv name
+// is doubled by lvm so that dm name is unique among all vgs
+func DMName(vg, lv string) string {
+	return strings.ReplaceAll(vg, "-", "--") + "-" + strings.ReplaceAll(lv, "-", "--")
+}
+
+// ValidateDMName checks whether dm name of lv fits in the limit of device-mapper
+func ValidateDMName(vg, lv string) error {
+	if name := DMName(vg, lv); len(name) > MaxDMNameLength {
+		return fmt.Errorf("dm name %s of lv %s/%s is longer than %d characters", name, vg, lv, MaxDMNameLength)
+	}
+	return nil
+}
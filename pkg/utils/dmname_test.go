@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ShortenName(t *testing.T) {
+	prefix := strings.Repeat("a", 130)
+	tests := []struct {
+		name       string
+		value      string
+		max        int
+		want       string
+		wantLength int
+	}{
+		{
+			name:       "test short name",
+			value:      "luks-local-0f7d5c5e",
+			max:        MaxDMNameLength,
+			want:       "luks-local-0f7d5c5e",
+			wantLength: 19,
+		},
+		{
+			name:       "test long name",
+			value:      prefix + "-0",
+			max:        MaxDMNameLength,
+			wantLength: MaxDMNameLength,
+		},
+		{
+			name:       "test max less than hash",
+			value:      prefix,
+			max:        1,
+			wantLength: minShortenedNameLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ShortenName(tt.value, tt.max)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("ShortenName() = %v, want %v", got, tt.want)
+			}
+			if len(got) != tt.wantLength {
+				t.Errorf("length of ShortenName() = %d, want %d", len(got), tt.wantLength)
+			}
+		})
+	}
+	// names collide under naive truncation
+	a, b := ShortenName(prefix+"-0", MaxDMNameLength), ShortenName(prefix+"-1", MaxDMNameLength)
+	if a == b || a[:MaxDMNameLength-nameHashLength] != b[:MaxDMNameLength-nameHashLength] {
+		t.Errorf("ShortenName() of names sharing prefix are %s and %s", a, b)
+	}
+}
+
+func Test_ValidateDMName(t *testing.T) {
+	tests := []struct {
+		name    string
+		vg      string
+		lv      string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "test hyphen doubled",
+			vg:   "open-local-pool-0",
+			lv:   "local-0f7d5c5e",
+			want: "open--local--pool--0-local--0f7d5c5e",
+		},
+		{
+			name:    "test too long after doubled",
+			vg:      "open-local-pool-0",
+			lv:      strings.Repeat("a-", 50),
+			want:    "open--local--pool--0-" + strings.Repeat("a--", 50),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DMName(tt.vg, tt.lv); got != tt.want {
+				t.Errorf("DMName() = %v, want %v", got, tt.want)
+			}
+			if err := ValidateDMName(tt.vg, tt.lv); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDMName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
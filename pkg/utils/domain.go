@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+)
+
+// ParseDomainSelector parses csi.aliyun.com/domain-selector in form of label
+// selector of node, e.g. topology.open-local.io/power-domain in (pd-a,pd-b)
+func ParseDomainSelector(value string) (labels.Selector, error) {
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %s", localtype.ParamDomainSelector, value, err.Error())
+	}
+	return selector, nil
+}
+
+// GetDomainSelectorFromPVC returns selector of node labels pvc requires, the
+// annotation of pvc takes precedence over storage class. It is
+// labels.Everything() if neither is set
+func GetDomainSelectorFromPVC(pvc *corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) (labels.Selector, error) {
+	if value, exist := LookupParam(pvc.Annotations, localtype.ParamDomainSelector); exist {
+		return ParseDomainSelector(value)
+	}
+	sc, err := GetStorageClassFromPVC(pvc, scLister)
+	if err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		return labels.Everything(), nil
+	}
+	if value, exist := LookupParam(sc.Parameters, localtype.ParamDomainSelector); exist {
+		return ParseDomainSelector(value)
+	}
+	return labels.Everything(), nil
+}
+
+// NodeDomain returns labels of node selected by keys of selector, e.g.
+// power-domain=pd-b, which reports the domain node is in
+func NodeDomain(node *corev1.Node, selector labels.Selector) string {
+	requirements, _ := selector.Requirements()
+	keys := map[string]bool{}
+	for _, requirement := range requirements {
+		keys[requirement.Key()] = true
+	}
+	domain := make([]string, 0, len(keys))
+	for key := range keys {
+		value, exist := node.Labels[key]
+		if !exist {
+			value = "<none>"
+		}
+		domain = append(domain, key+"="+value)
+	}
+	sort.Strings(domain)
+	return strings.Join(domain, ",")
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeDomain(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{
+		"power-domain": "pd-b",
+		"rack":         "r1",
+		"zone":         "z1",
+	}}}
+	tests := []struct {
+		name     string
+		selector string
+		want     string
+	}{
+		{name: "test single key", selector: "power-domain=pd-a", want: "power-domain=pd-b"},
+		{name: "test keys sorted", selector: "rack in (r2),power-domain!=pd-b", want: "power-domain=pd-b,rack=r1"},
+		{name: "test missing label", selector: "row=w1", want: "row=<none>"},
+		{name: "test empty selector", selector: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseDomainSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseDomainSelector() error = %v", err)
+			}
+			if got := NodeDomain(node, selector); got != tt.want {
+				t.Errorf("NodeDomain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+//go:build faultinjection
+// +build faultinjection
+
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fault
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault is the failure of command, it fails with Err after Delay. Command
+// with nil Err really runs after Delay, which simulates slow command
+type Fault struct {
+	Err   error
+	Delay time.Duration
+	// Times limits how many times the fault is hit, 0 means always
+	Times int
+}
+
+var (
+	lock   sync.Mutex
+	faults = map[string]*Fault{}
+)
+
+// Inject makes command, such as lvcreate or vgs, fail with fault until the
+// returned function is called
+func Inject(command string, fault Fault) func() {
+	lock.Lock()
+	defer lock.Unlock()
+	faults[command] = &fault
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		delete(faults, command)
+	}
+}
+
+// Reset removes all injected faults
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	faults = map[string]*Fault{}
+}
+
+// Check returns error of fault injected to any word of cmd after its delay,
+// or error of ctx if ctx is done during the delay
+func Check(ctx context.Context, cmd string) error {
+	fault := match(cmd)
+	if fault == nil {
+		return nil
+	}
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fault.Err
+}
+
+func match(cmd string) *Fault {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, word := range strings.Fields(cmd) {
+		fault, ok := faults[word]
+		if !ok {
+			continue
+		}
+		hit := *fault
+		if fault.Times > 0 {
+			if fault.Times--; fault.Times == 0 {
+				delete(faults, word)
+			}
+		}
+		return &hit
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+//go:build faultinjection
+// +build faultinjection
+
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	errNoSpace := errors.New("insufficient free space")
+	tests := []struct {
+		name    string
+		command string
+		fault   Fault
+		cmd     string
+		timeout time.Duration
+		wantErr []error
+	}{
+		{
+			name:    "test fail matched command",
+			command: "lvcreate",
+			fault:   Fault{Err: errNoSpace},
+			cmd:     "nsenter --mount=/proc/1/ns/mnt lvcreate -n lv -L 1024b vg",
+			wantErr: []error{errNoSpace, errNoSpace},
+		},
+		{
+			name:    "test ignore other command",
+			command: "lvcreate",
+			fault:   Fault{Err: errNoSpace},
+			cmd:     "nsenter --mount=/proc/1/ns/mnt lvremove -f vg/lv",
+			wantErr: []error{nil},
+		},
+		{
+			name:    "test fail limited times",
+			command: "vgs",
+			fault:   Fault{Err: errNoSpace, Times: 1},
+			cmd:     "vgs",
+			wantErr: []error{errNoSpace, nil},
+		},
+		{
+			name:    "test slow command succeeds",
+			command: "lvextend",
+			fault:   Fault{Delay: 10 * time.Millisecond},
+			cmd:     "lvextend -L1024B vg/lv",
+			timeout: time.Second,
+			wantErr: []error{nil},
+		},
+		{
+			name:    "test slow command times out",
+			command: "lvextend",
+			fault:   Fault{Delay: time.Minute},
+			cmd:     "lvextend -L1024B vg/lv",
+			timeout: 10 * time.Millisecond,
+			wantErr: []error{context.DeadlineExceeded},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer Inject(tt.command, tt.fault)()
+			for i, want := range tt.wantErr {
+				ctx := context.Background()
+				if tt.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+					defer cancel()
+				}
+				if err := Check(ctx, tt.cmd); !errors.Is(err, want) {
+					t.Errorf("Check() #%d error = %v, want %v", i, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	Inject("lvcreate", Fault{Err: errors.New("busy")})
+	Inject("vgs", Fault{Err: errors.New("busy")})
+	Reset()
+	for _, cmd := range []string{"lvcreate -n lv vg", "vgs"} {
+		if err := Check(context.Background(), cmd); err != nil {
+			t.Errorf("Check(%q) error = %v after Reset", cmd, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	v1 "k8s.io/api/core/v1"
+	log "k8s.io/klog/v2"
+)
+
+// CgroupVersion is the cgroup hierarchy mounted on node
+type CgroupVersion string
+
+const (
+	CgroupNone CgroupVersion = ""
+	CgroupV1   CgroupVersion = "v1"
+	CgroupV2   CgroupVersion = "v2"
+
+	// CgroupV2IOMaxFile is the io throttling file of cgroup v2 io controller
+	CgroupV2IOMaxFile = "io.max"
+)
+
+// ioThrottleFiles are the files of io throttling, keyed by cgroup version
+var ioThrottleFiles = map[CgroupVersion][]string{
+	CgroupV1: {localtype.IOPSReadFile, localtype.IOPSWriteFile, localtype.BPSReadFile, localtype.BPSWriteFile},
+	CgroupV2: {CgroupV2IOMaxFile},
+}
+
+// DetectCgroupVersion returns CgroupV2 on unified hierarchy, CgroupV1 if
+// blkio controller is mounted, and CgroupNone otherwise
+func DetectCgroupVersion(sysPath string) CgroupVersion {
+	if _, err := os.Stat(filepath.Join(sysPath, "fs/cgroup/cgroup.controllers")); err == nil {
+		return CgroupV2
+	}
+	if _, err := os.Stat(filepath.Join(sysPath, "fs/cgroup/blkio")); err == nil {
+		return CgroupV1
+	}
+	return CgroupNone
+}
+
+// PodCgroupDir returns the cgroup directory of pod where io throttling is set
+func PodCgroupDir(sysPath string, version CgroupVersion, qosClass v1.PodQOSClass, podUID string) string {
+	root := filepath.Join(sysPath, "fs/cgroup")
+	if version == CgroupV1 {
+		root = filepath.Join(root, "blkio")
+	}
+	return filepath.Join(root, CgroupPathFormatter.ParentDir+CgroupPathFormatter.QOSDirFn(qosClass)+CgroupPathFormatter.PodDirFn(qosClass, podUID))
+}
+
+// IOThrottleSettings returns the content to write in every io throttling file
+// for device maj:min, iops and bps are left unlimited if empty
+func IOThrottleSettings(version CgroupVersion, maj, min uint64, iops, bps string) map[string]string {
+	device := fmt.Sprintf("%d:%d", maj, min)
+	settings := map[string]string{}
+	switch version {
+	case CgroupV1:
+		if iops != "" {
+			settings[localtype.IOPSReadFile] = fmt.Sprintf("%s %s", device, iops)
+			settings[localtype.IOPSWriteFile] = fmt.Sprintf("%s %s", device, iops)
+		}
+		if bps != "" {
+			settings[localtype.BPSReadFile] = fmt.Sprintf("%s %s", device, bps)
+			settings[localtype.BPSWriteFile] = fmt.Sprintf("%s %s", device, bps)
+		}
+	case CgroupV2:
+		limits := []string{device}
+		if iops != "" {
+			limits = append(limits, "riops="+iops, "wiops="+iops)
+		}
+		if bps != "" {
+			limits = append(limits, "rbps="+bps, "wbps="+bps)
+		}
+		if len(limits) > 1 {
+			settings[CgroupV2IOMaxFile] = strings.Join(limits, " ")
+		}
+	}
+	return settings
+}
+
+// IOThrottleClearSettings returns the content which removes io throttling of
+// device maj:min
+func IOThrottleClearSettings(version CgroupVersion, maj, min uint64) map[string]string {
+	device := fmt.Sprintf("%d:%d", maj, min)
+	settings := map[string]string{}
+	switch version {
+	case CgroupV1:
+		for _, file := range ioThrottleFiles[CgroupV1] {
+			settings[file] = fmt.Sprintf("%s 0", device)
+		}
+	case CgroupV2:
+		settings[CgroupV2IOMaxFile] = fmt.Sprintf("%s riops=max wiops=max rbps=max wbps=max", device)
+	}
+	return settings
+}
+
+// WriteIOThrottleSettings writes settings into cgroup dir. It returns false
+// without writing anything if dir lacks throttling files, which means the
+// controller is not available
+func WriteIOThrottleSettings(dir string, settings map[string]string) (bool, error) {
+	for file := range settings {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return false, nil
+		}
+	}
+	for file, content := range settings {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return true, fmt.Errorf("failed to write %s: %s", path, err.Error())
+		}
+	}
+	return true, nil
+}
+
+// IsIOThrottled checks whether any throttling file in cgroup dir has rule of
+// device maj:min
+func IsIOThrottled(dir string, version CgroupVersion, maj, min uint64) bool {
+	device := fmt.Sprintf("%d:%d ", maj, min)
+	for _, file := range ioThrottleFiles[version] {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			// cgroup v2 lists unlimited devices as well, e.g. 253:0 rbps=max wbps=max riops=max wiops=max
+			if strings.HasPrefix(line, device) && !isUnlimitedIOMax(strings.TrimPrefix(line, device)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isUnlimitedIOMax(limits string) bool {
+	for _, limit := range strings.Fields(limits) {
+		if !strings.HasSuffix(limit, "=max") {
+			return false
+		}
+	}
+	return true
+}
+
+// PodUIDFromTargetPath parses pod uid from target path of block or mount volume
+func PodUIDFromTargetPath(targetPath string) string {
+	parts := strings.Split(targetPath, "/")
+	if strings.Contains(targetPath, "/volumeDevices/publish/") {
+		// /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/yoda-c018ff81-d346-452e-b7b8-a45f1d1c230e/76cf946e-d074-4455-a272-4d3a81264fab
+		if len(parts) > 10 {
+			return parts[10]
+		}
+		return ""
+	}
+	// /var/lib/kubelet/pods/2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f/volumes/kubernetes.io~csi/yoda-70597cb6-c08b-4bbb-8d41-c4afcfa91866/mount
+	if len(parts) > 5 {
+		return parts[5]
+	}
+	return ""
+}
+
+// ClearPodIOThrottling removes io throttling of device maj:min from cgroup of
+// pod, it is best effort since cgroup of pod may have been removed
+func ClearPodIOThrottling(sysPath string, maj, min uint64, podUID string) {
+	version := DetectCgroupVersion(sysPath)
+	if version == CgroupNone || podUID == "" {
+		return
+	}
+	for _, qosClass := range []v1.PodQOSClass{v1.PodQOSGuaranteed, v1.PodQOSBurstable, v1.PodQOSBestEffort} {
+		dir := PodCgroupDir(sysPath, version, qosClass, podUID)
+		if !IsIOThrottled(dir, version, maj, min) {
+			continue
+		}
+		if _, err := WriteIOThrottleSettings(dir, IOThrottleClearSettings(version, maj, min)); err != nil {
+			log.Warningf("failed to clear io throttling of device %d:%d in %s: %s", maj, min, dir, err.Error())
+			continue
+		}
+		log.Infof("io throttling of device %d:%d in %s is cleared", maj, min, dir)
+	}
+}
+
+// ModifyPodIOThrottling replaces io throttling of device maj:min in cgroup of
+// pod with iops and bps, limit left empty is removed. It returns false if io
+// controller is not available for the pod
+func ModifyPodIOThrottling(sysPath string, maj, min uint64, qosClass v1.PodQOSClass, podUID, iops, bps string) (bool, error) {
+	version := DetectCgroupVersion(sysPath)
+	if version == CgroupNone {
+		return false, nil
+	}
+	dir := PodCgroupDir(sysPath, version, qosClass, podUID)
+	// io.max of cgroup v2 keeps limits not written, so they are cleared first
+	if written, err := WriteIOThrottleSettings(dir, IOThrottleClearSettings(version, maj, min)); err != nil || !written {
+		return written, err
+	}
+	return WriteIOThrottleSettings(dir, IOThrottleSettings(version, maj, min, iops, bps))
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ioThrottleSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		version CgroupVersion
+		iops    string
+		bps     string
+		want    map[string]string
+	}{
+		{
+			name:    "test cgroup v1 iops and bps",
+			version: CgroupV1,
+			iops:    "1024",
+			bps:     "1048576",
+			want: map[string]string{
+				localtype.IOPSReadFile:  "253:3 1024",
+				localtype.IOPSWriteFile: "253:3 1024",
+				localtype.BPSReadFile:   "253:3 1048576",
+				localtype.BPSWriteFile:  "253:3 1048576",
+			},
+		},
+		{
+			name:    "test cgroup v2 iops and bps",
+			version: CgroupV2,
+			iops:    "1024",
+			bps:     "1048576",
+			want:    map[string]string{CgroupV2IOMaxFile: "253:3 riops=1024 wiops=1024 rbps=1048576 wbps=1048576"},
+		},
+		{
+			name:    "test cgroup v2 bps only",
+			version: CgroupV2,
+			bps:     "1048576",
+			want:    map[string]string{CgroupV2IOMaxFile: "253:3 rbps=1048576 wbps=1048576"},
+		},
+		{
+			name:    "test no io controller",
+			version: CgroupNone,
+			iops:    "1024",
+			want:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IOThrottleSettings(tt.version, 253, 3, tt.iops, tt.bps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IOThrottleSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_IOThrottling_SetAndClear(t *testing.T) {
+	podUID := "2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f"
+	targetPath := "/var/lib/kubelet/pods/" + podUID + "/volumes/kubernetes.io~csi/yoda-70597cb6/mount"
+	tests := []struct {
+		name      string
+		version   CgroupVersion
+		marker    string
+		files     []string
+		wantSet   map[string]string
+		wantClear map[string]string
+	}{
+		{
+			name:    "test cgroup v1",
+			version: CgroupV1,
+			marker:  "fs/cgroup/blkio",
+			files:   ioThrottleFiles[CgroupV1],
+			wantSet: map[string]string{
+				localtype.IOPSReadFile:  "253:3 1024",
+				localtype.IOPSWriteFile: "253:3 1024",
+				localtype.BPSReadFile:   "253:3 1048576",
+				localtype.BPSWriteFile:  "253:3 1048576",
+			},
+			wantClear: map[string]string{
+				localtype.IOPSReadFile:  "253:3 0",
+				localtype.IOPSWriteFile: "253:3 0",
+				localtype.BPSReadFile:   "253:3 0",
+				localtype.BPSWriteFile:  "253:3 0",
+			},
+		},
+		{
+			name:      "test cgroup v2",
+			version:   CgroupV2,
+			marker:    "fs/cgroup/cgroup.controllers",
+			files:     ioThrottleFiles[CgroupV2],
+			wantSet:   map[string]string{CgroupV2IOMaxFile: "253:3 riops=1024 wiops=1024 rbps=1048576 wbps=1048576"},
+			wantClear: map[string]string{CgroupV2IOMaxFile: "253:3 riops=max wiops=max rbps=max wbps=max"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysPath := t.TempDir()
+			// blkio of cgroup v1 or cgroup.controllers of cgroup v2
+			if err := os.MkdirAll(filepath.Join(sysPath, "fs/cgroup"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(sysPath, tt.marker), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if got := DetectCgroupVersion(sysPath); got != tt.version {
+				t.Fatalf("DetectCgroupVersion() = %v, want %v", got, tt.version)
+			}
+			dir := PodCgroupDir(sysPath, tt.version, corev1.PodQOSBurstable, PodUIDFromTargetPath(targetPath))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			written, err := WriteIOThrottleSettings(dir, IOThrottleSettings(tt.version, 253, 3, "1024", "1048576"))
+			if err != nil || !written {
+				t.Fatalf("WriteIOThrottleSettings() = %v, %v, want true, nil", written, err)
+			}
+			if got := readFiles(t, dir, tt.files); !reflect.DeepEqual(got, tt.wantSet) {
+				t.Errorf("io throttling set = %v, want %v", got, tt.wantSet)
+			}
+
+			ClearPodIOThrottling(sysPath, 253, 3, PodUIDFromTargetPath(targetPath))
+			if got := readFiles(t, dir, tt.files); !reflect.DeepEqual(got, tt.wantClear) {
+				t.Errorf("io throttling cleared = %v, want %v", got, tt.wantClear)
+			}
+		})
+	}
+}
+
+func Test_writeIOThrottleSettings_ControllerUnavailable(t *testing.T) {
+	// io controller is not enabled for the cgroup
+	dir := t.TempDir()
+	written, err := WriteIOThrottleSettings(dir, IOThrottleSettings(CgroupV2, 253, 3, "1024", ""))
+	if err != nil || written {
+		t.Errorf("WriteIOThrottleSettings() = %v, %v, want false, nil", written, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CgroupV2IOMaxFile)); !os.IsNotExist(err) {
+		t.Errorf("WriteIOThrottleSettings() created %s", CgroupV2IOMaxFile)
+	}
+}
+
+func Test_ModifyPodIOThrottling_CgroupV1(t *testing.T) {
+	podUID := "2a7bbb9c-c915-4006-84d7-0e3ac9d8d70f"
+	sysPath := t.TempDir()
+	dir := PodCgroupDir(sysPath, CgroupV1, corev1.PodQOSBurstable, podUID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range ioThrottleFiles[CgroupV1] {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := WriteIOThrottleSettings(dir, IOThrottleSettings(CgroupV1, 253, 3, "1024", "1048576")); err != nil {
+		t.Fatal(err)
+	}
+
+	// bps is removed since only iops is given
+	written, err := ModifyPodIOThrottling(sysPath, 253, 3, corev1.PodQOSBurstable, podUID, "2048", "")
+	if err != nil || !written {
+		t.Fatalf("ModifyPodIOThrottling() = %v, %v, want true, nil", written, err)
+	}
+	want := map[string]string{
+		localtype.IOPSReadFile:  "253:3 2048",
+		localtype.IOPSWriteFile: "253:3 2048",
+		localtype.BPSReadFile:   "253:3 0",
+		localtype.BPSWriteFile:  "253:3 0",
+	}
+	if got := readFiles(t, dir, ioThrottleFiles[CgroupV1]); !reflect.DeepEqual(got, want) {
+		t.Errorf("io throttling modified = %v, want %v", got, want)
+	}
+}
+
+func readFiles(t *testing.T, dir string, files []string) map[string]string {
+	contents := map[string]string{}
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[file] = string(content)
+	}
+	return contents
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+
+	logsjson "k8s.io/component-base/logs/json"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// LogFormatText is the default klog text format
+	LogFormatText = "text"
+	// LogFormatJSON writes every log line as a json object
+	LogFormatJSON = "json"
+
+	// keys of structured log, shared by agent and csi plugin
+	LogKeyOperation = "operation"
+	LogKeyLV        = "lv"
+	LogKeyVG        = "vg"
+	LogKeySnapshot  = "snapshot"
+	// LogKeyOperationID is shared by all log lines of a csi operation from
+	// csi plugin to lvmd, derived from volume handle
+	LogKeyOperationID = "operationID"
+)
+
+// SetLogFormat switches format of klog output. Verbosity(-v) still applies
+// to json format, the level is recorded in field "v"
+func SetLogFormat(format string) error {
+	switch format {
+	case "", LogFormatText:
+		log.SetLogger(nil)
+	case LogFormatJSON:
+		log.SetLogger(logsjson.NewJSONLogger(os.Stderr))
+	default:
+		return fmt.Errorf("unsupported log format %q, must be %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	log "k8s.io/klog/v2"
+)
+
+// CommandConfig customizes environment and flags of every lvm command, paths
+// are resolved in the host mount namespace
+type CommandConfig struct {
+	// SystemDir is the directory holding lvm.conf, exported as LVM_SYSTEM_DIR
+	SystemDir string
+	// LockingDir overrides global/locking_dir of lvm.conf
+	LockingDir string
+}
+
+var (
+	commandConfigLock sync.RWMutex
+	commandConfig     CommandConfig
+)
+
+// Validate returns error if paths in config are not absolute or can not be
+// passed to lvm safely
+func (c CommandConfig) Validate() error {
+	for name, path := range map[string]string{"lvm system dir": c.SystemDir, "lvm locking dir": c.LockingDir} {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("%s %q must be an absolute path", name, path)
+		}
+		if strings.ContainsAny(path, "'\"\\ \t\n{}") {
+			return fmt.Errorf("%s %q contains invalid characters", name, path)
+		}
+	}
+	return nil
+}
+
+// SetCommandConfig makes all following lvm commands honor cfg
+func SetCommandConfig(cfg CommandConfig) {
+	commandConfigLock.Lock()
+	defer commandConfigLock.Unlock()
+	if cfg != (CommandConfig{}) {
+		log.Infof("lvm commands run with system dir %q, locking dir %q", cfg.SystemDir, cfg.LockingDir)
+	}
+	commandConfig = cfg
+}
+
+// commandEnv returns environment variables set for lvm commands
+func commandEnv() []string {
+	commandConfigLock.RLock()
+	defer commandConfigLock.RUnlock()
+	var env []string
+	if commandConfig.SystemDir != "" {
+		env = append(env, "LVM_SYSTEM_DIR="+commandConfig.SystemDir)
+	}
+	return env
+}
+
+// commandArgs returns flags appended to lvm commands
+func commandArgs() []string {
+	commandConfigLock.RLock()
+	defer commandConfigLock.RUnlock()
+	var args []string
+	if commandConfig.LockingDir != "" {
+		args = append(args, fmt.Sprintf("--config 'global{locking_dir=\"%s\"}'", commandConfig.LockingDir))
+	}
+	return args
+}
+
+// Command returns shell command line of lvm cmd which runs in host namespaces
+// and honors CommandConfig. Environment is set by assignments before nsenter,
+// which keeps it for lvm running in host namespaces. Every lvm command is
+// built by it, so that agent and lvmd agree on lvm.conf and locking dir
+func Command(cmd string, args ...string) string {
+	line := commandEnv()
+	line = append(line, localtype.NsenterCmd, cmd)
+	line = append(line, commandArgs()...)
+	line = append(line, args...)
+	return strings.Join(line, " ")
+}
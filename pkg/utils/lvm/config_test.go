@@ -0,0 +1,116 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+func Test_CommandConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  CommandConfig
+		wantErr bool
+	}{
+		{
+			name:    "test empty config",
+			config:  CommandConfig{},
+			wantErr: false,
+		},
+		{
+			name:    "test absolute paths",
+			config:  CommandConfig{SystemDir: "/etc/open-local/lvm", LockingDir: "/run/open-local/lock"},
+			wantErr: false,
+		},
+		{
+			name:    "test relative system dir",
+			config:  CommandConfig{SystemDir: "etc/lvm"},
+			wantErr: true,
+		},
+		{
+			name:    "test locking dir with quote",
+			config:  CommandConfig{LockingDir: "/run/lock\"}"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newCommand_CommandConfig(t *testing.T) {
+	defer SetCommandConfig(CommandConfig{})
+
+	tests := []struct {
+		name     string
+		config   CommandConfig
+		wantEnv  string
+		wantArgs string
+	}{
+		{
+			name:     "test default config",
+			config:   CommandConfig{},
+			wantArgs: "vgs --reportformat=json --units=b --nosuffix --options=vg_name",
+		},
+		{
+			name:     "test custom system dir and locking dir",
+			config:   CommandConfig{SystemDir: "/etc/open-local/lvm", LockingDir: "/run/open-local/lock"},
+			wantEnv:  "LVM_SYSTEM_DIR=/etc/open-local/lvm",
+			wantArgs: "vgs --config 'global{locking_dir=\"/run/open-local/lock\"}' --reportformat=json --units=b --nosuffix --options=vg_name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetCommandConfig(tt.config)
+			c := newCommand("vgs", true, "--options=vg_name")
+			if len(c.Args) != 3 || !strings.HasSuffix(c.Args[2], tt.wantArgs) {
+				t.Errorf("newCommand() args = %v, want suffix %q", c.Args, tt.wantArgs)
+			}
+			var gotEnv string
+			if env := strings.Fields(c.Args[2])[0]; strings.HasPrefix(env, "LVM_SYSTEM_DIR=") {
+				gotEnv = env
+			}
+			if gotEnv != tt.wantEnv {
+				t.Errorf("newCommand() env = %q, want %q", gotEnv, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func Test_Command(t *testing.T) {
+	defer SetCommandConfig(CommandConfig{})
+
+	SetCommandConfig(CommandConfig{SystemDir: "/etc/open-local/lvm", LockingDir: "/run/open-local/lock"})
+	got := Command("lvextend", "-L1024B", "vg/lv")
+	want := "LVM_SYSTEM_DIR=/etc/open-local/lvm " + localtype.NsenterCmd + " lvextend --config 'global{locking_dir=\"/run/open-local/lock\"}' -L1024B vg/lv"
+	if got != want {
+		t.Errorf("Command() = %s, want %s", got, want)
+	}
+	// lvm.conf of system dir is read by lvm in host namespaces
+	out, err := exec.Command("sh", "-c", strings.Replace(got, localtype.NsenterCmd+" lvextend", "printenv LVM_SYSTEM_DIR; true", 1)).Output()
+	if err != nil || strings.TrimSpace(string(out)) != "/etc/open-local/lvm" {
+		t.Errorf("LVM_SYSTEM_DIR of command = %q, error = %v", out, err)
+	}
+}
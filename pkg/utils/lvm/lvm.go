@@ -18,14 +18,19 @@ package lvm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
-	localtype "github.com/alibaba/open-local/pkg"
+	"github.com/alibaba/open-local/pkg/utils/fault"
 	log "k8s.io/klog/v2"
 )
 
@@ -191,6 +196,164 @@ func (vg *VolumeGroup) ExtentFreeCount() (uint64, error) {
 	return 0, ErrVolumeGroupNotFound
 }
 
+// MetadataUsage returns the free and total size in bytes of the metadata
+// area of the volume group. No lv can be created once metadata area is full,
+// even if there is free data space.
+func (vg *VolumeGroup) MetadataUsage() (free, size uint64, err error) {
+	result := new(vgsOutput)
+	if err := run("vgs", result, "--options=vg_mda_free,vg_mda_size", vg.name); err != nil {
+		if IsVolumeGroupNotFound(err) {
+			return 0, 0, ErrVolumeGroupNotFound
+		}
+		log.Errorf("MetadataUsage error: %s", err.Error())
+		return 0, 0, err
+	}
+	return metadataUsage(result)
+}
+
+func metadataUsage(result *vgsOutput) (free, size uint64, err error) {
+	for _, report := range result.Report {
+		for _, vg := range report.Vg {
+			return vg.VgMdaFree, vg.VgMdaSize, nil
+		}
+	}
+	return 0, 0, ErrVolumeGroupNotFound
+}
+
+// Allocation is the extent layout and allocation policy of volume group
+type Allocation struct {
+	// ExtentSize is the size in bytes of a single extent
+	ExtentSize uint64
+	// ExtentCount is the number of extents
+	ExtentCount uint64
+	// FreeExtentCount is the number of free extents
+	FreeExtentCount uint64
+	// Policy is the allocation policy, e.g. normal, contiguous or cling
+	Policy string
+}
+
+// Allocation returns the extent layout and allocation policy of the volume
+// group, sizes of lv are rounded up to extent by lvm
+func (vg *VolumeGroup) Allocation() (Allocation, error) {
+	result := new(vgsOutput)
+	if err := run("vgs", result, "--options=vg_extent_size,vg_extent_count,vg_free_count,vg_allocation_policy", vg.name); err != nil {
+		if IsVolumeGroupNotFound(err) {
+			return Allocation{}, ErrVolumeGroupNotFound
+		}
+		log.Errorf("Allocation error: %s", err.Error())
+		return Allocation{}, err
+	}
+	return allocation(result)
+}
+
+func allocation(result *vgsOutput) (Allocation, error) {
+	for _, report := range result.Report {
+		for _, vg := range report.Vg {
+			return Allocation{
+				ExtentSize:      vg.VgExtentSize,
+				ExtentCount:     vg.VgExtentCount,
+				FreeExtentCount: vg.VgFreeExtentCount,
+				Policy:          vg.VgAllocPolicy,
+			}, nil
+		}
+	}
+	return Allocation{}, ErrVolumeGroupNotFound
+}
+
+type pvsegsOutput struct {
+	Report []struct {
+		Pvseg []pvseg `json:"pvseg"`
+		// some lvm versions report pv segments as pv
+		Pv []pvseg `json:"pv"`
+	} `json:"report"`
+}
+
+type pvseg struct {
+	PvName    string `json:"pv_name"`
+	VgName    string `json:"vg_name"`
+	PvsegSize uint64 `json:"pvseg_size,string"`
+	Segtype   string `json:"segtype"`
+	LvName    string `json:"lv_name"`
+	LvAttr    string `json:"lv_attr"`
+}
+
+// LargestFreeExtentRun returns the size in bytes of the largest contiguous
+// free space in volume group, which limits the size of a single-extent lv.
+func (vg *VolumeGroup) LargestFreeExtentRun() (uint64, error) {
+	extentSize, err := vg.ExtentSize()
+	if err != nil {
+		return 0, err
+	}
+	result := new(pvsegsOutput)
+	if err := run("pvs", result, "--segments", "--options=pv_name,vg_name,pvseg_size,segtype"); err != nil {
+		log.Errorf("LargestFreeExtentRun error: %s", err.Error())
+		return 0, err
+	}
+	return largestFreeExtentCount(result, vg.name) * extentSize, nil
+}
+
+// largestFreeExtentCount returns the extent count of the largest free pv
+// segment in vg, lvm already merges adjacent free extents into one segment
+func largestFreeExtentCount(result *pvsegsOutput, vgName string) uint64 {
+	var largest uint64
+	for _, report := range result.Report {
+		for _, seg := range append(report.Pvseg, report.Pv...) {
+			if seg.VgName != vgName || seg.Segtype != "free" {
+				continue
+			}
+			if seg.PvsegSize > largest {
+				largest = seg.PvsegSize
+			}
+		}
+	}
+	return largest
+}
+
+// PhysicalVolumeLV is the space in bytes an lv takes on a physical volume
+type PhysicalVolumeLV struct {
+	PVName string
+	LVName string
+	Attr   string
+	Size   uint64
+}
+
+// PhysicalVolumeLVs returns the space each lv of this volume group takes on
+// each of its physical volumes
+func (vg *VolumeGroup) PhysicalVolumeLVs() ([]PhysicalVolumeLV, error) {
+	extentSize, err := vg.ExtentSize()
+	if err != nil {
+		return nil, err
+	}
+	result := new(pvsegsOutput)
+	if err := run("pvs", result, "--segments", "--options=pv_name,vg_name,pvseg_size,segtype,lv_name,lv_attr"); err != nil {
+		log.Errorf("PhysicalVolumeLVs error: %s", err.Error())
+		return nil, err
+	}
+	return physicalVolumeLVs(result, vg.name, extentSize), nil
+}
+
+// physicalVolumeLVs sums up segments of the same lv on a pv, free segments
+// are skipped
+func physicalVolumeLVs(result *pvsegsOutput, vgName string, extentSize uint64) []PhysicalVolumeLV {
+	var lvs []PhysicalVolumeLV
+	indexOf := map[string]int{}
+	for _, report := range result.Report {
+		for _, seg := range append(report.Pvseg, report.Pv...) {
+			if seg.VgName != vgName || seg.Segtype == "free" || seg.LvName == "" {
+				continue
+			}
+			key := seg.PvName + "/" + seg.LvName
+			if i, ok := indexOf[key]; ok {
+				lvs[i].Size += seg.PvsegSize * extentSize
+				continue
+			}
+			indexOf[key] = len(lvs)
+			lvs = append(lvs, PhysicalVolumeLV{PVName: seg.PvName, LVName: seg.LvName, Attr: seg.LvAttr, Size: seg.PvsegSize * extentSize})
+		}
+	}
+	return lvs
+}
+
 // CreateLogicalVolume creates a logical volume of the given device
 // and size.
 //
@@ -215,6 +378,7 @@ func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags
 	args = append(args, fmt.Sprintf("--size=%db", sizeInBytes))
 	args = append(args, "--name="+name)
 	args = append(args, vg.name)
+	defer lockVG(vg.name)()
 	if err := run("lvcreate", nil, args...); err != nil {
 		if isInsufficientSpace(err) {
 			return nil, ErrNoSpace
@@ -222,7 +386,7 @@ func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags
 		log.Errorf("CreateLogicalVolume error: %s", err.Error())
 		return nil, err
 	}
-	return &LogicalVolume{name, sizeInBytes, vg, "", 0}, nil
+	return &LogicalVolume{name, sizeInBytes, vg, "", 0, false, tags}, nil
 }
 
 // ValidateLogicalVolumeName validates a volume group name. A valid volume
@@ -237,6 +401,10 @@ func ValidateLogicalVolumeName(name string) error {
 
 const ErrLogicalVolumeNotFound = simpleError("lvm: logical volume not found")
 
+// ErrLogicalVolumeSuspended is returned instead of running operations which
+// would hang on suspended device-mapper target
+const ErrLogicalVolumeSuspended = simpleError("lvm: logical volume is suspended")
+
 type lvsOutput struct {
 	Report []struct {
 		Lv []struct {
@@ -247,6 +415,10 @@ type lvsOutput struct {
 			LvTags      string  `json:"lv_tags"`
 			LvOrigin    string  `json:"origin"`
 			LvSnapUsage float64 `json:"snap_percent,string"`
+			LvAttr      string  `json:"lv_attr"`
+			KernelMajor string  `json:"lv_kernel_major"`
+			KernelMinor string  `json:"lv_kernel_minor"`
+			ReadAhead   string  `json:"lv_read_ahead"`
 		} `json:"lv"`
 	} `json:"report"`
 }
@@ -270,7 +442,7 @@ func IsLogicalVolumeNotFound(err error) bool {
 func (vg *VolumeGroup) LookupLogicalVolume(name string) (*LogicalVolume, error) {
 	var err error
 	result := new(lvsOutput)
-	if err = run("lvs", result, "--options=lv_name,lv_size,vg_name,origin", vg.Name()); err != nil {
+	if err = run("lvs", result, "--options=lv_name,lv_size,vg_name,origin,lv_attr,lv_tags", vg.Name()); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return nil, ErrLogicalVolumeNotFound
 		}
@@ -291,12 +463,104 @@ func (vg *VolumeGroup) LookupLogicalVolume(name string) (*LogicalVolume, error)
 				_ = run("lvs", tmpResult, "--options=lv_name,lv_size,vg_name,origin,snap_percent", lv.VgName+"/"+lv.Name)
 				usage = tmpResult.Report[0].Lv[0].LvSnapUsage
 			}
-			return &LogicalVolume{lv.Name, lv.LvSize, vg, lv.LvOrigin, usage / 100}, nil
+			return &LogicalVolume{lv.Name, lv.LvSize, vg, lv.LvOrigin, usage / 100, isSuspendedAttr(lv.LvAttr), splitTags(lv.LvTags)}, nil
 		}
 	}
 	return nil, ErrLogicalVolumeNotFound
 }
 
+// ListInactiveLogicalVolumeNames returns the names of the logical volumes in
+// this volume group which are not activated, such as lvs left inactive after
+// reboot if auto activation is disabled.
+func (vg *VolumeGroup) ListInactiveLogicalVolumeNames() ([]string, error) {
+	var names []string
+	result := new(lvsOutput)
+	if err := run("lvs", result, "--options=lv_name,vg_name,lv_attr", vg.name); err != nil {
+		log.Errorf("ListInactiveLogicalVolumeNames error: %s", err.Error())
+		return nil, err
+	}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			if lv.VgName == vg.name && isInactiveAttr(lv.LvAttr) {
+				names = append(names, lv.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// SnapshotRelation is the snapshot dependency of a logical volume
+type SnapshotRelation struct {
+	// Origin is empty if the logical volume is not a snapshot
+	Origin string
+	// Snapshots are snapshots whose origin is the logical volume
+	Snapshots []string
+	// Depth is the number of origins up to the logical volume which is not a snapshot
+	Depth int
+}
+
+// SnapshotRelations returns snapshot relation of every logical volume in the
+// volume group keyed by lv name, derived from origin reported by lvs
+func (vg *VolumeGroup) SnapshotRelations() (map[string]SnapshotRelation, error) {
+	result := new(lvsOutput)
+	if err := run("lvs", result, "--options=lv_name,vg_name,origin", vg.name); err != nil {
+		log.Errorf("SnapshotRelations error: %s", err.Error())
+		return nil, err
+	}
+	return snapshotRelations(result, vg.name), nil
+}
+
+func snapshotRelations(result *lvsOutput, vgName string) map[string]SnapshotRelation {
+	relations := map[string]SnapshotRelation{}
+	for _, report := range result.Report {
+		for _, lv := range report.Lv {
+			if lv.VgName != vgName {
+				continue
+			}
+			relation := relations[lv.Name]
+			relation.Origin = lv.LvOrigin
+			relations[lv.Name] = relation
+			if lv.LvOrigin != "" {
+				origin := relations[lv.LvOrigin]
+				origin.Snapshots = append(origin.Snapshots, lv.Name)
+				relations[lv.LvOrigin] = origin
+			}
+		}
+	}
+	for name, relation := range relations {
+		sort.Strings(relation.Snapshots)
+		// origin chain never loops in lvm, bounded in case of broken output
+		for origin := relation.Origin; origin != "" && relation.Depth < len(relations); origin = relations[origin].Origin {
+			relation.Depth++
+		}
+		relations[name] = relation
+	}
+	return relations
+}
+
+// splitTags splits lv_tags reported by lvs, which are separated by comma
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// isInactiveAttr checks the state bit of lv_attr, which is - for inactive
+// logical volume
+func isInactiveAttr(attr string) bool {
+	return len(attr) >= 5 && attr[4] == '-'
+}
+
+// ActivateLogicalVolume activates the logical volume in this volume group
+func (vg *VolumeGroup) ActivateLogicalVolume(name string) error {
+	if err := run("lvchange", nil, "-ay", vg.name+"/"+name); err != nil {
+		log.Errorf("ActivateLogicalVolume error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
 // ListLogicalVolumes returns the names of the logical volumes in this volume group.
 func (vg *VolumeGroup) ListLogicalVolumeNames() ([]string, error) {
 	var names []string
@@ -381,8 +645,39 @@ func (vg *VolumeGroup) ListPhysicalVolumeNames() ([]string, error) {
 	return names, nil
 }
 
+// PhysicalVolumeSpace is the size and free space in bytes of a physical volume
+type PhysicalVolumeSpace struct {
+	Name string
+	Size uint64
+	Free uint64
+}
+
+// PhysicalVolumeSpaces returns size and free space of each physical volume in
+// this volume group, free space of vg may be stranded on a single pv
+func (vg *VolumeGroup) PhysicalVolumeSpaces() ([]PhysicalVolumeSpace, error) {
+	result := new(pvsOutput)
+	if err := run("pvs", result, "--options=pv_name,vg_name,pv_size,pv_free"); err != nil {
+		log.Errorf("PhysicalVolumeSpaces error: %s", err.Error())
+		return nil, err
+	}
+	return physicalVolumeSpaces(result, vg.name), nil
+}
+
+func physicalVolumeSpaces(result *pvsOutput, vgName string) []PhysicalVolumeSpace {
+	var spaces []PhysicalVolumeSpace
+	for _, report := range result.Report {
+		for _, pv := range report.Pv {
+			if pv.VgName == vgName {
+				spaces = append(spaces, PhysicalVolumeSpace{Name: pv.Name, Size: pv.PvSize, Free: pv.PvFree})
+			}
+		}
+	}
+	return spaces
+}
+
 // Remove removes the volume group from disk.
 func (vg *VolumeGroup) Remove() error {
+	defer lockVG(vg.name)()
 	if err := run("vgremove", nil, "-f", vg.name); err != nil {
 		log.Errorf("volume group Remove error: %s", err.Error())
 		return err
@@ -396,12 +691,19 @@ type LogicalVolume struct {
 	vg             *VolumeGroup
 	originLvName   string
 	usageInPercent float64
+	suspended      bool
+	tags           []string
 }
 
 func (lv *LogicalVolume) Name() string {
 	return lv.name
 }
 
+// VGName returns the name of volume group the logical volume belongs to
+func (lv *LogicalVolume) VGName() string {
+	return lv.vg.Name()
+}
+
 func (lv *LogicalVolume) SizeInBytes() uint64 {
 	return lv.sizeInBytes
 }
@@ -410,6 +712,21 @@ func (lv *LogicalVolume) OriginLVName() string {
 	return lv.originLvName
 }
 
+// HasTag checks if the logical volume is tagged with tag
+func (lv *LogicalVolume) HasTag(tag string) bool {
+	for _, t := range lv.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns tags of the logical volume
+func (lv *LogicalVolume) Tags() []string {
+	return lv.tags
+}
+
 func (lv *LogicalVolume) Usage() float64 {
 	return lv.usageInPercent
 }
@@ -432,11 +749,135 @@ func (lv *LogicalVolume) Path() (string, error) {
 	return "", ErrLogicalVolumeNotFound
 }
 
+// OriginWrittenBytes returns bytes written to origin of the snapshot since
+// boot, read from block device stat under sysPath
+func (lv *LogicalVolume) OriginWrittenBytes(sysPath string) (uint64, error) {
+	if lv.originLvName == "" {
+		return 0, fmt.Errorf("logical volume %s/%s is not a snapshot", lv.vg.name, lv.name)
+	}
+	result := new(lvsOutput)
+	if err := run("lvs", result, "--options=lv_kernel_major,lv_kernel_minor", lv.vg.name+"/"+lv.originLvName); err != nil {
+		return 0, err
+	}
+	for _, report := range result.Report {
+		for _, origin := range report.Lv {
+			stat, err := os.ReadFile(filepath.Join(sysPath, "dev/block", origin.KernelMajor+":"+origin.KernelMinor, "stat"))
+			if err != nil {
+				return 0, err
+			}
+			return writtenBytesFromStat(string(stat))
+		}
+	}
+	return 0, ErrLogicalVolumeNotFound
+}
+
+// OriginSizeInBytes returns size of origin of the snapshot
+func (lv *LogicalVolume) OriginSizeInBytes() (uint64, error) {
+	if lv.originLvName == "" {
+		return 0, fmt.Errorf("logical volume %s/%s is not a snapshot", lv.vg.name, lv.name)
+	}
+	result := new(lvsOutput)
+	if err := run("lvs", result, "--options=lv_name,lv_size", lv.vg.name+"/"+lv.originLvName); err != nil {
+		return 0, err
+	}
+	for _, report := range result.Report {
+		for _, origin := range report.Lv {
+			return origin.LvSize, nil
+		}
+	}
+	return 0, ErrLogicalVolumeNotFound
+}
+
+// writtenBytesFromStat parses sectors written, the 7th field of block device
+// stat, into bytes
+func writtenBytesFromStat(stat string) (uint64, error) {
+	fields := strings.Fields(stat)
+	if len(fields) < 7 {
+		return 0, fmt.Errorf("invalid block device stat %q", stat)
+	}
+	sectors, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sectors written %q: %s", fields[6], err.Error())
+	}
+	return sectors * 512, nil
+}
+
+// SetReadAhead sets read ahead of the logical volume to sectors of 512 bytes,
+// it returns false without running lvchange if it is already set. lvm keeps
+// it in metadata and applies it whenever the logical volume is activated
+func (lv *LogicalVolume) SetReadAhead(sectors uint64) (bool, error) {
+	if err := lv.checkNotSuspended(); err != nil {
+		return false, err
+	}
+	result := new(lvsOutput)
+	if err := run("lvs", result, "--options=lv_read_ahead", lv.vg.name+"/"+lv.name); err != nil {
+		return false, err
+	}
+	for _, report := range result.Report {
+		for _, current := range report.Lv {
+			if readAheadMatches(current.ReadAhead, sectors) {
+				return false, nil
+			}
+		}
+	}
+	defer lockVG(lv.vg.name)()
+	if err := run("lvchange", nil, "--readahead", readAheadArg(sectors), lv.vg.name+"/"+lv.name); err != nil {
+		return false, err
+	}
+	log.Infof("[SetReadAhead]read ahead of logical volume %s/%s is set to %d sectors", lv.vg.name, lv.name, sectors)
+	return true, nil
+}
+
+// readAheadMatches checks lv_read_ahead reported in bytes, which is auto if
+// read ahead is decided by lvm
+func readAheadMatches(current string, sectors uint64) bool {
+	bytes, err := strconv.ParseUint(strings.TrimSpace(current), 10, 64)
+	if err != nil {
+		return false
+	}
+	return bytes == sectors*512
+}
+
+// readAheadArg returns argument of lvchange --readahead, where none disables it
+func readAheadArg(sectors uint64) string {
+	if sectors == 0 {
+		return "none"
+	}
+	return strconv.FormatUint(sectors, 10)
+}
+
 func (lv *LogicalVolume) IsSnapshot() bool {
 	return lv.originLvName != ""
 }
 
+// IsSuspended reports whether device-mapper target of the logical volume was
+// suspended when it was looked up
+func (lv *LogicalVolume) IsSuspended() bool {
+	return lv.suspended
+}
+
+// checkNotSuspended fails fast for operations on suspended logical volume
+func (lv *LogicalVolume) checkNotSuspended() error {
+	if lv.suspended {
+		return fmt.Errorf("%w: %s/%s, resume it by dmsetup resume after the failed operation is cleaned up", ErrLogicalVolumeSuspended, lv.vg.name, lv.name)
+	}
+	return nil
+}
+
+// isSuspendedAttr checks the state bit of lv_attr, which is s, S, M or C for
+// suspended logical volume
+func isSuspendedAttr(attr string) bool {
+	if len(attr) < 5 {
+		return false
+	}
+	return strings.ContainsRune("sSMC", rune(attr[4]))
+}
+
 func (lv *LogicalVolume) Remove() error {
+	if err := lv.checkNotSuspended(); err != nil {
+		return err
+	}
+	defer lockVG(lv.vg.name)()
 	if err := run("lvremove", nil, "-f", lv.vg.name+"/"+lv.name); err != nil {
 		log.Errorf("lvremove error: %s", err.Error())
 		return err
@@ -445,15 +886,15 @@ func (lv *LogicalVolume) Remove() error {
 }
 
 func (lv *LogicalVolume) Expand(size uint64) error {
-	args := []string{localtype.NsenterCmd, "lvextend", fmt.Sprintf("--size=+%db", size), lv.vg.name + "/" + lv.name}
-	cmd := strings.Join(args, " ")
-	log.V(6).Infof("[Expand]cmd: %s", cmd)
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
-	if err != nil {
+	if err := lv.checkNotSuspended(); err != nil {
 		return err
 	}
-	log.Infof("[Expand]out: %s", string(out))
-
+	defer lockVG(lv.vg.name)()
+	if err := run("lvextend", nil, fmt.Sprintf("--size=+%db", size), lv.vg.name+"/"+lv.name); err != nil {
+		log.Errorf("lvextend error: %s", err.Error())
+		return err
+	}
+	log.Infof("[Expand]logical volume %s/%s is expanded by %d bytes", lv.vg.name, lv.name, size)
 	return nil
 }
 
@@ -517,6 +958,7 @@ func CreateVolumeGroup(
 	if force {
 		args = append(args, "--force")
 	}
+	defer lockVG(name)()
 	if err := run("vgcreate", nil, args...); err != nil {
 		log.Errorf("CreateVolumeGroup error: %s", err.Error())
 		return nil, err
@@ -571,6 +1013,9 @@ type vgsOutput struct {
 			VgExtentSize      uint64 `json:"vg_extent_size,string"`
 			VgExtentCount     uint64 `json:"vg_extent_count,string"`
 			VgFreeExtentCount uint64 `json:"vg_free_count,string"`
+			VgMdaFree         uint64 `json:"vg_mda_free,string"`
+			VgMdaSize         uint64 `json:"vg_mda_size,string"`
+			VgAllocPolicy     string `json:"vg_allocation_policy"`
 			VgTags            string `json:"vg_tags"`
 		} `json:"vg"`
 	} `json:"report"`
@@ -650,6 +1095,8 @@ type pvsOutput struct {
 		Pv []struct {
 			Name   string `json:"pv_name"`
 			VgName string `json:"vg_name"`
+			PvSize uint64 `json:"pv_size,string"`
+			PvFree uint64 `json:"pv_free,string"`
 		} `json:"pv"`
 	} `json:"report"`
 }
@@ -692,16 +1139,15 @@ func LookupPhysicalVolume(name string) (*PhysicalVolume, error) {
 // https://github.com/Jajcus/lvm2/blob/266d6564d7a72fcff5b25367b7a95424ccf8089e/lib/metadata/metadata.c#L983
 
 func run(cmd string, v interface{}, extraArgs ...string) error {
-	var args []string
-	cmdUseNsenter := fmt.Sprintf("%s %s", localtype.NsenterCmd, cmd)
-	args = append(args, cmdUseNsenter)
-	if v != nil {
-		args = append(args, "--reportformat=json")
-		args = append(args, "--units=b")
-		args = append(args, "--nosuffix")
+	if isMutatingCommand(cmd) {
+		if err := WaitMutatingOp(context.Background()); err != nil {
+			return err
+		}
 	}
-	args = append(args, extraArgs...)
-	c := exec.Command("sh", "-c", strings.Join(args[:], " "))
+	if err := fault.Check(context.Background(), cmd); err != nil {
+		return err
+	}
+	c := newCommand(cmd, v != nil, extraArgs...)
 	// log.Infof("Executing: %s", c.String())
 	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
 	c.Stdout = stdout
@@ -727,6 +1173,19 @@ func run(cmd string, v interface{}, extraArgs ...string) error {
 	return nil
 }
 
+// newCommand builds lvm command which honors CommandConfig, report is true
+// if command outputs json report
+func newCommand(cmd string, report bool, extraArgs ...string) *exec.Cmd {
+	var args []string
+	if report {
+		args = append(args, "--reportformat=json")
+		args = append(args, "--units=b")
+		args = append(args, "--nosuffix")
+	}
+	args = append(args, extraArgs...)
+	return exec.Command("sh", "-c", Command(cmd, args...))
+}
+
 func ignoreWarnings(str string) string {
 	lines := strings.Split(str, "\n")
 	result := make([]string, 0, len(lines))
@@ -0,0 +1,373 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_largestFreeExtentCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		vgName string
+		want   uint64
+	}{
+		{
+			name: "test fragmented vg",
+			output: `{"report":[{"pvseg":[
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"100","segtype":"linear"},
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"20","segtype":"free"},
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"100","segtype":"linear"},
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"30","segtype":"free"},
+				{"pv_name":"/dev/sdc","vg_name":"vg","pvseg_size":"25","segtype":"free"}
+			]}]}`,
+			vgName: "vg",
+			want:   30,
+		},
+		{
+			name: "test segments reported as pv",
+			output: `{"report":[{"pv":[
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"40","segtype":"free"}
+			]}]}`,
+			vgName: "vg",
+			want:   40,
+		},
+		{
+			name: "test free segments of other vg",
+			output: `{"report":[{"pvseg":[
+				{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"100","segtype":"linear"},
+				{"pv_name":"/dev/sdc","vg_name":"other","pvseg_size":"50","segtype":"free"}
+			]}]}`,
+			vgName: "vg",
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := new(pvsegsOutput)
+			if err := json.Unmarshal([]byte(tt.output), result); err != nil {
+				t.Fatalf("unmarshal error: %s", err.Error())
+			}
+			if got := largestFreeExtentCount(result, tt.vgName); got != tt.want {
+				t.Errorf("largestFreeExtentCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_physicalVolumeSpaces(t *testing.T) {
+	output := `{"report":[{"pv":[
+		{"pv_name":"/dev/sdb","vg_name":"vg","pv_size":"107369988096","pv_free":"0"},
+		{"pv_name":"/dev/sdc","vg_name":"vg","pv_size":"107369988096","pv_free":"53687091200"},
+		{"pv_name":"/dev/sdd","vg_name":"other","pv_size":"53682896896","pv_free":"53682896896"},
+		{"pv_name":"/dev/sde","vg_name":"","pv_size":"10737418240","pv_free":"10737418240"}
+	]}]}`
+	result := new(pvsOutput)
+	if err := json.Unmarshal([]byte(output), result); err != nil {
+		t.Fatalf("unmarshal error: %s", err.Error())
+	}
+	want := []PhysicalVolumeSpace{
+		{Name: "/dev/sdb", Size: 107369988096, Free: 0},
+		{Name: "/dev/sdc", Size: 107369988096, Free: 53687091200},
+	}
+	if got := physicalVolumeSpaces(result, "vg"); !reflect.DeepEqual(got, want) {
+		t.Errorf("physicalVolumeSpaces() = %+v, want %+v", got, want)
+	}
+	if got := physicalVolumeSpaces(result, "missing"); got != nil {
+		t.Errorf("physicalVolumeSpaces() of missing vg = %+v, want nil", got)
+	}
+}
+
+func Test_physicalVolumeLVs(t *testing.T) {
+	output := `{"report":[{"pvseg":[
+		{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"100","segtype":"linear","lv_name":"lv1","lv_attr":"-wi-ao----"},
+		{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"20","segtype":"free","lv_name":"","lv_attr":""},
+		{"pv_name":"/dev/sdb","vg_name":"vg","pvseg_size":"50","segtype":"linear","lv_name":"lv1","lv_attr":"-wi-ao----"},
+		{"pv_name":"/dev/sdc","vg_name":"vg","pvseg_size":"30","segtype":"linear","lv_name":"lv1","lv_attr":"-wi-ao----"},
+		{"pv_name":"/dev/sdc","vg_name":"vg","pvseg_size":"10","segtype":"linear","lv_name":"snap","lv_attr":"swi-a-s---"},
+		{"pv_name":"/dev/sdd","vg_name":"other","pvseg_size":"10","segtype":"linear","lv_name":"lv2","lv_attr":"-wi-a-----"}
+	]}]}`
+	result := new(pvsegsOutput)
+	if err := json.Unmarshal([]byte(output), result); err != nil {
+		t.Fatalf("unmarshal error: %s", err.Error())
+	}
+	want := []PhysicalVolumeLV{
+		{PVName: "/dev/sdb", LVName: "lv1", Attr: "-wi-ao----", Size: 150 * 4096},
+		{PVName: "/dev/sdc", LVName: "lv1", Attr: "-wi-ao----", Size: 30 * 4096},
+		{PVName: "/dev/sdc", LVName: "snap", Attr: "swi-a-s---", Size: 10 * 4096},
+	}
+	if got := physicalVolumeLVs(result, "vg", 4096); !reflect.DeepEqual(got, want) {
+		t.Errorf("physicalVolumeLVs() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_metadataUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		wantFree uint64
+		wantSize uint64
+		wantErr  bool
+	}{
+		{
+			name:     "test metadata usage",
+			output:   `{"report":[{"vg":[{"vg_mda_free":"517632","vg_mda_size":"1044480"}]}]}`,
+			wantFree: 517632,
+			wantSize: 1044480,
+			wantErr:  false,
+		},
+		{
+			name:     "test metadata full",
+			output:   `{"report":[{"vg":[{"vg_mda_free":"0","vg_mda_size":"1044480"}]}]}`,
+			wantFree: 0,
+			wantSize: 1044480,
+			wantErr:  false,
+		},
+		{
+			name:    "test vg not found",
+			output:  `{"report":[{"vg":[]}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := new(vgsOutput)
+			if err := json.Unmarshal([]byte(tt.output), result); err != nil {
+				t.Fatalf("unmarshal error: %s", err.Error())
+			}
+			free, size, err := metadataUsage(result)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("metadataUsage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if free != tt.wantFree || size != tt.wantSize {
+				t.Errorf("metadataUsage() = %v, %v, want %v, %v", free, size, tt.wantFree, tt.wantSize)
+			}
+		})
+	}
+}
+
+func Test_isSuspendedAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr string
+		want bool
+	}{
+		{
+			name: "test active lv",
+			attr: "-wi-ao----",
+			want: false,
+		},
+		{
+			name: "test suspended lv",
+			attr: "-wi-so----",
+			want: true,
+		},
+		{
+			name: "test invalid suspended snapshot",
+			attr: "swi-S-s---",
+			want: true,
+		},
+		{
+			name: "test malformed attr",
+			attr: "-wi",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSuspendedAttr(tt.attr); got != tt.want {
+				t.Errorf("isSuspendedAttr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_LogicalVolume_Suspended(t *testing.T) {
+	// operations fail before any lvm command runs
+	lv := &LogicalVolume{name: "lv", vg: &VolumeGroup{name: "vg"}, suspended: true}
+	if err := lv.Expand(1024); !errors.Is(err, ErrLogicalVolumeSuspended) {
+		t.Errorf("Expand() error = %v, want %v", err, ErrLogicalVolumeSuspended)
+	}
+	if err := lv.Remove(); !errors.Is(err, ErrLogicalVolumeSuspended) {
+		t.Errorf("Remove() error = %v, want %v", err, ErrLogicalVolumeSuspended)
+	}
+}
+
+func Test_LogicalVolume_HasTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want bool
+	}{
+		{name: "test no tag", tags: "", want: false},
+		{name: "test managed tag", tags: "open-local.io/managed", want: true},
+		{name: "test managed tag among others", tags: "foo,open-local.io/managed", want: true},
+		{name: "test other tags", tags: "foo,open-local.io/managed-by", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := &LogicalVolume{name: "lv", tags: splitTags(tt.tags)}
+			if got := lv.HasTag("open-local.io/managed"); got != tt.want {
+				t.Errorf("HasTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writtenBytesFromStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    string
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name: "test block device stat",
+			stat: "    1471        0    86704      612    20480        0   163840     9812        0     7844    10424        0        0        0        0\n",
+			want: 163840 * 512,
+		},
+		{
+			name:    "test truncated stat",
+			stat:    "1471 0 86704",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := writtenBytesFromStat(tt.stat)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("writtenBytesFromStat() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("writtenBytesFromStat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readAheadMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		sectors uint64
+		want    bool
+	}{
+		{
+			name:    "test already set",
+			current: "131072",
+			sectors: 256,
+			want:    true,
+		},
+		{
+			name:    "test different read ahead",
+			current: "131072",
+			sectors: 16,
+			want:    false,
+		},
+		{
+			name:    "test auto read ahead",
+			current: "auto",
+			sectors: 256,
+			want:    false,
+		},
+		{
+			name:    "test disabled read ahead",
+			current: "0",
+			sectors: 0,
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readAheadMatches(tt.current, tt.sectors); got != tt.want {
+				t.Errorf("readAheadMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_allocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    Allocation
+		wantErr bool
+	}{
+		{
+			name:   "test normal policy",
+			output: `{"report":[{"vg":[{"vg_extent_size":"4194304","vg_extent_count":"25599","vg_free_count":"12800","vg_allocation_policy":"normal"}]}]}`,
+			want:   Allocation{ExtentSize: 4194304, ExtentCount: 25599, FreeExtentCount: 12800, Policy: "normal"},
+		},
+		{
+			name:   "test full vg of contiguous policy",
+			output: `{"report":[{"vg":[{"vg_extent_size":"33554432","vg_extent_count":"1024","vg_free_count":"0","vg_allocation_policy":"contiguous"}]}]}`,
+			want:   Allocation{ExtentSize: 33554432, ExtentCount: 1024, FreeExtentCount: 0, Policy: "contiguous"},
+		},
+		{
+			name:    "test vg not found",
+			output:  `{"report":[{"vg":[]}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := new(vgsOutput)
+			if err := json.Unmarshal([]byte(tt.output), result); err != nil {
+				t.Fatalf("unmarshal error: %s", err.Error())
+			}
+			got, err := allocation(result)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("allocation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("allocation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_snapshotRelations(t *testing.T) {
+	output := `{"report":[{"lv":[
+		{"lv_name":"origin","vg_name":"vg","origin":""},
+		{"lv_name":"snap-1","vg_name":"vg","origin":"origin"},
+		{"lv_name":"snap-0","vg_name":"vg","origin":"origin"},
+		{"lv_name":"snap-0-0","vg_name":"vg","origin":"snap-0"},
+		{"lv_name":"plain","vg_name":"vg","origin":""},
+		{"lv_name":"snap-other","vg_name":"other","origin":"origin"}
+	]}]}`
+	result := new(lvsOutput)
+	if err := json.Unmarshal([]byte(output), result); err != nil {
+		t.Fatalf("unmarshal error: %s", err.Error())
+	}
+	want := map[string]SnapshotRelation{
+		"origin":   {Snapshots: []string{"snap-0", "snap-1"}},
+		"snap-0":   {Origin: "origin", Snapshots: []string{"snap-0-0"}, Depth: 1},
+		"snap-1":   {Origin: "origin", Depth: 1},
+		"snap-0-0": {Origin: "snap-0", Depth: 2},
+		"plain":    {},
+	}
+	if got := snapshotRelations(result, "vg"); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshotRelations() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "k8s.io/klog/v2"
+)
+
+// PercentBase is what percentage of lvextend -l refers to
+type PercentBase string
+
+const (
+	// PercentOfVG is percentage of total size of volume group
+	PercentOfVG PercentBase = "VG"
+	// PercentOfFree is percentage of free space of volume group
+	PercentOfFree PercentBase = "FREE"
+)
+
+// PercentSize is size of lvextend -l in percentage, such as 80%VG which is
+// the new size of lv, or +50%FREE which is added to lv
+type PercentSize struct {
+	Percent float64
+	Base    PercentBase
+	// Relative is true for size with + prefix
+	Relative bool
+}
+
+// IsPercentSize checks if value ends with %VG or %FREE
+func IsPercentSize(value string) bool {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	return strings.HasSuffix(value, "%"+string(PercentOfVG)) || strings.HasSuffix(value, "%"+string(PercentOfFree))
+}
+
+// ParsePercentSize parses [+]N%VG or [+]N%FREE, N must be in (0, 100]
+func ParsePercentSize(value string) (PercentSize, error) {
+	var size PercentSize
+	str := strings.ToUpper(strings.TrimSpace(value))
+	if strings.HasPrefix(str, "+") {
+		size.Relative = true
+		str = str[1:]
+	}
+	index := strings.Index(str, "%")
+	if index < 0 {
+		return PercentSize{}, fmt.Errorf("size in percentage must be in form of [+]N%%VG or [+]N%%FREE, got %q", value)
+	}
+	size.Base = PercentBase(str[index+1:])
+	if size.Base != PercentOfVG && size.Base != PercentOfFree {
+		return PercentSize{}, fmt.Errorf("size in percentage must be in form of [+]N%%VG or [+]N%%FREE, got %q", value)
+	}
+	percent, err := strconv.ParseFloat(str[:index], 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return PercentSize{}, fmt.Errorf("percentage must be a number in (0, 100], got %q", value)
+	}
+	size.Percent = percent
+	return size, nil
+}
+
+func (p PercentSize) String() string {
+	var prefix string
+	if p.Relative {
+		prefix = "+"
+	}
+	return fmt.Sprintf("%s%g%%%s", prefix, p.Percent, p.Base)
+}
+
+// TargetExtents returns the new extents of lv of lvExtents in volume group of
+// vgExtents and freeExtents. It is clamped to free space and never less than
+// lvExtents, which means nothing to expand.
+func (p PercentSize) TargetExtents(lvExtents, vgExtents, freeExtents uint64) uint64 {
+	base := vgExtents
+	if p.Base == PercentOfFree {
+		base = freeExtents
+	}
+	// lvm rounds percentage down to extent
+	target := uint64(float64(base) * p.Percent / 100)
+	if p.Relative {
+		target += lvExtents
+	}
+	if target > lvExtents+freeExtents {
+		target = lvExtents + freeExtents
+	}
+	if target < lvExtents {
+		target = lvExtents
+	}
+	return target
+}
+
+// ExpansionBytes returns bytes to add to lv of lvSize bytes to reach the
+// target, which is 0 if lv is already large enough or vg is full
+func (p PercentSize) ExpansionBytes(lvSize, vgSize, free, extentSize uint64) uint64 {
+	if extentSize == 0 {
+		return 0
+	}
+	lvExtents := (lvSize + extentSize - 1) / extentSize
+	return (p.TargetExtents(lvExtents, vgSize/extentSize, free/extentSize) - lvExtents) * extentSize
+}
+
+// ExpandToPercent expands the logical volume to the target in percentage.
+// The target is resolved to extents by current space of volume group and it
+// returns the bytes added, 0 if nothing is expanded.
+func (lv *LogicalVolume) ExpandToPercent(size PercentSize) (uint64, error) {
+	if err := lv.checkNotSuspended(); err != nil {
+		return 0, err
+	}
+	defer lockVG(lv.vg.name)()
+	vgSize, err := lv.vg.BytesTotal()
+	if err != nil {
+		return 0, err
+	}
+	free, err := lv.vg.BytesFree()
+	if err != nil {
+		return 0, err
+	}
+	extentSize, err := lv.vg.ExtentSize()
+	if err != nil {
+		return 0, err
+	}
+	added := size.ExpansionBytes(lv.sizeInBytes, vgSize, free, extentSize)
+	if added == 0 {
+		log.Infof("[ExpandToPercent]logical volume %s/%s of %d bytes already reaches %s", lv.vg.name, lv.name, lv.sizeInBytes, size)
+		return 0, nil
+	}
+	// concrete extents instead of percentage, which lvm resolves by itself
+	extents := (lv.sizeInBytes+extentSize-1)/extentSize + added/extentSize
+	if err := run("lvextend", nil, fmt.Sprintf("--extents=%d", extents), lv.vg.name+"/"+lv.name); err != nil {
+		return 0, err
+	}
+	log.Infof("[ExpandToPercent]logical volume %s/%s is expanded to %d extents", lv.vg.name, lv.name, extents)
+	lv.sizeInBytes = extents * extentSize
+	return added, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParsePercentSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    PercentSize
+		wantErr bool
+	}{
+		{name: "test percentage of vg", value: "80%VG", want: PercentSize{Percent: 80, Base: PercentOfVG}},
+		{name: "test relative percentage of free", value: "+50%free", want: PercentSize{Percent: 50, Base: PercentOfFree, Relative: true}},
+		{name: "test percentage of origin", value: "20%", wantErr: true},
+		{name: "test unknown base", value: "20%PVS", wantErr: true},
+		{name: "test zero percentage", value: "0%VG", wantErr: true},
+		{name: "test percentage over 100", value: "+120%FREE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// percentage of origin is left to snapshot size
+			if wantPercentSize := tt.value != "20%" && tt.value != "20%PVS"; IsPercentSize(tt.value) != wantPercentSize {
+				t.Errorf("IsPercentSize(%q) = %v, want %v", tt.value, !wantPercentSize, wantPercentSize)
+			}
+			got, err := ParsePercentSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePercentSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePercentSize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PercentSize_TargetExtents(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        string
+		lvExtents   uint64
+		vgExtents   uint64
+		freeExtents uint64
+		want        uint64
+	}{
+		{name: "test percentage of vg", size: "80%VG", lvExtents: 100, vgExtents: 1000, freeExtents: 900, want: 800},
+		{name: "test percentage of vg rounded down", size: "33%VG", lvExtents: 100, vgExtents: 1001, freeExtents: 900, want: 330},
+		{name: "test relative percentage of vg", size: "+10%VG", lvExtents: 100, vgExtents: 1000, freeExtents: 900, want: 200},
+		{name: "test percentage of vg clamped at capacity", size: "80%VG", lvExtents: 100, vgExtents: 1000, freeExtents: 300, want: 400},
+		{name: "test percentage of vg smaller than lv", size: "5%VG", lvExtents: 100, vgExtents: 1000, freeExtents: 900, want: 100},
+		{name: "test relative percentage of free", size: "+50%FREE", lvExtents: 100, vgExtents: 1000, freeExtents: 300, want: 250},
+		{name: "test all free", size: "+100%FREE", lvExtents: 100, vgExtents: 1000, freeExtents: 300, want: 400},
+		{name: "test percentage of free", size: "50%FREE", lvExtents: 100, vgExtents: 1000, freeExtents: 300, want: 150},
+		{name: "test full vg", size: "+10%VG", lvExtents: 100, vgExtents: 1000, freeExtents: 0, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := ParsePercentSize(tt.size)
+			if err != nil {
+				t.Fatalf("ParsePercentSize() error = %v", err)
+			}
+			if got := size.TargetExtents(tt.lvExtents, tt.vgExtents, tt.freeExtents); got != tt.want {
+				t.Errorf("TargetExtents() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PercentSize_ExpansionBytes(t *testing.T) {
+	const extent = 4 * 1024 * 1024
+	size := PercentSize{Percent: 10, Base: PercentOfVG, Relative: true}
+	// lv not aligned to extent is counted in rounded up extents
+	if got := size.ExpansionBytes(100*extent-1, 1000*extent, 50*extent, extent); got != 50*extent {
+		t.Errorf("ExpansionBytes() = %d, want %d", got, 50*extent)
+	}
+	if got := size.ExpansionBytes(100*extent, 1000*extent, 500*extent, extent); got != 100*extent {
+		t.Errorf("ExpansionBytes() = %d, want %d", got, 100*extent)
+	}
+	if got := size.ExpansionBytes(100*extent, 1000*extent, 500*extent, 0); got != 0 {
+		t.Errorf("ExpansionBytes() of unknown extent size = %d, want 0", got)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	log "k8s.io/klog/v2"
+)
+
+const ErrFilesystemNotShrinkable = simpleError("lvm: filesystem does not support shrinking")
+const ErrShrinkBelowUsed = simpleError("lvm: used space of filesystem exceeds target size")
+
+// shrinkableFilesystems are filesystems resize2fs shrinks offline, xfs can
+// never be shrunk
+var shrinkableFilesystems = map[string]bool{
+	"ext2": true,
+	"ext3": true,
+	"ext4": true,
+}
+
+var (
+	// replaced in unit test
+	probeFilesystem     = blkidFilesystem
+	findMountPoints     = findmntTargets
+	checkExtFilesystem  = e2fsck
+	extUsedBytes        = dumpe2fsUsedBytes
+	resizeExtFilesystem = resize2fs
+)
+
+// Shrink shrinks filesystem of the logical volume to size and then the
+// logical volume itself. It is an offline admin operation which is never
+// reachable by csi, the filesystem must be unmounted and backed up.
+func (lv *LogicalVolume) Shrink(size uint64) error {
+	if err := lv.checkNotSuspended(); err != nil {
+		return err
+	}
+	if size == 0 || size >= lv.sizeInBytes {
+		return fmt.Errorf("target size %d of %s/%s must be less than current size %d", size, lv.vg.name, lv.name, lv.sizeInBytes)
+	}
+	dev, err := lv.Path()
+	if err != nil {
+		return err
+	}
+	defer lockVG(lv.vg.name)()
+	if err := shrinkFilesystem(dev, size); err != nil {
+		return err
+	}
+	// lvreduce rounds size up to extent, which never cuts the filesystem
+	if err := run("lvreduce", nil, "-f", fmt.Sprintf("--size=%db", size), lv.vg.name+"/"+lv.name); err != nil {
+		return fmt.Errorf("filesystem of %s is shrunk but lvreduce failed: %s", dev, err.Error())
+	}
+	log.Infof("[Shrink]logical volume %s/%s is shrunk to %d bytes", lv.vg.name, lv.name, size)
+	return nil
+}
+
+// shrinkFilesystem shrinks filesystem on dev to size, it refuses mounted,
+// unsupported or too full filesystem before anything is changed
+func shrinkFilesystem(dev string, size uint64) error {
+	fsType, err := probeFilesystem(dev)
+	if err != nil {
+		return fmt.Errorf("failed to probe filesystem of %s: %s", dev, err.Error())
+	}
+	if !shrinkableFilesystems[fsType] {
+		if fsType == "" {
+			fsType = "none"
+		}
+		return fmt.Errorf("%w: %s of %s", ErrFilesystemNotShrinkable, fsType, dev)
+	}
+	mountPoints, err := findMountPoints(dev)
+	if err != nil {
+		return fmt.Errorf("failed to find mount points of %s: %s", dev, err.Error())
+	}
+	if len(mountPoints) > 0 {
+		return fmt.Errorf("%s is mounted at %s, stop the pod using it first", dev, strings.Join(mountPoints, ","))
+	}
+	// resize2fs requires a freshly checked filesystem
+	if err := checkExtFilesystem(dev); err != nil {
+		return fmt.Errorf("failed to check filesystem of %s: %s", dev, err.Error())
+	}
+	used, err := extUsedBytes(dev)
+	if err != nil {
+		return fmt.Errorf("failed to get used space of %s: %s", dev, err.Error())
+	}
+	if used > size {
+		return fmt.Errorf("%w: %d bytes used in %s, target %d bytes", ErrShrinkBelowUsed, used, dev, size)
+	}
+	return resizeExtFilesystem(dev, size)
+}
+
+func blkidFilesystem(dev string) (string, error) {
+	out, err := exec.Command("sh", "-c", fmt.Sprintf("%s blkid -p -s TYPE -o value %s", localtype.NsenterCmd, dev)).CombinedOutput()
+	if err != nil {
+		// blkid exits with 2 if no signature is found
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			return "", nil
+		}
+		return "", fmt.Errorf("%s: %s", err.Error(), string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func findmntTargets(dev string) ([]string, error) {
+	out, err := exec.Command("sh", "-c", fmt.Sprintf("%s findmnt -n -o TARGET -S %s", localtype.NsenterCmd, dev)).CombinedOutput()
+	if err != nil {
+		// findmnt exits with 1 if nothing is found
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %s", err.Error(), string(out))
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func e2fsck(dev string) error {
+	out, err := exec.Command("sh", "-c", fmt.Sprintf("%s e2fsck -f -y %s", localtype.NsenterCmd, dev)).CombinedOutput()
+	if err != nil {
+		// e2fsck exits with 1 if errors are corrected
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil
+		}
+		return fmt.Errorf("%s: %s", err.Error(), string(out))
+	}
+	return nil
+}
+
+func dumpe2fsUsedBytes(dev string) (uint64, error) {
+	out, err := exec.Command("sh", "-c", fmt.Sprintf("%s dumpe2fs -h %s", localtype.NsenterCmd, dev)).Output()
+	if err != nil {
+		return 0, err
+	}
+	return usedBytesFromDumpe2fs(string(out))
+}
+
+// usedBytesFromDumpe2fs parses used bytes from superblock printed by dumpe2fs -h
+func usedBytesFromDumpe2fs(out string) (uint64, error) {
+	fields := map[string]uint64{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if key != "Block count" && key != "Free blocks" && key != "Block size" {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", key, parts[1])
+		}
+		fields[key] = value
+	}
+	count, okCount := fields["Block count"]
+	free, okFree := fields["Free blocks"]
+	blockSize, okSize := fields["Block size"]
+	if !okCount || !okFree || !okSize || free > count {
+		return 0, fmt.Errorf("invalid superblock from dumpe2fs: %q", out)
+	}
+	return (count - free) * blockSize, nil
+}
+
+func resize2fs(dev string, size uint64) error {
+	// resize2fs takes K as unit and rounds down to filesystem block
+	cmd := fmt.Sprintf("%s resize2fs %s %dK", localtype.NsenterCmd, dev, size/1024)
+	log.Infof("[Shrink]cmd: %s", cmd)
+	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), string(out))
+	}
+	log.Infof("[Shrink]out: %s", string(out))
+	return nil
+}
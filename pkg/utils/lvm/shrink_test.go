@@ -0,0 +1,141 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_shrinkFilesystem(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	tests := []struct {
+		name        string
+		fsType      string
+		mountPoints []string
+		used        uint64
+		size        uint64
+		wantErr     bool
+		wantErrIs   error
+		wantResized bool
+	}{
+		{
+			name:        "test shrink ext4",
+			fsType:      "ext4",
+			used:        3 * gi,
+			size:        5 * gi,
+			wantResized: true,
+		},
+		{
+			name:      "test used space exceeds target",
+			fsType:    "ext4",
+			used:      6 * gi,
+			size:      5 * gi,
+			wantErr:   true,
+			wantErrIs: ErrShrinkBelowUsed,
+		},
+		{
+			name:      "test reject xfs",
+			fsType:    "xfs",
+			used:      1 * gi,
+			size:      5 * gi,
+			wantErr:   true,
+			wantErrIs: ErrFilesystemNotShrinkable,
+		},
+		{
+			name:      "test reject raw block",
+			fsType:    "",
+			size:      5 * gi,
+			wantErr:   true,
+			wantErrIs: ErrFilesystemNotShrinkable,
+		},
+		{
+			name:        "test reject mounted",
+			fsType:      "ext4",
+			mountPoints: []string{"/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount"},
+			used:        1 * gi,
+			size:        5 * gi,
+			wantErr:     true,
+		},
+	}
+	originProbe, originFind, originCheck, originUsed, originResize := probeFilesystem, findMountPoints, checkExtFilesystem, extUsedBytes, resizeExtFilesystem
+	defer func() {
+		probeFilesystem, findMountPoints, checkExtFilesystem, extUsedBytes, resizeExtFilesystem = originProbe, originFind, originCheck, originUsed, originResize
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resized uint64
+			probeFilesystem = func(dev string) (string, error) { return tt.fsType, nil }
+			findMountPoints = func(dev string) ([]string, error) { return tt.mountPoints, nil }
+			checkExtFilesystem = func(dev string) error { return nil }
+			extUsedBytes = func(dev string) (uint64, error) { return tt.used, nil }
+			resizeExtFilesystem = func(dev string, size uint64) error {
+				resized = size
+				return nil
+			}
+
+			err := shrinkFilesystem("/dev/vg/lv", tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("shrinkFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("shrinkFilesystem() error = %v, want %v", err, tt.wantErrIs)
+			}
+			if tt.wantResized != (resized == tt.size) {
+				t.Errorf("shrinkFilesystem() resized to %d, want resized %v", resized, tt.wantResized)
+			}
+		})
+	}
+}
+
+func Test_usedBytesFromDumpe2fs(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name: "test ext4 superblock",
+			out: `Filesystem volume name:   <none>
+Filesystem UUID:          0b3c5c4e-4f57-4d2a-9d35-2d5a0e1e3b43
+Block count:              2621440
+Reserved block count:     131072
+Free blocks:              2554432
+Free inodes:              655349
+Block size:               4096
+`,
+			want: (2621440 - 2554432) * 4096,
+		},
+		{
+			name:    "test missing block size",
+			out:     "Block count:              2621440\nFree blocks:              2554432\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := usedBytesFromDumpe2fs(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("usedBytesFromDumpe2fs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("usedBytesFromDumpe2fs() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	log "k8s.io/klog/v2"
+)
+
+var (
+	limiterLock sync.RWMutex
+	// mutatingLimiter paces lvm operations which change volumes, nil means unlimited
+	mutatingLimiter *rate.Limiter
+)
+
+// mutatingCommands change lvm metadata or volume data, other commands are
+// read-only queries and never throttled
+var mutatingCommands = map[string]bool{
+	"lvcreate": true,
+	"lvextend": true,
+	"lvreduce": true,
+	"lvremove": true,
+	"lvrename": true,
+	"lvchange": true,
+	"vgcreate": true,
+	"vgextend": true,
+	"vgremove": true,
+	"pvcreate": true,
+	"pvremove": true,
+	"pvmove":   true,
+}
+
+// SetMutatingOpsLimit limits mutating lvm operations to opsPerSecond, the
+// limit is disabled if opsPerSecond <= 0
+func SetMutatingOpsLimit(opsPerSecond float64) {
+	limiterLock.Lock()
+	defer limiterLock.Unlock()
+	if opsPerSecond <= 0 {
+		mutatingLimiter = nil
+		return
+	}
+	log.Infof("mutating lvm operations are limited to %v per second", opsPerSecond)
+	mutatingLimiter = rate.NewLimiter(rate.Limit(opsPerSecond), 1)
+}
+
+// WaitMutatingOp blocks until a mutating lvm operation is allowed. Throttled
+// operation queues until its turn rather than fails, unless ctx is done.
+func WaitMutatingOp(ctx context.Context) error {
+	limiterLock.RLock()
+	limiter := mutatingLimiter
+	limiterLock.RUnlock()
+	if limiter == nil {
+		return nil
+	}
+	// rate.Limiter.Wait fails at once if ctx deadline is earlier than the turn
+	r := limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	log.V(4).Infof("mutating lvm operation is throttled for %v", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+func isMutatingCommand(cmd string) bool {
+	return mutatingCommands[cmd]
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitMutatingOp(t *testing.T) {
+	tests := []struct {
+		name         string
+		opsPerSecond float64
+		ops          int
+		minElapsed   time.Duration
+		maxElapsed   time.Duration
+	}{
+		{
+			name:         "test unlimited",
+			opsPerSecond: 0,
+			ops:          50,
+			minElapsed:   0,
+			maxElapsed:   100 * time.Millisecond,
+		},
+		{
+			name:         "test paced by limit",
+			opsPerSecond: 20,
+			ops:          11,
+			// the first op passes at once, the other 10 ops take 50ms each
+			minElapsed: 450 * time.Millisecond,
+			maxElapsed: 2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMutatingOpsLimit(tt.opsPerSecond)
+			defer SetMutatingOpsLimit(0)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, tt.ops)
+			start := time.Now()
+			for i := 0; i < tt.ops; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- WaitMutatingOp(context.Background())
+				}()
+			}
+			wg.Wait()
+			elapsed := time.Since(start)
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Errorf("WaitMutatingOp() error = %v", err)
+				}
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("%d ops took %v, want between %v and %v", tt.ops, elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestWaitMutatingOp_Canceled(t *testing.T) {
+	SetMutatingOpsLimit(1)
+	defer SetMutatingOpsLimit(0)
+
+	if err := WaitMutatingOp(context.Background()); err != nil {
+		t.Fatalf("WaitMutatingOp() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := WaitMutatingOp(ctx); err == nil {
+		t.Errorf("WaitMutatingOp() should fail when ctx is done before its turn")
+	}
+}
+
+func TestIsMutatingCommand(t *testing.T) {
+	for cmd, want := range map[string]bool{
+		"lvcreate": true,
+		"lvextend": true,
+		"lvremove": true,
+		"lvs":      false,
+		"vgs":      false,
+		"pvs":      false,
+	} {
+		if got := isMutatingCommand(cmd); got != want {
+			t.Errorf("isMutatingCommand(%s) = %v, want %v", cmd, got, want)
+		}
+	}
+}
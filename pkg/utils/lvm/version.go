@@ -0,0 +1,106 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the version printed by lvm version, such as 2.03.11(2)
+type Version struct {
+	// LVM is the version of lvm2 tools
+	LVM string
+	// Library is the version of libdevmapper
+	Library string
+	// Driver is the version of device-mapper driver in kernel
+	Driver string
+}
+
+// GetVersion runs lvm version
+func GetVersion() (Version, error) {
+	c := newCommand("lvm version", false)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		return Version{}, errors.New(ignoreWarnings(stderr.String()))
+	}
+	return ParseVersion(stdout.String())
+}
+
+// ParseVersion parses output of lvm version, which looks like
+//
+//	LVM version:     2.03.11(2) (2021-01-08)
+//	Library version: 1.02.175 (2021-01-08)
+//	Driver version:  4.45.0
+//	Configuration:   ./configure ...
+func ParseVersion(out string) (Version, error) {
+	v := Version{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "LVM version":
+			v.LVM = fields[0]
+		case "Library version":
+			v.Library = fields[0]
+		case "Driver version":
+			v.Driver = fields[0]
+		}
+	}
+	if v.LVM == "" {
+		return Version{}, fmt.Errorf("no lvm version found in %q", out)
+	}
+	return v, nil
+}
+
+// VersionAtLeast reports whether version such as 2.03.11(2) is no older than
+// major.minor.patch, false if version can not be parsed
+func VersionAtLeast(version string, major, minor, patch int) bool {
+	// strip api version in parentheses
+	if i := strings.Index(version, "("); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	got := make([]int, 0, 3)
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		}
+		got = append(got, n)
+	}
+	for i, want := range []int{major, minor, patch} {
+		if got[i] != want {
+			return got[i] > want
+		}
+	}
+	return true
+}
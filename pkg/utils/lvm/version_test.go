@@ -0,0 +1,72 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"testing"
+)
+
+const sampleVersionOutput = `  LVM version:     2.03.11(2) (2021-01-08)
+  Library version: 1.02.175 (2021-01-08)
+  Driver version:  4.45.0
+  Configuration:   ./configure --build=x86_64-linux-gnu --prefix=/usr --enable-cmdlib
+`
+
+func Test_ParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    Version
+		wantErr bool
+	}{
+		{name: "test lvm version output", out: sampleVersionOutput, want: Version{LVM: "2.03.11(2)", Library: "1.02.175", Driver: "4.45.0"}},
+		{name: "test without driver", out: "  LVM version:     2.02.187(2)-RHEL7 (2020-03-24)\n  Library version: 1.02.170-RHEL7 (2020-03-24)\n", want: Version{LVM: "2.02.187(2)-RHEL7", Library: "1.02.170-RHEL7"}},
+		{name: "test empty output", out: "", wantErr: true},
+		{name: "test missing lvm version", out: "  Library version: 1.02.175 (2021-01-08)\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVersion(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVersion() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_VersionAtLeast(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    bool
+	}{
+		{name: "test same version", version: "2.02.158(2)", want: true},
+		{name: "test newer minor", version: "2.03.11(2)", want: true},
+		{name: "test older patch", version: "2.02.98(2)", want: false},
+		{name: "test invalid version", version: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VersionAtLeast(tt.version, 2, 2, 158); got != tt.want {
+				t.Errorf("VersionAtLeast(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
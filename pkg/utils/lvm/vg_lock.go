@@ -0,0 +1,165 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "k8s.io/klog/v2"
+)
+
+// DefaultLockDir is the host directory of lock files of vg and device, it is
+// mounted by agent and csi plugin at the same path so that agent and lvmd of
+// node serialize mutating operations on the same vg
+const DefaultLockDir = "/run/open-local/lock"
+
+var (
+	locksLock sync.Mutex
+	// locks holds a semaphore of one slot for every vg and device, they are
+	// few on node so semaphores are never released
+	locks = map[string]chan struct{}{}
+	// lockDir is the directory of lock files, empty means operations are
+	// serialized in process only
+	lockDir string
+	// lockRetryInterval is the interval of retrying lock file held by
+	// another process
+	lockRetryInterval = 100 * time.Millisecond
+)
+
+// SetLockDir makes following locks of vg and device taken on lock files in
+// dir as well, which is shared by processes of node. Empty dir means
+// operations are serialized in process only
+func SetLockDir(dir string) {
+	locksLock.Lock()
+	defer locksLock.Unlock()
+	if dir != "" {
+		log.Infof("mutating lvm operations are serialized by lock files in %s", dir)
+	}
+	lockDir = dir
+}
+
+func semaphore(key string) (chan struct{}, string) {
+	locksLock.Lock()
+	defer locksLock.Unlock()
+	sem, ok := locks[key]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		locks[key] = sem
+	}
+	return sem, lockDir
+}
+
+// LockVolumeGroup serializes mutating operations on the same vg, operations
+// on different vgs run concurrently. It blocks until the running operation
+// on vg calls unlock, unless ctx is done. Empty vgName is never locked.
+// Goroutines of one process queue on a semaphore, and the one holding it
+// takes flock of the lock file of vg, which excludes other processes such as
+// agent and lvmd taking lock of the same vg
+func LockVolumeGroup(ctx context.Context, vgName string) (unlock func(), err error) {
+	if vgName == "" {
+		return func() {}, nil
+	}
+	return lock(ctx, "vg "+vgName, "vg-"+vgName)
+}
+
+// LockDevice serializes mutating operations on device out of any vg, such as
+// wiping device volume, the same way as LockVolumeGroup
+func LockDevice(ctx context.Context, device string) (unlock func(), err error) {
+	if device == "" {
+		return func() {}, nil
+	}
+	return lock(ctx, "device "+device, "dev-"+strings.ReplaceAll(strings.TrimPrefix(filepath.Clean(device), "/"), "/", "-"))
+}
+
+func lock(ctx context.Context, target, key string) (func(), error) {
+	sem, dir := semaphore(key)
+	select {
+	case sem <- struct{}{}:
+	default:
+		log.V(4).Infof("mutating lvm operation waits for running operation on %s", target)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if dir == "" {
+		return func() { <-sem }, nil
+	}
+	f, err := lockFile(ctx, target, filepath.Join(dir, key+".lock"))
+	if err != nil {
+		<-sem
+		return nil, err
+	}
+	return func() {
+		// flock is released once file is closed
+		_ = f.Close()
+		<-sem
+	}, nil
+}
+
+// lockFile takes exclusive flock of file at path, retrying until the process
+// holding it releases it or ctx is done
+func lockFile(ctx context.Context, target, path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("fail to create lock dir of %s: %s", target, err.Error())
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open lock file of %s: %s", target, err.Error())
+	}
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			_ = f.Close()
+			return nil, fmt.Errorf("fail to lock %s by %s: %s", target, path, err.Error())
+		}
+		if !waiting {
+			log.V(4).Infof("mutating lvm operation waits for operation of another process on %s", target)
+			waiting = true
+		}
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lockVG locks vg for operation of lvm library, which is never canceled.
+// Operation goes on unlocked if lock file of vg fails
+func lockVG(vgName string) func() {
+	unlock, err := LockVolumeGroup(context.Background(), vgName)
+	if err != nil {
+		log.Errorf("fail to lock vg %s, operation goes on unlocked: %s", vgName, err.Error())
+		return func() {}
+	}
+	return unlock
+}
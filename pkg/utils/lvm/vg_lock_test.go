@@ -0,0 +1,186 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockVolumeGroup(t *testing.T) {
+	const opDuration = 100 * time.Millisecond
+	const opsPerVG = 3
+	vgs := []string{"test-vg-a", "test-vg-b"}
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	maxRunningPerVG := map[string]int{}
+	total, maxTotal := 0, 0
+
+	var wg sync.WaitGroup
+	errs := make(chan error, opsPerVG*len(vgs))
+	start := time.Now()
+	for _, vg := range vgs {
+		for i := 0; i < opsPerVG; i++ {
+			wg.Add(1)
+			go func(vg string) {
+				defer wg.Done()
+				unlock, err := LockVolumeGroup(context.Background(), vg)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer unlock()
+				mu.Lock()
+				running[vg]++
+				total++
+				if running[vg] > maxRunningPerVG[vg] {
+					maxRunningPerVG[vg] = running[vg]
+				}
+				if total > maxTotal {
+					maxTotal = total
+				}
+				mu.Unlock()
+
+				time.Sleep(opDuration)
+
+				mu.Lock()
+				running[vg]--
+				total--
+				mu.Unlock()
+			}(vg)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(errs)
+	for err := range errs {
+		t.Errorf("LockVolumeGroup() error = %v", err)
+	}
+
+	// operations on the same vg run one at a time
+	for _, vg := range vgs {
+		if maxRunningPerVG[vg] != 1 {
+			t.Errorf("%d operations ran concurrently on vg %s, want 1", maxRunningPerVG[vg], vg)
+		}
+	}
+	// operations on different vgs run concurrently
+	if maxTotal != len(vgs) {
+		t.Errorf("%d operations ran concurrently across vgs, want %d", maxTotal, len(vgs))
+	}
+	if elapsed < opsPerVG*opDuration || elapsed > (opsPerVG+2)*opDuration {
+		t.Errorf("%d operations on %d vgs took %v, want about %v", opsPerVG*len(vgs), len(vgs), elapsed, opsPerVG*opDuration)
+	}
+}
+
+func TestLockVolumeGroup_Canceled(t *testing.T) {
+	unlock, err := LockVolumeGroup(context.Background(), "test-vg-canceled")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() error = %v", err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := LockVolumeGroup(ctx, "test-vg-canceled"); err == nil {
+		t.Errorf("LockVolumeGroup() should fail when ctx is done before vg is unlocked")
+	}
+	// empty vg is never locked
+	unlockEmpty, err := LockVolumeGroup(ctx, "")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() of empty vg error = %v", err)
+	}
+	unlockEmpty()
+}
+
+func TestLockVolumeGroup_LockFile(t *testing.T) {
+	SetLockDir(t.TempDir())
+	defer SetLockDir("")
+
+	// lock file of vg held by another process, which is another open file
+	// description of the lock file
+	unlock, err := LockVolumeGroup(context.Background(), "test-vg-file")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() error = %v", err)
+	}
+	unlock()
+	f, err := os.Open(filepath.Join(lockDir, "vg-test-vg-file.lock"))
+	if err != nil {
+		t.Fatalf("open lock file error = %v", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("flock lock file error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := LockVolumeGroup(ctx, "test-vg-file"); err == nil {
+		t.Errorf("LockVolumeGroup() should fail when lock file is held by another process until ctx is done")
+	}
+	// other vg is not affected
+	unlockOther, err := LockVolumeGroup(context.Background(), "test-vg-other")
+	if err != nil {
+		t.Fatalf("LockVolumeGroup() of other vg error = %v", err)
+	}
+	unlockOther()
+
+	released := make(chan error, 1)
+	go func() {
+		unlock, err := LockVolumeGroup(context.Background(), "test-vg-file")
+		if err == nil {
+			unlock()
+		}
+		released <- err
+	}()
+	time.Sleep(2 * lockRetryInterval)
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatalf("unlock lock file error = %v", err)
+	}
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Errorf("LockVolumeGroup() error = %v after lock file is released", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("LockVolumeGroup() is still blocked after lock file is released")
+	}
+}
+
+func TestLockDevice(t *testing.T) {
+	SetLockDir(t.TempDir())
+	defer SetLockDir("")
+
+	unlock, err := LockDevice(context.Background(), "/dev/sdb")
+	if err != nil {
+		t.Fatalf("LockDevice() error = %v", err)
+	}
+	defer unlock()
+	if _, err := os.Stat(filepath.Join(lockDir, "dev-dev-sdb.lock")); err != nil {
+		t.Errorf("lock file of device error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := LockDevice(ctx, "/dev/sdb"); err == nil {
+		t.Errorf("LockDevice() should fail when ctx is done before device is unlocked")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// LVNamePlaceholderPV is replaced by the pv name(or snapshot name for snapshot lv)
+	LVNamePlaceholderPV = "{pv}"
+	// LVNamePlaceholderPVC is replaced by the pvc name(or volumesnapshot name for snapshot lv)
+	LVNamePlaceholderPVC = "{pvc}"
+	// LVNamePlaceholderNS is replaced by the namespace of pvc(or volumesnapshot)
+	LVNamePlaceholderNS = "{ns}"
+	// DefaultLVNameTemplate keeps lv name the same as pv name
+	DefaultLVNameTemplate = LVNamePlaceholderPV
+
+	// lvm limits the length of lv name to 127 characters
+	maxLVNameLength = 127
+	// characters which never appear in kubernetes object names, used to
+	// separate two placeholders so that lv name can be resolved without ambiguity
+	lvNameSeparatorChars = "_+"
+	// kubernetes object names consist of lowercase alphanumeric characters, '-' and '.'
+	lvNamePlaceholderPattern = "([a-z0-9.-]+)"
+)
+
+var (
+	lvNameTemplateLiteralRegexp = regexp.MustCompile(`^[A-Za-z0-9_+.-]*$`)
+	lvNameTemplatePlaceholder   = regexp.MustCompile(`\{[^{}]*\}`)
+	lvNameRegexp                = regexp.MustCompile(`^[A-Za-z0-9_+.][A-Za-z0-9_+.-]*$`)
+	// see lvm2 lib/misc/lvm-string.c
+	lvNameReservedPrefixes = []string{"snapshot", "pvmove"}
+	lvNameReservedStrings  = []string{"_cdata", "_cmeta", "_corig", "_mlog", "_mimage", "_pmspare", "_rimage", "_rmeta", "_tdata", "_tmeta", "_vorigin"}
+)
+
+// ValidateLVNameTemplate checks whether template is able to render legal and
+// resolvable lv names. An empty template means DefaultLVNameTemplate.
+func ValidateLVNameTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	if strings.Count(template, LVNamePlaceholderPV) != 1 {
+		return fmt.Errorf("lv name template %q must contain %s exactly once", template, LVNamePlaceholderPV)
+	}
+	for _, placeholder := range []string{LVNamePlaceholderPVC, LVNamePlaceholderNS} {
+		if strings.Count(template, placeholder) > 1 {
+			return fmt.Errorf("lv name template %q contains %s more than once", template, placeholder)
+		}
+	}
+	for _, placeholder := range lvNameTemplatePlaceholder.FindAllString(template, -1) {
+		if placeholder != LVNamePlaceholderPV && placeholder != LVNamePlaceholderPVC && placeholder != LVNamePlaceholderNS {
+			return fmt.Errorf("lv name template %q contains unknown placeholder %s", template, placeholder)
+		}
+	}
+	literals := lvNameTemplatePlaceholder.Split(template, -1)
+	for i, literal := range literals {
+		if !lvNameTemplateLiteralRegexp.MatchString(literal) {
+			return fmt.Errorf("lv name template %q contains invalid character, valid set includes: [A-Za-z0-9_+.-]", template)
+		}
+		// literal between two placeholders
+		if i != 0 && i != len(literals)-1 && !strings.ContainsAny(literal, lvNameSeparatorChars) {
+			return fmt.Errorf("lv name template %q must separate placeholders with a string containing one of %q", template, lvNameSeparatorChars)
+		}
+	}
+	if strings.HasPrefix(template, "-") {
+		return fmt.Errorf("lv name template %q can not start with '-'", template)
+	}
+	return nil
+}
+
+// RenderLVName renders lv name from template
+func RenderLVName(template, pv, pvc, ns string) (string, error) {
+	if template == "" || template == DefaultLVNameTemplate {
+		// keep compatible with volumes created before lv name template is supported
+		return pv, nil
+	}
+	if err := ValidateLVNameTemplate(template); err != nil {
+		return "", err
+	}
+	if (strings.Contains(template, LVNamePlaceholderPVC) && pvc == "") || (strings.Contains(template, LVNamePlaceholderNS) && ns == "") {
+		return "", fmt.Errorf("lv name template %q requires pvc name and namespace, but got pvc %q, namespace %q", template, pvc, ns)
+	}
+	name := renderLVName(template, pv, pvc, ns)
+	// shorten pvc and namespace with hash suffix if lv name is too long, pv is
+	// kept as it is so that lv name is still resolvable by ParseLVName
+	for _, value := range []struct {
+		placeholder string
+		value       *string
+	}{{LVNamePlaceholderPVC, &pvc}, {LVNamePlaceholderNS, &ns}} {
+		over := len(name) - maxLVNameLength
+		if over <= 0 || !strings.Contains(template, value.placeholder) || len(*value.value) <= minShortenedNameLength {
+			continue
+		}
+		*value.value = ShortenName(*value.value, len(*value.value)-over)
+		name = renderLVName(template, pv, pvc, ns)
+	}
+	if err := validateLVName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ParseLVName resolves the value of {pv} from lv name rendered by template
+func ParseLVName(template, lvName string) (string, bool) {
+	if template == "" || template == DefaultLVNameTemplate {
+		return lvName, true
+	}
+	if ValidateLVNameTemplate(template) != nil {
+		return "", false
+	}
+	pvIndex := -1
+	pattern := "^"
+	literals := lvNameTemplatePlaceholder.Split(template, -1)
+	placeholders := lvNameTemplatePlaceholder.FindAllString(template, -1)
+	for i, literal := range literals {
+		pattern += regexp.QuoteMeta(literal)
+		if i < len(placeholders) {
+			pattern += lvNamePlaceholderPattern
+			if placeholders[i] == LVNamePlaceholderPV {
+				pvIndex = i + 1
+			}
+		}
+	}
+	pattern += "$"
+	matches := regexp.MustCompile(pattern).FindStringSubmatch(lvName)
+	if matches == nil {
+		return "", false
+	}
+	return matches[pvIndex], true
+}
+
+// GetLVNameFromCsiPV extracts lv name from open-local csi PV via
+// VolumeAttributes, pv created before lv name template is supported uses pv name
+func GetLVNameFromCsiPV(pv *corev1.PersistentVolume) string {
+	if pv.Spec.CSI != nil {
+		if v, ok := pv.Spec.CSI.VolumeAttributes[localtype.ParamLVName]; ok && v != "" {
+			return v
+		}
+	}
+	return pv.Name
+}
+
+func renderLVName(template, pv, pvc, ns string) string {
+	return strings.NewReplacer(LVNamePlaceholderPV, pv, LVNamePlaceholderPVC, pvc, LVNamePlaceholderNS, ns).Replace(template)
+}
+
+func validateLVName(name string) error {
+	if len(name) > maxLVNameLength {
+		return fmt.Errorf("lv name %s is longer than %d characters", name, maxLVNameLength)
+	}
+	if name == "." || name == ".." || !lvNameRegexp.MatchString(name) {
+		return fmt.Errorf("lv name %s contains invalid character, valid set includes: [A-Za-z0-9_+.-]", name)
+	}
+	for _, prefix := range lvNameReservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("lv name %s can not start with reserved %q", name, prefix)
+		}
+	}
+	for _, str := range lvNameReservedStrings {
+		if strings.Contains(name, str) {
+			return fmt.Errorf("lv name %s can not contain reserved %q", name, str)
+		}
+	}
+	return nil
+}
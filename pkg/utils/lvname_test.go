@@ -0,0 +1,244 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ValidateLVNameTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{
+			name:     "test empty template",
+			template: "",
+			wantErr:  false,
+		},
+		{
+			name:     "test default template",
+			template: DefaultLVNameTemplate,
+			wantErr:  false,
+		},
+		{
+			name:     "test all placeholders",
+			template: "ol_{ns}_{pvc}_{pv}",
+			wantErr:  false,
+		},
+		{
+			name:     "test template without pv",
+			template: "{ns}_{pvc}",
+			wantErr:  true,
+		},
+		{
+			name:     "test duplicated placeholder",
+			template: "{pv}_{pvc}_{pvc}",
+			wantErr:  true,
+		},
+		{
+			name:     "test unknown placeholder",
+			template: "{node}_{pv}",
+			wantErr:  true,
+		},
+		{
+			name:     "test invalid character",
+			template: "data/{pv}",
+			wantErr:  true,
+		},
+		{
+			name:     "test ambiguous separator",
+			template: "{pvc}-{pv}",
+			wantErr:  true,
+		},
+		{
+			name:     "test start with hyphen",
+			template: "-{pv}",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateLVNameTemplate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateLVNameTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_RenderLVName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		pv       string
+		pvc      string
+		ns       string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "test default template",
+			template: "",
+			pv:       "local-0f7d5c5e",
+			want:     "local-0f7d5c5e",
+			wantErr:  false,
+		},
+		{
+			name:     "test all placeholders",
+			template: "ol_{ns}_{pvc}_{pv}",
+			pv:       "local-0f7d5c5e",
+			pvc:      "html-nginx-0",
+			ns:       "default",
+			want:     "ol_default_html-nginx-0_local-0f7d5c5e",
+			wantErr:  false,
+		},
+		{
+			name:     "test missing pvc",
+			template: "{pvc}_{pv}",
+			pv:       "local-0f7d5c5e",
+			wantErr:  true,
+		},
+		{
+			name:     "test reserved prefix",
+			template: "{pvc}_{pv}",
+			pv:       "local-0f7d5c5e",
+			pvc:      "snapshot-data",
+			ns:       "default",
+			wantErr:  true,
+		},
+		{
+			name:     "test long pvc is shortened",
+			template: "{pvc}_{pv}",
+			pv:       "local-0f7d5c5e",
+			pvc:      strings.Repeat("a", 120),
+			ns:       "default",
+			want:     strings.Repeat("a", 101) + "-2f3d335432_local-0f7d5c5e",
+			wantErr:  false,
+		},
+		{
+			name:     "test too long",
+			template: "{pvc}_{pv}",
+			pv:       "local-" + strings.Repeat("0", 120),
+			pvc:      "html-nginx-0",
+			ns:       "default",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderLVName(tt.template, tt.pv, tt.pvc, tt.ns)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RenderLVName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RenderLVName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseLVName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		lvName   string
+		want     string
+		wantOK   bool
+	}{
+		{
+			name:     "test default template",
+			template: "",
+			lvName:   "local-0f7d5c5e",
+			want:     "local-0f7d5c5e",
+			wantOK:   true,
+		},
+		{
+			name:     "test pv at the end",
+			template: "ol_{ns}_{pvc}_{pv}",
+			lvName:   "ol_default_html-nginx-0_local-0f7d5c5e",
+			want:     "local-0f7d5c5e",
+			wantOK:   true,
+		},
+		{
+			name:     "test pv in the middle",
+			template: "{ns}+{pv}+{pvc}.data",
+			lvName:   "kube-system+local-0f7d5c5e+html-nginx-0.data",
+			want:     "local-0f7d5c5e",
+			wantOK:   true,
+		},
+		{
+			name:     "test lv not rendered by template",
+			template: "ol_{ns}_{pvc}_{pv}",
+			lvName:   "local-0f7d5c5e",
+			want:     "",
+			wantOK:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLVName(tt.template, tt.lvName)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ParseLVName() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_RenderAndParseLVName(t *testing.T) {
+	template := "{ns}_{pvc}_{pv}"
+	pvs := []string{"local-0f7d5c5e", "snap-1.2-3"}
+	for _, pv := range pvs {
+		lvName, err := RenderLVName(template, pv, "a-b.c", "d-e")
+		if err != nil {
+			t.Fatalf("RenderLVName() error = %v", err)
+		}
+		if got, ok := ParseLVName(template, lvName); !ok || got != pv {
+			t.Errorf("ParseLVName(%s) = %v, %v, want %v", lvName, got, ok, pv)
+		}
+	}
+}
+
+func Test_RenderLVName_LongNamesNotCollide(t *testing.T) {
+	template := "{ns}_{pvc}_{pv}"
+	prefix := strings.Repeat("data-elasticsearch-cluster-", 5)
+	// naive truncation to 127 characters cuts off the different tail
+	pvcs := []string{prefix + "master-0", prefix + "master-1"}
+	names := map[string]string{}
+	for _, pvc := range pvcs {
+		name, err := RenderLVName(template, "local-0f7d5c5e", pvc, "default")
+		if err != nil {
+			t.Fatalf("RenderLVName() of pvc %s error = %v", pvc, err)
+		}
+		if len(name) > maxLVNameLength {
+			t.Errorf("RenderLVName() of pvc %s = %s, longer than %d", pvc, name, maxLVNameLength)
+		}
+		if other, exist := names[name]; exist {
+			t.Errorf("RenderLVName() of pvc %s and %s are the same %s", pvc, other, name)
+		}
+		names[name] = pvc
+		// deterministic
+		if again, _ := RenderLVName(template, "local-0f7d5c5e", pvc, "default"); again != name {
+			t.Errorf("RenderLVName() of pvc %s = %s, then %s", pvc, name, again)
+		}
+		if pv, ok := ParseLVName(template, name); !ok || pv != "local-0f7d5c5e" {
+			t.Errorf("ParseLVName(%s) = %s, %t", name, pv, ok)
+		}
+	}
+}
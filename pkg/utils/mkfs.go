@@ -0,0 +1,50 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+// maxReservedBlocksPercent is the largest reserved blocks percentage mkfs.ext4 accepts
+const maxReservedBlocksPercent = 50
+
+// GetReservedBlocksPercent returns reserved blocks percentage of ext4 set by
+// ParamReservedBlocksPercent in params, empty if it is not set
+func GetReservedBlocksPercent(params map[string]string) (string, error) {
+	value, ok := LookupParam(params, localtype.ParamReservedBlocksPercent)
+	if !ok || value == "" {
+		return "", nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > maxReservedBlocksPercent {
+		return "", fmt.Errorf("%s must be a number between 0 and %d, got %q", localtype.ParamReservedBlocksPercent, maxReservedBlocksPercent, value)
+	}
+	return strconv.FormatFloat(percent, 'f', -1, 64), nil
+}
+
+// MkfsReservedBlocksArgs returns mkfs argument of reserved blocks percentage,
+// nil if fsType is not ext4 or percent is empty
+func MkfsReservedBlocksArgs(fsType, percent string) []string {
+	if fsType != localtype.VolumeFSTypeExt4 || percent == "" {
+		return nil
+	}
+	return []string{"-m" + percent}
+}
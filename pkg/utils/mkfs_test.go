@@ -0,0 +1,72 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+func Test_GetReservedBlocksPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "test unset", params: map[string]string{}, want: ""},
+		{name: "test zero", params: map[string]string{localtype.ParamReservedBlocksPercent: "0"}, want: "0"},
+		{name: "test fractional percent", params: map[string]string{localtype.ParamReservedBlocksPercent: "0.50"}, want: "0.5"},
+		{name: "test negative percent", params: map[string]string{localtype.ParamReservedBlocksPercent: "-1"}, wantErr: true},
+		{name: "test percent over 50", params: map[string]string{localtype.ParamReservedBlocksPercent: "51"}, wantErr: true},
+		{name: "test not a number", params: map[string]string{localtype.ParamReservedBlocksPercent: "1%"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetReservedBlocksPercent(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetReservedBlocksPercent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetReservedBlocksPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MkfsReservedBlocksArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsType  string
+		percent string
+		want    []string
+	}{
+		{name: "test ext4", fsType: "ext4", percent: "1", want: []string{"-m1"}},
+		{name: "test ext4 unset", fsType: "ext4", percent: "", want: nil},
+		{name: "test xfs ignored", fsType: "xfs", percent: "1", want: nil},
+		{name: "test ext3 ignored", fsType: "ext3", percent: "1", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MkfsReservedBlocksArgs(tt.fsType, tt.percent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MkfsReservedBlocksArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
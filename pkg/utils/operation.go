@@ -0,0 +1,147 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// types of long operation
+	OperationTypeClone  = "clone"
+	OperationTypeFormat = "format"
+	OperationTypeZero   = "zero"
+	OperationTypeWipe   = "wipe"
+	// periodic work of agent, tracked to be waited for on shutdown
+	OperationTypeDiscovery         = "discovery"
+	OperationTypeSnapshotExpansion = "snapshot-expansion"
+	OperationTypeStatusDriftCheck  = "status-drift-check"
+	OperationTypeLVActivation      = "lv-activation"
+	OperationTypeInitResource      = "init-resource"
+)
+
+var (
+	ErrOperationNotFound       = errors.New("operation not found")
+	ErrOperationNotCancellable = errors.New("operation is not cancellable")
+)
+
+// LongOperations is the tracker of long operations running in the process
+var LongOperations = NewOperationTracker()
+
+// LongOperation is a snapshot of a running long operation
+type LongOperation struct {
+	ID        string
+	Type      string
+	VG        string
+	LV        string
+	StartTime time.Time
+	// Progress is empty if unknown
+	Progress    string
+	Cancellable bool
+}
+
+type trackedOperation struct {
+	op       LongOperation
+	cancel   func()
+	progress func() string
+}
+
+// OperationTracker tracks long operations, such as copying data of clone and
+// formatting large volume, so that they can be listed and cancelled
+type OperationTracker struct {
+	lock sync.Mutex
+	seq  uint64
+	ops  map[string]*trackedOperation
+}
+
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{ops: map[string]*trackedOperation{}}
+}
+
+// Register registers a running operation on lv of vg. cancel is nil if the
+// operation is not cancellable and progress is nil if unknown. The returned
+// function deregisters the operation and must be called when it ends
+func (t *OperationTracker) Register(opType, vg, lv string, cancel func(), progress func() string) (string, func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.seq++
+	id := fmt.Sprintf("%s-%d", opType, t.seq)
+	t.ops[id] = &trackedOperation{
+		op: LongOperation{
+			ID:          id,
+			Type:        opType,
+			VG:          vg,
+			LV:          lv,
+			StartTime:   time.Now(),
+			Cancellable: cancel != nil,
+		},
+		cancel:   cancel,
+		progress: progress,
+	}
+	return id, func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		delete(t.ops, id)
+	}
+}
+
+// List returns running operations ordered by start time
+func (t *OperationTracker) List() []LongOperation {
+	t.lock.Lock()
+	tracked := make([]*trackedOperation, 0, len(t.ops))
+	for _, op := range t.ops {
+		tracked = append(tracked, op)
+	}
+	t.lock.Unlock()
+
+	ops := make([]LongOperation, 0, len(tracked))
+	for _, op := range tracked {
+		// progress may run command, it is called without lock
+		snapshot := op.op
+		if op.progress != nil {
+			snapshot.Progress = op.progress()
+		}
+		ops = append(ops, snapshot)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].StartTime.Equal(ops[j].StartTime) {
+			return ops[i].ID < ops[j].ID
+		}
+		return ops[i].StartTime.Before(ops[j].StartTime)
+	})
+	return ops
+}
+
+// Cancel cancels the running operation of id, the operation deregisters
+// itself once it exits
+func (t *OperationTracker) Cancel(id string) error {
+	t.lock.Lock()
+	op, ok := t.ops[id]
+	t.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrOperationNotFound, id)
+	}
+	if op.cancel == nil {
+		return fmt.Errorf("%w: %s", ErrOperationNotCancellable, id)
+	}
+	op.cancel()
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// OperationIDMetadataKey carries operation id from csi plugin to lvmd
+const OperationIDMetadataKey = "x-open-local-operation-id"
+
+type operationIDKey struct{}
+
+// WithOperationID returns ctx carrying operation id, empty id keeps ctx as is
+func WithOperationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// OperationIDFromContext returns operation id in ctx, empty if none
+func OperationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(operationIDKey{}).(string)
+	return id
+}
+
+// OperationLogKeys prepends operation id in ctx to keys and values of
+// structured log, so that all log lines of an operation can be grepped by it
+func OperationLogKeys(ctx context.Context, keys ...interface{}) []interface{} {
+	id := OperationIDFromContext(ctx)
+	if id == "" {
+		return keys
+	}
+	return append([]interface{}{LogKeyOperationID, id}, keys...)
+}
+
+// InjectOperationID copies operation id in ctx into outgoing grpc metadata
+func InjectOperationID(ctx context.Context) context.Context {
+	id := OperationIDFromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, OperationIDMetadataKey, id)
+}
+
+// ExtractOperationID returns ctx carrying operation id of incoming grpc
+// metadata if present
+func ExtractOperationID(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if values := md.Get(OperationIDMetadataKey); len(values) > 0 {
+		return WithOperationID(ctx, values[0])
+	}
+	return ctx
+}
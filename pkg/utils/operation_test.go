@@ -0,0 +1,43 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_RunContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	// children of sh must be killed as well, or output is never closed
+	_, err := RunContext(ctx, "sleep 10 | cat")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunContext() returned after %v, want it killed at once", elapsed)
+	}
+
+	out, err := RunContext(context.Background(), "echo done")
+	if err != nil || out != "done\n" {
+		t.Errorf("RunContext() = %q, %v, want %q, nil", out, err, "done\n")
+	}
+}
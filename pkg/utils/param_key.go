@@ -0,0 +1,63 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+	"sync"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	log "k8s.io/klog/v2"
+)
+
+// deprecatedKeysLogged records legacy keys which are logged as deprecated,
+// every key is logged once to keep periodic checks quiet
+var deprecatedKeysLogged sync.Map
+
+// LegacyParamKey returns key in the legacy domain, empty if key is not in the
+// domain of open-local
+func LegacyParamKey(key string) string {
+	if !strings.HasPrefix(key, localtype.ParamKeyPrefix) {
+		return ""
+	}
+	return localtype.LegacyParamKeyPrefix + strings.TrimPrefix(key, localtype.ParamKeyPrefix)
+}
+
+// LookupParam looks up key of parameters, volume attributes or annotations.
+// Key in the legacy domain is honored if key itself is absent
+func LookupParam(params map[string]string, key string) (string, bool) {
+	if value, exist := params[key]; exist {
+		return value, true
+	}
+	legacyKey := LegacyParamKey(key)
+	if legacyKey == "" {
+		return "", false
+	}
+	value, exist := params[legacyKey]
+	if exist {
+		if _, logged := deprecatedKeysLogged.LoadOrStore(legacyKey, true); !logged {
+			log.Warningf("key %s is deprecated, use %s instead", legacyKey, key)
+		}
+	}
+	return value, exist
+}
+
+// GetParam returns value of key like LookupParam, empty if it is absent
+func GetParam(params map[string]string, key string) string {
+	value, _ := LookupParam(params, key)
+	return value
+}
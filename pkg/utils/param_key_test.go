@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+func Test_LookupParam(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    map[string]string
+		key       string
+		want      string
+		wantExist bool
+	}{
+		{
+			name:      "test key",
+			params:    map[string]string{"csi.aliyun.com/readonly": "true"},
+			key:       localtype.ParamReadonly,
+			want:      "true",
+			wantExist: true,
+		},
+		{
+			name:      "test legacy key",
+			params:    map[string]string{"storage.oecp.io/readonly": "true"},
+			key:       localtype.ParamReadonly,
+			want:      "true",
+			wantExist: true,
+		},
+		{
+			name:      "test key takes precedence over legacy key",
+			params:    map[string]string{"csi.aliyun.com/readonly": "false", "storage.oecp.io/readonly": "true"},
+			key:       localtype.ParamReadonly,
+			want:      "false",
+			wantExist: true,
+		},
+		{
+			name:      "test absent",
+			params:    map[string]string{"storage.oecp.io/snapshot-id": "snap"},
+			key:       localtype.ParamReadonly,
+			wantExist: false,
+		},
+		{
+			name:      "test key out of domain has no legacy key",
+			params:    map[string]string{"storage.oecp.io/vgName": "vg"},
+			key:       localtype.ParamVGName,
+			wantExist: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exist := LookupParam(tt.params, tt.key)
+			if got != tt.want || exist != tt.wantExist {
+				t.Errorf("LookupParam() = %q, %v, want %q, %v", got, exist, tt.want, tt.wantExist)
+			}
+			if got := GetParam(tt.params, tt.key); got != tt.want {
+				t.Errorf("GetParam() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
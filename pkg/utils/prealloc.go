@@ -0,0 +1,89 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	log "k8s.io/klog/v2"
+)
+
+// PreallocatedLVs is the number and total size of unassigned preallocated
+// lvs in a vg
+type PreallocatedLVs struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// IsUnassignedPreallocatedLV returns true if lv of tags is preallocated and
+// not yet taken by any volume
+func IsUnassignedPreallocatedLV(tags []string) bool {
+	preallocated := false
+	for _, tag := range tags {
+		if tag == localtype.ManagedLVTag {
+			return false
+		}
+		if strings.HasPrefix(tag, localtype.PreallocatedLVTagPrefix) {
+			preallocated = true
+		}
+	}
+	return preallocated
+}
+
+// GetPreallocatedLVs returns unassigned preallocated lvs of every vg recorded
+// in annotation of nls, invalid annotation is ignored
+func GetPreallocatedLVs(nls *nodelocalstorage.NodeLocalStorage) map[string]PreallocatedLVs {
+	if nls == nil || nls.Annotations[localtype.AnnoPreallocatedLVs] == "" {
+		return nil
+	}
+	vgs := map[string]PreallocatedLVs{}
+	if err := json.Unmarshal([]byte(nls.Annotations[localtype.AnnoPreallocatedLVs]), &vgs); err != nil {
+		log.Warningf("invalid annotation %s of nls %s: %s", localtype.AnnoPreallocatedLVs, nls.Name, err.Error())
+		return nil
+	}
+	return vgs
+}
+
+// SetPreallocatedLVs records unassigned preallocated lvs of vg in annotation
+// of nls, it returns false if the annotation is left unchanged
+func SetPreallocatedLVs(nls *nodelocalstorage.NodeLocalStorage, vg string, lvs PreallocatedLVs) bool {
+	vgs := GetPreallocatedLVs(nls)
+	if vgs == nil {
+		vgs = map[string]PreallocatedLVs{}
+	}
+	if old, ok := vgs[vg]; ok && old == lvs || !ok && lvs.Count == 0 {
+		return false
+	}
+	if lvs.Count == 0 {
+		delete(vgs, vg)
+	} else {
+		vgs[vg] = lvs
+	}
+	if len(vgs) == 0 {
+		delete(nls.Annotations, localtype.AnnoPreallocatedLVs)
+		return true
+	}
+	data, _ := json.Marshal(vgs)
+	if nls.Annotations == nil {
+		nls.Annotations = map[string]string{}
+	}
+	nls.Annotations[localtype.AnnoPreallocatedLVs] = string(data)
+	return true
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func Test_IsUnassignedPreallocatedLV(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{tags: []string{localtype.PreallocatedLVTagPrefix + "1024"}, want: true},
+		{tags: []string{localtype.PreallocatedLVTagPrefix + "1024", localtype.ManagedLVTag}, want: false},
+		{tags: []string{localtype.ManagedLVTag}, want: false},
+		{tags: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := IsUnassignedPreallocatedLV(tt.tags); got != tt.want {
+			t.Errorf("IsUnassignedPreallocatedLV(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func Test_SetPreallocatedLVs(t *testing.T) {
+	nls := &nodelocalstorage.NodeLocalStorage{}
+	if !SetPreallocatedLVs(nls, "pool-0", PreallocatedLVs{Count: 2, Size: 2048}) {
+		t.Fatalf("SetPreallocatedLVs() of new vg = false, want true")
+	}
+	if SetPreallocatedLVs(nls, "pool-0", PreallocatedLVs{Count: 2, Size: 2048}) {
+		t.Errorf("SetPreallocatedLVs() of unchanged vg = true, want false")
+	}
+	SetPreallocatedLVs(nls, "pool-1", PreallocatedLVs{Count: 1, Size: 1024})
+	want := map[string]PreallocatedLVs{"pool-0": {Count: 2, Size: 2048}, "pool-1": {Count: 1, Size: 1024}}
+	if got := GetPreallocatedLVs(nls); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPreallocatedLVs() = %v, want %v", got, want)
+	}
+	SetPreallocatedLVs(nls, "pool-0", PreallocatedLVs{})
+	SetPreallocatedLVs(nls, "pool-1", PreallocatedLVs{})
+	if _, ok := nls.Annotations[localtype.AnnoPreallocatedLVs]; ok {
+		t.Errorf("annotation %s of empty pools is kept", localtype.AnnoPreallocatedLVs)
+	}
+	if SetPreallocatedLVs(nls, "pool-0", PreallocatedLVs{}) {
+		t.Errorf("SetPreallocatedLVs() of empty vg = true, want false")
+	}
+
+	nls.Annotations = map[string]string{localtype.AnnoPreallocatedLVs: "invalid"}
+	if got := GetPreallocatedLVs(nls); got != nil {
+		t.Errorf("GetPreallocatedLVs() of invalid annotation = %v, want nil", got)
+	}
+}
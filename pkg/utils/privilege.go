@@ -0,0 +1,80 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	procSelfStatus = "/proc/self/status"
+	// DeviceMapperControl is the control device every lvm and dm operation
+	// opens, which is absent in container not privileged
+	DeviceMapperControl = "/dev/mapper/control"
+	// capSysAdmin is bit of CAP_SYS_ADMIN in capability sets, needed by
+	// mount, nsenter and device-mapper ioctl
+	capSysAdmin = 21
+)
+
+// MissingPrivileges returns privileges lacked by the process to run lvm and
+// mount operations on node, empty if all are granted. Privilege failed to be
+// checked is not reported as missing
+func MissingPrivileges() []string {
+	return missingPrivileges(procSelfStatus, DeviceMapperControl)
+}
+
+func missingPrivileges(statusPath, dmControl string) []string {
+	var missing []string
+	if capEff, err := readEffectiveCapabilities(statusPath); err != nil {
+		log.Warningf("fail to read capabilities of process, skip checking: %s", err.Error())
+	} else if capEff&(1<<capSysAdmin) == 0 {
+		missing = append(missing, "CAP_SYS_ADMIN")
+	}
+	if f, err := os.OpenFile(dmControl, os.O_RDWR, 0); err != nil {
+		missing = append(missing, fmt.Sprintf("access to %s", dmControl))
+	} else {
+		f.Close()
+	}
+	return missing
+}
+
+// readEffectiveCapabilities reads CapEff of status file of process
+func readEffectiveCapabilities(statusPath string) (uint64, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in %s", statusPath)
+}
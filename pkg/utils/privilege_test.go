@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_missingPrivileges(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		noDM    bool
+		want    []string
+		wantDev bool
+	}{
+		{
+			name:   "test privileged",
+			status: "Name:\tagent\nCapEff:\t000001ffffffffff\n",
+		},
+		{
+			name:   "test without CAP_SYS_ADMIN",
+			status: "Name:\tagent\nCapEff:\t00000000a80425fb\n",
+			want:   []string{"CAP_SYS_ADMIN"},
+		},
+		{
+			name:    "test without device-mapper control",
+			status:  "Name:\tagent\nCapEff:\t000001ffffffffff\n",
+			noDM:    true,
+			wantDev: true,
+		},
+		{
+			name:   "test capabilities unknown",
+			status: "Name:\tagent\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			statusPath := filepath.Join(dir, "status")
+			if err := os.WriteFile(statusPath, []byte(tt.status), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dmControl := filepath.Join(dir, "control")
+			if !tt.noDM {
+				if err := os.WriteFile(dmControl, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := tt.want
+			if tt.wantDev {
+				want = append(want, "access to "+dmControl)
+			}
+			if got := missingPrivileges(statusPath, dmControl); !reflect.DeepEqual(got, want) {
+				t.Errorf("missingPrivileges() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+
+	"github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ParseReplicaAffinity validates weight of replica affinity, which is in the
+// same range as node anti-affinity so that neither dominates the other
+func ParseReplicaAffinity(weight int, label string) (pkg.ReplicaAffinity, error) {
+	if weight < MinScore || weight > MaxScore {
+		return pkg.ReplicaAffinity{}, fmt.Errorf("replica affinity weight is out-of-range [%d, %d], current value is %d", MinScore, MaxScore, weight)
+	}
+	return pkg.ReplicaAffinity{Weight: weight, Label: label}, nil
+}
+
+// IsReplicaSibling returns true if other is another live replica of the
+// workload of pod, matched by label if pod has it, otherwise by controller
+func IsReplicaSibling(pod, other *corev1.Pod, label string) bool {
+	if other.UID == pod.UID || other.Namespace != pod.Namespace {
+		return false
+	}
+	if other.DeletionTimestamp != nil || other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if label != "" {
+		if value, ok := pod.Labels[label]; ok {
+			otherValue, otherOk := other.Labels[label]
+			return otherOk && otherValue == value
+		}
+	}
+	owner := metav1.GetControllerOf(pod)
+	otherOwner := metav1.GetControllerOf(other)
+	return owner != nil && otherOwner != nil && owner.UID == otherOwner.UID
+}
+
+// ReplicaAffinityScore returns weight of affinity if any of pods is a replica
+// sibling of pod running on node, it never filters out a node
+func ReplicaAffinityScore(affinity pkg.ReplicaAffinity, pod *corev1.Pod, pods []*corev1.Pod, nodeName string) int {
+	if !affinity.Enabled() {
+		return MinScore
+	}
+	for _, other := range pods {
+		if other.Spec.NodeName == nodeName && IsReplicaSibling(pod, other, affinity.Label) {
+			return affinity.Weight
+		}
+	}
+	return MinScore
+}
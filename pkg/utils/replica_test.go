@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func replicaPod(name, nodeName, ownerUID string, labels map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Labels:    labels,
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if ownerUID != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: ownerUID, UID: types.UID(ownerUID), Controller: &controller}}
+	}
+	return pod
+}
+
+func Test_IsReplicaSibling(t *testing.T) {
+	pod := replicaPod("db-2", "", "db", map[string]string{"app": "db"})
+	deleting := replicaPod("db-3", "node1", "db", nil)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	failed := replicaPod("db-4", "node1", "db", nil)
+	failed.Status.Phase = corev1.PodFailed
+	otherNamespace := replicaPod("db-5", "node1", "db", nil)
+	otherNamespace.Namespace = "other"
+
+	tests := []struct {
+		name  string
+		pod   *corev1.Pod
+		other *corev1.Pod
+		label string
+		want  bool
+	}{
+		{name: "same controller", pod: pod, other: replicaPod("db-0", "node1", "db", nil), want: true},
+		{name: "other controller", pod: pod, other: replicaPod("web-0", "node1", "web", nil), want: false},
+		{name: "no controller", pod: replicaPod("job", "", "", nil), other: replicaPod("job-1", "node1", "", nil), want: false},
+		{name: "itself", pod: pod, other: pod, want: false},
+		{name: "deleting", pod: pod, other: deleting, want: false},
+		{name: "failed", pod: pod, other: failed, want: false},
+		{name: "other namespace", pod: pod, other: otherNamespace, want: false},
+		{name: "same label", pod: pod, other: replicaPod("db-1", "node1", "", map[string]string{"app": "db"}), label: "app", want: true},
+		{name: "label overrides controller", pod: pod, other: replicaPod("db-1", "node1", "db", map[string]string{"app": "cache"}), label: "app", want: false},
+		{name: "pod without label", pod: replicaPod("db-6", "", "db", nil), other: replicaPod("db-0", "node1", "db", nil), label: "app", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsReplicaSibling(tt.pod, tt.other, tt.label); got != tt.want {
+				t.Errorf("IsReplicaSibling() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ReplicaAffinityScore(t *testing.T) {
+	pod := replicaPod("db-2", "", "db", nil)
+	pods := []*corev1.Pod{
+		replicaPod("db-0", "node1", "db", nil),
+		replicaPod("web-0", "node2", "web", nil),
+	}
+	tests := []struct {
+		name     string
+		affinity pkg.ReplicaAffinity
+		nodeName string
+		want     int
+	}{
+		{name: "node with sibling", affinity: pkg.ReplicaAffinity{Weight: 5}, nodeName: "node1", want: 5},
+		{name: "node without sibling", affinity: pkg.ReplicaAffinity{Weight: 5}, nodeName: "node2", want: MinScore},
+		{name: "empty node", affinity: pkg.ReplicaAffinity{Weight: 5}, nodeName: "node3", want: MinScore},
+		{name: "disabled", affinity: pkg.ReplicaAffinity{}, nodeName: "node1", want: MinScore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReplicaAffinityScore(tt.affinity, pod, pods, tt.nodeName); got != tt.want {
+				t.Errorf("ReplicaAffinityScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseReplicaAffinity(t *testing.T) {
+	if _, err := ParseReplicaAffinity(MaxScore+1, ""); err == nil {
+		t.Errorf("ParseReplicaAffinity() with weight %d should fail", MaxScore+1)
+	}
+	if _, err := ParseReplicaAffinity(-1, ""); err == nil {
+		t.Errorf("ParseReplicaAffinity() with weight -1 should fail")
+	}
+	got, err := ParseReplicaAffinity(3, "app")
+	if err != nil {
+		t.Fatalf("ParseReplicaAffinity() error: %s", err.Error())
+	}
+	if want := (pkg.ReplicaAffinity{Weight: 3, Label: "app"}); got != want {
+		t.Errorf("ParseReplicaAffinity() = %+v, want %+v", got, want)
+	}
+}
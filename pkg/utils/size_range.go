@@ -0,0 +1,51 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// GetVolumeSizeRange returns minSize and maxSize of storage class in bytes,
+// 0 means unrestricted
+func GetVolumeSizeRange(params map[string]string) (minSize, maxSize int64, err error) {
+	if minSize, err = parseVolumeSizeLimit(params, localtype.VolumeMinSize); err != nil {
+		return 0, 0, err
+	}
+	if maxSize, err = parseVolumeSizeLimit(params, localtype.VolumeMaxSize); err != nil {
+		return 0, 0, err
+	}
+	if minSize > 0 && maxSize > 0 && minSize > maxSize {
+		return 0, 0, fmt.Errorf("%s %s is larger than %s %s", localtype.VolumeMinSize, params[localtype.VolumeMinSize], localtype.VolumeMaxSize, params[localtype.VolumeMaxSize])
+	}
+	return minSize, maxSize, nil
+}
+
+func parseVolumeSizeLimit(params map[string]string, key string) (int64, error) {
+	value, ok := params[key]
+	if !ok || value == "" {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.Sign() < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative quantity, e.g. 10Gi, got %q", key, value)
+	}
+	return quantity.Value(), nil
+}
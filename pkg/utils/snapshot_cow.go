@@ -0,0 +1,44 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+// GetSnapshotCOWPVs returns pvs holding cow data of snapshot set by
+// ParamSnapshotCOWPVs in params, nil means anywhere in vg of origin lv
+func GetSnapshotCOWPVs(params map[string]string) ([]string, error) {
+	value, ok := LookupParam(params, localtype.ParamSnapshotCOWPVs)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var pvs []string
+	for _, pv := range strings.Split(value, ",") {
+		pv = strings.TrimSpace(pv)
+		if !strings.HasPrefix(pv, "/dev/") || strings.ContainsAny(pv, " \t;&|$`'\"") {
+			return nil, fmt.Errorf("%s must be device paths of pvs separated by comma, got %q", localtype.ParamSnapshotCOWPVs, value)
+		}
+		if !ContainsString(pvs, pv) {
+			pvs = append(pvs, pv)
+		}
+	}
+	return pvs, nil
+}
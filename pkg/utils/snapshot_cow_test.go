@@ -0,0 +1,66 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+)
+
+func Test_GetSnapshotCOWPVs(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "test unset",
+			params: map[string]string{},
+			want:   nil,
+		},
+		{
+			name:   "test pvs",
+			params: map[string]string{localtype.ParamSnapshotCOWPVs: "/dev/nvme1n1, /dev/nvme2n1,/dev/nvme1n1"},
+			want:   []string{"/dev/nvme1n1", "/dev/nvme2n1"},
+		},
+		{
+			name:    "test not device path",
+			params:  map[string]string{localtype.ParamSnapshotCOWPVs: "open-local-pool-1"},
+			wantErr: true,
+		},
+		{
+			name:    "test shell character",
+			params:  map[string]string{localtype.ParamSnapshotCOWPVs: "/dev/sdb;reboot"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetSnapshotCOWPVs(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetSnapshotCOWPVs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetSnapshotCOWPVs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
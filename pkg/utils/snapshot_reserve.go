@@ -0,0 +1,102 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	log "k8s.io/klog/v2"
+)
+
+// GetSnapshotReserveSize returns bytes of snapshot headroom reserved for a
+// volume of size according to ParamSnapshotReservePercent in params
+func GetSnapshotReserveSize(size int64, params map[string]string) (int64, error) {
+	value, ok := LookupParam(params, localtype.ParamSnapshotReservePercent)
+	if !ok || value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("%s must be a number between 0 and 100, got %q", localtype.ParamSnapshotReservePercent, value)
+	}
+	return int64(float64(size) * percent / 100), nil
+}
+
+// GetExpansionSnapshotSize returns bytes of cow space of the snapshot taken
+// before filesystem of a volume of size is grown, according to
+// ParamExpansionSnapshotPercent in params
+func GetExpansionSnapshotSize(size int64, params map[string]string) (int64, error) {
+	value, ok := LookupParam(params, localtype.ParamExpansionSnapshotPercent)
+	if !ok || value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("%s must be a number between 0 and 100, got %q", localtype.ParamExpansionSnapshotPercent, value)
+	}
+	return int64(float64(size) * percent / 100), nil
+}
+
+// GetLVMPVCAllocatedSize returns size a lvm pvc allocates from vg, that is the
+// requested size plus snapshot headroom set by its storage class
+func GetLVMPVCAllocatedSize(pvc *corev1.PersistentVolumeClaim, scLister storagelisters.StorageClassLister) (int64, error) {
+	requested := GetPVCRequested(pvc)
+	sc, err := GetStorageClassFromPVC(pvc, scLister)
+	if err != nil {
+		return 0, err
+	}
+	if sc == nil {
+		return requested, nil
+	}
+	reserved, err := GetSnapshotReserveSize(requested, sc.Parameters)
+	if err != nil {
+		return 0, fmt.Errorf("storage class %s: %s", sc.Name, err.Error())
+	}
+	return requested + reserved, nil
+}
+
+// GetSnapshotReservedSizeFromCsiPV extracts snapshot headroom recorded in
+// open-local csi PV via VolumeAttributes
+func GetSnapshotReservedSizeFromCsiPV(pv *corev1.PersistentVolume) int64 {
+	if pv.Spec.CSI == nil {
+		return 0
+	}
+	value, ok := LookupParam(pv.Spec.CSI.VolumeAttributes, localtype.ParamSnapshotReservedSize)
+	if !ok {
+		return 0
+	}
+	reserved, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || reserved < 0 {
+		log.Warningf("PV %s has invalid %s %q, ignored", pv.Name, localtype.ParamSnapshotReservedSize, value)
+		return 0
+	}
+	return reserved
+}
+
+// GetLVMPVAllocatedSize returns size a lvm PV allocates from vg, that is the
+// capacity plus snapshot headroom reserved when provisioning
+func GetLVMPVAllocatedSize(pv *corev1.PersistentVolume) int64 {
+	var capacity int64
+	if v, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+		capacity = v.Value()
+	}
+	return capacity + GetSnapshotReservedSizeFromCsiPV(pv)
+}
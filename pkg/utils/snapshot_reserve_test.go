@@ -0,0 +1,110 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_GetSnapshotReserveSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent string
+		want    int64
+		wantErr bool
+	}{
+		{
+			name:    "test no headroom",
+			percent: "",
+			want:    0,
+			wantErr: false,
+		},
+		{
+			name:    "test integer percent",
+			percent: "20",
+			want:    200,
+			wantErr: false,
+		},
+		{
+			name:    "test fractional percent",
+			percent: "12.5",
+			want:    125,
+			wantErr: false,
+		},
+		{
+			name:    "test negative percent",
+			percent: "-1",
+			wantErr: true,
+		},
+		{
+			name:    "test percent over 100",
+			percent: "101",
+			wantErr: true,
+		},
+		{
+			name:    "test invalid percent",
+			percent: "20%",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{}
+			if tt.percent != "" {
+				params[localtype.ParamSnapshotReservePercent] = tt.percent
+			}
+			got, err := GetSnapshotReserveSize(1000, params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetSnapshotReserveSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetSnapshotReserveSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_GetLVMPVAllocatedSize(t *testing.T) {
+	pv := &corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("1Ki"),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					VolumeAttributes: map[string]string{},
+				},
+			},
+		},
+	}
+	if got := GetLVMPVAllocatedSize(pv); got != 1024 {
+		t.Errorf("GetLVMPVAllocatedSize() = %v, want %v", got, 1024)
+	}
+	pv.Spec.CSI.VolumeAttributes[localtype.ParamSnapshotReservedSize] = "256"
+	if got := GetLVMPVAllocatedSize(pv); got != 1280 {
+		t.Errorf("GetLVMPVAllocatedSize() = %v, want %v", got, 1280)
+	}
+	pv.Spec.CSI.VolumeAttributes[localtype.ParamSnapshotReservedSize] = "invalid"
+	if got := GetLVMPVAllocatedSize(pv); got != 1024 {
+		t.Errorf("GetLVMPVAllocatedSize() = %v, want %v", got, 1024)
+	}
+}
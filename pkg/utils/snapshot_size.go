@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	units "github.com/docker/go-units"
+)
+
+// SnapshotSize is size of snapshot lv, either in bytes or in percentage of
+// size of its origin lv
+type SnapshotSize struct {
+	Bytes   uint64
+	Percent float64
+}
+
+// ParseSnapshotSize parses absolute size such as 4Gi, or percentage of origin
+// size such as 20%. Percentage must be in (0, 100] and both forms must not be
+// mixed in one value
+func ParseSnapshotSize(value string) (SnapshotSize, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		number := strings.TrimSpace(strings.TrimSuffix(value, "%"))
+		percent, err := strconv.ParseFloat(number, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return SnapshotSize{}, fmt.Errorf("percentage of origin size must be a number in (0, 100], got %q", value)
+		}
+		return SnapshotSize{Percent: percent}, nil
+	}
+	if strings.Contains(value, "%") {
+		return SnapshotSize{}, fmt.Errorf("size must be either absolute bytes or percentage of origin size, got %q", value)
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return SnapshotSize{}, err
+	}
+	if size <= 0 {
+		return SnapshotSize{}, fmt.Errorf("size must be positive, got %q", value)
+	}
+	return SnapshotSize{Bytes: uint64(size)}, nil
+}
+
+// IsPercent returns true if size is percentage of origin size
+func (s SnapshotSize) IsPercent() bool {
+	return s.Percent > 0
+}
+
+// Resolve returns bytes of size for origin lv of originSize bytes
+func (s SnapshotSize) Resolve(originSize uint64) (uint64, error) {
+	if !s.IsPercent() {
+		return s.Bytes, nil
+	}
+	if originSize == 0 {
+		return 0, fmt.Errorf("origin size is unknown, can not resolve %g%% of it", s.Percent)
+	}
+	size := uint64(float64(originSize) * s.Percent / 100)
+	if size == 0 {
+		// lvm rounds it up to extent
+		size = 1
+	}
+	return size, nil
+}
+
+func (s SnapshotSize) String() string {
+	if s.IsPercent() {
+		return fmt.Sprintf("%g%%", s.Percent)
+	}
+	return strconv.FormatUint(s.Bytes, 10)
+}
+
+// SnapshotCapacityLimit resolves maxSnapshotSize of node, absolute or
+// percentage of total size of vgs, limited is false if it is not set
+func SnapshotCapacityLimit(maxSnapshotSize string, vgs []nodelocalstorage.VolumeGroup) (limit uint64, limited bool, err error) {
+	if strings.TrimSpace(maxSnapshotSize) == "" {
+		return 0, false, nil
+	}
+	size, err := ParseSnapshotSize(maxSnapshotSize)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid maxSnapshotSize: %s", err.Error())
+	}
+	if !size.IsPercent() {
+		return size.Bytes, true, nil
+	}
+	var total uint64
+	for _, vg := range vgs {
+		total += vg.Total
+	}
+	if total == 0 {
+		return 0, false, fmt.Errorf("total size of vgs is unknown, can not resolve maxSnapshotSize %s", size.String())
+	}
+	return uint64(float64(total) * size.Percent / 100), true, nil
+}
+
+// SnapshotCapacityUsed returns total size of snapshot lvs in vgs
+func SnapshotCapacityUsed(vgs []nodelocalstorage.VolumeGroup) uint64 {
+	var used uint64
+	for _, vg := range vgs {
+		for _, lv := range vg.LogicalVolumes {
+			if lv.Origin != "" {
+				used += lv.Total
+			}
+		}
+	}
+	return used
+}
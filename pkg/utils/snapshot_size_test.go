@@ -0,0 +1,222 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func Test_ParseSnapshotSize(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	tests := []struct {
+		name    string
+		value   string
+		want    SnapshotSize
+		wantErr bool
+	}{
+		{
+			name:  "test absolute size",
+			value: "4Gi",
+			want:  SnapshotSize{Bytes: 4 * gi},
+		},
+		{
+			name:  "test absolute size in bytes",
+			value: "1048576",
+			want:  SnapshotSize{Bytes: 1048576},
+		},
+		{
+			name:  "test percentage",
+			value: "20%",
+			want:  SnapshotSize{Percent: 20},
+		},
+		{
+			name:  "test fractional percentage",
+			value: " 2.5 %",
+			want:  SnapshotSize{Percent: 2.5},
+		},
+		{
+			name:  "test full origin size",
+			value: "100%",
+			want:  SnapshotSize{Percent: 100},
+		},
+		{
+			name:    "test zero percentage",
+			value:   "0%",
+			wantErr: true,
+		},
+		{
+			name:    "test percentage over 100",
+			value:   "150%",
+			wantErr: true,
+		},
+		{
+			name:    "test percentage mixed with unit",
+			value:   "20%Gi",
+			wantErr: true,
+		},
+		{
+			name:    "test unit mixed with percentage",
+			value:   "4Gi%",
+			wantErr: true,
+		},
+		{
+			name:    "test zero size",
+			value:   "0",
+			wantErr: true,
+		},
+		{
+			name:    "test invalid size",
+			value:   "large",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSnapshotSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSnapshotSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSnapshotSize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_SnapshotSize_Resolve(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	tests := []struct {
+		name       string
+		value      string
+		originSize uint64
+		want       uint64
+		wantErr    bool
+	}{
+		{
+			name:       "test absolute size ignores origin size",
+			value:      "4Gi",
+			originSize: 100 * gi,
+			want:       4 * gi,
+		},
+		{
+			name:       "test absolute size without origin size",
+			value:      "4Gi",
+			originSize: 0,
+			want:       4 * gi,
+		},
+		{
+			name:       "test percentage of tiny origin",
+			value:      "20%",
+			originSize: 1 * gi,
+			want:       gi / 5,
+		},
+		{
+			name:       "test percentage of huge origin",
+			value:      "20%",
+			originSize: 1000 * gi,
+			want:       200 * gi,
+		},
+		{
+			name:       "test percentage without origin size",
+			value:      "20%",
+			originSize: 0,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := ParseSnapshotSize(tt.value)
+			if err != nil {
+				t.Fatalf("ParseSnapshotSize() error = %v", err)
+			}
+			got, err := size.Resolve(tt.originSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_SnapshotSize_Consistent(t *testing.T) {
+	// percentage and absolute size of the same bytes are resolved equally
+	const originSize = 10 * 1024 * 1024 * 1024
+	pairs := [][2]string{
+		{"20%", "2Gi"},
+		{"50%", "5Gi"},
+		{"100%", "10Gi"},
+	}
+	for _, pair := range pairs {
+		percent, err := ParseSnapshotSize(pair[0])
+		if err != nil {
+			t.Fatalf("ParseSnapshotSize(%q) error = %v", pair[0], err)
+		}
+		absolute, err := ParseSnapshotSize(pair[1])
+		if err != nil {
+			t.Fatalf("ParseSnapshotSize(%q) error = %v", pair[1], err)
+		}
+		gotPercent, _ := percent.Resolve(originSize)
+		gotAbsolute, _ := absolute.Resolve(originSize)
+		if gotPercent != gotAbsolute {
+			t.Errorf("%s of origin = %d, want %s = %d", pair[0], gotPercent, pair[1], gotAbsolute)
+		}
+	}
+}
+
+func Test_SnapshotCapacityLimit(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	vgs := []nodelocalstorage.VolumeGroup{{Name: "pool-0", Total: 100 * gi}, {Name: "pool-1", Total: 300 * gi}}
+	tests := []struct {
+		name        string
+		value       string
+		vgs         []nodelocalstorage.VolumeGroup
+		wantLimit   uint64
+		wantLimited bool
+		wantErr     bool
+	}{
+		{name: "test unset", value: "", vgs: vgs},
+		{name: "test absolute", value: "50Gi", vgs: vgs, wantLimit: 50 * gi, wantLimited: true},
+		{name: "test percentage of all vgs", value: "25%", vgs: vgs, wantLimit: 100 * gi, wantLimited: true},
+		{name: "test percentage without vg", value: "25%", wantErr: true},
+		{name: "test invalid", value: "150%", vgs: vgs, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, limited, err := SnapshotCapacityLimit(tt.value, tt.vgs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SnapshotCapacityLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if limit != tt.wantLimit || limited != tt.wantLimited {
+				t.Errorf("SnapshotCapacityLimit() = %d, %v, want %d, %v", limit, limited, tt.wantLimit, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func Test_SnapshotCapacityUsed(t *testing.T) {
+	vgs := []nodelocalstorage.VolumeGroup{
+		{Name: "pool-0", LogicalVolumes: []nodelocalstorage.LogicalVolume{{Name: "origin", Total: 100}, {Name: "snap-0", Origin: "origin", Total: 10}}},
+		{Name: "pool-1", LogicalVolumes: []nodelocalstorage.LogicalVolume{{Name: "snap-1", Origin: "data", Total: 20}}},
+	}
+	if got := SnapshotCapacityUsed(vgs); got != 30 {
+		t.Errorf("SnapshotCapacityUsed() = %d, want 30", got)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"sort"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// StorageClassServiceability matches open-local storage classes against
+// storage in status of nls. Storage class of other provisioners or volume
+// types not scheduled by open-local is in neither list
+func StorageClassServiceability(nls *nodelocalstorage.NodeLocalStorage, scs []*storagev1.StorageClass) *nodelocalstorage.StorageClassServiceability {
+	result := &nodelocalstorage.StorageClassServiceability{}
+	for _, sc := range scs {
+		if !ContainsProvisioner(sc.Provisioner) {
+			continue
+		}
+		serviceable, known := IsStorageClassServiceable(nls, sc)
+		if !known {
+			continue
+		}
+		if serviceable {
+			result.Serviceable = append(result.Serviceable, sc.Name)
+		} else {
+			result.Unserviceable = append(result.Unserviceable, sc.Name)
+		}
+	}
+	sort.Strings(result.Serviceable)
+	sort.Strings(result.Unserviceable)
+	return result
+}
+
+// IsStorageClassServiceable returns true if node of nls has a VG, mount point
+// or device volumes of sc can be placed on, known is false if volume type of
+// sc is not scheduled by open-local
+func IsStorageClassServiceable(nls *nodelocalstorage.NodeLocalStorage, sc *storagev1.StorageClass) (serviceable bool, known bool) {
+	switch LocalPVType(sc) {
+	case localtype.VolumeTypeLVM:
+		return hasServiceableVG(nls, sc.Parameters), true
+	case localtype.VolumeTypeMountPoint:
+		return hasServiceableMountPoint(nls, localtype.MediaType(sc.Parameters[localtype.VolumeMediaType])), true
+	case localtype.VolumeTypeDevice:
+		return hasServiceableDevice(nls, localtype.MediaType(sc.Parameters[localtype.VolumeMediaType])), true
+	}
+	return false, false
+}
+
+// hasServiceableVG is true if any vg allowed for scheduling and not under
+// maintenance is the vgName of parameters and matches its vg selector
+func hasServiceableVG(nls *nodelocalstorage.NodeLocalStorage, params map[string]string) bool {
+	selector, err := ParseVGSelector(params)
+	if err != nil {
+		return false
+	}
+	vgName := params[localtype.ParamVGName]
+	for _, vg := range nls.Status.FilteredStorageInfo.VolumeGroups {
+		if vgName != "" && vg != vgName {
+			continue
+		}
+		if IsVGInMaintenance(nls, vg) || !selector.Matches(labels.Set(GetVGLabels(nls, vg))) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// hasServiceableMountPoint is true if any mount point allowed for scheduling
+// has supported filesystem and lies on device of mediaType, empty matches any
+func hasServiceableMountPoint(nls *nodelocalstorage.NodeLocalStorage, mediaType localtype.MediaType) bool {
+	mountPoints := make(map[string]nodelocalstorage.MountPoint, len(nls.Status.NodeStorageInfo.MountPoints))
+	for _, mp := range nls.Status.NodeStorageInfo.MountPoints {
+		mountPoints[mp.Name] = mp
+	}
+	for _, name := range nls.Status.FilteredStorageInfo.MountPoints {
+		mp, exist := mountPoints[name]
+		if !exist || !CheckMountPointOptions(&mp) {
+			continue
+		}
+		if mediaType == "" || deviceMediaType(nls, mp.Device) == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// hasServiceableDevice is true if any device allowed for scheduling is of
+// mediaType, empty matches any
+func hasServiceableDevice(nls *nodelocalstorage.NodeLocalStorage, mediaType localtype.MediaType) bool {
+	for _, name := range nls.Status.FilteredStorageInfo.Devices {
+		if mediaType == "" || deviceMediaType(nls, name) == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func deviceMediaType(nls *nodelocalstorage.NodeLocalStorage, name string) localtype.MediaType {
+	for _, device := range nls.Status.NodeStorageInfo.DeviceInfos {
+		if device.Name == name {
+			return localtype.MediaType(device.MediaType)
+		}
+	}
+	return ""
+}
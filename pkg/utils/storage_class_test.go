@@ -0,0 +1,81 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testStorageClass(name string, params map[string]string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: name},
+		Provisioner: localtype.ProvisionerName,
+		Parameters:  params,
+	}
+}
+
+func Test_StorageClassServiceability(t *testing.T) {
+	nls := &nodelocalstorage.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	nls.Spec.ListConfig.VGs.Maintenance = []string{"pool-1"}
+	nls.Spec.ListConfig.VGs.Labels = []nodelocalstorage.VGLabels{{Name: "pool-0", Labels: map[string]string{"pool": "fast"}}}
+	nls.Status.NodeStorageInfo.DeviceInfos = []nodelocalstorage.DeviceInfo{
+		{Name: "/dev/vdb", MediaType: string(localtype.MediaTypeHDD)},
+		{Name: "/dev/vdc", MediaType: string(localtype.MediaTypeSSD)},
+	}
+	nls.Status.NodeStorageInfo.MountPoints = []nodelocalstorage.MountPoint{
+		{Name: "/mnt/disk-1", Device: "/dev/vdc", FsType: "ext4"},
+	}
+	nls.Status.FilteredStorageInfo.VolumeGroups = []string{"pool-0", "pool-1"}
+	nls.Status.FilteredStorageInfo.MountPoints = []string{"/mnt/disk-1"}
+	nls.Status.FilteredStorageInfo.Devices = []string{"/dev/vdb"}
+
+	lvm := string(localtype.VolumeTypeLVM)
+	scs := []*storagev1.StorageClass{
+		testStorageClass("lvm-any-vg", map[string]string{localtype.VolumeTypeKey: lvm}),
+		testStorageClass("lvm-pool-0", map[string]string{localtype.VolumeTypeKey: lvm, localtype.ParamVGName: "pool-0"}),
+		testStorageClass("lvm-in-maintenance", map[string]string{localtype.VolumeTypeKey: lvm, localtype.ParamVGName: "pool-1"}),
+		testStorageClass("lvm-missing-vg", map[string]string{localtype.VolumeTypeKey: lvm, localtype.ParamVGName: "pool-2"}),
+		testStorageClass("lvm-fast", map[string]string{localtype.VolumeTypeKey: lvm, localtype.ParamVGSelector: "pool=fast"}),
+		testStorageClass("lvm-slow", map[string]string{localtype.VolumeTypeKey: lvm, localtype.ParamVGSelector: "pool=slow"}),
+		testStorageClass("mp-ssd", map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeMountPoint), localtype.VolumeMediaType: string(localtype.MediaTypeSSD)}),
+		testStorageClass("mp-hdd", map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeMountPoint), localtype.VolumeMediaType: string(localtype.MediaTypeHDD)}),
+		testStorageClass("device-hdd", map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeDevice), localtype.VolumeMediaType: string(localtype.MediaTypeHDD)}),
+		testStorageClass("device-ssd", map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeDevice), localtype.VolumeMediaType: string(localtype.MediaTypeSSD)}),
+		testStorageClass("quota", map[string]string{localtype.VolumeTypeKey: string(localtype.VolumeTypeQuota)}),
+		{ObjectMeta: metav1.ObjectMeta{Name: "not-local"}, Provisioner: "kubernetes.io/no-provisioner"},
+	}
+	want := &nodelocalstorage.StorageClassServiceability{
+		Serviceable:   []string{"device-hdd", "lvm-any-vg", "lvm-fast", "lvm-pool-0", "mp-ssd"},
+		Unserviceable: []string{"device-ssd", "lvm-in-maintenance", "lvm-missing-vg", "lvm-slow", "mp-hdd"},
+	}
+	if got := StorageClassServiceability(nls, scs); !reflect.DeepEqual(got, want) {
+		t.Errorf("StorageClassServiceability() = %+v, want %+v", got, want)
+	}
+
+	// node without storage serves no class
+	empty := &nodelocalstorage.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+	got := StorageClassServiceability(empty, scs[:1])
+	if len(got.Serviceable) != 0 || !reflect.DeepEqual(got.Unserviceable, []string{"lvm-any-vg"}) {
+		t.Errorf("StorageClassServiceability() of empty node = %+v", got)
+	}
+}
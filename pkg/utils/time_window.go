@@ -0,0 +1,84 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time window in UTC, it crosses midnight if End is
+// not after Start
+type TimeWindow struct {
+	// Start and End are offsets from midnight
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeWindows parses windows in the form of HH:MM-HH:MM, such as
+// 01:00-05:00 or 22:00-02:00 crossing midnight
+func ParseTimeWindows(values []string) ([]TimeWindow, error) {
+	windows := make([]TimeWindow, 0, len(values))
+	for _, value := range values {
+		parts := strings.Split(strings.TrimSpace(value), "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid time window %q, must be HH:MM-HH:MM", value)
+		}
+		start, err := parseTimeOfDay(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of time window %q: %s", value, err.Error())
+		}
+		end, err := parseTimeOfDay(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of time window %q: %s", value, err.Error())
+		}
+		if start == end {
+			return nil, fmt.Errorf("time window %q is empty", value)
+		}
+		windows = append(windows, TimeWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains is true if t in UTC falls in the window, start inclusive
+func (w TimeWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// InTimeWindows is true if t falls in any of windows
+func InTimeWindows(windows []TimeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseTimeWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		at      string
+		want    bool
+		wantErr bool
+	}{
+		{name: "test no window", values: nil, at: "03:00", want: false},
+		{name: "test in window", values: []string{"01:00-05:00"}, at: "01:00", want: true},
+		{name: "test end exclusive", values: []string{"01:00-05:00"}, at: "05:00", want: false},
+		{name: "test in second window", values: []string{"01:00-02:00", " 12:30-13:00 "}, at: "12:45", want: true},
+		{name: "test crossing midnight before", values: []string{"22:00-02:00"}, at: "23:59", want: true},
+		{name: "test crossing midnight after", values: []string{"22:00-02:00"}, at: "01:59", want: true},
+		{name: "test out of window crossing midnight", values: []string{"22:00-02:00"}, at: "12:00", want: false},
+		{name: "test invalid format", values: []string{"01:00"}, wantErr: true},
+		{name: "test invalid hour", values: []string{"01:00-25:00"}, wantErr: true},
+		{name: "test empty window", values: []string{"01:00-01:00"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseTimeWindows(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeWindows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			at, _ := time.Parse("15:04", tt.at)
+			if got := InTimeWindows(windows, at); got != tt.want {
+				t.Errorf("InTimeWindows(%v, %s) = %v, want %v", tt.values, tt.at, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	log "k8s.io/klog/v2"
+)
+
+// instrumentationName is the name of tracer creating all spans of open-local
+const instrumentationName = "github.com/alibaba/open-local"
+
+// Setup exports spans to otlp grpc endpoint, e.g. otel-collector:4317. Tracing
+// is off if endpoint is empty, spans are then dropped by the noop provider of
+// otel. The returned func flushes pending spans
+func Setup(endpoint, serviceName string) (func(context.Context) error, error) {
+	// w3c trace context is propagated even if tracing is off, so that callers
+	// are still correlated with downstream components
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Infof("tracing is enabled, spans are exported to %s", endpoint)
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts span name as child of span in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan marks span as failed if err is not nil and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// UnaryServerInterceptor starts span for grpc methods in spanNames, which maps
+// full method to span name, with attributes returned by attrs for the request.
+// Parent span is extracted from grpc metadata if present
+func UnaryServerInterceptor(spanNames map[string]string, attrs func(req interface{}) []attribute.KeyValue) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		name, ok := spanNames[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		}
+		var kvs []attribute.KeyValue
+		if attrs != nil {
+			kvs = attrs(req)
+		}
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(append(kvs, semconv.RPCSystemKey.String("grpc"), semconv.RPCMethodKey.String(info.FullMethod))...))
+		resp, err := handler(ctx, req)
+		EndSpan(span, err)
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor injects span in ctx into grpc metadata
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+}
+
+// metadataCarrier adapts grpc metadata to carrier of otel propagator
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func setupInMemoryExporter(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	return exporter
+}
+
+func TestUnaryInterceptors(t *testing.T) {
+	exporter := setupInMemoryExporter(t)
+	spanNames := map[string]string{"/proto.LVM/CreateLV": "lvm.CreateLV"}
+	attrs := func(req interface{}) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("lvm.lv", req.(string))}
+	}
+	server := UnaryServerInterceptor(spanNames, attrs)
+
+	// span of caller is propagated to server through grpc metadata
+	ctx, parent := StartSpan(context.Background(), "csi.CreateVolume")
+	var incoming context.Context
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		incoming = metadata.NewIncomingContext(context.Background(), md)
+		return nil
+	}
+	if err := UnaryClientInterceptor(ctx, "/proto.LVM/CreateLV", "local-1", nil, nil, invoker); err != nil {
+		t.Fatalf("UnaryClientInterceptor() = %v", err)
+	}
+	parent.End()
+
+	handlerErr := errors.New("lvcreate failed")
+	var handlerSpan trace.SpanContext
+	_, err := server(incoming, "local-1", &grpc.UnaryServerInfo{FullMethod: "/proto.LVM/CreateLV"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return nil, handlerErr
+	})
+	if err != handlerErr {
+		t.Errorf("UnaryServerInterceptor() = %v, want %v", err, handlerErr)
+	}
+	// untraced method creates no span
+	if _, err := server(incoming, "local-1", &grpc.UnaryServerInfo{FullMethod: "/proto.LVM/ListLV"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Errorf("UnaryServerInterceptor() = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	span := spans[1]
+	if span.Name != "lvm.CreateLV" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span name and kind = %s, %s, want lvm.CreateLV, server", span.Name, span.SpanKind)
+	}
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() || span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("span parent = %v, want %v", span.Parent, parent.SpanContext())
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("handler runs in span %v, want %v", handlerSpan, span.SpanContext)
+	}
+	want := []attribute.KeyValue{
+		attribute.String("lvm.lv", "local-1"),
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.method", "/proto.LVM/CreateLV"),
+	}
+	if !reflect.DeepEqual(span.Attributes, want) {
+		t.Errorf("span attributes = %v, want %v", span.Attributes, want)
+	}
+	if span.Status.Code != codes.Error || span.Status.Description != handlerErr.Error() {
+		t.Errorf("span status = %v, want error %s", span.Status, handlerErr.Error())
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := Setup("", "local.csi.aliyun.com")
+	if err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		t.Errorf("tracer provider is set while tracing is off")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() = %v", err)
+	}
+}
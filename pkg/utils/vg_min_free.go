@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+// VGMinFree resolves minFreeSize of nls spec for vg of total bytes, absolute
+// or percentage of total, 0 if it is not set
+func VGMinFree(nls *nodelocalstorage.NodeLocalStorage, total uint64) (uint64, error) {
+	if nls == nil || strings.TrimSpace(nls.Spec.ListConfig.VGs.MinFreeSize) == "" {
+		return 0, nil
+	}
+	size, err := ParseSnapshotSize(nls.Spec.ListConfig.VGs.MinFreeSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minFreeSize: %s", err.Error())
+	}
+	if !size.IsPercent() {
+		return size.Bytes, nil
+	}
+	return uint64(float64(total) * size.Percent / 100), nil
+}
+
+// GetVGAllocatable returns allocatable of vg excluding unassigned
+// preallocated lvs and minFreeSize of nls spec, invalid minFreeSize is
+// ignored as it is rejected by webhook
+func GetVGAllocatable(nls *nodelocalstorage.NodeLocalStorage, vg nodelocalstorage.VolumeGroup) uint64 {
+	allocatable := vg.Allocatable
+	if preallocated := uint64(GetPreallocatedLVs(nls)[vg.Name].Size); preallocated > 0 {
+		if allocatable <= preallocated {
+			return 0
+		}
+		allocatable -= preallocated
+	}
+	minFree, err := VGMinFree(nls, vg.Total)
+	if err != nil || minFree == 0 {
+		return allocatable
+	}
+	if allocatable <= minFree {
+		return 0
+	}
+	return allocatable - minFree
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+func Test_GetVGAllocatable(t *testing.T) {
+	const gi = 1024 * 1024 * 1024
+	vg := nodelocalstorage.VolumeGroup{Name: "pool-0", Total: 200 * gi, Allocatable: 100 * gi}
+	tests := []struct {
+		name        string
+		minFreeSize string
+		// annotation of unassigned preallocated lvs
+		preallocated string
+		want         uint64
+		wantMinFree  uint64
+		wantErr      bool
+	}{
+		{name: "test unset", want: 100 * gi},
+		{name: "test absolute", minFreeSize: "10Gi", want: 90 * gi, wantMinFree: 10 * gi},
+		{name: "test percentage of vg total", minFreeSize: "5%", want: 90 * gi, wantMinFree: 10 * gi},
+		{name: "test floor beyond allocatable", minFreeSize: "150Gi", want: 0, wantMinFree: 150 * gi},
+		{name: "test invalid is ignored", minFreeSize: "150%", want: 100 * gi, wantErr: true},
+		{name: "test preallocated lvs", minFreeSize: "10Gi", preallocated: `{"pool-0":{"count":2,"size":21474836480}}`, want: 70 * gi, wantMinFree: 10 * gi},
+		{name: "test preallocated lvs of other vg", preallocated: `{"pool-1":{"count":2,"size":21474836480}}`, want: 100 * gi},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nls := &nodelocalstorage.NodeLocalStorage{}
+			nls.Spec.ListConfig.VGs.MinFreeSize = tt.minFreeSize
+			if tt.preallocated != "" {
+				nls.Annotations = map[string]string{localtype.AnnoPreallocatedLVs: tt.preallocated}
+			}
+			minFree, err := VGMinFree(nls, vg.Total)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VGMinFree() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if minFree != tt.wantMinFree {
+				t.Errorf("VGMinFree() = %d, want %d", minFree, tt.wantMinFree)
+			}
+			if got := GetVGAllocatable(nls, vg); got != tt.want {
+				t.Errorf("GetVGAllocatable() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
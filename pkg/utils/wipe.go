@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+)
+
+// WipingDevices returns devices listed in wiping annotation of nls
+func WipingDevices(nls *nodelocalstorage.NodeLocalStorage) []string {
+	if nls == nil {
+		return nil
+	}
+	var devices []string
+	for _, dev := range strings.Split(nls.Annotations[localtype.AnnoWipingDevices], ",") {
+		if dev = strings.TrimSpace(dev); dev != "" && !ContainsString(devices, dev) {
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// IsDeviceWiping returns true if device is listed in wiping annotation of nls
+// or reported wiping by agent. The annotation is set before pv of device is
+// deleted, so device is never seen free before agent reports it
+func IsDeviceWiping(nls *nodelocalstorage.NodeLocalStorage, device string) bool {
+	if ContainsString(WipingDevices(nls), device) {
+		return true
+	}
+	if nls == nil {
+		return false
+	}
+	for _, dev := range nls.Status.NodeStorageInfo.DeviceInfos {
+		if dev.Name == device {
+			return dev.Condition == nodelocalstorage.StorageWiping
+		}
+	}
+	return false
+}
+
+// SetDeviceWiping adds device to or removes it from wiping annotation of nls,
+// it returns false if the annotation is left unchanged
+func SetDeviceWiping(nls *nodelocalstorage.NodeLocalStorage, device string, wiping bool) bool {
+	devices := WipingDevices(nls)
+	if ContainsString(devices, device) == wiping {
+		return false
+	}
+	if wiping {
+		devices = append(devices, device)
+	} else {
+		remaining := make([]string, 0, len(devices))
+		for _, dev := range devices {
+			if dev != device {
+				remaining = append(remaining, dev)
+			}
+		}
+		devices = remaining
+	}
+	if len(devices) == 0 {
+		delete(nls.Annotations, localtype.AnnoWipingDevices)
+		return true
+	}
+	if nls.Annotations == nil {
+		nls.Annotations = map[string]string{}
+	}
+	nls.Annotations[localtype.AnnoWipingDevices] = strings.Join(devices, ",")
+	return true
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	nodelocalstorage "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func wipingNLS(annotation string, devices ...nodelocalstorage.DeviceInfo) *nodelocalstorage.NodeLocalStorage {
+	nls := &nodelocalstorage.NodeLocalStorage{}
+	if annotation != "" {
+		nls.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{localtype.AnnoWipingDevices: annotation}}
+	}
+	nls.Status.NodeStorageInfo.DeviceInfos = devices
+	return nls
+}
+
+func Test_IsDeviceWiping(t *testing.T) {
+	tests := []struct {
+		name   string
+		nls    *nodelocalstorage.NodeLocalStorage
+		device string
+		want   bool
+	}{
+		{
+			name:   "test nil nls",
+			device: "/dev/sdb",
+		},
+		{
+			name:   "test listed in annotation",
+			nls:    wipingNLS("/dev/sda, /dev/sdb"),
+			device: "/dev/sdb",
+			want:   true,
+		},
+		{
+			name:   "test reported wiping by agent",
+			nls:    wipingNLS("", nodelocalstorage.DeviceInfo{Name: "/dev/sdb", Condition: nodelocalstorage.StorageWiping}),
+			device: "/dev/sdb",
+			want:   true,
+		},
+		{
+			name:   "test ready device",
+			nls:    wipingNLS("/dev/sda", nodelocalstorage.DeviceInfo{Name: "/dev/sdb", Condition: nodelocalstorage.StorageReady}),
+			device: "/dev/sdb",
+		},
+	}
+	for _, tt := range tests {
+		if got := IsDeviceWiping(tt.nls, tt.device); got != tt.want {
+			t.Errorf("%s: IsDeviceWiping() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_SetDeviceWiping(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotation  string
+		device      string
+		wiping      bool
+		wantChanged bool
+		wantDevices []string
+	}{
+		{
+			name:        "test add to empty",
+			device:      "/dev/sdb",
+			wiping:      true,
+			wantChanged: true,
+			wantDevices: []string{"/dev/sdb"},
+		},
+		{
+			name:        "test add existing",
+			annotation:  "/dev/sdb",
+			device:      "/dev/sdb",
+			wiping:      true,
+			wantDevices: []string{"/dev/sdb"},
+		},
+		{
+			name:        "test remove one of two",
+			annotation:  "/dev/sda,/dev/sdb",
+			device:      "/dev/sda",
+			wantChanged: true,
+			wantDevices: []string{"/dev/sdb"},
+		},
+		{
+			name:        "test remove the last",
+			annotation:  "/dev/sdb",
+			device:      "/dev/sdb",
+			wantChanged: true,
+		},
+		{
+			name:   "test remove absent",
+			device: "/dev/sdb",
+		},
+	}
+	for _, tt := range tests {
+		nls := wipingNLS(tt.annotation)
+		if changed := SetDeviceWiping(nls, tt.device, tt.wiping); changed != tt.wantChanged {
+			t.Errorf("%s: SetDeviceWiping() = %t, want %t", tt.name, changed, tt.wantChanged)
+		}
+		if got := WipingDevices(nls); !reflect.DeepEqual(got, tt.wantDevices) {
+			t.Errorf("%s: WipingDevices() = %v, want %v", tt.name, got, tt.wantDevices)
+		}
+		if _, exist := nls.Annotations[localtype.AnnoWipingDevices]; exist && len(tt.wantDevices) == 0 {
+			t.Errorf("%s: empty annotation %s is kept", tt.name, localtype.AnnoWipingDevices)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/julienschmidt/httprouter"
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	ValidateStorageClassPath     = "/validate-storageclass"
+	ValidateNodeLocalStoragePath = "/validate-nodelocalstorage"
+)
+
+type validateFunc func(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error)
+
+// NewRouter returns router serving validating admission reviews
+func NewRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.POST(ValidateStorageClassPath, admissionRoute(validateStorageClass))
+	router.POST(ValidateNodeLocalStoragePath, admissionRoute(validateNodeLocalStorage))
+	return router
+}
+
+// Start serves webhook over tls on port in background
+func Start(port int, certFile, keyFile string) {
+	go func() {
+		log.Infof("starting webhook server on port %d", port)
+		if err := http.ListenAndServeTLS(fmt.Sprintf(":%d", port), certFile, keyFile, NewRouter()); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+func admissionRoute(validate validateFunc) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		review := admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode admission review: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "admission review has no request", http.StatusBadRequest)
+			return
+		}
+		response, err := validate(review.Request)
+		if err != nil {
+			response = &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusBadRequest,
+					Reason:  metav1.StatusReasonBadRequest,
+					Message: err.Error(),
+				},
+			}
+		}
+		response.UID = review.Request.UID
+		review.Response = response
+		// request is not echoed back
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			log.Errorf("failed to write admission review: %s", err.Error())
+		}
+	}
+}
+
+func validateStorageClass(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	sc := storagev1.StorageClass{}
+	if err := json.Unmarshal(req.Object.Raw, &sc); err != nil {
+		return nil, fmt.Errorf("failed to decode storage class: %s", err.Error())
+	}
+	return admissionResponse("storage class", sc.Name, ValidateStorageClass(&sc)), nil
+}
+
+func validateNodeLocalStorage(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	nls := localv1alpha1.NodeLocalStorage{}
+	if err := json.Unmarshal(req.Object.Raw, &nls); err != nil {
+		return nil, fmt.Errorf("failed to decode NodeLocalStorage: %s", err.Error())
+	}
+	if req.Operation == admissionv1.Update {
+		old := localv1alpha1.NodeLocalStorage{}
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return nil, fmt.Errorf("failed to decode old NodeLocalStorage: %s", err.Error())
+		}
+		// metadata is updated in spite of spec accepted before validation is enabled
+		if reflect.DeepEqual(old.Spec, nls.Spec) {
+			return &admissionv1.AdmissionResponse{Allowed: true}, nil
+		}
+	}
+	return admissionResponse("NodeLocalStorage", nls.Name, ValidateNodeLocalStorageSpec(&nls.Spec)), nil
+}
+
+func admissionResponse(kind, name string, errs field.ErrorList) *admissionv1.AdmissionResponse {
+	if len(errs) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	message := fmt.Sprintf("%s %s is invalid: %s", kind, name, errs.ToAggregate().Error())
+	log.Infof("[webhook]reject %s", message)
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_AdmissionRoute(t *testing.T) {
+	validSC := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "open-local-lvm"},
+		Provisioner: localtype.ProvisionerName,
+		Parameters:  map[string]string{localtype.VolumeTypeKey: "LVM", ParamCSIFSType: "ext4"},
+	}
+	invalidSC := validSC.DeepCopy()
+	invalidSC.Parameters[localtype.ParamSnapshotThreshold] = "0"
+	invalidSpec := localv1alpha1.NodeLocalStorageSpec{
+		NodeName:   "node-1",
+		ListConfig: localv1alpha1.ListConfig{VGs: localv1alpha1.VGList{Include: []string{""}}},
+	}
+	validSpec := localv1alpha1.NodeLocalStorageSpec{
+		NodeName:   "node-1",
+		ListConfig: localv1alpha1.ListConfig{VGs: localv1alpha1.VGList{Include: []string{"share"}}},
+	}
+	tests := []struct {
+		name        string
+		path        string
+		operation   admissionv1.Operation
+		object      interface{}
+		oldObject   interface{}
+		wantAllowed bool
+		wantMessage string
+	}{
+		{
+			name:        "test valid storage class",
+			path:        ValidateStorageClassPath,
+			operation:   admissionv1.Create,
+			object:      validSC,
+			wantAllowed: true,
+		},
+		{
+			name:        "test invalid storage class",
+			path:        ValidateStorageClassPath,
+			operation:   admissionv1.Create,
+			object:      invalidSC,
+			wantMessage: "storage class open-local-lvm is invalid: parameters[csi.aliyun.com/snapshot-expansion-threshold]",
+		},
+		{
+			name:        "test invalid nls created",
+			path:        ValidateNodeLocalStoragePath,
+			operation:   admissionv1.Create,
+			object:      &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: invalidSpec},
+			wantMessage: "NodeLocalStorage node-1 is invalid: spec.listConfig.vgs.include[0]",
+		},
+		{
+			name:        "test nls updated to invalid spec",
+			path:        ValidateNodeLocalStoragePath,
+			operation:   admissionv1.Update,
+			object:      &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: invalidSpec},
+			oldObject:   &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: validSpec},
+			wantMessage: "spec.listConfig.vgs.include[0]",
+		},
+		{
+			name:        "test nls updated without spec changed",
+			path:        ValidateNodeLocalStoragePath,
+			operation:   admissionv1.Update,
+			object:      &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"a": "b"}}, Spec: invalidSpec},
+			oldObject:   &localv1alpha1.NodeLocalStorage{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: invalidSpec},
+			wantAllowed: true,
+		},
+	}
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &admissionv1.AdmissionRequest{
+				UID:       types.UID("705ab4f5-6393-11e8-b7cc-42010a800002"),
+				Operation: tt.operation,
+				Object:    runtime.RawExtension{Raw: mustMarshal(t, tt.object)},
+			}
+			if tt.oldObject != nil {
+				request.OldObject = runtime.RawExtension{Raw: mustMarshal(t, tt.oldObject)}
+			}
+			body := mustMarshal(t, admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request:  request,
+			})
+			resp, err := http.Post(server.URL+tt.path, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			review := admissionv1.AdmissionReview{}
+			if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+			if review.Response == nil || review.Response.UID != request.UID {
+				t.Fatalf("response %v does not match request uid %s", review.Response, request.UID)
+			}
+			if review.Kind != "AdmissionReview" || review.APIVersion != "admission.k8s.io/v1" {
+				t.Errorf("review type %v, want admission.k8s.io/v1 AdmissionReview", review.TypeMeta)
+			}
+			if review.Response.Allowed != tt.wantAllowed {
+				t.Errorf("allowed = %v, want %v", review.Response.Allowed, tt.wantAllowed)
+			}
+			if tt.wantMessage != "" && (review.Response.Result == nil || !strings.Contains(review.Response.Result.Message, tt.wantMessage)) {
+				t.Errorf("result %v does not contain %q", review.Response.Result, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, obj interface{}) []byte {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
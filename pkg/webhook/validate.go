@@ -0,0 +1,346 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	"github.com/alibaba/open-local/pkg/csi"
+	"github.com/alibaba/open-local/pkg/utils"
+	deviceutil "github.com/alibaba/open-local/pkg/utils/device"
+	"github.com/alibaba/open-local/pkg/utils/lvm"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// ParamCSIFSType is the fsType parameter consumed by csi external-provisioner
+	ParamCSIFSType = "csi.storage.k8s.io/fstype"
+	// ParamNodeStageSecretName is the node stage secret passed by kubelet
+	ParamNodeStageSecretName = "csi.storage.k8s.io/node-stage-secret-name"
+)
+
+var (
+	// filesystems volume is formatted with, it is documented that ext2 is
+	// supported besides those of mount point
+	validFSTypes    = []string{"ext2", localtype.VolumeFSTypeExt3, localtype.VolumeFSTypeExt4, localtype.VolumeFSTypeXFS}
+	validMediaTypes = []string{string(localtype.MediaTypeSSD), string(localtype.MediaTypeHDD)}
+	validLvmTypes   = []string{csi.LinearType, csi.StripingType}
+)
+
+// ValidateStorageClass validates parameters of open-local storage class, storage
+// class of other provisioners is not validated
+func ValidateStorageClass(sc *storagev1.StorageClass) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !utils.ContainsProvisioner(sc.Provisioner) {
+		return allErrs
+	}
+	fldPath := field.NewPath("parameters")
+	params := sc.Parameters
+
+	volumeType, ok := params[localtype.VolumeTypeKey]
+	if !ok {
+		allErrs = append(allErrs, field.Required(fldPath.Key(localtype.VolumeTypeKey), fmt.Sprintf("must be one of %s", strings.Join(volumeTypes(), ", "))))
+	} else if !validVolumeType(volumeType) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Key(localtype.VolumeTypeKey), volumeType, volumeTypes()))
+	}
+	for _, key := range []string{localtype.VolumeFSTypeKey, ParamCSIFSType} {
+		if value, ok := params[key]; ok && utils.StringsContains(validFSTypes, value) == -1 {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(key), value, validFSTypes))
+		}
+	}
+	if value, ok := params[localtype.VolumeMediaType]; ok && utils.StringsContains(validMediaTypes, value) == -1 {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Key(localtype.VolumeMediaType), value, validMediaTypes))
+	}
+	if value, ok := params[csi.LvmTypeTag]; ok && utils.StringsContains(validLvmTypes, value) == -1 {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Key(csi.LvmTypeTag), value, validLvmTypes))
+	}
+	if value, ok := params[localtype.ParamVGName]; ok && strings.TrimSpace(value) == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(localtype.ParamVGName), value, "remove vgName or set it to name of an existing volume group"))
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamVGSelector); ok {
+		selectorPath := fldPath.Key(paramKey(params, localtype.ParamVGSelector))
+		if _, err := utils.ParseVGSelector(params); err != nil {
+			allErrs = append(allErrs, field.Invalid(selectorPath, value, "must be a label selector, e.g. pool=fast"))
+		} else if _, exist := params[localtype.ParamVGName]; exist {
+			allErrs = append(allErrs, field.Invalid(selectorPath, value, "vgName and vg selector can not be set at the same time"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamDomainSelector); ok {
+		if _, err := utils.ParseDomainSelector(value); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamDomainSelector)), value, "must be a label selector of node, e.g. topology.open-local.io/power-domain=pd-a"))
+		}
+	}
+	for _, key := range []string{localtype.VolumeIOPS, localtype.VolumeBPS} {
+		if value, ok := params[key]; ok {
+			if limit, err := strconv.ParseUint(value, 10, 64); err != nil || limit == 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(key), value, "must be a positive integer"))
+			}
+		}
+	}
+	sizeRangeValid := true
+	for _, key := range []string{localtype.VolumeMinSize, localtype.VolumeMaxSize} {
+		if value, ok := params[key]; ok {
+			if quantity, err := resource.ParseQuantity(value); err != nil || quantity.Sign() < 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(key), value, "must be a non-negative quantity, e.g. 10Gi"))
+				sizeRangeValid = false
+			}
+		}
+	}
+	if _, _, err := utils.GetVolumeSizeRange(params); sizeRangeValid && err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(localtype.VolumeMaxSize), params[localtype.VolumeMaxSize], err.Error()))
+	}
+	for _, key := range []string{localtype.ParamSnapshotInitialSize, localtype.ParamSnapshotExpansionSize} {
+		if value, ok := utils.LookupParam(params, key); ok {
+			// expansion size may also be in percentage of vg or its free space
+			if key == localtype.ParamSnapshotExpansionSize && lvm.IsPercentSize(value) {
+				if _, err := lvm.ParsePercentSize(value); err != nil {
+					allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, key)), value, err.Error()))
+				}
+			} else if _, err := utils.ParseSnapshotSize(value); err != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, key)), value, err.Error()))
+			}
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamSnapshotThreshold); ok {
+		// the same as how csi and agent parse the threshold
+		threshold, err := strconv.ParseFloat(strings.ReplaceAll(value, "%", ""), 64)
+		if err != nil || threshold <= 0 || threshold > 100 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamSnapshotThreshold)), value, "must be a percentage greater than 0 and no more than 100, e.g. 50%"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamSnapshotAlertThreshold); ok {
+		threshold, err := strconv.ParseFloat(strings.ReplaceAll(value, "%", ""), 64)
+		if err != nil || threshold <= 0 || threshold > 100 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamSnapshotAlertThreshold)), value, "must be a percentage greater than 0 and no more than 100, e.g. 70%"))
+		}
+	}
+	if _, err := utils.GetSnapshotReserveSize(0, params); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamSnapshotReservePercent)), utils.GetParam(params, localtype.ParamSnapshotReservePercent), err.Error()))
+	}
+	if _, err := utils.GetExpansionSnapshotSize(0, params); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamExpansionSnapshotPercent)), utils.GetParam(params, localtype.ParamExpansionSnapshotPercent), err.Error()))
+	}
+	if _, err := utils.GetReservedBlocksPercent(params); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamReservedBlocksPercent)), utils.GetParam(params, localtype.ParamReservedBlocksPercent), err.Error()))
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamSnapshotOriginGrowthRatio); ok {
+		if ratio, err := strconv.ParseFloat(value, 64); err != nil || ratio < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamSnapshotOriginGrowthRatio)), value, "must be a non-negative number"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamSnapshotReadAhead); ok {
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(paramKey(params, localtype.ParamSnapshotReadAhead)), value, "must be a non-negative integer in 512-byte sectors"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamEncrypted); ok {
+		encryptedPath := fldPath.Key(paramKey(params, localtype.ParamEncrypted))
+		if value != "true" && value != "false" {
+			allErrs = append(allErrs, field.NotSupported(encryptedPath, value, []string{"true", "false"}))
+		} else if value == "true" {
+			if volumeType != string(localtype.VolumeTypeLVM) {
+				allErrs = append(allErrs, field.Invalid(encryptedPath, value, "encryption is only supported for LVM volume"))
+			}
+			if _, exist := params[ParamNodeStageSecretName]; !exist {
+				allErrs = append(allErrs, field.Required(fldPath.Key(ParamNodeStageSecretName), fmt.Sprintf("must refer to secret holding LUKS passphrase in key %s for encrypted volume", localtype.EncryptionPassphraseKey)))
+			}
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamZeroFill); ok {
+		zeroFillPath := fldPath.Key(paramKey(params, localtype.ParamZeroFill))
+		if value != "true" && value != "false" {
+			allErrs = append(allErrs, field.NotSupported(zeroFillPath, value, []string{"true", "false"}))
+		} else if value == "true" && volumeType != string(localtype.VolumeTypeLVM) {
+			allErrs = append(allErrs, field.Invalid(zeroFillPath, value, "zero fill is only supported for LVM volume"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamWipeOnDelete); ok {
+		wipePath := fldPath.Key(paramKey(params, localtype.ParamWipeOnDelete))
+		if value != "true" && value != "false" {
+			allErrs = append(allErrs, field.NotSupported(wipePath, value, []string{"true", "false"}))
+		} else if value == "true" && volumeType != string(localtype.VolumeTypeDevice) {
+			allErrs = append(allErrs, field.Invalid(wipePath, value, "wipe on delete is only supported for Device volume"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamDiscardOnDelete); ok {
+		discardPath := fldPath.Key(paramKey(params, localtype.ParamDiscardOnDelete))
+		if value != "true" && value != "false" {
+			allErrs = append(allErrs, field.NotSupported(discardPath, value, []string{"true", "false"}))
+		} else if value == "true" && volumeType != string(localtype.VolumeTypeLVM) {
+			allErrs = append(allErrs, field.Invalid(discardPath, value, "discard on delete is only supported for LVM volume"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamDeleteOrphanedSnapshots); ok {
+		orphanedPath := fldPath.Key(paramKey(params, localtype.ParamDeleteOrphanedSnapshots))
+		if value != "true" && value != "false" {
+			allErrs = append(allErrs, field.NotSupported(orphanedPath, value, []string{"true", "false"}))
+		} else if value == "true" && volumeType != string(localtype.VolumeTypeLVM) {
+			allErrs = append(allErrs, field.Invalid(orphanedPath, value, "deleting orphaned snapshots is only supported for LVM volume"))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamMountPropagation); ok {
+		if !utils.ContainsString(localtype.MountPropagations, value) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(paramKey(params, localtype.ParamMountPropagation)), value, localtype.MountPropagations))
+		}
+	}
+	if value, ok := utils.LookupParam(params, localtype.ParamAllocationPolicy); ok {
+		allocationPath := fldPath.Key(paramKey(params, localtype.ParamAllocationPolicy))
+		if !utils.ContainsString(localtype.AllocationPolicies, value) {
+			allErrs = append(allErrs, field.NotSupported(allocationPath, value, localtype.AllocationPolicies))
+		} else if volumeType != string(localtype.VolumeTypeLVM) {
+			allErrs = append(allErrs, field.Invalid(allocationPath, value, "allocation policy is only supported for LVM volume"))
+		}
+	}
+	return allErrs
+}
+
+// paramKey returns the key present in params, which is the legacy one if only
+// it is set
+func paramKey(params map[string]string, key string) string {
+	if _, exist := params[key]; exist {
+		return key
+	}
+	if legacyKey := utils.LegacyParamKey(key); legacyKey != "" {
+		if _, exist := params[legacyKey]; exist {
+			return legacyKey
+		}
+	}
+	return key
+}
+
+// ValidateNodeLocalStorageSpec validates spec of NodeLocalStorage
+func ValidateNodeLocalStorageSpec(spec *localv1alpha1.NodeLocalStorageSpec) field.ErrorList {
+	allErrs := field.ErrorList{}
+	fldPath := field.NewPath("spec")
+	if spec.NodeName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("nodeName"), "must be the name of node"))
+	}
+	allErrs = append(allErrs, validateListConfig(&spec.ListConfig, fldPath.Child("listConfig"))...)
+	allErrs = append(allErrs, validateResourceToBeInited(&spec.ResourceToBeInited, fldPath.Child("resourceToBeInited"))...)
+	return allErrs
+}
+
+func validateListConfig(config *localv1alpha1.ListConfig, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	vgPath := fldPath.Child("vgs")
+	allErrs = append(allErrs, validatePatterns(config.VGs.Include, vgPath.Child("include"))...)
+	allErrs = append(allErrs, validatePatterns(config.VGs.Exclude, vgPath.Child("exclude"))...)
+	if config.VGs.MaxLogicalVolumes < 0 {
+		allErrs = append(allErrs, field.Invalid(vgPath.Child("maxLogicalVolumes"), config.VGs.MaxLogicalVolumes, "must be 0(unlimited) or a positive integer"))
+	}
+	if config.VGs.MaxSnapshotSize != "" {
+		if _, err := utils.ParseSnapshotSize(config.VGs.MaxSnapshotSize); err != nil {
+			allErrs = append(allErrs, field.Invalid(vgPath.Child("maxSnapshotSize"), config.VGs.MaxSnapshotSize, fmt.Sprintf("must be absolute size such as 100Gi or percentage of total size of vgs such as 30%%: %s", err.Error())))
+		}
+	}
+	if config.VGs.MinFreeSize != "" {
+		if _, err := utils.ParseSnapshotSize(config.VGs.MinFreeSize); err != nil {
+			allErrs = append(allErrs, field.Invalid(vgPath.Child("minFreeSize"), config.VGs.MinFreeSize, fmt.Sprintf("must be absolute size such as 10Gi or percentage of total size of vg such as 5%%: %s", err.Error())))
+		}
+	}
+	for i, window := range config.VGs.SnapshotExpansionWindows {
+		if _, err := utils.ParseTimeWindows([]string{window}); err != nil {
+			allErrs = append(allErrs, field.Invalid(vgPath.Child("snapshotExpansionWindows").Index(i), window, fmt.Sprintf("must be time window in UTC such as 01:00-05:00: %s", err.Error())))
+		}
+	}
+	for i, vg := range config.VGs.Maintenance {
+		if strings.TrimSpace(vg) == "" {
+			allErrs = append(allErrs, field.Invalid(vgPath.Child("maintenance").Index(i), vg, "must be name of a volume group"))
+		}
+	}
+	allErrs = append(allErrs, validatePatterns(config.MountPoints.Include, fldPath.Child("mountPoints", "include"))...)
+	allErrs = append(allErrs, validatePatterns(config.MountPoints.Exclude, fldPath.Child("mountPoints", "exclude"))...)
+	allErrs = append(allErrs, validatePatterns(config.Devices.Include, fldPath.Child("devices", "include"))...)
+	allErrs = append(allErrs, validatePatterns(config.Devices.Exclude, fldPath.Child("devices", "exclude"))...)
+	return allErrs
+}
+
+// validatePatterns checks include and exclude patterns, agent panics on
+// pattern which is not a valid regular expression
+func validatePatterns(patterns []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, "must not be empty, remove the entry or set a name or regular expression"))
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, fmt.Sprintf("must be a valid regular expression: %s", err.Error())))
+		}
+	}
+	return allErrs
+}
+
+func validateResourceToBeInited(resource *localv1alpha1.ResourceToBeInited, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, vg := range resource.VGs {
+		vgPath := fldPath.Child("vgs").Index(i)
+		if strings.TrimSpace(vg.Name) == "" {
+			allErrs = append(allErrs, field.Required(vgPath.Child("name"), "must be name of the volume group to create"))
+		}
+		if len(vg.Devices) == 0 && len(vg.LoopFiles) == 0 {
+			allErrs = append(allErrs, field.Required(vgPath.Child("devices"), "must list at least one device or loop file to create volume group from"))
+		}
+		for j, device := range vg.Devices {
+			if strings.TrimSpace(device) == "" {
+				allErrs = append(allErrs, field.Invalid(vgPath.Child("devices").Index(j), device, "must be path of a block device, e.g. /dev/vdb"))
+			}
+		}
+		for j, file := range vg.LoopFiles {
+			if _, err := deviceutil.ParseLoopFile(file.Path, file.Size); err != nil {
+				allErrs = append(allErrs, field.Invalid(vgPath.Child("loopFiles").Index(j), file.Path, err.Error()))
+			}
+		}
+	}
+	for i, mp := range resource.MountPoints {
+		mpPath := fldPath.Child("mountpoints").Index(i)
+		if strings.TrimSpace(mp.Path) == "" {
+			allErrs = append(allErrs, field.Required(mpPath.Child("path"), "must be the path to mount device at"))
+		}
+		if strings.TrimSpace(mp.Device) == "" {
+			allErrs = append(allErrs, field.Required(mpPath.Child("device"), "must be path of a block device, e.g. /dev/vdc"))
+		}
+		if mp.FsType != "" && utils.StringsContains(localtype.SupportedFS, mp.FsType) == -1 {
+			allErrs = append(allErrs, field.NotSupported(mpPath.Child("fsType"), mp.FsType, localtype.SupportedFS))
+		}
+	}
+	return allErrs
+}
+
+func validVolumeType(volumeType string) bool {
+	for _, t := range localtype.ValidVolumeType {
+		if string(t) == volumeType {
+			return true
+		}
+	}
+	return false
+}
+
+func volumeTypes() []string {
+	types := make([]string, 0, len(localtype.ValidVolumeType))
+	for _, t := range localtype.ValidVolumeType {
+		types = append(types, string(t))
+	}
+	return types
+}
@@ -0,0 +1,471 @@
+/*
+Copyright © 2021 Alibaba Group Holding Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	localtype "github.com/alibaba/open-local/pkg"
+	localv1alpha1 "github.com/alibaba/open-local/pkg/apis/storage/v1alpha1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ValidateStorageClass(t *testing.T) {
+	tests := []struct {
+		name        string
+		provisioner string
+		params      map[string]string
+		// substrings of every error, no error is expected if empty
+		wantErrs []string
+	}{
+		{
+			name:        "test valid lvm storage class",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:                  "LVM",
+				ParamCSIFSType:                           "xfs",
+				"lvmType":                                "striping",
+				localtype.ParamVGName:                    "share",
+				localtype.VolumeIOPS:                     "1024",
+				localtype.ParamSnapshotInitialSize:       "10%",
+				localtype.ParamSnapshotExpansionSize:     "1Gi",
+				localtype.ParamSnapshotThreshold:         "50%",
+				localtype.ParamSnapshotAlertThreshold:    "40%",
+				localtype.ParamSnapshotReservePercent:    "20",
+				localtype.ParamSnapshotOriginGrowthRatio: "1.5",
+				localtype.ParamSnapshotReadAhead:         "16",
+				localtype.ParamReservedBlocksPercent:     "0.5",
+				localtype.VolumeMinSize:                  "1Gi",
+				localtype.VolumeMaxSize:                  "100Gi",
+			},
+		},
+		{
+			name:        "test storage class of other provisioner",
+			provisioner: "disk.csi.aliyun.com",
+			params:      map[string]string{localtype.VolumeTypeKey: "cloud"},
+		},
+		{
+			name:        "test missing and unknown types",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeFSTypeKey: "ntfs",
+				localtype.VolumeMediaType: "nvme",
+				"lvmType":                 "mirror",
+			},
+			wantErrs: []string{
+				"parameters[volumeType]: Required value",
+				`parameters[fsType]: Unsupported value: "ntfs"`,
+				`parameters[mediaType]: Unsupported value: "nvme"`,
+				`parameters[lvmType]: Unsupported value: "mirror"`,
+			},
+		},
+		{
+			name:        "test vg selector",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:   "LVM",
+				localtype.ParamVGSelector: "pool in (fast,nvme),tier!=archive",
+			},
+		},
+		{
+			name:        "test invalid vg selector",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:   "LVM",
+				localtype.ParamVGSelector: "pool in fast",
+			},
+			wantErrs: []string{
+				`parameters[csi.aliyun.com/vg-selector]: Invalid value: "pool in fast"`,
+			},
+		},
+		{
+			name:        "test vg selector with vg name",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:   "LVM",
+				localtype.ParamVGName:     "open-local-pool-0",
+				localtype.ParamVGSelector: "pool=fast",
+			},
+			wantErrs: []string{
+				`parameters[csi.aliyun.com/vg-selector]: Invalid value: "pool=fast"`,
+			},
+		},
+		{
+			name:        "test domain selector",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:       "Device",
+				localtype.ParamDomainSelector: "topology.open-local.io/power-domain in (pd-a,pd-b)",
+			},
+		},
+		{
+			name:        "test invalid domain selector",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:       "LVM",
+				localtype.ParamDomainSelector: "power-domain in pd-a",
+			},
+			wantErrs: []string{
+				`parameters[csi.aliyun.com/domain-selector]: Invalid value: "power-domain in pd-a"`,
+			},
+		},
+		{
+			name:        "test thresholds out of range",
+			provisioner: localtype.ProvisionerNameYoda,
+			params: map[string]string{
+				localtype.VolumeTypeKey:                  "LVM",
+				localtype.ParamSnapshotThreshold:         "150%",
+				localtype.ParamSnapshotAlertThreshold:    "0%",
+				localtype.ParamSnapshotReservePercent:    "-1",
+				localtype.ParamSnapshotOriginGrowthRatio: "-0.5",
+				localtype.ParamSnapshotReadAhead:         "-8",
+				localtype.ParamReservedBlocksPercent:     "60",
+				localtype.ParamExpansionSnapshotPercent:  "120",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/snapshot-expansion-threshold]: Invalid value: \"150%\"",
+				"parameters[csi.aliyun.com/snapshot-alert-threshold]: Invalid value: \"0%\"",
+				"parameters[csi.aliyun.com/snapshot-reserve-percent]: Invalid value: \"-1\"",
+				"parameters[csi.aliyun.com/snapshot-origin-growth-ratio]: Invalid value: \"-0.5\"",
+				"parameters[csi.aliyun.com/snapshot-read-ahead]: Invalid value: \"-8\"",
+				"parameters[csi.aliyun.com/ext4-reserved-blocks-percent]: Invalid value: \"60\"",
+				"parameters[csi.aliyun.com/expansion-snapshot-percent]: Invalid value: \"120\"",
+			},
+		},
+		{
+			name:        "test legacy keys",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:                        "LVM",
+				"storage.oecp.io/snapshot-expansion-threshold": "150%",
+				"storage.oecp.io/snapshot-initial-size":        "4Gix",
+				"storage.oecp.io/snapshot-expansion-size":      "1Gi",
+			},
+			wantErrs: []string{
+				"parameters[storage.oecp.io/snapshot-expansion-threshold]: Invalid value: \"150%\"",
+				"parameters[storage.oecp.io/snapshot-initial-size]: Invalid value: \"4Gix\"",
+			},
+		},
+		{
+			name:        "test expansion size in percentage of vg",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:              "LVM",
+				localtype.ParamSnapshotInitialSize:   "20%VG",
+				localtype.ParamSnapshotExpansionSize: "+120%FREE",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/snapshot-initial-size]: Invalid value: \"20%VG\"",
+				"parameters[csi.aliyun.com/snapshot-expansion-size]: Invalid value: \"+120%FREE\"",
+			},
+		},
+		{
+			name:        "test sizes and io limits unparseable",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:              "LVM",
+				localtype.ParamSnapshotInitialSize:   "4Gix",
+				localtype.ParamSnapshotExpansionSize: "120%",
+				localtype.VolumeBPS:                  "0",
+				localtype.ParamVGName:                " ",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/snapshot-initial-size]: Invalid value: \"4Gix\"",
+				"parameters[csi.aliyun.com/snapshot-expansion-size]: Invalid value: \"120%\"",
+				"parameters[bps]: Invalid value: \"0\": must be a positive integer",
+				"parameters[vgName]: Invalid value: \" \"",
+			},
+		},
+		{
+			name:        "test size range unparseable",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey: "LVM",
+				localtype.VolumeMinSize: "1Gix",
+				localtype.VolumeMaxSize: "-1Gi",
+			},
+			wantErrs: []string{
+				"parameters[minSize]: Invalid value: \"1Gix\": must be a non-negative quantity",
+				"parameters[maxSize]: Invalid value: \"-1Gi\": must be a non-negative quantity",
+			},
+		},
+		{
+			name:        "test min size larger than max size",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey: "LVM",
+				localtype.VolumeMinSize: "10Gi",
+				localtype.VolumeMaxSize: "1Gi",
+			},
+			wantErrs: []string{
+				"parameters[maxSize]: Invalid value: \"1Gi\": minSize 10Gi is larger than maxSize 1Gi",
+			},
+		},
+		{
+			name:        "test encrypted lvm storage class",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:                          "LVM",
+				localtype.ParamEncrypted:                         "true",
+				ParamNodeStageSecretName:                         "luks",
+				"csi.storage.k8s.io/node-stage-secret-namespace": "default",
+			},
+		},
+		{
+			name:        "test encryption without secret or lvm",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:  "Device",
+				localtype.ParamEncrypted: "true",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/encrypted]: Invalid value: \"true\": encryption is only supported for LVM volume",
+				"parameters[csi.storage.k8s.io/node-stage-secret-name]: Required value",
+			},
+		},
+		{
+			name:        "test encrypted not boolean",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:  "LVM",
+				localtype.ParamEncrypted: "yes",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/encrypted]: Unsupported value: \"yes\"",
+			},
+		},
+		{
+			name:        "test zero fill lvm storage class",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey: "LVM",
+				localtype.ParamZeroFill: "true",
+			},
+		},
+		{
+			name:        "test zero fill of device or not boolean",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey: "Device",
+				localtype.ParamZeroFill: "yes",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/zero-fill]: Unsupported value: \"yes\"",
+			},
+		},
+		{
+			name:        "test wipe on delete of device",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:     "Device",
+				localtype.ParamWipeOnDelete: "true",
+			},
+		},
+		{
+			name:        "test wipe on delete of lvm",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:     "LVM",
+				localtype.ParamWipeOnDelete: "true",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/wipe-on-delete]: Invalid value: \"true\": wipe on delete is only supported for Device volume",
+			},
+		},
+		{
+			name:        "test discard on delete of device",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:        "Device",
+				localtype.ParamDiscardOnDelete: "true",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/discard-on-delete]: Invalid value: \"true\": discard on delete is only supported for LVM volume",
+			},
+		},
+		{
+			name:        "test contiguous allocation policy",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:         "LVM",
+				localtype.ParamAllocationPolicy: "contiguous",
+			},
+		},
+		{
+			name:        "test unknown allocation policy",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:         "LVM",
+				localtype.ParamAllocationPolicy: "inherit",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/allocation-policy]: Unsupported value: \"inherit\"",
+			},
+		},
+		{
+			name:        "test allocation policy of device",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:         "Device",
+				localtype.ParamAllocationPolicy: "cling",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/allocation-policy]: Invalid value: \"cling\"",
+			},
+		},
+		{
+			name:        "test delete orphaned snapshots of device",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:                "Device",
+				localtype.ParamDeleteOrphanedSnapshots: "true",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/delete-orphaned-snapshots]: Invalid value: \"true\": deleting orphaned snapshots is only supported for LVM volume",
+			},
+		},
+		{
+			name:        "test mount propagation",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:         "MountPoint",
+				localtype.ParamMountPropagation: "rslave",
+			},
+		},
+		{
+			name:        "test unknown mount propagation",
+			provisioner: localtype.ProvisionerName,
+			params: map[string]string{
+				localtype.VolumeTypeKey:         "LVM",
+				localtype.ParamMountPropagation: "shared",
+			},
+			wantErrs: []string{
+				"parameters[csi.aliyun.com/mount-propagation]: Unsupported value: \"shared\"",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "test"},
+				Provisioner: tt.provisioner,
+				Parameters:  tt.params,
+			}
+			checkErrors(t, ValidateStorageClass(sc).ToAggregate(), tt.wantErrs)
+		})
+	}
+}
+
+func Test_ValidateNodeLocalStorageSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     localv1alpha1.NodeLocalStorageSpec
+		wantErrs []string
+	}{
+		{
+			name: "test valid spec",
+			spec: localv1alpha1.NodeLocalStorageSpec{
+				NodeName: "node-1",
+				ListConfig: localv1alpha1.ListConfig{
+					VGs:     localv1alpha1.VGList{Include: []string{"open-local-pool-[0-9]+"}, MaxLogicalVolumes: 64, MaxSnapshotSize: "30%", MinFreeSize: "10Gi", Maintenance: []string{"open-local-pool-0"}, SnapshotExpansionWindows: []string{"22:00-02:00"}},
+					Devices: localv1alpha1.DeviceList{Include: []string{"/dev/vd[c-d]+"}},
+				},
+				ResourceToBeInited: localv1alpha1.ResourceToBeInited{
+					VGs:         []localv1alpha1.VGToBeInited{{Name: "open-local-pool-0", Devices: []string{"/dev/vdb"}}},
+					MountPoints: []localv1alpha1.MountPointToBeInited{{Path: "/mnt/open-local/disk-1", Device: "/dev/vdc", FsType: "xfs"}},
+				},
+			},
+		},
+		{
+			name: "test empty and invalid vg patterns",
+			spec: localv1alpha1.NodeLocalStorageSpec{
+				NodeName: "node-1",
+				ListConfig: localv1alpha1.ListConfig{
+					VGs:         localv1alpha1.VGList{Include: []string{"share", ""}, Exclude: []string{"pool-[0-9"}, MaxLogicalVolumes: -1, MaxSnapshotSize: "200%", MinFreeSize: "150%", Maintenance: []string{""}, SnapshotExpansionWindows: []string{"01:00-05:00", "1am-5am"}},
+					MountPoints: localv1alpha1.MountPointList{Exclude: []string{" "}},
+				},
+			},
+			wantErrs: []string{
+				`spec.listConfig.vgs.include[1]: Invalid value: "": must not be empty`,
+				`spec.listConfig.vgs.exclude[0]: Invalid value: "pool-[0-9": must be a valid regular expression`,
+				"spec.listConfig.vgs.maxLogicalVolumes: Invalid value: -1",
+				`spec.listConfig.vgs.maxSnapshotSize: Invalid value: "200%"`,
+				`spec.listConfig.vgs.minFreeSize: Invalid value: "150%"`,
+				`spec.listConfig.vgs.snapshotExpansionWindows[1]: Invalid value: "1am-5am"`,
+				`spec.listConfig.vgs.maintenance[0]: Invalid value: ""`,
+				`spec.listConfig.mountPoints.exclude[0]: Invalid value: " "`,
+			},
+		},
+		{
+			name: "test incomplete resource to be inited",
+			spec: localv1alpha1.NodeLocalStorageSpec{
+				ResourceToBeInited: localv1alpha1.ResourceToBeInited{
+					VGs:         []localv1alpha1.VGToBeInited{{Name: "share"}, {Devices: []string{""}}},
+					MountPoints: []localv1alpha1.MountPointToBeInited{{Path: "/mnt/open-local/disk-1", FsType: "btrfs"}},
+				},
+			},
+			wantErrs: []string{
+				"spec.nodeName: Required value",
+				"spec.resourceToBeInited.vgs[0].devices: Required value",
+				"spec.resourceToBeInited.vgs[1].name: Required value",
+				`spec.resourceToBeInited.vgs[1].devices[0]: Invalid value: ""`,
+				"spec.resourceToBeInited.mountpoints[0].device: Required value",
+				`spec.resourceToBeInited.mountpoints[0].fsType: Unsupported value: "btrfs"`,
+			},
+		},
+		{
+			name: "test loop files",
+			spec: localv1alpha1.NodeLocalStorageSpec{
+				NodeName: "node-1",
+				ResourceToBeInited: localv1alpha1.ResourceToBeInited{
+					VGs: []localv1alpha1.VGToBeInited{
+						{Name: "open-local-pool-loop", LoopFiles: []localv1alpha1.LoopFile{{Path: "/var/lib/open-local/pv0.img", Size: "10Gi"}}},
+						{Name: "open-local-pool-bad", LoopFiles: []localv1alpha1.LoopFile{{Path: "pv0.img", Size: "10Gi"}, {Path: "/var/lib/open-local/pv1.img", Size: "0"}}},
+					},
+				},
+			},
+			wantErrs: []string{
+				`spec.resourceToBeInited.vgs[1].loopFiles[0]: Invalid value: "pv0.img"`,
+				`spec.resourceToBeInited.vgs[1].loopFiles[1]: Invalid value: "/var/lib/open-local/pv1.img"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErrors(t, ValidateNodeLocalStorageSpec(&tt.spec).ToAggregate(), tt.wantErrs)
+		})
+	}
+}
+
+func checkErrors(t *testing.T, err error, wantErrs []string) {
+	if len(wantErrs) == 0 {
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("expect errors %v, got nil", wantErrs)
+	}
+	for _, want := range wantErrs {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err.Error(), want)
+		}
+	}
+}